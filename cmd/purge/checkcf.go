@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runCheckCF(ctx context.Context, args []string) error {
+	var opts purge.CheckConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	checkCFFlags(&opts).Parse(args)
+
+	passed, err := purge.WriteCheckResults(os.Stdout, purge.CheckCF(ctx, opts))
+	if err != nil {
+		return err
+	}
+	if !passed {
+		return errors.New("CF checks failed")
+	}
+	return nil
+}
+
+// checkCFFlags defines the check-cf command's flags on opts
+func checkCFFlags(opts *purge.CheckConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("check-cf", flag.ExitOnError)
+	flags.StringVar(&opts.CanaryOrg, "canary-org", opts.CanaryOrg, "org in which to check that spaces can be created and deleted")
+	flags.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "log level: info, or debug to also log every CF API request")
+	return flags
+}
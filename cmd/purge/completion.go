@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runCompletion prints the completion script for the shell named in args
+// and returns the exit code
+func runCompletion(w io.Writer, args []string) int {
+	shell := ""
+	if len(args) > 0 {
+		shell = args[0]
+	}
+	switch shell {
+	case "bash":
+		writeBashCompletion(w)
+	case "zsh":
+		writeZshCompletion(w)
+	default:
+		fmt.Fprintf(os.Stderr, "usage: %s completion bash|zsh\n", programName)
+		return exitUsage
+	}
+	return 0
+}
+
+// completionFlag is a flag as completion scripts need it
+type completionFlag struct {
+	name  string
+	usage string
+	// takesValue is false for boolean flags, which can stand alone
+	takesValue bool
+}
+
+// takesFile guesses from its name and usage whether a flag's value is a path
+func (f completionFlag) takesFile() bool {
+	return f.takesValue && (strings.Contains(f.name, "file") || strings.Contains(f.usage, "file") || strings.Contains(f.usage, "directory"))
+}
+
+// completionFlags lists a command's flags in order of name
+func completionFlags(cmd command) []completionFlag {
+	var flags []completionFlag
+	cmd.flags().VisitAll(func(f *flag.Flag) {
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:       f.Name,
+			usage:      f.Usage,
+			takesValue: !ok || !boolFlag.IsBoolFlag(),
+		})
+	})
+	return flags
+}
+
+// commandNames lists every command, including the builtins
+func commandNames() []string {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	for _, builtin := range builtins {
+		names = append(names, builtin.name)
+	}
+	return names
+}
+
+// flagWords lists a command's flags as they are typed
+func flagWords(cmd command) string {
+	var words []string
+	for _, f := range completionFlags(cmd) {
+		words = append(words, "-"+f.name)
+	}
+	return strings.Join(words, " ")
+}
+
+// writeBashCompletion writes a bash completion script: commands first, then
+// each command's flags, and files for flag values and other arguments
+func writeBashCompletion(w io.Writer) {
+	defaultCmd, _ := findCommand("run")
+
+	fmt.Fprintf(w, "# bash completion for %[1]s; load it with: source <(%[1]s completion bash)\n", programName)
+	fmt.Fprintf(w, "_%s() {\n", programName)
+	fmt.Fprintf(w, "  local cur=\"${COMP_WORDS[COMP_CWORD]}\" flags\n")
+	fmt.Fprintf(w, "  if [[ $COMP_CWORD -eq 1 && \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " "))
+	fmt.Fprintf(w, "    return\n")
+	fmt.Fprintf(w, "  fi\n")
+	fmt.Fprintf(w, "  case \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %s) flags=%q ;;\n", cmd.name, flagWords(cmd))
+	}
+	fmt.Fprintf(w, "    help) [[ $COMP_CWORD -eq 2 ]] && COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", strings.Join(commandNames(), " "))
+	fmt.Fprintf(w, "    completion) [[ $COMP_CWORD -eq 2 ]] && COMPREPLY=($(compgen -W \"bash zsh\" -- \"$cur\")); return ;;\n")
+	fmt.Fprintf(w, "    *) flags=%q ;;\n", flagWords(defaultCmd))
+	fmt.Fprintf(w, "  esac\n")
+	fmt.Fprintf(w, "  if [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "  else\n")
+	fmt.Fprintf(w, "    COMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprintf(w, "  fi\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -o filenames -F _%[1]s %[1]s\n", programName)
+}
+
+// writeZshCompletion writes a zsh completion script that describes each
+// command and flag with its summary or usage, and completes files for the
+// flags that take a path
+func writeZshCompletion(w io.Writer) {
+	defaultCmd, _ := findCommand("run")
+
+	fmt.Fprintf(w, "#compdef %s\n", programName)
+	fmt.Fprintf(w, "# zsh completion for %[1]s; load it with: source <(%[1]s completion zsh)\n\n", programName)
+	fmt.Fprintf(w, "_%s() {\n", programName)
+	fmt.Fprintf(w, "  local -a commands\n")
+	fmt.Fprintf(w, "  commands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %s\n", zshQuote(zshEscape(cmd.name)+":"+zshEscape(cmd.summary)))
+	}
+	for _, builtin := range builtins {
+		fmt.Fprintf(w, "    %s\n", zshQuote(zshEscape(builtin.name)+":"+zshEscape(builtin.summary)))
+	}
+	fmt.Fprintf(w, "  )\n")
+	fmt.Fprintf(w, "  if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n")
+	fmt.Fprintf(w, "    _describe command commands\n")
+	fmt.Fprintf(w, "    return\n")
+	fmt.Fprintf(w, "  fi\n")
+	fmt.Fprintf(w, "  if [[ $words[2] == -* ]]; then\n")
+	fmt.Fprintf(w, "    _arguments %s\n", zshArguments(defaultCmd))
+	fmt.Fprintf(w, "    return\n")
+	fmt.Fprintf(w, "  fi\n")
+	fmt.Fprintf(w, "  local cmd=$words[2]\n")
+	fmt.Fprintf(w, "  shift words\n")
+	fmt.Fprintf(w, "  (( CURRENT-- ))\n")
+	fmt.Fprintf(w, "  case $cmd in\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %s) _arguments %s ;;\n", cmd.name, zshArguments(cmd))
+	}
+	fmt.Fprintf(w, "    help) (( CURRENT == 2 )) && _describe command commands ;;\n")
+	fmt.Fprintf(w, "    completion) (( CURRENT == 2 )) && _values shell bash zsh ;;\n")
+	fmt.Fprintf(w, "  esac\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "if [[ $funcstack[1] == _%[1]s ]]; then\n  _%[1]s \"$@\"\nelse\n  compdef _%[1]s %[1]s\nfi\n", programName)
+}
+
+// zshArguments returns the _arguments specs of a command's flags
+func zshArguments(cmd command) string {
+	var specs []string
+	for _, f := range completionFlags(cmd) {
+		spec := "-" + f.name + "[" + zshEscape(f.usage) + "]"
+		switch {
+		case f.takesFile():
+			spec += ":file:_files"
+		case f.takesValue:
+			spec += ":value: "
+		}
+		specs = append(specs, zshQuote(spec))
+	}
+	return strings.Join(specs, " ")
+}
+
+// zshEscape escapes the characters _arguments and _describe treat as syntax
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
+
+// zshQuote single-quotes s for the shell
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
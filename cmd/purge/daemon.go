@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runDaemon(ctx context.Context, args []string) error {
+	var opts purge.DaemonConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	daemonFlags(&opts).Parse(args)
+
+	return purge.Daemon(ctx, opts)
+}
+
+// daemonFlags defines the daemon command's flags on opts
+func daemonFlags(opts *purge.DaemonConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags.StringVar(&opts.ConfigFile, "config-file", opts.ConfigFile, "read KEY=VALUE settings overriding the environment from this file before every cycle")
+	flags.DurationVar(&opts.DaemonInterval, "interval", opts.DaemonInterval, "time between purge cycles")
+	flags.StringVar(&opts.DaemonListenAddress, "listen", opts.DaemonListenAddress, "serve /healthz and /status on this address, like :8080")
+	flags.StringVar(&opts.DaemonStatusFile, "status-file", opts.DaemonStatusFile, "write the daemon's status as JSON to this file whenever it changes")
+	return flags
+}
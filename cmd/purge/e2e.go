@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runE2E(ctx context.Context, args []string) error {
+	var opts purge.E2EConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	e2eFlags(&opts).Parse(args)
+
+	passed, err := purge.WriteCheckResults(os.Stdout, purge.E2E(ctx, opts))
+	if err != nil {
+		return err
+	}
+	if !passed {
+		return errors.New("end-to-end check failed")
+	}
+	return nil
+}
+
+// e2eFlags defines the e2e command's flags on opts
+func e2eFlags(opts *purge.E2EConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("e2e", flag.ExitOnError)
+	flags.StringVar(&opts.CanaryOrg, "canary-org", opts.CanaryOrg, "org in which to create, warn, purge, and delete a disposable space")
+	flags.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "log level: info, or debug to also log every CF API request")
+	return flags
+}
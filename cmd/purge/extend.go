@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runExtend(ctx context.Context, args []string) error {
+	var opts purge.ExtendConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	extendFlags(&opts).Parse(args)
+
+	extension, err := purge.Extend(ctx, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s/%s will be purged on %s", extension.Org, extension.Space, extension.PurgeDate.Format("Jan 02, 2006"))
+	if !extension.PreviousPurgeDate.IsZero() {
+		fmt.Fprintf(os.Stdout, " rather than %s", extension.PreviousPurgeDate.Format("Jan 02, 2006"))
+	}
+	if extension.DryRun {
+		fmt.Fprint(os.Stdout, " (dry run; not recorded)")
+	}
+	fmt.Fprintln(os.Stdout)
+	return nil
+}
+
+// extendFlags defines the extend command's flags on opts
+func extendFlags(opts *purge.ExtendConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("extend", flag.ExitOnError)
+	flags.StringVar(&opts.ExtendOrg, "org", opts.ExtendOrg, "sandbox org of the space to extend")
+	flags.StringVar(&opts.ExtendSpace, "space", opts.ExtendSpace, "space whose purge to extend")
+	flags.IntVar(&opts.ExtendDays, "days", 30, "days to push the purge back from its current date, or from today if that has passed")
+	flags.StringVar(&opts.ExtendReason, "reason", opts.ExtendReason, "why the extension was granted, recorded on the space")
+	flags.StringVar(&opts.ExtendBy, "by", os.Getenv("USER"), "operator granting the extension, recorded on the space")
+	flags.BoolVar(&opts.DryRun, "dry-run", opts.DryRun, "report the new purge date without annotating the space")
+	flags.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "log level: info, or debug to also log every CF API request")
+	return flags
+}
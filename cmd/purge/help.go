@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// programName is the name help and completion scripts give the binary, which
+// is built from cmd/purge
+const programName = "purge"
+
+// builtins are the commands handled before the environment is read
+var builtins = []struct {
+	name    string
+	summary string
+}{
+	{"help", "show a command's flags and examples"},
+	{"completion", "print a bash or zsh completion script"},
+}
+
+// findCommand returns the command with name
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// printUsage lists every command with its summary
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s [command] [flags]\n\ncommands:\n", programName)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	for _, builtin := range builtins {
+		fmt.Fprintf(w, "  %-12s %s\n", builtin.name, builtin.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s help COMMAND' for a command's flags and examples.\n", programName)
+}
+
+// printHelp describes a command's flags and gives examples of its use
+func printHelp(w io.Writer, cmd command) {
+	fmt.Fprintf(w, "usage: %s %s [flags]\n\n%s\n", programName, cmd.name, cmd.summary)
+	flags := cmd.flags()
+	fmt.Fprintln(w, "\nflags:")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+	fmt.Fprintln(w, "\nFlags left out keep the value of their environment setting; see the README.")
+	if len(cmd.examples) > 0 {
+		fmt.Fprintln(w, "\nexamples:")
+		for _, example := range cmd.examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+}
+
+// runHelp prints the usage, or a command's help, and returns the exit code
+func runHelp(w io.Writer, args []string) int {
+	if len(args) == 0 {
+		printUsage(w)
+		return 0
+	}
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %s\n\n", args[0])
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	printHelp(w, cmd)
+	return 0
+}
+
+// wantsHelp reports whether args ask for help before any "--"
+func wantsHelp(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "--":
+			return false
+		case "-h", "-help", "--h", "--help":
+			return true
+		}
+	}
+	return false
+}
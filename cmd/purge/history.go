@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runHistory(ctx context.Context, args []string) error {
+	var opts purge.HistoryConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	historyFlags(&opts).Parse(args)
+
+	history, err := purge.History(opts)
+	if err != nil {
+		return err
+	}
+	return history.WriteText(os.Stdout)
+}
+
+// historyFlags defines the history command's flags on opts
+func historyFlags(opts *purge.HistoryConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	flags.StringVar(&opts.HistoryUser, "user", opts.HistoryUser, "username or email address whose warnings and purges to list")
+	flags.StringVar(&opts.StateFile, "state-file", opts.StateFile, "state file the purge job records its runs in")
+	return flags
+}
@@ -2,111 +2,204 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
-	"github.com/sethvargo/go-envconfig"
+	"github.com/18f/cg-sandbox/purge"
 )
 
-// Options describes common configuration
-type Options struct {
-	APIAddress        string `env:"API_ADDRESS, required"`
-	ClientID          string `env:"CLIENT_ID, required"`
-	ClientSecret      string `env:"CLIENT_SECRET, required"`
-	OrgPrefix         string `env:"ORG_PREFIX, required"`
-	NotifyDays        int    `env:"NOTIFY_DAYS, default=25"`
-	PurgeDays         int    `env:"PURGE_DAYS, default=30"`
-	MailSender        string `env:"MAIL_SENDER, required"`
-	NotifyMailSubject string `env:"NOTIFY_MAIL_SUBJECT, required"`
-	PurgeMailSubject  string `env:"PURGE_MAIL_SUBJECT, required"`
-	DryRun            bool   `env:"DRY_RUN, default=true"`
-	TimeStartsAt      string `env:"TIME_STARTS_AT"`
-	DisablePurge      bool   `env:"DISABLE_PURGE, default=false"`
-	SandboxQuotaName  string `env:"SANDBOX_QUOTA_NAME, required"`
-	SMTPOptions
-}
+// Exit codes, so CF task and CI job status reflect how a run ended
+const (
+	exitFailed         = 1
+	exitUsage          = 2
+	exitPartialFailure = 3
+)
 
-func main() {
-	var opts Options
-	ctx := context.Background()
+// exitError is an error that ends the process with a specific exit code
+type exitError struct {
+	code int
+	err  error
+}
 
-	if err := envconfig.Process(ctx, &opts); err != nil {
-		log.Fatalf("error parsing options: %s", err.Error())
-	}
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
 
-	cfClient, err := newCFClient(
-		opts.APIAddress,
-		opts.ClientID,
-		opts.ClientSecret,
-	)
-	if err != nil {
-		log.Fatalf("error creating client: %s", err.Error())
-	}
+// command is a purge subcommand
+type command struct {
+	name    string
+	summary string
+	// flags defines the command's flags on empty options, for help and
+	// shell completion
+	flags    func() *flag.FlagSet
+	examples []string
+	run      func(ctx context.Context, args []string) error
+}
 
-	orgs, err := listSandboxOrgs(ctx, cfClient, opts.OrgPrefix)
-	if err != nil {
-		log.Fatalf("error getting orgs: %s", err.Error())
-	}
+var commands = []command{
+	{
+		name:    "run",
+		summary: "notify and purge sandbox spaces (default)",
+		flags:   func() *flag.FlagSet { return purgeFlags(&purge.Config{}) },
+		examples: []string{
+			`purge run -plan-only -report-format markdown`,
+			`purge run -space-guids-file spaces.txt -override-recipient ops@example.gov`,
+			`purge run -apply-plan plan.json -approved-plan s3://bucket/approved.json`,
+		},
+		run: runPurge,
+	},
+	{
+		name:    "check-cf",
+		summary: "check CF API connectivity, credentials, and permissions",
+		flags:   func() *flag.FlagSet { return checkCFFlags(&purge.CheckConfig{}) },
+		examples: []string{
+			`purge check-cf`,
+			`purge check-cf -canary-org sandbox-canary -log-level debug`,
+		},
+		run: runCheckCF,
+	},
+	{
+		name:    "e2e",
+		summary: "warn, purge, and recreate a disposable space in a canary org to check the whole pipeline",
+		flags:   func() *flag.FlagSet { return e2eFlags(&purge.E2EConfig{}) },
+		examples: []string{
+			`purge e2e -canary-org sandbox-canary`,
+		},
+		run: runE2E,
+	},
+	{
+		name:    "serve",
+		summary: "accept authenticated on-demand purge requests over HTTP",
+		flags:   func() *flag.FlagSet { return serveFlags(&purge.ServeConfig{}) },
+		examples: []string{
+			`purge serve -listen :8080`,
+		},
+		run: runServe,
+	},
+	{
+		name:    "daemon",
+		summary: "run purges on a schedule, reloading configuration between cycles",
+		flags:   func() *flag.FlagSet { return daemonFlags(&purge.DaemonConfig{}) },
+		examples: []string{
+			`purge daemon -interval 24h -config-file s3://bucket/purge.env -listen :8080`,
+		},
+		run: runDaemon,
+	},
+	{
+		name:    "watch",
+		summary: "welcome, annotate, and quota new sandbox spaces between runs",
+		flags:   func() *flag.FlagSet { return watchFlags(&purge.WatchConfig{}) },
+		examples: []string{
+			`purge watch -interval 5m`,
+		},
+		run: runWatch,
+	},
+	{
+		name:    "users",
+		summary: "remove sandbox org roles from users who are not on an allowlist",
+		flags:   func() *flag.FlagSet { return usersFlags(&purge.UsersConfig{}) },
+		examples: []string{
+			`purge users -allowlist-file allowlist.txt`,
+			`purge users -uaa-group sandbox-users -dry-run=false`,
+		},
+		run: runUsers,
+	},
+	{
+		name:    "extend",
+		summary: "push back a space's purge date, recording who granted it and why",
+		flags:   func() *flag.FlagSet { return extendFlags(&purge.ExtendConfig{}) },
+		examples: []string{
+			`purge extend -org sandbox-agency -space jane.doe -days 30 -reason "demo next week"`,
+			`purge extend -org sandbox-agency -space jane.doe -dry-run`,
+		},
+		run: runExtend,
+	},
+	{
+		name:    "timeline",
+		summary: "list a space's pushes, deletes, and role changes from CF audit events",
+		flags:   func() *flag.FlagSet { return timelineFlags(&purge.TimelineConfig{}) },
+		examples: []string{
+			`purge timeline -org sandbox-agency -space jane.doe`,
+			`purge timeline -space-guid GUID -days 90`,
+		},
+		run: runTimeline,
+	},
+	{
+		name:    "history",
+		summary: "list the warnings and purges that affected a user's spaces",
+		flags:   func() *flag.FlagSet { return historyFlags(&purge.HistoryConfig{}) },
+		examples: []string{
+			`purge history -user jane.doe@agency.gov -state-file state.json`,
+		},
+		run: runHistory,
+	},
+}
 
-	// Build filter of users with email addresses (not service accounts)
-	users, err := cfClient.Users.ListAll(ctx, nil)
-	if err != nil {
-		log.Fatalf("error getting users: %s", err.Error())
+func main() {
+	// CF log streaming timestamps every line itself and tags stderr lines
+	// as errors, so log plainly to stdout when running as a CF app or task
+	logOutput := os.Stderr
+	if os.Getenv("VCAP_APPLICATION") != "" {
+		logOutput = os.Stdout
+		log.SetFlags(0)
+		log.SetOutput(logOutput)
 	}
-	userGUIDs := map[string]bool{}
-	for _, user := range users {
-		if strings.Contains(user.Username, "@") {
-			userGUIDs[user.GUID] = true
-		}
+	if err := purge.SetLogFormat(os.Getenv("LOG_FORMAT"), logOutput); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
 	}
 
-	now := time.Now().Truncate(24 * time.Hour)
+	// CF stops a task with SIGTERM and kills it ten seconds later
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	var timeStartsAt time.Time
-	if opts.TimeStartsAt != "" {
-		timeStartsAt, err = time.Parse(time.RFC3339Nano, opts.TimeStartsAt)
-		if err != nil {
-			log.Fatalf("error parsing time starts at: %s", err.Error())
-		}
+	if len(os.Args) == 2 && wantsHelp(os.Args[1:]) {
+		printUsage(os.Stdout)
+		return
 	}
 
-	var allPurgeErrors []string
-
-	for _, org := range orgs {
-		log.Printf("getting org resources for org %s", org.Name)
-		spaces, apps, instances, err := listOrgResources(ctx, cfClient, org)
-		if err != nil {
-			log.Fatalf("error listing org resources for org %s: %s", org.Name, err.Error())
-		}
-
-		toNotify, toPurge, err := listPurgeSpaces(spaces, apps, instances, opts, now, timeStartsAt)
-		if err != nil {
-			log.Fatalf("error listing spaces to purge for org %s: %s", org.Name, err.Error())
-		}
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-		mailSender := &smtpMailer{
-			options: opts.SMTPOptions,
-		}
+	switch name {
+	case "help":
+		os.Exit(runHelp(os.Stdout, args))
+	case "completion":
+		os.Exit(runCompletion(os.Stdout, args))
+	}
 
-		log.Printf("notifying %d spaces in org %s", len(toNotify), org.Name)
-		for _, details := range toNotify {
-			err = notifySpaceUsers(ctx, cfClient, opts, userGUIDs, org, details, mailSender)
-			if err != nil {
-				log.Fatalf("error notifying space %s in org %s: %s", details.Space.Name, org.Name, err)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			// help is printed before the environment is read, so it works
+			// without the settings a command needs to run
+			if wantsHelp(args) {
+				printHelp(os.Stdout, cmd)
+				return
 			}
-		}
-
-		log.Printf("purging %d spaces in org %s", len(toPurge), org.Name)
-		for _, details := range toPurge {
-			err = purgeAndRecreateSpace(ctx, cfClient, opts, userGUIDs, org, details, mailSender)
-			if err != nil {
-				allPurgeErrors = append(allPurgeErrors, err.Error())
+			err := cmd.run(ctx, args)
+			if err == nil {
+				return
+			}
+			purge.LogError(err)
+			code := exitFailed
+			var exitErr *exitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.code
 			}
+			stop()
+			os.Exit(code)
 		}
 	}
 
-	if len(allPurgeErrors) > 0 {
-		log.Fatalf("error(s) purging sandboxes: %s", strings.Join(allPurgeErrors, ", "))
-	}
+	fmt.Fprintf(os.Stderr, "unknown command %s\n\n", name)
+	printUsage(os.Stderr)
+	os.Exit(exitUsage)
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runPurge(ctx context.Context, args []string) error {
+	var opts purge.Config
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	purgeFlags(&opts).Parse(args)
+
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	report, err := purge.Run(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if len(report.Errors) > 0 {
+		return &exitError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("error(s) purging sandboxes: %s", report.ErrorSummary()),
+		}
+	}
+	return nil
+}
+
+// purgeFlags defines the run command's flags on opts
+func purgeFlags(opts *purge.Config) *flag.FlagSet {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.StringVar(&opts.ProfileDir, "profile", opts.ProfileDir, "write pprof CPU and heap profiles to this directory")
+	flags.StringVar(&opts.PlanFile, "plan-file", opts.PlanFile, "write the action plan as JSON to this file")
+	flags.BoolVar(&opts.PlanOnly, "plan-only", opts.PlanOnly, "print the action plan without applying it")
+	flags.StringVar(&opts.ApplyPlan, "apply-plan", opts.ApplyPlan, "apply a plan previously written with -plan-file instead of planning")
+	flags.StringVar(&opts.ApprovedPlan, "approved-plan", opts.ApprovedPlan, "only apply planned actions that this signed-off plan also holds")
+	flags.StringVar(&opts.SandboxQuotaFallback, "quota-fallback", opts.SandboxQuotaFallback, "when the sandbox quota is missing from an org: create, org-default, or empty to fail")
+	flags.StringVar(&opts.ReportFormat, "report-format", opts.ReportFormat, "render the run report as json, markdown, or html, or its messages as csv")
+	flags.StringVar(&opts.ReportFile, "report-file", opts.ReportFile, "write the rendered report to this file instead of stdout")
+	flags.IntVar(&opts.LeaderboardSize, "leaderboard", opts.LeaderboardSize, "rank this many of the oldest active sandboxes and heaviest users in the report")
+	flags.BoolVar(&opts.AgencyRollups, "agency-rollups", opts.AgencyRollups, "summarize spaces in the report by their owners' email domains")
+	flags.DurationVar(&opts.MaxRuntime, "max-runtime", opts.MaxRuntime, "stop starting new orgs after running this long; skipped orgs go first next run")
+	flags.IntVar(&opts.PurgesPerHour, "purges-per-hour", opts.PurgesPerHour, "space out space purges so no more than this many start in an hour")
+	flags.BoolVar(&opts.OnlyNotifyNew, "only-notify-new", opts.OnlyNotifyNew, "only warn spaces that haven't been warned yet this purge cycle, according to STATE_FILE")
+	flags.BoolVar(&opts.IgnoreAnomalies, "ignore-anomalies", opts.IgnoreAnomalies, "apply the plan even if its candidate counts are anomalous compared to previous runs")
+	flags.StringVar(&opts.SpaceGUIDsFile, "space-guids-file", opts.SpaceGUIDsFile, "only process the spaces listed in this file, one GUID per line")
+	flags.StringVar(&opts.ExpectAPI, "expect-api", opts.ExpectAPI, "refuse to run unless the CF API root is this address")
+	flags.StringVar(&opts.ExpectFoundationName, "expect-foundation-name", opts.ExpectFoundationName, "refuse to run unless the foundation's /v3/info name is this")
+	flags.StringVar(&opts.ExpectOrg, "expect-org", opts.ExpectOrg, "refuse to run unless this org exists")
+	flags.StringVar(&opts.MailOverrideRecipient, "override-recipient", opts.MailOverrideRecipient, "send every email to this address instead of its recipients")
+	flags.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "log level: info, or debug to also log every CF API request")
+	flags.StringVar(&opts.RunTime, "run-time", opts.RunTime, "evaluate a dry run as of this RFC3339 time and timestamp its report with it, for reproducible reports")
+	return flags
+}
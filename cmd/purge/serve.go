@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runServe(ctx context.Context, args []string) error {
+	var opts purge.ServeConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	serveFlags(&opts).Parse(args)
+
+	return purge.Serve(ctx, opts)
+}
+
+// serveFlags defines the serve command's flags on opts
+func serveFlags(opts *purge.ServeConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&opts.ListenAddress, "listen", opts.ListenAddress, "address to listen for purge requests on")
+	flags.StringVar(&opts.MailOverrideRecipient, "override-recipient", opts.MailOverrideRecipient, "send every email to this address instead of its recipients")
+	flags.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "log level: info, or debug to also log every CF API request")
+	return flags
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runTimeline(ctx context.Context, args []string) error {
+	var opts purge.TimelineConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	timelineFlags(&opts).Parse(args)
+
+	timeline, err := purge.Timeline(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return timeline.WriteText(os.Stdout)
+}
+
+// timelineFlags defines the timeline command's flags on opts
+func timelineFlags(opts *purge.TimelineConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("timeline", flag.ExitOnError)
+	flags.StringVar(&opts.TimelineOrg, "org", opts.TimelineOrg, "sandbox org of the space")
+	flags.StringVar(&opts.TimelineSpace, "space", opts.TimelineSpace, "space whose timeline to list")
+	flags.StringVar(&opts.TimelineSpaceGUID, "space-guid", opts.TimelineSpaceGUID, "GUID of the space, such as a purged space's GUID from a run report")
+	flags.IntVar(&opts.TimelineDays, "days", opts.TimelineDays, "days of audit events to list")
+	flags.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "log level: info, or debug to also log every CF API request")
+	return flags
+}
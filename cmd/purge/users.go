@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runUsers(ctx context.Context, args []string) error {
+	var opts purge.UsersConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	usersFlags(&opts).Parse(args)
+
+	report, err := purge.ReconcileUsers(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if len(report.Errors) > 0 {
+		return &exitError{
+			code: exitPartialFailure,
+			err:  fmt.Errorf("error(s) reconciling sandbox users: %s", strings.Join(report.Errors, ", ")),
+		}
+	}
+	return nil
+}
+
+// usersFlags defines the users command's flags on opts
+func usersFlags(opts *purge.UsersConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("users", flag.ExitOnError)
+	flags.StringVar(&opts.UsersAllowlistFile, "allowlist-file", opts.UsersAllowlistFile, "file listing allowed usernames, one per line")
+	flags.StringVar(&opts.UsersAllowlistGroup, "uaa-group", opts.UsersAllowlistGroup, "UAA group whose members are allowed")
+	flags.BoolVar(&opts.DryRun, "dry-run", opts.DryRun, "report roles to remove without removing them")
+	flags.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "log level: info, or debug to also log every CF API request")
+	return flags
+}
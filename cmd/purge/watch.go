@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/18f/cg-sandbox/purge"
+)
+
+func runWatch(ctx context.Context, args []string) error {
+	var opts purge.WatchConfig
+	if err := envconfig.Process(ctx, &opts); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+
+	watchFlags(&opts).Parse(args)
+
+	return purge.Watch(ctx, opts)
+}
+
+// watchFlags defines the watch command's flags on opts
+func watchFlags(opts *purge.WatchConfig) *flag.FlagSet {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	flags.StringVar(&opts.ConfigFile, "config-file", opts.ConfigFile, "read KEY=VALUE settings overriding the environment from this file before every poll")
+	flags.DurationVar(&opts.WatchInterval, "interval", opts.WatchInterval, "time between polls for new spaces and resources")
+	return flags
+}
@@ -0,0 +1,139 @@
+package purge
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AckOptions describes the signed links in purge warnings that let users
+// acknowledge their sandbox will be purged
+type AckOptions struct {
+	// AckBaseURL is the public URL of the purge server; links are disabled
+	// when it is empty
+	AckBaseURL    string `env:"ACK_BASE_URL"`
+	AckSigningKey string `env:"ACK_SIGNING_KEY"`
+}
+
+var (
+	errAckInvalid = errors.New("acknowledgement link is invalid")
+	errAckExpired = errors.New("acknowledgement link has expired")
+)
+
+// enabled reports whether purge warnings carry acknowledgement links
+func (o AckOptions) enabled() bool {
+	return o.AckBaseURL != ""
+}
+
+// validate checks that acknowledgement links can be signed and recorded
+func (o AckOptions) validate(stateFile string) error {
+	if !o.enabled() {
+		return nil
+	}
+	if o.AckSigningKey == "" {
+		return fmt.Errorf("ACK_SIGNING_KEY is required for ACK_BASE_URL")
+	}
+	if stateFile == "" {
+		return fmt.Errorf("STATE_FILE is required for ACK_BASE_URL")
+	}
+	if _, err := url.Parse(o.AckBaseURL); err != nil {
+		return fmt.Errorf("invalid ACK_BASE_URL %s: %w", o.AckBaseURL, err)
+	}
+	return nil
+}
+
+// ackSignature signs a space GUID and expiry with the signing key
+func (o AckOptions) ackSignature(spaceGUID string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(o.AckSigningKey))
+	fmt.Fprintf(mac, "%s:%d", spaceGUID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ackURL returns the signed acknowledgement link for a space, valid until
+// the space is purged, or an empty string if links are disabled
+func (o AckOptions) ackURL(spaceGUID string, expires time.Time) string {
+	if !o.enabled() {
+		return ""
+	}
+	query := url.Values{
+		"space":   {spaceGUID},
+		"expires": {strconv.FormatInt(expires.Unix(), 10)},
+	}
+	query.Set("sig", o.ackSignature(spaceGUID, expires.Unix()))
+	return strings.TrimSuffix(o.AckBaseURL, "/") + "/ack?" + query.Encode()
+}
+
+// verifyAck checks an acknowledgement link's signature and expiry and
+// returns the space it acknowledges
+func (o AckOptions) verifyAck(query url.Values, now time.Time) (string, error) {
+	spaceGUID := query.Get("space")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if spaceGUID == "" || err != nil {
+		return "", errAckInvalid
+	}
+	expected := o.ackSignature(spaceGUID, expires)
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(expected)) {
+		return "", errAckInvalid
+	}
+	if now.Unix() > expires {
+		return "", errAckExpired
+	}
+	return spaceGUID, nil
+}
+
+// acknowledgedAt returns when a space's purge warning was acknowledged, or
+// nil if it hasn't been
+func (s *State) acknowledgedAt(spaceGUID string) *time.Time {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if space, ok := s.Spaces[spaceGUID]; ok {
+		return space.AcknowledgedAt
+	}
+	return nil
+}
+
+// recordAcknowledged remembers that a space's purge warning was acknowledged
+func (s *State) recordAcknowledged(spaceGUID string, at time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	space, ok := s.Spaces[spaceGUID]
+	if !ok {
+		space = &SpaceState{}
+		s.Spaces[spaceGUID] = space
+	}
+	space.AcknowledgedAt = &at
+}
+
+// mergeAcknowledgements copies newer acknowledgements from other state for
+// spaces this state still remembers; spaces forgotten after a purge stay
+// forgotten
+func (s *State) mergeAcknowledgements(other *State) {
+	if s == nil || other == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	for guid, space := range s.Spaces {
+		theirs, ok := other.Spaces[guid]
+		if !ok || theirs.AcknowledgedAt == nil {
+			continue
+		}
+		if space.AcknowledgedAt == nil || theirs.AcknowledgedAt.After(*space.AcknowledgedAt) {
+			space.AcknowledgedAt = theirs.AcknowledgedAt
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package purge
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyAck(t *testing.T) {
+	opts := AckOptions{AckBaseURL: "https://sandbox-purge.example.gov/", AckSigningKey: "key"}
+	purgeDate := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	link, err := url.Parse(opts.ackURL("space-1", purgeDate))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if link.Host != "sandbox-purge.example.gov" || link.Path != "/ack" {
+		t.Fatalf("unexpected acknowledgement link %s", link)
+	}
+
+	testCases := map[string]struct {
+		query       func(url.Values)
+		now         time.Time
+		expectedErr error
+	}{
+		"valid": {
+			query: func(url.Values) {},
+			now:   purgeDate.Add(-time.Hour),
+		},
+		"expired": {
+			query:       func(url.Values) {},
+			now:         purgeDate.Add(time.Hour),
+			expectedErr: errAckExpired,
+		},
+		"other space": {
+			query:       func(q url.Values) { q.Set("space", "space-2") },
+			now:         purgeDate.Add(-time.Hour),
+			expectedErr: errAckInvalid,
+		},
+		"extended expiry": {
+			query:       func(q url.Values) { q.Set("expires", "4102444800") },
+			now:         purgeDate.Add(-time.Hour),
+			expectedErr: errAckInvalid,
+		},
+		"missing signature": {
+			query:       func(q url.Values) { q.Del("sig") },
+			now:         purgeDate.Add(-time.Hour),
+			expectedErr: errAckInvalid,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			query := link.Query()
+			test.query(query)
+			spaceGUID, err := opts.verifyAck(query, test.now)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+			if err == nil && spaceGUID != "space-1" {
+				t.Errorf("expected space-1, got %s", spaceGUID)
+			}
+		})
+	}
+
+	if got := (AckOptions{}).ackURL("space-1", purgeDate); got != "" {
+		t.Errorf("expected no link when disabled, got %s", got)
+	}
+}
+
+func TestServeAck(t *testing.T) {
+	store := &fileStateStore{path: filepath.Join(t.TempDir(), "state.json")}
+	server := testPurgeServer()
+	server.opts.AckOptions = AckOptions{AckBaseURL: "https://sandbox-purge.example.gov", AckSigningKey: "key"}
+	server.store = store
+	link, err := url.Parse(server.opts.ackURL("space-1", time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, step := range []struct {
+		method         string
+		query          string
+		expectedStatus int
+		expectedAck    bool
+	}{
+		{method: http.MethodGet, query: "space=space-1", expectedStatus: http.StatusForbidden},
+		{method: http.MethodGet, query: link.RawQuery, expectedStatus: http.StatusOK},
+		{method: http.MethodPost, query: link.RawQuery, expectedStatus: http.StatusOK, expectedAck: true},
+	} {
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(step.method, "/ack?"+step.query, nil))
+		if rec.Code != step.expectedStatus {
+			t.Fatalf("%s %s: expected status %d, got %d: %s", step.method, step.query, step.expectedStatus, rec.Code, rec.Body.String())
+		}
+		state, err := store.load()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if acked := state.acknowledgedAt("space-1") != nil; acked != step.expectedAck {
+			t.Fatalf("%s %s: expected acknowledged %t, got %t", step.method, step.query, step.expectedAck, acked)
+		}
+	}
+}
+
+func TestMergeAcknowledgements(t *testing.T) {
+	earlier := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+	state := &State{Spaces: map[string]*SpaceState{
+		"notified": {Space: "notified"},
+		"older":    {Space: "older", AcknowledgedAt: &earlier},
+		"newer":    {Space: "newer", AcknowledgedAt: &later},
+	}}
+	onDisk := &State{Spaces: map[string]*SpaceState{
+		"notified": {AcknowledgedAt: &earlier},
+		"older":    {AcknowledgedAt: &later},
+		"newer":    {AcknowledgedAt: &earlier},
+		"purged":   {AcknowledgedAt: &earlier},
+	}}
+	state.mergeAcknowledgements(onDisk)
+
+	expected := map[string]time.Time{"notified": earlier, "older": later, "newer": later}
+	for guid, at := range expected {
+		if got := state.acknowledgedAt(guid); got == nil || !got.Equal(at) {
+			t.Errorf("expected %s acknowledged at %s, got %v", guid, at, got)
+		}
+	}
+	if _, ok := state.Spaces["purged"]; ok {
+		t.Errorf("expected forgotten space to stay forgotten")
+	}
+}
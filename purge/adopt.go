@@ -0,0 +1,62 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// cfErrorDetailContains reports whether a CF API error, or any of several,
+// has a detail containing substr, ignoring case
+func cfErrorDetailContains(err error, substr string) bool {
+	var cfErrs resource.CloudFoundryErrors
+	if errors.As(err, &cfErrs) {
+		for _, cfErr := range cfErrs.Errors {
+			if cfErrorDetailContains(cfErr, substr) {
+				return true
+			}
+		}
+		return false
+	}
+	var cfErr resource.CloudFoundryError
+	if !errors.As(err, &cfErr) {
+		return false
+	}
+	return strings.Contains(strings.ToLower(cfErr.Detail), substr)
+}
+
+// isNameTakenError reports whether a space couldn't be created because the
+// org already has a space with its name
+func isNameTakenError(err error) bool {
+	return cfErrorDetailContains(err, "must be unique")
+}
+
+// isRoleExistsError reports whether a role couldn't be created because the
+// user already has it
+func isRoleExistsError(err error) bool {
+	return cfErrorDetailContains(err, "already has")
+}
+
+// findSpaceByName finds the space named name in org. A purge uses it to
+// find the space that took a purged space's name, created by its user or
+// another process between the purge's delete and create, so the purge can
+// adopt it rather than fail
+func findSpaceByName(ctx context.Context, cfClient *cfResourceClient, org *resource.Organization, name string) (*resource.Space, error) {
+	spaceListOptions := client.NewSpaceListOptions()
+	spaceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	spaceListOptions.Names.EqualTo(name)
+	spaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error listing spaces named %s in org %s: %w", name, org.Name, err)
+	}
+	for _, space := range spaces {
+		if space.Name == name {
+			return space, nil
+		}
+	}
+	return nil, fmt.Errorf("space %s in org %s: %w", name, org.Name, errSpaceNotFound)
+}
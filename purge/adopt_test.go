@@ -0,0 +1,98 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIsNameTakenError(t *testing.T) {
+	nameTaken := resource.CloudFoundryError{Code: 10008, Title: "CF-UnprocessableEntity", Detail: "Name must be unique per organization"}
+	testCases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"name taken": {
+			err:      nameTaken,
+			expected: true,
+		},
+		"name taken among several": {
+			err:      resource.CloudFoundryErrors{Errors: []resource.CloudFoundryError{nameTaken}},
+			expected: true,
+		},
+		"other unprocessable entity": {
+			err: resource.CloudFoundryError{Code: 10008, Title: "CF-UnprocessableEntity", Detail: "Invalid organization"},
+		},
+		"other error": {
+			err: errors.New("must be unique"),
+		},
+		"nil": {},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := isNameTakenError(test.err); got != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestRecreateSpaceAdoptsTakenName(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	details := SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "jane.doe", Relationships: &resource.SpaceRelationships{}}}
+	taken := &resource.Space{GUID: "space-2", Name: "jane.doe"}
+	nameTaken := resource.CloudFoundryError{Code: 10008, Title: "CF-UnprocessableEntity", Detail: "Name must be unique per organization"}
+	quota := &resource.SpaceQuota{GUID: "quota-1", Name: "sandbox"}
+
+	testCases := map[string]struct {
+		spaces          []*resource.Space
+		expectedGUID    string
+		expectedAdopted []string
+		expectedErr     string
+	}{
+		"adopts the space": {
+			spaces:          []*resource.Space{{GUID: "space-3", Name: "jane.doe-old"}, taken},
+			expectedGUID:    "space-2",
+			expectedAdopted: []string{"sandbox-agency/jane.doe"},
+		},
+		"taken space gone": {
+			expectedErr: "error creating space jane.doe in org sandbox-agency: cfclient error (CF-UnprocessableEntity|10008): Name must be unique per organization (error finding the space that took its name: space jane.doe in org sandbox-agency: space not found)",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				Spaces:      &mockSpaces{spaces: test.spaces, createErr: nameTaken},
+				SpaceQuotas: &mockSpaceQuotas{spaceQuotaName: "sandbox", orgGUID: "org-1", quota: quota},
+			}
+			report := &Report{}
+			space, _, err := recreateSpace(context.Background(), cfClient, Config{SandboxQuotaName: "sandbox"}, org, details, report)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if space.GUID != test.expectedGUID {
+				t.Errorf("expected space %s, got %s", test.expectedGUID, space.GUID)
+			}
+			if diff := cmp.Diff(test.expectedAdopted, report.SpacesAdopted); diff != "" {
+				t.Errorf("SpacesAdopted mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRecreateSpaceDevsAndManagersExistingRole(t *testing.T) {
+	roles := &mockRoles{createErr: resource.CloudFoundryError{Code: 10008, Title: "CF-UnprocessableEntity", Detail: "User 'jane.doe@agency.gov' already has 'space_developer' role in space 'jane.doe'."}}
+	err := recreateSpaceDevsAndManagers(context.Background(), &cfResourceClient{Roles: roles}, "space-1", []spaceUser{{GUID: "user-1"}}, nil)
+	if err != nil {
+		t.Errorf("expected an existing role to be ignored, got: %s", err)
+	}
+}
@@ -0,0 +1,18 @@
+package purge
+
+// AGE_BY values
+const (
+	// ageByCreated ages a space from the creation of its first resource
+	ageByCreated = "created"
+	// ageByUpdated ages a space from the latest update to any of its
+	// resources, so actively maintained sandboxes aren't purged
+	ageByUpdated = "updated"
+)
+
+func validAgeBy(value string) bool {
+	switch value {
+	case "", ageByCreated, ageByUpdated:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package purge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListSpaceFirstResourcesAgeBy(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	space := &resource.Space{GUID: "space-guid", Name: "maintained"}
+	inSpace := resource.SpaceRelationship{
+		Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: "space-guid"}},
+	}
+	apps := []*resource.App{
+		{GUID: "app-1", CreatedAt: now.AddDate(0, 0, -60), UpdatedAt: now.AddDate(0, 0, -2), Relationships: inSpace},
+		{GUID: "app-2", CreatedAt: now.AddDate(0, 0, -40), UpdatedAt: now.AddDate(0, 0, -40), Relationships: inSpace},
+	}
+	day := func(t time.Time) time.Time { return t.Truncate(24 * time.Hour) }
+	testCases := map[string]struct {
+		ageBy        string
+		timeStartsAt time.Time
+		expected     time.Time
+	}{
+		"default":        {expected: day(now.AddDate(0, 0, -60))},
+		"by creation":    {ageBy: ageByCreated, expected: day(now.AddDate(0, 0, -60))},
+		"by last update": {ageBy: ageByUpdated, expected: day(now.AddDate(0, 0, -2))},
+		"update before time starts": {
+			ageBy:        ageByUpdated,
+			timeStartsAt: now.AddDate(0, 0, -1),
+			expected:     day(now.AddDate(0, 0, -1)),
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			inventory := collect.New(&resource.Organization{}, collect.Resources{Spaces: []*resource.Space{space}, Apps: apps})
+			details := listSpaceFirstResources(inventory, test.ageBy, test.timeStartsAt)
+			if diff := cmp.Diff(test.expected, details[0].Timestamp); diff != "" {
+				t.Errorf("listSpaceFirstResources() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
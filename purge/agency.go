@@ -0,0 +1,125 @@
+package purge
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// agencyUnknown groups spaces without any owners with an email address
+const agencyUnknown = "unknown"
+
+// AgencyRollupOptions describes the report section summarizing sandboxes by
+// agency, meaning the email domain of their owners, for account managers
+type AgencyRollupOptions struct {
+	AgencyRollups   bool   `env:"AGENCY_ROLLUPS, default=false"`
+	AgencyRollupCSV string `env:"AGENCY_ROLLUP_CSV"`
+}
+
+// AgencyRollup counts an agency's sandbox spaces by what this run decided
+// for them
+type AgencyRollup struct {
+	Agency string `json:"agency"`
+	// SpacesActive counts spaces with resources that aren't being purged
+	SpacesActive   int `json:"spaces_active"`
+	SpacesNotified int `json:"spaces_notified"`
+	SpacesPurged   int `json:"spaces_purged"`
+	// AverageLifetimeDays is the mean age of the agency's spaces with
+	// resources, including the ones being purged
+	AverageLifetimeDays float64 `json:"average_lifetime_days"`
+}
+
+// agencyOf returns the lower-cased email domain of an owner
+func agencyOf(owner string) string {
+	at := strings.LastIndex(owner, "@")
+	if at < 0 || at == len(owner)-1 {
+		return agencyUnknown
+	}
+	return strings.ToLower(owner[at+1:])
+}
+
+// buildAgencyRollups groups an inventory by agency; a space with owners from
+// several agencies counts toward each of them, and agencies are sorted by
+// name
+func buildAgencyRollups(records []InventoryRecord) []AgencyRollup {
+	rollups := map[string]*AgencyRollup{}
+	lifetimes := map[string]int{}
+	for _, record := range records {
+		agencies := map[string]bool{}
+		for _, owner := range record.Owners {
+			agencies[agencyOf(owner)] = true
+		}
+		if len(agencies) == 0 {
+			agencies[agencyUnknown] = true
+		}
+		for agency := range agencies {
+			rollup, ok := rollups[agency]
+			if !ok {
+				rollup = &AgencyRollup{Agency: agency}
+				rollups[agency] = rollup
+			}
+			switch record.Decision {
+			case planActionNotify:
+				rollup.SpacesNotified++
+			case planActionPurge:
+				rollup.SpacesPurged++
+			}
+			if record.AgeDays == nil {
+				continue
+			}
+			if record.Decision != planActionPurge {
+				rollup.SpacesActive++
+			}
+			lifetimes[agency] += *record.AgeDays
+		}
+	}
+
+	sorted := make([]AgencyRollup, 0, len(rollups))
+	for agency, rollup := range rollups {
+		if aged := rollup.SpacesActive + rollup.SpacesPurged; aged > 0 {
+			rollup.AverageLifetimeDays = float64(lifetimes[agency]) / float64(aged)
+		}
+		sorted = append(sorted, *rollup)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Agency < sorted[j].Agency
+	})
+	return sorted
+}
+
+// writeAgencyRollupCSV writes the rollups to path
+func writeAgencyRollupCSV(path string, rollups []AgencyRollup) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating agency rollup %s: %w", path, err)
+	}
+	if err := encodeAgencyRollupCSV(f, rollups); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing agency rollup %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing agency rollup %s: %w", path, err)
+	}
+	logFields{}.printf("wrote agency rollup to %s", path)
+	return nil
+}
+
+func encodeAgencyRollupCSV(w io.Writer, rollups []AgencyRollup) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"agency", "spaces_active", "spaces_notified", "spaces_purged", "average_lifetime_days"})
+	for _, rollup := range rollups {
+		writer.Write([]string{
+			rollup.Agency,
+			strconv.Itoa(rollup.SpacesActive),
+			strconv.Itoa(rollup.SpacesNotified),
+			strconv.Itoa(rollup.SpacesPurged),
+			strconv.FormatFloat(rollup.AverageLifetimeDays, 'f', 1, 64),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
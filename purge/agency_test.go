@@ -0,0 +1,57 @@
+package purge
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildAgencyRollups(t *testing.T) {
+	records := []InventoryRecord{
+		testLeaderboardRecord("new", 2, inventoryDecisionKeep, 1, 0, "new@agency.gov"),
+		testLeaderboardRecord("old", 28, planActionNotify, 2, 1, "old@Agency.gov", "shared@other.gov"),
+		testLeaderboardRecord("purged", 31, planActionPurge, 9, 9, "purged@agency.gov"),
+		testLeaderboardRecord("empty", -1, inventoryDecisionEmpty, 0, 0, "empty@agency.gov"),
+		testLeaderboardRecord("orphaned", 5, inventoryDecisionKeep, 1, 0),
+	}
+
+	expected := []AgencyRollup{
+		{Agency: "agency.gov", SpacesActive: 2, SpacesNotified: 1, SpacesPurged: 1, AverageLifetimeDays: 61.0 / 3},
+		{Agency: "other.gov", SpacesActive: 1, SpacesNotified: 1, AverageLifetimeDays: 28},
+		{Agency: agencyUnknown, SpacesActive: 1, AverageLifetimeDays: 5},
+	}
+	if diff := cmp.Diff(expected, buildAgencyRollups(records)); diff != "" {
+		t.Errorf("buildAgencyRollups() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteAgencyRollupCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agencies.csv")
+	rollups := []AgencyRollup{{Agency: "agency.gov", SpacesActive: 2, SpacesPurged: 1, AverageLifetimeDays: 20.5}}
+	if err := writeAgencyRollupCSV(path, rollups); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "agency,spaces_active,spaces_notified,spaces_purged,average_lifetime_days\nagency.gov,2,0,1,20.5\n"
+	if string(content) != expected {
+		t.Errorf("expected %q, got %q", expected, content)
+	}
+}
+
+func TestWriteReportMarkdownAgencies(t *testing.T) {
+	report := Report{Agencies: []AgencyRollup{{Agency: "agency.gov", SpacesActive: 2, SpacesNotified: 1, AverageLifetimeDays: 12.5}}}
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, report, reportFormatMarkdown); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "| agency.gov | 2 | 1 | 0 | 12.5 |"; !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected Markdown report to contain %q, got:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,169 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	alertProviderPagerDuty = "pagerduty"
+	alertProviderOpsgenie  = "opsgenie"
+
+	// opsgenieMessageLimit is the maximum length, in characters, Opsgenie
+	// accepts for an alert message
+	opsgenieMessageLimit = 130
+
+	// alertTimeout bounds alerting and publishing the report once a run ends
+	alertTimeout = 2 * time.Minute
+)
+
+// AlertOptions describes configuration for alerting on failed runs
+type AlertOptions struct {
+	AlertProvider         string  `env:"ALERT_PROVIDER"`
+	AlertFailureThreshold float64 `env:"ALERT_FAILURE_THRESHOLD, default=0.5"`
+	AlertSource           string  `env:"ALERT_SOURCE, default=cg-sandbox"`
+	PagerDutyRoutingKey   string  `env:"PAGERDUTY_ROUTING_KEY"`
+	PagerDutyEventsURL    string  `env:"PAGERDUTY_EVENTS_URL, default=https://events.pagerduty.com/v2/enqueue"`
+	OpsgenieAPIKey        string  `env:"OPSGENIE_API_KEY"`
+	OpsgenieAlertsURL     string  `env:"OPSGENIE_ALERTS_URL, default=https://api.opsgenie.com/v2/alerts"`
+}
+
+type alerter interface {
+	sendAlert(ctx context.Context, summary string, report *Report) error
+}
+
+// newAlerter returns the alerter for the configured provider, or nil if alerting is disabled
+func newAlerter(opts AlertOptions) (alerter, error) {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	switch opts.AlertProvider {
+	case "":
+		return nil, nil
+	case alertProviderPagerDuty:
+		if opts.PagerDutyRoutingKey == "" {
+			return nil, fmt.Errorf("PAGERDUTY_ROUTING_KEY is required for alert provider %s", opts.AlertProvider)
+		}
+		return &pagerDutyAlerter{options: opts, httpClient: httpClient}, nil
+	case alertProviderOpsgenie:
+		if opts.OpsgenieAPIKey == "" {
+			return nil, fmt.Errorf("OPSGENIE_API_KEY is required for alert provider %s", opts.AlertProvider)
+		}
+		return &opsgenieAlerter{options: opts, httpClient: httpClient}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert provider %s", opts.AlertProvider)
+	}
+}
+
+// alertSummary decides whether a run should alert, and if so describes why;
+// quarantined spaces always alert, since operators need to investigate them
+func alertSummary(opts AlertOptions, report *Report, runErr error) (string, bool) {
+	if runErr != nil {
+		return fmt.Sprintf("sandbox purge run aborted: %s", runErr), true
+	}
+	attempts := report.SpacesPurged + len(report.Errors)
+	failureRate := 0.0
+	if attempts > 0 {
+		failureRate = float64(len(report.Errors)) / float64(attempts)
+	}
+	if len(report.Errors) == 0 || failureRate < opts.AlertFailureThreshold {
+		if len(report.SpacesQuarantined) > 0 {
+			return fmt.Sprintf(
+				"sandbox purge quarantined %d spaces after failed deletes: %s",
+				len(report.SpacesQuarantined),
+				strings.Join(report.SpacesQuarantined, ", "),
+			), true
+		}
+		return "", false
+	}
+	return fmt.Sprintf(
+		"sandbox purge failed for %d of %d spaces (%.0f%%)",
+		len(report.Errors),
+		attempts,
+		failureRate*100,
+	), true
+}
+
+type pagerDutyAlerter struct {
+	options    AlertOptions
+	httpClient *http.Client
+}
+
+// sendAlert triggers an event via the PagerDuty Events API v2
+func (a *pagerDutyAlerter) sendAlert(ctx context.Context, summary string, report *Report) error {
+	event := map[string]interface{}{
+		"routing_key":  a.options.PagerDutyRoutingKey,
+		"event_action": "trigger",
+		"payload": map[string]interface{}{
+			"summary":        summary,
+			"source":         a.options.AlertSource,
+			"severity":       "error",
+			"custom_details": report,
+		},
+	}
+	return postAlert(ctx, a.httpClient, a.options.PagerDutyEventsURL, nil, event)
+}
+
+type opsgenieAlerter struct {
+	options    AlertOptions
+	httpClient *http.Client
+}
+
+// sendAlert creates an alert via the Opsgenie Alert API
+func (a *opsgenieAlerter) sendAlert(ctx context.Context, summary string, report *Report) error {
+	message := summary
+	// the limit counts characters, and a cut mid-character isn't valid UTF-8
+	if runes := []rune(message); len(runes) > opsgenieMessageLimit {
+		message = string(runes[:opsgenieMessageLimit])
+	}
+	alert := map[string]interface{}{
+		"message":     message,
+		"description": summary + "\n\n" + report.ErrorSummary(),
+		"source":      a.options.AlertSource,
+		"priority":    "P2",
+		"details": map[string]string{
+			"spaces_notified": strconv.Itoa(report.SpacesNotified),
+			"spaces_purged":   strconv.Itoa(report.SpacesPurged),
+			"errors":          strconv.Itoa(len(report.Errors)),
+		},
+	}
+	headers := map[string]string{
+		"Authorization": "GenieKey " + a.options.OpsgenieAPIKey,
+	}
+	return postAlert(ctx, a.httpClient, a.options.OpsgenieAlertsURL, headers, alert)
+}
+
+// postAlert sends a JSON payload to an alerting API
+func postAlert(
+	ctx context.Context,
+	httpClient *http.Client,
+	url string,
+	headers map[string]string,
+	payload interface{},
+) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status posting alert to %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAlertSummary(t *testing.T) {
+	testCases := map[string]struct {
+		options         AlertOptions
+		report          *Report
+		runErr          error
+		expectedSummary string
+		expectedAlert   bool
+	}{
+		"alerts on aborted run": {
+			report:          &Report{},
+			runErr:          errors.New("error getting orgs"),
+			expectedSummary: "sandbox purge run aborted: error getting orgs",
+			expectedAlert:   true,
+		},
+		"does not alert without errors": {
+			options: AlertOptions{AlertFailureThreshold: 0.5},
+			report:  &Report{SpacesPurged: 3},
+		},
+		"does not alert below threshold": {
+			options: AlertOptions{AlertFailureThreshold: 0.5},
+			report: &Report{
+				SpacesPurged: 3,
+				Errors:       []string{"error purging space-1"},
+			},
+		},
+		"alerts at threshold": {
+			options: AlertOptions{AlertFailureThreshold: 0.5},
+			report: &Report{
+				SpacesPurged: 1,
+				Errors:       []string{"error purging space-1"},
+			},
+			expectedSummary: "sandbox purge failed for 1 of 2 spaces (50%)",
+			expectedAlert:   true,
+		},
+		"alerts on quarantined spaces below threshold": {
+			options: AlertOptions{AlertFailureThreshold: 0.5},
+			report: &Report{
+				SpacesPurged:      3,
+				Errors:            []string{"space foo was quarantined"},
+				SpacesQuarantined: []string{"sandbox-bar/foo"},
+			},
+			expectedSummary: "sandbox purge quarantined 1 spaces after failed deletes: sandbox-bar/foo",
+			expectedAlert:   true,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			summary, ok := alertSummary(test.options, test.report, test.runErr)
+			if ok != test.expectedAlert {
+				t.Fatalf("expected alert: %t, got: %t", test.expectedAlert, ok)
+			}
+			if summary != test.expectedSummary {
+				t.Fatalf("expected summary: %s, got: %s", test.expectedSummary, summary)
+			}
+		})
+	}
+}
+
+func TestNewAlerter(t *testing.T) {
+	testCases := map[string]struct {
+		options     AlertOptions
+		expectNil   bool
+		expectedErr string
+	}{
+		"disabled": {
+			expectNil: true,
+		},
+		"pagerduty": {
+			options: AlertOptions{
+				AlertProvider:       alertProviderPagerDuty,
+				PagerDutyRoutingKey: "key",
+			},
+		},
+		"pagerduty missing key": {
+			options: AlertOptions{
+				AlertProvider: alertProviderPagerDuty,
+			},
+			expectNil:   true,
+			expectedErr: "PAGERDUTY_ROUTING_KEY is required for alert provider pagerduty",
+		},
+		"opsgenie missing key": {
+			options: AlertOptions{
+				AlertProvider: alertProviderOpsgenie,
+			},
+			expectNil:   true,
+			expectedErr: "OPSGENIE_API_KEY is required for alert provider opsgenie",
+		},
+		"unknown provider": {
+			options: AlertOptions{
+				AlertProvider: "carrier-pigeon",
+			},
+			expectNil:   true,
+			expectedErr: "unknown alert provider carrier-pigeon",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			a, err := newAlerter(test.options)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && test.expectedErr != err.Error()) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if (a == nil) != test.expectNil {
+				t.Fatalf("expected nil alerter: %t, got: %v", test.expectNil, a)
+			}
+		})
+	}
+}
+
+func TestSendAlert(t *testing.T) {
+	report := &Report{
+		SpacesPurged: 1,
+		Errors:       []string{"error purging space-1"},
+	}
+
+	t.Run("pagerduty", func(t *testing.T) {
+		var event map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		a := &pagerDutyAlerter{
+			options: AlertOptions{
+				AlertSource:         "test",
+				PagerDutyRoutingKey: "routing-key",
+				PagerDutyEventsURL:  server.URL,
+			},
+			httpClient: server.Client(),
+		}
+		if err := a.sendAlert(context.Background(), "summary", report); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if event["routing_key"] != "routing-key" || event["event_action"] != "trigger" {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+		payload := event["payload"].(map[string]interface{})
+		if diff := cmp.Diff([]interface{}{"error purging space-1"}, payload["custom_details"].(map[string]interface{})["errors"]); diff != "" {
+			t.Errorf("sendAlert() mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("opsgenie", func(t *testing.T) {
+		var authorization string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		a := &opsgenieAlerter{
+			options: AlertOptions{
+				OpsgenieAPIKey:    "api-key",
+				OpsgenieAlertsURL: server.URL,
+			},
+			httpClient: server.Client(),
+		}
+		if err := a.sendAlert(context.Background(), "summary", report); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if authorization != "GenieKey api-key" {
+			t.Fatalf("unexpected authorization header: %s", authorization)
+		}
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		a := &opsgenieAlerter{
+			options: AlertOptions{
+				OpsgenieAPIKey:    "api-key",
+				OpsgenieAlertsURL: server.URL,
+			},
+			httpClient: server.Client(),
+		}
+		if err := a.sendAlert(context.Background(), "summary", report); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestOpsgenieMessageTruncation(t *testing.T) {
+	var alert map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	a := &opsgenieAlerter{
+		options: AlertOptions{
+			OpsgenieAPIKey:    "api-key",
+			OpsgenieAlertsURL: server.URL,
+		},
+		httpClient: server.Client(),
+	}
+	// each é is two bytes, so a byte limit would cut one in half
+	summary := "a" + strings.Repeat("é", opsgenieMessageLimit)
+	if err := a.sendAlert(context.Background(), summary, &Report{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	message := alert["message"].(string)
+	if !utf8.ValidString(message) {
+		t.Errorf("expected valid UTF-8, got %q", message)
+	}
+	if expected := "a" + strings.Repeat("é", opsgenieMessageLimit-1); message != expected {
+		t.Errorf("expected message %q, got %q", expected, message)
+	}
+}
+
+func TestAlertAndPublishCanceledRun(t *testing.T) {
+	var summary string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		summary, _ = event["payload"].(map[string]interface{})["summary"].(string)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	a := &pagerDutyAlerter{
+		options: AlertOptions{
+			PagerDutyRoutingKey: "routing-key",
+			PagerDutyEventsURL:  server.URL,
+		},
+		httpClient: server.Client(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	alertAndPublish(ctx, Config{}, a, &Report{}, ctx.Err())
+	if expected := "sandbox purge run aborted: context canceled"; summary != expected {
+		t.Errorf("expected alert %q, got %q", expected, summary)
+	}
+}
@@ -0,0 +1,171 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+const (
+	annotationFirstResource = "sandbox.first-resource"
+	annotationPurgeDate     = "sandbox.purge-date"
+	annotationLastEvaluated = "sandbox.last-evaluated"
+	// annotationPurgeWarning is read by the cloud.gov dashboard, which shows
+	// a banner with the purge date on spaces that carry it
+	annotationPurgeWarning = "notice.purge-warning"
+)
+
+// SpaceAnnotation describes the purge schedule annotations to write to a space;
+// a nil value removes the annotation
+type SpaceAnnotation struct {
+	Org         string             `json:"org"`
+	Space       string             `json:"space"`
+	SpaceGUID   string             `json:"space_guid"`
+	Annotations map[string]*string `json:"annotations"`
+}
+
+// planSpaceAnnotations builds the purge schedule annotations for every space
+// in an org except those being purged, which are recreated without them
+func planSpaceAnnotations(
+	org *resource.Organization,
+	details []SpaceDetails,
+	toPurge []SpaceDetails,
+	opts Config,
+	now time.Time,
+) []SpaceAnnotation {
+	purging := map[string]bool{}
+	for _, purge := range toPurge {
+		purging[purge.Space.GUID] = true
+	}
+
+	annotations := []SpaceAnnotation{}
+	for _, spaceDetails := range details {
+		if purging[spaceDetails.Space.GUID] {
+			continue
+		}
+		metadata := resource.NewMetadata()
+		metadata.SetAnnotation("", annotationLastEvaluated, now.Format("2006-01-02"))
+		if spaceDetails.Timestamp.IsZero() {
+			metadata.RemoveAnnotation("", annotationFirstResource)
+			metadata.RemoveAnnotation("", annotationPurgeDate)
+		} else {
+			metadata.SetAnnotation("", annotationFirstResource, spaceDetails.Timestamp.Format("2006-01-02"))
+			if opts.DisablePurge {
+				metadata.RemoveAnnotation("", annotationPurgeDate)
+			} else {
+				metadata.SetAnnotation("", annotationPurgeDate, spacePurgeCutoff(spaceDetails, opts.PurgeDays).Format("2006-01-02"))
+			}
+		}
+		annotations = append(annotations, SpaceAnnotation{
+			Org:         org.Name,
+			Space:       spaceDetails.Space.Name,
+			SpaceGUID:   spaceDetails.Space.GUID,
+			Annotations: metadata.Annotations,
+		})
+	}
+	return annotations
+}
+
+// planPurgeWarningNotices adds the dashboard's purge warning to spaces being
+// warned and removes it from spaces no longer being warned; spaces already
+// carrying the right notice aren't written, and purged spaces are recreated
+// without it
+func planPurgeWarningNotices(
+	org *resource.Organization,
+	spaces []*resource.Space,
+	toNotify []SpaceDetails,
+	toPurge []SpaceDetails,
+	opts Config,
+) []SpaceAnnotation {
+	warnings := map[string]string{}
+	for _, spaceDetails := range toNotify {
+		warnings[spaceDetails.Space.GUID] = spacePurgeCutoff(spaceDetails, opts.PurgeDays).Format("2006-01-02")
+	}
+	purging := map[string]bool{}
+	for _, purge := range toPurge {
+		purging[purge.Space.GUID] = true
+	}
+
+	notices := []SpaceAnnotation{}
+	for _, space := range spaces {
+		if purging[space.GUID] {
+			continue
+		}
+		var current *string
+		if space.Metadata != nil {
+			current = space.Metadata.Annotations[annotationPurgeWarning]
+		}
+		metadata := resource.NewMetadata()
+		warning, warned := warnings[space.GUID]
+		switch {
+		case warned && (current == nil || *current != warning):
+			metadata.SetAnnotation("", annotationPurgeWarning, warning)
+		case !warned && current != nil:
+			metadata.RemoveAnnotation("", annotationPurgeWarning)
+		default:
+			continue
+		}
+		notices = append(notices, SpaceAnnotation{
+			Org:         org.Name,
+			Space:       space.Name,
+			SpaceGUID:   space.GUID,
+			Annotations: metadata.Annotations,
+		})
+	}
+	return notices
+}
+
+// mergeSpaceAnnotations adds notices to the annotations planned for the same
+// spaces, so each space is written once
+func mergeSpaceAnnotations(annotations []SpaceAnnotation, notices []SpaceAnnotation) []SpaceAnnotation {
+	planned := map[string]int{}
+	for i, annotation := range annotations {
+		planned[annotation.SpaceGUID] = i
+	}
+	for _, notice := range notices {
+		i, ok := planned[notice.SpaceGUID]
+		if !ok {
+			annotations = append(annotations, notice)
+			continue
+		}
+		for key, value := range notice.Annotations {
+			annotations[i].Annotations[key] = value
+		}
+	}
+	return annotations
+}
+
+// applySpaceAnnotations writes planned annotations to each space, recording
+// failures in the report without aborting the run
+func applySpaceAnnotations(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	annotations []SpaceAnnotation,
+	report *Report,
+) {
+	if opts.DryRun {
+		if len(annotations) > 0 {
+			logFields{}.printf("dry run; skipping annotations on %d spaces", len(annotations))
+		}
+		return
+	}
+
+	for _, annotation := range annotations {
+		update := &resource.SpaceUpdate{
+			Metadata: &resource.Metadata{Annotations: annotation.Annotations},
+		}
+		_, err := cfClient.Spaces.Update(ctx, annotation.SpaceGUID, update)
+		if isNotFoundError(err) {
+			logFields{Org: annotation.Org, Space: annotation.Space}.printf("skipping annotations on space %s in org %s; space was deleted during the run", annotation.Space, annotation.Org)
+			continue
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("error annotating space %s in org %s: %s", annotation.Space, annotation.Org, err))
+			continue
+		}
+		report.SpacesAnnotated++
+	}
+}
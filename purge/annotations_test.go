@@ -0,0 +1,208 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestPlanSpaceAnnotations(t *testing.T) {
+	now := time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{Name: "sandbox-org"}
+	active := &resource.Space{GUID: "space-1", Name: "active"}
+	empty := &resource.Space{GUID: "space-2", Name: "empty"}
+	purging := &resource.Space{GUID: "space-3", Name: "purging"}
+	details := []SpaceDetails{
+		{Timestamp: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), Space: active},
+		{Space: empty},
+		{Timestamp: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), Space: purging},
+	}
+	toPurge := details[2:]
+
+	testCases := map[string]struct {
+		opts     Config
+		expected []SpaceAnnotation
+	}{
+		"annotates all but purged spaces": {
+			opts: Config{PurgeDays: 30},
+			expected: []SpaceAnnotation{
+				{
+					Org:       "sandbox-org",
+					Space:     "active",
+					SpaceGUID: "space-1",
+					Annotations: map[string]*string{
+						annotationFirstResource: stringPtr("2024-01-10"),
+						annotationPurgeDate:     stringPtr("2024-02-09"),
+						annotationLastEvaluated: stringPtr("2024-01-20"),
+					},
+				},
+				{
+					Org:       "sandbox-org",
+					Space:     "empty",
+					SpaceGUID: "space-2",
+					Annotations: map[string]*string{
+						annotationFirstResource: nil,
+						annotationPurgeDate:     nil,
+						annotationLastEvaluated: stringPtr("2024-01-20"),
+					},
+				},
+			},
+		},
+		"removes purge date when purge is disabled": {
+			opts: Config{PurgeDays: 30, DisablePurge: true},
+			expected: []SpaceAnnotation{
+				{
+					Org:       "sandbox-org",
+					Space:     "active",
+					SpaceGUID: "space-1",
+					Annotations: map[string]*string{
+						annotationFirstResource: stringPtr("2024-01-10"),
+						annotationPurgeDate:     nil,
+						annotationLastEvaluated: stringPtr("2024-01-20"),
+					},
+				},
+				{
+					Org:       "sandbox-org",
+					Space:     "empty",
+					SpaceGUID: "space-2",
+					Annotations: map[string]*string{
+						annotationFirstResource: nil,
+						annotationPurgeDate:     nil,
+						annotationLastEvaluated: stringPtr("2024-01-20"),
+					},
+				},
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			annotations := planSpaceAnnotations(org, details, toPurge, test.opts, now)
+			if diff := cmp.Diff(test.expected, annotations); diff != "" {
+				t.Errorf("planSpaceAnnotations() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPlanPurgeWarningNotices(t *testing.T) {
+	org := &resource.Organization{Name: "sandbox-org"}
+	noticed := func(guid, warning string) *resource.Space {
+		space := &resource.Space{GUID: guid, Name: guid}
+		if warning != "" {
+			space.Metadata = resource.NewMetadata()
+			space.Metadata.SetAnnotation("", annotationPurgeWarning, warning)
+		}
+		return space
+	}
+	warned := noticed("warned", "")
+	rewarned := noticed("rewarned", "2024-02-01")
+	unchanged := noticed("unchanged", "2024-02-09")
+	extended := noticed("extended", "2024-02-09")
+	quiet := noticed("quiet", "")
+	purging := noticed("purging", "2024-01-20")
+	spaces := []*resource.Space{warned, rewarned, unchanged, extended, quiet, purging}
+	firstResource := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	toNotify := []SpaceDetails{
+		{Timestamp: firstResource, Space: warned},
+		{Timestamp: firstResource, Space: rewarned},
+		{Timestamp: firstResource, Space: unchanged},
+	}
+	toPurge := []SpaceDetails{{Timestamp: firstResource.AddDate(0, -1, 0), Space: purging}}
+
+	notices := planPurgeWarningNotices(org, spaces, toNotify, toPurge, Config{PurgeDays: 30})
+	expected := []SpaceAnnotation{
+		{
+			Org:         "sandbox-org",
+			Space:       "warned",
+			SpaceGUID:   "warned",
+			Annotations: map[string]*string{annotationPurgeWarning: stringPtr("2024-02-09")},
+		},
+		{
+			Org:         "sandbox-org",
+			Space:       "rewarned",
+			SpaceGUID:   "rewarned",
+			Annotations: map[string]*string{annotationPurgeWarning: stringPtr("2024-02-09")},
+		},
+		{
+			Org:         "sandbox-org",
+			Space:       "extended",
+			SpaceGUID:   "extended",
+			Annotations: map[string]*string{annotationPurgeWarning: nil},
+		},
+	}
+	if diff := cmp.Diff(expected, notices); diff != "" {
+		t.Errorf("planPurgeWarningNotices() mismatch (-want +got):\n%s", diff)
+	}
+
+	annotations := []SpaceAnnotation{{
+		Org:         "sandbox-org",
+		Space:       "warned",
+		SpaceGUID:   "warned",
+		Annotations: map[string]*string{annotationLastEvaluated: stringPtr("2024-01-20")},
+	}}
+	merged := mergeSpaceAnnotations(annotations, notices)
+	if len(merged) != 3 {
+		t.Fatalf("expected 3 spaces annotated, got %d", len(merged))
+	}
+	expectedMerged := map[string]*string{
+		annotationLastEvaluated: stringPtr("2024-01-20"),
+		annotationPurgeWarning:  stringPtr("2024-02-09"),
+	}
+	if diff := cmp.Diff(expectedMerged, merged[0].Annotations); diff != "" {
+		t.Errorf("merged annotations mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplySpaceAnnotations(t *testing.T) {
+	annotations := []SpaceAnnotation{{
+		Org:         "sandbox-org",
+		Space:       "active",
+		SpaceGUID:   "space-1",
+		Annotations: map[string]*string{annotationLastEvaluated: stringPtr("2024-01-20")},
+	}}
+
+	testCases := map[string]struct {
+		opts              Config
+		updateErr         error
+		expectedUpdates   int
+		expectedAnnotated int
+		expectedErrors    []string
+	}{
+		"dry run": {
+			opts: Config{DryRun: true},
+		},
+		"annotates spaces": {
+			expectedUpdates:   1,
+			expectedAnnotated: 1,
+		},
+		"records errors": {
+			updateErr:       errors.New("forbidden"),
+			expectedUpdates: 1,
+			expectedErrors:  []string{"error annotating space active in org sandbox-org: forbidden"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			spaces := &mockSpaces{updateErr: test.updateErr}
+			report := &Report{}
+			applySpaceAnnotations(context.Background(), &cfResourceClient{Spaces: spaces}, test.opts, annotations, report)
+			if len(spaces.updates) != test.expectedUpdates {
+				t.Fatalf("expected %d updates, got %d", test.expectedUpdates, len(spaces.updates))
+			}
+			if report.SpacesAnnotated != test.expectedAnnotated {
+				t.Errorf("expected %d spaces annotated, got %d", test.expectedAnnotated, report.SpacesAnnotated)
+			}
+			if diff := cmp.Diff(test.expectedErrors, report.Errors); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package purge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AnomalyOptions describes how a plan is compared against previous runs
+// before any actions are applied
+type AnomalyOptions struct {
+	// AnomalyFactor flags plans with this many times the usual number of
+	// candidates; zero disables anomaly detection
+	AnomalyFactor     float64 `env:"ANOMALY_FACTOR, default=10"`
+	AnomalyMinHistory int     `env:"ANOMALY_MIN_HISTORY, default=3"`
+	AnomalyWindow     int     `env:"ANOMALY_WINDOW, default=10"`
+	// AnomalyMinCandidates is the smallest count treated as significant:
+	// spikes below it and drops to zero from a baseline below it are ignored
+	AnomalyMinCandidates int  `env:"ANOMALY_MIN_CANDIDATES, default=10"`
+	IgnoreAnomalies      bool `env:"IGNORE_ANOMALIES, default=false"`
+}
+
+// RunCounts records how many candidates a run planned, for comparison with
+// later runs
+type RunCounts struct {
+	PlannedAt time.Time `json:"planned_at"`
+	Notify    int       `json:"notify"`
+	Purge     int       `json:"purge"`
+}
+
+// runCounts summarizes a plan's candidates; aged service instance deletions
+// count toward purges
+func (p *Plan) runCounts() RunCounts {
+	counts := p.counts()
+	return RunCounts{
+		PlannedAt: p.CreatedAt,
+		Notify:    counts[planActionNotify],
+		Purge:     counts[planActionPurge] + counts[planActionPurgeInstance],
+	}
+}
+
+// recordRun remembers a run's counts, keeping only the most recent window
+func (s *State) recordRun(counts RunCounts, window int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.History = append(s.History, counts)
+	if window > 0 && len(s.History) > window {
+		s.History = s.History[len(s.History)-window:]
+	}
+}
+
+// history returns the most recent window of remembered run counts
+func (s *State) history(window int) []RunCounts {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := s.History
+	if window > 0 && len(history) > window {
+		history = history[len(history)-window:]
+	}
+	return append([]RunCounts(nil), history...)
+}
+
+// detectAnomalies compares a run's counts against the median of previous
+// runs and describes any sudden spike in candidates, or a drop to zero when
+// there are usually many, that suggests clock skew or bad API data
+func detectAnomalies(opts AnomalyOptions, history []RunCounts, current RunCounts) []string {
+	if opts.AnomalyFactor <= 0 || len(history) < opts.AnomalyMinHistory || len(history) == 0 {
+		return nil
+	}
+
+	var anomalies []string
+	check := func(kind string, count int, previous func(RunCounts) int) {
+		values := make([]int, len(history))
+		for i, run := range history {
+			values[i] = previous(run)
+		}
+		baseline := median(values)
+		switch {
+		case count >= opts.AnomalyMinCandidates && float64(count) > opts.AnomalyFactor*float64(max(baseline, 1)):
+			anomalies = append(anomalies, fmt.Sprintf(
+				"planned %d spaces to %s, more than %gx the median of %d over the last %d runs",
+				count, kind, opts.AnomalyFactor, baseline, len(history),
+			))
+		case count == 0 && baseline >= opts.AnomalyMinCandidates:
+			anomalies = append(anomalies, fmt.Sprintf(
+				"planned no spaces to %s, down from a median of %d over the last %d runs",
+				kind, baseline, len(history),
+			))
+		}
+	}
+	check("notify", current.Notify, func(r RunCounts) int { return r.Notify })
+	check("purge", current.Purge, func(r RunCounts) int { return r.Purge })
+	return anomalies
+}
+
+// checkAnomalies returns an error describing any anomalies in a plan unless
+// IGNORE_ANOMALIES is set
+func checkAnomalies(opts AnomalyOptions, state *State, plan *Plan) error {
+	anomalies := detectAnomalies(opts, state.history(opts.AnomalyWindow), plan.runCounts())
+	if len(anomalies) == 0 {
+		return nil
+	}
+	if opts.IgnoreAnomalies {
+		for _, anomaly := range anomalies {
+			logFields{}.printf("ignoring anomaly: %s", anomaly)
+		}
+		return nil
+	}
+	return fmt.Errorf("refusing to apply anomalous plan (set IGNORE_ANOMALIES=true to override): %s", strings.Join(anomalies, "; "))
+}
+
+func median(values []int) int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,93 @@
+package purge
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDetectAnomalies(t *testing.T) {
+	opts := AnomalyOptions{AnomalyFactor: 10, AnomalyMinHistory: 3, AnomalyMinCandidates: 10}
+	usual := []RunCounts{
+		{Notify: 30, Purge: 2},
+		{Notify: 25, Purge: 3},
+		{Notify: 40, Purge: 2},
+	}
+
+	testCases := map[string]struct {
+		opts     AnomalyOptions
+		history  []RunCounts
+		current  RunCounts
+		expected []string
+	}{
+		"usual run": {
+			opts:    opts,
+			history: usual,
+			current: RunCounts{Notify: 35, Purge: 4},
+		},
+		"not enough history": {
+			opts:    opts,
+			history: usual[:2],
+			current: RunCounts{Notify: 0, Purge: 300},
+		},
+		"disabled": {
+			opts:    AnomalyOptions{},
+			history: usual,
+			current: RunCounts{Notify: 0, Purge: 300},
+		},
+		"purge spike": {
+			opts:     opts,
+			history:  usual,
+			current:  RunCounts{Notify: 30, Purge: 21},
+			expected: []string{"planned 21 spaces to purge, more than 10x the median of 2 over the last 3 runs"},
+		},
+		"small spike from zero": {
+			opts:    opts,
+			history: []RunCounts{{}, {}, {}},
+			current: RunCounts{Purge: 9},
+		},
+		"notify drop": {
+			opts:     opts,
+			history:  usual,
+			current:  RunCounts{Purge: 2},
+			expected: []string{"planned no spaces to notify, down from a median of 30 over the last 3 runs"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			anomalies := detectAnomalies(test.opts, test.history, test.current)
+			if diff := cmp.Diff(test.expected, anomalies); diff != "" {
+				t.Errorf("detectAnomalies() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckAnomalies(t *testing.T) {
+	opts := AnomalyOptions{AnomalyFactor: 10, AnomalyMinHistory: 1, AnomalyWindow: 2, AnomalyMinCandidates: 1}
+	state := &State{}
+	state.recordRun(RunCounts{Notify: 50}, opts.AnomalyWindow)
+	state.recordRun(RunCounts{Notify: 1}, opts.AnomalyWindow)
+	state.recordRun(RunCounts{Notify: 1}, opts.AnomalyWindow)
+	if len(state.History) != 2 {
+		t.Fatalf("expected history trimmed to 2 runs, got %d", len(state.History))
+	}
+
+	plan := &Plan{Actions: []PlannedAction{}}
+	for i := 0; i < 11; i++ {
+		plan.Actions = append(plan.Actions, PlannedAction{Action: planActionPurgeInstance})
+	}
+	err := checkAnomalies(opts, state, plan)
+	expected := "refusing to apply anomalous plan (set IGNORE_ANOMALIES=true to override): planned no spaces to notify, down from a median of 1 over the last 2 runs; planned 11 spaces to purge, more than 10x the median of 0 over the last 2 runs"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got: %v", expected, err)
+	}
+
+	opts.IgnoreAnomalies = true
+	if err := checkAnomalies(opts, state, plan); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := checkAnomalies(opts, nil, plan); err != nil {
+		t.Fatalf("unexpected error without state: %s", err)
+	}
+}
@@ -0,0 +1,164 @@
+package purge
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// guidPattern matches the GUIDs embedded in CF API paths
+var guidPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// EndpointCount is the number of calls made to a CF API endpoint during a run
+type EndpointCount struct {
+	Endpoint string `json:"endpoint"`
+	Calls    int    `json:"calls"`
+}
+
+// APIResponse is a failed CF API response, or a job's state, kept so a
+// failure can be triaged after the environment has changed
+type APIResponse struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	Body   string    `json:"body"`
+}
+
+const (
+	// apiResponseHistory is how many recent responses are kept
+	apiResponseHistory = 50
+	// apiResponseBodyLimit is how much of each response body is kept
+	apiResponseBodyLimit = 4096
+)
+
+// apiCallStats counts CF API calls per endpoint and, when keepResponses is
+// set, remembers recent failed and job responses
+type apiCallStats struct {
+	mu            sync.Mutex
+	counts        map[string]int
+	keepResponses bool
+	responses     []APIResponse
+}
+
+func newAPICallStats() *apiCallStats {
+	return &apiCallStats{counts: map[string]int{}}
+}
+
+// wrap returns a transport that counts each request before sending it with base
+func (s *apiCallStats) wrap(base http.RoundTripper) http.RoundTripper {
+	return &countingTransport{stats: s, base: base}
+}
+
+func (s *apiCallStats) record(endpoint string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[endpoint]++
+}
+
+// recordResponse remembers a response, dropping the oldest once
+// apiResponseHistory are kept
+func (s *apiCallStats) recordResponse(response APIResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, response)
+	if len(s.responses) > apiResponseHistory {
+		s.responses = s.responses[len(s.responses)-apiResponseHistory:]
+	}
+}
+
+// responsesSince returns the remembered responses received at or after since
+func (s *apiCallStats) responsesSince(since time.Time) []APIResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	responses := []APIResponse{}
+	for _, response := range s.responses {
+		if !response.Time.Before(since) {
+			responses = append(responses, response)
+		}
+	}
+	return responses
+}
+
+// total returns the number of calls made to all endpoints
+func (s *apiCallStats) total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, calls := range s.counts {
+		total += calls
+	}
+	return total
+}
+
+// top returns the n most-called endpoints, most calls first
+func (s *apiCallStats) top(n int) []EndpointCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make([]EndpointCount, 0, len(s.counts))
+	for endpoint, calls := range s.counts {
+		counts = append(counts, EndpointCount{Endpoint: endpoint, Calls: calls})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Calls != counts[j].Calls {
+			return counts[i].Calls > counts[j].Calls
+		}
+		return counts[i].Endpoint < counts[j].Endpoint
+	})
+	if n >= 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// log writes the n most-called endpoints to the log
+func (s *apiCallStats) log(n int) {
+	logFields{}.printf("made %d CF API calls", s.total())
+	for _, count := range s.top(n) {
+		logFields{}.printf("  %6d %s", count.Calls, count.Endpoint)
+	}
+}
+
+type countingTransport struct {
+	stats *apiCallStats
+	base  http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.stats.record(endpointName(req))
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || !t.stats.keepResponses {
+		return resp, err
+	}
+	if resp.StatusCode < 400 && !strings.HasPrefix(req.URL.Path, "/v3/jobs/") {
+		return resp, nil
+	}
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return nil, readErr
+	}
+	if len(body) > apiResponseBodyLimit {
+		body = body[:apiResponseBodyLimit]
+	}
+	t.stats.recordResponse(APIResponse{
+		Time:   time.Now(),
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Status: resp.StatusCode,
+		Body:   string(body),
+	})
+	return resp, nil
+}
+
+// endpointName identifies the endpoint a request is for by its method and path,
+// with GUIDs replaced so calls for different resources are counted together
+func endpointName(req *http.Request) string {
+	return req.Method + " " + guidPattern.ReplaceAllString(req.URL.Path, ":guid")
+}
@@ -0,0 +1,118 @@
+package purge
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEndpointName(t *testing.T) {
+	testCases := map[string]struct {
+		method   string
+		url      string
+		expected string
+	}{
+		"list endpoint": {
+			method:   http.MethodGet,
+			url:      "https://api.example.gov/v3/apps?organization_guids=5a2bc512-3d1e-4f43-9c6a-2f8f0a4bd111",
+			expected: "GET /v3/apps",
+		},
+		"resource endpoint": {
+			method:   http.MethodDelete,
+			url:      "https://api.example.gov/v3/spaces/5a2bc512-3d1e-4f43-9c6a-2f8f0a4bd111",
+			expected: "DELETE /v3/spaces/:guid",
+		},
+		"nested endpoint": {
+			method:   http.MethodGet,
+			url:      "https://api.example.gov/v3/spaces/5A2BC512-3D1E-4F43-9C6A-2F8F0A4BD111/users",
+			expected: "GET /v3/spaces/:guid/users",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.url, nil)
+			if got := endpointName(req); got != test.expected {
+				t.Fatalf("expected: %s, got: %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestAPICallStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	stats := newAPICallStats()
+	httpClient := &http.Client{Transport: stats.wrap(http.DefaultTransport)}
+	for _, path := range []string{"/v3/apps", "/v3/apps", "/v3/apps", "/v3/spaces", "/v3/spaces", "/v3/roles"} {
+		resp, err := httpClient.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resp.Body.Close()
+	}
+
+	if stats.total() != 6 {
+		t.Fatalf("expected 6 calls, got: %d", stats.total())
+	}
+	expected := []EndpointCount{
+		{Endpoint: "GET /v3/apps", Calls: 3},
+		{Endpoint: "GET /v3/spaces", Calls: 2},
+	}
+	if diff := cmp.Diff(expected, stats.top(2)); diff != "" {
+		t.Errorf("top() mismatch (-want +got):\n%s", diff)
+	}
+	if len(stats.top(-1)) != 3 {
+		t.Errorf("expected all endpoints for negative n, got: %+v", stats.top(-1))
+	}
+}
+
+func TestAPICallStatsResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v3/spaces/space-1":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"errors":[{"detail":"space has service instances"}]}`)
+		case "/v3/jobs/job-1":
+			io.WriteString(w, `{"state":"FAILED"}`)
+		default:
+			io.WriteString(w, `{}`)
+		}
+	}))
+	defer server.Close()
+
+	stats := newAPICallStats()
+	stats.keepResponses = true
+	httpClient := &http.Client{Transport: stats.wrap(http.DefaultTransport)}
+	started := time.Now()
+	for _, path := range []string{"/v3/apps", "/v3/spaces/space-1", "/v3/jobs/job-1"} {
+		resp, err := httpClient.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if len(body) == 0 {
+			t.Errorf("expected response body for %s to still be readable", path)
+		}
+	}
+
+	var got []string
+	for _, response := range stats.responsesSince(started) {
+		got = append(got, response.Path+" "+response.Body)
+	}
+	expected := []string{
+		`/v3/spaces/space-1 {"errors":[{"detail":"space has service instances"}]}`,
+		`/v3/jobs/job-1 {"state":"FAILED"}`,
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("responsesSince() mismatch (-want +got):\n%s", diff)
+	}
+	if responses := stats.responsesSince(time.Now().Add(time.Hour)); len(responses) != 0 {
+		t.Errorf("expected no responses after now, got %v", responses)
+	}
+}
@@ -0,0 +1,93 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errNotApproved marks actions skipped because they aren't in APPROVED_PLAN
+var errNotApproved = errors.New("skipped: not in the approved plan")
+
+// approvalKey identifies an action across plans: what it does, and to which
+// space and service instance
+func (a PlannedAction) approvalKey() string {
+	key := a.Action
+	if a.Details.Space != nil {
+		key += "/" + a.Details.Space.GUID
+	}
+	if a.ServiceInstance != nil {
+		key += "/" + a.ServiceInstance.GUID
+	}
+	return key
+}
+
+// readApprovedPlan reads a plan signed off for enforcement, from a local
+// path or S3; like POLICY_FILE, a plan read from S3 must carry a valid
+// signature when CONFIG_SIGNING_KEY is set
+func readApprovedPlan(ctx context.Context, opts Config) (*Plan, error) {
+	contents, err := readConfigSource(ctx, opts.configSource(), opts.ApprovedPlan)
+	if err != nil {
+		return nil, fmt.Errorf("error reading approved plan %s: %w", opts.ApprovedPlan, err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(contents, &plan); err != nil {
+		return nil, fmt.Errorf("error decoding approved plan %s: %w", opts.ApprovedPlan, err)
+	}
+	return &plan, nil
+}
+
+// approvePlan keeps only the actions of a fresh plan that the approved plan
+// also holds, recording the rest as skipped. Approved actions that are no
+// longer planned, such as for spaces used again since, aren't applied; they
+// are listed in ApprovedNotPlanned
+func approvePlan(plan *Plan, approved *Plan, report *Report) {
+	approvedKeys := map[string]bool{}
+	for _, action := range approved.Actions {
+		approvedKeys[action.approvalKey()] = true
+	}
+	planned := map[string]bool{}
+	var kept []PlannedAction
+	for _, action := range plan.Actions {
+		key := action.approvalKey()
+		planned[key] = true
+		if !approvedKeys[key] {
+			actionFields(action).printf("skipping %s of %s in org %s; it isn't in the approved plan", action.Action, action.target(), action.Org.Name)
+			report.recordAction(action, errNotApproved)
+			continue
+		}
+		kept = append(kept, action)
+	}
+	for _, action := range approved.Actions {
+		if !planned[action.approvalKey()] {
+			report.ApprovedNotPlanned = append(report.ApprovedNotPlanned, fmt.Sprintf("%s of %s in org %s", action.Action, action.target(), action.Org.Name))
+		}
+	}
+	if len(report.ApprovedNotPlanned) > 0 {
+		logFields{}.printf("%d approved actions are no longer planned and won't be applied", len(report.ApprovedNotPlanned))
+	}
+	plan.Actions = kept
+}
+
+// savePlan writes a plan as JSON to a local path, or to S3 for review
+// before a later run applies it with APPROVED_PLAN
+func savePlan(ctx context.Context, opts Config, path string, plan *Plan) error {
+	bucket, key, ok := parseS3URL(path)
+	if !ok {
+		if strings.HasPrefix(path, "s3://") {
+			return fmt.Errorf("invalid S3 URL %s; expected s3://bucket/key", path)
+		}
+		return writePlanFile(path, plan)
+	}
+	contents, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding plan: %w", err)
+	}
+	if err := newS3Client(opts.S3Options).putObject(ctx, bucket, key, "application/json", contents); err != nil {
+		return err
+	}
+	logFields{}.printf("wrote plan to %s", path)
+	return nil
+}
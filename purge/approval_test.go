@@ -0,0 +1,71 @@
+package purge
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApprovePlan(t *testing.T) {
+	plan := testPlan()
+	approved := testPlan()
+	// approve the purge of baz, and a purge no longer planned
+	approved.Actions = approved.Actions[1:]
+	approved.Actions = append(approved.Actions, PlannedAction{
+		Action:  planActionPurge,
+		Org:     &resource.Organization{GUID: "org-1", Name: "sandbox-bar"},
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-3", Name: "qux"}},
+	})
+	report := &Report{}
+
+	approvePlan(plan, approved, report)
+
+	if len(plan.Actions) != 1 || plan.Actions[0].Details.Space.GUID != "space-2" {
+		t.Errorf("expected only the purge of space-2 to be kept, got %+v", plan.Actions)
+	}
+	expectedSpaces := []SpaceResult{
+		{
+			Org:           "sandbox-bar",
+			Space:         "foo",
+			SpaceGUID:     "space-1",
+			Action:        planActionNotify,
+			FirstResource: testPlan().Actions[0].Details.Timestamp,
+			Recipients:    []string{"foo@bar.gov"},
+			Note:          errNotApproved.Error(),
+		},
+	}
+	if diff := cmp.Diff(expectedSpaces, report.Spaces); diff != "" {
+		t.Errorf("report spaces mismatch (-want +got):\n%s", diff)
+	}
+	expectedNotPlanned := []string{"purge of space qux in org sandbox-bar"}
+	if diff := cmp.Diff(expectedNotPlanned, report.ApprovedNotPlanned); diff != "" {
+		t.Errorf("approved not planned mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApprovedPlanRoundTrip(t *testing.T) {
+	plan := testPlan()
+	path := filepath.Join(t.TempDir(), "plan.json")
+	opts := Config{ApprovedPlan: path}
+
+	if err := savePlan(context.Background(), opts, path, plan); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	read, err := readApprovedPlan(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(plan, read); diff != "" {
+		t.Errorf("readApprovedPlan() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSavePlanInvalidS3URL(t *testing.T) {
+	err := savePlan(context.Background(), Config{}, "s3://bucket", testPlan())
+	if err == nil || err.Error() != "invalid S3 URL s3://bucket; expected s3://bucket/key" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package purge
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// budgetExceeded reports whether a run that started at startedAt has used up
+// its MAX_RUNTIME; a zero budget never runs out
+func budgetExceeded(maxRuntime time.Duration, startedAt time.Time) bool {
+	return maxRuntime > 0 && time.Since(startedAt) > maxRuntime
+}
+
+// prioritizeOrgs moves orgs that a previous run skipped to the front, in the
+// order they were skipped, so every org is eventually processed
+func prioritizeOrgs(orgs []*resource.Organization, skipped []string) []*resource.Organization {
+	if len(skipped) == 0 {
+		return orgs
+	}
+	byGUID := map[string]*resource.Organization{}
+	for _, org := range orgs {
+		byGUID[org.GUID] = org
+	}
+	prioritized := make([]*resource.Organization, 0, len(orgs))
+	first := map[string]bool{}
+	for _, guid := range skipped {
+		if org, ok := byGUID[guid]; ok && !first[guid] {
+			prioritized = append(prioritized, org)
+			first[guid] = true
+		}
+	}
+	for _, org := range orgs {
+		if !first[org.GUID] {
+			prioritized = append(prioritized, org)
+		}
+	}
+	return prioritized
+}
+
+// skippedOrgs returns the GUIDs of orgs the last run didn't get to
+func (s *State) skippedOrgs() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.SkippedOrgs...)
+}
+
+// recordSkippedOrgs remembers the orgs a run didn't get to, replacing those
+// remembered from the previous run
+func (s *State) recordSkippedOrgs(orgs []*resource.Organization) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SkippedOrgs = nil
+	for _, org := range orgs {
+		s.SkippedOrgs = append(s.SkippedOrgs, org.GUID)
+	}
+}
@@ -0,0 +1,88 @@
+package purge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrioritizeOrgs(t *testing.T) {
+	orgs := []*resource.Organization{{GUID: "a"}, {GUID: "b"}, {GUID: "c"}}
+	testCases := map[string]struct {
+		skipped  []string
+		expected []string
+	}{
+		"nothing skipped": {
+			expected: []string{"a", "b", "c"},
+		},
+		"skipped orgs first in skipped order": {
+			skipped:  []string{"c", "b"},
+			expected: []string{"c", "b", "a"},
+		},
+		"ignores deleted orgs": {
+			skipped:  []string{"gone", "b"},
+			expected: []string{"b", "a", "c"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			for _, org := range prioritizeOrgs(orgs, test.skipped) {
+				got = append(got, org.GUID)
+			}
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("prioritizeOrgs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBuildPlanMaxRuntime(t *testing.T) {
+	orgs := []*resource.Organization{
+		{GUID: "org-a", Name: "sandbox-a"},
+		{GUID: "org-b", Name: "sandbox-b"},
+		{GUID: "org-c", Name: "sandbox-c"},
+	}
+	testCases := map[string]struct {
+		maxRuntime          time.Duration
+		expectedOrgsSkipped []string
+		expectedState       []string
+	}{
+		"no budget": {},
+		"budget left": {
+			maxRuntime: 2 * time.Hour,
+		},
+		"budget exceeded": {
+			maxRuntime:          time.Minute,
+			expectedOrgsSkipped: []string{"sandbox-a", "sandbox-b"},
+			expectedState:       []string{"org-a", "org-b"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				Applications:     &mockApplications{},
+				ServiceInstances: &mockServiceInstances{},
+				Routes:           &mockRoutes{},
+				Spaces:           &mockSpaces{},
+			}
+			state := &State{Spaces: map[string]*SpaceState{}, SkippedOrgs: []string{"org-c"}}
+			report := &Report{StartedAt: time.Now().Add(-time.Hour)}
+			opts := Config{MaxRuntime: test.maxRuntime}
+
+			_, err := buildPlan(context.Background(), cfClient, opts, orgs, nil, nil, time.Now(), time.Time{}, state, report, nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(test.expectedOrgsSkipped, report.OrgsSkipped); diff != "" {
+				t.Errorf("skipped orgs mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedState, state.SkippedOrgs); diff != "" {
+				t.Errorf("remembered orgs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
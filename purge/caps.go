@@ -0,0 +1,98 @@
+package purge
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// SpaceCapOptions caps the resources a single sandbox space can hold,
+// whatever its age, so one user can't take outsized capacity within the
+// purge window; zero leaves a resource uncapped
+type SpaceCapOptions struct {
+	SpaceMaxApps     int `env:"SPACE_MAX_APPS, default=0"`
+	SpaceMaxServices int `env:"SPACE_MAX_SERVICES, default=0"`
+	SpaceMaxRoutes   int `env:"SPACE_MAX_ROUTES, default=0"`
+	// EnforceSpaceCaps purges spaces over a cap instead of only reporting
+	// them
+	EnforceSpaceCaps bool `env:"ENFORCE_SPACE_CAPS, default=false"`
+}
+
+func (o SpaceCapOptions) capsSet() bool {
+	return o.SpaceMaxApps > 0 || o.SpaceMaxServices > 0 || o.SpaceMaxRoutes > 0
+}
+
+func (o SpaceCapOptions) validate() error {
+	if o.SpaceMaxApps < 0 || o.SpaceMaxServices < 0 || o.SpaceMaxRoutes < 0 {
+		return fmt.Errorf("SPACE_MAX_APPS, SPACE_MAX_SERVICES, and SPACE_MAX_ROUTES must not be negative")
+	}
+	if o.EnforceSpaceCaps && !o.capsSet() {
+		return fmt.Errorf("ENFORCE_SPACE_CAPS requires SPACE_MAX_APPS, SPACE_MAX_SERVICES, or SPACE_MAX_ROUTES")
+	}
+	return nil
+}
+
+// spaceOverCaps is a space holding more of some resource than its cap
+type spaceOverCaps struct {
+	Space *resource.Space
+	// Exceeded describes each cap it's over, like "12 apps (cap 10)"
+	Exceeded []string
+}
+
+// listOverCapSpaces finds the spaces holding more apps, service instances,
+// or routes than their caps; instances should exclude system plans
+func listOverCapSpaces(inventory *collect.Inventory, opts SpaceCapOptions) []spaceOverCaps {
+	var overCaps []spaceOverCaps
+	for _, space := range inventory.Spaces {
+		var exceeded []string
+		check := func(count int, limit int, noun string) {
+			if limit > 0 && count > limit {
+				exceeded = append(exceeded, fmt.Sprintf("%d %s (cap %d)", count, noun, limit))
+			}
+		}
+		check(len(space.Apps), opts.SpaceMaxApps, "apps")
+		check(len(space.ServiceInstances), opts.SpaceMaxServices, "service instances")
+		check(len(space.Routes), opts.SpaceMaxRoutes, "routes")
+		if len(exceeded) > 0 {
+			overCaps = append(overCaps, spaceOverCaps{Space: space.Space, Exceeded: exceeded})
+		}
+	}
+	return overCaps
+}
+
+// enforceSpaceCaps moves spaces over their caps into toPurge, out of toNotify
+// if they were only due a warning
+func enforceSpaceCaps(
+	overCaps []spaceOverCaps,
+	toNotify []SpaceDetails,
+	toPurge []SpaceDetails,
+	inventory *collect.Inventory,
+	ageBy string,
+	timeStartsAt time.Time,
+) ([]SpaceDetails, []SpaceDetails) {
+	purging := map[string]bool{}
+	for _, details := range toPurge {
+		purging[details.Space.GUID] = true
+	}
+	var spaces []*resource.Space
+	for _, over := range overCaps {
+		if !purging[over.Space.GUID] {
+			spaces = append(spaces, over.Space)
+			purging[over.Space.GUID] = true
+		}
+	}
+	if len(spaces) == 0 {
+		return toNotify, toPurge
+	}
+
+	details := listSpaceFirstResources(inventory.ForSpaces(spaces), ageBy, timeStartsAt)
+	var kept []SpaceDetails
+	for _, d := range toNotify {
+		if !purging[d.Space.GUID] {
+			kept = append(kept, d)
+		}
+	}
+	return kept, append(toPurge, details...)
+}
@@ -0,0 +1,162 @@
+package purge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func appInSpace(guid string, spaceGUID string, createdAt time.Time) *resource.App {
+	return &resource.App{
+		GUID: guid,
+		Relationships: resource.SpaceRelationship{
+			Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: spaceGUID}},
+		},
+		CreatedAt: createdAt,
+	}
+}
+
+func routeInSpace(guid string, spaceGUID string) *resource.Route {
+	return &resource.Route{
+		GUID: guid,
+		Relationships: resource.RouteRelationships{
+			Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: spaceGUID}},
+		},
+	}
+}
+
+func TestListOverCapSpaces(t *testing.T) {
+	now := time.Now()
+	spaces := []*resource.Space{{GUID: "big", Name: "big"}, {GUID: "small", Name: "small"}}
+	apps := []*resource.App{
+		appInSpace("app-1", "big", now),
+		appInSpace("app-2", "big", now),
+		appInSpace("app-3", "big", now),
+		appInSpace("app-4", "small", now),
+	}
+	instances := []*resource.ServiceInstance{instanceInSpace("db-1", "big"), instanceInSpace("db-2", "small")}
+	routes := []*resource.Route{routeInSpace("route-1", "big"), routeInSpace("route-2", "big")}
+	testCases := map[string]struct {
+		opts     SpaceCapOptions
+		expected map[string][]string
+	}{
+		"no caps": {},
+		"over app cap": {
+			opts:     SpaceCapOptions{SpaceMaxApps: 2},
+			expected: map[string][]string{"big": {"3 apps (cap 2)"}},
+		},
+		"at caps": {
+			opts: SpaceCapOptions{SpaceMaxApps: 3, SpaceMaxServices: 1, SpaceMaxRoutes: 2},
+		},
+		"over several caps": {
+			opts:     SpaceCapOptions{SpaceMaxApps: 2, SpaceMaxServices: 1, SpaceMaxRoutes: 1},
+			expected: map[string][]string{"big": {"3 apps (cap 2)", "2 routes (cap 1)"}},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var got map[string][]string
+			for _, over := range listOverCapSpaces(collect.New(&resource.Organization{}, collect.Resources{Spaces: spaces, Apps: apps, ServiceInstances: instances, Routes: routes}), test.opts) {
+				if got == nil {
+					got = map[string][]string{}
+				}
+				got[over.Space.GUID] = over.Exceeded
+			}
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("listOverCapSpaces() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEvaluateResourcesSpaceCaps(t *testing.T) {
+	now := time.Now().Truncate(24 * time.Hour)
+	org := &resource.Organization{GUID: "org-guid", Name: "sandbox-org"}
+	spaces := []*resource.Space{{GUID: "new", Name: "new"}, {GUID: "warned", Name: "warned"}, {GUID: "under", Name: "under"}}
+	apps := []*resource.App{
+		appInSpace("app-1", "new", now.Add(-2*24*time.Hour)),
+		appInSpace("app-2", "new", now.Add(-2*24*time.Hour)),
+		appInSpace("app-3", "warned", now.Add(-26*24*time.Hour)),
+		appInSpace("app-4", "warned", now.Add(-26*24*time.Hour)),
+		appInSpace("app-5", "under", now.Add(-26*24*time.Hour)),
+	}
+	testCases := map[string]struct {
+		opts             Config
+		expectedToNotify []string
+		expectedToPurge  []string
+		expectedOverCaps []string
+	}{
+		"reports only": {
+			opts:             Config{NotifyDays: 25, PurgeDays: 30, SpaceCapOptions: SpaceCapOptions{SpaceMaxApps: 1}},
+			expectedToNotify: []string{"warned", "under"},
+			expectedOverCaps: []string{"new", "warned"},
+		},
+		"enforces": {
+			opts:             Config{NotifyDays: 25, PurgeDays: 30, SpaceCapOptions: SpaceCapOptions{SpaceMaxApps: 1, EnforceSpaceCaps: true}},
+			expectedToNotify: []string{"under"},
+			expectedToPurge:  []string{"new", "warned"},
+			expectedOverCaps: []string{"new", "warned"},
+		},
+		"purge disabled": {
+			opts:             Config{NotifyDays: 25, PurgeDays: 30, DisablePurge: true, SpaceCapOptions: SpaceCapOptions{SpaceMaxApps: 1, EnforceSpaceCaps: true}},
+			expectedToNotify: []string{"warned", "under"},
+			expectedOverCaps: []string{"new", "warned"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			evaluation := evaluateResources(collect.New(org, collect.Resources{Spaces: spaces, Apps: apps}), test.opts, nil, now, time.Time{})
+			spaceGUIDs := func(details []SpaceDetails) []string {
+				var guids []string
+				for _, d := range details {
+					guids = append(guids, d.Space.GUID)
+				}
+				return guids
+			}
+			var overCaps []string
+			for _, over := range evaluation.overCaps {
+				overCaps = append(overCaps, over.Space.GUID)
+			}
+			if diff := cmp.Diff(test.expectedToNotify, spaceGUIDs(evaluation.toNotify)); diff != "" {
+				t.Errorf("toNotify mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedToPurge, spaceGUIDs(evaluation.toPurge)); diff != "" {
+				t.Errorf("toPurge mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedOverCaps, overCaps); diff != "" {
+				t.Errorf("overCaps mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConfigValidateSpaceCaps(t *testing.T) {
+	testCases := map[string]struct {
+		opts        SpaceCapOptions
+		expectedErr string
+	}{
+		"no caps": {},
+		"enforced caps": {
+			opts: SpaceCapOptions{SpaceMaxApps: 10, EnforceSpaceCaps: true},
+		},
+		"negative cap": {
+			opts:        SpaceCapOptions{SpaceMaxRoutes: -1},
+			expectedErr: "SPACE_MAX_APPS, SPACE_MAX_SERVICES, and SPACE_MAX_ROUTES must not be negative",
+		},
+		"enforced without caps": {
+			opts:        SpaceCapOptions{EnforceSpaceCaps: true},
+			expectedErr: "ENFORCE_SPACE_CAPS requires SPACE_MAX_APPS, SPACE_MAX_SERVICES, or SPACE_MAX_ROUTES",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := Config{SpaceCapOptions: test.opts}.Validate()
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Errorf("expected error %q, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
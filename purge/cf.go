@@ -0,0 +1,224 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/config"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// CFOptions describes configuration for connecting to the CF API
+type CFOptions struct {
+	APIAddress   string `env:"API_ADDRESS, required"`
+	ClientID     string `env:"CLIENT_ID, required"`
+	ClientSecret string `env:"CLIENT_SECRET, required"`
+	// LogLevel debug logs every CF API request, to diagnose API failures
+	LogLevel string `env:"LOG_LEVEL, default=info"`
+}
+
+type ApplicationsClient interface {
+	Create(ctx context.Context, r *resource.AppCreate) (*resource.App, error)
+	Delete(ctx context.Context, guid string) (string, error)
+	List(ctx context.Context, opts *client.AppListOptions) ([]*resource.App, *client.Pager, error)
+	ListAll(ctx context.Context, opts *client.AppListOptions) ([]*resource.App, error)
+	Stop(ctx context.Context, guid string) (*resource.App, error)
+}
+
+type OrganizationsClient interface {
+	ListAll(ctx context.Context, opts *client.OrganizationListOptions) ([]*resource.Organization, error)
+	Single(ctx context.Context, opts *client.OrganizationListOptions) (*resource.Organization, error)
+}
+
+type RolesClient interface {
+	CreateSpaceRole(ctx context.Context, spaceGUID, userGUID string, roleType resource.SpaceRoleType) (*resource.Role, error)
+	Delete(ctx context.Context, guid string) (string, error)
+	ListIncludeUsersAll(ctx context.Context, opts *client.RoleListOptions) ([]*resource.Role, []*resource.User, error)
+}
+
+type RoutesClient interface {
+	Delete(ctx context.Context, guid string) (string, error)
+	ListAll(ctx context.Context, opts *client.RouteListOptions) ([]*resource.Route, error)
+}
+
+type ServiceCredentialBindingsClient interface {
+	Delete(ctx context.Context, guid string) error
+	ListAll(ctx context.Context, opts *client.ServiceCredentialBindingListOptions) ([]*resource.ServiceCredentialBinding, error)
+}
+
+type ServiceInstancesClient interface {
+	CreateUserProvided(ctx context.Context, r *resource.ServiceInstanceCreate) (*resource.ServiceInstance, error)
+	Delete(ctx context.Context, guid string) (string, error)
+	Purge(ctx context.Context, guid string) error
+	GetManagedParameters(ctx context.Context, guid string) (*json.RawMessage, error)
+	List(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, *client.Pager, error)
+	ListAll(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, error)
+}
+
+type ServiceRouteBindingsClient interface {
+	Delete(ctx context.Context, guid string) (string, error)
+	ListAll(ctx context.Context, opts *client.ServiceRouteBindingListOptions) ([]*resource.ServiceRouteBinding, error)
+}
+
+type ServicePlansClient interface {
+	ListIncludeServiceOfferingAll(ctx context.Context, opts *client.ServicePlanListOptions) ([]*resource.ServicePlan, []*resource.ServiceOffering, error)
+}
+
+type SpacesClient interface {
+	ListAll(ctx context.Context, opts *client.SpaceListOptions) ([]*resource.Space, error)
+	ListUsersAll(ctx context.Context, spaceGUID string, opts *client.UserListOptions) ([]*resource.User, error)
+	Create(ctx context.Context, r *resource.SpaceCreate) (*resource.Space, error)
+	Delete(ctx context.Context, guid string) (string, error)
+	Get(ctx context.Context, guid string) (*resource.Space, error)
+	GetAssignedIsolationSegment(ctx context.Context, guid string) (string, error)
+	AssignIsolationSegment(ctx context.Context, guid, isolationSegmentGUID string) error
+	Update(ctx context.Context, guid string, r *resource.SpaceUpdate) (*resource.Space, error)
+}
+
+type SpaceQuotasClient interface {
+	ListAll(ctx context.Context, opts *client.SpaceQuotaListOptions) ([]*resource.SpaceQuota, error)
+	Single(ctx context.Context, opts *client.SpaceQuotaListOptions) (*resource.SpaceQuota, error)
+	Apply(ctx context.Context, guid string, spaceGUIDs []string) ([]string, error)
+	Create(ctx context.Context, r *resource.SpaceQuotaCreateOrUpdate) (*resource.SpaceQuota, error)
+	Update(ctx context.Context, guid string, r *resource.SpaceQuotaCreateOrUpdate) (*resource.SpaceQuota, error)
+}
+
+type SecurityGroupsClient interface {
+	Single(ctx context.Context, opts *client.SecurityGroupListOptions) (*resource.SecurityGroup, error)
+	BindRunningSecurityGroup(ctx context.Context, guid string, spaceGUIDs []string) ([]string, error)
+	BindStagingSecurityGroup(ctx context.Context, guid string, spaceGUIDs []string) ([]string, error)
+}
+
+type SpaceFeaturesClient interface {
+	IsSSHEnabled(ctx context.Context, spaceGUID string) (bool, error)
+	EnableSSH(ctx context.Context, spaceGUID string, enable bool) error
+}
+
+type UsersClient interface {
+	ListAll(ctx context.Context, opts *client.UserListOptions) ([]*resource.User, error)
+}
+
+type DropletsClient interface {
+	Delete(ctx context.Context, guid string) (string, error)
+	ListAll(ctx context.Context, opts *client.DropletListOptions) ([]*resource.Droplet, error)
+}
+
+type TasksClient interface {
+	Cancel(ctx context.Context, guid string) (*resource.Task, error)
+	ListAll(ctx context.Context, opts *client.TaskListOptions) ([]*resource.Task, error)
+}
+
+type RootClient interface {
+	Get(ctx context.Context) (*resource.Root, error)
+}
+
+type AuthClient interface {
+	AccessToken(ctx context.Context) (string, error)
+}
+
+type AuditEventsClient interface {
+	ListAll(ctx context.Context, opts *client.AuditEventListOptions) ([]*resource.AuditEvent, error)
+}
+
+type JobsClient interface {
+	Get(ctx context.Context, guid string) (*resource.Job, error)
+	PollComplete(ctx context.Context, jobGUID string, opts *client.PollingOptions) error
+}
+
+type cfResourceClient struct {
+	Root                      RootClient
+	Info                      InfoClient
+	Auth                      AuthClient
+	Applications              ApplicationsClient
+	Droplets                  DropletsClient
+	Organizations             OrganizationsClient
+	Roles                     RolesClient
+	Routes                    RoutesClient
+	ServiceInstances          ServiceInstancesClient
+	ServiceCredentialBindings ServiceCredentialBindingsClient
+	ServiceRouteBindings      ServiceRouteBindingsClient
+	ServicePlans              ServicePlansClient
+	Spaces                    SpacesClient
+	SpaceQuotas               SpaceQuotasClient
+	SpaceFeatures             SpaceFeaturesClient
+	SecurityGroups            SecurityGroupsClient
+	Tasks                     TasksClient
+	Users                     UsersClient
+	Jobs                      JobsClient
+	AuditEvents               AuditEventsClient
+}
+
+// guidBatchSize caps the GUIDs filtered on in a single listing, keeping
+// request URLs well under CF and gorouter limits
+const guidBatchSize = 50
+
+// guidBatches splits guids into batches of at most guidBatchSize
+func guidBatches(guids []string) [][]string {
+	var batches [][]string
+	for start := 0; start < len(guids); start += guidBatchSize {
+		batches = append(batches, guids[start:min(start+guidBatchSize, len(guids))])
+	}
+	return batches
+}
+
+func newCFClient(
+	opts CFOptions,
+	wrapTransport func(http.RoundTripper) http.RoundTripper,
+) (*cfResourceClient, error) {
+	if !validLogLevel(opts.LogLevel) {
+		return nil, fmt.Errorf("unknown LOG_LEVEL %s; expected info or debug", opts.LogLevel)
+	}
+	cfg, err := config.NewClientSecret(
+		opts.APIAddress,
+		opts.ClientID,
+		opts.ClientSecret,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// the rate limiter wraps any other transport so its retries are counted,
+	// and the debug log is innermost so it logs each retry
+	httpClient := cfg.HTTPClient()
+	transport := httpClient.Transport
+	if opts.LogLevel == logLevelDebug {
+		transport = newDebugTransport(transport)
+	}
+	if wrapTransport != nil {
+		transport = wrapTransport(transport)
+	}
+	httpClient.Transport = newRateLimiter().wrap(transport)
+	cf, err := client.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &cfResourceClient{
+		Root:          cf.Root,
+		Info:          &infoClient{apiAddress: opts.APIAddress, httpClient: httpClient},
+		Auth:          cf,
+		Applications:  cf.Applications,
+		Droplets:      cf.Droplets,
+		Organizations: cf.Organizations,
+		Roles:         cf.Roles,
+		Routes:        cf.Routes,
+		ServiceInstances: &purgingServiceInstanceClient{
+			ServiceInstanceClient: cf.ServiceInstances,
+			apiAddress:            opts.APIAddress,
+			auth:                  cf,
+			httpClient:            httpClient,
+		},
+		ServiceCredentialBindings: cf.ServiceCredentialBindings,
+		ServiceRouteBindings:      cf.ServiceRouteBindings,
+		ServicePlans:              cf.ServicePlans,
+		Spaces:                    cf.Spaces,
+		SpaceQuotas:               cf.SpaceQuotas,
+		SpaceFeatures:             cf.SpaceFeatures,
+		SecurityGroups:            cf.SecurityGroups,
+		Tasks:                     cf.Tasks,
+		Users:                     cf.Users,
+		Jobs:                      cf.Jobs,
+		AuditEvents:               cf.AuditEvents,
+	}, nil
+}
@@ -0,0 +1,115 @@
+package purge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// mailTemplateFiles are the files read from TEMPLATE_DIR
+var mailTemplateFiles = []string{"base.html", notifyTemplateName, purgeTemplateName, purgeInstanceTemplateName, welcomeTemplateName, digestTemplateName}
+
+// mailTemplateNames returns mailTemplateFiles along with the tier templates
+// and partials in dir or among names, sorted after the fixed files
+func mailTemplateNames(dir string, names ...string) []string {
+	seen := map[string]bool{}
+	for _, name := range mailTemplateFiles {
+		seen[name] = true
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	var extra []string
+	for _, name := range names {
+		partial, _ := filepath.Match(mailPartialPattern, name)
+		if seen[name] || !(partial || filepath.Ext(name) == ".tmpl") {
+			continue
+		}
+		seen[name] = true
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	return append(append([]string{}, mailTemplateFiles...), extra...)
+}
+
+// vcapService is a service instance bound to a CF app, as listed in
+// VCAP_SERVICES
+type vcapService struct {
+	Name        string                 `json:"name"`
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+// useServiceTemplates replaces TEMPLATE_DIR with a temporary directory
+// holding the templates from the TEMPLATE_SERVICE binding, so a CF task can
+// take multiline templates without files; templates the binding leaves out
+// are copied from TEMPLATE_DIR. The returned function removes the directory
+func (c *Config) useServiceTemplates() (func(), error) {
+	if c.TemplateService == "" {
+		return func() {}, nil
+	}
+	credentials, err := boundServiceCredentials(os.Getenv("VCAP_SERVICES"), c.TemplateService)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "sandbox-templates")
+	if err != nil {
+		return nil, fmt.Errorf("error creating template directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	var bound []string
+	for name := range credentials {
+		bound = append(bound, name)
+	}
+	for _, name := range mailTemplateNames(c.TemplateDir, bound...) {
+		var contents []byte
+		if value, ok := credentials[name]; ok {
+			text, ok := value.(string)
+			if !ok {
+				cleanup()
+				return nil, fmt.Errorf("template %s in service %s is not a string", name, c.TemplateService)
+			}
+			contents = []byte(text)
+		} else {
+			contents, err = os.ReadFile(filepath.Join(c.TemplateDir, name))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				cleanup()
+				return nil, fmt.Errorf("error reading template %s: %w", name, err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), contents, 0644); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("error writing template %s: %w", name, err)
+		}
+	}
+	logFields{}.printf("using email templates from service %s", c.TemplateService)
+	c.TemplateDir = dir
+	return cleanup, nil
+}
+
+// boundServiceCredentials returns the credentials of the named service in a
+// VCAP_SERVICES document
+func boundServiceCredentials(vcapServices string, name string) (map[string]interface{}, error) {
+	if vcapServices == "" {
+		return nil, fmt.Errorf("service %s is not bound: VCAP_SERVICES is not set", name)
+	}
+	services := map[string][]vcapService{}
+	if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+		return nil, fmt.Errorf("error decoding VCAP_SERVICES: %w", err)
+	}
+	for _, instances := range services {
+		for _, service := range instances {
+			if service.Name == name {
+				return service.Credentials, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("service %s is not bound", name)
+}
@@ -0,0 +1,88 @@
+package purge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUseServiceTemplates(t *testing.T) {
+	templateDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(templateDir, notifyTemplateName), []byte("file notify"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(templateDir, purgeTemplateName), []byte("file purge"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(templateDir, "_footer.html"), []byte("file footer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]struct {
+		vcapServices string
+		expected     map[string]string
+		expectedErr  string
+	}{
+		"service templates with fallback": {
+			vcapServices: `{"user-provided": [{"name": "sandbox-templates", "credentials": {"notify.tmpl": "service notify"}}]}`,
+			expected: map[string]string{
+				notifyTemplateName: "service notify",
+				purgeTemplateName:  "file purge",
+				"_footer.html":     "file footer",
+			},
+		},
+		"tier templates": {
+			vcapServices: `{"user-provided": [{"name": "sandbox-templates", "credentials": {"notify-final.tmpl": "service final", "_footer.html": "service footer", "uri": "https://example.gov"}}]}`,
+			expected: map[string]string{
+				notifyTemplateName:  "file notify",
+				purgeTemplateName:   "file purge",
+				"notify-final.tmpl": "service final",
+				"_footer.html":      "service footer",
+			},
+		},
+		"not a string": {
+			vcapServices: `{"user-provided": [{"name": "sandbox-templates", "credentials": {"notify.tmpl": 1}}]}`,
+			expectedErr:  "template notify.tmpl in service sandbox-templates is not a string",
+		},
+		"not bound": {
+			vcapServices: `{"user-provided": [{"name": "other", "credentials": {}}]}`,
+			expectedErr:  "service sandbox-templates is not bound",
+		},
+		"no VCAP_SERVICES": {
+			expectedErr: "service sandbox-templates is not bound: VCAP_SERVICES is not set",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("VCAP_SERVICES", test.vcapServices)
+			cfg := Config{TemplateDir: templateDir, TemplateService: "sandbox-templates"}
+			cleanup, err := cfg.useServiceTemplates()
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			defer cleanup()
+			if cfg.TemplateDir == templateDir {
+				t.Fatalf("expected template directory to be replaced")
+			}
+			for _, name := range append(mailTemplateFiles, "notify-final.tmpl", "_footer.html", "uri") {
+				contents, err := os.ReadFile(filepath.Join(cfg.TemplateDir, name))
+				expected, ok := test.expected[name]
+				if !ok {
+					if !os.IsNotExist(err) {
+						t.Errorf("expected no %s, got: %v", name, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if string(contents) != expected {
+					t.Errorf("expected %s to be %q, got %q", name, expected, contents)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	channelEmail   = "email"
+	channelSlack   = "slack"
+	channelWebhook = "webhook"
+	channelSNS     = "sns"
+)
+
+// ChannelOptions describes configuration for delivering notifications over
+// channels other than email
+type ChannelOptions struct {
+	NotifyPreferencesFile string `env:"NOTIFY_PREFERENCES_FILE"`
+	SlackBotToken         string `env:"SLACK_BOT_TOKEN"`
+	SlackAPIURL           string `env:"SLACK_API_URL, default=https://slack.com/api/chat.postMessage"`
+	NotifyWebhookURL      string `env:"NOTIFY_WEBHOOK_URL"`
+	NotifySNSTopicARN     string `env:"NOTIFY_SNS_TOPIC_ARN"`
+	// NotifySNSEndpoint overrides the regional SNS endpoint
+	NotifySNSEndpoint string `env:"NOTIFY_SNS_ENDPOINT"`
+}
+
+// channelPreference is where a user or domain wants notifications delivered
+type channelPreference struct {
+	Channel   string `json:"channel"`
+	SlackUser string `json:"slack_user,omitempty"`
+}
+
+// channelPreferences maps recipients to notification channels; user
+// preferences take precedence over domain preferences, which take precedence
+// over the default
+type channelPreferences struct {
+	Default string                       `json:"default,omitempty"`
+	Domains map[string]channelPreference `json:"domains,omitempty"`
+	Users   map[string]channelPreference `json:"users,omitempty"`
+}
+
+// readChannelPreferences reads channel preferences from a JSON file
+func readChannelPreferences(path string) (*channelPreferences, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading notification preferences %s: %w", path, err)
+	}
+	prefs := &channelPreferences{}
+	if err := json.Unmarshal(contents, prefs); err != nil {
+		return nil, fmt.Errorf("error decoding notification preferences %s: %w", path, err)
+	}
+	return prefs, nil
+}
+
+// lookup returns the preference for a recipient address
+func (p *channelPreferences) lookup(recipient string) channelPreference {
+	address := strings.ToLower(recipient)
+	pref, ok := p.Users[address]
+	if !ok {
+		domain := address[strings.LastIndex(address, "@")+1:]
+		pref, ok = p.Domains[domain]
+	}
+	if !ok {
+		pref = channelPreference{Channel: p.Default}
+	}
+	if pref.Channel == "" {
+		pref.Channel = channelEmail
+	}
+	if pref.SlackUser == "" {
+		pref.SlackUser = p.Users[address].SlackUser
+	}
+	return pref
+}
+
+// newNotifier returns the mailer to notify users through; without a
+// preferences file every notification goes out as email. SNS topics are
+// published to with the AWS credentials in awsOptions
+func newNotifier(opts ChannelOptions, awsOptions S3Options, email mailer) (mailer, error) {
+	if opts.NotifyPreferencesFile == "" {
+		return email, nil
+	}
+	prefs, err := readChannelPreferences(opts.NotifyPreferencesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	channels := map[string]mailer{channelEmail: email}
+	if opts.SlackBotToken != "" {
+		channels[channelSlack] = &slackNotifier{options: opts, prefs: prefs, httpClient: httpClient}
+	}
+	if opts.NotifyWebhookURL != "" {
+		channels[channelWebhook] = &webhookNotifier{options: opts, httpClient: httpClient}
+	}
+	if opts.NotifySNSTopicARN != "" {
+		channels[channelSNS] = &snsNotifier{options: opts, aws: awsOptions, httpClient: httpClient, now: time.Now}
+	}
+
+	for _, pref := range prefs.allPreferences() {
+		if _, ok := channels[pref.Channel]; !ok {
+			return nil, fmt.Errorf("notification channel %s is not configured", pref.Channel)
+		}
+	}
+	return &notificationDispatcher{prefs: prefs, channels: channels}, nil
+}
+
+// allPreferences returns every preference named in the file, including the default
+func (p *channelPreferences) allPreferences() []channelPreference {
+	prefs := []channelPreference{p.lookup("")}
+	for _, pref := range p.Domains {
+		prefs = append(prefs, pref)
+	}
+	for _, pref := range p.Users {
+		prefs = append(prefs, pref)
+	}
+	return prefs
+}
+
+// notificationDispatcher delivers each notification to its recipients over
+// their preferred channels
+type notificationDispatcher struct {
+	prefs    *channelPreferences
+	channels map[string]mailer
+}
+
+// sendMail groups recipients by channel and sends the notification on each
+func (d *notificationDispatcher) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	byChannel := map[string][]string{}
+	for _, recipient := range recipients {
+		channel := d.prefs.lookup(recipient).Channel
+		byChannel[channel] = append(byChannel[channel], recipient)
+	}
+
+	channels := make([]string, 0, len(byChannel))
+	for channel := range byChannel {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	var errs []string
+	for _, channel := range channels {
+		channelMailer := d.channels[channel]
+		if channelMailer == nil {
+			errs = append(errs, fmt.Sprintf("notification channel %s is not configured", channel))
+			continue
+		}
+		if err := channelMailer.sendMail(ctx, opts, sender, subject, body, thread, byChannel[channel], attachments...); err != nil {
+			errs = append(errs, fmt.Sprintf("error notifying %s via %s: %s", byChannel[channel], channel, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// plainText converts a rendered HTML email body to plain text for chat channels
+func plainText(body string) string {
+	if i := strings.Index(body, "<body>"); i >= 0 {
+		body = body[i:]
+	}
+	text := html.UnescapeString(htmlTagPattern.ReplaceAllString(body, ""))
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+type slackNotifier struct {
+	options    ChannelOptions
+	prefs      *channelPreferences
+	httpClient *http.Client
+}
+
+// sendMail sends a direct message to each recipient's mapped Slack user;
+// attachments aren't sent, since direct messages are plain text
+func (s *slackNotifier) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	text := fmt.Sprintf("*%s*\n%s", subject, plainText(body))
+	for _, recipient := range recipients {
+		slackUser := s.prefs.lookup(recipient).SlackUser
+		if slackUser == "" {
+			return fmt.Errorf("no Slack user mapped for %s", recipient)
+		}
+		message := map[string]string{
+			"channel": slackUser,
+			"text":    text,
+		}
+		result := struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}{}
+		headers := map[string]string{
+			"Authorization": "Bearer " + s.options.SlackBotToken,
+		}
+		if err := postJSON(ctx, s.httpClient, s.options.SlackAPIURL, headers, message, &result); err != nil {
+			return err
+		}
+		if !result.OK {
+			return fmt.Errorf("error sending Slack message to %s: %s", recipient, result.Error)
+		}
+	}
+	return nil
+}
+
+type webhookNotifier struct {
+	options    ChannelOptions
+	httpClient *http.Client
+}
+
+// sendMail posts the notification to the configured webhook
+func (w *webhookNotifier) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	payload := notificationPayload(sender, subject, body, thread, recipients)
+	if len(attachments) > 0 {
+		payload["attachments"] = attachments
+	}
+	return postJSON(ctx, w.httpClient, w.options.NotifyWebhookURL, nil, payload, nil)
+}
+
+// notificationPayload describes a notification for channels that hand it
+// to another system
+func notificationPayload(sender string, subject string, body string, thread mailThread, recipients []string) map[string]interface{} {
+	payload := map[string]interface{}{
+		"sender":     sender,
+		"subject":    subject,
+		"body":       body,
+		"text":       plainText(body),
+		"recipients": recipients,
+	}
+	if thread.MessageID != "" {
+		payload["message_id"] = thread.MessageID
+	}
+	return payload
+}
+
+// postJSON posts a JSON payload and optionally decodes the JSON response
+func postJSON(
+	ctx context.Context,
+	httpClient *http.Client,
+	url string,
+	headers map[string]string,
+	payload interface{},
+	result interface{},
+) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status posting notification to %s: %s", url, resp.Status)
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("error decoding response from %s: %w", url, err)
+		}
+	}
+	return nil
+}
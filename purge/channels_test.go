@@ -0,0 +1,157 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type recordingMailer struct {
+	recipients  []string
+	bodies      []string
+	attachments []mailAttachment
+	err         error
+}
+
+func (m *recordingMailer) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	m.recipients = append(m.recipients, recipients...)
+	m.bodies = append(m.bodies, body)
+	m.attachments = append(m.attachments, attachments...)
+	return m.err
+}
+
+func TestChannelPreferencesLookup(t *testing.T) {
+	prefs := &channelPreferences{
+		Domains: map[string]channelPreference{
+			"agency.gov": {Channel: channelSlack},
+		},
+		Users: map[string]channelPreference{
+			"hook@bar.gov":      {Channel: channelWebhook},
+			"mapped@agency.gov": {SlackUser: "U123"},
+		},
+	}
+	testCases := map[string]struct {
+		recipient        string
+		expectedChannel  string
+		expectedSlackUID string
+	}{
+		"defaults to email": {
+			recipient:       "foo@bar.gov",
+			expectedChannel: channelEmail,
+		},
+		"uses domain preference": {
+			recipient:       "foo@Agency.gov",
+			expectedChannel: channelSlack,
+		},
+		"user preference overrides domain": {
+			recipient:       "hook@bar.gov",
+			expectedChannel: channelWebhook,
+		},
+		"user Slack mapping without channel": {
+			recipient:        "mapped@agency.gov",
+			expectedChannel:  channelEmail,
+			expectedSlackUID: "U123",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			pref := prefs.lookup(test.recipient)
+			if pref.Channel != test.expectedChannel {
+				t.Errorf("expected channel: %s, got: %s", test.expectedChannel, pref.Channel)
+			}
+			if pref.SlackUser != test.expectedSlackUID {
+				t.Errorf("expected Slack user: %s, got: %s", test.expectedSlackUID, pref.SlackUser)
+			}
+		})
+	}
+}
+
+func TestNotificationDispatcher(t *testing.T) {
+	email := &recordingMailer{}
+	webhook := &recordingMailer{err: errors.New("boom")}
+	dispatcher := &notificationDispatcher{
+		prefs: &channelPreferences{
+			Domains: map[string]channelPreference{
+				"agency.gov": {Channel: channelWebhook},
+				"other.gov":  {Channel: channelSlack},
+			},
+		},
+		channels: map[string]mailer{
+			channelEmail:   email,
+			channelWebhook: webhook,
+		},
+	}
+
+	err := dispatcher.sendMail(context.Background(), SMTPOptions{}, "sender", "subject", "body", mailThread{}, []string{
+		"foo@bar.gov",
+		"foo@agency.gov",
+		"foo@other.gov",
+	})
+	expectedErr := "notification channel slack is not configured; error notifying [foo@agency.gov] via webhook: boom"
+	if err == nil || err.Error() != expectedErr {
+		t.Fatalf("expected error: %s, got: %v", expectedErr, err)
+	}
+	if diff := cmp.Diff([]string{"foo@bar.gov"}, email.recipients); diff != "" {
+		t.Errorf("email recipients mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"foo@agency.gov"}, webhook.recipients); diff != "" {
+		t.Errorf("webhook recipients mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var messages []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected authorization header: %s", r.Header.Get("Authorization"))
+		}
+		message := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	notifier := &slackNotifier{
+		options: ChannelOptions{SlackBotToken: "token", SlackAPIURL: server.URL},
+		prefs: &channelPreferences{
+			Users: map[string]channelPreference{
+				"foo@agency.gov": {Channel: channelSlack, SlackUser: "U123"},
+			},
+		},
+		httpClient: server.Client(),
+	}
+
+	body := "<html><head><title>cloud.gov</title></head><body>\n<p>Your sandbox &amp; apps</p>\n</body></html>"
+	if err := notifier.sendMail(context.Background(), SMTPOptions{}, "sender", "Purge warning", body, mailThread{}, []string{"foo@agency.gov"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []map[string]string{{
+		"channel": "U123",
+		"text":    "*Purge warning*\nYour sandbox & apps",
+	}}
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("sendMail() mismatch (-want +got):\n%s", diff)
+	}
+
+	err := notifier.sendMail(context.Background(), SMTPOptions{}, "sender", "Purge warning", body, mailThread{}, []string{"bar@agency.gov"})
+	if err == nil || err.Error() != "no Slack user mapped for bar@agency.gov" {
+		t.Fatalf("expected missing Slack user error, got: %v", err)
+	}
+}
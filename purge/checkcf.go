@@ -0,0 +1,138 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// CheckConfig describes configuration for the CF preflight check
+type CheckConfig struct {
+	CFOptions
+	OrgPrefix string `env:"ORG_PREFIX"`
+	CanaryOrg string `env:"CANARY_ORG"`
+}
+
+// CheckResult is the outcome of a single preflight check
+type CheckResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CheckCF validates that the CF API is reachable, that the configured client
+// can get a token, and that it has the permissions a purge run needs
+func CheckCF(ctx context.Context, cfg CheckConfig) []CheckResult {
+	cfClient, err := newCFClient(cfg.CFOptions, nil)
+	if err != nil {
+		return []CheckResult{{
+			Name:  "connect to API",
+			Error: fmt.Sprintf("error connecting to %s: %s", cfg.APIAddress, err),
+		}}
+	}
+	return runCFChecks(ctx, cfClient, cfg)
+}
+
+// runCFChecks runs each preflight check in turn, stopping at the first
+// failure that later checks depend on
+func runCFChecks(ctx context.Context, cfClient *cfResourceClient, cfg CheckConfig) []CheckResult {
+	var results []CheckResult
+
+	root, err := cfClient.Root.Get(ctx)
+	results = append(results, checkResult("connect to API", err, func() string {
+		return fmt.Sprintf("CF API %s, UAA %s", root.Links.CloudControllerV3.Href, root.Links.Uaa.Href)
+	}))
+	if err != nil {
+		return results
+	}
+
+	_, err = cfClient.Auth.AccessToken(ctx)
+	results = append(results, checkResult("get token", err, func() string {
+		return fmt.Sprintf("client %s authenticated", cfg.ClientID)
+	}))
+	if err != nil {
+		return results
+	}
+
+	orgs, err := listSandboxOrgs(ctx, cfClient, cfg.OrgPrefix)
+	results = append(results, checkResult("list orgs", err, func() string {
+		return fmt.Sprintf("found %d orgs with prefix %q", len(orgs), cfg.OrgPrefix)
+	}))
+
+	if cfg.CanaryOrg == "" {
+		results = append(results, CheckResult{
+			Name:   "delete spaces",
+			OK:     true,
+			Detail: "skipped; no canary org configured",
+		})
+		return results
+	}
+	spaceName, err := checkDeleteSpace(ctx, cfClient, cfg.CanaryOrg)
+	results = append(results, checkResult("delete spaces", err, func() string {
+		return fmt.Sprintf("created and deleted space %s in org %s", spaceName, cfg.CanaryOrg)
+	}))
+
+	return results
+}
+
+func checkResult(name string, err error, detail func() string) CheckResult {
+	if err != nil {
+		return CheckResult{Name: name, Error: err.Error()}
+	}
+	return CheckResult{Name: name, OK: true, Detail: detail()}
+}
+
+// checkDeleteSpace creates a disposable space in the canary org and deletes it
+func checkDeleteSpace(ctx context.Context, cfClient *cfResourceClient, canaryOrg string) (string, error) {
+	org, err := findCanaryOrg(ctx, cfClient, canaryOrg)
+	if err != nil {
+		return "", err
+	}
+
+	spaceName := fmt.Sprintf("cg-sandbox-check-%d", time.Now().Unix())
+	space, err := cfClient.Spaces.Create(ctx, resource.NewSpaceCreate(spaceName, org.GUID))
+	if err != nil {
+		return spaceName, fmt.Errorf("error creating space %s in canary org %s: %w", spaceName, canaryOrg, err)
+	}
+
+	jobGUID, err := cfClient.Spaces.Delete(ctx, space.GUID)
+	if err != nil {
+		return spaceName, fmt.Errorf("error deleting space %s in canary org %s: %w", spaceName, canaryOrg, err)
+	}
+	if err := waitForSpaceDeletion(ctx, cfClient, jobGUID); err != nil {
+		return spaceName, fmt.Errorf("error waiting for delete job %s to be complete: %w", jobGUID, err)
+	}
+	return spaceName, nil
+}
+
+// findCanaryOrg finds the org checks may create and delete spaces in
+func findCanaryOrg(ctx context.Context, cfClient *cfResourceClient, canaryOrg string) (*resource.Organization, error) {
+	orgListOptions := client.NewOrganizationListOptions()
+	orgListOptions.Names.EqualTo(canaryOrg)
+	org, err := cfClient.Organizations.Single(ctx, orgListOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error finding canary org %s: %w", canaryOrg, err)
+	}
+	return org, nil
+}
+
+// WriteCheckResults writes a line per check result and reports whether all checks passed
+func WriteCheckResults(w io.Writer, results []CheckResult) (bool, error) {
+	passed := true
+	for _, result := range results {
+		status, message := "ok", result.Detail
+		if !result.OK {
+			passed = false
+			status, message = "FAIL", result.Error
+		}
+		if _, err := fmt.Fprintf(w, "[%s] %s: %s\n", status, result.Name, message); err != nil {
+			return passed, err
+		}
+	}
+	return passed, nil
+}
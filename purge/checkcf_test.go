@@ -0,0 +1,174 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockRoot struct {
+	root   *resource.Root
+	getErr error
+}
+
+func (r *mockRoot) Get(ctx context.Context) (*resource.Root, error) {
+	return r.root, r.getErr
+}
+
+type mockAuth struct {
+	token    string
+	tokenErr error
+}
+
+func (a *mockAuth) AccessToken(ctx context.Context) (string, error) {
+	if a.token != "" {
+		return a.token, a.tokenErr
+	}
+	return "token", a.tokenErr
+}
+
+type mockOrganizations struct {
+	orgs       []*resource.Organization
+	listErr    error
+	org        *resource.Organization
+	singleErr  error
+	singleOpts *client.OrganizationListOptions
+}
+
+// ListAll returns the orgs matching any GUIDs filtered on, as the API does
+func (o *mockOrganizations) ListAll(ctx context.Context, opts *client.OrganizationListOptions) ([]*resource.Organization, error) {
+	if opts == nil || len(opts.GUIDs.Values) == 0 {
+		return o.orgs, o.listErr
+	}
+	var orgs []*resource.Organization
+	for _, org := range o.orgs {
+		if slices.Contains(opts.GUIDs.Values, org.GUID) {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs, o.listErr
+}
+
+func (o *mockOrganizations) Single(ctx context.Context, opts *client.OrganizationListOptions) (*resource.Organization, error) {
+	o.singleOpts = opts
+	return o.org, o.singleErr
+}
+
+func TestRunCFChecks(t *testing.T) {
+	root := &resource.Root{}
+	root.Links.CloudControllerV3.Href = "https://api.example.gov/v3"
+	root.Links.Uaa.Href = "https://uaa.example.gov"
+
+	newClient := func() *cfResourceClient {
+		return &cfResourceClient{
+			Root: &mockRoot{root: root},
+			Auth: &mockAuth{},
+			Organizations: &mockOrganizations{
+				orgs: []*resource.Organization{
+					{Name: "sandbox-foo"},
+					{Name: "sandbox-bar"},
+					{Name: "cloud-gov"},
+				},
+				org: &resource.Organization{GUID: "canary-guid", Name: "canary"},
+			},
+			Spaces: &mockSpaces{
+				space:         &resource.Space{GUID: "check-space-guid"},
+				deleteJobGUID: "delete-job",
+			},
+			Jobs: &mockJobs{expectedJobGUID: "delete-job"},
+		}
+	}
+
+	testCases := map[string]struct {
+		cfClient       func() *cfResourceClient
+		cfg            CheckConfig
+		expectedChecks []string
+		expectedFailed string
+	}{
+		"all checks pass without canary": {
+			cfClient:       newClient,
+			cfg:            CheckConfig{OrgPrefix: "sandbox-"},
+			expectedChecks: []string{"connect to API", "get token", "list orgs", "delete spaces"},
+		},
+		"all checks pass with canary": {
+			cfClient:       newClient,
+			cfg:            CheckConfig{OrgPrefix: "sandbox-", CanaryOrg: "canary"},
+			expectedChecks: []string{"connect to API", "get token", "list orgs", "delete spaces"},
+		},
+		"stops when API is unreachable": {
+			cfClient: func() *cfResourceClient {
+				c := newClient()
+				c.Root = &mockRoot{getErr: errors.New("connection refused")}
+				return c
+			},
+			expectedChecks: []string{"connect to API"},
+			expectedFailed: "connect to API",
+		},
+		"stops when token fails": {
+			cfClient: func() *cfResourceClient {
+				c := newClient()
+				c.Auth = &mockAuth{tokenErr: errors.New("invalid client")}
+				return c
+			},
+			expectedChecks: []string{"connect to API", "get token"},
+			expectedFailed: "get token",
+		},
+		"fails when canary space can't be deleted": {
+			cfClient: func() *cfResourceClient {
+				c := newClient()
+				c.Spaces = &mockSpaces{
+					space:     &resource.Space{GUID: "check-space-guid"},
+					deleteErr: errors.New("forbidden"),
+				}
+				return c
+			},
+			cfg:            CheckConfig{CanaryOrg: "canary"},
+			expectedChecks: []string{"connect to API", "get token", "list orgs", "delete spaces"},
+			expectedFailed: "delete spaces",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			results := runCFChecks(context.Background(), test.cfClient(), test.cfg)
+
+			var checks []string
+			var failed string
+			for _, result := range results {
+				checks = append(checks, result.Name)
+				if !result.OK {
+					failed = result.Name
+				}
+			}
+			if diff := cmp.Diff(test.expectedChecks, checks); diff != "" {
+				t.Errorf("runCFChecks() mismatch (-want +got):\n%s", diff)
+			}
+			if failed != test.expectedFailed {
+				t.Errorf("expected failed check: %q, got: %q (%+v)", test.expectedFailed, failed, results)
+			}
+		})
+	}
+}
+
+func TestWriteCheckResults(t *testing.T) {
+	var b strings.Builder
+	passed, err := WriteCheckResults(&b, []CheckResult{
+		{Name: "connect to API", OK: true, Detail: "reachable"},
+		{Name: "get token", Error: "invalid client"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if passed {
+		t.Fatal("expected checks to fail")
+	}
+	expected := "[ok] connect to API: reachable\n[FAIL] get token: invalid client\n"
+	if diff := cmp.Diff(expected, b.String()); diff != "" {
+		t.Errorf("WriteCheckResults() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,42 @@
+package purge
+
+import (
+	"context"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// collectClient narrows cfClient to the listings an inventory is collected
+// from
+func collectClient(cfClient *cfResourceClient) *collect.Client {
+	return &collect.Client{
+		Applications:              cfClient.Applications,
+		ServiceInstances:          cfClient.ServiceInstances,
+		Routes:                    cfClient.Routes,
+		Spaces:                    cfClient.Spaces,
+		ServiceCredentialBindings: cfClient.ServiceCredentialBindings,
+		Roles:                     cfClient.Roles,
+	}
+}
+
+// collectOrgInventory lists every space in an org and the resources and
+// roles in them
+func collectOrgInventory(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+) (*collect.Inventory, error) {
+	return collect.Org(ctx, collectClient(cfClient), org)
+}
+
+// collectSpaceInventory lists the resources and roles in a single space of
+// an org; the inventory holds just that space, and finds no orphans
+func collectSpaceInventory(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	space *resource.Space,
+) (*collect.Inventory, error) {
+	return collect.Space(ctx, collectClient(cfClient), org, space)
+}
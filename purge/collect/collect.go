@@ -0,0 +1,226 @@
+package collect
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// guidBatchSize caps the GUIDs filtered on in a single listing, keeping
+// request URLs well under CF and gorouter limits
+const guidBatchSize = 50
+
+type ApplicationsLister interface {
+	ListAll(ctx context.Context, opts *client.AppListOptions) ([]*resource.App, error)
+}
+
+type ServiceInstancesLister interface {
+	ListAll(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, error)
+}
+
+type RoutesLister interface {
+	ListAll(ctx context.Context, opts *client.RouteListOptions) ([]*resource.Route, error)
+}
+
+type SpacesLister interface {
+	ListAll(ctx context.Context, opts *client.SpaceListOptions) ([]*resource.Space, error)
+}
+
+type ServiceCredentialBindingsLister interface {
+	ListAll(ctx context.Context, opts *client.ServiceCredentialBindingListOptions) ([]*resource.ServiceCredentialBinding, error)
+}
+
+type RolesLister interface {
+	ListIncludeUsersAll(ctx context.Context, opts *client.RoleListOptions) ([]*resource.Role, []*resource.User, error)
+}
+
+// Client is the part of the CF API an inventory is listed from
+type Client struct {
+	Applications              ApplicationsLister
+	ServiceInstances          ServiceInstancesLister
+	Routes                    RoutesLister
+	Spaces                    SpacesLister
+	ServiceCredentialBindings ServiceCredentialBindingsLister
+	Roles                     RolesLister
+}
+
+// Org lists every space in an org, sorted by name, and the apps, service
+// instances (managed and user-provided), routes, service keys, and space
+// roles in them; the listings are requested concurrently
+func Org(ctx context.Context, c *Client, org *resource.Organization) (*Inventory, error) {
+	var listed Resources
+	err := listConcurrently(ctx,
+		func(ctx context.Context) (err error) {
+			appListOptions := client.NewAppListOptions()
+			appListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+			listed.Apps, err = c.Applications.ListAll(ctx, appListOptions)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			serviceListOptions := client.NewServiceInstanceListOptions()
+			serviceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+			listed.ServiceInstances, err = c.ServiceInstances.ListAll(ctx, serviceListOptions)
+			if err != nil || len(listed.ServiceInstances) == 0 {
+				return err
+			}
+			listed.ServiceKeys, err = listServiceKeys(ctx, c, listed.ServiceInstances)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			routeListOptions := client.NewRouteListOptions()
+			routeListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+			listed.Routes, err = c.Routes.ListAll(ctx, routeListOptions)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			spaceListOptions := client.NewSpaceListOptions()
+			spaceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+			listed.Spaces, err = c.Spaces.ListAll(ctx, spaceListOptions)
+			if err != nil || len(listed.Spaces) == 0 {
+				return err
+			}
+			SortSpaces(listed.Spaces)
+			listed.Roles, listed.Users, err = listSpaceRoles(ctx, c, listed.Spaces)
+			return err
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return New(org, listed), nil
+}
+
+// Space lists the apps, service instances, routes, service keys, and roles
+// in a single space of an org; the inventory holds just that space, and
+// finds no orphans
+func Space(
+	ctx context.Context,
+	c *Client,
+	org *resource.Organization,
+	space *resource.Space,
+) (*Inventory, error) {
+	listed := Resources{Spaces: []*resource.Space{space}}
+	err := listConcurrently(ctx,
+		func(ctx context.Context) (err error) {
+			appListOptions := client.NewAppListOptions()
+			appListOptions.SpaceGUIDs.EqualTo(space.GUID)
+			listed.Apps, err = c.Applications.ListAll(ctx, appListOptions)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			serviceListOptions := client.NewServiceInstanceListOptions()
+			serviceListOptions.SpaceGUIDs.EqualTo(space.GUID)
+			listed.ServiceInstances, err = c.ServiceInstances.ListAll(ctx, serviceListOptions)
+			if err != nil || len(listed.ServiceInstances) == 0 {
+				return err
+			}
+			listed.ServiceKeys, err = listServiceKeys(ctx, c, listed.ServiceInstances)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			routeListOptions := client.NewRouteListOptions()
+			routeListOptions.SpaceGUIDs.EqualTo(space.GUID)
+			listed.Routes, err = c.Routes.ListAll(ctx, routeListOptions)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			listed.Roles, listed.Users, err = listSpaceRoles(ctx, c, listed.Spaces)
+			return err
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return New(org, listed), nil
+}
+
+// listServiceKeys lists the service keys of instances, guidBatchSize
+// instances per request
+func listServiceKeys(
+	ctx context.Context,
+	c *Client,
+	instances []*resource.ServiceInstance,
+) ([]*resource.ServiceCredentialBinding, error) {
+	var guids []string
+	for _, instance := range instances {
+		guids = append(guids, instance.GUID)
+	}
+	var keys []*resource.ServiceCredentialBinding
+	for _, batch := range guidBatches(guids) {
+		keyListOptions := client.NewServiceCredentialBindingListOptions()
+		keyListOptions.Type.EqualTo("key")
+		keyListOptions.ServiceInstanceGUIDs.EqualTo(batch...)
+		batchKeys, err := c.ServiceCredentialBindings.ListAll(ctx, keyListOptions)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, batchKeys...)
+	}
+	return keys, nil
+}
+
+// listSpaceRoles lists the roles on spaces with their users included,
+// guidBatchSize spaces per request
+func listSpaceRoles(
+	ctx context.Context,
+	c *Client,
+	spaces []*resource.Space,
+) ([]*resource.Role, []*resource.User, error) {
+	var guids []string
+	for _, space := range spaces {
+		guids = append(guids, space.GUID)
+	}
+	var (
+		roles []*resource.Role
+		users []*resource.User
+	)
+	for _, batch := range guidBatches(guids) {
+		roleListOptions := client.NewRoleListOptions()
+		roleListOptions.SpaceGUIDs.EqualTo(batch...)
+		batchRoles, batchUsers, err := c.Roles.ListIncludeUsersAll(ctx, roleListOptions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing roles with users on %d spaces: %w", len(batch), err)
+		}
+		roles = append(roles, batchRoles...)
+		users = append(users, batchUsers...)
+	}
+	return roles, users, nil
+}
+
+// guidBatches splits guids into batches of at most guidBatchSize
+func guidBatches(guids []string) [][]string {
+	var batches [][]string
+	for start := 0; start < len(guids); start += guidBatchSize {
+		batches = append(batches, guids[start:min(start+guidBatchSize, len(guids))])
+	}
+	return batches
+}
+
+// listConcurrently runs every listing at once, canceling the context passed
+// to the rest after the first failure; it returns the first error once they
+// have all finished
+func listConcurrently(ctx context.Context, listings ...func(context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		once     sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for _, listing := range listings {
+		wg.Add(1)
+		go func(listing func(context.Context) error) {
+			defer wg.Done()
+			if err := listing(ctx); err != nil {
+				once.Do(func() { firstErr = err })
+				cancel()
+			}
+		}(listing)
+	}
+	wg.Wait()
+	return firstErr
+}
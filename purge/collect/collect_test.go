@@ -0,0 +1,149 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeApps struct{ apps []*resource.App }
+
+func (f *fakeApps) ListAll(ctx context.Context, opts *client.AppListOptions) ([]*resource.App, error) {
+	return f.apps, nil
+}
+
+type fakeInstances struct{ instances []*resource.ServiceInstance }
+
+func (f *fakeInstances) ListAll(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, error) {
+	return f.instances, nil
+}
+
+type fakeRoutes struct{ routes []*resource.Route }
+
+func (f *fakeRoutes) ListAll(ctx context.Context, opts *client.RouteListOptions) ([]*resource.Route, error) {
+	return f.routes, nil
+}
+
+type fakeSpaces struct{ spaces []*resource.Space }
+
+func (f *fakeSpaces) ListAll(ctx context.Context, opts *client.SpaceListOptions) ([]*resource.Space, error) {
+	return f.spaces, nil
+}
+
+type fakeKeys struct {
+	keys []*resource.ServiceCredentialBinding
+}
+
+func (f *fakeKeys) ListAll(ctx context.Context, opts *client.ServiceCredentialBindingListOptions) ([]*resource.ServiceCredentialBinding, error) {
+	return f.keys, nil
+}
+
+// fakeRoles gives each listed space a developer role held by user-1, and
+// records the spaces filtered on in each request
+type fakeRoles struct {
+	mu      sync.Mutex
+	batches [][]string
+	err     error
+}
+
+func (f *fakeRoles) ListIncludeUsersAll(ctx context.Context, opts *client.RoleListOptions) ([]*resource.Role, []*resource.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	f.batches = append(f.batches, opts.SpaceGUIDs.Values)
+	var roles []*resource.Role
+	for _, spaceGUID := range opts.SpaceGUIDs.Values {
+		roles = append(roles, roleInSpace("role-"+spaceGUID, "user-1", spaceGUID))
+	}
+	return roles, []*resource.User{{GUID: "user-1"}}, nil
+}
+
+func TestOrg(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	var spaces []*resource.Space
+	for i := 60; i > 0; i-- {
+		spaces = append(spaces, &resource.Space{GUID: fmt.Sprintf("space-%02d", i), Name: fmt.Sprintf("space-%02d", i)})
+	}
+	roles := &fakeRoles{}
+	c := &Client{
+		Applications:              &fakeApps{apps: []*resource.App{appInSpace("app-1", "space-01", now)}},
+		ServiceInstances:          &fakeInstances{instances: []*resource.ServiceInstance{instanceInSpace("instance-1", "space-02")}},
+		Routes:                    &fakeRoutes{routes: []*resource.Route{routeInSpace("route-1", "space-03")}},
+		Spaces:                    &fakeSpaces{spaces: spaces},
+		ServiceCredentialBindings: &fakeKeys{keys: []*resource.ServiceCredentialBinding{keyForInstance("key-1", "instance-1")}},
+		Roles:                     roles,
+	}
+
+	inventory, err := Org(context.Background(), c, org)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(inventory.Spaces) != 60 || inventory.Spaces[0].Space.Name != "space-01" {
+		t.Fatalf("expected 60 spaces sorted by name, got %d starting with %s", len(inventory.Spaces), inventory.Spaces[0].Space.Name)
+	}
+	first, second := inventory.Spaces[0], inventory.Spaces[1]
+	if len(first.Apps) != 1 || len(second.ServiceInstances) != 1 || len(second.ServiceKeys) != 1 || len(inventory.Spaces[2].Routes) != 1 {
+		t.Errorf("expected resources grouped under their spaces, got %+v", inventory.Spaces[:3])
+	}
+	if diff := cmp.Diff([]*resource.User{{GUID: "user-1"}}, first.Users); diff != "" {
+		t.Errorf("users mismatch (-want +got):\n%s", diff)
+	}
+	if len(first.Roles) != 1 || first.Roles[0].GUID != "role-space-01" {
+		t.Errorf("expected space-01's role, got %+v", first.Roles)
+	}
+	var batchSizes []int
+	for _, batch := range roles.batches {
+		batchSizes = append(batchSizes, len(batch))
+	}
+	if diff := cmp.Diff([]int{50, 10}, batchSizes); diff != "" {
+		t.Errorf("role batches mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOrgRoleError(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	c := &Client{
+		Applications:     &fakeApps{},
+		ServiceInstances: &fakeInstances{},
+		Routes:           &fakeRoutes{},
+		Spaces:           &fakeSpaces{spaces: []*resource.Space{{GUID: "space-1"}}},
+		Roles:            &fakeRoles{err: errors.New("boom")},
+	}
+	if _, err := Org(context.Background(), c, org); err == nil {
+		t.Fatal("expected an error listing roles")
+	}
+}
+
+func TestSpace(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	space := &resource.Space{GUID: "space-1", Name: "foo"}
+	roles := &fakeRoles{}
+	c := &Client{
+		Applications:     &fakeApps{apps: []*resource.App{appInSpace("app-1", "space-1", now)}},
+		ServiceInstances: &fakeInstances{},
+		Routes:           &fakeRoutes{},
+		Roles:            roles,
+	}
+
+	inventory, err := Space(context.Background(), c, org, space)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(inventory.Spaces) != 1 || len(inventory.Spaces[0].Apps) != 1 || len(inventory.Spaces[0].Roles) != 1 {
+		t.Errorf("expected the space's app and role, got %+v", inventory.Spaces)
+	}
+	if diff := cmp.Diff([][]string{{"space-1"}}, roles.batches); diff != "" {
+		t.Errorf("role batches mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,335 @@
+// Package collect lists what a sandbox org holds into an Inventory: its
+// spaces and the apps, service instances, routes, service keys, and roles in
+// each of them. The purge package makes its decisions and reports from the
+// inventory rather than from the listings themselves.
+package collect
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// Inventory is an org's spaces and the resources in each of them
+type Inventory struct {
+	Org    *resource.Organization
+	Spaces []*SpaceInventory
+	// Orphans are service instances whose space no longer exists in the org
+	Orphans []*resource.ServiceInstance
+	// OrphanedRoutes are routes whose space no longer exists in the org
+	OrphanedRoutes []*resource.Route
+}
+
+// SpaceInventory is a space and the apps, service instances, routes, service
+// keys, and roles in it, each carrying its created and updated timestamps
+type SpaceInventory struct {
+	Space            *resource.Space
+	Apps             []*resource.App
+	ServiceInstances []*resource.ServiceInstance
+	Routes           []*resource.Route
+	ServiceKeys      []*resource.ServiceCredentialBinding
+	Roles            []*resource.Role
+	// Users hold the space's roles, each listed once
+	Users []*resource.User
+}
+
+// Resources are the listings an Inventory is built from
+type Resources struct {
+	Spaces           []*resource.Space
+	Apps             []*resource.App
+	ServiceInstances []*resource.ServiceInstance
+	Routes           []*resource.Route
+	ServiceKeys      []*resource.ServiceCredentialBinding
+	Roles            []*resource.Role
+	// Users are the users holding Roles
+	Users []*resource.User
+}
+
+// New groups an org's listed resources under their spaces; spaces keep the
+// order they are given in
+func New(org *resource.Organization, listed Resources) *Inventory {
+	groupedApps := groupAppsBySpace(listed.Apps)
+	groupedInstances := groupInstancesBySpace(listed.ServiceInstances)
+	groupedRoutes := groupRoutesBySpace(listed.Routes)
+	groupedKeys := groupKeysBySpace(listed.ServiceKeys, listed.ServiceInstances)
+	groupedRoles, groupedUsers := groupRolesBySpace(listed.Roles, listed.Users)
+
+	inventory := &Inventory{
+		Org:            org,
+		Spaces:         make([]*SpaceInventory, 0, len(listed.Spaces)),
+		Orphans:        listOrphanedInstances(listed.Spaces, listed.ServiceInstances),
+		OrphanedRoutes: listOrphanedRoutes(listed.Spaces, listed.Routes),
+	}
+	for _, space := range listed.Spaces {
+		inventory.Spaces = append(inventory.Spaces, &SpaceInventory{
+			Space:            space,
+			Apps:             groupedApps[space.GUID],
+			ServiceInstances: groupedInstances[space.GUID],
+			Routes:           groupedRoutes[space.GUID],
+			ServiceKeys:      groupedKeys[space.GUID],
+			Roles:            groupedRoles[space.GUID],
+			Users:            groupedUsers[space.GUID],
+		})
+	}
+	return inventory
+}
+
+// SpaceList lists the inventory's spaces in order
+func (inv *Inventory) SpaceList() []*resource.Space {
+	spaces := make([]*resource.Space, 0, len(inv.Spaces))
+	for _, space := range inv.Spaces {
+		spaces = append(spaces, space.Space)
+	}
+	return spaces
+}
+
+// ForSpaces narrows the inventory to the given spaces, in the inventory's
+// order
+func (inv *Inventory) ForSpaces(spaces []*resource.Space) *Inventory {
+	wanted := map[string]bool{}
+	for _, space := range spaces {
+		wanted[space.GUID] = true
+	}
+	narrowed := &Inventory{Org: inv.Org}
+	for _, space := range inv.Spaces {
+		if wanted[space.Space.GUID] {
+			narrowed.Spaces = append(narrowed.Spaces, space)
+		}
+	}
+	return narrowed
+}
+
+// WithoutSystemInstances drops service instances provisioned from one of
+// systemPlans, and their service keys; user-provided instances are always
+// kept
+func (inv *Inventory) WithoutSystemInstances(systemPlans map[string]bool) *Inventory {
+	if len(systemPlans) == 0 {
+		return inv
+	}
+	filtered := &Inventory{
+		Org:            inv.Org,
+		Spaces:         make([]*SpaceInventory, 0, len(inv.Spaces)),
+		Orphans:        inv.Orphans,
+		OrphanedRoutes: inv.OrphanedRoutes,
+	}
+	for _, space := range inv.Spaces {
+		instances := make([]*resource.ServiceInstance, 0, len(space.ServiceInstances))
+		kept := map[string]bool{}
+		for _, instance := range space.ServiceInstances {
+			plan := instance.Relationships.ServicePlan
+			if plan != nil && plan.Data != nil && systemPlans[plan.Data.GUID] {
+				continue
+			}
+			instances = append(instances, instance)
+			kept[instance.GUID] = true
+		}
+		var keys []*resource.ServiceCredentialBinding
+		for _, key := range space.ServiceKeys {
+			if kept[key.Relationships.ServiceInstance.Data.GUID] {
+				keys = append(keys, key)
+			}
+		}
+		narrowed := *space
+		narrowed.ServiceInstances = instances
+		narrowed.ServiceKeys = keys
+		filtered.Spaces = append(filtered.Spaces, &narrowed)
+	}
+	return filtered
+}
+
+// Empty reports whether a space holds no apps, routes, or service instances
+func (s *SpaceInventory) Empty() bool {
+	return len(s.Apps) == 0 && len(s.ServiceInstances) == 0 && len(s.Routes) == 0
+}
+
+// FirstResource gets the creation timestamp of the earliest-created resource
+// in the space; roles don't count
+func (s *SpaceInventory) FirstResource() time.Time {
+	var firstResource time.Time
+	earliest := func(createdAt time.Time) {
+		if firstResource.IsZero() || createdAt.Before(firstResource) {
+			firstResource = createdAt
+		}
+	}
+
+	for _, app := range s.Apps {
+		earliest(app.CreatedAt)
+	}
+	for _, instance := range s.ServiceInstances {
+		earliest(instance.CreatedAt)
+	}
+	for _, route := range s.Routes {
+		earliest(route.CreatedAt)
+	}
+	for _, key := range s.ServiceKeys {
+		earliest(key.CreatedAt)
+	}
+	return firstResource
+}
+
+// LastUpdate gets the timestamp of the most recently updated resource in the
+// space; roles don't count
+func (s *SpaceInventory) LastUpdate() time.Time {
+	var lastUpdate time.Time
+	latest := func(updatedAt time.Time) {
+		if updatedAt.After(lastUpdate) {
+			lastUpdate = updatedAt
+		}
+	}
+
+	for _, app := range s.Apps {
+		latest(app.UpdatedAt)
+	}
+	for _, instance := range s.ServiceInstances {
+		latest(instance.UpdatedAt)
+	}
+	for _, route := range s.Routes {
+		latest(route.UpdatedAt)
+	}
+	for _, key := range s.ServiceKeys {
+		latest(key.UpdatedAt)
+	}
+	return lastUpdate
+}
+
+// SortSpaces orders spaces by name, so runs against the same data evaluate
+// and act on them in the same order
+func SortSpaces(spaces []*resource.Space) {
+	sort.SliceStable(spaces, func(i, j int) bool {
+		if spaces[i].Name != spaces[j].Name {
+			return spaces[i].Name < spaces[j].Name
+		}
+		return spaces[i].GUID < spaces[j].GUID
+	})
+}
+
+func groupAppsBySpace(apps []*resource.App) map[string][]*resource.App {
+	grouped := map[string][]*resource.App{}
+	for _, app := range apps {
+		spaceGUID := app.Relationships.Space.Data.GUID
+		grouped[spaceGUID] = append(grouped[spaceGUID], app)
+	}
+	return grouped
+}
+
+func groupInstancesBySpace(instances []*resource.ServiceInstance) map[string][]*resource.ServiceInstance {
+	grouped := map[string][]*resource.ServiceInstance{}
+	for _, instance := range instances {
+		if instance.Relationships.Space == nil || instance.Relationships.Space.Data == nil {
+			continue
+		}
+		spaceGUID := instance.Relationships.Space.Data.GUID
+		grouped[spaceGUID] = append(grouped[spaceGUID], instance)
+	}
+	return grouped
+}
+
+func groupRoutesBySpace(routes []*resource.Route) map[string][]*resource.Route {
+	grouped := map[string][]*resource.Route{}
+	for _, route := range routes {
+		if route.Relationships.Space.Data == nil {
+			continue
+		}
+		spaceGUID := route.Relationships.Space.Data.GUID
+		grouped[spaceGUID] = append(grouped[spaceGUID], route)
+	}
+	return grouped
+}
+
+// groupKeysBySpace groups service keys by the space of their service instance
+func groupKeysBySpace(
+	keys []*resource.ServiceCredentialBinding,
+	instances []*resource.ServiceInstance,
+) map[string][]*resource.ServiceCredentialBinding {
+	instanceSpaces := map[string]string{}
+	for spaceGUID, spaceInstances := range groupInstancesBySpace(instances) {
+		for _, instance := range spaceInstances {
+			instanceSpaces[instance.GUID] = spaceGUID
+		}
+	}
+
+	grouped := map[string][]*resource.ServiceCredentialBinding{}
+	for _, key := range keys {
+		instance := key.Relationships.ServiceInstance
+		if instance == nil || instance.Data == nil {
+			continue
+		}
+		spaceGUID, ok := instanceSpaces[instance.Data.GUID]
+		if !ok {
+			continue
+		}
+		grouped[spaceGUID] = append(grouped[spaceGUID], key)
+	}
+	return grouped
+}
+
+// groupRolesBySpace groups space roles by their space, along with the users
+// holding them; org roles, and roles whose user wasn't listed with them, are
+// left out of the users
+func groupRolesBySpace(
+	roles []*resource.Role,
+	users []*resource.User,
+) (map[string][]*resource.Role, map[string][]*resource.User) {
+	usersByGUID := map[string]*resource.User{}
+	for _, user := range users {
+		usersByGUID[user.GUID] = user
+	}
+
+	groupedRoles := map[string][]*resource.Role{}
+	groupedUsers := map[string][]*resource.User{}
+	added := map[string]map[string]bool{}
+	for _, role := range roles {
+		if role.Relationships.Space.Data == nil || role.Relationships.User.Data == nil {
+			continue
+		}
+		spaceGUID, userGUID := role.Relationships.Space.Data.GUID, role.Relationships.User.Data.GUID
+		groupedRoles[spaceGUID] = append(groupedRoles[spaceGUID], role)
+		if user, ok := usersByGUID[userGUID]; ok && !added[spaceGUID][userGUID] {
+			if added[spaceGUID] == nil {
+				added[spaceGUID] = map[string]bool{}
+			}
+			added[spaceGUID][userGUID] = true
+			groupedUsers[spaceGUID] = append(groupedUsers[spaceGUID], user)
+		}
+	}
+	return groupedRoles, groupedUsers
+}
+
+// listOrphanedInstances finds service instances whose space relationship is
+// missing or points at a space that no longer exists in the org
+func listOrphanedInstances(
+	spaces []*resource.Space,
+	instances []*resource.ServiceInstance,
+) []*resource.ServiceInstance {
+	spaceGUIDs := map[string]bool{}
+	for _, space := range spaces {
+		spaceGUIDs[space.GUID] = true
+	}
+
+	orphans := []*resource.ServiceInstance{}
+	for _, instance := range instances {
+		space := instance.Relationships.Space
+		if space == nil || space.Data == nil || !spaceGUIDs[space.Data.GUID] {
+			orphans = append(orphans, instance)
+		}
+	}
+	return orphans
+}
+
+// listOrphanedRoutes finds routes whose space no longer exists in the org
+func listOrphanedRoutes(spaces []*resource.Space, routes []*resource.Route) []*resource.Route {
+	spaceGUIDs := map[string]bool{}
+	for _, space := range spaces {
+		spaceGUIDs[space.GUID] = true
+	}
+
+	orphans := []*resource.Route{}
+	for _, route := range routes {
+		space := route.Relationships.Space
+		if space.Data == nil || !spaceGUIDs[space.Data.GUID] {
+			orphans = append(orphans, route)
+		}
+	}
+	return orphans
+}
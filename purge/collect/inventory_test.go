@@ -0,0 +1,255 @@
+package collect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func appInSpace(guid string, spaceGUID string, createdAt time.Time) *resource.App {
+	return &resource.App{
+		GUID: guid,
+		Relationships: resource.SpaceRelationship{
+			Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: spaceGUID}},
+		},
+		CreatedAt: createdAt,
+	}
+}
+
+func instanceInSpace(guid string, spaceGUID string) *resource.ServiceInstance {
+	return &resource.ServiceInstance{
+		GUID: guid,
+		Relationships: resource.ServiceInstanceRelationships{
+			Space: &resource.ToOneRelationship{
+				Data: &resource.Relationship{GUID: spaceGUID},
+			},
+		},
+	}
+}
+
+func planInstance(guid string, spaceGUID string, planGUID string) *resource.ServiceInstance {
+	instance := instanceInSpace(guid, spaceGUID)
+	instance.Relationships.ServicePlan = &resource.ToOneRelationship{Data: &resource.Relationship{GUID: planGUID}}
+	return instance
+}
+
+func routeInSpace(guid string, spaceGUID string) *resource.Route {
+	return &resource.Route{
+		GUID: guid,
+		Relationships: resource.RouteRelationships{
+			Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: spaceGUID}},
+		},
+	}
+}
+
+func keyForInstance(guid string, instanceGUID string) *resource.ServiceCredentialBinding {
+	return &resource.ServiceCredentialBinding{
+		GUID: guid,
+		Relationships: resource.ServiceCredentialBindingRelationships{
+			ServiceInstance: &resource.ToOneRelationship{Data: &resource.Relationship{GUID: instanceGUID}},
+		},
+	}
+}
+
+func roleInSpace(guid string, userGUID string, spaceGUID string) *resource.Role {
+	role := &resource.Role{
+		GUID: guid,
+		Type: resource.SpaceRoleDeveloper.String(),
+		Relationships: resource.RoleSpaceUserOrganizationRelationships{
+			User: resource.ToOneRelationship{Data: &resource.Relationship{GUID: userGUID}},
+		},
+	}
+	if spaceGUID != "" {
+		role.Relationships.Space = resource.ToOneRelationship{Data: &resource.Relationship{GUID: spaceGUID}}
+	}
+	return role
+}
+
+func TestNew(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	spaces := []*resource.Space{{GUID: "space-1", Name: "foo"}, {GUID: "space-2", Name: "bar"}}
+	apps := []*resource.App{appInSpace("app-1", "space-1", now), appInSpace("app-2", "space-2", now)}
+	instances := []*resource.ServiceInstance{instanceInSpace("instance-1", "space-1"), instanceInSpace("instance-2", "deleted-space")}
+	routes := []*resource.Route{routeInSpace("route-1", "space-2"), routeInSpace("route-2", "deleted-space")}
+	keys := []*resource.ServiceCredentialBinding{keyForInstance("key-1", "instance-1")}
+	roles := []*resource.Role{
+		roleInSpace("role-1", "user-1", "space-1"),
+		roleInSpace("role-2", "user-1", "space-1"),
+		roleInSpace("role-3", "user-2", "space-2"),
+		roleInSpace("role-4", "user-1", ""),
+	}
+	users := []*resource.User{{GUID: "user-1"}, {GUID: "user-2"}}
+
+	inventory := New(org, Resources{
+		Spaces:           spaces,
+		Apps:             apps,
+		ServiceInstances: instances,
+		Routes:           routes,
+		ServiceKeys:      keys,
+		Roles:            roles,
+		Users:            users,
+	})
+	expected := &Inventory{
+		Org: org,
+		Spaces: []*SpaceInventory{
+			{
+				Space:            spaces[0],
+				Apps:             apps[:1],
+				ServiceInstances: instances[:1],
+				ServiceKeys:      keys,
+				Roles:            roles[:2],
+				Users:            users[:1],
+			},
+			{
+				Space:  spaces[1],
+				Apps:   apps[1:],
+				Routes: routes[:1],
+				Roles:  roles[2:3],
+				Users:  users[1:],
+			},
+		},
+		Orphans:        instances[1:],
+		OrphanedRoutes: routes[1:],
+	}
+	if diff := cmp.Diff(expected, inventory); diff != "" {
+		t.Errorf("New() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]*resource.Space{spaces[1]}, inventory.ForSpaces(spaces[1:]).SpaceList()); diff != "" {
+		t.Errorf("ForSpaces() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestInventoryWithoutSystemInstances(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	spaces := []*resource.Space{{GUID: "space-1", Name: "foo"}}
+	instances := []*resource.ServiceInstance{
+		planInstance("logging", "space-1", "plan-logging"),
+		planInstance("database", "space-1", "plan-db"),
+		instanceInSpace("user-provided", "space-1"),
+	}
+	keys := []*resource.ServiceCredentialBinding{keyForInstance("key-1", "logging"), keyForInstance("key-2", "database")}
+	roles := []*resource.Role{roleInSpace("role-1", "user-1", "space-1")}
+	inventory := New(org, Resources{Spaces: spaces, ServiceInstances: instances, ServiceKeys: keys, Roles: roles})
+
+	filtered := inventory.WithoutSystemInstances(map[string]bool{"plan-logging": true})
+	if diff := cmp.Diff(instances[1:], filtered.Spaces[0].ServiceInstances); diff != "" {
+		t.Errorf("instances mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(keys[1:], filtered.Spaces[0].ServiceKeys); diff != "" {
+		t.Errorf("keys mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(roles, filtered.Spaces[0].Roles); diff != "" {
+		t.Errorf("roles mismatch (-want +got):\n%s", diff)
+	}
+	if len(inventory.Spaces[0].ServiceInstances) != 3 {
+		t.Errorf("expected the unfiltered inventory to be unchanged, got %d instances", len(inventory.Spaces[0].ServiceInstances))
+	}
+}
+
+func TestListOrphanedInstances(t *testing.T) {
+	testCases := map[string]struct {
+		spaces          []*resource.Space
+		instances       []*resource.ServiceInstance
+		expectedOrphans []string
+	}{
+		"no orphans": {
+			spaces:          []*resource.Space{{GUID: "space-1"}},
+			instances:       []*resource.ServiceInstance{instanceInSpace("instance-1", "space-1")},
+			expectedOrphans: []string{},
+		},
+		"instance in deleted space": {
+			spaces: []*resource.Space{{GUID: "space-1"}},
+			instances: []*resource.ServiceInstance{
+				instanceInSpace("instance-1", "space-1"),
+				instanceInSpace("instance-2", "space-2"),
+			},
+			expectedOrphans: []string{"instance-2"},
+		},
+		"missing space relationship": {
+			spaces: []*resource.Space{{GUID: "space-1"}},
+			instances: []*resource.ServiceInstance{
+				{GUID: "instance-1"},
+				{
+					GUID: "instance-2",
+					Relationships: resource.ServiceInstanceRelationships{
+						Space: &resource.ToOneRelationship{},
+					},
+				},
+			},
+			expectedOrphans: []string{"instance-1", "instance-2"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			orphans := listOrphanedInstances(test.spaces, test.instances)
+			guids := []string{}
+			for _, orphan := range orphans {
+				guids = append(guids, orphan.GUID)
+			}
+			if diff := cmp.Diff(test.expectedOrphans, guids); diff != "" {
+				t.Errorf("listOrphanedInstances() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFirstResourceAndLastUpdate(t *testing.T) {
+	now := time.Now()
+	testCases := map[string]struct {
+		space              SpaceInventory
+		expectedFirst      time.Time
+		expectedLastUpdate time.Time
+	}{
+		"skips empty spaces": {},
+		"app and instance": {
+			space: SpaceInventory{
+				Apps:             []*resource.App{{CreatedAt: now.Add(-10 * 24 * time.Hour), UpdatedAt: now.Add(-2 * 24 * time.Hour)}},
+				ServiceInstances: []*resource.ServiceInstance{{CreatedAt: now.Add(-5 * 24 * time.Hour), UpdatedAt: now.Add(-5 * 24 * time.Hour)}},
+			},
+			expectedFirst:      now.Add(-10 * 24 * time.Hour),
+			expectedLastUpdate: now.Add(-2 * 24 * time.Hour),
+		},
+		"route and service key": {
+			space: SpaceInventory{
+				Routes:      []*resource.Route{{CreatedAt: now.Add(-5 * 24 * time.Hour), UpdatedAt: now.Add(-1 * 24 * time.Hour)}},
+				ServiceKeys: []*resource.ServiceCredentialBinding{{CreatedAt: now.Add(-7 * 24 * time.Hour), UpdatedAt: now.Add(-7 * 24 * time.Hour)}},
+			},
+			expectedFirst:      now.Add(-7 * 24 * time.Hour),
+			expectedLastUpdate: now.Add(-1 * 24 * time.Hour),
+		},
+		"roles don't count": {
+			space: SpaceInventory{
+				Roles: []*resource.Role{{CreatedAt: now.Add(-30 * 24 * time.Hour), UpdatedAt: now}},
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if first := test.space.FirstResource(); !first.Equal(test.expectedFirst) {
+				t.Errorf("FirstResource() expected: %s, got: %s", test.expectedFirst, first)
+			}
+			if last := test.space.LastUpdate(); !last.Equal(test.expectedLastUpdate) {
+				t.Errorf("LastUpdate() expected: %s, got: %s", test.expectedLastUpdate, last)
+			}
+		})
+	}
+}
+
+func TestGroupKeysBySpace(t *testing.T) {
+	instances := []*resource.ServiceInstance{instanceInSpace("instance-1", "space-1"), {GUID: "orphan"}}
+	keys := []*resource.ServiceCredentialBinding{
+		keyForInstance("key-1", "instance-1"),
+		keyForInstance("key-2", "orphan"),
+	}
+
+	grouped := groupKeysBySpace(keys, instances)
+	expected := map[string][]*resource.ServiceCredentialBinding{
+		"space-1": {keys[0]},
+	}
+	if diff := cmp.Diff(expected, grouped); diff != "" {
+		t.Errorf("groupKeysBySpace() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,54 @@
+package purge
+
+import (
+	"context"
+	"sync"
+)
+
+// cfRequestConcurrency caps how many per-space CF API requests a single
+// planning step makes at once
+const cfRequestConcurrency = 4
+
+// runConcurrently runs tasks with at most limit running at once, canceling
+// the context passed to the rest after the first failure; it returns the
+// first error once every started task has finished
+func runConcurrently(ctx context.Context, limit int, tasks ...func(context.Context) error) error {
+	if limit < 1 || limit > len(tasks) {
+		limit = len(tasks)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, limit)
+	for _, task := range tasks {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(task func(context.Context) error) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := task(ctx); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}(task)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
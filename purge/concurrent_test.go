@@ -0,0 +1,83 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestRunConcurrently(t *testing.T) {
+	boom := errors.New("boom")
+	testCases := map[string]struct {
+		limit       int
+		failAt      int
+		expectedErr error
+		expectedMax int
+		expectedRan int
+	}{
+		"runs every task within the limit": {
+			limit:       2,
+			failAt:      -1,
+			expectedMax: 2,
+			expectedRan: 6,
+		},
+		"unlimited": {
+			failAt:      -1,
+			expectedMax: 6,
+			expectedRan: 6,
+		},
+		"stops starting tasks after the first error": {
+			limit:       1,
+			failAt:      1,
+			expectedErr: boom,
+			expectedMax: 1,
+			expectedRan: 2,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				mu                  sync.Mutex
+				running, maxRunning int
+				ran                 int
+				allStarted          = make(chan struct{})
+			)
+			tasks := make([]func(context.Context) error, 6)
+			for i := range tasks {
+				tasks[i] = func(ctx context.Context) error {
+					mu.Lock()
+					running++
+					ran++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					if ran == test.expectedMax {
+						close(allStarted)
+					}
+					mu.Unlock()
+					// hold each task open until the limit is reached, so the
+					// observed maximum is deterministic
+					<-allStarted
+					mu.Lock()
+					running--
+					mu.Unlock()
+					if i == test.failAt {
+						return boom
+					}
+					return nil
+				}
+			}
+			err := runConcurrently(context.Background(), test.limit, tasks...)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error: %v, got: %v", test.expectedErr, err)
+			}
+			if maxRunning != test.expectedMax {
+				t.Errorf("expected at most %d tasks running, got %d", test.expectedMax, maxRunning)
+			}
+			if ran != test.expectedRan {
+				t.Errorf("expected %d tasks to run, got %d", test.expectedRan, ran)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package purge
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Config describes common configuration
+type Config struct {
+	OrgPrefix         string `env:"ORG_PREFIX, required"`
+	NotifyDays        int    `env:"NOTIFY_DAYS, default=25"`
+	PurgeDays         int    `env:"PURGE_DAYS, default=30"`
+	MailSender        string `env:"MAIL_SENDER, required"`
+	NotifyMailSubject string `env:"NOTIFY_MAIL_SUBJECT, required"`
+	PurgeMailSubject  string `env:"PURGE_MAIL_SUBJECT, required"`
+	DryRun            bool   `env:"DRY_RUN, default=true"`
+	TimeStartsAt      string `env:"TIME_STARTS_AT"`
+	// RunTime pins a dry run's clock, evaluating spaces and timestamping its
+	// plan and report as of this RFC3339 time, so dry runs against the same
+	// data produce identical reports
+	RunTime          string `env:"RUN_TIME"`
+	DisablePurge     bool   `env:"DISABLE_PURGE, default=false"`
+	SandboxQuotaName string `env:"SANDBOX_QUOTA_NAME, required"`
+	TemplateDir      string `env:"TEMPLATE_DIR, default=../../templates"`
+	// TemplateService names a bound CF service whose credentials hold email
+	// templates keyed by file name, overriding those in TemplateDir
+	TemplateService string `env:"TEMPLATE_SERVICE"`
+	// PolicyFile is a YAML file of per-org-prefix settings overriding these,
+	// or an s3://bucket/key URL of one
+	PolicyFile string `env:"POLICY_FILE"`
+	// ConfigSigningKey is a PEM public key that verifies POLICY_FILE, and
+	// CONFIG_FILE when set in the environment, if they are read from S3
+	ConfigSigningKey string `env:"CONFIG_SIGNING_KEY"`
+	// SpaceGUIDsFile lists the only spaces the run processes, one GUID per
+	// line
+	SpaceGUIDsFile string `env:"SPACE_GUIDS_FILE"`
+	ProfileDir     string `env:"PROFILE_DIR"`
+	PlanFile       string `env:"PLAN_FILE"`
+	PlanOnly       bool   `env:"PLAN_ONLY, default=false"`
+	ApplyPlan      string `env:"APPLY_PLAN"`
+	// ApprovedPlan is a plan signed off for enforcement; the run plans as
+	// usual, but only applies actions that plan also holds
+	ApprovedPlan  string `env:"APPROVED_PLAN"`
+	CFAPITopCalls int    `env:"CF_API_TOP_CALLS, default=10"`
+	ReportFormat  string `env:"REPORT_FORMAT"`
+	ReportFile    string `env:"REPORT_FILE"`
+	StatusFile    string `env:"STATUS_FILE"`
+	// MetricsTextfile is a .prom file for node_exporter's textfile collector,
+	// rewritten with the run's metrics when it finishes
+	MetricsTextfile string `env:"METRICS_TEXTFILE"`
+	AnnotateSpaces  bool   `env:"ANNOTATE_SPACES, default=false"`
+	// DashboardNotices annotates warned spaces for the dashboard's purge
+	// warning banner
+	DashboardNotices bool `env:"DASHBOARD_NOTICES, default=false"`
+	// DashboardURL is the dashboard's base URL, for links to orgs and spaces
+	// in emails and reports
+	DashboardURL     string `env:"DASHBOARD_URL"`
+	StateFile        string `env:"STATE_FILE"`
+	NotifyRecurrence string `env:"NOTIFY_RECURRENCE"`
+	// OnlyNotifyNew warns only spaces the state has no warning for in their
+	// current purge cycle, so a manual re-run doesn't warn everyone again
+	OnlyNotifyNew bool `env:"ONLY_NOTIFY_NEW, default=false"`
+	// InstancePurgeDays deletes service instances older than this many days
+	// ahead of the full purge at PurgeDays; zero disables it
+	InstancePurgeDays        int    `env:"INSTANCE_PURGE_DAYS, default=0"`
+	InstancePurgeMailSubject string `env:"INSTANCE_PURGE_MAIL_SUBJECT, default=Your cloud.gov sandbox service instance has been deleted"`
+	// WelcomeMailSubject enables a welcome email explaining the purge policy
+	// when a space's first resource appears
+	WelcomeMailSubject string `env:"WELCOME_MAIL_SUBJECT"`
+	AttachManifest     bool   `env:"ATTACH_MANIFEST, default=false"`
+	// AttachManifestParameters adds service instances' broker parameters,
+	// with secret-looking values redacted, to attached manifests; they end
+	// up in emails and plan files, so they are left out unless asked for
+	AttachManifestParameters bool `env:"ATTACH_MANIFEST_PARAMETERS, default=false"`
+	// QuarantineBlockedSpaces stops apps and labels a space purge-blocked
+	// when its delete fails, rather than deleting its apps
+	QuarantineBlockedSpaces bool `env:"QUARANTINE_BLOCKED_SPACES, default=false"`
+	// SkipCustomQuotaSpaces leaves spaces assigned a quota other than the
+	// sandbox quota out of purges, listing them for manual review
+	SkipCustomQuotaSpaces bool `env:"SKIP_CUSTOM_QUOTA_SPACES, default=false"`
+	// PurgeDeleteFailedInstances purges service instances whose deletes
+	// failed, like cf purge-service-instance, so their space can be deleted
+	PurgeDeleteFailedInstances bool          `env:"PURGE_DELETE_FAILED_INSTANCES, default=false"`
+	SpaceCreateRetries         int           `env:"SPACE_CREATE_RETRIES, default=3"`
+	SpaceCreateRetryDelay      time.Duration `env:"SPACE_CREATE_RETRY_DELAY, default=30s"`
+	// DeprovisionTimeout is how long to wait for service instances to
+	// deprovision before leaving their space's purge for the next run
+	DeprovisionTimeout      time.Duration `env:"DEPROVISION_TIMEOUT, default=30m"`
+	DeprovisionPollInterval time.Duration `env:"DEPROVISION_POLL_INTERVAL, default=15s"`
+	// SpaceSSH is "preserve" to give a recreated space the purged space's
+	// SSH setting, or "enabled" or "disabled" to force one
+	SpaceSSH string `env:"SPACE_SSH, default=preserve"`
+	// SpaceSecurityGroups names the application security groups bound to
+	// every recreated space, for running and staging apps
+	SpaceSecurityGroups []string `env:"SPACE_SECURITY_GROUPS"`
+	// AgeBy is "created" to age a space from its first resource's creation,
+	// or "updated" to age it from its latest resource update
+	AgeBy string `env:"AGE_BY, default=created"`
+	// StopAppsOnNotify stops a space's running apps when its purge warning
+	// is sent, leaving its data intact until the purge
+	StopAppsOnNotify bool `env:"STOP_APPS_ON_NOTIFY, default=false"`
+	// CreateUserSpaces creates the missing space named after each sandbox org
+	// user's email local part, with the sandbox quota and the user's roles
+	CreateUserSpaces bool `env:"CREATE_USER_SPACES, default=false"`
+	// MaxRuntime stops a run from starting new orgs once it has run this
+	// long; zero means no limit
+	MaxRuntime time.Duration `env:"MAX_RUNTIME, default=0"`
+	// PurgesPerHour spaces out space purges so no more than this many start
+	// in an hour; zero doesn't limit them
+	PurgesPerHour int `env:"PURGES_PER_HOUR, default=0"`
+	// EmptySpaceDeleteDays deletes spaces recreated by a purge that are still
+	// empty this many days later, rather than keeping them; zero disables it
+	EmptySpaceDeleteDays int `env:"EMPTY_SPACE_DELETE_DAYS, default=0"`
+	// TargetedQueryThreshold lists an org's resources space by space once
+	// it holds more apps or service instances than this; zero disables it
+	TargetedQueryThreshold int `env:"TARGETED_QUERY_THRESHOLD, default=5000"`
+	// VerifySampleSize re-reads this many randomly chosen recreated spaces
+	// once a run's purges finish, checking them again from scratch; zero
+	// disables it
+	VerifySampleSize int `env:"VERIFY_SAMPLE_SIZE, default=0"`
+	CFOptions
+	SMTPOptions
+	GraphOptions
+	MailOptions
+	ChannelOptions
+	AlertOptions
+	QuotaOptions
+	SpaceCapOptions
+	InventoryOptions
+	SystemServiceOptions
+	TriageOptions
+	LeaderboardOptions
+	AgencyRollupOptions
+	AckOptions
+	AnomalyOptions
+	GitHubOptions
+	PlanCostOptions
+	OperatorDigestOptions
+	KillSwitchOptions
+	FoundationOptions
+	UAAUserCheckOptions
+
+	// policies are read from PolicyFile at startup
+	policies []OrgPolicy
+	// notifyTiers are the purge warning tiers read from PolicyFile
+	notifyTiers []NotifyTier
+	// policyDigest is the SHA-256 digest of PolicyFile as it was read
+	policyDigest string
+	// spaceGUIDs are read from SpaceGUIDsFile at startup, or found by a
+	// watch poll
+	spaceGUIDs map[string]bool
+	// watching limits a run to the welcomes and annotations of a watch poll
+	watching bool
+	// planCostTable is read from PlanCostFile at startup, and planCosts
+	// holds its costs by plan GUID once a run has looked the plans up
+	planCostTable map[string]float64
+	planCosts     map[string]float64
+	// killSwitch is checked as the run starts and between orgs
+	killSwitch *killSwitch
+	// clockAhead evaluates spaces as if the run were this much later, so the
+	// e2e check can purge a space it just created
+	clockAhead time.Duration
+	// userCheck looks up the users whose roles a purge recreates when
+	// UAA_USER_CHECK is set
+	userCheck *uaaUserChecker
+}
+
+// pinnedTime returns RUN_TIME, if it is set
+func (c Config) pinnedTime() (time.Time, bool) {
+	if c.RunTime == "" {
+		return time.Time{}, false
+	}
+	pinned, err := time.Parse(time.RFC3339, c.RunTime)
+	return pinned, err == nil
+}
+
+// timeStartsAt returns TIME_STARTS_AT, or the zero time if it isn't set
+func (c Config) timeStartsAt() (time.Time, error) {
+	if c.TimeStartsAt == "" {
+		return time.Time{}, nil
+	}
+	timeStartsAt, err := time.Parse(time.RFC3339Nano, c.TimeStartsAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing time starts at: %w", err)
+	}
+	return timeStartsAt, nil
+}
+
+// Validate checks settings that can't be expressed as env tags
+func (c Config) Validate() error {
+	if !validLogLevel(c.LogLevel) {
+		return fmt.Errorf("unknown LOG_LEVEL %s; expected info or debug", c.LogLevel)
+	}
+	if !validReportFormat(c.ReportFormat) {
+		return fmt.Errorf("unknown report format %s", c.ReportFormat)
+	}
+	if c.MailOverrideRecipient != "" {
+		if _, err := mail.ParseAddress(c.MailOverrideRecipient); err != nil {
+			return fmt.Errorf("invalid MAIL_OVERRIDE_RECIPIENT %s: %w", c.MailOverrideRecipient, err)
+		}
+	}
+	if c.RunTime != "" {
+		if _, err := time.Parse(time.RFC3339, c.RunTime); err != nil {
+			return fmt.Errorf("invalid RUN_TIME %s: %w", c.RunTime, err)
+		}
+		if !c.DryRun {
+			return fmt.Errorf("RUN_TIME can only be used with DRY_RUN")
+		}
+	}
+	if c.MailHoldAfterCFFailures < 0 {
+		return fmt.Errorf("MAIL_HOLD_AFTER_CF_FAILURES must not be negative, got %d", c.MailHoldAfterCFFailures)
+	}
+	if c.VerifySampleSize < 0 {
+		return fmt.Errorf("VERIFY_SAMPLE_SIZE must not be negative, got %d", c.VerifySampleSize)
+	}
+	if !validSpaceSSH(c.SpaceSSH) {
+		return fmt.Errorf("unknown SPACE_SSH %s; expected preserve, enabled, or disabled", c.SpaceSSH)
+	}
+	if !validAgeBy(c.AgeBy) {
+		return fmt.Errorf("unknown AGE_BY %s; expected created or updated", c.AgeBy)
+	}
+	if c.AgeBy == ageByUpdated && c.StopAppsOnNotify {
+		return fmt.Errorf("STOP_APPS_ON_NOTIFY can't be used with AGE_BY=updated; stopping apps updates them and restarts the clock")
+	}
+	if _, err := parseRecurrencePolicies(c.NotifyRecurrence); err != nil {
+		return err
+	}
+	if c.NotifyRecurrence != "" && c.StateFile == "" {
+		return fmt.Errorf("STATE_FILE is required for NOTIFY_RECURRENCE")
+	}
+	if c.OnlyNotifyNew && c.StateFile == "" {
+		return fmt.Errorf("STATE_FILE is required for ONLY_NOTIFY_NEW")
+	}
+	if c.MetricsTextfile != "" && !strings.HasSuffix(c.MetricsTextfile, ".prom") {
+		return fmt.Errorf("METRICS_TEXTFILE %s must end in .prom for node_exporter to read it", c.MetricsTextfile)
+	}
+	if c.welcomeEnabled() && c.StateFile == "" {
+		return fmt.Errorf("STATE_FILE is required for WELCOME_MAIL_SUBJECT")
+	}
+	if c.SpaceGUIDsFile != "" && c.ApplyPlan != "" {
+		return fmt.Errorf("SPACE_GUIDS_FILE can't be used with APPLY_PLAN")
+	}
+	if c.ApprovedPlan != "" && c.ApplyPlan != "" {
+		return fmt.Errorf("APPROVED_PLAN can't be used with APPLY_PLAN")
+	}
+	if c.MaxRuntime > 0 && c.StateFile == "" {
+		return fmt.Errorf("STATE_FILE is required for MAX_RUNTIME")
+	}
+	if c.PurgesPerHour < 0 {
+		return fmt.Errorf("PURGES_PER_HOUR must not be negative")
+	}
+	if err := validateDashboardURL(c.DashboardURL); err != nil {
+		return err
+	}
+	if c.EmptySpaceDeleteDays < 0 {
+		return fmt.Errorf("EMPTY_SPACE_DELETE_DAYS must not be negative")
+	}
+	if c.LeaderboardCSVDir != "" && c.LeaderboardSize <= 0 {
+		return fmt.Errorf("LEADERBOARD_SIZE is required for LEADERBOARD_CSV_DIR")
+	}
+	if c.AgencyRollupCSV != "" && !c.AgencyRollups {
+		return fmt.Errorf("AGENCY_ROLLUPS is required for AGENCY_ROLLUP_CSV")
+	}
+	if err := c.AckOptions.validate(c.StateFile); err != nil {
+		return err
+	}
+	if err := c.GitHubOptions.validate(); err != nil {
+		return err
+	}
+	if err := c.KillSwitchOptions.validate(); err != nil {
+		return err
+	}
+	if err := c.PlanCostOptions.validate(); err != nil {
+		return err
+	}
+	if err := c.SpaceCapOptions.validate(); err != nil {
+		return err
+	}
+	if err := c.S3Options.validate(); err != nil {
+		return err
+	}
+	if err := c.OperatorDigestOptions.validate(c.StateFile); err != nil {
+		return err
+	}
+	return c.QuotaOptions.validate()
+}
+
+// collectsInventory reports whether planning needs to describe every space,
+// for the inventory export, the leaderboard, or the agency rollups
+func (c Config) collectsInventory() bool {
+	return c.InventoryOptions.enabled() || c.LeaderboardSize > 0 || c.AgencyRollups
+}
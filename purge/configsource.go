@@ -0,0 +1,123 @@
+package purge
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// configSource describes how CONFIG_FILE and POLICY_FILE are read when they
+// name s3://bucket/key URLs instead of local paths
+type configSource struct {
+	S3Options
+	// ConfigSigningKey is a local PEM public key; when it is set, a config
+	// file read from S3 is only used if the detached signature next to it,
+	// under the same key with .sig appended, verifies against it
+	ConfigSigningKey string `env:"CONFIG_SIGNING_KEY"`
+}
+
+// configSource returns where the config's own policy file is read from
+func (c Config) configSource() configSource {
+	return configSource{S3Options: c.S3Options, ConfigSigningKey: c.ConfigSigningKey}
+}
+
+// parseS3URL splits an s3://bucket/key URL; ok is false for anything else
+func parseS3URL(path string) (bucket string, key string, ok bool) {
+	rest, found := strings.CutPrefix(path, "s3://")
+	if !found {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return "", "", false
+	}
+	return bucket, key, true
+}
+
+// readConfigSource reads a config file from a local path or from S3; an
+// object read from S3 must carry a valid signature when a signing key is
+// configured, so a compromised bucket can't change the settings
+func readConfigSource(ctx context.Context, source configSource, path string) ([]byte, error) {
+	bucket, key, ok := parseS3URL(path)
+	if !ok {
+		if strings.HasPrefix(path, "s3://") {
+			return nil, fmt.Errorf("invalid S3 URL %s; expected s3://bucket/key", path)
+		}
+		return os.ReadFile(path)
+	}
+	client := newS3Client(source.S3Options)
+	contents, err := client.getObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if source.ConfigSigningKey == "" {
+		return contents, nil
+	}
+	signature, err := client.getObject(ctx, bucket, key+".sig")
+	if err != nil {
+		return nil, fmt.Errorf("error reading signature of %s: %w", path, err)
+	}
+	if err := verifyConfigSignature(source.ConfigSigningKey, contents, signature); err != nil {
+		return nil, fmt.Errorf("error verifying signature of %s: %w", path, err)
+	}
+	return contents, nil
+}
+
+// verifyConfigSignature checks a detached signature of contents against the
+// public key in keyFile. Signatures may be base64, as `cosign sign-blob`
+// writes them, or raw bytes, as `openssl dgst -sha256 -sign` does; ECDSA
+// and RSA keys sign a SHA-256 digest, and Ed25519 keys sign contents itself
+func verifyConfigSignature(keyFile string, contents []byte, signature []byte) error {
+	publicKey, err := readSigningKey(keyFile)
+	if err != nil {
+		return err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err == nil {
+		signature = decoded
+	}
+	digest := sha256.Sum256(contents)
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], signature) {
+			return errors.New("signature doesn't match")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("signature doesn't match")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, contents, signature) {
+			return errors.New("signature doesn't match")
+		}
+	default:
+		return fmt.Errorf("unsupported signing key type %T", publicKey)
+	}
+	return nil
+}
+
+// readSigningKey parses a PEM-encoded PKIX public key
+func readSigningKey(path string) (crypto.PublicKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key: %w", err)
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("signing key %s isn't a PEM public key", path)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key %s: %w", path, err)
+	}
+	return publicKey, nil
+}
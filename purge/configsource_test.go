@@ -0,0 +1,134 @@
+package purge
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSigningKey(t *testing.T, publicKey any) string {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pub")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigSource(t *testing.T) {
+	contents := []byte("PURGE_DAYS=90\n")
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(contents)
+	ecSignature, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKeyFile := writeSigningKey(t, &ecKey.PublicKey)
+	edKeyFile := writeSigningKey(t, edPublic)
+
+	testCases := map[string]struct {
+		objects     map[string][]byte
+		signingKey  string
+		expectedErr string
+	}{
+		"unsigned without a key": {
+			objects: map[string][]byte{"/config/purge.env": contents},
+		},
+		"cosign signature": {
+			objects: map[string][]byte{
+				"/config/purge.env":     contents,
+				"/config/purge.env.sig": []byte(base64.StdEncoding.EncodeToString(ecSignature) + "\n"),
+			},
+			signingKey: ecKeyFile,
+		},
+		"raw ed25519 signature": {
+			objects: map[string][]byte{
+				"/config/purge.env":     contents,
+				"/config/purge.env.sig": ed25519.Sign(edKey, contents),
+			},
+			signingKey: edKeyFile,
+		},
+		"tampered contents": {
+			objects: map[string][]byte{
+				"/config/purge.env":     []byte("PURGE_DAYS=1\n"),
+				"/config/purge.env.sig": ecSignature,
+			},
+			signingKey:  ecKeyFile,
+			expectedErr: "error verifying signature of s3://config/purge.env: signature doesn't match",
+		},
+		"signed by another key": {
+			objects: map[string][]byte{
+				"/config/purge.env":     contents,
+				"/config/purge.env.sig": ecSignature,
+			},
+			signingKey:  edKeyFile,
+			expectedErr: "error verifying signature of s3://config/purge.env: signature doesn't match",
+		},
+		"missing signature": {
+			objects:     map[string][]byte{"/config/purge.env": contents},
+			signingKey:  ecKeyFile,
+			expectedErr: "error reading signature of s3://config/purge.env: error reading s3://config/purge.env.sig: 404 Not Found: missing",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				object, ok := test.objects[r.URL.Path]
+				if !ok {
+					http.Error(w, "missing", http.StatusNotFound)
+					return
+				}
+				w.Write(object)
+			}))
+			defer server.Close()
+
+			source := configSource{S3Options: S3Options{S3Endpoint: server.URL}, ConfigSigningKey: test.signingKey}
+			got, err := readConfigSource(context.Background(), source, "s3://config/purge.env")
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %s", test.expectedErr, err)
+			}
+			if test.expectedErr == "" && string(got) != string(test.objects["/config/purge.env"]) {
+				t.Errorf("expected contents %q, got %q", test.objects["/config/purge.env"], got)
+			}
+		})
+	}
+}
+
+func TestReadConfigSourceLocal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "purge.env")
+	if err := os.WriteFile(path, []byte("PURGE_DAYS=90\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// local files are trusted like the rest of the deployment
+	got, err := readConfigSource(context.Background(), configSource{ConfigSigningKey: "/nonexistent.pub"}, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(got) != "PURGE_DAYS=90\n" {
+		t.Errorf("unexpected contents %q", got)
+	}
+	if _, err := readConfigSource(context.Background(), configSource{}, "s3://bucket-only"); err == nil {
+		t.Error("expected an error for an S3 URL without a key")
+	}
+}
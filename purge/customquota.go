@@ -0,0 +1,121 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// spaceQuotaGUID returns the GUID of the quota assigned to a space, or ""
+// when the space uses the org default
+func spaceQuotaGUID(space *resource.Space) string {
+	if space.Relationships == nil {
+		return ""
+	}
+	return relationshipGUID(space.Relationships.Quota)
+}
+
+// findCustomQuotaSpaces returns the spaces an org would warn or purge, or
+// whose service instances it would purge, that are assigned a quota other
+// than the sandbox quota; such a quota usually means a special arrangement
+// that a person should review. The sandbox quota is only looked up when a
+// candidate space has a quota assigned
+func findCustomQuotaSpaces(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	org *resource.Organization,
+	evaluation orgEvaluation,
+	report *Report,
+) ([]*resource.Space, error) {
+	var candidates []*resource.Space
+	seen := map[string]bool{}
+	add := func(space *resource.Space) {
+		if !seen[space.GUID] && spaceQuotaGUID(space) != "" {
+			seen[space.GUID] = true
+			candidates = append(candidates, space)
+		}
+	}
+	for _, details := range evaluation.toNotify {
+		add(details.Space)
+	}
+	for _, details := range evaluation.toPurge {
+		add(details.Space)
+	}
+	for _, aged := range evaluation.agedInstances {
+		add(aged.Space)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	quotaListOptions := client.NewSpaceQuotaListOptions()
+	quotaListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	quotaListOptions.Names.EqualTo(opts.SandboxQuotaName)
+	sandboxQuota, warning, err := lookupSpaceQuota(ctx, cfClient, org, quotaListOptions, opts.SandboxQuotaName)
+	if warning != "" {
+		report.recordQuotaDrift(warning)
+	}
+	if err != nil && !errors.Is(err, client.ErrNoResultsReturned) {
+		return nil, fmt.Errorf("error finding quota %s in org %s: %w", opts.SandboxQuotaName, org.Name, err)
+	}
+
+	var custom []*resource.Space
+	for _, space := range candidates {
+		if sandboxQuota == nil || spaceQuotaGUID(space) != sandboxQuota.GUID {
+			custom = append(custom, space)
+		}
+	}
+	return custom, nil
+}
+
+// withoutSpaces drops the given spaces from an evaluation's purge warnings,
+// purges, aged service instance purges, and cap violations, and marks them in its
+// inventory as left for review
+func (e orgEvaluation) withoutSpaces(spaces []*resource.Space) orgEvaluation {
+	if len(spaces) == 0 {
+		return e
+	}
+	excluded := map[string]bool{}
+	for _, space := range spaces {
+		excluded[space.GUID] = true
+	}
+	dropDetails := func(details []SpaceDetails) []SpaceDetails {
+		var kept []SpaceDetails
+		for _, d := range details {
+			if !excluded[d.Space.GUID] {
+				kept = append(kept, d)
+			}
+		}
+		return kept
+	}
+	e.toNotify = dropDetails(e.toNotify)
+	e.toPurge = dropDetails(e.toPurge)
+	e.toDeleteEmpty = dropDetails(e.toDeleteEmpty)
+	var agedInstances []spaceInstances
+	for _, aged := range e.agedInstances {
+		if !excluded[aged.Space.GUID] {
+			agedInstances = append(agedInstances, aged)
+		}
+	}
+	e.agedInstances = agedInstances
+	var overCaps []spaceOverCaps
+	for _, over := range e.overCaps {
+		if !excluded[over.Space.GUID] {
+			overCaps = append(overCaps, over)
+		}
+	}
+	e.overCaps = overCaps
+	inventory := make([]InventoryRecord, len(e.inventory))
+	for i, record := range e.inventory {
+		if excluded[record.SpaceGUID] {
+			record.Decision = inventoryDecisionCustomQuota
+		}
+		inventory[i] = record
+	}
+	e.inventory = inventory
+	return e
+}
@@ -0,0 +1,104 @@
+package purge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func spaceWithQuota(guid string, quotaGUID string) *resource.Space {
+	space := &resource.Space{GUID: guid, Name: guid, Relationships: &resource.SpaceRelationships{}}
+	if quotaGUID != "" {
+		space.Relationships.Quota = &resource.ToOneRelationship{Data: &resource.Relationship{GUID: quotaGUID}}
+	}
+	return space
+}
+
+func TestFindCustomQuotaSpaces(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-org"}
+	opts := Config{SandboxQuotaName: "sandbox"}
+	evaluation := orgEvaluation{
+		toNotify: []SpaceDetails{
+			{Space: spaceWithQuota("sandbox-quota", "sandbox-guid")},
+			{Space: spaceWithQuota("org-default", "")},
+		},
+		toPurge: []SpaceDetails{{Space: spaceWithQuota("custom-quota", "custom-guid")}},
+		agedInstances: []spaceInstances{
+			{Space: spaceWithQuota("custom-instances", "custom-guid")},
+		},
+	}
+	testCases := map[string]struct {
+		evaluation  orgEvaluation
+		spaceQuotas *mockSpaceQuotas
+		expected    []string
+	}{
+		"custom quotas": {
+			evaluation: evaluation,
+			spaceQuotas: &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "sandbox",
+				quota:          &resource.SpaceQuota{GUID: "sandbox-guid"},
+			},
+			expected: []string{"custom-quota", "custom-instances"},
+		},
+		"no sandbox quota in org": {
+			evaluation: evaluation,
+			spaceQuotas: &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "sandbox",
+				singleErr:      client.ErrExactlyOneResultNotReturned,
+			},
+			expected: []string{"sandbox-quota", "custom-quota", "custom-instances"},
+		},
+		"no quotas assigned": {
+			evaluation: orgEvaluation{
+				toPurge: []SpaceDetails{{Space: spaceWithQuota("org-default", "")}},
+			},
+			// the sandbox quota isn't looked up
+			spaceQuotas: &mockSpaceQuotas{orgGUID: "unexpected"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			custom, err := findCustomQuotaSpaces(context.Background(), &cfResourceClient{SpaceQuotas: test.spaceQuotas}, opts, org, test.evaluation, &Report{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var got []string
+			for _, space := range custom {
+				got = append(got, space.GUID)
+			}
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("findCustomQuotaSpaces() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithoutSpaces(t *testing.T) {
+	custom := spaceWithQuota("custom", "custom-guid")
+	other := spaceWithQuota("other", "")
+	evaluation := orgEvaluation{
+		toNotify:      []SpaceDetails{{Space: custom}, {Space: other}},
+		toPurge:       []SpaceDetails{{Space: custom}},
+		agedInstances: []spaceInstances{{Space: custom}},
+		inventory: []InventoryRecord{
+			{SpaceGUID: "custom", Decision: planActionPurge},
+			{SpaceGUID: "other", Decision: planActionNotify},
+		},
+	}
+	got := evaluation.withoutSpaces([]*resource.Space{custom})
+	if len(got.toNotify) != 1 || got.toNotify[0].Space.GUID != "other" || len(got.toPurge) != 0 || len(got.agedInstances) != 0 {
+		t.Errorf("expected only space other to remain, got %+v", got)
+	}
+	decisions := []string{got.inventory[0].Decision, got.inventory[1].Decision}
+	if diff := cmp.Diff([]string{inventoryDecisionCustomQuota, planActionNotify}, decisions); diff != "" {
+		t.Errorf("inventory decisions mismatch (-want +got):\n%s", diff)
+	}
+	if evaluation.inventory[0].Decision != planActionPurge {
+		t.Error("expected the original evaluation to be unchanged")
+	}
+}
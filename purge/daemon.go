@@ -0,0 +1,272 @@
+package purge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-envconfig"
+)
+
+// DaemonConfig describes configuration for running purges on a schedule
+type DaemonConfig struct {
+	// ConfigFile holds KEY=VALUE settings that override the environment, or
+	// is an s3://bucket/key URL of such a file; it is reread before every
+	// cycle so changes apply without a restart
+	ConfigFile     string        `env:"CONFIG_FILE"`
+	DaemonInterval time.Duration `env:"DAEMON_INTERVAL, default=24h"`
+	// DaemonListenAddress serves /healthz and /status when it is set
+	DaemonListenAddress string `env:"DAEMON_LISTEN_ADDRESS"`
+	// DaemonStatusFile is rewritten with the /status JSON whenever the
+	// scheduler's state changes
+	DaemonStatusFile string `env:"DAEMON_STATUS_FILE"`
+}
+
+// secretSettings are substrings of setting names whose values are never
+// logged
+var secretSettings = []string{"SECRET", "PASS", "TOKEN", "KEY"}
+
+// LoadConfig reads the purge configuration from the environment, overridden
+// by the settings in configFile if it is set
+func LoadConfig(ctx context.Context, configFile string) (Config, error) {
+	var cfg Config
+	lookuper := envconfig.OsLookuper()
+	if configFile != "" {
+		// the file can't name its own signing key or S3 credentials
+		var source configSource
+		if err := envconfig.ProcessWith(ctx, &envconfig.Config{Target: &source, Lookuper: lookuper}); err != nil {
+			return Config{}, fmt.Errorf("error parsing options: %w", err)
+		}
+		settings, err := readConfigFile(ctx, source, configFile)
+		if err != nil {
+			return Config{}, err
+		}
+		lookuper = envconfig.MultiLookuper(envconfig.MapLookuper(settings), lookuper)
+	}
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{Target: &cfg, Lookuper: lookuper}); err != nil {
+		return Config{}, fmt.Errorf("error parsing options: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("error parsing options: %w", err)
+	}
+	if err := cfg.usePolicyFile(ctx); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// readConfigFile parses a file of KEY=VALUE lines; blank lines and lines
+// starting with # are ignored, and values may be double-quoted
+func readConfigFile(ctx context.Context, source configSource, path string) (map[string]string, error) {
+	contents, err := readConfigSource(ctx, source, path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	settings := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		settings[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	return settings, nil
+}
+
+// configSettings flattens a config into its settings keyed by env name,
+// with secret values masked
+func configSettings(cfg Config) map[string]string {
+	settings := map[string]string{}
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i))
+				continue
+			}
+			tag := field.Tag.Get("env")
+			if tag == "" {
+				continue
+			}
+			name := strings.TrimSpace(strings.Split(tag, ",")[0])
+			value := fmt.Sprint(v.Field(i).Interface())
+			for _, secret := range secretSettings {
+				if strings.Contains(name, secret) && value != "" {
+					// a short digest still shows when a secret changes
+					sum := sha256.Sum256([]byte(value))
+					value = fmt.Sprintf("<redacted %x>", sum[:4])
+					break
+				}
+			}
+			settings[name] = value
+		}
+	}
+	walk(reflect.ValueOf(cfg))
+	return settings
+}
+
+// templateDigests returns a digest of each email template in a directory
+func templateDigests(dir string) map[string]string {
+	digests := map[string]string{}
+	for _, name := range mailTemplateNames(dir) {
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		digests[name] = fmt.Sprintf("%x", sha256.Sum256(contents))
+	}
+	return digests
+}
+
+// daemonSnapshot is the effective configuration of a daemon cycle
+type daemonSnapshot struct {
+	settings  map[string]string
+	templates map[string]string
+	policy    string
+}
+
+func newDaemonSnapshot(cfg Config) daemonSnapshot {
+	snapshot := daemonSnapshot{
+		settings:  configSettings(cfg),
+		templates: templateDigests(cfg.TemplateDir),
+	}
+	snapshot.policy = cfg.policyDigest
+	return snapshot
+}
+
+// diff describes how the settings and templates in next differ from s
+func (s daemonSnapshot) diff(next daemonSnapshot) []string {
+	var changes []string
+	for _, name := range sortedUnion(s.settings, next.settings) {
+		before, after := s.settings[name], next.settings[name]
+		if before != after {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", name, before, after))
+		}
+	}
+	for _, name := range sortedUnion(s.templates, next.templates) {
+		before, beforeOK := s.templates[name]
+		after, afterOK := next.templates[name]
+		switch {
+		case !beforeOK:
+			changes = append(changes, fmt.Sprintf("template %s added", name))
+		case !afterOK:
+			changes = append(changes, fmt.Sprintf("template %s removed", name))
+		case before != after:
+			changes = append(changes, fmt.Sprintf("template %s changed", name))
+		}
+	}
+	if s.policy != next.policy {
+		changes = append(changes, "policy file changed")
+	}
+	return changes
+}
+
+func sortedUnion(a, b map[string]string) []string {
+	keys := []string{}
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// daemon runs purges on a schedule, reloading its configuration before
+// each cycle
+type daemon struct {
+	opts     DaemonConfig
+	cfg      Config
+	snapshot daemonSnapshot
+	status   *daemonStatus
+	// run is Run, replaced in tests
+	run func(context.Context, Config) (Report, error)
+}
+
+// reload rereads the configuration and templates, logging what changed
+// since the last cycle; an invalid configuration is logged and the last
+// good one is kept
+func (d *daemon) reload(ctx context.Context) {
+	cfg, err := LoadConfig(ctx, d.opts.ConfigFile)
+	if err != nil {
+		logFields{Err: err}.printf("keeping previous configuration: %s", err)
+		return
+	}
+	snapshot := newDaemonSnapshot(cfg)
+	if changes := d.snapshot.diff(snapshot); len(changes) > 0 {
+		logFields{}.printf("configuration changed:\n  %s", strings.Join(changes, "\n  "))
+	}
+	d.cfg, d.snapshot = cfg, snapshot
+}
+
+// Daemon runs a purge every DaemonInterval until ctx is canceled, applying
+// changes to CONFIG_FILE and the email templates on the next cycle
+func Daemon(ctx context.Context, opts DaemonConfig) error {
+	if opts.DaemonInterval <= 0 {
+		return fmt.Errorf("DAEMON_INTERVAL must be positive")
+	}
+	cfg, err := LoadConfig(ctx, opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	d := &daemon{
+		opts:     opts,
+		cfg:      cfg,
+		snapshot: newDaemonSnapshot(cfg),
+		status:   newDaemonStatus(opts, time.Now()),
+		run:      Run,
+	}
+	if opts.DaemonListenAddress != "" {
+		if err := serveDaemonStatus(ctx, opts.DaemonListenAddress, d.status); err != nil {
+			return err
+		}
+	}
+	logFields{}.printf("running purges every %s", opts.DaemonInterval)
+	d.loop(ctx)
+	return nil
+}
+
+// loop runs a purge every DaemonInterval until ctx is canceled, recording
+// each run in the daemon's status
+func (d *daemon) loop(ctx context.Context) {
+	defer d.status.stop()
+	for {
+		d.status.startRun(time.Now())
+		report, err := d.run(ctx, d.cfg)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logFields{Err: err}.printf("purge cycle failed: %s", err)
+		}
+		d.status.finishRun(report, err, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.opts.DaemonInterval):
+		}
+		d.reload(ctx)
+	}
+}
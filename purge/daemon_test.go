@@ -0,0 +1,132 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testConfigFile = `# sandbox purge
+API_ADDRESS=https://api.example.gov
+CLIENT_ID=purge
+CLIENT_SECRET="secret"
+ORG_PREFIX=sandbox-
+MAIL_SENDER=no-reply@example.gov
+NOTIFY_MAIL_SUBJECT=Your sandbox will be purged
+PURGE_MAIL_SUBJECT=Your sandbox has been purged
+SANDBOX_QUOTA_NAME=sandbox
+`
+
+func TestLoadConfig(t *testing.T) {
+	testCases := map[string]struct {
+		contents    string
+		env         map[string]string
+		expected    Config
+		expectedErr string
+	}{
+		"file overrides environment": {
+			contents: testConfigFile + "NOTIFY_DAYS = 20\n",
+			env:      map[string]string{"NOTIFY_DAYS": "10", "PURGE_DAYS": "40"},
+			expected: Config{NotifyDays: 20, PurgeDays: 40},
+		},
+		"invalid line": {
+			contents:    testConfigFile + "NOTIFY_DAYS\n",
+			expectedErr: "config.env:10: expected KEY=VALUE",
+		},
+		"invalid config": {
+			contents:    testConfigFile + "MAX_RUNTIME=1h\n",
+			expectedErr: "error parsing options: STATE_FILE is required for MAX_RUNTIME",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+			path := filepath.Join(t.TempDir(), "config.env")
+			if err := os.WriteFile(path, []byte(test.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(context.Background(), path)
+			if test.expectedErr != "" {
+				test.expectedErr = strings.Replace(test.expectedErr, "config.env", path, 1)
+			}
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.ClientSecret != "secret" || cfg.OrgPrefix != "sandbox-" {
+				t.Errorf("expected settings from config file, got %+v", cfg)
+			}
+			got := Config{NotifyDays: cfg.NotifyDays, PurgeDays: cfg.PurgeDays}
+			if diff := cmp.Diff(test.expected, got, cmp.AllowUnexported(Config{})); diff != "" {
+				t.Errorf("LoadConfig() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDaemonReload(t *testing.T) {
+	dir := t.TempDir()
+	templateDir := filepath.Join(dir, "templates")
+	if err := os.Mkdir(templateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(templateDir, notifyTemplateName), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.env")
+	initial := testConfigFile + "TEMPLATE_DIR=" + templateDir + "\n"
+	if err := os.WriteFile(path, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	d := &daemon{opts: DaemonConfig{ConfigFile: path}, cfg: cfg, snapshot: newDaemonSnapshot(cfg)}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	changed := strings.Replace(initial, `"secret"`, "rotated", 1) + "PURGE_DAYS=60\n"
+	if err := os.WriteFile(path, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(templateDir, notifyTemplateName), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.reload(context.Background())
+	if d.cfg.PurgeDays != 60 {
+		t.Errorf("expected PURGE_DAYS to be reloaded, got %d", d.cfg.PurgeDays)
+	}
+	for _, expected := range []string{`PURGE_DAYS: "30" -> "60"`, "CLIENT_SECRET: \"<redacted", "template notify.tmpl changed"} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("expected log to contain %q, got:\n%s", expected, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "rotated") {
+		t.Errorf("expected secret to be redacted, got:\n%s", logs.String())
+	}
+
+	logs.Reset()
+	if err := os.WriteFile(path, []byte(changed+"MAX_RUNTIME=1h\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d.reload(context.Background())
+	if d.cfg.PurgeDays != 60 || d.cfg.MaxRuntime != 0 {
+		t.Errorf("expected previous configuration to be kept, got %+v", d.cfg)
+	}
+	if !strings.Contains(logs.String(), "keeping previous configuration") {
+		t.Errorf("expected invalid configuration to be logged, got:\n%s", logs.String())
+	}
+}
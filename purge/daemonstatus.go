@@ -0,0 +1,216 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Daemon scheduler states
+const (
+	daemonStateRunning = "running"
+	daemonStateWaiting = "waiting"
+	daemonStateStopped = "stopped"
+)
+
+// Outcomes of a daemon cycle's run
+const (
+	runOutcomeSucceeded      = "succeeded"
+	runOutcomePartialFailure = "partial-failure"
+	runOutcomeFailed         = "failed"
+)
+
+// DaemonStatus describes the daemon's scheduler, as served from /status and
+// written to DAEMON_STATUS_FILE
+type DaemonStatus struct {
+	State     string    `json:"state"`
+	Healthy   bool      `json:"healthy"`
+	Problem   string    `json:"problem,omitempty"`
+	Interval  string    `json:"interval"`
+	StartedAt time.Time `json:"started_at"`
+	Cycles    int       `json:"cycles"`
+	// RunStartedAt is when the run in progress started
+	RunStartedAt *time.Time       `json:"run_started_at,omitempty"`
+	LastRun      *DaemonRunStatus `json:"last_run,omitempty"`
+	NextRunAt    *time.Time       `json:"next_run_at,omitempty"`
+}
+
+// DaemonRunStatus describes the outcome of the daemon's last run
+type DaemonRunStatus struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Outcome    string    `json:"outcome"`
+	Summary    string    `json:"summary,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// daemonStatus tracks the daemon's scheduler for its health endpoints and
+// status file
+type daemonStatus struct {
+	mu       sync.Mutex
+	status   DaemonStatus
+	interval time.Duration
+	path     string
+}
+
+func newDaemonStatus(opts DaemonConfig, now time.Time) *daemonStatus {
+	return &daemonStatus{
+		status: DaemonStatus{
+			State:     daemonStateWaiting,
+			Interval:  opts.DaemonInterval.String(),
+			StartedAt: now,
+		},
+		interval: opts.DaemonInterval,
+		path:     opts.DaemonStatusFile,
+	}
+}
+
+// startRun records that a cycle's run has started
+func (s *daemonStatus) startRun(now time.Time) {
+	s.update(func(status *DaemonStatus) {
+		status.State = daemonStateRunning
+		status.RunStartedAt = &now
+		status.NextRunAt = nil
+	})
+}
+
+// finishRun records a run's outcome and when the next one is due
+func (s *daemonStatus) finishRun(report Report, runErr error, now time.Time) {
+	s.update(func(status *DaemonStatus) {
+		run := &DaemonRunStatus{FinishedAt: now, Outcome: runOutcomeSucceeded, Summary: report.summary()}
+		if status.RunStartedAt != nil {
+			run.StartedAt = *status.RunStartedAt
+		}
+		switch {
+		case runErr != nil:
+			run.Outcome = runOutcomeFailed
+			run.Error = runErr.Error()
+		case len(report.Errors) > 0:
+			run.Outcome = runOutcomePartialFailure
+			run.Error = report.ErrorSummary()
+		}
+		next := now.Add(s.interval)
+		status.State = daemonStateWaiting
+		status.Cycles++
+		status.RunStartedAt = nil
+		status.LastRun = run
+		status.NextRunAt = &next
+	})
+}
+
+// stop records that the scheduler has stopped
+func (s *daemonStatus) stop() {
+	s.update(func(status *DaemonStatus) {
+		status.State = daemonStateStopped
+		status.NextRunAt = nil
+	})
+}
+
+// update changes the status and rewrites the status file
+func (s *daemonStatus) update(change func(*DaemonStatus)) {
+	s.mu.Lock()
+	change(&s.status)
+	s.mu.Unlock()
+	if s.path == "" {
+		return
+	}
+	if err := s.writeFile(time.Now()); err != nil {
+		logFields{Err: err}.printf("error writing daemon status: %s", err)
+	}
+}
+
+// snapshot returns the status as of now; the scheduler is unhealthy once it
+// has stopped, or once a run or the wait for one has lasted an interval
+// longer than it should
+func (s *daemonStatus) snapshot(now time.Time) DaemonStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	switch {
+	case status.State == daemonStateStopped:
+		status.Problem = "scheduler stopped"
+	case status.RunStartedAt != nil && now.Sub(*status.RunStartedAt) > s.interval:
+		status.Problem = fmt.Sprintf("run in progress for %s", now.Sub(*status.RunStartedAt).Round(time.Second))
+	case status.NextRunAt != nil && now.Sub(*status.NextRunAt) > s.interval:
+		status.Problem = fmt.Sprintf("run overdue by %s", now.Sub(*status.NextRunAt).Round(time.Second))
+	}
+	status.Healthy = status.Problem == ""
+	return status
+}
+
+// writeFile writes the status as JSON next to the status file and renames it
+// into place, so readers never see a partial file
+func (s *daemonStatus) writeFile(now time.Time) error {
+	contents, err := json.MarshalIndent(s.snapshot(now), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := os.WriteFile(tmp, append(contents, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing status file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("error replacing status file %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// ServeHTTP serves GET /healthz, which fails while the scheduler is
+// unhealthy, and GET /status, which describes it as JSON
+func (s *daemonStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/healthz" && r.URL.Path != "/status" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := s.snapshot(time.Now())
+	if r.URL.Path == "/healthz" {
+		if !status.Healthy {
+			http.Error(w, status.Problem, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logFields{Err: err}.printf("error writing daemon status: %s", err)
+	}
+}
+
+// serveDaemonStatus serves the daemon's health endpoints on address until
+// ctx is canceled; it fails if it can't listen there
+func serveDaemonStatus(ctx context.Context, address string, status *daemonStatus) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("error listening for health checks on %s: %w", address, err)
+	}
+	server := &http.Server{
+		Handler:           status,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logFields{Err: err}.printf("error serving health checks: %s", err)
+		}
+	}()
+	logFields{}.printf("serving health checks on %s", listener.Addr())
+	return nil
+}
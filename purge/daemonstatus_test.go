@@ -0,0 +1,233 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDaemonStatusSnapshot(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		update          func(s *daemonStatus)
+		now             time.Time
+		expectedState   string
+		expectedProblem string
+	}{
+		"starting": {
+			update:        func(s *daemonStatus) {},
+			now:           start,
+			expectedState: daemonStateWaiting,
+		},
+		"running": {
+			update:        func(s *daemonStatus) { s.startRun(start) },
+			now:           start.Add(time.Hour),
+			expectedState: daemonStateRunning,
+		},
+		"run stuck": {
+			update:          func(s *daemonStatus) { s.startRun(start) },
+			now:             start.Add(25 * time.Hour),
+			expectedState:   daemonStateRunning,
+			expectedProblem: "run in progress for 25h0m0s",
+		},
+		"waiting": {
+			update: func(s *daemonStatus) {
+				s.startRun(start)
+				s.finishRun(Report{}, nil, start.Add(time.Hour))
+			},
+			now:           start.Add(30 * time.Hour),
+			expectedState: daemonStateWaiting,
+		},
+		"overdue": {
+			update: func(s *daemonStatus) {
+				s.startRun(start)
+				s.finishRun(Report{}, nil, start.Add(time.Hour))
+			},
+			now:             start.Add(50 * time.Hour),
+			expectedState:   daemonStateWaiting,
+			expectedProblem: "run overdue by 25h0m0s",
+		},
+		"stopped": {
+			update:          func(s *daemonStatus) { s.stop() },
+			now:             start,
+			expectedState:   daemonStateStopped,
+			expectedProblem: "scheduler stopped",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			status := newDaemonStatus(DaemonConfig{DaemonInterval: 24 * time.Hour}, start)
+			test.update(status)
+			got := status.snapshot(test.now)
+			if got.State != test.expectedState {
+				t.Errorf("expected state %q, got %q", test.expectedState, got.State)
+			}
+			if got.Problem != test.expectedProblem || got.Healthy != (test.expectedProblem == "") {
+				t.Errorf("expected problem %q, got %q (healthy %t)", test.expectedProblem, got.Problem, got.Healthy)
+			}
+		})
+	}
+}
+
+func TestDaemonStatusFinishRun(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		report          Report
+		err             error
+		expectedOutcome string
+	}{
+		"succeeded": {
+			report:          Report{SpacesPurged: 2},
+			expectedOutcome: runOutcomeSucceeded,
+		},
+		"partial failure": {
+			report:          Report{SpacesPurged: 1, Errors: []string{"error deleting space space-1"}},
+			expectedOutcome: runOutcomePartialFailure,
+		},
+		"failed": {
+			err:             errors.New("error getting orgs"),
+			expectedOutcome: runOutcomeFailed,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			status := newDaemonStatus(DaemonConfig{DaemonInterval: 24 * time.Hour}, start)
+			status.startRun(start)
+			status.finishRun(test.report, test.err, start.Add(time.Hour))
+			got := status.snapshot(start.Add(time.Hour))
+
+			expectedError := ""
+			if test.err != nil {
+				expectedError = test.err.Error()
+			} else if len(test.report.Errors) > 0 {
+				expectedError = test.report.ErrorSummary()
+			}
+			next := start.Add(25 * time.Hour)
+			expected := &DaemonRunStatus{
+				StartedAt:  start,
+				FinishedAt: start.Add(time.Hour),
+				Outcome:    test.expectedOutcome,
+				Summary:    test.report.summary(),
+				Error:      expectedError,
+			}
+			if diff := cmp.Diff(expected, got.LastRun); diff != "" {
+				t.Errorf("LastRun mismatch (-want +got):\n%s", diff)
+			}
+			if got.Cycles != 1 || got.NextRunAt == nil || !got.NextRunAt.Equal(next) {
+				t.Errorf("expected 1 cycle with the next run at %s, got %d at %v", next, got.Cycles, got.NextRunAt)
+			}
+		})
+	}
+}
+
+func TestDaemonStatusServeHTTP(t *testing.T) {
+	testCases := map[string]struct {
+		method       string
+		path         string
+		stopped      bool
+		expectedCode int
+		expectedBody string
+	}{
+		"healthy": {
+			method:       http.MethodGet,
+			path:         "/healthz",
+			expectedCode: http.StatusOK,
+			expectedBody: "ok\n",
+		},
+		"unhealthy": {
+			method:       http.MethodGet,
+			path:         "/healthz",
+			stopped:      true,
+			expectedCode: http.StatusServiceUnavailable,
+			expectedBody: "scheduler stopped\n",
+		},
+		"status": {
+			method:       http.MethodGet,
+			path:         "/status",
+			expectedCode: http.StatusOK,
+		},
+		"wrong method": {
+			method:       http.MethodPost,
+			path:         "/healthz",
+			expectedCode: http.StatusMethodNotAllowed,
+			expectedBody: "method not allowed\n",
+		},
+		"unknown path": {
+			method:       http.MethodGet,
+			path:         "/metrics",
+			expectedCode: http.StatusNotFound,
+			expectedBody: "404 page not found\n",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			status := newDaemonStatus(DaemonConfig{DaemonInterval: 24 * time.Hour}, time.Now())
+			if test.stopped {
+				status.stop()
+			}
+			recorder := httptest.NewRecorder()
+			status.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+			if recorder.Code != test.expectedCode {
+				t.Errorf("expected status %d, got %d", test.expectedCode, recorder.Code)
+			}
+			if test.expectedBody != "" && recorder.Body.String() != test.expectedBody {
+				t.Errorf("expected body %q, got %q", test.expectedBody, recorder.Body.String())
+			}
+			if test.path == "/status" {
+				var got DaemonStatus
+				if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+					t.Fatalf("error parsing status: %s", err)
+				}
+				if got.State != daemonStateWaiting || !got.Healthy || got.Interval != "24h0m0s" {
+					t.Errorf("unexpected status %+v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestDaemonLoopStatusFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := DaemonConfig{DaemonInterval: time.Hour, DaemonStatusFile: path}
+	d := &daemon{
+		opts:   opts,
+		status: newDaemonStatus(opts, time.Now()),
+		run: func(ctx context.Context, cfg Config) (Report, error) {
+			// the file shows the run in progress
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				t.Errorf("error reading status file during run: %s", err)
+			} else if !strings.Contains(string(contents), `"state": "running"`) {
+				t.Errorf("expected a running status during the run, got %s", contents)
+			}
+			cancel()
+			return Report{SpacesPurged: 1}, nil
+		},
+	}
+	d.loop(ctx)
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading status file: %s", err)
+	}
+	var got DaemonStatus
+	if err := json.Unmarshal(contents, &got); err != nil {
+		t.Fatalf("error parsing status file: %s", err)
+	}
+	if got.State != daemonStateStopped || got.Healthy || got.Cycles != 1 || got.LastRun == nil || got.LastRun.Outcome != runOutcomeSucceeded {
+		t.Errorf("unexpected status %s", contents)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), ".status.json.tmp")); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary status file to be renamed away, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package purge
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// dashboardOrgURL links to an org in the dashboard at base, or is empty
+// without a dashboard URL
+func dashboardOrgURL(base string, orgGUID string) string {
+	if base == "" || orgGUID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/orgs/%s", strings.TrimSuffix(base, "/"), url.PathEscape(orgGUID))
+}
+
+// dashboardSpaceURL links to a space in the dashboard at base, or is empty
+// without a dashboard URL
+func dashboardSpaceURL(base string, orgGUID string, spaceGUID string) string {
+	orgURL := dashboardOrgURL(base, orgGUID)
+	if orgURL == "" || spaceGUID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/spaces/%s", orgURL, url.PathEscape(spaceGUID))
+}
+
+// validateDashboardURL checks that DASHBOARD_URL is an absolute URL links
+// can be built from
+func validateDashboardURL(base string) error {
+	if base == "" {
+		return nil
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("invalid DASHBOARD_URL %s: %w", base, err)
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return fmt.Errorf("invalid DASHBOARD_URL %s; expected an absolute URL like https://dashboard.example.gov", base)
+	}
+	return nil
+}
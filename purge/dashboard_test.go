@@ -0,0 +1,63 @@
+package purge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+func TestDashboardURLs(t *testing.T) {
+	if got := dashboardSpaceURL("https://dashboard.example.gov/", "org-1", "space-1"); got != "https://dashboard.example.gov/orgs/org-1/spaces/space-1" {
+		t.Errorf("unexpected space URL %q", got)
+	}
+	if got := dashboardOrgURL("https://dashboard.example.gov", "org-1"); got != "https://dashboard.example.gov/orgs/org-1" {
+		t.Errorf("unexpected org URL %q", got)
+	}
+	if got := dashboardSpaceURL("", "org-1", "space-1"); got != "" {
+		t.Errorf("expected no URL without a dashboard, got %q", got)
+	}
+}
+
+func TestValidateDashboardURL(t *testing.T) {
+	for base, valid := range map[string]bool{
+		"":                              true,
+		"https://dashboard.example.gov": true,
+		"dashboard.example.gov":         false,
+	} {
+		if err := validateDashboardURL(base); (err == nil) != valid {
+			t.Errorf("validateDashboardURL(%q) returned %v", base, err)
+		}
+	}
+}
+
+func TestReportLinks(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-bar"}
+	report := &Report{DashboardURL: "https://dashboard.example.gov"}
+	report.recordAction(PlannedAction{
+		Action:  planActionNotify,
+		Org:     org,
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+	}, nil)
+	report.recordAction(PlannedAction{
+		Action:  planActionPurge,
+		Org:     org,
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-2", Name: "baz"}},
+	}, nil)
+
+	if got := report.Spaces[0].URL; got != "https://dashboard.example.gov/orgs/org-1/spaces/space-1" {
+		t.Errorf("expected a link to the warned space, got %q", got)
+	}
+	// the purged space is recreated with a new GUID
+	if got := report.Spaces[1].URL; got != "https://dashboard.example.gov/orgs/org-1" {
+		t.Errorf("expected a link to the purged space's org, got %q", got)
+	}
+
+	var b strings.Builder
+	if err := WriteReport(&b, *report, reportFormatMarkdown); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(b.String(), "| sandbox-bar | [foo](https://dashboard.example.gov/orgs/org-1/spaces/space-1) |") {
+		t.Errorf("expected a linked space in the report, got:\n%s", b.String())
+	}
+}
@@ -0,0 +1,58 @@
+package purge
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// LOG_LEVEL values
+const (
+	logLevelInfo = "info"
+	// logLevelDebug also logs every CF API request
+	logLevelDebug = "debug"
+)
+
+func validLogLevel(level string) bool {
+	switch level {
+	case "", logLevelInfo, logLevelDebug:
+		return true
+	}
+	return false
+}
+
+// correlationHeaders are response headers that identify a request in the CF
+// API's own logs, most specific first
+var correlationHeaders = []string{"X-Vcap-Request-Id", "X-B3-Traceid"}
+
+// debugTransport logs the method, path, status, duration, and correlation ID
+// of each request it sends; query strings, headers, and bodies are left out,
+// since they can carry tokens and other secrets
+type debugTransport struct {
+	base http.RoundTripper
+	now  func() time.Time
+	logf func(format string, v ...any)
+}
+
+func newDebugTransport(base http.RoundTripper) *debugTransport {
+	return &debugTransport{base: base, now: time.Now, logf: logFields{}.printf}
+}
+
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := t.now()
+	resp, err := t.base.RoundTrip(req)
+	duration := t.now().Sub(started).Round(time.Millisecond)
+	if err != nil {
+		t.logf("debug: CF API %s %s failed after %s: %s", req.Method, req.URL.Path, duration, err)
+		return resp, err
+	}
+	status := fmt.Sprint(resp.StatusCode)
+	for _, header := range correlationHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			status += " " + header + "=" + id
+			break
+		}
+	}
+	t.logf("debug: CF API %s %s %s in %s", req.Method, req.URL.Path, status, duration)
+	return resp, nil
+}
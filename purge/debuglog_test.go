@@ -0,0 +1,75 @@
+package purge
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type stubTransport struct {
+	resp *http.Response
+	err  error
+}
+
+func (t *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.resp, t.err
+}
+
+func TestDebugTransport(t *testing.T) {
+	testCases := map[string]struct {
+		resp     *http.Response
+		err      error
+		expected string
+	}{
+		"request ID": {
+			resp: &http.Response{StatusCode: 200, Header: http.Header{
+				"X-Vcap-Request-Id": {"vcap-id"},
+				"X-B3-Traceid":      {"trace-id"},
+			}},
+			expected: "debug: CF API GET /v3/spaces 200 X-Vcap-Request-Id=vcap-id in 85ms",
+		},
+		"trace ID": {
+			resp:     &http.Response{StatusCode: 404, Header: http.Header{"X-B3-Traceid": {"trace-id"}}},
+			expected: "debug: CF API GET /v3/spaces 404 X-B3-Traceid=trace-id in 85ms",
+		},
+		"no correlation ID": {
+			resp:     &http.Response{StatusCode: 500, Header: http.Header{}},
+			expected: "debug: CF API GET /v3/spaces 500 in 85ms",
+		},
+		"failed": {
+			err:      errors.New("connection refused"),
+			expected: "debug: CF API GET /v3/spaces failed after 85ms: connection refused",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			times := []time.Time{start, start.Add(85 * time.Millisecond)}
+			var logged []string
+			transport := &debugTransport{
+				base: &stubTransport{resp: test.resp, err: test.err},
+				now: func() time.Time {
+					now := times[0]
+					times = times[1:]
+					return now
+				},
+				logf: func(format string, v ...any) {
+					logged = append(logged, fmt.Sprintf(format, v...))
+				},
+			}
+			req, err := http.NewRequest(http.MethodGet, "https://api.example.gov/v3/spaces?names=secret", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "bearer token")
+			transport.RoundTrip(req)
+			if diff := cmp.Diff([]string{test.expected}, logged); diff != "" {
+				t.Errorf("RoundTrip() log mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// purgingServiceInstanceClient adds purging to go-cfclient's service
+// instance client, which doesn't support the purge parameter
+type purgingServiceInstanceClient struct {
+	*client.ServiceInstanceClient
+	apiAddress string
+	auth       AuthClient
+	httpClient *http.Client
+}
+
+// Purge removes a service instance and its bindings and keys from CF without
+// asking its broker to deprovision it, like cf purge-service-instance
+func (c *purgingServiceInstanceClient) Purge(ctx context.Context, guid string) error {
+	token, err := c.auth.AccessToken(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting token: %w", err)
+	}
+	endpoint := fmt.Sprintf("%s/v3/service_instances/%s?purge=true", strings.TrimSuffix(c.apiAddress, "/"), url.PathEscape(guid))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return client.CloudFoundryHTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+	}
+	return nil
+}
+
+// purgeDeleteFailedInstances purges the given service instances, whose
+// deletes failed, so their space can be deleted; their brokers may still
+// hold the resources they provisioned
+func purgeDeleteFailedInstances(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+	instances []*resource.ServiceInstance,
+) (spaceCleanup, error) {
+	var cleanup spaceCleanup
+	for _, instance := range instances {
+		logFields{Space: space.Name, Action: planActionPurge}.printf("purging service instance %s in space %s; its delete failed: %s", instance.Name, space.Name, instance.LastOperation.Description)
+		err := cfClient.ServiceInstances.Purge(ctx, instance.GUID)
+		if isNotFoundError(err) {
+			continue
+		}
+		if err != nil {
+			return cleanup, fmt.Errorf("error purging service instance %s in space %s: %w", instance.Name, space.Name, err)
+		}
+		cleanup.InstancesForcePurged++
+	}
+	return cleanup, nil
+}
@@ -0,0 +1,101 @@
+package purge
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPurgingServiceInstanceClientPurge(t *testing.T) {
+	testCases := map[string]struct {
+		status           int
+		expectedNotFound bool
+	}{
+		"purged":    {status: http.StatusNoContent},
+		"not found": {status: http.StatusNotFound, expectedNotFound: true},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete || r.URL.Path != "/v3/service_instances/db-guid" || r.URL.Query().Get("purge") != "true" {
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+				}
+				if r.Header.Get("Authorization") != "Bearer token" {
+					t.Errorf("unexpected authorization header: %s", r.Header.Get("Authorization"))
+				}
+				w.WriteHeader(test.status)
+			}))
+			defer server.Close()
+
+			c := &purgingServiceInstanceClient{apiAddress: server.URL + "/", auth: &mockAuth{}, httpClient: server.Client()}
+			err := c.Purge(context.Background(), "db-guid")
+			if test.expectedNotFound {
+				if !isNotFoundError(err) {
+					t.Errorf("expected a not found error, got: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestAwaitSpaceDeletionDeleteFailedInstances(t *testing.T) {
+	space := &resource.Space{GUID: "space-guid", Name: "foo"}
+	testCases := map[string]struct {
+		purgeDeleteFailed bool
+		expectedPolled    []string
+		expectedPurged    []string
+		expectedCleanup   spaceCleanup
+		expectedErr       string
+	}{
+		"purges delete failed instances": {
+			purgeDeleteFailed: true,
+			expectedPolled:    []string{"job-1", "job-2"},
+			expectedPurged:    []string{"db-guid"},
+			expectedCleanup:   spaceCleanup{InstancesForcePurged: 1},
+		},
+		"leaves them without the option": {
+			expectedPolled: []string{"job-1"},
+			expectedErr:    "delete job job-1 for space foo failed: received state FAILED while waiting for async process",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			jobs := &sequenceJobs{errs: []error{client.AsyncProcessFailedError}}
+			instances := &mockServiceInstances{instances: []*resource.ServiceInstance{{
+				GUID: "db-guid",
+				Name: "db",
+				LastOperation: resource.LastOperation{
+					Type:        lastOperationDelete,
+					State:       lastOperationFailed,
+					Description: "broker unavailable",
+				},
+			}}}
+			cfClient := &cfResourceClient{
+				Jobs:             jobs,
+				ServiceInstances: instances,
+				Spaces:           &mockSpaces{deleteJobGUID: "job-2"},
+			}
+			opts := Config{PurgeDeleteFailedInstances: test.purgeDeleteFailed}
+			cleanup, err := awaitSpaceDeletion(context.Background(), cfClient, opts, space, "job-1")
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedCleanup, cleanup); diff != "" {
+				t.Errorf("cleanup mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedPolled, jobs.polled); diff != "" {
+				t.Errorf("polled jobs mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedPurged, instances.purgedGUIDs); diff != "" {
+				t.Errorf("purged instances mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
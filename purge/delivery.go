@@ -0,0 +1,187 @@
+package purge
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Delivery statuses of a message to a single recipient
+const (
+	// deliveryQueued messages were planned but never attempted, because an
+	// earlier failure stopped the run
+	deliveryQueued = "queued"
+	deliverySent   = "sent"
+	deliveryFailed = "failed"
+	// deliverySuppressed messages were withheld by a dry run or redirected
+	// to MAIL_OVERRIDE_RECIPIENT
+	deliverySuppressed = "suppressed"
+	// deliveryDeduped recipients were listed more than once for a message
+	// and only sent it once
+	deliveryDeduped = "deduped"
+	// deliveryHeld messages were held for the next run because CF calls
+	// were failing
+	deliveryHeld = "held"
+)
+
+// MessageResult is the delivery outcome of one notification to one
+// recipient, tied to the action that sent it
+type MessageResult struct {
+	Org             string    `json:"org"`
+	Space           string    `json:"space,omitempty"`
+	SpaceGUID       string    `json:"space_guid,omitempty"`
+	ServiceInstance string    `json:"service_instance,omitempty"`
+	Action          string    `json:"action"`
+	Recipient       string    `json:"recipient"`
+	Subject         string    `json:"subject,omitempty"`
+	Status          string    `json:"status"`
+	At              time.Time `json:"at"`
+	Note            string    `json:"note,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// newMessageResult describes a message sent for action to recipient
+func newMessageResult(action PlannedAction, recipient string, status string) MessageResult {
+	result := MessageResult{
+		Org:       action.Org.Name,
+		Action:    action.Action,
+		Recipient: recipient,
+		Subject:   action.Subject,
+		Status:    status,
+		At:        time.Now(),
+	}
+	if action.Details.Space != nil {
+		result.Space = action.Details.Space.Name
+		result.SpaceGUID = action.Details.Space.GUID
+	}
+	if action.ServiceInstance != nil {
+		result.ServiceInstance = action.ServiceInstance.Name
+	}
+	return result
+}
+
+// queuedMessages describes the messages of an action that was never started
+func queuedMessages(action PlannedAction) []MessageResult {
+	var messages []MessageResult
+	for _, recipient := range action.Recipients {
+		messages = append(messages, newMessageResult(action, recipient, deliveryQueued))
+	}
+	return messages
+}
+
+// deliveryRecorder is a mailer that records the outcome of every message
+// sent for a single action; it drops recipients repeated in a message, so
+// nobody gets the same email twice
+type deliveryRecorder struct {
+	mailer            mailer
+	action            PlannedAction
+	overrideRecipient string
+	attempted         bool
+	messages          []MessageResult
+	// fallbacks describes the templates that failed for the action's space,
+	// so its messages were sent as the plaintext fallback
+	fallbacks []string
+}
+
+func recordDeliveries(m mailer, opts Config, action PlannedAction) *deliveryRecorder {
+	return &deliveryRecorder{
+		mailer:            m,
+		action:            action,
+		overrideRecipient: opts.MailOverrideRecipient,
+	}
+}
+
+// sendMail sends the message to each distinct recipient and records how it went
+func (r *deliveryRecorder) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	r.attempted = true
+	seen := map[string]bool{}
+	var distinct []string
+	for _, recipient := range recipients {
+		address := strings.ToLower(recipient)
+		if seen[address] {
+			r.messages = append(r.messages, newMessageResult(r.action, recipient, deliveryDeduped))
+			continue
+		}
+		seen[address] = true
+		distinct = append(distinct, recipient)
+	}
+
+	err := r.mailer.sendMail(ctx, opts, sender, subject, body, thread, distinct, attachments...)
+	for _, recipient := range distinct {
+		result := newMessageResult(r.action, recipient, deliverySent)
+		result.Subject = subject
+		switch {
+		case err != nil:
+			result.Status = deliveryFailed
+			result.Error = err.Error()
+		case r.overrideRecipient != "":
+			result.Status = deliverySuppressed
+			result.Note = "redirected to " + r.overrideRecipient
+		}
+		r.messages = append(r.messages, result)
+	}
+	return err
+}
+
+// recordFallback notes a template that failed for the action's space
+func (r *deliveryRecorder) recordFallback(templateName string, err error) {
+	where := r.action.Org.Name
+	if r.action.Details.Space != nil {
+		where += "/" + r.action.Details.Space.Name
+	}
+	r.fallbacks = append(r.fallbacks, fmt.Sprintf("%s: %s: %s", where, templateName, err))
+}
+
+// results returns the recorded messages; when the action sent nothing, its
+// recipients are listed as suppressed in a dry run, or as failed if the
+// action failed before it could send for any reason but its target being
+// deleted
+func (r *deliveryRecorder) results(dryRun bool, actionErr error) []MessageResult {
+	if r.attempted {
+		return r.messages
+	}
+	var messages []MessageResult
+	for _, recipient := range r.action.Recipients {
+		result := newMessageResult(r.action, recipient, deliverySuppressed)
+		switch {
+		case dryRun:
+			result.Note = "dry run"
+		case actionErr != nil && !errors.Is(actionErr, errDeletedDuringRun):
+			result.Status = deliveryFailed
+			result.Error = actionErr.Error()
+		default:
+			continue
+		}
+		messages = append(messages, result)
+	}
+	return messages
+}
+
+// writeMessagesCSV writes one row per message in a report
+func writeMessagesCSV(w io.Writer, messages []MessageResult) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"org", "space", "service_instance", "action", "recipient", "subject", "status", "at", "note", "error"}); err != nil {
+		return err
+	}
+	for _, m := range messages {
+		row := []string{m.Org, m.Space, m.ServiceInstance, m.Action, m.Recipient, m.Subject, m.Status, m.At.UTC().Format(time.RFC3339), m.Note, m.Error}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,130 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDeliveryRecorder(t *testing.T) {
+	action := PlannedAction{
+		Action:     planActionNotify,
+		Org:        &resource.Organization{Name: "sandbox-gsa"},
+		Details:    SpaceDetails{Space: &resource.Space{GUID: "space-guid", Name: "user"}},
+		Recipients: []string{"a@gsa.gov", "A@gsa.gov", "b@gsa.gov"},
+		Subject:    "Your sandbox will be purged",
+	}
+	type outcome struct {
+		Recipient string
+		Status    string
+		Note      string
+		Error     string
+	}
+	testCases := map[string]struct {
+		opts      Config
+		mailErr   error
+		send      bool
+		actionErr error
+		expected  []outcome
+		sentTo    []string
+	}{
+		"sent": {
+			send: true,
+			expected: []outcome{
+				{Recipient: "A@gsa.gov", Status: deliveryDeduped},
+				{Recipient: "a@gsa.gov", Status: deliverySent},
+				{Recipient: "b@gsa.gov", Status: deliverySent},
+			},
+			sentTo: []string{"a@gsa.gov", "b@gsa.gov"},
+		},
+		"send failed": {
+			mailErr:   errors.New("connection refused"),
+			send:      true,
+			actionErr: errors.New("error sending mail"),
+			expected: []outcome{
+				{Recipient: "A@gsa.gov", Status: deliveryDeduped},
+				{Recipient: "a@gsa.gov", Status: deliveryFailed, Error: "connection refused"},
+				{Recipient: "b@gsa.gov", Status: deliveryFailed, Error: "connection refused"},
+			},
+			sentTo: []string{"a@gsa.gov", "b@gsa.gov"},
+		},
+		"override recipient": {
+			opts: Config{MailOptions: MailOptions{MailOverrideRecipient: "staging@example.gov"}},
+			send: true,
+			expected: []outcome{
+				{Recipient: "A@gsa.gov", Status: deliveryDeduped},
+				{Recipient: "a@gsa.gov", Status: deliverySuppressed, Note: "redirected to staging@example.gov"},
+				{Recipient: "b@gsa.gov", Status: deliverySuppressed, Note: "redirected to staging@example.gov"},
+			},
+			sentTo: []string{"a@gsa.gov", "b@gsa.gov"},
+		},
+		"dry run": {
+			opts: Config{DryRun: true},
+			expected: []outcome{
+				{Recipient: "a@gsa.gov", Status: deliverySuppressed, Note: "dry run"},
+				{Recipient: "A@gsa.gov", Status: deliverySuppressed, Note: "dry run"},
+				{Recipient: "b@gsa.gov", Status: deliverySuppressed, Note: "dry run"},
+			},
+		},
+		"failed before sending": {
+			actionErr: errors.New("error reading notify template"),
+			expected: []outcome{
+				{Recipient: "a@gsa.gov", Status: deliveryFailed, Error: "error reading notify template"},
+				{Recipient: "A@gsa.gov", Status: deliveryFailed, Error: "error reading notify template"},
+				{Recipient: "b@gsa.gov", Status: deliveryFailed, Error: "error reading notify template"},
+			},
+		},
+		"deleted during run": {
+			actionErr: deletedDuringRun("space user"),
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mailSender := &recordingMailer{err: test.mailErr}
+			deliveries := recordDeliveries(mailSender, test.opts, action)
+			if test.send {
+				_ = deliveries.sendMail(context.Background(), SMTPOptions{}, "no-reply@example.gov", action.Subject, "body", mailThread{}, action.Recipients)
+			}
+			var got []outcome
+			for _, m := range deliveries.results(test.opts.DryRun, test.actionErr) {
+				if m.Org != "sandbox-gsa" || m.Space != "user" || m.SpaceGUID != "space-guid" || m.Subject != action.Subject {
+					t.Errorf("message not tied to its action: %+v", m)
+				}
+				got = append(got, outcome{m.Recipient, m.Status, m.Note, m.Error})
+			}
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("results() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.sentTo, mailSender.recipients); diff != "" {
+				t.Errorf("sendMail() recipients mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteReportCSV(t *testing.T) {
+	action := PlannedAction{
+		Action:     planActionPurge,
+		Org:        &resource.Organization{Name: "sandbox-gsa"},
+		Details:    SpaceDetails{Space: &resource.Space{Name: "user"}},
+		Recipients: []string{"a@gsa.gov"},
+		Subject:    "Purged, with a comma",
+	}
+	report := Report{Messages: queuedMessages(action)}
+	report.Messages[0].At = report.Messages[0].At.Truncate(0)
+	at := report.Messages[0].At.UTC().Format("2006-01-02T15:04:05Z07:00")
+
+	var b strings.Builder
+	if err := WriteReport(&b, report, reportFormatCSV); err != nil {
+		t.Fatal(err)
+	}
+	expected := "org,space,service_instance,action,recipient,subject,status,at,note,error\n" +
+		"sandbox-gsa,user,,purge,a@gsa.gov,\"Purged, with a comma\",queued," + at + ",,\n"
+	if diff := cmp.Diff(expected, b.String()); diff != "" {
+		t.Errorf("WriteReport() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,204 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// Last operation states of a service instance
+const (
+	lastOperationDelete     = "delete"
+	lastOperationInProgress = "in progress"
+	lastOperationFailed     = "failed"
+)
+
+// deprovisionPendingError reports that a space couldn't be deleted because
+// its service instances are still deprovisioning; the space is retried on
+// the next run rather than counted as a failure
+type deprovisionPendingError struct {
+	space     string
+	instances []string
+}
+
+func (e *deprovisionPendingError) Error() string {
+	return fmt.Sprintf("space %s is waiting for service instances to deprovision: %s", e.space, strings.Join(e.instances, ", "))
+}
+
+// listDeprovisioningInstances returns the names of a space's service
+// instances whose delete is still in progress, and the instances whose
+// delete failed
+func listDeprovisioningInstances(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+) (names []string, failed []*resource.ServiceInstance, err error) {
+	serviceListOptions := client.NewServiceInstanceListOptions()
+	serviceListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	instances, err := cfClient.ServiceInstances.ListAll(ctx, serviceListOptions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing service instances in space %s: %w", space.Name, err)
+	}
+	for _, instance := range instances {
+		if instance.LastOperation.Type != lastOperationDelete {
+			continue
+		}
+		switch instance.LastOperation.State {
+		case lastOperationInProgress:
+			names = append(names, instance.Name)
+		case lastOperationFailed:
+			failed = append(failed, instance)
+		}
+	}
+	return names, failed, nil
+}
+
+// waitForDeprovision polls the last operations of a space's service
+// instances until none is still being deleted, for up to DEPROVISION_TIMEOUT
+func waitForDeprovision(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	space *resource.Space,
+) error {
+	pollingOptions := deprovisionPollingOptions(opts)
+	if opts.DeprovisionPollInterval > 0 {
+		pollingOptions.CheckInterval = opts.DeprovisionPollInterval
+	}
+	return client.PollForStateOrTimeout(func() (string, error) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		deprovisioning, failed, err := listDeprovisioningInstances(ctx, cfClient, space)
+		switch {
+		case err != nil:
+			return "", err
+		case len(failed) > 0:
+			return pollingOptions.FailedState, nil
+		case len(deprovisioning) > 0:
+			return lastOperationInProgress, nil
+		}
+		return "COMPLETE", nil
+	}, "COMPLETE", pollingOptions)
+}
+
+// awaitSpaceDeletion waits for a space's delete job. If the job fails while
+// service instances in the space are still deprovisioning, it waits for them
+// and deletes the space again, and if they take longer than
+// DEPROVISION_TIMEOUT, it returns a *deprovisionPendingError. Otherwise the
+// job's errors decide how the resources blocking the delete are cleaned up
+// before deleting the space again, unless PURGE_DELETE_FAILED_INSTANCES is
+// set and instances whose deletes failed can be purged instead; a
+// *spaceDeleteJobError describes a failure that can't be cleaned up
+func awaitSpaceDeletion(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	space *resource.Space,
+	deleteJobGUID string,
+) (spaceCleanup, error) {
+	jobErr := waitForSpaceDeletion(ctx, cfClient, deleteJobGUID)
+	if jobErr == nil {
+		return spaceCleanup{}, nil
+	}
+	failure := describeDeleteJobFailure(ctx, cfClient, space, deleteJobGUID, jobErr)
+	deprovisioning, deleteFailed, err := listDeprovisioningInstances(ctx, cfClient, space)
+	if err != nil {
+		return spaceCleanup{}, failure
+	}
+
+	var cleanup spaceCleanup
+	if len(deprovisioning) > 0 {
+		logFields{Space: space.Name, Action: planActionPurge}.printf("waiting up to %s for service instances %v in space %s to deprovision", opts.DeprovisionTimeout, deprovisioning, space.Name)
+		if err := waitForDeprovision(ctx, cfClient, opts, space); err != nil {
+			if errors.Is(err, client.AsyncProcessTimeoutError) {
+				return spaceCleanup{}, &deprovisionPendingError{space: space.Name, instances: deprovisioning}
+			}
+			return spaceCleanup{}, fmt.Errorf("error waiting for service instances in space %s to deprovision: %w", space.Name, err)
+		}
+		logFields{Space: space.Name, Action: planActionPurge}.printf("service instances in space %s deprovisioned; deleting it again", space.Name)
+	} else if len(deleteFailed) > 0 && opts.PurgeDeleteFailedInstances {
+		logFields{Space: space.Name, Action: planActionPurge, Err: failure}.printf("%s", failure)
+		cleanup, err = purgeDeleteFailedInstances(ctx, cfClient, space, deleteFailed)
+		if err != nil {
+			return cleanup, fmt.Errorf("%s; %w", failure, err)
+		}
+		logFields{Space: space.Name, Action: planActionPurge}.printf("purged service instances whose deletes failed in space %s; deleting it again", space.Name)
+	} else {
+		logFields{Space: space.Name, Action: planActionPurge, Err: failure}.printf("%s", failure)
+		cleanup, err = cleanupDeleteBlockers(ctx, cfClient, opts, space, failure)
+		if err != nil {
+			return cleanup, err
+		}
+		logFields{Space: space.Name, Action: planActionPurge}.printf("cleaned up resources blocking the delete of space %s; deleting it again", space.Name)
+	}
+
+	deleteJobGUID, err = cfClient.Spaces.Delete(ctx, space.GUID)
+	if isNotFoundError(err) {
+		return cleanup, nil
+	}
+	if err != nil {
+		return cleanup, fmt.Errorf("error deleting space %s again: %w", space.Name, err)
+	}
+	if err := waitForSpaceDeletion(ctx, cfClient, deleteJobGUID); err != nil {
+		return cleanup, describeDeleteJobFailure(ctx, cfClient, space, deleteJobGUID, err)
+	}
+	return cleanup, nil
+}
+
+// waitForDeprovisionJob polls a service instance's asynchronous delete job
+// for up to DEPROVISION_TIMEOUT, since brokered databases and search
+// clusters can take much longer to deprovision than other jobs take
+func waitForDeprovisionJob(ctx context.Context, cfClient *cfResourceClient, opts Config, jobGUID string) error {
+	if jobGUID == "" {
+		return nil
+	}
+	return cfClient.Jobs.PollComplete(ctx, jobGUID, deprovisionPollingOptions(opts))
+}
+
+// deprovisionPollingOptions waits for DEPROVISION_TIMEOUT, or the client's
+// default timeout if it isn't set
+func deprovisionPollingOptions(opts Config) *client.PollingOptions {
+	pollingOptions := client.NewPollingOptions()
+	if opts.DeprovisionTimeout > 0 {
+		pollingOptions.Timeout = opts.DeprovisionTimeout
+	}
+	return pollingOptions
+}
+
+// pendingDeprovisionSince returns when a space first couldn't be purged
+// because its service instances were still deprovisioning, or nil
+func (s *State) pendingDeprovisionSince(spaceGUID string) *time.Time {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if space, ok := s.Spaces[spaceGUID]; ok {
+		return space.PendingDeprovisionSince
+	}
+	return nil
+}
+
+// recordPendingDeprovision remembers that a space's purge is waiting for its
+// service instances to deprovision, keeping when it started waiting
+func (s *State) recordPendingDeprovision(action PlannedAction, at time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	space, ok := s.Spaces[action.Details.Space.GUID]
+	if !ok {
+		space = &SpaceState{Org: action.Org.Name, Space: action.Details.Space.Name}
+		s.Spaces[action.Details.Space.GUID] = space
+	}
+	if space.PendingDeprovisionSince == nil {
+		space.PendingDeprovisionSince = &at
+	}
+}
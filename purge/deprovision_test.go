@@ -0,0 +1,140 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+// sequenceJobs returns each poll error in turn, then succeeds
+type sequenceJobs struct {
+	errs   []error
+	polled []string
+	job    *resource.Job
+}
+
+func (j *sequenceJobs) Get(ctx context.Context, guid string) (*resource.Job, error) {
+	return j.job, nil
+}
+
+func (j *sequenceJobs) PollComplete(ctx context.Context, jobGUID string, opts *client.PollingOptions) error {
+	j.polled = append(j.polled, jobGUID)
+	if len(j.errs) == 0 {
+		return nil
+	}
+	err := j.errs[0]
+	j.errs = j.errs[1:]
+	return err
+}
+
+// deprovisioningInstances lists a database whose delete stays in progress
+// for the given number of listings, then is gone
+type deprovisioningInstances struct {
+	mockServiceInstances
+	listings int
+}
+
+func (s *deprovisioningInstances) ListAll(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, error) {
+	if s.listings <= 0 {
+		return nil, nil
+	}
+	s.listings--
+	return []*resource.ServiceInstance{{
+		Name:          "db",
+		LastOperation: resource.LastOperation{Type: lastOperationDelete, State: lastOperationInProgress},
+	}}, nil
+}
+
+func TestAwaitSpaceDeletion(t *testing.T) {
+	space := &resource.Space{GUID: "space-guid", Name: "foo"}
+	jobErr := errors.New("job failed")
+	opts := Config{DeprovisionTimeout: 50 * time.Millisecond, DeprovisionPollInterval: time.Millisecond}
+	testCases := map[string]struct {
+		jobErrs        []error
+		listings       int
+		expectedPolled []string
+		expectedErr    string
+		expectPending  bool
+	}{
+		"deleted": {
+			expectedPolled: []string{"job-1"},
+		},
+		"job failed without deprovisioning instances": {
+			jobErrs:        []error{jobErr},
+			expectedPolled: []string{"job-1"},
+			expectedErr:    "delete job job-1 for space foo failed: job failed",
+		},
+		"deprovisioned within the timeout": {
+			jobErrs:        []error{jobErr},
+			listings:       3,
+			expectedPolled: []string{"job-1", "job-2"},
+		},
+		"still deprovisioning": {
+			jobErrs:        []error{jobErr},
+			listings:       1000000,
+			expectedPolled: []string{"job-1"},
+			expectedErr:    "space foo is waiting for service instances to deprovision: db",
+			expectPending:  true,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			jobs := &sequenceJobs{errs: test.jobErrs}
+			cfClient := &cfResourceClient{
+				Jobs:             jobs,
+				ServiceInstances: &deprovisioningInstances{listings: test.listings},
+				Spaces:           &mockSpaces{deleteJobGUID: "job-2"},
+			}
+			_, err := awaitSpaceDeletion(context.Background(), cfClient, opts, space, "job-1")
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %s", test.expectedErr, err)
+			}
+			var pending *deprovisionPendingError
+			if errors.As(err, &pending) != test.expectPending {
+				t.Errorf("expected pending deprovision: %t, got: %s", test.expectPending, err)
+			}
+			if diff := cmp.Diff(test.expectedPolled, jobs.polled); diff != "" {
+				t.Errorf("polled jobs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyPlanPendingDeprovision(t *testing.T) {
+	since := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	action := PlannedAction{
+		Action:                  planActionPurge,
+		Org:                     &resource.Organization{Name: "sandbox-foo"},
+		Details:                 SpaceDetails{Space: &resource.Space{GUID: "space-guid", Name: "foo"}},
+		Recipients:              []string{"foo@bar.gov"},
+		PendingDeprovisionSince: &since,
+	}
+	cfClient := &cfResourceClient{ServiceInstances: &deprovisioningInstances{listings: 1}}
+	state := &State{Spaces: map[string]*SpaceState{}}
+	state.recordPendingDeprovision(action, since)
+	state.recordPendingDeprovision(action, since.AddDate(0, 0, 1))
+	mailSender := &recordingMailer{}
+	report := &Report{}
+
+	plan := &Plan{Actions: []PlannedAction{action}}
+	if err := applyPlan(context.Background(), cfClient, Config{TemplateDir: "../templates"}, plan, mailSender, state, report, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(report.Errors) > 0 {
+		t.Errorf("unexpected errors: %v", report.Errors)
+	}
+	if diff := cmp.Diff([]string{"sandbox-foo/foo"}, report.SpacesPendingDeprovision); diff != "" {
+		t.Errorf("SpacesPendingDeprovision mismatch (-want +got):\n%s", diff)
+	}
+	if len(mailSender.recipients) > 0 {
+		t.Errorf("expected no email on retry, sent to: %v", mailSender.recipients)
+	}
+	if got := state.pendingDeprovisionSince("space-guid"); got == nil || !got.Equal(since) {
+		t.Errorf("expected pending since %s, got: %v", since, got)
+	}
+}
@@ -0,0 +1,313 @@
+package purge
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+)
+
+const (
+	digestTemplateName   = "operator-digest.tmpl"
+	digestAttachmentName = "operator-digest"
+)
+
+// OperatorDigestOptions emails operators the spaces a run is about to purge,
+// before it purges them, with what changed in each since the previous run
+type OperatorDigestOptions struct {
+	OperatorDigestRecipients []string `env:"OPERATOR_DIGEST_RECIPIENTS"`
+	OperatorDigestSubject    string   `env:"OPERATOR_DIGEST_SUBJECT, default=Sandbox spaces to be purged"`
+	// OperatorDigestMaxSpaces is the most spaces listed in the digest's
+	// body; the rest are only in its attachment. Zero lists every space
+	OperatorDigestMaxSpaces int `env:"OPERATOR_DIGEST_MAX_SPACES, default=50"`
+	// OperatorDigestMaxBytes is the largest body the digest is sent with;
+	// a larger one is replaced by its summary, with every space attached
+	OperatorDigestMaxBytes int `env:"OPERATOR_DIGEST_MAX_BYTES, default=100000"`
+	// OperatorDigestAttachmentMaxBytes is the largest uncompressed CSV in a
+	// single attachment; larger tables are split across several
+	OperatorDigestAttachmentMaxBytes int `env:"OPERATOR_DIGEST_ATTACHMENT_MAX_BYTES, default=5000000"`
+}
+
+func (o OperatorDigestOptions) enabled() bool {
+	return len(o.OperatorDigestRecipients) > 0
+}
+
+func (o OperatorDigestOptions) validate(stateFile string) error {
+	if !o.enabled() {
+		return nil
+	}
+	if stateFile == "" {
+		return fmt.Errorf("STATE_FILE is required for OPERATOR_DIGEST_RECIPIENTS")
+	}
+	if o.OperatorDigestMaxSpaces < 0 || o.OperatorDigestMaxBytes < 0 || o.OperatorDigestAttachmentMaxBytes < 0 {
+		return fmt.Errorf("OPERATOR_DIGEST_MAX_SPACES, OPERATOR_DIGEST_MAX_BYTES, and OPERATOR_DIGEST_ATTACHMENT_MAX_BYTES can't be negative")
+	}
+	for _, recipient := range o.OperatorDigestRecipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return fmt.Errorf("invalid OPERATOR_DIGEST_RECIPIENTS address %s: %w", recipient, err)
+		}
+	}
+	return nil
+}
+
+// SpaceContents names the apps and service instances a run saw in a space
+type SpaceContents struct {
+	SpaceGUID        string    `json:"-"`
+	Space            string    `json:"-"`
+	Apps             []string  `json:"apps"`
+	ServiceInstances []string  `json:"service_instances"`
+	SeenAt           time.Time `json:"seen_at"`
+}
+
+// SpaceContentsDiff describes the apps and service instances added to or
+// removed from a space since an earlier run saw it
+type SpaceContentsDiff struct {
+	Since                   time.Time `json:"since"`
+	AppsAdded               []string  `json:"apps_added,omitempty"`
+	AppsRemoved             []string  `json:"apps_removed,omitempty"`
+	ServiceInstancesAdded   []string  `json:"service_instances_added,omitempty"`
+	ServiceInstancesRemoved []string  `json:"service_instances_removed,omitempty"`
+}
+
+// Changed reports whether anything was added or removed
+func (d *SpaceContentsDiff) Changed() bool {
+	return d != nil && len(d.AppsAdded)+len(d.AppsRemoved)+len(d.ServiceInstancesAdded)+len(d.ServiceInstancesRemoved) > 0
+}
+
+// listSpaceContents names the apps and service instances in the spaces to
+// notify or purge, so the next run can tell what changed before it purges
+// them
+func listSpaceContents(
+	inventory *collect.Inventory,
+	toNotify []SpaceDetails,
+	toPurge []SpaceDetails,
+	now time.Time,
+) []SpaceContents {
+	spaces := map[string]*collect.SpaceInventory{}
+	for _, space := range inventory.Spaces {
+		spaces[space.Space.GUID] = space
+	}
+	var contents []SpaceContents
+	for _, details := range append(append([]SpaceDetails{}, toNotify...), toPurge...) {
+		space := SpaceContents{
+			SpaceGUID:        details.Space.GUID,
+			Space:            details.Space.Name,
+			Apps:             []string{},
+			ServiceInstances: []string{},
+			SeenAt:           now,
+		}
+		if listed, ok := spaces[details.Space.GUID]; ok {
+			for _, app := range listed.Apps {
+				space.Apps = append(space.Apps, app.Name)
+			}
+			for _, instance := range listed.ServiceInstances {
+				space.ServiceInstances = append(space.ServiceInstances, instance.Name)
+			}
+		}
+		sort.Strings(space.Apps)
+		sort.Strings(space.ServiceInstances)
+		contents = append(contents, space)
+	}
+	return contents
+}
+
+// diffSpaceContents compares a space's contents with what an earlier run
+// saw; it returns nil if no earlier run saw the space
+func diffSpaceContents(previous *SpaceContents, current SpaceContents) *SpaceContentsDiff {
+	if previous == nil {
+		return nil
+	}
+	diff := &SpaceContentsDiff{Since: previous.SeenAt}
+	diff.AppsAdded, diff.AppsRemoved = diffNames(previous.Apps, current.Apps)
+	diff.ServiceInstancesAdded, diff.ServiceInstancesRemoved = diffNames(previous.ServiceInstances, current.ServiceInstances)
+	return diff
+}
+
+// diffNames returns the names only in current and the names only in previous
+func diffNames(previous []string, current []string) ([]string, []string) {
+	before := map[string]bool{}
+	for _, name := range previous {
+		before[name] = true
+	}
+	after := map[string]bool{}
+	for _, name := range current {
+		after[name] = true
+	}
+	var added, removed []string
+	for _, name := range current {
+		if !before[name] {
+			added = append(added, name)
+		}
+	}
+	for _, name := range previous {
+		if !after[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed
+}
+
+// digestData is the data passed to the operator digest template; "listed"
+// holds the purges the body lists, those that changed since the previous
+// run first, and "omitted" counts the rest
+func digestData(opts Config, plan *Plan) map[string]interface{} {
+	var purges []PlannedAction
+	changed := 0
+	// spaceURLs links each space by GUID, for the template to look up
+	spaceURLs := map[string]string{}
+	for _, action := range plan.Actions {
+		if action.Action != planActionPurge {
+			continue
+		}
+		purges = append(purges, action)
+		if action.ContentsDiff.Changed() {
+			changed++
+		}
+		if link := dashboardSpaceURL(opts.DashboardURL, action.Org.GUID, action.Details.Space.GUID); link != "" {
+			spaceURLs[action.Details.Space.GUID] = link
+		}
+	}
+	listed := append([]PlannedAction{}, purges...)
+	sort.SliceStable(listed, func(i, j int) bool {
+		return listed[i].ContentsDiff.Changed() && !listed[j].ContentsDiff.Changed()
+	})
+	if max := opts.OperatorDigestMaxSpaces; max > 0 && len(listed) > max {
+		listed = listed[:max]
+	}
+	return map[string]interface{}{
+		"purges":    purges,
+		"listed":    listed,
+		"omitted":   len(purges) - len(listed),
+		"changed":   changed,
+		"spaceURLs": spaceURLs,
+	}
+}
+
+// sendOperatorDigest emails OPERATOR_DIGEST_RECIPIENTS the plan's purges
+// and what changed in each space since the previous run; plans without
+// purges send nothing. Spaces left out of the body, to keep it under
+// OPERATOR_DIGEST_MAX_SPACES and OPERATOR_DIGEST_MAX_BYTES, are sent with
+// every other space as a compressed CSV attachment
+func sendOperatorDigest(ctx context.Context, opts Config, plan *Plan, mailSender mailer) error {
+	if !opts.OperatorDigestOptions.enabled() || opts.DryRun {
+		return nil
+	}
+	data := digestData(opts, plan)
+	purges := data["purges"].([]PlannedAction)
+	if len(purges) == 0 {
+		return nil
+	}
+	tmpl, err := parseMailTemplate(opts.TemplateDir, digestTemplateName)
+	if err != nil {
+		return fmt.Errorf("error reading operator digest template: %w", err)
+	}
+	body, err := renderTemplate(tmpl, data)
+	if err != nil {
+		return fmt.Errorf("error rendering operator digest: %w", err)
+	}
+	if max := opts.OperatorDigestMaxBytes; max > 0 && len(body) > max {
+		data["listed"] = []PlannedAction{}
+		data["omitted"] = len(purges)
+		body, err = renderTemplate(tmpl, data)
+		if err != nil {
+			return fmt.Errorf("error rendering operator digest: %w", err)
+		}
+	}
+	var attachments []mailAttachment
+	if data["omitted"].(int) > 0 {
+		attachments, err = digestAttachments(opts, purges)
+		if err != nil {
+			return fmt.Errorf("error attaching operator digest table: %w", err)
+		}
+	}
+	logFields{}.printf("sending operator digest to %s", opts.OperatorDigestRecipients)
+	if err := mailSender.sendMail(ctx, opts.SMTPOptions, opts.MailSender, opts.OperatorDigestSubject, body, mailThread{}, opts.OperatorDigestRecipients, attachments...); err != nil {
+		return fmt.Errorf("error sending operator digest: %w", err)
+	}
+	return nil
+}
+
+// digestRow is a purge's row in the digest's CSV attachment
+func digestRow(opts Config, action PlannedAction) []string {
+	var seenSince, appsAdded, appsRemoved, instancesAdded, instancesRemoved string
+	if diff := action.ContentsDiff; diff != nil {
+		seenSince = diff.Since.Format("2006-01-02")
+		appsAdded = strings.Join(diff.AppsAdded, " ")
+		appsRemoved = strings.Join(diff.AppsRemoved, " ")
+		instancesAdded = strings.Join(diff.ServiceInstancesAdded, " ")
+		instancesRemoved = strings.Join(diff.ServiceInstancesRemoved, " ")
+	}
+	return []string{
+		action.Org.Name,
+		action.Details.Space.Name,
+		action.Details.Space.GUID,
+		action.Details.Timestamp.Format("2006-01-02"),
+		fmt.Sprint(action.ContentsDiff.Changed()),
+		seenSince,
+		appsAdded,
+		appsRemoved,
+		instancesAdded,
+		instancesRemoved,
+		dashboardSpaceURL(opts.DashboardURL, action.Org.GUID, action.Details.Space.GUID),
+	}
+}
+
+// digestAttachments writes every purge to gzipped CSV attachments, split so
+// no attachment's uncompressed CSV is larger than
+// OPERATOR_DIGEST_ATTACHMENT_MAX_BYTES; each part repeats the header
+func digestAttachments(opts Config, purges []PlannedAction) ([]mailAttachment, error) {
+	header := []string{
+		"org", "space", "space_guid", "first_resource", "changed", "seen_since",
+		"apps_added", "apps_removed", "service_instances_added", "service_instances_removed", "url",
+	}
+	encode := func(record []string) []byte {
+		var b bytes.Buffer
+		writer := csv.NewWriter(&b)
+		writer.Write(record)
+		writer.Flush()
+		return b.Bytes()
+	}
+
+	var parts [][]byte
+	current := encode(header)
+	rows := 0
+	for _, action := range purges {
+		row := encode(digestRow(opts, action))
+		if max := opts.OperatorDigestAttachmentMaxBytes; max > 0 && rows > 0 && len(current)+len(row) > max {
+			parts = append(parts, current)
+			current, rows = encode(header), 0
+		}
+		current = append(current, row...)
+		rows++
+	}
+	parts = append(parts, current)
+
+	attachments := make([]mailAttachment, 0, len(parts))
+	for i, part := range parts {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(part); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		name := digestAttachmentName + ".csv.gz"
+		if len(parts) > 1 {
+			name = fmt.Sprintf("%s-%d.csv.gz", digestAttachmentName, i+1)
+		}
+		attachments = append(attachments, mailAttachment{
+			Name:        name,
+			ContentType: "application/gzip",
+			Content:     compressed.Bytes(),
+		})
+	}
+	return attachments, nil
+}
@@ -0,0 +1,249 @@
+package purge
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestListSpaceContents(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	spaces := []*resource.Space{{GUID: "space-1", Name: "foo"}, {GUID: "space-2", Name: "bar"}, {GUID: "space-3", Name: "young"}}
+	apps := []*resource.App{
+		appInSpace("app-b", "space-1", now),
+		appInSpace("app-a", "space-1", now),
+		appInSpace("app-c", "space-3", now),
+	}
+	apps[0].Name, apps[1].Name, apps[2].Name = "web", "api", "young-app"
+	db := instanceInSpace("instance-1", "space-2")
+	db.Name = "db"
+
+	contents := listSpaceContents(collect.New(&resource.Organization{}, collect.Resources{Spaces: spaces, Apps: apps, ServiceInstances: []*resource.ServiceInstance{db}}), []SpaceDetails{{Space: spaces[0]}}, []SpaceDetails{{Space: spaces[1]}}, now)
+	expected := []SpaceContents{
+		{SpaceGUID: "space-1", Space: "foo", Apps: []string{"api", "web"}, ServiceInstances: []string{}, SeenAt: now},
+		{SpaceGUID: "space-2", Space: "bar", Apps: []string{}, ServiceInstances: []string{"db"}, SeenAt: now},
+	}
+	if diff := cmp.Diff(expected, contents); diff != "" {
+		t.Errorf("listSpaceContents() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiffSpaceContents(t *testing.T) {
+	seen := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	previous := &SpaceContents{Apps: []string{"api", "web"}, ServiceInstances: []string{"db"}, SeenAt: seen}
+	testCases := map[string]struct {
+		previous        *SpaceContents
+		current         SpaceContents
+		expected        *SpaceContentsDiff
+		expectedChanged bool
+	}{
+		"not seen before": {
+			current: SpaceContents{Apps: []string{"api"}},
+		},
+		"unchanged": {
+			previous: previous,
+			current:  SpaceContents{Apps: []string{"api", "web"}, ServiceInstances: []string{"db"}},
+			expected: &SpaceContentsDiff{Since: seen},
+		},
+		"changed": {
+			previous: previous,
+			current:  SpaceContents{Apps: []string{"api", "worker"}, ServiceInstances: []string{"cache", "db"}},
+			expected: &SpaceContentsDiff{
+				Since:                 seen,
+				AppsAdded:             []string{"worker"},
+				AppsRemoved:           []string{"web"},
+				ServiceInstancesAdded: []string{"cache"},
+			},
+			expectedChanged: true,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			diff := diffSpaceContents(test.previous, test.current)
+			if d := cmp.Diff(test.expected, diff); d != "" {
+				t.Errorf("diffSpaceContents() mismatch (-want +got):\n%s", d)
+			}
+			if diff.Changed() != test.expectedChanged {
+				t.Errorf("expected changed: %t, got: %t", test.expectedChanged, diff.Changed())
+			}
+		})
+	}
+}
+
+func TestSendOperatorDigest(t *testing.T) {
+	org := &resource.Organization{Name: "sandbox-foo"}
+	since := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	plan := &Plan{Actions: []PlannedAction{
+		{Action: planActionNotify, Org: org, Details: SpaceDetails{Space: &resource.Space{Name: "warned"}}},
+		{
+			Action:       planActionPurge,
+			Org:          org,
+			Details:      SpaceDetails{Space: &resource.Space{Name: "busy"}},
+			ContentsDiff: &SpaceContentsDiff{Since: since, AppsAdded: []string{"worker"}},
+		},
+		{
+			Action:       planActionPurge,
+			Org:          org,
+			Details:      SpaceDetails{Space: &resource.Space{Name: "idle"}},
+			ContentsDiff: &SpaceContentsDiff{Since: since},
+		},
+	}}
+	testCases := map[string]struct {
+		opts               Config
+		plan               *Plan
+		expectedRecipients []string
+		expectedBody       []string
+	}{
+		"disabled": {
+			opts: Config{TemplateDir: "../templates"},
+			plan: plan,
+		},
+		"dry run": {
+			opts: Config{TemplateDir: "../templates", DryRun: true, OperatorDigestOptions: OperatorDigestOptions{OperatorDigestRecipients: []string{"ops@example.gov"}}},
+			plan: plan,
+		},
+		"no purges": {
+			opts: Config{TemplateDir: "../templates", OperatorDigestOptions: OperatorDigestOptions{OperatorDigestRecipients: []string{"ops@example.gov"}}},
+			plan: &Plan{Actions: plan.Actions[:1]},
+		},
+		"purges": {
+			opts:               Config{TemplateDir: "../templates", OperatorDigestOptions: OperatorDigestOptions{OperatorDigestRecipients: []string{"ops@example.gov"}}},
+			plan:               plan,
+			expectedRecipients: []string{"ops@example.gov"},
+			expectedBody: []string{
+				"about to purge 2 sandbox spaces. 1 of them changed since the previous run",
+				"sandbox-foo/busy",
+				"<li>app added: worker</li>",
+				"sandbox-foo/idle, first resource Jan 01, 0001:\n    unchanged since Feb 29, 2024",
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mailSender := &recordingMailer{}
+			if err := sendOperatorDigest(context.Background(), test.opts, test.plan, mailSender); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(test.expectedRecipients, mailSender.recipients); diff != "" {
+				t.Errorf("recipients mismatch (-want +got):\n%s", diff)
+			}
+			for _, expected := range test.expectedBody {
+				if len(mailSender.bodies) == 0 || !strings.Contains(mailSender.bodies[0], expected) {
+					t.Errorf("expected body to contain %q, got %v", expected, mailSender.bodies)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordContents(t *testing.T) {
+	org := &resource.Organization{Name: "sandbox-foo"}
+	state := &State{Spaces: map[string]*SpaceState{}}
+	seen := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	state.recordContents(org, []SpaceContents{{SpaceGUID: "space-1", Space: "foo", Apps: []string{"api"}, SeenAt: seen}})
+	state.recordNotified(PlannedAction{Org: org, Details: SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}}}, seen)
+
+	contents := state.spaceContents("space-1")
+	if contents == nil || !cmp.Equal(contents.Apps, []string{"api"}) {
+		t.Errorf("expected the recorded contents to survive the warning, got %+v", contents)
+	}
+	if state.spaceContents("space-2") != nil {
+		t.Errorf("expected no contents for an unseen space")
+	}
+}
+
+func TestSendOperatorDigestAttachment(t *testing.T) {
+	org := &resource.Organization{Name: "sandbox-foo"}
+	since := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	plan := &Plan{}
+	for _, name := range []string{"first", "second", "third"} {
+		plan.Actions = append(plan.Actions, PlannedAction{
+			Action:       planActionPurge,
+			Org:          org,
+			Details:      SpaceDetails{Space: &resource.Space{GUID: name + "-guid", Name: name}},
+			ContentsDiff: &SpaceContentsDiff{Since: since},
+		})
+	}
+	plan.Actions[2].ContentsDiff.AppsAdded = []string{"worker"}
+
+	readCSV := func(t *testing.T, attachment mailAttachment) [][]string {
+		reader, err := gzip.NewReader(bytes.NewReader(attachment.Content))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		records, err := csv.NewReader(reader).ReadAll()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return records
+	}
+
+	testCases := map[string]struct {
+		digest              OperatorDigestOptions
+		expectedBody        []string
+		expectedAttachments []string
+		expectedRows        int
+	}{
+		"every space listed": {
+			digest:       OperatorDigestOptions{OperatorDigestMaxSpaces: 3},
+			expectedBody: []string{"sandbox-foo/first", "sandbox-foo/third"},
+		},
+		"spaces over the limit": {
+			digest:              OperatorDigestOptions{OperatorDigestMaxSpaces: 1},
+			expectedBody:        []string{"sandbox-foo/third", "2 more spaces aren't listed here"},
+			expectedAttachments: []string{"operator-digest.csv.gz"},
+			expectedRows:        3,
+		},
+		"body over the limit": {
+			digest:              OperatorDigestOptions{OperatorDigestMaxBytes: 100},
+			expectedBody:        []string{"Too many spaces are being purged to list them here"},
+			expectedAttachments: []string{"operator-digest.csv.gz"},
+			expectedRows:        3,
+		},
+		"attachment split": {
+			digest:              OperatorDigestOptions{OperatorDigestMaxSpaces: 1, OperatorDigestAttachmentMaxBytes: 270},
+			expectedBody:        []string{"2 more spaces aren't listed here"},
+			expectedAttachments: []string{"operator-digest-1.csv.gz", "operator-digest-2.csv.gz"},
+			expectedRows:        3,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			test.digest.OperatorDigestRecipients = []string{"ops@example.gov"}
+			opts := Config{TemplateDir: "../templates", OperatorDigestOptions: test.digest}
+			mailSender := &recordingMailer{}
+			if err := sendOperatorDigest(context.Background(), opts, plan, mailSender); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, expected := range test.expectedBody {
+				if !strings.Contains(mailSender.bodies[0], expected) {
+					t.Errorf("expected body to contain %q, got:\n%s", expected, mailSender.bodies[0])
+				}
+			}
+			var names []string
+			rows := 0
+			for _, attachment := range mailSender.attachments {
+				names = append(names, attachment.Name)
+				records := readCSV(t, attachment)
+				if records[0][0] != "org" {
+					t.Errorf("expected a header in %s, got %v", attachment.Name, records[0])
+				}
+				rows += len(records) - 1
+			}
+			if diff := cmp.Diff(test.expectedAttachments, names); diff != "" {
+				t.Errorf("attachments mismatch (-want +got):\n%s", diff)
+			}
+			if rows != test.expectedRows {
+				t.Errorf("expected %d rows, got %d", test.expectedRows, rows)
+			}
+		})
+	}
+}
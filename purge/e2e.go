@@ -0,0 +1,261 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// e2eClockAhead is how far ahead the e2e check's purge run evaluates
+// spaces, so a space created today is past its one-day purge date
+const e2eClockAhead = 48 * time.Hour
+
+// E2EConfig describes configuration for the end-to-end check, which runs
+// the purge pipeline against a disposable space in a dedicated canary org
+type E2EConfig struct {
+	Config
+	CanaryOrg string `env:"CANARY_ORG"`
+}
+
+// E2E creates a disposable space holding an app and a user-provided service
+// instance in the canary org, then warns, purges, and recreates it with
+// short thresholds, checks the recreated space, and deletes it again
+func E2E(ctx context.Context, cfg E2EConfig) []CheckResult {
+	if cfg.CanaryOrg == "" {
+		return []CheckResult{{Name: "find canary org", Error: "CANARY_ORG is required"}}
+	}
+	cfClient, err := newCFClient(cfg.CFOptions, nil)
+	if err != nil {
+		return []CheckResult{{
+			Name:  "connect to API",
+			Error: fmt.Sprintf("error connecting to %s: %s", cfg.APIAddress, err),
+		}}
+	}
+	if err := guardFoundation(ctx, cfClient, cfg.FoundationOptions); err != nil {
+		return []CheckResult{{Name: "check foundation", Error: err.Error()}}
+	}
+	// the check's runs would only report, but it creates and deletes its
+	// space itself
+	if reason := newKillSwitch(cfg.KillSwitchOptions, cfg.S3Options).check(ctx, cfClient); reason != "" {
+		return []CheckResult{{Name: "check kill switch", Error: "kill switch engaged: " + reason}}
+	}
+	return runE2E(ctx, cfClient, cfg, Run)
+}
+
+// runE2E runs each stage of the end-to-end check in turn, stopping at the
+// first failure; the space is torn down whichever stage fails
+func runE2E(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	cfg E2EConfig,
+	run func(context.Context, Config) (Report, error),
+) []CheckResult {
+	var results []CheckResult
+
+	org, err := findCanaryOrg(ctx, cfClient, cfg.CanaryOrg)
+	results = append(results, checkResult("find canary org", err, func() string {
+		return fmt.Sprintf("found org %s", org.Name)
+	}))
+	if err != nil {
+		return results
+	}
+
+	spaceName := fmt.Sprintf("cg-sandbox-e2e-%d", time.Now().Unix())
+	space, err := createE2ESpace(ctx, cfClient, org, spaceName)
+	results = append(results, checkResult("create space", err, func() string {
+		return fmt.Sprintf("created space %s with an app and a service instance in org %s", spaceName, org.Name)
+	}))
+	if space == nil {
+		return results
+	}
+	if err == nil {
+		results = append(results, runE2EStages(ctx, cfClient, cfg, run, org, space)...)
+	}
+
+	err = deleteE2ESpace(ctx, cfClient, org, spaceName)
+	results = append(results, checkResult("tear down", err, func() string {
+		return fmt.Sprintf("deleted space %s in org %s", spaceName, org.Name)
+	}))
+	return results
+}
+
+// runE2EStages warns the space, purges it, and checks the recreated space
+func runE2EStages(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	cfg E2EConfig,
+	run func(context.Context, Config) (Report, error),
+	org *resource.Organization,
+	space *resource.Space,
+) []CheckResult {
+	var results []CheckResult
+
+	report, err := run(ctx, cfg.Config.forE2E(org.Name, space.GUID, 0))
+	err = e2eOutcome(report, err, planActionNotify, space)
+	results = append(results, checkResult("warn space", err, func() string {
+		return fmt.Sprintf("warned space %s", space.Name)
+	}))
+	if err != nil {
+		return results
+	}
+
+	report, err = run(ctx, cfg.Config.forE2E(org.Name, space.GUID, e2eClockAhead))
+	err = e2eOutcome(report, err, planActionPurge, space)
+	results = append(results, checkResult("purge space", err, func() string {
+		return fmt.Sprintf("purged and recreated space %s", space.Name)
+	}))
+	if err != nil {
+		return results
+	}
+
+	recreated, err := verifyE2ESpace(ctx, cfClient, org, space)
+	results = append(results, checkResult("verify recreated space", err, func() string {
+		return fmt.Sprintf("space %s was recreated empty as %s", space.Name, recreated.GUID)
+	}))
+	return results
+}
+
+// forE2E configures a live run over just the e2e check's space in the canary
+// org, which warns spaces from the day they are created and purges them the
+// next day; clockAhead moves the run's clock past that
+func (c Config) forE2E(canaryOrg string, spaceGUID string, clockAhead time.Duration) Config {
+	c = c.withoutRunOutputs()
+	c.OrgPrefix = canaryOrg
+	c.SpaceGUIDsFile = ""
+	c.spaceGUIDs = map[string]bool{spaceGUID: true}
+	c.DryRun = false
+	c.RunTime = ""
+	c.PlanOnly = false
+	c.DisablePurge = false
+	c.NotifyDays = 0
+	c.PurgeDays = 1
+	c.InstancePurgeDays = 0
+	c.TimeStartsAt = ""
+	// so per-org settings can't change the thresholds
+	c.PolicyFile = ""
+	c.NotifyRecurrence = ""
+	c.OnlyNotifyNew = false
+	c.clockAhead = clockAhead
+	return c
+}
+
+// createE2ESpace creates the e2e check's space with an app and a
+// user-provided service instance in it; the space is returned once created,
+// even if its contents can't be
+func createE2ESpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	spaceName string,
+) (*resource.Space, error) {
+	space, err := cfClient.Spaces.Create(ctx, resource.NewSpaceCreate(spaceName, org.GUID))
+	if err != nil {
+		return nil, fmt.Errorf("error creating space %s in canary org %s: %w", spaceName, org.Name, err)
+	}
+	if _, err := cfClient.Applications.Create(ctx, resource.NewAppCreate("e2e-app", space.GUID)); err != nil {
+		return space, fmt.Errorf("error creating app in space %s: %w", spaceName, err)
+	}
+	if _, err := cfClient.ServiceInstances.CreateUserProvided(ctx, resource.NewServiceInstanceCreateUserProvided("e2e-service", space.GUID)); err != nil {
+		return space, fmt.Errorf("error creating service instance in space %s: %w", spaceName, err)
+	}
+	return space, nil
+}
+
+// e2eOutcome checks that a run applied action to the space without error
+func e2eOutcome(report Report, runErr error, action string, space *resource.Space) error {
+	if runErr != nil {
+		return runErr
+	}
+	if report.Mode != runModeLive {
+		return fmt.Errorf("the run was %s: %s", report.Mode, report.ModeReason)
+	}
+	for _, result := range report.Spaces {
+		if result.SpaceGUID != space.GUID || result.Action != action {
+			continue
+		}
+		if result.Error != "" {
+			return errors.New(result.Error)
+		}
+		if result.Note != "" {
+			return errors.New(result.Note)
+		}
+		return nil
+	}
+	return fmt.Errorf("the run didn't %s space %s", action, space.Name)
+}
+
+// verifyE2ESpace checks that the purged space was recreated under its name
+// with nothing in it
+func verifyE2ESpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	purged *resource.Space,
+) (*resource.Space, error) {
+	space, err := findE2ESpace(ctx, cfClient, org, purged.Name)
+	if err != nil {
+		return nil, err
+	}
+	if space == nil {
+		return nil, fmt.Errorf("space %s wasn't recreated", purged.Name)
+	}
+	if space.GUID == purged.GUID {
+		return nil, fmt.Errorf("space %s wasn't deleted", purged.Name)
+	}
+	inventory, err := collectSpaceInventory(ctx, cfClient, org, space)
+	if err != nil {
+		return nil, err
+	}
+	if recreated := inventory.Spaces[0]; !recreated.Empty() {
+		return nil, fmt.Errorf("recreated space %s holds %d apps, %d service instances, and %d routes", purged.Name, len(recreated.Apps), len(recreated.ServiceInstances), len(recreated.Routes))
+	}
+	return space, nil
+}
+
+// findE2ESpace finds a space by name in the canary org, or nil if there is
+// none. Names are unique within an org, so the space is listed rather than
+// fetched with Single, which reports no match as an error
+func findE2ESpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	spaceName string,
+) (*resource.Space, error) {
+	spaceListOptions := client.NewSpaceListOptions()
+	spaceListOptions.Names.EqualTo(spaceName)
+	spaceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	spaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error finding space %s in canary org %s: %w", spaceName, org.Name, err)
+	}
+	if len(spaces) == 0 {
+		return nil, nil
+	}
+	return spaces[0], nil
+}
+
+// deleteE2ESpace deletes the e2e check's space, whether it is the original
+// or the recreated one, and waits for it to be gone
+func deleteE2ESpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	spaceName string,
+) error {
+	space, err := findE2ESpace(ctx, cfClient, org, spaceName)
+	if err != nil || space == nil {
+		return err
+	}
+	jobGUID, err := cfClient.Spaces.Delete(ctx, space.GUID)
+	if err != nil {
+		return fmt.Errorf("error deleting space %s in canary org %s: %w", spaceName, org.Name, err)
+	}
+	if err := waitForSpaceDeletion(ctx, cfClient, jobGUID); err != nil {
+		return fmt.Errorf("error waiting for delete job %s to be complete: %w", jobGUID, err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package purge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+// e2eRun fakes the e2e check's runs, applying each run's action in turn to
+// the space it is constrained to
+type e2eRun struct {
+	actions []string
+	errs    []string
+	configs []Config
+}
+
+func (r *e2eRun) run(ctx context.Context, cfg Config) (Report, error) {
+	i := len(r.configs)
+	r.configs = append(r.configs, cfg)
+	report := Report{Mode: runModeLive}
+	for guid := range cfg.spaceGUIDs {
+		result := SpaceResult{SpaceGUID: guid, Action: r.actions[i]}
+		if i < len(r.errs) {
+			result.Error = r.errs[i]
+		}
+		report.Spaces = append(report.Spaces, result)
+	}
+	return report, nil
+}
+
+func newE2EClient(recreated *resource.Space) *cfResourceClient {
+	var spaces []*resource.Space
+	if recreated != nil {
+		spaces = append(spaces, recreated)
+	}
+	return &cfResourceClient{
+		Organizations:             &mockOrganizations{org: &resource.Organization{GUID: "org-1", Name: "sandbox-canary"}},
+		Spaces:                    &mockSpaces{space: &resource.Space{GUID: "space-1", Name: "e2e"}, spaces: spaces, deleteJobGUID: "job-1"},
+		Applications:              &mockApplications{},
+		ServiceInstances:          &mockServiceInstances{},
+		Routes:                    &mockRoutes{},
+		ServiceCredentialBindings: &mockServiceCredentialBindings{},
+		Jobs:                      &mockJobs{expectedJobGUID: "job-1"},
+		Roles:                     &mockMemberRoles{},
+	}
+}
+
+func checkNames(results []CheckResult) (names []string, failed []string) {
+	for _, result := range results {
+		names = append(names, result.Name)
+		if !result.OK {
+			failed = append(failed, result.Name)
+		}
+	}
+	return names, failed
+}
+
+func TestRunE2E(t *testing.T) {
+	cfClient := newE2EClient(&resource.Space{GUID: "space-2", Name: "e2e"})
+	runs := &e2eRun{actions: []string{planActionNotify, planActionPurge}}
+	cfg := E2EConfig{Config: Config{NotifyDays: 25, PurgeDays: 30, PlanFile: "plan.json"}, CanaryOrg: "sandbox-canary"}
+
+	results := runE2E(context.Background(), cfClient, cfg, runs.run)
+	names, failed := checkNames(results)
+	expected := []string{"find canary org", "create space", "warn space", "purge space", "verify recreated space", "tear down"}
+	if diff := cmp.Diff(expected, names); diff != "" {
+		t.Errorf("checks mismatch (-want +got):\n%s", diff)
+	}
+	if len(failed) > 0 {
+		t.Errorf("expected every check to pass, got %+v", results)
+	}
+
+	if len(runs.configs) != 2 {
+		t.Fatalf("expected 2 runs, got %d", len(runs.configs))
+	}
+	for _, ran := range runs.configs {
+		if ran.OrgPrefix != "sandbox-canary" || ran.DryRun || ran.NotifyDays != 0 || ran.PurgeDays != 1 || ran.PlanFile != "" {
+			t.Errorf("unexpected run config %+v", ran)
+		}
+		if diff := cmp.Diff(map[string]bool{"space-1": true}, ran.spaceGUIDs); diff != "" {
+			t.Errorf("run spaces mismatch (-want +got):\n%s", diff)
+		}
+	}
+	if runs.configs[0].clockAhead != 0 || runs.configs[1].clockAhead != e2eClockAhead {
+		t.Errorf("expected only the purge run to move its clock ahead, got %s and %s", runs.configs[0].clockAhead, runs.configs[1].clockAhead)
+	}
+	if apps := cfClient.Applications.(*mockApplications).created; len(apps) != 1 {
+		t.Errorf("expected an app to be created, got %d", len(apps))
+	}
+	if instances := cfClient.ServiceInstances.(*mockServiceInstances).created; len(instances) != 1 {
+		t.Errorf("expected a service instance to be created, got %d", len(instances))
+	}
+}
+
+func TestRunE2EFailures(t *testing.T) {
+	testCases := map[string]struct {
+		recreated *resource.Space
+		runs      *e2eRun
+		expected  []string
+		failed    []string
+	}{
+		"warning fails": {
+			runs:     &e2eRun{actions: []string{planActionNotify}, errs: []string{"no mail"}},
+			expected: []string{"find canary org", "create space", "warn space", "tear down"},
+			failed:   []string{"warn space"},
+		},
+		"space isn't purged": {
+			runs:     &e2eRun{actions: []string{planActionNotify, planActionNotify}},
+			expected: []string{"find canary org", "create space", "warn space", "purge space", "tear down"},
+			failed:   []string{"purge space"},
+		},
+		"space isn't recreated": {
+			runs:     &e2eRun{actions: []string{planActionNotify, planActionPurge}},
+			expected: []string{"find canary org", "create space", "warn space", "purge space", "verify recreated space", "tear down"},
+			failed:   []string{"verify recreated space"},
+		},
+		"space isn't deleted": {
+			recreated: &resource.Space{GUID: "space-1", Name: "e2e"},
+			runs:      &e2eRun{actions: []string{planActionNotify, planActionPurge}},
+			expected:  []string{"find canary org", "create space", "warn space", "purge space", "verify recreated space", "tear down"},
+			failed:    []string{"verify recreated space"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := E2EConfig{CanaryOrg: "sandbox-canary"}
+			results := runE2E(context.Background(), newE2EClient(test.recreated), cfg, test.runs.run)
+			names, failed := checkNames(results)
+			if diff := cmp.Diff(test.expected, names); diff != "" {
+				t.Errorf("checks mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.failed, failed); diff != "" {
+				t.Errorf("failed checks mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestE2EOutcome(t *testing.T) {
+	space := &resource.Space{GUID: "space-1", Name: "e2e"}
+	report := Report{Mode: runModeReportOnly, ModeReason: "kill switch engaged"}
+	if err := e2eOutcome(report, nil, planActionPurge, space); err == nil || err.Error() != "the run was report-only: kill switch engaged" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	report = Report{Mode: runModeLive, Spaces: []SpaceResult{{SpaceGUID: "space-1", Action: planActionPurge, Note: "purge deferred"}}}
+	if err := e2eOutcome(report, nil, planActionPurge, space); err == nil {
+		t.Errorf("expected a skipped purge to fail the check")
+	}
+}
@@ -0,0 +1,123 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// annotationRecreatedAt marks the day a purge recreated a space; it is
+// removed once the space holds resources again
+const annotationRecreatedAt = "sandbox.recreated-at"
+
+const planActionDeleteEmpty = "delete-empty"
+
+// recreatedAt returns the day a purge recreated a space, if it is marked
+func recreatedAt(space *resource.Space) (time.Time, bool) {
+	if space.Metadata == nil {
+		return time.Time{}, false
+	}
+	value := space.Metadata.Annotations[annotationRecreatedAt]
+	if value == nil {
+		return time.Time{}, false
+	}
+	day, err := time.Parse("2006-01-02", *value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return day, true
+}
+
+// listEmptyRecreatedSpaces finds recreated spaces that have stayed empty for
+// EMPTY_SPACE_DELETE_DAYS, each with the day it was recreated as its
+// timestamp; recreated spaces that hold resources again are unmarked, so
+// they aren't deleted if they empty out later. A space counts as empty only
+// without any apps, routes, or service instances, including instances of
+// system plans
+func listEmptyRecreatedSpaces(
+	inventory *collect.Inventory,
+	opts Config,
+	now time.Time,
+) ([]SpaceDetails, []SpaceAnnotation) {
+	details := listSpaceFirstResources(inventory, ageByCreated, time.Time{})
+	cutoff := now.AddDate(0, 0, -opts.EmptySpaceDeleteDays)
+	var empty []SpaceDetails
+	var unmarked []SpaceAnnotation
+	for _, d := range details {
+		day, ok := recreatedAt(d.Space)
+		if !ok {
+			continue
+		}
+		if !d.Timestamp.IsZero() {
+			metadata := resource.NewMetadata()
+			metadata.RemoveAnnotation("", annotationRecreatedAt)
+			unmarked = append(unmarked, SpaceAnnotation{
+				Org:         inventory.Org.Name,
+				Space:       d.Space.Name,
+				SpaceGUID:   d.Space.GUID,
+				Annotations: metadata.Annotations,
+			})
+			continue
+		}
+		if day.After(cutoff) || isPurgeBlocked(d.Space) {
+			continue
+		}
+		empty = append(empty, SpaceDetails{Timestamp: day, Space: d.Space})
+	}
+	return empty, unmarked
+}
+
+// planDeleteEmpty plans the deletion of a recreated space that stayed empty
+func planDeleteEmpty(org *resource.Organization, details SpaceDetails) PlannedAction {
+	logFields{Org: org.Name, Space: details.Space.Name, Action: planActionDeleteEmpty}.printf("deleting space %s in org %s; it has been empty since it was recreated on %s", details.Space.Name, org.Name, details.Timestamp.Format("2006-01-02"))
+	return PlannedAction{
+		Action:  planActionDeleteEmpty,
+		Org:     org,
+		Details: details,
+	}
+}
+
+// applyDeleteEmpty deletes an empty recreated space without recreating it;
+// the space is listed again first, and kept if it gained resources since it
+// was planned
+func applyDeleteEmpty(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	action PlannedAction,
+	report *Report,
+) error {
+	space := action.Details.Space
+	if opts.DryRun {
+		return nil
+	}
+
+	inventory, err := collectSpaceInventory(ctx, cfClient, action.Org, space)
+	if isNotFoundError(err) {
+		return deletedDuringRun("space " + space.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("error listing resources for space %s in org %s: %w", space.Name, action.Org.Name, err)
+	}
+	if !inventory.Spaces[0].Empty() {
+		actionFields(action).printf("keeping space %s in org %s; it is no longer empty", space.Name, action.Org.Name)
+		return nil
+	}
+
+	actionFields(action).printf("deleting empty space %s in org %s", space.Name, action.Org.Name)
+	jobGUID, err := cfClient.Spaces.Delete(ctx, space.GUID)
+	if isNotFoundError(err) {
+		return deletedDuringRun("space " + space.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting empty space %s in org %s: %w", space.Name, action.Org.Name, err)
+	}
+	if err := waitForSpaceDeletion(ctx, cfClient, jobGUID); err != nil {
+		return fmt.Errorf("error waiting for delete job %s to be complete: %w", jobGUID, err)
+	}
+	report.EmptySpacesDeleted = append(report.EmptySpacesDeleted, action.Org.Name+"/"+space.Name)
+	return nil
+}
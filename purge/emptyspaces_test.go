@@ -0,0 +1,89 @@
+package purge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func recreatedSpace(guid string, name string, day string) *resource.Space {
+	metadata := resource.NewMetadata()
+	metadata.SetAnnotation("", annotationRecreatedAt, day)
+	return &resource.Space{GUID: guid, Name: name, Metadata: metadata}
+}
+
+func TestListEmptyRecreatedSpaces(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	blocked := recreatedSpace("space-5", "blocked", "2024-01-01")
+	blocked.Metadata.SetLabel("", labelPurgeBlocked, "true")
+	spaces := []*resource.Space{
+		recreatedSpace("space-1", "stale", "2024-01-15"),
+		recreatedSpace("space-2", "recent", "2024-02-20"),
+		recreatedSpace("space-3", "used", "2024-01-15"),
+		{GUID: "space-4", Name: "never-purged"},
+		blocked,
+	}
+	apps := []*resource.App{appInSpace("app-1", "space-3", now.AddDate(0, 0, -3))}
+
+	empty, unmarked := listEmptyRecreatedSpaces(collect.New(org, collect.Resources{Spaces: spaces, Apps: apps}), Config{EmptySpaceDeleteDays: 30}, now)
+	expectedEmpty := []SpaceDetails{{Timestamp: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), Space: spaces[0]}}
+	if diff := cmp.Diff(expectedEmpty, empty); diff != "" {
+		t.Errorf("empty spaces mismatch (-want +got):\n%s", diff)
+	}
+	if len(unmarked) != 1 || unmarked[0].SpaceGUID != "space-3" {
+		t.Fatalf("expected space-3 to be unmarked, got %+v", unmarked)
+	}
+	if value, ok := unmarked[0].Annotations[annotationRecreatedAt]; !ok || value != nil {
+		t.Errorf("expected %s to be removed, got %v", annotationRecreatedAt, unmarked[0].Annotations)
+	}
+}
+
+func TestApplyDeleteEmpty(t *testing.T) {
+	space := recreatedSpace("space-1", "stale", "2024-01-15")
+	action := PlannedAction{
+		Action:  planActionDeleteEmpty,
+		Org:     &resource.Organization{GUID: "org-1", Name: "sandbox-agency"},
+		Details: SpaceDetails{Space: space},
+	}
+
+	testCases := map[string]struct {
+		opts            Config
+		apps            []*resource.App
+		expectedDeleted []string
+	}{
+		"deletes an empty space": {
+			expectedDeleted: []string{"sandbox-agency/stale"},
+		},
+		"keeps a space used since it was planned": {
+			apps: []*resource.App{appInSpace("app-1", "space-1", time.Now())},
+		},
+		"dry run": {
+			opts: Config{DryRun: true},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				Applications:              &mockApplications{apps: test.apps},
+				ServiceInstances:          &mockServiceInstances{},
+				ServiceCredentialBindings: &mockServiceCredentialBindings{},
+				Routes:                    &mockRoutes{},
+				Spaces:                    &mockSpaces{deleteJobGUID: "job-1"},
+				Jobs:                      &mockJobs{expectedJobGUID: "job-1"},
+				Roles:                     &mockMemberRoles{},
+			}
+			report := &Report{}
+			if err := applyDeleteEmpty(context.Background(), cfClient, test.opts, action, report); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(test.expectedDeleted, report.EmptySpacesDeleted); diff != "" {
+				t.Errorf("deleted spaces mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// Annotations recording an operator's extension of a space's purge date;
+// only the latest extension is kept, and CF's audit events record the
+// space updates that wrote each one
+const (
+	annotationPurgeExtendedUntil   = "sandbox.purge-extended-until"
+	annotationPurgeExtendedBy      = "sandbox.purge-extended-by"
+	annotationPurgeExtendedAt      = "sandbox.purge-extended-at"
+	annotationPurgeExtensionReason = "sandbox.purge-extension-reason"
+)
+
+// ExtendConfig describes configuration for extending a space's purge date
+type ExtendConfig struct {
+	Config
+	ExtendOrg    string
+	ExtendSpace  string
+	ExtendDays   int
+	ExtendReason string
+	// ExtendBy names the operator granting the extension
+	ExtendBy string
+}
+
+func (c ExtendConfig) validate() error {
+	if c.ExtendOrg == "" || c.ExtendSpace == "" {
+		return errors.New("an org and space are required")
+	}
+	if c.ExtendDays <= 0 {
+		return fmt.Errorf("days must be positive, got %d", c.ExtendDays)
+	}
+	if c.ExtendReason == "" {
+		return errors.New("a reason is required")
+	}
+	if c.ExtendBy == "" {
+		return errors.New("the operator granting the extension is required")
+	}
+	return c.Validate()
+}
+
+// SpaceExtension describes an extension of a space's purge date
+type SpaceExtension struct {
+	Org               string    `json:"org"`
+	Space             string    `json:"space"`
+	SpaceGUID         string    `json:"space_guid"`
+	PreviousPurgeDate time.Time `json:"previous_purge_date"`
+	PurgeDate         time.Time `json:"purge_date"`
+	By                string    `json:"by"`
+	Reason            string    `json:"reason"`
+	At                time.Time `json:"at"`
+	DryRun            bool      `json:"dry_run"`
+}
+
+// purgeExtension returns the date a space's purge was extended to, or the
+// zero time if it wasn't or the annotation can't be parsed
+func purgeExtension(space *resource.Space) time.Time {
+	if space == nil || space.Metadata == nil || space.Metadata.Annotations[annotationPurgeExtendedUntil] == nil {
+		return time.Time{}
+	}
+	value := *space.Metadata.Annotations[annotationPurgeExtendedUntil]
+	until, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		logFields{Space: space.Name}.printf("ignoring purge extension %q on space %s: %s", value, space.Name, err)
+		return time.Time{}
+	}
+	return until
+}
+
+// spacePurgeCutoff is purgeCutoff for a space, pushed back to the date an
+// operator extended its purge to
+func spacePurgeCutoff(details SpaceDetails, purgeDays int) time.Time {
+	cutoff := purgeCutoff(details.Timestamp, purgeDays)
+	if until := purgeExtension(details.Space); until.After(cutoff) {
+		return until
+	}
+	return cutoff
+}
+
+// Extend pushes a space's purge date back by ExtendDays from its current
+// purge date, or from today if that has passed or the space is empty, and
+// records who granted the extension and why on the space
+func Extend(ctx context.Context, cfg ExtendConfig) (SpaceExtension, error) {
+	if err := cfg.validate(); err != nil {
+		return SpaceExtension{}, fmt.Errorf("error parsing options: %w", err)
+	}
+	if err := cfg.usePolicyFile(ctx); err != nil {
+		return SpaceExtension{}, err
+	}
+	cfClient, err := newCFClient(cfg.CFOptions, nil)
+	if err != nil {
+		return SpaceExtension{}, fmt.Errorf("error creating client: %w", err)
+	}
+	if err := guardFoundation(ctx, cfClient, cfg.FoundationOptions); err != nil {
+		return SpaceExtension{}, err
+	}
+	if reason := newKillSwitch(cfg.KillSwitchOptions, cfg.S3Options).check(ctx, cfClient); reason != "" && !cfg.DryRun {
+		return SpaceExtension{}, fmt.Errorf("kill switch engaged: %s; not extending space %s", reason, cfg.ExtendSpace)
+	}
+	timeStartsAt, err := cfg.timeStartsAt()
+	if err != nil {
+		return SpaceExtension{}, err
+	}
+	systemPlans, err := listSystemPlans(ctx, cfClient, cfg.SystemServiceOptions)
+	if err != nil {
+		return SpaceExtension{}, err
+	}
+	return extendSpace(ctx, cfClient, cfg, systemPlans, time.Now(), timeStartsAt)
+}
+
+// extendSpace finds the space to extend, ages it the way a run would, and
+// annotates it with its new purge date
+func extendSpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	cfg ExtendConfig,
+	systemPlans map[string]bool,
+	now time.Time,
+	timeStartsAt time.Time,
+) (SpaceExtension, error) {
+	orgs, err := listSandboxOrgs(ctx, cfClient, cfg.ExtendOrg)
+	if err != nil {
+		return SpaceExtension{}, fmt.Errorf("error getting orgs: %w", err)
+	}
+	var org *resource.Organization
+	for _, candidate := range orgs {
+		if candidate.Name == cfg.ExtendOrg {
+			org = candidate
+		}
+	}
+	if org == nil {
+		return SpaceExtension{}, fmt.Errorf("org %s: %w", cfg.ExtendOrg, errSpaceNotFound)
+	}
+	opts := cfg.forOrg(org.Name)
+
+	inventory, err := collectOrgInventory(ctx, cfClient, org)
+	if err != nil {
+		return SpaceExtension{}, fmt.Errorf("error listing org resources for org %s: %w", org.Name, err)
+	}
+	details := listSpaceFirstResources(inventory.WithoutSystemInstances(systemPlans), opts.AgeBy, timeStartsAt)
+	var spaceDetails *SpaceDetails
+	for i := range details {
+		if details[i].Space.Name == cfg.ExtendSpace {
+			spaceDetails = &details[i]
+		}
+	}
+	if spaceDetails == nil {
+		return SpaceExtension{}, fmt.Errorf("space %s in org %s: %w", cfg.ExtendSpace, org.Name, errSpaceNotFound)
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+	extension := SpaceExtension{
+		Org:       org.Name,
+		Space:     spaceDetails.Space.Name,
+		SpaceGUID: spaceDetails.Space.GUID,
+		By:        cfg.ExtendBy,
+		Reason:    cfg.ExtendReason,
+		At:        now.UTC(),
+		DryRun:    cfg.DryRun,
+	}
+	from := purgeExtension(spaceDetails.Space)
+	if !spaceDetails.Timestamp.IsZero() {
+		extension.PreviousPurgeDate = spacePurgeCutoff(*spaceDetails, opts.PurgeDays)
+		from = extension.PreviousPurgeDate
+	}
+	if from.Before(today) {
+		from = today
+	}
+	extension.PurgeDate = from.AddDate(0, 0, cfg.ExtendDays)
+
+	if cfg.DryRun {
+		logFields{Org: extension.Org, Space: extension.Space}.printf("would extend purge of space %s in org %s to %s", extension.Space, extension.Org, extension.PurgeDate.Format("2006-01-02"))
+		return extension, nil
+	}
+	metadata := resource.NewMetadata()
+	metadata.SetAnnotation("", annotationPurgeExtendedUntil, extension.PurgeDate.Format("2006-01-02"))
+	metadata.SetAnnotation("", annotationPurgeExtendedBy, extension.By)
+	metadata.SetAnnotation("", annotationPurgeExtendedAt, extension.At.Format(time.RFC3339))
+	metadata.SetAnnotation("", annotationPurgeExtensionReason, extension.Reason)
+	if _, err := cfClient.Spaces.Update(ctx, extension.SpaceGUID, &resource.SpaceUpdate{Metadata: metadata}); err != nil {
+		return SpaceExtension{}, fmt.Errorf("error annotating space %s in org %s: %w", extension.Space, extension.Org, err)
+	}
+	logFields{Org: extension.Org, Space: extension.Space}.printf("extended purge of space %s in org %s to %s; granted by %s: %s", extension.Space, extension.Org, extension.PurgeDate.Format("2006-01-02"), extension.By, extension.Reason)
+	return extension, nil
+}
@@ -0,0 +1,158 @@
+package purge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func extendedSpace(guid string, until string) *resource.Space {
+	space := &resource.Space{GUID: guid, Name: guid}
+	if until != "" {
+		space.Metadata = resource.NewMetadata()
+		space.Metadata.SetAnnotation("", annotationPurgeExtendedUntil, until)
+	}
+	return space
+}
+
+func TestListPurgeSpacesExtended(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	opts := Config{NotifyDays: 25, PurgeDays: 30}
+	spaces := []*resource.Space{
+		extendedSpace("unextended", ""),
+		extendedSpace("extended", "2024-03-20"),
+		extendedSpace("warned-again", "2024-03-04"),
+		extendedSpace("lapsed", "2024-02-01"),
+		extendedSpace("malformed", "next month"),
+	}
+	var apps []*resource.App
+	for _, space := range spaces {
+		apps = append(apps, appInSpace("app-"+space.GUID, space.GUID, now.AddDate(0, 0, -31)))
+	}
+
+	toNotify, toPurge := listPurgeSpaces(collect.New(&resource.Organization{}, collect.Resources{Spaces: spaces, Apps: apps}), opts, now, time.Time{})
+	guids := func(details []SpaceDetails) []string {
+		var got []string
+		for _, d := range details {
+			got = append(got, d.Space.GUID)
+		}
+		return got
+	}
+	if diff := cmp.Diff([]string{"warned-again"}, guids(toNotify)); diff != "" {
+		t.Errorf("toNotify mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"unextended", "lapsed", "malformed"}, guids(toPurge)); diff != "" {
+		t.Errorf("toPurge mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtendSpace(t *testing.T) {
+	now := time.Date(2024, 3, 1, 15, 4, 5, 0, time.UTC)
+	testCases := map[string]struct {
+		space            *resource.Space
+		appCreatedAt     time.Time
+		dryRun           bool
+		expectedPrevious time.Time
+		expectedDate     time.Time
+	}{
+		"from the purge date": {
+			space:            extendedSpace("space-1", ""),
+			appCreatedAt:     now.AddDate(0, 0, -20),
+			expectedPrevious: time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+			expectedDate:     time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC),
+		},
+		"from an earlier extension": {
+			space:            extendedSpace("space-1", "2024-03-20"),
+			appCreatedAt:     now.AddDate(0, 0, -20),
+			expectedPrevious: time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC),
+			expectedDate:     time.Date(2024, 4, 19, 0, 0, 0, 0, time.UTC),
+		},
+		"from today when overdue": {
+			space:            extendedSpace("space-1", ""),
+			appCreatedAt:     now.AddDate(0, 0, -40),
+			expectedPrevious: time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC),
+			expectedDate:     time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+		},
+		"empty space": {
+			space:        extendedSpace("space-1", ""),
+			expectedDate: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+		},
+		"dry run": {
+			space:            extendedSpace("space-1", ""),
+			appCreatedAt:     now.AddDate(0, 0, -20),
+			dryRun:           true,
+			expectedPrevious: time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+			expectedDate:     time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var apps []*resource.App
+			if !test.appCreatedAt.IsZero() {
+				apps = append(apps, appInSpace("app-1", "space-1", test.appCreatedAt))
+			}
+			spaces := &mockSpaces{spaces: []*resource.Space{test.space}}
+			cfClient := &cfResourceClient{
+				Organizations:    &mockOrganizations{orgs: []*resource.Organization{{GUID: "org-1", Name: "sandbox-foo"}}},
+				Applications:     &mockApplications{apps: apps},
+				ServiceInstances: &mockServiceInstances{},
+				Routes:           &mockRoutes{},
+				Spaces:           spaces,
+				Roles:            &mockMemberRoles{},
+			}
+			cfg := ExtendConfig{
+				Config:       Config{PurgeDays: 30, DryRun: test.dryRun},
+				ExtendOrg:    "sandbox-foo",
+				ExtendSpace:  "space-1",
+				ExtendDays:   30,
+				ExtendReason: "demo for agency leadership",
+				ExtendBy:     "jane.doe",
+			}
+			extension, err := extendSpace(context.Background(), cfClient, cfg, nil, now, time.Time{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			expected := SpaceExtension{
+				Org:               "sandbox-foo",
+				Space:             "space-1",
+				SpaceGUID:         "space-1",
+				PreviousPurgeDate: test.expectedPrevious,
+				PurgeDate:         test.expectedDate,
+				By:                "jane.doe",
+				Reason:            "demo for agency leadership",
+				At:                now,
+				DryRun:            test.dryRun,
+			}
+			if diff := cmp.Diff(expected, extension); diff != "" {
+				t.Errorf("extendSpace() mismatch (-want +got):\n%s", diff)
+			}
+
+			if test.dryRun {
+				if len(spaces.updates) > 0 {
+					t.Errorf("expected no updates on a dry run, got %+v", spaces.updates)
+				}
+				return
+			}
+			if len(spaces.updates) != 1 {
+				t.Fatalf("expected 1 update, got %d", len(spaces.updates))
+			}
+			annotations := map[string]string{}
+			for key, value := range spaces.updates[0].Update.Metadata.Annotations {
+				annotations[key] = *value
+			}
+			expectedAnnotations := map[string]string{
+				annotationPurgeExtendedUntil:   test.expectedDate.Format("2006-01-02"),
+				annotationPurgeExtendedBy:      "jane.doe",
+				annotationPurgeExtendedAt:      "2024-03-01T15:04:05Z",
+				annotationPurgeExtensionReason: "demo for agency leadership",
+			}
+			if diff := cmp.Diff(expectedAnnotations, annotations); diff != "" {
+				t.Errorf("annotations mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+)
+
+// FoundationOptions pin the CF foundation a job is meant for, so a job
+// configured for one foundation can't purge another after an env var mix-up
+type FoundationOptions struct {
+	// ExpectAPI must match the API root the foundation reports for itself
+	ExpectAPI string `env:"EXPECT_API"`
+	// ExpectFoundationName must match the name in the foundation's /v3/info
+	ExpectFoundationName string `env:"EXPECT_FOUNDATION_NAME"`
+	// ExpectOrg names an org that must exist on the foundation
+	ExpectOrg string `env:"EXPECT_ORG"`
+}
+
+func (o FoundationOptions) enabled() bool {
+	return o.ExpectAPI != "" || o.ExpectFoundationName != "" || o.ExpectOrg != ""
+}
+
+// FoundationInfo is the part of /v3/info that identifies a foundation
+type FoundationInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type InfoClient interface {
+	Get(ctx context.Context) (*FoundationInfo, error)
+}
+
+// infoClient reads /v3/info, which the CF client doesn't support
+type infoClient struct {
+	apiAddress string
+	httpClient *http.Client
+}
+
+func (c *infoClient) Get(ctx context.Context) (*FoundationInfo, error) {
+	endpoint := strings.TrimSuffix(c.apiAddress, "/") + "/v3/info"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, client.CloudFoundryHTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+	}
+	var info FoundationInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", endpoint, err)
+	}
+	return &info, nil
+}
+
+// normalizeAPI makes API addresses comparable, ignoring case and a trailing
+// slash
+func normalizeAPI(address string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(address), "/"))
+}
+
+// guardFoundation refuses to go on unless the client is pointed at the
+// expected foundation, when one is configured; commands that change CF call
+// it before anything else
+func guardFoundation(ctx context.Context, cfClient *cfResourceClient, opts FoundationOptions) error {
+	if !opts.enabled() {
+		return nil
+	}
+	if err := checkFoundation(ctx, cfClient, opts); err != nil {
+		return fmt.Errorf("refusing to run against this foundation: %w", err)
+	}
+	return nil
+}
+
+// checkFoundation returns an error unless the client is pointed at the
+// expected foundation; a foundation that can't be identified fails the
+// check too
+func checkFoundation(ctx context.Context, cfClient *cfResourceClient, opts FoundationOptions) error {
+	if opts.ExpectAPI != "" {
+		root, err := cfClient.Root.Get(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting API root to check EXPECT_API: %w", err)
+		}
+		if got := root.Links.Self.Href; normalizeAPI(got) != normalizeAPI(opts.ExpectAPI) {
+			return fmt.Errorf("API root is %s, not the expected %s", got, opts.ExpectAPI)
+		}
+	}
+	if opts.ExpectFoundationName != "" {
+		info, err := cfClient.Info.Get(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting API info to check EXPECT_FOUNDATION_NAME: %w", err)
+		}
+		if info.Name != opts.ExpectFoundationName {
+			return fmt.Errorf("foundation is named %q, not the expected %q", info.Name, opts.ExpectFoundationName)
+		}
+	}
+	if opts.ExpectOrg != "" {
+		orgListOptions := client.NewOrganizationListOptions()
+		orgListOptions.Names.EqualTo(opts.ExpectOrg)
+		if _, err := cfClient.Organizations.Single(ctx, orgListOptions); err != nil {
+			return fmt.Errorf("error getting expected org %s: %w", opts.ExpectOrg, err)
+		}
+	}
+	return nil
+}
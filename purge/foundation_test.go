@@ -0,0 +1,106 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+type mockInfo struct {
+	info   *FoundationInfo
+	getErr error
+}
+
+func (i *mockInfo) Get(ctx context.Context) (*FoundationInfo, error) {
+	return i.info, i.getErr
+}
+
+func TestCheckFoundation(t *testing.T) {
+	root := &resource.Root{}
+	root.Links.Self.Href = "https://api.fr-stage.cloud.gov"
+	newClient := func() *cfResourceClient {
+		return &cfResourceClient{
+			Root:          &mockRoot{root: root},
+			Info:          &mockInfo{info: &FoundationInfo{Name: "staging"}},
+			Organizations: &mockOrganizations{org: &resource.Organization{Name: "cloud-gov"}},
+		}
+	}
+
+	testCases := map[string]struct {
+		opts        FoundationOptions
+		modify      func(*cfResourceClient)
+		expectedErr string
+	}{
+		"matching foundation": {
+			opts: FoundationOptions{
+				ExpectAPI:            "https://API.fr-stage.cloud.gov/",
+				ExpectFoundationName: "staging",
+				ExpectOrg:            "cloud-gov",
+			},
+		},
+		"wrong API": {
+			opts:        FoundationOptions{ExpectAPI: "https://api.fr.cloud.gov"},
+			expectedErr: "API root is https://api.fr-stage.cloud.gov, not the expected https://api.fr.cloud.gov",
+		},
+		"wrong name": {
+			opts:        FoundationOptions{ExpectFoundationName: "production"},
+			expectedErr: `foundation is named "staging", not the expected "production"`,
+		},
+		"info unavailable": {
+			opts: FoundationOptions{ExpectFoundationName: "staging"},
+			modify: func(c *cfResourceClient) {
+				c.Info = &mockInfo{getErr: errors.New("connection refused")}
+			},
+			expectedErr: "error getting API info to check EXPECT_FOUNDATION_NAME: connection refused",
+		},
+		"org missing": {
+			opts: FoundationOptions{ExpectOrg: "cloud-gov"},
+			modify: func(c *cfResourceClient) {
+				c.Organizations = &mockOrganizations{singleErr: client.ErrExactlyOneResultNotReturned}
+			},
+			expectedErr: "error getting expected org cloud-gov: expected exactly 1 result, but got less or more than 1",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := newClient()
+			if test.modify != nil {
+				test.modify(cfClient)
+			}
+			err := checkFoundation(context.Background(), cfClient, test.opts)
+			if test.expectedErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.expectedErr {
+				t.Errorf("expected error %q, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestInfoClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/info" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"name":"production","description":"cloud.gov","version":3}`))
+	}))
+	defer server.Close()
+
+	info, err := (&infoClient{apiAddress: server.URL + "/", httpClient: server.Client()}).Get(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if info.Name != "production" {
+		t.Errorf("expected name production, got %q", info.Name)
+	}
+}
@@ -0,0 +1,206 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	githubPublishIssue = "issue"
+	githubPublishGist  = "gist"
+
+	// githubBodyLimit is the maximum length GitHub accepts for an issue or
+	// comment body
+	githubBodyLimit = 65536
+)
+
+// GitHubOptions describes configuration for publishing run reports to GitHub
+type GitHubOptions struct {
+	// GitHubReportPublish is "issue" to add each run's Markdown report to an
+	// issue in GitHubRepo, or "gist" to add it to a gist
+	GitHubReportPublish string `env:"GITHUB_REPORT_PUBLISH"`
+	GitHubToken         string `env:"GITHUB_TOKEN"`
+	GitHubAPIURL        string `env:"GITHUB_API_URL, default=https://api.github.com"`
+	// GitHubRepo is the owner/name of the repo holding the report issue
+	GitHubRepo string `env:"GITHUB_REPO"`
+	// GitHubIssueLabel finds the open report issue; run reports are comments
+	// on it, and a new issue is opened when none is
+	GitHubIssueLabel string `env:"GITHUB_ISSUE_LABEL, default=sandbox-purge-report"`
+	GitHubIssueTitle string `env:"GITHUB_ISSUE_TITLE, default=Sandbox purge run reports"`
+	// GitHubGistID is the secret gist that run reports are added to; a new
+	// one is created, and its ID logged, when it's empty
+	GitHubGistID string `env:"GITHUB_GIST_ID"`
+}
+
+func (o GitHubOptions) validate() error {
+	switch o.GitHubReportPublish {
+	case "":
+		return nil
+	case githubPublishIssue:
+		if o.GitHubRepo == "" || !strings.Contains(o.GitHubRepo, "/") {
+			return fmt.Errorf("GITHUB_REPO must be owner/name to publish reports to an issue")
+		}
+	case githubPublishGist:
+	default:
+		return fmt.Errorf("unknown GITHUB_REPORT_PUBLISH %s; expected issue or gist", o.GitHubReportPublish)
+	}
+	if o.GitHubToken == "" {
+		return fmt.Errorf("GITHUB_TOKEN is required for GITHUB_REPORT_PUBLISH")
+	}
+	return nil
+}
+
+type githubPublisher struct {
+	options    GitHubOptions
+	httpClient *http.Client
+}
+
+func newGitHubPublisher(opts GitHubOptions) *githubPublisher {
+	if opts.GitHubReportPublish == "" {
+		return nil
+	}
+	return &githubPublisher{options: opts, httpClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// publish adds a run's Markdown report to the configured issue or gist
+func (p *githubPublisher) publish(ctx context.Context, report Report) error {
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, report, reportFormatMarkdown); err != nil {
+		return fmt.Errorf("error rendering report: %w", err)
+	}
+	title := fmt.Sprintf("Run %s", report.StartedAt.UTC().Format(time.RFC3339))
+	if report.DryRun {
+		title += " (dry run)"
+	}
+	body := fmt.Sprintf("## %s\n\n%s", title, buf.String())
+
+	if p.options.GitHubReportPublish == githubPublishGist {
+		return p.publishGist(ctx, report, body)
+	}
+	return p.publishIssue(ctx, truncateGitHubBody(body))
+}
+
+// publishIssue comments the report on the open issue with the report label,
+// or opens one with the report as its body
+func (p *githubPublisher) publishIssue(ctx context.Context, body string) error {
+	query := url.Values{
+		"labels":   {p.options.GitHubIssueLabel},
+		"state":    {"open"},
+		"per_page": {"1"},
+	}
+	var issues []struct {
+		Number int `json:"number"`
+	}
+	if err := p.request(ctx, http.MethodGet, "/repos/"+p.options.GitHubRepo+"/issues?"+query.Encode(), nil, &issues); err != nil {
+		return fmt.Errorf("error finding report issue in %s: %w", p.options.GitHubRepo, err)
+	}
+	if len(issues) > 0 {
+		path := fmt.Sprintf("/repos/%s/issues/%d/comments", p.options.GitHubRepo, issues[0].Number)
+		if err := p.request(ctx, http.MethodPost, path, map[string]string{"body": body}, nil); err != nil {
+			return fmt.Errorf("error commenting on report issue #%d: %w", issues[0].Number, err)
+		}
+		return nil
+	}
+
+	issue := map[string]interface{}{
+		"title":  p.options.GitHubIssueTitle,
+		"body":   body,
+		"labels": []string{p.options.GitHubIssueLabel},
+	}
+	var created struct {
+		Number int `json:"number"`
+	}
+	if err := p.request(ctx, http.MethodPost, "/repos/"+p.options.GitHubRepo+"/issues", issue, &created); err != nil {
+		return fmt.Errorf("error opening report issue in %s: %w", p.options.GitHubRepo, err)
+	}
+	logFields{}.printf("opened report issue #%d in %s", created.Number, p.options.GitHubRepo)
+	return nil
+}
+
+// publishGist adds the report to the gist as a file named for the run, so
+// the gist's revisions keep the history; it creates the gist when
+// GITHUB_GIST_ID is empty
+func (p *githubPublisher) publishGist(ctx context.Context, report Report, body string) error {
+	name := fmt.Sprintf("sandbox-purge-%s.md", report.StartedAt.UTC().Format("20060102T150405Z"))
+	files := map[string]interface{}{name: map[string]string{"content": body}}
+	if p.options.GitHubGistID != "" {
+		if err := p.request(ctx, http.MethodPatch, "/gists/"+url.PathEscape(p.options.GitHubGistID), map[string]interface{}{"files": files}, nil); err != nil {
+			return fmt.Errorf("error updating report gist %s: %w", p.options.GitHubGistID, err)
+		}
+		return nil
+	}
+
+	gist := map[string]interface{}{
+		"description": p.options.GitHubIssueTitle,
+		"public":      false,
+		"files":       files,
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := p.request(ctx, http.MethodPost, "/gists", gist, &created); err != nil {
+		return fmt.Errorf("error creating report gist: %w", err)
+	}
+	logFields{}.printf("created report gist %s; set GITHUB_GIST_ID to add later reports to it", created.ID)
+	return nil
+}
+
+// request calls the GitHub REST API and optionally decodes its response
+func (p *githubPublisher) request(
+	ctx context.Context,
+	method string,
+	path string,
+	payload interface{},
+	result interface{},
+) error {
+	var body io.Reader
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(p.options.GitHubAPIURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+p.options.GitHubToken)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status from GitHub: %s", resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// truncateGitHubBody shortens a report that's too long for an issue or
+// comment, noting that it was cut
+func truncateGitHubBody(body string) string {
+	if len(body) <= githubBodyLimit {
+		return body
+	}
+	note := "\n\n_Report truncated; see the job's report file for the rest._\n"
+	body = body[:githubBodyLimit-len(note)]
+	if i := strings.LastIndex(body, "\n"); i > 0 {
+		body = body[:i]
+	}
+	return body + note
+}
@@ -0,0 +1,147 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// githubRequest is a request received by the fake GitHub API
+type githubRequest struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+func TestGitHubPublisherIssue(t *testing.T) {
+	testCases := map[string]struct {
+		issues   string
+		expected []string
+	}{
+		"comments on open issue": {
+			issues:   `[{"number": 7}]`,
+			expected: []string{"GET /repos/18f/sandbox-reports/issues", "POST /repos/18f/sandbox-reports/issues/7/comments"},
+		},
+		"opens issue": {
+			issues:   `[]`,
+			expected: []string{"GET /repos/18f/sandbox-reports/issues", "POST /repos/18f/sandbox-reports/issues"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var requests []githubRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer token" {
+					t.Errorf("unexpected authorization header: %s", r.Header.Get("Authorization"))
+				}
+				request := githubRequest{Method: r.Method, Path: r.URL.Path}
+				if r.Method == http.MethodGet {
+					if r.URL.Query().Get("labels") != "sandbox-purge-report" {
+						t.Errorf("unexpected issue query: %s", r.URL.RawQuery)
+					}
+					w.Write([]byte(test.issues))
+				} else {
+					if err := json.NewDecoder(r.Body).Decode(&request.Body); err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"number": 8}`))
+				}
+				requests = append(requests, request)
+			}))
+			defer server.Close()
+
+			p := &githubPublisher{
+				options: GitHubOptions{
+					GitHubReportPublish: githubPublishIssue,
+					GitHubToken:         "token",
+					GitHubAPIURL:        server.URL,
+					GitHubRepo:          "18f/sandbox-reports",
+					GitHubIssueLabel:    "sandbox-purge-report",
+					GitHubIssueTitle:    "Sandbox purge run reports",
+				},
+				httpClient: server.Client(),
+			}
+			report := Report{StartedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), DryRun: true}
+			if err := p.publish(context.Background(), report); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var got []string
+			for _, request := range requests {
+				got = append(got, request.Method+" "+request.Path)
+			}
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Fatalf("publish() requests mismatch (-want +got):\n%s", diff)
+			}
+			body, _ := requests[1].Body["body"].(string)
+			if !strings.HasPrefix(body, "## Run 2024-03-01T12:00:00Z (dry run)\n\n") {
+				t.Errorf("unexpected report body: %q", body)
+			}
+		})
+	}
+}
+
+func TestGitHubPublisherGist(t *testing.T) {
+	testCases := map[string]struct {
+		gistID   string
+		expected string
+	}{
+		"creates gist": {expected: "POST /gists"},
+		"updates gist": {gistID: "abc123", expected: "PATCH /gists/abc123"},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var requests []githubRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				request := githubRequest{Method: r.Method, Path: r.URL.Path}
+				if err := json.NewDecoder(r.Body).Decode(&request.Body); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				requests = append(requests, request)
+				w.Write([]byte(`{"id": "abc123"}`))
+			}))
+			defer server.Close()
+
+			p := &githubPublisher{
+				options: GitHubOptions{
+					GitHubReportPublish: githubPublishGist,
+					GitHubToken:         "token",
+					GitHubAPIURL:        server.URL,
+					GitHubGistID:        test.gistID,
+				},
+				httpClient: server.Client(),
+			}
+			report := Report{StartedAt: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+			if err := p.publish(context.Background(), report); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(requests) != 1 || requests[0].Method+" "+requests[0].Path != test.expected {
+				t.Fatalf("expected request %s, got %+v", test.expected, requests)
+			}
+			files, _ := requests[0].Body["files"].(map[string]interface{})
+			if _, ok := files["sandbox-purge-20240301T120000Z.md"]; !ok {
+				t.Errorf("expected a file named for the run, got %+v", files)
+			}
+		})
+	}
+}
+
+func TestTruncateGitHubBody(t *testing.T) {
+	body := strings.Repeat("| row |\n", githubBodyLimit/4)
+	got := truncateGitHubBody(body)
+	if len(got) > githubBodyLimit {
+		t.Errorf("expected at most %d bytes, got %d", githubBodyLimit, len(got))
+	}
+	if !strings.HasSuffix(got, "| row |\n\n_Report truncated; see the job's report file for the rest._\n") {
+		t.Errorf("expected the body cut at a line with a note, got %q", got[len(got)-100:])
+	}
+	if truncateGitHubBody("short") != "short" {
+		t.Error("expected a short body to be unchanged")
+	}
+}
@@ -0,0 +1,219 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GraphOptions describes configuration for sending mail through the
+// Microsoft Graph API with OAuth client credentials; the URLs can point at
+// national clouds such as GCC High
+type GraphOptions struct {
+	GraphTenantID     string `env:"GRAPH_TENANT_ID"`
+	GraphClientID     string `env:"GRAPH_CLIENT_ID"`
+	GraphClientSecret string `env:"GRAPH_CLIENT_SECRET"`
+	GraphAuthorityURL string `env:"GRAPH_AUTHORITY_URL, default=https://login.microsoftonline.com"`
+	GraphAPIURL       string `env:"GRAPH_API_URL, default=https://graph.microsoft.com/v1.0"`
+}
+
+// graphTokenExpiryMargin is how long before expiry a cached token is refreshed
+const graphTokenExpiryMargin = time.Minute
+
+// graphMailer sends mail as the MAIL_SENDER mailbox through Graph sendMail;
+// the app registration needs the Mail.Send application permission
+type graphMailer struct {
+	options    GraphOptions
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+type graphEmailAddress struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+type graphRecipient struct {
+	EmailAddress graphEmailAddress `json:"emailAddress"`
+}
+
+type graphAttachment struct {
+	ODataType    string `json:"@odata.type"`
+	Name         string `json:"name"`
+	ContentType  string `json:"contentType"`
+	ContentBytes string `json:"contentBytes"`
+}
+
+type graphMessage struct {
+	// InternetMessageID is the only threading header Graph lets a sender set
+	InternetMessageID string `json:"internetMessageId,omitempty"`
+	Subject           string `json:"subject"`
+	Body              struct {
+		ContentType string `json:"contentType"`
+		Content     string `json:"content"`
+	} `json:"body"`
+	From         graphRecipient    `json:"from"`
+	ToRecipients []graphRecipient  `json:"toRecipients"`
+	Attachments  []graphAttachment `json:"attachments,omitempty"`
+}
+
+// sendMail sends email via the Graph API
+func (m *graphMailer) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return fmt.Errorf("error parsing sender %s: %w", sender, err)
+	}
+	message := graphMessage{
+		InternetMessageID: thread.MessageID,
+		Subject:           subject,
+		From:              graphRecipient{EmailAddress: graphEmailAddress{Name: from.Name, Address: from.Address}},
+	}
+	message.Body.ContentType = "HTML"
+	message.Body.Content = body
+	for _, recipient := range recipients {
+		message.ToRecipients = append(message.ToRecipients, graphRecipient{EmailAddress: graphEmailAddress{Address: recipient}})
+	}
+	for _, attachment := range attachments {
+		message.Attachments = append(message.Attachments, graphAttachment{
+			ODataType:    "#microsoft.graph.fileAttachment",
+			Name:         attachment.Name,
+			ContentType:  attachment.ContentType,
+			ContentBytes: base64.StdEncoding.EncodeToString(attachment.Content),
+		})
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"message":         message,
+		"saveToSentItems": false,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding Graph message: %w", err)
+	}
+
+	token, err := m.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/users/%s/sendMail", strings.TrimSuffix(m.options.GraphAPIURL, "/"), url.PathEscape(from.Address))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status sending mail via Graph: %s%s", resp.Status, graphErrorMessage(resp.Body))
+	}
+	return nil
+}
+
+// accessToken returns a cached client credentials token, requesting a new
+// one when it is missing or about to expire
+func (m *graphMailer) accessToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" && time.Now().Add(graphTokenExpiryMargin).Before(m.tokenExpiry) {
+		return m.token, nil
+	}
+
+	scope, err := graphScope(m.options.GraphAPIURL)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {m.options.GraphClientID},
+		"client_secret": {m.options.GraphClientSecret},
+		"scope":         {scope},
+	}
+	endpoint := fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(m.options.GraphAuthorityURL, "/"), url.PathEscape(m.options.GraphTenantID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting Graph token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status requesting Graph token: %s%s", resp.Status, graphErrorMessage(resp.Body))
+	}
+	result := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error decoding Graph token: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("no access token in Graph token response")
+	}
+	m.token = result.AccessToken
+	m.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	return m.token, nil
+}
+
+// graphScope returns the client credentials scope for a Graph API URL,
+// such as https://graph.microsoft.com/.default
+func graphScope(apiURL string) (string, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid GRAPH_API_URL %s", apiURL)
+	}
+	return fmt.Sprintf("%s://%s/.default", u.Scheme, u.Host), nil
+}
+
+// graphErrorMessage extracts the description from a Graph or Entra ID error
+// response, if there is one
+func graphErrorMessage(body io.Reader) string {
+	result := struct {
+		Error            json.RawMessage `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+	}{}
+	contents, err := io.ReadAll(io.LimitReader(body, 64*1024))
+	if err != nil || json.Unmarshal(contents, &result) != nil {
+		return ""
+	}
+	if result.ErrorDescription != "" {
+		return ": " + result.ErrorDescription
+	}
+	graphErr := struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{}
+	if json.Unmarshal(result.Error, &graphErr) != nil || graphErr.Message == "" {
+		return ""
+	}
+	return fmt.Sprintf(": %s: %s", graphErr.Code, graphErr.Message)
+}
@@ -0,0 +1,180 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGraphMailer(t *testing.T) {
+	tokenRequests := 0
+	var messages []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tenant-1/oauth2/v2.0/token":
+			tokenRequests++
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			if r.PostForm.Get("grant_type") != "client_credentials" || r.PostForm.Get("client_secret") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error": "invalid_client", "error_description": "bad secret"}`)
+				return
+			}
+			if scope := r.PostForm.Get("scope"); scope != "http://"+r.Host+"/.default" {
+				t.Errorf("unexpected scope: %s", scope)
+			}
+			fmt.Fprint(w, `{"access_token": "token", "expires_in": 3600}`)
+		case "/v1.0/users/no-reply@cloud.gov/sendMail":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				t.Errorf("unexpected authorization header: %s", r.Header.Get("Authorization"))
+			}
+			message := map[string]interface{}{}
+			if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+				t.Fatal(err)
+			}
+			messages = append(messages, message)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error": {"code": "ErrorAccessDenied", "message": "Access is denied."}}`)
+		}
+	}))
+	defer server.Close()
+
+	newMailer := func(secret string) *graphMailer {
+		return &graphMailer{
+			options: GraphOptions{
+				GraphTenantID:     "tenant-1",
+				GraphClientID:     "client-1",
+				GraphClientSecret: secret,
+				GraphAuthorityURL: server.URL,
+				GraphAPIURL:       server.URL + "/v1.0",
+			},
+			httpClient: server.Client(),
+		}
+	}
+	attachment := mailAttachment{Name: "manifest.yml", ContentType: "text/yaml", Content: []byte("applications: []\n")}
+	expectedMessage := map[string]interface{}{
+		"saveToSentItems": false,
+		"message": map[string]interface{}{
+			"internetMessageId": "<sandbox.space-1@cloud.gov>",
+			"subject":           "Purge warning",
+			"body":              map[string]interface{}{"contentType": "HTML", "content": "<p>hi</p>"},
+			"from": map[string]interface{}{
+				"emailAddress": map[string]interface{}{"name": "cloud.gov", "address": "no-reply@cloud.gov"},
+			},
+			"toRecipients": []interface{}{
+				map[string]interface{}{"emailAddress": map[string]interface{}{"address": "foo@agency.gov"}},
+			},
+			"attachments": []interface{}{
+				map[string]interface{}{
+					"@odata.type":  "#microsoft.graph.fileAttachment",
+					"name":         "manifest.yml",
+					"contentType":  "text/yaml",
+					"contentBytes": "YXBwbGljYXRpb25zOiBbXQo=",
+				},
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		mailer         *graphMailer
+		sender         string
+		expectedErr    string
+		expectedTokens int
+		expected       []map[string]interface{}
+	}{
+		"sends each message with a cached token": {
+			mailer:         newMailer("secret"),
+			sender:         "cloud.gov <no-reply@cloud.gov>",
+			expectedTokens: 1,
+			expected:       []map[string]interface{}{expectedMessage, expectedMessage},
+		},
+		"token error": {
+			mailer:         newMailer("wrong"),
+			sender:         "no-reply@cloud.gov",
+			expectedTokens: 1,
+			expectedErr:    "unexpected status requesting Graph token: 401 Unauthorized: bad secret",
+		},
+		"send error": {
+			mailer:         newMailer("secret"),
+			sender:         "other@cloud.gov",
+			expectedTokens: 1,
+			expectedErr:    "unexpected status sending mail via Graph: 403 Forbidden: ErrorAccessDenied: Access is denied.",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tokenRequests = 0
+			messages = nil
+			if err := test.mailer.sendMail(context.Background(), SMTPOptions{}, test.sender, "Purge warning", "<p>hi</p>", mailThread{}, nil); err != nil {
+				t.Fatalf("unexpected error without recipients: %s", err)
+			}
+			var err error
+			for i := 0; i < 2 && err == nil; i++ {
+				err = test.mailer.sendMail(context.Background(), SMTPOptions{}, test.sender, "Purge warning", "<p>hi</p>", mailThread{MessageID: "<sandbox.space-1@cloud.gov>"}, []string{"foo@agency.gov"}, attachment)
+			}
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error %q, got: %v", test.expectedErr, err)
+			}
+			if tokenRequests != test.expectedTokens {
+				t.Errorf("expected %d token requests, got %d", test.expectedTokens, tokenRequests)
+			}
+			if diff := cmp.Diff(test.expected, messages); diff != "" {
+				t.Errorf("sendMail() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewMailTransport(t *testing.T) {
+	testCases := map[string]struct {
+		opts        Config
+		expected    string
+		expectedErr string
+	}{
+		"smtp": {
+			opts: Config{
+				SMTPOptions: SMTPOptions{SMTPHost: "smtp.example.com", SMTPUser: "user", SMTPPass: "pass"},
+				MailOptions: MailOptions{MailTransport: mailTransportSMTP},
+			},
+			expected: "*purge.smtpMailer",
+		},
+		"smtp without credentials": {
+			opts:        Config{MailOptions: MailOptions{MailTransport: mailTransportSMTP}},
+			expectedErr: "SMTP_HOST, SMTP_USER, and SMTP_PASS are required for mail transport smtp",
+		},
+		"graph": {
+			opts: Config{
+				GraphOptions: GraphOptions{GraphTenantID: "tenant", GraphClientID: "client", GraphClientSecret: "secret", GraphAPIURL: "https://graph.microsoft.us/v1.0"},
+				MailOptions:  MailOptions{MailTransport: mailTransportGraph},
+			},
+			expected: "*purge.graphMailer",
+		},
+		"graph without credentials": {
+			opts:        Config{MailOptions: MailOptions{MailTransport: mailTransportGraph}},
+			expectedErr: "GRAPH_TENANT_ID, GRAPH_CLIENT_ID, and GRAPH_CLIENT_SECRET are required for mail transport graph",
+		},
+		"unknown": {
+			opts:        Config{MailOptions: MailOptions{MailTransport: "carrier-pigeon"}},
+			expectedErr: "unknown mail transport carrier-pigeon",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			transport, err := newMailTransport(test.opts)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error %q, got: %v", test.expectedErr, err)
+			}
+			if err == nil && fmt.Sprintf("%T", transport) != test.expected {
+				t.Errorf("expected %s, got %T", test.expected, transport)
+			}
+		})
+	}
+}
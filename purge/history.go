@@ -0,0 +1,157 @@
+package purge
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// noticeRetention is how long notices are kept in state, long enough to
+	// answer disputes about a purge a year back
+	noticeRetention = 400 * 24 * time.Hour
+	// maxNotices bounds the notices kept in state, at a few hundred bytes
+	// each, so a busy foundation can't grow the state file without limit;
+	// the oldest are dropped first
+	maxNotices = 50000
+)
+
+// Notice is a purge warning, welcome, or purge that a run sent or applied
+// to a space, kept in state for support lookups
+type Notice struct {
+	At              time.Time `json:"at"`
+	RunID           string    `json:"run_id"`
+	Action          string    `json:"action"`
+	Org             string    `json:"org"`
+	Space           string    `json:"space"`
+	SpaceGUID       string    `json:"space_guid"`
+	ServiceInstance string    `json:"service_instance,omitempty"`
+	// Users are the lowercased recipients of the action's emails and the
+	// users whose roles a purge re-added
+	Users []string `json:"users"`
+}
+
+// recordNotice remembers that an action was sent or applied, dropping
+// notices older than noticeRetention and the oldest beyond maxNotices
+func (s *State) recordNotice(action PlannedAction, runID string, at time.Time) {
+	if s == nil {
+		return
+	}
+	notice := Notice{
+		At:        at.UTC(),
+		RunID:     runID,
+		Action:    action.Action,
+		Org:       action.Org.Name,
+		Space:     action.Details.Space.Name,
+		SpaceGUID: action.Details.Space.GUID,
+		Users:     actionUsers(action),
+	}
+	if action.ServiceInstance != nil {
+		notice.ServiceInstance = action.ServiceInstance.Name
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.Notices[:0]
+	for _, previous := range s.Notices {
+		if at.Sub(previous.At) <= noticeRetention {
+			kept = append(kept, previous)
+		}
+	}
+	kept = append(kept, notice)
+	if len(kept) > maxNotices {
+		kept = kept[len(kept)-maxNotices:]
+	}
+	s.Notices = kept
+}
+
+// actionUsers returns the users an action affects, lowercased and sorted
+func actionUsers(action PlannedAction) []string {
+	seen := map[string]bool{}
+	var users []string
+	add := func(user string) {
+		user = strings.ToLower(user)
+		if user != "" && !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+	for _, recipient := range action.Recipients {
+		add(recipient)
+	}
+	for _, user := range action.Developers {
+		add(user.Username)
+	}
+	for _, user := range action.Managers {
+		add(user.Username)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// HistoryConfig describes configuration for looking up a user's notices
+type HistoryConfig struct {
+	StateFile   string `env:"STATE_FILE, required"`
+	HistoryUser string
+}
+
+// UserHistory is every notice affecting a user's spaces, oldest first
+type UserHistory struct {
+	User    string   `json:"user"`
+	Notices []Notice `json:"notices"`
+}
+
+// History looks up the notices affecting a user in the state file, to
+// answer support requests about a purge
+func History(cfg HistoryConfig) (UserHistory, error) {
+	if cfg.HistoryUser == "" {
+		return UserHistory{}, errors.New("a user is required")
+	}
+	// loading a missing state file starts a new state, which would report
+	// no notices rather than a mistyped path
+	if _, err := os.Stat(cfg.StateFile); err != nil {
+		return UserHistory{}, fmt.Errorf("error reading state file %s: %w", cfg.StateFile, err)
+	}
+	state, err := (&fileStateStore{path: cfg.StateFile}).load()
+	if err != nil {
+		return UserHistory{}, err
+	}
+	return state.userHistory(cfg.HistoryUser), nil
+}
+
+func (s *State) userHistory(user string) UserHistory {
+	history := UserHistory{User: user, Notices: []Notice{}}
+	user = strings.ToLower(user)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, notice := range s.Notices {
+		for _, affected := range notice.Users {
+			if affected == user {
+				history.Notices = append(history.Notices, notice)
+				break
+			}
+		}
+	}
+	sort.SliceStable(history.Notices, func(i, j int) bool {
+		return history.Notices[i].At.Before(history.Notices[j].At)
+	})
+	return history
+}
+
+// WriteText writes the history one notice per line
+func (h UserHistory) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d notices for %s\n", len(h.Notices), h.User)
+	for _, notice := range h.Notices {
+		target := notice.Org + "/" + notice.Space
+		if notice.ServiceInstance != "" {
+			target += " (service instance " + notice.ServiceInstance + ")"
+		}
+		fmt.Fprintf(&b, "  %s  %-14s  %s  run %s\n", notice.At.Format(time.RFC3339), notice.Action, target, notice.RunID)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,116 @@
+package purge
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecordNotice(t *testing.T) {
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	state := &State{
+		Spaces: map[string]*SpaceState{},
+		Notices: []Notice{
+			{At: now.Add(-noticeRetention - time.Hour), Action: planActionNotify, Users: []string{"old@agency.gov"}},
+			{At: now.AddDate(0, 0, -5), Action: planActionNotify, Users: []string{"jane.doe@agency.gov"}},
+		},
+	}
+	action := PlannedAction{
+		Action:     planActionPurge,
+		Org:        &resource.Organization{Name: "sandbox-agency"},
+		Details:    SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "jane.doe"}},
+		Developers: []spaceUser{{Username: "Jane.Doe@agency.gov"}, {Username: "dev@agency.gov"}},
+		Managers:   []spaceUser{{Username: "manager@agency.gov"}},
+		Recipients: []string{"jane.doe@agency.gov"},
+	}
+	state.recordNotice(action, "20240301T060000Z", now)
+
+	expected := []Notice{
+		{At: now.AddDate(0, 0, -5), Action: planActionNotify, Users: []string{"jane.doe@agency.gov"}},
+		{
+			At:        now,
+			RunID:     "20240301T060000Z",
+			Action:    planActionPurge,
+			Org:       "sandbox-agency",
+			Space:     "jane.doe",
+			SpaceGUID: "space-1",
+			Users:     []string{"dev@agency.gov", "jane.doe@agency.gov", "manager@agency.gov"},
+		},
+	}
+	if diff := cmp.Diff(expected, state.Notices); diff != "" {
+		t.Errorf("notices mismatch (-want +got):\n%s", diff)
+	}
+
+	var nilState *State
+	nilState.recordNotice(action, "20240301T060000Z", now)
+}
+
+func TestRecordNoticeBounded(t *testing.T) {
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	state := &State{Spaces: map[string]*SpaceState{}}
+	for i := 0; i < maxNotices; i++ {
+		state.Notices = append(state.Notices, Notice{At: now.Add(time.Duration(i-maxNotices) * time.Second), RunID: fmt.Sprintf("run-%d", i)})
+	}
+	action := PlannedAction{
+		Action:  planActionNotify,
+		Org:     &resource.Organization{Name: "sandbox-agency"},
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "jane.doe"}},
+	}
+	state.recordNotice(action, "latest", now)
+
+	if len(state.Notices) != maxNotices {
+		t.Fatalf("expected %d notices, got %d", maxNotices, len(state.Notices))
+	}
+	if first, last := state.Notices[0].RunID, state.Notices[maxNotices-1].RunID; first != "run-1" || last != "latest" {
+		t.Errorf("expected the oldest notice dropped, got first %s and last %s", first, last)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	notified := time.Date(2024, 2, 1, 6, 0, 0, 0, time.UTC)
+	purged := time.Date(2024, 2, 6, 6, 0, 0, 0, time.UTC)
+	state := &State{
+		Spaces: map[string]*SpaceState{},
+		Notices: []Notice{
+			{At: purged, RunID: "20240206T060000Z", Action: planActionPurge, Org: "sandbox-agency", Space: "jane.doe", SpaceGUID: "space-1", Users: []string{"jane.doe@agency.gov"}},
+			{At: notified, RunID: "20240201T060000Z", Action: planActionNotify, Org: "sandbox-agency", Space: "jane.doe", SpaceGUID: "space-1", Users: []string{"jane.doe@agency.gov"}},
+			{At: notified, RunID: "20240201T060000Z", Action: planActionNotify, Org: "sandbox-agency", Space: "john.doe", SpaceGUID: "space-2", Users: []string{"john.doe@agency.gov"}},
+		},
+	}
+	contents, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := History(HistoryConfig{StateFile: path}); err == nil {
+		t.Error("expected an error without a user")
+	}
+	if _, err := History(HistoryConfig{StateFile: filepath.Join(t.TempDir(), "missing.json"), HistoryUser: "jane.doe@agency.gov"}); err == nil {
+		t.Error("expected an error for a missing state file")
+	}
+	history, err := History(HistoryConfig{StateFile: path, HistoryUser: "Jane.Doe@agency.gov"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var b strings.Builder
+	if err := history.WriteText(&b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "2 notices for Jane.Doe@agency.gov\n" +
+		"  2024-02-01T06:00:00Z  notify          sandbox-agency/jane.doe  run 20240201T060000Z\n" +
+		"  2024-02-06T06:00:00Z  purge           sandbox-agency/jane.doe  run 20240206T060000Z\n"
+	if diff := cmp.Diff(expected, b.String()); diff != "" {
+		t.Errorf("WriteText mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,183 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// spaceInstances is a space and the service instances in it that are past
+// INSTANCE_PURGE_DAYS, or COSTLY_INSTANCE_PURGE_DAYS for expensive plans
+type spaceInstances struct {
+	Space     *resource.Space
+	Instances []*resource.ServiceInstance
+}
+
+// instanceAge returns the creation day of a service instance, moved up to
+// timeStartsAt if earlier, and its age in days
+func instanceAge(instance *resource.ServiceInstance, now time.Time, timeStartsAt time.Time) (time.Time, int) {
+	created := instance.CreatedAt
+	if timeStartsAt.After(created) {
+		created = timeStartsAt
+	}
+	created = created.Truncate(24 * time.Hour)
+	return created, int(now.Sub(created).Hours() / 24)
+}
+
+// listAgedInstances identifies service instances past INSTANCE_PURGE_DAYS,
+// or COSTLY_INSTANCE_PURGE_DAYS for expensive plans, in spaces that aren't
+// already being purged in full
+func listAgedInstances(
+	inventory *collect.Inventory,
+	toPurge []SpaceDetails,
+	opts Config,
+	now time.Time,
+	timeStartsAt time.Time,
+) []spaceInstances {
+	if opts.InstancePurgeDays <= 0 && !opts.costlyInstancePurgeEnabled() {
+		return nil
+	}
+	purging := map[string]bool{}
+	for _, details := range toPurge {
+		purging[details.Space.GUID] = true
+	}
+
+	var aged []spaceInstances
+	for _, space := range inventory.Spaces {
+		if purging[space.Space.GUID] {
+			continue
+		}
+		var old []*resource.ServiceInstance
+		for _, instance := range space.ServiceInstances {
+			days, _ := opts.instancePurgeDays(instance)
+			if _, age := instanceAge(instance, now, timeStartsAt); days > 0 && age >= days {
+				old = append(old, instance)
+			}
+		}
+		if len(old) > 0 {
+			aged = append(aged, spaceInstances{Space: space.Space, Instances: old})
+		}
+	}
+	return aged
+}
+
+// planPurgeInstances looks up the recipients for a space once and plans the
+// deletion of each of its aged service instances
+func planPurgeInstances(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	userGUIDs map[string]bool,
+	rosters spaceRosters,
+	org *resource.Organization,
+	aged spaceInstances,
+	now time.Time,
+	timeStartsAt time.Time,
+) ([]PlannedAction, error) {
+	spaceUsers, err := rosters.spaceUsers(ctx, cfClient, aged.Space)
+	if err != nil {
+		return nil, err
+	}
+	recipients, err := listRecipients(userGUIDs, spaceUsers)
+	if err != nil {
+		return nil, fmt.Errorf("error listing recipients on space %s: %w", aged.Space.Name, err)
+	}
+
+	actions := make([]PlannedAction, 0, len(aged.Instances))
+	for _, instance := range aged.Instances {
+		created, _ := instanceAge(instance, now, timeStartsAt)
+		_, cost := opts.instancePurgeDays(instance)
+		logFields{Org: org.Name, Space: aged.Space.Name, Action: planActionPurgeInstance}.printf("Deleting service instance %s in space %s; recipients %+v", instance.Name, aged.Space.Name, recipients)
+		actions = append(actions, PlannedAction{
+			Action:          planActionPurgeInstance,
+			Org:             org,
+			Details:         SpaceDetails{Timestamp: created, Space: aged.Space},
+			ServiceInstance: instance,
+			Recipients:      recipients,
+			Subject:         opts.InstancePurgeMailSubject,
+			InstanceCost:    cost,
+		})
+	}
+	return actions, nil
+}
+
+// applyPurgeInstance deletes a service instance's bindings, keys, and route
+// bindings and the instance itself, then emails the space's users; the email
+// says the instance is gone, so it's only sent once the delete completes, and
+// a failure to send it doesn't undo the purge
+func applyPurgeInstance(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	action PlannedAction,
+	mailSender mailer,
+	report *Report,
+) error {
+	if opts.DryRun {
+		return nil
+	}
+
+	org, space, instance := action.Org, action.Details.Space, action.ServiceInstance
+	tmpl, err := parseMailTemplate(opts.TemplateDir, purgeInstanceTemplateName)
+	if err != nil {
+		return fmt.Errorf("error reading purge instance template: %w", err)
+	}
+	body := renderSpaceMail(tmpl, purgeInstanceTemplateName, purgeInstanceTemplateData(opts, org, action.Details, instance, action.InstanceCost), mailSender, actionFields(action))
+	if err := deleteInstanceBindings(ctx, cfClient, space, instance); err != nil {
+		return err
+	}
+	unbound, err := unbindRouteServices(ctx, cfClient, space, nil, []*resource.ServiceInstance{instance})
+	for _, binding := range unbound {
+		report.RouteServicesUnbound = append(report.RouteServicesUnbound, org.Name+"/"+space.Name+": "+binding)
+	}
+	if err != nil {
+		return err
+	}
+
+	actionFields(action).printf("deleting service instance %s in space %s", instance.Name, space.Name)
+	jobGUID, err := cfClient.ServiceInstances.Delete(ctx, instance.GUID)
+	if isNotFoundError(err) {
+		return deletedDuringRun("service instance " + instance.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting service instance %s in space %s in org %s: %w", instance.Name, space.Name, org.Name, err)
+	}
+	if err := waitForDeprovisionJob(ctx, cfClient, opts, jobGUID); err != nil {
+		return fmt.Errorf("error waiting for delete job %s to be complete: %w", jobGUID, err)
+	}
+	report.InstancesPurged++
+
+	actionFields(action).printf("sending to %s: %s", action.Recipients, body)
+	thread := newMailThread(opts.MailSender, action.Details, "purge-instance-"+instance.GUID)
+	if err := mailSender.sendMail(ctx, opts.SMTPOptions, opts.MailSender, action.Subject, body, thread, action.Recipients); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("error notifying users of deleted service instance %s in org %s: error sending mail on space %s: %s", instance.Name, org.Name, space.Name, err))
+	}
+	return nil
+}
+
+// deleteInstanceBindings deletes a service instance's app bindings and
+// service keys, which CF requires before the instance can be deleted
+func deleteInstanceBindings(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+	instance *resource.ServiceInstance,
+) error {
+	bindingListOptions := client.NewServiceCredentialBindingListOptions()
+	bindingListOptions.ServiceInstanceGUIDs.EqualTo(instance.GUID)
+	bindings, err := cfClient.ServiceCredentialBindings.ListAll(ctx, bindingListOptions)
+	if err != nil {
+		return fmt.Errorf("error listing bindings for service instance %s in space %s: %w", instance.Name, space.Name, err)
+	}
+	for _, binding := range bindings {
+		logFields{Space: space.Name}.printf("deleting %s binding %s of service instance %s", binding.Type, binding.GUID, instance.Name)
+		if err := cfClient.ServiceCredentialBindings.Delete(ctx, binding.GUID); err != nil && !isNotFoundError(err) {
+			return fmt.Errorf("error deleting binding %s of service instance %s in space %s: %w", binding.GUID, instance.Name, space.Name, err)
+		}
+	}
+	return nil
+}
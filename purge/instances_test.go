@@ -0,0 +1,153 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockServiceCredentialBindings struct {
+	bindings     []*resource.ServiceCredentialBinding
+	deleteErr    error
+	deletedGUIDs []string
+}
+
+func (b *mockServiceCredentialBindings) ListAll(ctx context.Context, opts *client.ServiceCredentialBindingListOptions) ([]*resource.ServiceCredentialBinding, error) {
+	return b.bindings, nil
+}
+
+func (b *mockServiceCredentialBindings) Delete(ctx context.Context, guid string) error {
+	b.deletedGUIDs = append(b.deletedGUIDs, guid)
+	return b.deleteErr
+}
+
+func TestListAgedInstances(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	spaces := []*resource.Space{
+		{GUID: "space-1", Name: "foo"},
+		{GUID: "space-2", Name: "purging"},
+	}
+	old := instanceInSpace("instance-1", "space-1")
+	old.CreatedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := instanceInSpace("instance-2", "space-1")
+	recent.CreatedAt = time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC)
+	recent.Relationships.ServicePlan = &resource.ToOneRelationship{Data: &resource.Relationship{GUID: "plan-large"}}
+	purging := instanceInSpace("instance-3", "space-2")
+	purging.CreatedAt = time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	instances := []*resource.ServiceInstance{old, recent, purging}
+	toPurge := []SpaceDetails{{Space: spaces[1]}}
+
+	testCases := map[string]struct {
+		opts         Config
+		timeStartsAt time.Time
+		expected     []spaceInstances
+	}{
+		"disabled": {
+			opts: Config{},
+		},
+		"past threshold": {
+			opts:     Config{InstancePurgeDays: 60},
+			expected: []spaceInstances{{Space: spaces[0], Instances: []*resource.ServiceInstance{old}}},
+		},
+		"time starts at": {
+			opts:         Config{InstancePurgeDays: 60},
+			timeStartsAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		"costly plan": {
+			opts: Config{
+				PlanCostOptions: PlanCostOptions{CostlyInstancePurgeDays: 7, CostlyInstanceMinCost: 100},
+				planCosts:       map[string]float64{"plan-large": 400},
+			},
+			expected: []spaceInstances{{Space: spaces[0], Instances: []*resource.ServiceInstance{recent}}},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			aged := listAgedInstances(collect.New(&resource.Organization{}, collect.Resources{Spaces: spaces, ServiceInstances: instances}), toPurge, test.opts, now, test.timeStartsAt)
+			if diff := cmp.Diff(test.expected, aged); diff != "" {
+				t.Errorf("listAgedInstances() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyPurgeInstance(t *testing.T) {
+	action := PlannedAction{
+		Action:          planActionPurgeInstance,
+		Org:             &resource.Organization{Name: "sandbox-org"},
+		Details:         SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+		ServiceInstance: &resource.ServiceInstance{GUID: "instance-1", Name: "db"},
+		Recipients:      []string{"foo@bar.gov"},
+	}
+	bindings := []*resource.ServiceCredentialBinding{{GUID: "binding-1"}, {GUID: "key-1"}}
+
+	testCases := map[string]struct {
+		opts              Config
+		deleteBindingErr  error
+		deleteInstanceErr error
+		expectedDeleted   []string
+		expectedMail      []string
+		expectedPurged    int
+		expectedUnbound   []string
+		expectedErr       string
+	}{
+		"dry run": {
+			opts: Config{DryRun: true},
+		},
+		"deletes bindings then instance": {
+			opts:            Config{TemplateDir: "../templates", InstancePurgeDays: 60},
+			expectedDeleted: []string{"instance-1"},
+			expectedMail:    []string{"foo@bar.gov"},
+			expectedPurged:  1,
+			expectedUnbound: []string{"sandbox-org/foo: route route-1 from service instance db"},
+		},
+		"binding error": {
+			opts:             Config{TemplateDir: "../templates", InstancePurgeDays: 60},
+			deleteBindingErr: errors.New("boom"),
+			expectedErr:      "error deleting binding binding-1 of service instance db in space foo: boom",
+		},
+		"no email when the instance delete fails": {
+			opts:              Config{TemplateDir: "../templates", InstancePurgeDays: 60},
+			deleteInstanceErr: errors.New("boom"),
+			expectedDeleted:   []string{"instance-1"},
+			expectedUnbound:   []string{"sandbox-org/foo: route route-1 from service instance db"},
+			expectedErr:       "error deleting service instance db in space foo in org sandbox-org: boom",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instances := &mockServiceInstances{deleteJobGUID: "job-1", deleteErr: test.deleteInstanceErr}
+			cfClient := &cfResourceClient{
+				ServiceCredentialBindings: &mockServiceCredentialBindings{bindings: bindings, deleteErr: test.deleteBindingErr},
+				ServiceInstances:          instances,
+				ServiceRouteBindings:      &mockServiceRouteBindings{bindings: []*resource.ServiceRouteBinding{routeBinding("route-binding-1", "route-1", "instance-1")}, deleteJobGUID: "job-1"},
+				Jobs:                      &mockJobs{expectedJobGUID: "job-1"},
+			}
+			mailSender := &recordingMailer{}
+			report := &Report{}
+
+			err := applyPurgeInstance(context.Background(), cfClient, test.opts, action, mailSender, report)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error %q, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedDeleted, instances.deletedGUIDs); diff != "" {
+				t.Errorf("deleted instances mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedMail, mailSender.recipients); diff != "" {
+				t.Errorf("mail recipients mismatch (-want +got):\n%s", diff)
+			}
+			if report.InstancesPurged != test.expectedPurged {
+				t.Errorf("expected %d instances purged, got %d", test.expectedPurged, report.InstancesPurged)
+			}
+			if diff := cmp.Diff(test.expectedUnbound, report.RouteServicesUnbound); diff != "" {
+				t.Errorf("route services unbound mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
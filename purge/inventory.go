@@ -0,0 +1,262 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+const (
+	inventoryDecisionKeep    = "keep"
+	inventoryDecisionEmpty   = "empty"
+	inventoryDecisionSkipped = "notify-skipped"
+	// inventoryDecisionCustomQuota spaces were left for review because of
+	// their quota
+	inventoryDecisionCustomQuota = "custom-quota"
+)
+
+// InventoryOptions describes where to export the per-run space inventory
+type InventoryOptions struct {
+	InventoryBucket string `env:"INVENTORY_BUCKET"`
+	InventoryPrefix string `env:"INVENTORY_PREFIX, default=sandbox-inventory/"`
+	InventoryFile   string `env:"INVENTORY_FILE"`
+	S3Options
+}
+
+// enabled reports whether an inventory export is configured
+func (o InventoryOptions) enabled() bool {
+	return o.InventoryBucket != "" || o.InventoryFile != ""
+}
+
+// InventoryRecord describes a single sandbox space as seen by a run; records
+// are exported as newline-delimited JSON for loading into BigQuery or Redshift
+type InventoryRecord struct {
+	RunStartedAt     time.Time  `json:"run_started_at"`
+	Org              string     `json:"org"`
+	OrgGUID          string     `json:"org_guid"`
+	Space            string     `json:"space"`
+	SpaceGUID        string     `json:"space_guid"`
+	SpaceCreatedAt   time.Time  `json:"space_created_at"`
+	Apps             int        `json:"apps"`
+	ServiceInstances int        `json:"service_instances"`
+	Routes           int        `json:"routes"`
+	ServiceKeys      int        `json:"service_keys"`
+	FirstResource    *time.Time `json:"first_resource"`
+	AgeDays          *int       `json:"age_days"`
+	Owners           []string   `json:"owners"`
+	Decision         string     `json:"decision"`
+	// AppDetails describes how each of the space's apps is built and run
+	AppDetails []InventoryApp `json:"app_details"`
+}
+
+// InventoryApp describes an app's lifecycle as recorded in the inventory;
+// DockerImage and ProcessTypes come from the app's current droplet
+type InventoryApp struct {
+	Name         string   `json:"name"`
+	GUID         string   `json:"guid"`
+	Lifecycle    string   `json:"lifecycle"`
+	Buildpacks   []string `json:"buildpacks"`
+	Stack        string   `json:"stack"`
+	DockerImage  string   `json:"docker_image"`
+	ProcessTypes []string `json:"process_types"`
+}
+
+// listInventory describes every space in an org along with the decision
+// made by listPurgeSpaces
+func listInventory(
+	inventory *collect.Inventory,
+	details []SpaceDetails,
+	toNotify []SpaceDetails,
+	toPurge []SpaceDetails,
+	now time.Time,
+) []InventoryRecord {
+	decisions := map[string]string{}
+	for _, d := range toNotify {
+		decisions[d.Space.GUID] = planActionNotify
+	}
+	for _, d := range toPurge {
+		decisions[d.Space.GUID] = planActionPurge
+	}
+
+	spaces := map[string]*collect.SpaceInventory{}
+	for _, space := range inventory.Spaces {
+		spaces[space.Space.GUID] = space
+	}
+
+	records := make([]InventoryRecord, 0, len(details))
+	for _, d := range details {
+		space := spaces[d.Space.GUID]
+		if space == nil {
+			space = &collect.SpaceInventory{Space: d.Space}
+		}
+		record := InventoryRecord{
+			Org:              inventory.Org.Name,
+			OrgGUID:          inventory.Org.GUID,
+			Space:            d.Space.Name,
+			SpaceGUID:        d.Space.GUID,
+			SpaceCreatedAt:   d.Space.CreatedAt,
+			Apps:             len(space.Apps),
+			ServiceInstances: len(space.ServiceInstances),
+			Routes:           len(space.Routes),
+			ServiceKeys:      len(space.ServiceKeys),
+			Owners:           []string{},
+			Decision:         decisions[d.Space.GUID],
+			AppDetails:       []InventoryApp{},
+		}
+		for _, app := range space.Apps {
+			inventoryApp := InventoryApp{
+				Name:      app.Name,
+				GUID:      app.GUID,
+				Lifecycle: appLifecycleType(app),
+			}
+			if inventoryApp.Lifecycle != lifecycleDocker {
+				inventoryApp.Buildpacks = app.Lifecycle.BuildpackData.Buildpacks
+				inventoryApp.Stack = app.Lifecycle.BuildpackData.Stack
+			}
+			record.AppDetails = append(record.AppDetails, inventoryApp)
+		}
+		if !d.Timestamp.IsZero() {
+			firstResource := d.Timestamp
+			age := int(now.Sub(firstResource).Hours() / 24)
+			record.FirstResource = &firstResource
+			record.AgeDays = &age
+		}
+		if record.Decision == "" {
+			record.Decision = inventoryDecisionKeep
+			if d.Timestamp.IsZero() {
+				record.Decision = inventoryDecisionEmpty
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// completeInventory fills in each record's owners, reusing the recipients of
+// planned actions and listing space rosters in batches for the rest, fills in its apps'
+// Docker images and process types from their droplets, and marks warnings
+// that were held back by a recurrence policy; spaces are listed
+// cfRequestConcurrency at a time
+func completeInventory(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	userGUIDs map[string]bool,
+	records []InventoryRecord,
+	actions []PlannedAction,
+) error {
+	planned := map[string]PlannedAction{}
+	for _, action := range actions {
+		if action.Details.Space != nil && action.ServiceInstance == nil {
+			planned[action.Details.Space.GUID] = action
+		}
+	}
+
+	var unplanned []string
+	for _, record := range records {
+		if _, ok := planned[record.SpaceGUID]; !ok {
+			unplanned = append(unplanned, record.SpaceGUID)
+		}
+	}
+	rosters, err := listSpaceRosters(ctx, cfClient, unplanned)
+	if err != nil {
+		return err
+	}
+
+	var tasks []func(context.Context) error
+	for i := range records {
+		record := &records[i]
+		if len(record.AppDetails) > 0 {
+			tasks = append(tasks, func(ctx context.Context) error {
+				droplets, err := listCurrentDroplets(ctx, cfClient, record.SpaceGUID)
+				if err != nil {
+					return fmt.Errorf("error listing droplets in space %s: %w", record.Space, err)
+				}
+				for j := range record.AppDetails {
+					app := &record.AppDetails[j]
+					app.DockerImage = dropletImage(droplets[app.GUID])
+					app.ProcessTypes = dropletProcessTypes(droplets[app.GUID])
+				}
+				return nil
+			})
+		}
+		if action, ok := planned[record.SpaceGUID]; ok {
+			record.Owners = action.Recipients
+			continue
+		}
+		if record.Decision == planActionNotify {
+			record.Decision = inventoryDecisionSkipped
+		}
+		tasks = append(tasks, func(ctx context.Context) error {
+			spaceUsers, err := rosters.spaceUsers(ctx, cfClient, &resource.Space{GUID: record.SpaceGUID, Name: record.Space})
+			if errors.Is(err, errDeletedDuringRun) {
+				logFields{Org: record.Org, Space: record.Space}.printf("space %s was deleted during the run; leaving its owners out of the inventory", record.Space)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			owners, err := listRecipients(userGUIDs, spaceUsers)
+			if err != nil {
+				return fmt.Errorf("error listing owners on space %s: %w", record.Space, err)
+			}
+			record.Owners = owners
+			return nil
+		})
+	}
+	return runConcurrently(ctx, cfRequestConcurrency, tasks...)
+}
+
+// encodeInventory writes records as newline-delimited JSON
+func encodeInventory(records []InventoryRecord, runStartedAt time.Time) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	for _, record := range records {
+		record.RunStartedAt = runStartedAt
+		if err := encoder.Encode(record); err != nil {
+			return nil, fmt.Errorf("error encoding inventory: %w", err)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// inventoryKey returns the object key for a run's inventory, partitioned by
+// day so external tables can prune by date
+func inventoryKey(prefix string, runStartedAt time.Time) string {
+	runStartedAt = runStartedAt.UTC()
+	return path.Join(
+		prefix,
+		"dt="+runStartedAt.Format("2006-01-02"),
+		"inventory-"+runStartedAt.Format("20060102T150405Z")+".ndjson",
+	)
+}
+
+// exportInventory writes a run's inventory to INVENTORY_FILE and to
+// INVENTORY_BUCKET, whichever are configured
+func exportInventory(ctx context.Context, opts InventoryOptions, records []InventoryRecord, runStartedAt time.Time) error {
+	contents, err := encodeInventory(records, runStartedAt)
+	if err != nil {
+		return err
+	}
+	if opts.InventoryFile != "" {
+		if err := os.WriteFile(opts.InventoryFile, contents, 0644); err != nil {
+			return fmt.Errorf("error writing inventory %s: %w", opts.InventoryFile, err)
+		}
+		logFields{}.printf("wrote inventory of %d spaces to %s", len(records), opts.InventoryFile)
+	}
+	if opts.InventoryBucket != "" {
+		key := inventoryKey(opts.InventoryPrefix, runStartedAt)
+		if err := newS3Client(opts.S3Options).putObject(ctx, opts.InventoryBucket, key, "application/x-ndjson", contents); err != nil {
+			return err
+		}
+		logFields{}.printf("wrote inventory of %d spaces to s3://%s/%s", len(records), opts.InventoryBucket, key)
+	}
+	return nil
+}
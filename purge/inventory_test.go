@@ -0,0 +1,168 @@
+package purge
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func TestListInventory(t *testing.T) {
+	now := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-org"}
+	inSpace := func(guid string) resource.SpaceRelationship {
+		return resource.SpaceRelationship{
+			Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: guid}},
+		}
+	}
+	spaces := []*resource.Space{
+		{GUID: "space-1", Name: "old"},
+		{GUID: "space-2", Name: "new"},
+		{GUID: "space-3", Name: "empty"},
+	}
+	apps := []*resource.App{
+		{GUID: "app-1", Relationships: inSpace("space-1")},
+		{GUID: "app-2", Name: "proxy", Relationships: inSpace("space-2"), Lifecycle: resource.Lifecycle{Type: "docker"}},
+	}
+	apps[0].Lifecycle.BuildpackData = resource.BuildpackLifecycle{Buildpacks: []string{"python_buildpack"}, Stack: "cflinuxfs4"}
+	instances := []*resource.ServiceInstance{
+		{GUID: "instance-1", Relationships: resource.ServiceInstanceRelationships{
+			Space: &resource.ToOneRelationship{Data: &resource.Relationship{GUID: "space-1"}},
+		}},
+	}
+	details := []SpaceDetails{
+		{Timestamp: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC), Space: spaces[0]},
+		{Timestamp: time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC), Space: spaces[1]},
+		{Space: spaces[2]},
+	}
+
+	records := listInventory(collect.New(org, collect.Resources{Spaces: spaces, Apps: apps, ServiceInstances: instances}), details, nil, details[:1], now)
+	expected := []InventoryRecord{
+		{
+			Org:              "sandbox-org",
+			OrgGUID:          "org-1",
+			Space:            "old",
+			SpaceGUID:        "space-1",
+			Apps:             1,
+			ServiceInstances: 1,
+			FirstResource:    timePtr(time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)),
+			AgeDays:          intPtr(61),
+			Owners:           []string{},
+			Decision:         planActionPurge,
+			AppDetails: []InventoryApp{{
+				GUID:       "app-1",
+				Lifecycle:  lifecycleBuildpack,
+				Buildpacks: []string{"python_buildpack"},
+				Stack:      "cflinuxfs4",
+			}},
+		},
+		{
+			Org:           "sandbox-org",
+			OrgGUID:       "org-1",
+			Space:         "new",
+			SpaceGUID:     "space-2",
+			Apps:          1,
+			FirstResource: timePtr(time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC)),
+			AgeDays:       intPtr(10),
+			Owners:        []string{},
+			Decision:      inventoryDecisionKeep,
+			AppDetails:    []InventoryApp{{Name: "proxy", GUID: "app-2", Lifecycle: lifecycleDocker}},
+		},
+		{
+			Org:        "sandbox-org",
+			OrgGUID:    "org-1",
+			Space:      "empty",
+			SpaceGUID:  "space-3",
+			Owners:     []string{},
+			Decision:   inventoryDecisionEmpty,
+			AppDetails: []InventoryApp{},
+		},
+	}
+	if diff := cmp.Diff(expected, records); diff != "" {
+		t.Errorf("listInventory() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompleteInventory(t *testing.T) {
+	records := []InventoryRecord{
+		{Space: "purging", SpaceGUID: "space-1", Decision: planActionPurge},
+		{Space: "skipped", SpaceGUID: "space-2", Decision: planActionNotify, AppDetails: []InventoryApp{{GUID: "app-1", Lifecycle: lifecycleDocker}}},
+	}
+	image := "nginx:1.25"
+	actions := []PlannedAction{{
+		Action:     planActionPurge,
+		Details:    SpaceDetails{Space: &resource.Space{GUID: "space-1"}},
+		Recipients: []string{"foo@bar.gov"},
+	}}
+	cfClient := &cfResourceClient{
+		Spaces: &mockSpaces{
+			spaceGUID: "space-2",
+			users:     []*resource.User{{GUID: "user-1", Username: "baz@bar.gov"}, {GUID: "client-1", Username: "robot"}},
+		},
+		Droplets: &mockDroplets{droplets: []*resource.Droplet{
+			{
+				CreatedAt:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				Relationships: resource.AppRelationship{App: resource.ToOneRelationship{Data: &resource.Relationship{GUID: "app-1"}}},
+			},
+			{
+				CreatedAt:     time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+				Image:         &image,
+				ProcessTypes:  map[string]string{"web": "", "worker": "nginx -g daemon off;"},
+				Relationships: resource.AppRelationship{App: resource.ToOneRelationship{Data: &resource.Relationship{GUID: "app-1"}}},
+			},
+		}},
+	}
+
+	err := completeInventory(context.Background(), cfClient, map[string]bool{"user-1": true}, records, actions)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []InventoryRecord{
+		{Space: "purging", SpaceGUID: "space-1", Decision: planActionPurge, Owners: []string{"foo@bar.gov"}},
+		{
+			Space:      "skipped",
+			SpaceGUID:  "space-2",
+			Decision:   inventoryDecisionSkipped,
+			Owners:     []string{"baz@bar.gov"},
+			AppDetails: []InventoryApp{{GUID: "app-1", Lifecycle: lifecycleDocker, DockerImage: "nginx:1.25", ProcessTypes: []string{"web", "worker"}}},
+		},
+	}
+	if diff := cmp.Diff(expected, records); diff != "" {
+		t.Errorf("completeInventory() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEncodeInventory(t *testing.T) {
+	runStartedAt := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	records := []InventoryRecord{
+		{Space: "foo", Owners: []string{}, Decision: inventoryDecisionEmpty},
+		{Space: "bar", Owners: []string{}, Decision: inventoryDecisionEmpty},
+	}
+
+	contents, err := encodeInventory(records, runStartedAt)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), contents)
+	}
+	if !strings.HasPrefix(lines[1], `{"run_started_at":"2024-01-31T12:00:00Z","org":"","org_guid":"","space":"bar",`) {
+		t.Errorf("unexpected record: %s", lines[1])
+	}
+	if expected := "sandbox-inventory/dt=2024-01-31/inventory-20240131T120000Z.ndjson"; inventoryKey("sandbox-inventory/", runStartedAt) != expected {
+		t.Errorf("expected key %s, got %s", expected, inventoryKey("sandbox-inventory/", runStartedAt))
+	}
+}
@@ -0,0 +1,233 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// Kinds of resources a failed space delete job can name as blocking it
+const (
+	blockerServiceInstance = "service instance"
+	// blockerServiceBroker is a service instance whose broker failed to
+	// deprovision it
+	blockerServiceBroker = "service broker"
+	blockerApp           = "app"
+)
+
+var (
+	blockingInstancePattern = regexp.MustCompile(`(?i)service instance:?\s+['"]?([^\s'":,]+)`)
+	blockingAppPattern      = regexp.MustCompile(`(?i)\bapp(?:lication)?:?\s+['"]?([^\s'":,]+)`)
+	serviceBrokerPattern    = regexp.MustCompile(`(?i)service broker`)
+)
+
+// deleteBlocker is a resource that a failed space delete job names as
+// blocking the delete
+type deleteBlocker struct {
+	Kind string
+	Name string
+}
+
+func (b deleteBlocker) String() string {
+	if b.Kind == blockerServiceBroker {
+		return "the service broker of service instance " + b.Name
+	}
+	return b.Kind + " " + b.Name
+}
+
+// spaceDeleteJobError reports a failed space delete job with every error the
+// job recorded, rather than only the first, and the resources they name
+type spaceDeleteJobError struct {
+	space    string
+	jobGUID  string
+	jobErrs  []resource.CloudFoundryError
+	blockers []deleteBlocker
+	err      error
+}
+
+func (e *spaceDeleteJobError) Error() string {
+	detail := e.err.Error()
+	if len(e.jobErrs) > 0 {
+		var details []string
+		for _, jobErr := range e.jobErrs {
+			details = append(details, fmt.Sprintf("%s: %s", jobErr.Title, strings.Join(strings.Fields(jobErr.Detail), " ")))
+		}
+		detail = strings.Join(details, "; ")
+	}
+	message := fmt.Sprintf("delete job %s for space %s failed: %s", e.jobGUID, e.space, detail)
+	if len(e.blockers) > 0 {
+		var blockers []string
+		for _, blocker := range e.blockers {
+			blockers = append(blockers, blocker.String())
+		}
+		message += fmt.Sprintf(" (blocked by %s)", strings.Join(blockers, ", "))
+	}
+	return message
+}
+
+func (e *spaceDeleteJobError) Unwrap() error {
+	return e.err
+}
+
+// blockersOf returns the names of the blockers of a kind
+func (e *spaceDeleteJobError) blockersOf(kind string) []string {
+	var names []string
+	for _, blocker := range e.blockers {
+		if blocker.Kind == kind && blocker.Name != "" {
+			names = append(names, blocker.Name)
+		}
+	}
+	return names
+}
+
+// describeDeleteJobFailure looks up the errors a failed space delete job
+// recorded; if the job can't be read, it falls back to the poll error
+func describeDeleteJobFailure(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+	jobGUID string,
+	pollErr error,
+) *spaceDeleteJobError {
+	failure := &spaceDeleteJobError{space: space.Name, jobGUID: jobGUID, err: pollErr}
+	job, err := cfClient.Jobs.Get(ctx, jobGUID)
+	var cfErr resource.CloudFoundryError
+	switch {
+	case err == nil && job != nil && len(job.Errors) > 0:
+		failure.jobErrs = job.Errors
+	case errors.As(pollErr, &cfErr):
+		failure.jobErrs = []resource.CloudFoundryError{cfErr}
+	}
+	failure.blockers = parseDeleteBlockers(failure.jobErrs)
+	return failure
+}
+
+// parseDeleteBlockers finds the service instances and apps named in a
+// delete job's errors, one nested failure per line; an instance named on a
+// line about its service broker is blocked by the broker
+func parseDeleteBlockers(jobErrs []resource.CloudFoundryError) []deleteBlocker {
+	var blockers []deleteBlocker
+	index := map[deleteBlocker]int{}
+	add := func(blocker deleteBlocker) {
+		if _, ok := index[blocker]; ok {
+			return
+		}
+		if blocker.Kind == blockerServiceBroker {
+			// the broker is what needs fixing, not the instance
+			if i, ok := index[deleteBlocker{Kind: blockerServiceInstance, Name: blocker.Name}]; ok {
+				blockers[i] = blocker
+				index[blocker] = i
+				return
+			}
+		}
+		if blocker.Kind == blockerServiceInstance {
+			if _, ok := index[deleteBlocker{Kind: blockerServiceBroker, Name: blocker.Name}]; ok {
+				return
+			}
+		}
+		index[blocker] = len(blockers)
+		blockers = append(blockers, blocker)
+	}
+	for _, jobErr := range jobErrs {
+		for _, line := range strings.Split(jobErr.Detail, "\n") {
+			instances := blockingInstancePattern.FindAllStringSubmatch(line, -1)
+			kind := blockerServiceInstance
+			if serviceBrokerPattern.MatchString(line) {
+				kind = blockerServiceBroker
+			}
+			for _, match := range instances {
+				add(deleteBlocker{Kind: kind, Name: match[1]})
+			}
+			if len(instances) > 0 {
+				continue
+			}
+			for _, match := range blockingAppPattern.FindAllStringSubmatch(line, -1) {
+				add(deleteBlocker{Kind: blockerApp, Name: match[1]})
+			}
+		}
+	}
+	return blockers
+}
+
+// cleanupDeleteBlockers removes what a failed space delete job named as
+// blocking it, so the space can be deleted again: service instances are
+// deleted with their bindings and keys, and apps by the generic cleanup.
+// Instances whose broker failed can't be removed here, so the space is
+// quarantined when QUARANTINE_BLOCKED_SPACES is set; it returns the failure
+// itself when there's nothing it knows how to clean up
+func cleanupDeleteBlockers(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	space *resource.Space,
+	failure *spaceDeleteJobError,
+) (spaceCleanup, error) {
+	if len(failure.blockersOf(blockerServiceBroker)) > 0 {
+		if !opts.QuarantineBlockedSpaces {
+			return spaceCleanup{}, failure
+		}
+		cleanup, err := quarantineSpace(ctx, cfClient, space)
+		if err != nil {
+			return cleanup, fmt.Errorf("error quarantining space %s after its delete failed (%s): %w", space.Name, failure, err)
+		}
+		return cleanup, &spaceQuarantinedError{space: space.Name, err: failure}
+	}
+
+	if names := failure.blockersOf(blockerServiceInstance); len(names) > 0 {
+		logFields{Space: space.Name, Action: planActionPurge}.printf("deleting service instances %v blocking the delete of space %s", names, space.Name)
+		if err := deleteBlockingInstances(ctx, cfClient, opts, space, names); err != nil {
+			return spaceCleanup{}, fmt.Errorf("%s; error deleting blocking service instances: %w", failure, err)
+		}
+		return spaceCleanup{}, nil
+	}
+
+	if names := failure.blockersOf(blockerApp); len(names) > 0 {
+		logFields{Space: space.Name, Action: planActionPurge}.printf("cleaning up apps %v blocking the delete of space %s", names, space.Name)
+		cleanup, err := cleanupSpaceResources(ctx, cfClient, space)
+		if err != nil {
+			return cleanup, fmt.Errorf("%s; error cleaning up apps: %w", failure, err)
+		}
+		return cleanup, nil
+	}
+	return spaceCleanup{}, failure
+}
+
+// deleteBlockingInstances deletes the named service instances in a space,
+// with their bindings and keys, waiting for each to deprovision
+func deleteBlockingInstances(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	space *resource.Space,
+	names []string,
+) error {
+	serviceListOptions := client.NewServiceInstanceListOptions()
+	serviceListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	serviceListOptions.Names.EqualTo(names...)
+	instances, err := cfClient.ServiceInstances.ListAll(ctx, serviceListOptions)
+	if err != nil {
+		return fmt.Errorf("error listing service instances in space %s: %w", space.Name, err)
+	}
+	for _, instance := range instances {
+		if err := deleteInstanceBindings(ctx, cfClient, space, instance); err != nil {
+			return err
+		}
+		logFields{Space: space.Name, Action: planActionPurge}.printf("deleting service instance %s in space %s", instance.Name, space.Name)
+		jobGUID, err := cfClient.ServiceInstances.Delete(ctx, instance.GUID)
+		if isNotFoundError(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error deleting service instance %s in space %s: %w", instance.Name, space.Name, err)
+		}
+		if err := waitForDeprovisionJob(ctx, cfClient, opts, jobGUID); err != nil {
+			return fmt.Errorf("error waiting for delete job %s to be complete: %w", jobGUID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDeleteBlockers(t *testing.T) {
+	testCases := map[string]struct {
+		detail   string
+		expected []deleteBlocker
+	}{
+		"service instance": {
+			detail:   "Deletion of space foo failed because one or more resources within could not be deleted.\n\n\tDeletion of service instance db failed because one or more associated resources could not be deleted.\n\n\tAn operation for the service binding between app web and service instance db is in progress.",
+			expected: []deleteBlocker{{Kind: blockerServiceInstance, Name: "db"}},
+		},
+		"service broker": {
+			detail:   "Deletion of space foo failed because one or more resources within could not be deleted.\n\n\tDeletion of service instance db failed.\n\n\tService instance db: The service broker returned an invalid response for the request to https://broker.example.gov/v2/service_instances/1234. Status Code: 500 Internal Server Error",
+			expected: []deleteBlocker{{Kind: blockerServiceBroker, Name: "db"}},
+		},
+		"app": {
+			detail:   "Deletion of space foo failed because one or more resources within could not be deleted.\n\n\tDeletion of app web failed.",
+			expected: []deleteBlocker{{Kind: blockerApp, Name: "web"}},
+		},
+		"nothing named": {
+			detail: "An unknown error occurred.",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got := parseDeleteBlockers([]resource.CloudFoundryError{{Title: "CF-SpaceDeletionFailed", Detail: test.detail}})
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("parseDeleteBlockers() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAwaitSpaceDeletionBlockers(t *testing.T) {
+	space := &resource.Space{GUID: "space-guid", Name: "foo"}
+	jobFailed := client.AsyncProcessFailedError
+	instanceErr := resource.CloudFoundryError{
+		Title:  "CF-SpaceDeletionFailed",
+		Detail: "Deletion of space foo failed.\n\tDeletion of service instance db failed because one or more associated resources could not be deleted.",
+	}
+	brokerErr := resource.CloudFoundryError{
+		Title:  "CF-SpaceDeletionFailed",
+		Detail: "Deletion of space foo failed.\n\tService instance db: The service broker returned an invalid response.",
+	}
+	appErr := resource.CloudFoundryError{
+		Title:  "CF-SpaceDeletionFailed",
+		Detail: "Deletion of space foo failed.\n\tDeletion of app web failed.",
+	}
+	testCases := map[string]struct {
+		jobErrs           []resource.CloudFoundryError
+		quarantine        bool
+		expectedPolled    []string
+		expectedInstances []string
+		expectedBindings  []string
+		expectedCleanup   spaceCleanup
+		expectedErr       string
+		expectQuarantined bool
+	}{
+		"deletes blocking service instance": {
+			jobErrs:           []resource.CloudFoundryError{instanceErr},
+			expectedPolled:    []string{"job-1", "job-2"},
+			expectedInstances: []string{"db-guid"},
+			expectedBindings:  []string{"binding-guid"},
+		},
+		"reports broker failure": {
+			jobErrs:        []resource.CloudFoundryError{brokerErr},
+			expectedPolled: []string{"job-1"},
+			expectedErr:    "delete job job-1 for space foo failed: CF-SpaceDeletionFailed: Deletion of space foo failed. Service instance db: The service broker returned an invalid response. (blocked by the service broker of service instance db)",
+		},
+		"quarantines on broker failure": {
+			jobErrs:           []resource.CloudFoundryError{brokerErr},
+			quarantine:        true,
+			expectedPolled:    []string{"job-1"},
+			expectedCleanup:   spaceCleanup{AppsStopped: 1},
+			expectedErr:       "space foo was quarantined with label purge-blocked after its delete failed: delete job job-1 for space foo failed: CF-SpaceDeletionFailed: Deletion of space foo failed. Service instance db: The service broker returned an invalid response. (blocked by the service broker of service instance db)",
+			expectQuarantined: true,
+		},
+		"cleans up blocking apps": {
+			jobErrs:         []resource.CloudFoundryError{appErr},
+			expectedPolled:  []string{"job-1", "job-2"},
+			expectedCleanup: spaceCleanup{AppsDeleted: 1},
+		},
+		"lists every job error": {
+			jobErrs: []resource.CloudFoundryError{
+				{Title: "CF-SpaceDeletionFailed", Detail: "first"},
+				{Title: "CF-UnknownError", Detail: "second"},
+			},
+			expectedPolled: []string{"job-1"},
+			expectedErr:    "delete job job-1 for space foo failed: CF-SpaceDeletionFailed: first; CF-UnknownError: second",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			jobs := &sequenceJobs{errs: []error{jobFailed}, job: &resource.Job{State: resource.JobStateFailed, Errors: test.jobErrs}}
+			instances := &mockServiceInstances{instances: []*resource.ServiceInstance{{GUID: "db-guid", Name: "db"}}}
+			bindings := &mockServiceCredentialBindings{bindings: []*resource.ServiceCredentialBinding{{GUID: "binding-guid"}}}
+			cfClient := &cfResourceClient{
+				Jobs:                      jobs,
+				ServiceInstances:          instances,
+				ServiceCredentialBindings: bindings,
+				Spaces:                    &mockSpaces{deleteJobGUID: "job-2"},
+				Applications:              &mockApplications{apps: []*resource.App{{GUID: "web-guid", Name: "web", State: "STARTED"}}},
+				Droplets:                  &mockDroplets{},
+				Tasks:                     &mockTasks{},
+			}
+			opts := Config{QuarantineBlockedSpaces: test.quarantine}
+			cleanup, err := awaitSpaceDeletion(context.Background(), cfClient, opts, space, "job-1")
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %s", test.expectedErr, err)
+			}
+			var quarantined *spaceQuarantinedError
+			if errors.As(err, &quarantined) != test.expectQuarantined {
+				t.Errorf("expected quarantined: %t, got: %s", test.expectQuarantined, err)
+			}
+			if diff := cmp.Diff(test.expectedCleanup, cleanup); diff != "" {
+				t.Errorf("cleanup mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedPolled, jobs.polled); diff != "" {
+				t.Errorf("polled jobs mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedInstances, instances.deletedGUIDs); diff != "" {
+				t.Errorf("deleted instances mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedBindings, bindings.deletedGUIDs); diff != "" {
+				t.Errorf("deleted bindings mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
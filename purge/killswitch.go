@@ -0,0 +1,145 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+)
+
+// labelPurgeHalt on the KILL_SWITCH_ORG org halts purges until an operator
+// removes it
+const labelPurgeHalt = "purge-halt"
+
+// errHalted marks actions skipped because the kill switch was engaged
+var errHalted = errors.New("skipped: kill switch engaged")
+
+// KillSwitchOptions lets operators halt purges without redeploying the job:
+// while the S3 object at KillSwitchURL exists, or the KillSwitchOrg org
+// carries the purge-halt label, runs only report what they would do
+type KillSwitchOptions struct {
+	KillSwitchURL string `env:"KILL_SWITCH_URL"`
+	KillSwitchOrg string `env:"KILL_SWITCH_ORG"`
+}
+
+func (o KillSwitchOptions) validate() error {
+	if o.KillSwitchURL == "" {
+		return nil
+	}
+	if _, _, ok := parseS3URL(o.KillSwitchURL); !ok {
+		return fmt.Errorf("invalid KILL_SWITCH_URL %s; expected s3://bucket/key", o.KillSwitchURL)
+	}
+	return nil
+}
+
+// killSwitch checks the remote kill switch; once engaged, it stays engaged
+// for the rest of the run
+type killSwitch struct {
+	options KillSwitchOptions
+	s3      S3Options
+	mu      sync.Mutex
+	reason  string
+}
+
+// newKillSwitch returns nil when no kill switch is configured
+func newKillSwitch(options KillSwitchOptions, s3 S3Options) *killSwitch {
+	if options.KillSwitchURL == "" && options.KillSwitchOrg == "" {
+		return nil
+	}
+	return &killSwitch{options: options, s3: s3}
+}
+
+// check re-reads the kill switch and returns why it is engaged, or "" if it
+// isn't. A switch that can't be read counts as engaged, so an outage reading
+// it errs on the side of not deleting anything
+func (k *killSwitch) check(ctx context.Context, cfClient *cfResourceClient) string {
+	if k == nil {
+		return ""
+	}
+	if reason := k.engaged(); reason != "" {
+		return reason
+	}
+	reason, err := k.read(ctx, cfClient)
+	if err != nil {
+		reason = fmt.Sprintf("error reading kill switch: %s", err)
+	}
+	if reason == "" {
+		return ""
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.reason == "" {
+		k.reason = reason
+		logFields{}.printf("kill switch engaged: %s; the rest of the run is report-only", reason)
+	}
+	return k.reason
+}
+
+// engaged returns why the kill switch was engaged, without reading it again
+func (k *killSwitch) engaged() string {
+	if k == nil {
+		return ""
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.reason
+}
+
+func (k *killSwitch) read(ctx context.Context, cfClient *cfResourceClient) (string, error) {
+	if k.options.KillSwitchURL != "" {
+		bucket, key, _ := parseS3URL(k.options.KillSwitchURL)
+		contents, err := newS3Client(k.s3).getObject(ctx, bucket, key)
+		var status *s3StatusError
+		switch {
+		case errors.As(err, &status) && status.statusCode == http.StatusNotFound:
+		case err != nil:
+			return "", err
+		default:
+			reason := fmt.Sprintf("%s exists", k.options.KillSwitchURL)
+			if note, _, _ := strings.Cut(strings.TrimSpace(string(contents)), "\n"); note != "" {
+				reason += ": " + note
+			}
+			return reason, nil
+		}
+	}
+	if k.options.KillSwitchOrg != "" {
+		orgListOptions := client.NewOrganizationListOptions()
+		orgListOptions.Names.EqualTo(k.options.KillSwitchOrg)
+		org, err := cfClient.Organizations.Single(ctx, orgListOptions)
+		if err != nil {
+			return "", fmt.Errorf("error getting kill switch org %s: %w", k.options.KillSwitchOrg, err)
+		}
+		if org.Metadata != nil {
+			if value, ok := org.Metadata.Labels[labelPurgeHalt]; ok && (value == nil || *value != "false") {
+				return fmt.Sprintf("org %s is labeled %s", org.Name, labelPurgeHalt), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// halted checks the kill switch ahead of live actions, recording in the
+// report why it was engaged; dry runs are already report-only
+func (c Config) halted(ctx context.Context, cfClient *cfResourceClient, report *Report) bool {
+	if c.DryRun {
+		return false
+	}
+	reason := c.killSwitch.check(ctx, cfClient)
+	if reason == "" {
+		return false
+	}
+	report.Halted = reason
+	return true
+}
+
+// skipHalted records an action skipped by the kill switch; its messages are
+// suppressed, as in a dry run
+func skipHalted(mailSender mailer, opts Config, action PlannedAction, report *Report, status *runStatus) {
+	report.recordAction(action, errHalted)
+	report.recordMessages(recordDeliveries(mailSender, opts, action).results(true, nil))
+	status.finishAction(action, report)
+}
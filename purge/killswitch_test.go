@@ -0,0 +1,120 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+func TestKillSwitchCheck(t *testing.T) {
+	halt := "true"
+	off := "false"
+	testCases := map[string]struct {
+		options        KillSwitchOptions
+		objects        map[string]string
+		s3Status       int
+		org            *resource.Organization
+		orgErr         error
+		expectedReason string
+	}{
+		"not configured": {},
+		"object missing": {
+			options: KillSwitchOptions{KillSwitchURL: "s3://control/purge-halt"},
+		},
+		"object exists": {
+			options:        KillSwitchOptions{KillSwitchURL: "s3://control/purge-halt"},
+			objects:        map[string]string{"/control/purge-halt": "bad data in the inventory\nsee ticket"},
+			expectedReason: "s3://control/purge-halt exists: bad data in the inventory",
+		},
+		"empty object": {
+			options:        KillSwitchOptions{KillSwitchURL: "s3://control/purge-halt"},
+			objects:        map[string]string{"/control/purge-halt": ""},
+			expectedReason: "s3://control/purge-halt exists",
+		},
+		"unreadable object": {
+			options:        KillSwitchOptions{KillSwitchURL: "s3://control/purge-halt"},
+			s3Status:       http.StatusForbidden,
+			expectedReason: "error reading kill switch: error reading s3://control/purge-halt: 403 Forbidden: denied",
+		},
+		"org unlabeled": {
+			options: KillSwitchOptions{KillSwitchOrg: "cloud-gov-control"},
+			org:     &resource.Organization{Name: "cloud-gov-control", Metadata: resource.NewMetadata()},
+		},
+		"org labeled": {
+			options:        KillSwitchOptions{KillSwitchOrg: "cloud-gov-control"},
+			org:            &resource.Organization{Name: "cloud-gov-control", Metadata: &resource.Metadata{Labels: map[string]*string{labelPurgeHalt: &halt}}},
+			expectedReason: "org cloud-gov-control is labeled purge-halt",
+		},
+		"org label false": {
+			options: KillSwitchOptions{KillSwitchOrg: "cloud-gov-control"},
+			org:     &resource.Organization{Name: "cloud-gov-control", Metadata: &resource.Metadata{Labels: map[string]*string{labelPurgeHalt: &off}}},
+		},
+		"org missing": {
+			options:        KillSwitchOptions{KillSwitchOrg: "cloud-gov-control"},
+			orgErr:         errors.New("expected exactly 1 result, but got less or more than 1"),
+			expectedReason: "error reading kill switch: error getting kill switch org cloud-gov-control: expected exactly 1 result, but got less or more than 1",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.s3Status != 0 {
+					http.Error(w, "denied", test.s3Status)
+					return
+				}
+				object, ok := test.objects[r.URL.Path]
+				if !ok {
+					http.Error(w, "missing", http.StatusNotFound)
+					return
+				}
+				w.Write([]byte(object))
+			}))
+			defer server.Close()
+
+			opts := Config{KillSwitchOptions: test.options, InventoryOptions: InventoryOptions{S3Options: S3Options{S3Endpoint: server.URL}}}
+			cfClient := &cfResourceClient{Organizations: &mockOrganizations{org: test.org, singleErr: test.orgErr}}
+			if reason := newKillSwitch(opts.KillSwitchOptions, opts.S3Options).check(context.Background(), cfClient); reason != test.expectedReason {
+				t.Errorf("expected reason %q, got %q", test.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestKillSwitchStaysEngaged(t *testing.T) {
+	halt := "true"
+	orgs := &mockOrganizations{org: &resource.Organization{Name: "cloud-gov-control", Metadata: &resource.Metadata{Labels: map[string]*string{labelPurgeHalt: &halt}}}}
+	cfClient := &cfResourceClient{Organizations: orgs}
+	opts := Config{KillSwitchOptions: KillSwitchOptions{KillSwitchOrg: "cloud-gov-control"}}
+	opts.killSwitch = newKillSwitch(opts.KillSwitchOptions, opts.S3Options)
+
+	report := &Report{}
+	if !opts.halted(context.Background(), cfClient, report) {
+		t.Fatal("expected the run to be halted")
+	}
+	// removing the label partway through a run doesn't resume deletes
+	orgs.org = &resource.Organization{Name: "cloud-gov-control", Metadata: resource.NewMetadata()}
+	if !opts.halted(context.Background(), cfClient, report) {
+		t.Error("expected the run to stay halted")
+	}
+	if report.Halted != "org cloud-gov-control is labeled purge-halt" {
+		t.Errorf("unexpected halted reason %q", report.Halted)
+	}
+
+	opts.DryRun = true
+	if opts.halted(context.Background(), cfClient, &Report{}) {
+		t.Error("expected dry runs not to report a halt")
+	}
+}
+
+func TestKillSwitchOptionsValidate(t *testing.T) {
+	if err := (KillSwitchOptions{KillSwitchURL: "s3://control/purge-halt"}).validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := (KillSwitchOptions{KillSwitchURL: "https://example.gov/purge-halt"}).validate(); err == nil {
+		t.Error("expected an error for a URL that isn't on S3")
+	}
+}
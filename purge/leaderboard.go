@@ -0,0 +1,177 @@
+package purge
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LeaderboardOptions describes the report section ranking the oldest active
+// sandboxes and heaviest users, so the team can reach out before purge day
+type LeaderboardOptions struct {
+	// LeaderboardSize is how many spaces and users to rank; zero disables the
+	// leaderboard
+	LeaderboardSize   int    `env:"LEADERBOARD_SIZE, default=0"`
+	LeaderboardCSVDir string `env:"LEADERBOARD_CSV_DIR"`
+}
+
+// Leaderboard ranks the sandboxes that will be purged soonest and the users
+// holding the most resources
+type Leaderboard struct {
+	OldestSpaces  []LeaderboardSpace `json:"oldest_spaces"`
+	HeaviestUsers []LeaderboardUser  `json:"heaviest_users"`
+}
+
+// LeaderboardSpace is an active sandbox space ranked by age
+type LeaderboardSpace struct {
+	Org              string    `json:"org"`
+	Space            string    `json:"space"`
+	FirstResource    time.Time `json:"first_resource"`
+	AgeDays          int       `json:"age_days"`
+	Apps             int       `json:"apps"`
+	ServiceInstances int       `json:"service_instances"`
+	Owners           []string  `json:"owners"`
+	Decision         string    `json:"decision"`
+}
+
+// LeaderboardUser is a sandbox user ranked by the apps and service instances
+// in the spaces they own
+type LeaderboardUser struct {
+	User             string `json:"user"`
+	Spaces           int    `json:"spaces"`
+	Apps             int    `json:"apps"`
+	ServiceInstances int    `json:"service_instances"`
+}
+
+// buildLeaderboard ranks the active spaces in an inventory; spaces with no
+// resources, and spaces purged by this run, are left out
+func buildLeaderboard(records []InventoryRecord, size int) *Leaderboard {
+	leaderboard := &Leaderboard{
+		OldestSpaces:  []LeaderboardSpace{},
+		HeaviestUsers: []LeaderboardUser{},
+	}
+	users := map[string]*LeaderboardUser{}
+	for _, record := range records {
+		if record.AgeDays == nil || record.Decision == planActionPurge {
+			continue
+		}
+		leaderboard.OldestSpaces = append(leaderboard.OldestSpaces, LeaderboardSpace{
+			Org:              record.Org,
+			Space:            record.Space,
+			FirstResource:    *record.FirstResource,
+			AgeDays:          *record.AgeDays,
+			Apps:             record.Apps,
+			ServiceInstances: record.ServiceInstances,
+			Owners:           record.Owners,
+			Decision:         record.Decision,
+		})
+		for _, owner := range record.Owners {
+			user, ok := users[owner]
+			if !ok {
+				user = &LeaderboardUser{User: owner}
+				users[owner] = user
+			}
+			user.Spaces++
+			user.Apps += record.Apps
+			user.ServiceInstances += record.ServiceInstances
+		}
+	}
+
+	spaces := leaderboard.OldestSpaces
+	sort.Slice(spaces, func(i, j int) bool {
+		if !spaces[i].FirstResource.Equal(spaces[j].FirstResource) {
+			return spaces[i].FirstResource.Before(spaces[j].FirstResource)
+		}
+		if spaces[i].Org != spaces[j].Org {
+			return spaces[i].Org < spaces[j].Org
+		}
+		return spaces[i].Space < spaces[j].Space
+	})
+	if len(spaces) > size {
+		leaderboard.OldestSpaces = spaces[:size]
+	}
+
+	for _, user := range users {
+		leaderboard.HeaviestUsers = append(leaderboard.HeaviestUsers, *user)
+	}
+	ranked := leaderboard.HeaviestUsers
+	sort.Slice(ranked, func(i, j int) bool {
+		iTotal := ranked[i].Apps + ranked[i].ServiceInstances
+		jTotal := ranked[j].Apps + ranked[j].ServiceInstances
+		if iTotal != jTotal {
+			return iTotal > jTotal
+		}
+		return ranked[i].User < ranked[j].User
+	})
+	if len(ranked) > size {
+		leaderboard.HeaviestUsers = ranked[:size]
+	}
+	return leaderboard
+}
+
+// writeLeaderboardCSV writes the leaderboard to oldest-spaces.csv and
+// heaviest-users.csv in dir
+func writeLeaderboardCSV(dir string, leaderboard *Leaderboard) error {
+	files := map[string]func(io.Writer) error{
+		"oldest-spaces.csv":  leaderboard.writeSpacesCSV,
+		"heaviest-users.csv": leaderboard.writeUsersCSV,
+	}
+	for name, write := range files {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("error creating leaderboard %s: %w", path, err)
+		}
+		if err := write(f); err != nil {
+			f.Close()
+			return fmt.Errorf("error writing leaderboard %s: %w", path, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("error writing leaderboard %s: %w", path, err)
+		}
+	}
+	logFields{}.printf("wrote leaderboard to %s", dir)
+	return nil
+}
+
+func (l *Leaderboard) writeSpacesCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"rank", "org", "space", "first_resource", "age_days", "apps", "service_instances", "owners", "decision"})
+	for i, space := range l.OldestSpaces {
+		writer.Write([]string{
+			strconv.Itoa(i + 1),
+			space.Org,
+			space.Space,
+			space.FirstResource.UTC().Format("2006-01-02"),
+			strconv.Itoa(space.AgeDays),
+			strconv.Itoa(space.Apps),
+			strconv.Itoa(space.ServiceInstances),
+			strings.Join(space.Owners, " "),
+			space.Decision,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func (l *Leaderboard) writeUsersCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"rank", "user", "spaces", "apps", "service_instances"})
+	for i, user := range l.HeaviestUsers {
+		writer.Write([]string{
+			strconv.Itoa(i + 1),
+			user.User,
+			strconv.Itoa(user.Spaces),
+			strconv.Itoa(user.Apps),
+			strconv.Itoa(user.ServiceInstances),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
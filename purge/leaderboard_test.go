@@ -0,0 +1,100 @@
+package purge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testLeaderboardRecord(space string, daysAgo int, decision string, apps int, instances int, owners ...string) InventoryRecord {
+	now := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	record := InventoryRecord{
+		Org:              "sandbox-agency",
+		Space:            space,
+		Apps:             apps,
+		ServiceInstances: instances,
+		Owners:           owners,
+		Decision:         decision,
+	}
+	if daysAgo >= 0 {
+		firstResource := now.AddDate(0, 0, -daysAgo)
+		record.FirstResource = &firstResource
+		record.AgeDays = &daysAgo
+	}
+	return record
+}
+
+func TestBuildLeaderboard(t *testing.T) {
+	records := []InventoryRecord{
+		testLeaderboardRecord("new", 2, inventoryDecisionKeep, 1, 0, "new@agency.gov"),
+		testLeaderboardRecord("old", 27, planActionNotify, 2, 1, "old@agency.gov", "shared@agency.gov"),
+		testLeaderboardRecord("purged", 30, planActionPurge, 9, 9, "purged@agency.gov"),
+		testLeaderboardRecord("empty", -1, inventoryDecisionEmpty, 0, 0, "empty@agency.gov"),
+		testLeaderboardRecord("heavy", 10, inventoryDecisionKeep, 5, 3, "shared@agency.gov"),
+	}
+
+	testCases := map[string]struct {
+		size           int
+		expectedSpaces []string
+		expectedUsers  []LeaderboardUser
+	}{
+		"ranks every active space": {
+			size:           10,
+			expectedSpaces: []string{"old", "heavy", "new"},
+			expectedUsers: []LeaderboardUser{
+				{User: "shared@agency.gov", Spaces: 2, Apps: 7, ServiceInstances: 4},
+				{User: "old@agency.gov", Spaces: 1, Apps: 2, ServiceInstances: 1},
+				{User: "new@agency.gov", Spaces: 1, Apps: 1},
+			},
+		},
+		"truncates to size": {
+			size:           1,
+			expectedSpaces: []string{"old"},
+			expectedUsers: []LeaderboardUser{
+				{User: "shared@agency.gov", Spaces: 2, Apps: 7, ServiceInstances: 4},
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			leaderboard := buildLeaderboard(records, test.size)
+			var spaces []string
+			for _, space := range leaderboard.OldestSpaces {
+				spaces = append(spaces, space.Space)
+			}
+			if diff := cmp.Diff(test.expectedSpaces, spaces); diff != "" {
+				t.Errorf("buildLeaderboard() spaces mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedUsers, leaderboard.HeaviestUsers); diff != "" {
+				t.Errorf("buildLeaderboard() users mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteLeaderboardCSV(t *testing.T) {
+	dir := t.TempDir()
+	leaderboard := buildLeaderboard([]InventoryRecord{
+		testLeaderboardRecord("old", 27, planActionNotify, 2, 1, "old@agency.gov", "shared@agency.gov"),
+	}, 10)
+	if err := writeLeaderboardCSV(dir, leaderboard); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]string{
+		"oldest-spaces.csv":  "rank,org,space,first_resource,age_days,apps,service_instances,owners,decision\n1,sandbox-agency,old,2024-01-04,27,2,1,old@agency.gov shared@agency.gov,notify\n",
+		"heaviest-users.csv": "rank,user,spaces,apps,service_instances\n1,old@agency.gov,1,2,1\n2,shared@agency.gov,1,2,1\n",
+	}
+	for name, contents := range expected {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff(contents, string(got)); diff != "" {
+			t.Errorf("writeLeaderboardCSV() %s mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package purge
+
+import (
+	"context"
+	"sort"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// App lifecycle types; buildpack is CF's default
+const (
+	lifecycleBuildpack = "buildpack"
+	lifecycleDocker    = "docker"
+	lifecycleCNB       = "cnb"
+)
+
+// appLifecycleType returns an app's lifecycle type, defaulting to buildpack
+func appLifecycleType(app *resource.App) string {
+	if app.Lifecycle.Type == "" {
+		return lifecycleBuildpack
+	}
+	return app.Lifecycle.Type
+}
+
+// listCurrentDroplets lists the staged droplets in a space keyed by app
+// GUID; the droplet listing doesn't mark an app's current droplet, so its
+// newest staged droplet stands in for it
+func listCurrentDroplets(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	spaceGUID string,
+) (map[string]*resource.Droplet, error) {
+	dropletListOptions := client.NewDropletListOptions()
+	dropletListOptions.SpaceGUIDs.EqualTo(spaceGUID)
+	dropletListOptions.States.EqualTo(string(resource.DropletStateStaged))
+	droplets, err := cfClient.Droplets.ListAll(ctx, dropletListOptions)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]*resource.Droplet{}
+	for _, droplet := range droplets {
+		if droplet.Relationships.App.Data == nil {
+			continue
+		}
+		appGUID := droplet.Relationships.App.Data.GUID
+		if existing, ok := current[appGUID]; !ok || droplet.CreatedAt.After(existing.CreatedAt) {
+			current[appGUID] = droplet
+		}
+	}
+	return current, nil
+}
+
+// dropletProcessTypes returns the process types in a droplet, sorted
+func dropletProcessTypes(droplet *resource.Droplet) []string {
+	if droplet == nil || len(droplet.ProcessTypes) == 0 {
+		return nil
+	}
+	types := make([]string, 0, len(droplet.ProcessTypes))
+	for processType := range droplet.ProcessTypes {
+		types = append(types, processType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// dropletImage returns the Docker image a droplet was staged from, or ""
+func dropletImage(droplet *resource.Droplet) string {
+	if droplet == nil || droplet.Image == nil {
+		return ""
+	}
+	return *droplet.Image
+}
@@ -0,0 +1,176 @@
+package purge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LOG_FORMAT values
+const (
+	logFormatText = "text"
+	// logFormatJSON writes every log line as a LogEntry, for CloudWatch Logs
+	// Insights
+	logFormatJSON = "json"
+)
+
+// LogEntry is the schema of a JSON log line. Logs Insights queries and
+// alerts parse these field names, so a change to them has to go out along
+// with changes to those queries
+type LogEntry struct {
+	Time  time.Time `json:"time"`
+	RunID string    `json:"run_id,omitempty"`
+	Org   string    `json:"org,omitempty"`
+	Space string    `json:"space,omitempty"`
+	// Action is the planned action a line is about, such as notify or purge
+	Action string `json:"action,omitempty"`
+	// Phase is the part of the run in progress, as in the run status
+	Phase      string `json:"phase,omitempty"`
+	DurationMS *int64 `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Message    string `json:"message"`
+}
+
+// jsonLogger writes log lines as LogEntry JSON; lines logged without fields
+// through the log package get the run's ID and phase
+type jsonLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	now   func() time.Time
+	runID string
+	phase string
+}
+
+// structuredLog is the JSON logger when LOG_FORMAT is json, or nil when
+// lines are logged as plain text
+var structuredLog *jsonLogger
+
+// SetLogFormat sends log lines to out in format, text or json; unlike other
+// options it is read before a command parses its configuration, so that
+// every line the command logs has the same format
+func SetLogFormat(format string, out io.Writer) error {
+	switch format {
+	case "", logFormatText:
+		structuredLog = nil
+		return nil
+	case logFormatJSON:
+		structuredLog = &jsonLogger{out: out, now: time.Now}
+		log.SetFlags(0)
+		log.SetOutput(structuredLog)
+		return nil
+	default:
+		return fmt.Errorf("unknown LOG_FORMAT %s; expected text or json", format)
+	}
+}
+
+// Write logs a line written through the log package
+func (l *jsonLogger) Write(p []byte) (int, error) {
+	l.write(LogEntry{Message: strings.TrimSuffix(string(p), "\n")})
+	return len(p), nil
+}
+
+func (l *jsonLogger) write(entry LogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.Time = l.now().UTC()
+	entry.RunID = l.runID
+	if entry.Phase == "" {
+		entry.Phase = l.phase
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(LogEntry{Time: entry.Time, RunID: l.runID, Message: entry.Message, Error: err.Error()})
+	}
+	l.out.Write(append(line, '\n'))
+}
+
+// setLogRun starts tagging log lines with a run's ID
+func setLogRun(runID string) {
+	if structuredLog == nil {
+		return
+	}
+	structuredLog.mu.Lock()
+	defer structuredLog.mu.Unlock()
+	structuredLog.runID = runID
+	structuredLog.phase = ""
+}
+
+// setLogPhase tags later log lines with the part of the run in progress
+func setLogPhase(phase string) {
+	if structuredLog == nil {
+		return
+	}
+	structuredLog.mu.Lock()
+	defer structuredLog.mu.Unlock()
+	structuredLog.phase = phase
+}
+
+// runID identifies a run by its start time, like its triage bundles
+func runID(startedAt time.Time) string {
+	return startedAt.UTC().Format("20060102T150405Z")
+}
+
+// logFields are the LogEntry fields of a log line; as text, the line is
+// logged as formatted, so the message itself should name what it is about
+type logFields struct {
+	Org      string
+	Space    string
+	Action   string
+	Duration time.Duration
+	Err      error
+}
+
+// actionFields returns the fields describing a planned action
+func actionFields(action PlannedAction) logFields {
+	fields := logFields{Action: action.Action}
+	if action.Org != nil {
+		fields.Org = action.Org.Name
+	}
+	if action.Details.Space != nil {
+		fields.Space = action.Details.Space.Name
+	}
+	return fields
+}
+
+func (f logFields) printf(format string, v ...any) {
+	if structuredLog == nil {
+		log.Printf(format, v...)
+		return
+	}
+	entry := LogEntry{
+		Org:     f.Org,
+		Space:   f.Space,
+		Action:  f.Action,
+		Message: fmt.Sprintf(format, v...),
+	}
+	if f.Duration > 0 {
+		ms := f.Duration.Milliseconds()
+		entry.DurationMS = &ms
+	}
+	if f.Err != nil {
+		entry.Error = f.Err.Error()
+	}
+	structuredLog.write(entry)
+}
+
+// LogError logs err on a line of its own, as the error field in JSON
+func LogError(err error) {
+	logFields{Err: err}.printf("%s", err)
+}
+
+// logActionResult logs how an applied action ended and how long it took
+func logActionResult(action PlannedAction, started time.Time, err error) {
+	fields := actionFields(action)
+	fields.Duration = time.Since(started)
+	fields.Err = err
+	duration := fields.Duration.Round(time.Millisecond)
+	if err != nil {
+		fields.printf("%s of %s in org %s failed after %s: %s", action.Action, action.target(), action.Org.Name, duration, err)
+		return
+	}
+	fields.printf("%s of %s in org %s finished in %s", action.Action, action.target(), action.Org.Name, duration)
+}
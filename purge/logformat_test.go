@@ -0,0 +1,107 @@
+package purge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSetLogFormat(t *testing.T) {
+	defer func() {
+		structuredLog = nil
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	}()
+	for _, format := range []string{"", logFormatText} {
+		if err := SetLogFormat(format, os.Stderr); err != nil || structuredLog != nil {
+			t.Errorf("expected plain logs for format %q, got error %v", format, err)
+		}
+	}
+	if err := SetLogFormat("xml", os.Stderr); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestJSONLogs(t *testing.T) {
+	var out bytes.Buffer
+	if err := SetLogFormat(logFormatJSON, &out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer func() {
+		structuredLog = nil
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	}()
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	structuredLog.now = func() time.Time { return now }
+
+	setLogRun("20240301T060000Z")
+	log.Printf("made %d CF API calls", 12)
+	setLogPhase("applying")
+	action := PlannedAction{
+		Action:  planActionPurge,
+		Org:     &resource.Organization{Name: "sandbox-agency"},
+		Details: SpaceDetails{Space: &resource.Space{Name: "jane.doe"}},
+	}
+	fields := actionFields(action)
+	fields.Duration = 1500 * time.Millisecond
+	fields.Err = errors.New("error deleting space")
+	fields.printf("purge of space jane.doe failed")
+	LogError(errors.New("error getting sandbox orgs: 502 Bad Gateway"))
+
+	var entries []LogEntry
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry LogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("error parsing log line %q: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	duration := int64(1500)
+	expected := []LogEntry{
+		{Time: now, RunID: "20240301T060000Z", Message: "made 12 CF API calls"},
+		{
+			Time:       now,
+			RunID:      "20240301T060000Z",
+			Org:        "sandbox-agency",
+			Space:      "jane.doe",
+			Action:     planActionPurge,
+			Phase:      "applying",
+			DurationMS: &duration,
+			Error:      "error deleting space",
+			Message:    "purge of space jane.doe failed",
+		},
+		{
+			Time:    now,
+			RunID:   "20240301T060000Z",
+			Phase:   "applying",
+			Error:   "error getting sandbox orgs: 502 Bad Gateway",
+			Message: "error getting sandbox orgs: 502 Bad Gateway",
+		},
+	}
+	if diff := cmp.Diff(expected, entries); diff != "" {
+		t.Errorf("log entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTextLogsUnchanged(t *testing.T) {
+	var out bytes.Buffer
+	log.SetFlags(0)
+	log.SetOutput(&out)
+	defer func() {
+		log.SetFlags(log.LstdFlags)
+		log.SetOutput(os.Stderr)
+	}()
+	logFields{Org: "sandbox-agency", Err: errors.New("forbidden")}.printf("error listing spaces in org %s: %s", "sandbox-agency", "forbidden")
+	if got := out.String(); got != "error listing spaces in org sandbox-agency: forbidden\n" {
+		t.Errorf("unexpected text log %q", got)
+	}
+}
@@ -0,0 +1,293 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+const (
+	mailTransportSMTP  = "smtp"
+	mailTransportGraph = "graph"
+)
+
+const (
+	// smtpsPort expects TLS from the start instead of STARTTLS
+	smtpsPort       = 465
+	smtpDialTimeout = 10 * time.Second
+	// smtpSendTimeout bounds a whole SMTP exchange once connected, so a
+	// relay that stops responding can't hold a run without a deadline
+	smtpSendTimeout = 5 * time.Minute
+)
+
+// SMTPOptions describes configation for sending mail via SMTP; the host and
+// credentials are required when MAIL_TRANSPORT is smtp
+type SMTPOptions struct {
+	SMTPHost string `env:"SMTP_HOST"`
+	SMTPPort int    `env:"SMTP_PORT, default=587"`
+	SMTPUser string `env:"SMTP_USER"`
+	SMTPPass string `env:"SMTP_PASS"`
+	SMTPCert string `env:"SMTP_CERT"`
+	// SMTPSecondaryHost is a relay to fail over to for the rest of the run
+	// once sending through SMTPHost fails; the port, user, password, and
+	// certificate left unset are SMTPHost's
+	SMTPSecondaryHost string `env:"SMTP_SECONDARY_HOST"`
+	SMTPSecondaryPort int    `env:"SMTP_SECONDARY_PORT, default=0"`
+	SMTPSecondaryUser string `env:"SMTP_SECONDARY_USER"`
+	SMTPSecondaryPass string `env:"SMTP_SECONDARY_PASS"`
+	SMTPSecondaryCert string `env:"SMTP_SECONDARY_CERT"`
+}
+
+type mailer interface {
+	sendMail(
+		ctx context.Context,
+		opts SMTPOptions,
+		sender string,
+		subject string,
+		body string,
+		thread mailThread,
+		recipients []string,
+		attachments ...mailAttachment,
+	) error
+}
+
+// mailAttachment is a file attached to a notification
+type mailAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+}
+
+type smtpMailer struct {
+	options SMTPOptions
+	// deliver sends a message through a relay; it is deliverSMTP unless a
+	// test replaces it
+	deliver func(ctx context.Context, opts SMTPOptions, msg *gomail.Message) error
+	relays  relayFailover
+}
+
+// newMailTransport returns the mailer that delivers email for the configured
+// MAIL_TRANSPORT
+func newMailTransport(opts Config) (mailer, error) {
+	switch opts.MailTransport {
+	case mailTransportSMTP:
+		if opts.SMTPHost == "" || opts.SMTPUser == "" || opts.SMTPPass == "" {
+			return nil, fmt.Errorf("SMTP_HOST, SMTP_USER, and SMTP_PASS are required for mail transport %s", opts.MailTransport)
+		}
+		return &smtpMailer{options: opts.SMTPOptions}, nil
+	case mailTransportGraph:
+		if opts.GraphTenantID == "" || opts.GraphClientID == "" || opts.GraphClientSecret == "" {
+			return nil, fmt.Errorf("GRAPH_TENANT_ID, GRAPH_CLIENT_ID, and GRAPH_CLIENT_SECRET are required for mail transport %s", opts.MailTransport)
+		}
+		if _, err := graphScope(opts.GraphAPIURL); err != nil {
+			return nil, err
+		}
+		return &graphMailer{
+			options:    opts.GraphOptions,
+			httpClient: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %s", opts.MailTransport)
+	}
+}
+
+// renderTemplate renders a template to string
+func renderTemplate(tmpl *template.Template, data map[string]interface{}) (string, error) {
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// sendMail sends email via SMTP
+func (m *smtpMailer) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	msg := gomail.NewMessage()
+	msg.SetHeaders(map[string][]string{
+		"From":    {sender},
+		"Subject": {subject},
+		"To":      recipients,
+	})
+	msg.SetHeaders(thread.headers())
+	msg.SetBody("text/html", body)
+	for _, attachment := range attachments {
+		content := attachment.Content
+		msg.Attach(
+			attachment.Name,
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}),
+			gomail.SetHeader(map[string][]string{"Content-Type": {attachment.ContentType}}),
+		)
+	}
+	return m.relays.send(ctx, opts, msg, m.deliverFunc())
+}
+
+func (m *smtpMailer) deliverFunc() func(context.Context, SMTPOptions, *gomail.Message) error {
+	if m.deliver != nil {
+		return m.deliver
+	}
+	return deliverSMTP
+}
+
+// deliverSMTP sends a message over a new SMTP connection. Cancelling ctx
+// closes the connection, so a stalled dial, handshake, or send returns the
+// context's error instead of hanging the run; without a deadline on ctx, the
+// exchange still gives up after smtpSendTimeout
+func deliverSMTP(ctx context.Context, opts SMTPOptions, msg *gomail.Message) (err error) {
+	tlsConfig := &tls.Config{ServerName: opts.SMTPHost}
+	if opts.SMTPCert != "" {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM([]byte(opts.SMTPCert))
+		tlsConfig.RootCAs = pool
+	}
+
+	address := net.JoinHostPort(opts.SMTPHost, strconv.Itoa(opts.SMTPPort))
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(smtpSendTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		if !stop() && err != nil {
+			err = fmt.Errorf("error sending mail via %s: %w", address, ctx.Err())
+		}
+	}()
+	if opts.SMTPPort == smtpsPort {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, opts.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if opts.SMTPPort != smtpsPort {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if opts.SMTPUser != "" {
+		if ok, auths := c.Extension("AUTH"); ok {
+			if err := c.Auth(smtpAuth(opts, auths)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := gomail.Send(smtpSender{c}, msg); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// smtpAuth picks an auth mechanism the relay advertises, preferring them in
+// the order gomail does: CRAM-MD5, then LOGIN for relays without PLAIN, then
+// PLAIN
+func smtpAuth(opts SMTPOptions, auths string) smtp.Auth {
+	switch {
+	case strings.Contains(auths, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(opts.SMTPUser, opts.SMTPPass)
+	case strings.Contains(auths, "LOGIN") && !strings.Contains(auths, "PLAIN"):
+		return &smtpLoginAuth{username: opts.SMTPUser, password: opts.SMTPPass, host: opts.SMTPHost}
+	default:
+		return smtp.PlainAuth("", opts.SMTPUser, opts.SMTPPass, opts.SMTPHost)
+	}
+}
+
+// smtpLoginAuth implements the LOGIN mechanism, which net/smtp lacks but
+// relays such as Office 365 require
+type smtpLoginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *smtpLoginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// like PlainAuth, only send credentials over TLS or to localhost
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *smtpLoginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
+
+// isLocalhost reports whether name is the local machine
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// smtpSender sends messages on an open SMTP connection for gomail
+type smtpSender struct {
+	client *smtp.Client
+}
+
+func (s smtpSender) Send(from string, to []string, msg io.WriterTo) error {
+	if err := s.client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := s.client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := s.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := msg.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
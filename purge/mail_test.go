@@ -0,0 +1,186 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net"
+	"net/smtp"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	notifyTemplate, err := template.ParseFiles("../templates/base.html", "../templates/_footer.html", "../templates/notify.tmpl")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	purgeTemplate, err := template.ParseFiles("../templates/base.html", "../templates/_footer.html", "../templates/purge.tmpl")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testCases := map[string]struct {
+		tpl              *template.Template
+		data             map[string]interface{}
+		expectedErr      string
+		expectedTestFile string
+	}{
+		"constructs the appropriate notify template": {
+			tpl: notifyTemplate,
+			data: map[string]interface{}{
+				"org": &resource.Organization{
+					Name: "test-org",
+				},
+				"space": &resource.Space{
+					Name: "test-space",
+				},
+				"date": time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC),
+				"days": 90,
+			},
+			expectedTestFile: "../testdata/notify.html",
+		},
+		"includes the acknowledgement link in the notify template": {
+			tpl: notifyTemplate,
+			data: map[string]interface{}{
+				"org": &resource.Organization{
+					Name: "test-org",
+				},
+				"space": &resource.Space{
+					Name: "test-space",
+				},
+				"date":   time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC),
+				"days":   90,
+				"ackURL": "https://sandbox-purge.example.gov/ack?expires=1258490098&sig=abc&space=space-1",
+			},
+			expectedTestFile: "../testdata/notify-ack.html",
+		},
+		"constructs the appropriate purge template": {
+			tpl: purgeTemplate,
+			data: map[string]interface{}{
+				"org": &resource.Organization{
+					Name: "test-org",
+				},
+				"space": &resource.Space{
+					Name: "test-space",
+				},
+				"date": time.Date(2009, 11, 17, 20, 34, 58, 651387237, time.UTC),
+				"days": 90,
+			},
+			expectedTestFile: "../testdata/purge.html",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			renderedTemplate, err := renderTemplate(
+				test.tpl,
+				test.data,
+			)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && test.expectedErr != err.Error()) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if test.expectedTestFile != "" {
+				if os.Getenv("OVERRIDE_TEMPLATES") == "1" {
+					err := os.WriteFile(test.expectedTestFile, []byte(renderedTemplate), 0644)
+					if err != nil {
+						t.Fatalf("unexpected error: %s", err)
+					}
+				}
+				expected, err := os.ReadFile(test.expectedTestFile)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if diff := cmp.Diff(string(expected), renderedTemplate); diff != "" {
+					t.Errorf("RenderTemplate() mismatch (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestSMTPMailerCancellation(t *testing.T) {
+	// the listener accepts connections but never sends the SMTP greeting
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	address := listener.Addr().(*net.TCPAddr)
+	opts := SMTPOptions{SMTPHost: "127.0.0.1", SMTPPort: address.Port, SMTPUser: "user", SMTPPass: "pass"}
+	m := &smtpMailer{options: opts}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = m.sendMail(ctx, opts, "no-reply@example.gov", "subject", "body", mailThread{}, []string{"foo@agency.gov"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected sendMail to give up at the deadline, took %s", elapsed)
+	}
+}
+
+func TestSMTPAuth(t *testing.T) {
+	opts := SMTPOptions{SMTPHost: "smtp.example.gov", SMTPUser: "user", SMTPPass: "pass"}
+	testCases := map[string]struct {
+		auths    string
+		expected string
+	}{
+		"plain":               {auths: "PLAIN", expected: "PLAIN"},
+		"login without plain": {auths: "LOGIN", expected: "LOGIN"},
+		"plain over login":    {auths: "LOGIN PLAIN", expected: "PLAIN"},
+		"cram-md5":            {auths: "LOGIN PLAIN CRAM-MD5", expected: "CRAM-MD5"},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := &smtp.ServerInfo{Name: opts.SMTPHost, TLS: true, Auth: []string{test.auths}}
+			mechanism, _, err := smtpAuth(opts, test.auths).Start(server)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if mechanism != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, mechanism)
+			}
+		})
+	}
+}
+
+func TestSMTPLoginAuth(t *testing.T) {
+	auth := &smtpLoginAuth{username: "user", password: "pass", host: "smtp.example.gov"}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.gov"}); err == nil {
+		t.Error("expected an error sending credentials without TLS")
+	}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "other.example.gov", TLS: true}); err == nil {
+		t.Error("expected an error sending credentials to the wrong host")
+	}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "smtp.example.gov", TLS: true}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for challenge, expected := range map[string]string{"Username:": "user", "Password:": "pass"} {
+		response, err := auth.Next([]byte(challenge), true)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(response) != expected {
+			t.Errorf("expected %q for %s, got %q", expected, challenge, response)
+		}
+	}
+	if _, err := auth.Next([]byte("Token:"), true); err == nil {
+		t.Error("expected an error for an unknown challenge")
+	}
+}
@@ -0,0 +1,93 @@
+package purge
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// fallbackRecorder is implemented by mailers that note messages sent as the
+// built-in plaintext fallback, so the report can list the templates to fix
+type fallbackRecorder interface {
+	recordFallback(templateName string, err error)
+}
+
+// renderSpaceMail renders a per-space email template. When the template
+// fails on this space's data, such as an edge-case name, the space's users
+// are sent a built-in plaintext message instead of nothing, and the failure
+// is noted with mailSender. Templates that fail to parse fail every space
+// alike, so they are still errors, and are caught by the template lint
+func renderSpaceMail(
+	tmpl *template.Template,
+	templateName string,
+	data map[string]interface{},
+	mailSender mailer,
+	fields logFields,
+) string {
+	body, err := renderTemplate(tmpl, data)
+	if err == nil {
+		return body
+	}
+	fields.Err = err
+	fields.printf("error rendering %s, sending the plaintext fallback: %s", templateName, err)
+	if recorder, ok := mailSender.(fallbackRecorder); ok {
+		recorder.recordFallback(templateName, err)
+	}
+	return plaintextFallback(templateName, data)
+}
+
+// plaintextFallback builds the minimal message sent in place of a template,
+// using only the names and dates the template was given. The mailers send
+// HTML bodies, so each paragraph is escaped and wrapped in plain <p> tags
+func plaintextFallback(templateName string, data map[string]interface{}) string {
+	where := "your cloud.gov sandbox space"
+	org, _ := data["org"].(*resource.Organization)
+	space, _ := data["space"].(*resource.Space)
+	if org != nil && space != nil {
+		where = fmt.Sprintf("your cloud.gov sandbox space %s/%s", org.Name, space.Name)
+	}
+	days, _ := data["days"].(int)
+
+	var paragraphs []string
+	switch templateName {
+	case purgeTemplateName:
+		paragraphs = []string{
+			fmt.Sprintf("We have deleted all applications, service instances, and routes in %s, and recreated the space empty.", where),
+			fmt.Sprintf("Sandbox content is cleared %d days after the first application or service is created.", days),
+		}
+	case purgeInstanceTemplateName:
+		name := "a service instance"
+		if instance, ok := data["instance"].(*resource.ServiceInstance); ok && instance != nil {
+			name = "the " + instance.Name + " service instance"
+		}
+		paragraphs = []string{
+			fmt.Sprintf("We have deleted %s, along with its bindings and service keys, in %s.", name, where),
+			"The rest of the space is unchanged.",
+		}
+	case welcomeTemplateName:
+		paragraphs = []string{
+			fmt.Sprintf("Welcome to %s.", where),
+			fmt.Sprintf("Sandbox content is cleared %d days after the first application or service is created, and you'll be warned beforehand.", days),
+		}
+	default:
+		when := "soon"
+		if date, ok := data["date"].(time.Time); ok && !date.IsZero() {
+			when = "on " + date.Format("Jan 02, 2006")
+		}
+		paragraphs = []string{
+			fmt.Sprintf("We will delete all applications, service instances, and routes in %s %s.", where, when),
+			fmt.Sprintf("Sandbox content is cleared %d days after the first application or service is created.", days),
+		}
+	}
+	paragraphs = append(paragraphs, "Learn more about policies for sandbox usage at https://cloud.gov/docs/pricing/free-limited-sandbox/.")
+
+	var body strings.Builder
+	for _, paragraph := range paragraphs {
+		body.WriteString("<p>" + html.EscapeString(paragraph) + "</p>\n")
+	}
+	return body.String()
+}
@@ -0,0 +1,72 @@
+package purge
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlaintextFallback(t *testing.T) {
+	data := map[string]interface{}{
+		"org":      &resource.Organization{Name: "sandbox-agency"},
+		"space":    &resource.Space{Name: "<jane>"},
+		"instance": &resource.ServiceInstance{Name: "db"},
+		"date":     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"days":     90,
+	}
+	testCases := map[string]string{
+		notifyTemplateName:        "<p>We will delete all applications, service instances, and routes in your cloud.gov sandbox space sandbox-agency/&lt;jane&gt; on Mar 01, 2024.</p>",
+		"notify-final.tmpl":       "on Mar 01, 2024.</p>",
+		purgeTemplateName:         "and recreated the space empty.</p>",
+		purgeInstanceTemplateName: "<p>We have deleted the db service instance,",
+		welcomeTemplateName:       "<p>Welcome to your cloud.gov sandbox space sandbox-agency/&lt;jane&gt;.</p>",
+	}
+	for name, expected := range testCases {
+		t.Run(name, func(t *testing.T) {
+			body := plaintextFallback(name, data)
+			if !strings.Contains(body, expected) {
+				t.Errorf("expected body to contain %q, got:\n%s", expected, body)
+			}
+		})
+	}
+}
+
+func TestApplyNotifyTemplateFallback(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.html":   `{{template "content" .}}`,
+		"notify.tmpl": `{{define "content"}}{{.unknown}}{{end}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	action := PlannedAction{
+		Action:     planActionNotify,
+		Org:        &resource.Organization{Name: "sandbox-agency"},
+		Details:    SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+		Recipients: []string{"foo@bar.gov"},
+	}
+	mailSender := &recordingMailer{}
+	deliveries := recordDeliveries(mailSender, Config{}, action)
+
+	if err := applyNotify(context.Background(), Config{TemplateDir: dir, PurgeDays: 90}, action, deliveries); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(mailSender.bodies) != 1 || !strings.Contains(mailSender.bodies[0], "sandbox-agency/foo") {
+		t.Fatalf("expected the plaintext fallback to be sent, got: %v", mailSender.bodies)
+	}
+	if len(deliveries.fallbacks) != 1 || !strings.HasPrefix(deliveries.fallbacks[0], "sandbox-agency/foo: notify.tmpl: ") {
+		t.Errorf("unexpected fallbacks: %v", deliveries.fallbacks)
+	}
+	if diff := cmp.Diff([]string{"foo@bar.gov"}, mailSender.recipients); diff != "" {
+		t.Errorf("recipients mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,73 @@
+package purge
+
+import (
+	"errors"
+	"sync"
+)
+
+// mailHold holds a run's remaining warnings and welcomes once consecutive
+// actions have failed on CF calls, so users aren't told about purges a CF
+// outage will keep from happening; held messages aren't recorded as sent,
+// so the next run sends them
+type mailHold struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	held      bool
+}
+
+func newMailHold(opts Config) *mailHold {
+	return &mailHold{threshold: opts.MailHoldAfterCFFailures}
+}
+
+// enabled reports whether mail waits on the actions it describes
+func (h *mailHold) enabled() bool {
+	return h != nil && h.threshold > 0
+}
+
+// record notes the outcome of an action's CF calls, holding mail once
+// threshold actions in a row have failed; a target deleted during the run,
+// instances still deprovisioning, a quarantined space, and a recreated space
+// that doesn't match are answers from CF, not failures to reach it
+func (h *mailHold) record(err error) {
+	if !h.enabled() {
+		return
+	}
+	var (
+		pending     *deprovisionPendingError
+		quarantined *spaceQuarantinedError
+		mismatch    *spaceMismatchError
+	)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil || errors.Is(err, errDeletedDuringRun) || errors.As(err, &pending) || errors.As(err, &quarantined) || errors.As(err, &mismatch) {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.failures >= h.threshold && !h.held {
+		h.held = true
+		logFields{Err: err}.printf("holding remaining warnings and welcomes after %d CF failures in a row: %s", h.failures, err)
+	}
+}
+
+// holding reports whether remaining warnings and welcomes are held
+func (h *mailHold) holding() bool {
+	if !h.enabled() {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.held
+}
+
+// heldMessages describes the messages of an action held for the next run
+func heldMessages(action PlannedAction) []MessageResult {
+	var messages []MessageResult
+	for _, recipient := range action.Recipients {
+		result := newMessageResult(action, recipient, deliveryHeld)
+		result.Note = "CF calls were failing"
+		messages = append(messages, result)
+	}
+	return messages
+}
@@ -0,0 +1,151 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMailHoldRecord(t *testing.T) {
+	failure := errors.New("502 Bad Gateway")
+	testCases := map[string]struct {
+		threshold int
+		outcomes  []error
+		expected  bool
+	}{
+		"disabled": {
+			outcomes: []error{failure, failure},
+		},
+		"below threshold": {
+			threshold: 2,
+			outcomes:  []error{failure},
+		},
+		"at threshold": {
+			threshold: 2,
+			outcomes:  []error{failure, failure},
+			expected:  true,
+		},
+		"success resets the count": {
+			threshold: 2,
+			outcomes:  []error{failure, nil, failure},
+		},
+		"answers from CF aren't failures": {
+			threshold: 1,
+			outcomes: []error{
+				deletedDuringRun("space foo"),
+				&deprovisionPendingError{space: "foo"},
+				&spaceMismatchError{space: "foo"},
+			},
+		},
+		"held for the rest of the run": {
+			threshold: 1,
+			outcomes:  []error{failure, nil},
+			expected:  true,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			hold := newMailHold(Config{MailOptions: MailOptions{MailHoldAfterCFFailures: test.threshold}})
+			for _, err := range test.outcomes {
+				hold.record(err)
+			}
+			if hold.holding() != test.expected {
+				t.Errorf("expected holding: %t, got: %t", test.expected, hold.holding())
+			}
+		})
+	}
+}
+
+func TestApplyPlanMailHold(t *testing.T) {
+	cfClient := &cfResourceClient{
+		Spaces:               &mockSpaces{deleteErr: errors.New("502 Bad Gateway")},
+		Applications:         &mockApplications{},
+		Droplets:             &mockDroplets{},
+		Tasks:                &mockTasks{},
+		Routes:               &mockRoutes{},
+		ServiceInstances:     &mockServiceInstances{},
+		ServiceRouteBindings: &mockServiceRouteBindings{},
+	}
+	mailSender := &recordingMailer{}
+	report := &Report{}
+	opts := Config{TemplateDir: "../templates", MailOptions: MailOptions{MailHoldAfterCFFailures: 1}}
+	if err := applyPlan(context.Background(), cfClient, opts, testPlan(), mailSender, nil, report, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(mailSender.recipients) > 0 {
+		t.Errorf("expected no email while CF is failing, sent to: %v", mailSender.recipients)
+	}
+	if report.SpacesNotified != 0 || report.SpacesPurged != 0 {
+		t.Errorf("expected no spaces notified or purged, got %d and %d", report.SpacesNotified, report.SpacesPurged)
+	}
+	statuses := map[string]string{}
+	for _, message := range report.Messages {
+		statuses[message.Recipient] = message.Status
+	}
+	expected := map[string]string{
+		"baz@bar.gov": deliveryFailed,
+		"foo@bar.gov": deliveryHeld,
+	}
+	if diff := cmp.Diff(expected, statuses); diff != "" {
+		t.Errorf("message statuses mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyPurgeInstanceMailAfterDelete(t *testing.T) {
+	action := PlannedAction{
+		Action:          planActionPurgeInstance,
+		Org:             &resource.Organization{GUID: "org-1", Name: "sandbox-foo"},
+		Details:         SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+		ServiceInstance: &resource.ServiceInstance{GUID: "instance-1", Name: "db"},
+		Recipients:      []string{"foo@bar.gov"},
+		Subject:         "purge instance",
+	}
+	opts := Config{TemplateDir: "../templates", MailOptions: MailOptions{MailHoldAfterCFFailures: 1}}
+	testCases := map[string]struct {
+		instances          *mockServiceInstances
+		mailErr            error
+		expectedErr        string
+		expectedRecipients []string
+		expectedErrors     []string
+	}{
+		"emails once deleted": {
+			instances:          &mockServiceInstances{},
+			expectedRecipients: []string{"foo@bar.gov"},
+		},
+		"no email when the delete fails": {
+			instances:   &mockServiceInstances{deleteErr: errors.New("502 Bad Gateway")},
+			expectedErr: "error deleting service instance db in space foo in org sandbox-foo: 502 Bad Gateway",
+		},
+		"mail failure doesn't fail the delete": {
+			instances:          &mockServiceInstances{},
+			mailErr:            errors.New("connection refused"),
+			expectedRecipients: []string{"foo@bar.gov"},
+			expectedErrors:     []string{"error notifying users of deleted service instance db in org sandbox-foo: error sending mail on space foo: connection refused"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				ServiceCredentialBindings: &mockServiceCredentialBindings{},
+				ServiceInstances:          test.instances,
+				ServiceRouteBindings:      &mockServiceRouteBindings{},
+				Jobs:                      &mockJobs{},
+			}
+			mailSender := &recordingMailer{err: test.mailErr}
+			report := &Report{}
+			err := applyPurgeInstance(context.Background(), cfClient, opts, action, mailSender, report)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedRecipients, mailSender.recipients); diff != "" {
+				t.Errorf("recipients mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedErrors, report.Errors); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
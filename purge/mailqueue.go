@@ -0,0 +1,268 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailOptions describes configuration for concurrent mail delivery
+type MailOptions struct {
+	MailTransport      string        `env:"MAIL_TRANSPORT, default=smtp"`
+	MailWorkers        int           `env:"MAIL_WORKERS, default=4"`
+	MailDomainInterval time.Duration `env:"MAIL_DOMAIN_INTERVAL, default=1s"`
+	// MailMaxBodyBytes caps the size of rendered emails; Gmail clips bodies
+	// over about 100KB
+	MailMaxBodyBytes int `env:"MAIL_MAX_BODY_BYTES, default=102400"`
+	// MailOverrideRecipient receives every message in place of its
+	// recipients, so runs against staging never email real users
+	MailOverrideRecipient string `env:"MAIL_OVERRIDE_RECIPIENT"`
+	// MailHoldAfterCFFailures sends purge emails only once their purges
+	// complete, and holds the run's remaining warnings and
+	// welcomes after this many actions in a row fail on CF calls; zero sends
+	// mail as planned
+	MailHoldAfterCFFailures int `env:"MAIL_HOLD_AFTER_CF_FAILURES, default=0"`
+}
+
+// overrideRecipientMailer sends every message to a single test address,
+// listing the recipients it would have gone to at the top of the body
+type overrideRecipientMailer struct {
+	mailer    mailer
+	recipient string
+}
+
+func newOverrideRecipientMailer(m mailer, recipient string) mailer {
+	if recipient == "" {
+		return m
+	}
+	logFields{}.printf("sending all email to %s instead of its recipients", recipient)
+	return &overrideRecipientMailer{mailer: m, recipient: recipient}
+}
+
+// sendMail sends the message to the override address instead of recipients
+func (m *overrideRecipientMailer) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	if len(recipients) == 0 {
+		return nil
+	}
+	note := fmt.Sprintf(
+		"<p><strong>Redirected to %s; originally addressed to: %s</strong></p>\n",
+		html.EscapeString(m.recipient),
+		html.EscapeString(strings.Join(recipients, ", ")),
+	)
+	if i := strings.Index(body, "<body>"); i >= 0 {
+		i += len("<body>")
+		body = body[:i] + "\n" + note + body[i:]
+	} else {
+		body = note + body
+	}
+	return m.mailer.sendMail(ctx, opts, sender, subject, body, thread, []string{m.recipient}, attachments...)
+}
+
+// domainRateLimitedMailer spaces out sends to each recipient domain so agency
+// mail gateways don't greylist bursts of messages
+type domainRateLimitedMailer struct {
+	mailer   mailer
+	interval time.Duration
+
+	mu       sync.Mutex
+	nextSend map[string]time.Time
+}
+
+func newDomainRateLimitedMailer(m mailer, interval time.Duration) mailer {
+	if interval <= 0 {
+		return m
+	}
+	return &domainRateLimitedMailer{
+		mailer:   m,
+		interval: interval,
+		nextSend: map[string]time.Time{},
+	}
+}
+
+// reserve claims the next send slot for every recipient domain and returns
+// when the message may be sent
+func (m *domainRateLimitedMailer) reserve(recipients []string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	sendAt := now
+	domains := map[string]bool{}
+	for _, recipient := range recipients {
+		domains[strings.ToLower(recipient[strings.LastIndex(recipient, "@")+1:])] = true
+	}
+	for domain := range domains {
+		if next := m.nextSend[domain]; next.After(sendAt) {
+			sendAt = next
+		}
+	}
+	for domain := range domains {
+		m.nextSend[domain] = sendAt.Add(m.interval)
+	}
+	return sendAt
+}
+
+// sendMail waits for each recipient domain's rate limit, then sends; it gives
+// up waiting when ctx is cancelled
+func (m *domainRateLimitedMailer) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	if err := sleepContext(ctx, time.Until(m.reserve(recipients))); err != nil {
+		return err
+	}
+	return m.mailer.sendMail(ctx, opts, sender, subject, body, thread, recipients, attachments...)
+}
+
+// applyNotifications sends planned purge warnings with a pool of workers;
+// a recipient's warnings are sent in plan order, and the first failure stops
+// any warnings not yet started
+func applyNotifications(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	actions []PlannedAction,
+	mailSender mailer,
+	state *State,
+	report *Report,
+	status *runStatus,
+	hold *mailHold,
+) error {
+	workers := opts.MailWorkers
+	// a dry run sends nothing, so one worker costs nothing and keeps its
+	// report in plan order
+	if workers < 1 || opts.DryRun {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	type job struct {
+		action PlannedAction
+		after  []chan struct{}
+		done   chan struct{}
+	}
+	jobs := make(chan job)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				for _, earlier := range j.after {
+					<-earlier
+				}
+				if ctx.Err() != nil {
+					close(j.done)
+					mu.Lock()
+					report.recordMessages(queuedMessages(j.action))
+					mu.Unlock()
+					continue
+				}
+				if hold.holding() {
+					close(j.done)
+					mu.Lock()
+					report.recordMessages(heldMessages(j.action))
+					mu.Unlock()
+					continue
+				}
+				started := time.Now()
+				orgOpts := opts.forOrg(j.action.Org.Name)
+				action, stopped, stopErr := stopNotifiedApps(ctx, cfClient, orgOpts, j.action)
+				if j.action.StopApps && !orgOpts.DryRun {
+					hold.record(stopErr)
+					if hold.holding() {
+						close(j.done)
+						mu.Lock()
+						report.recordCleanup(spaceCleanup{AppsStopped: stopped})
+						if stopErr != nil {
+							report.Errors = append(report.Errors, stopErr.Error())
+						}
+						report.recordMessages(heldMessages(action))
+						mu.Unlock()
+						continue
+					}
+				}
+				deliveries := recordDeliveries(mailSender, orgOpts, action)
+				err := applyNotify(ctx, orgOpts, action, deliveries)
+				close(j.done)
+				logActionResult(action, started, err)
+
+				mu.Lock()
+				report.recordCleanup(spaceCleanup{AppsStopped: stopped})
+				if stopErr != nil {
+					report.Errors = append(report.Errors, stopErr.Error())
+				}
+				report.recordMessages(deliveries.results(orgOpts.DryRun, err))
+				report.TemplateFallbacks = append(report.TemplateFallbacks, deliveries.fallbacks...)
+				report.recordAction(action, err)
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("error notifying space %s in org %s: %w", action.Details.Space.Name, action.Org.Name, err)
+					}
+					cancel()
+				} else {
+					report.SpacesNotified++
+					state.recordNotified(action, time.Now().Truncate(24*time.Hour))
+					state.recordNotice(action, report.RunID, time.Now())
+				}
+				status.finishAction(action, report)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	lastForRecipient := map[string]chan struct{}{}
+	dispatched := 0
+dispatch:
+	for _, action := range actions {
+		j := job{action: action, done: make(chan struct{})}
+		for _, recipient := range action.Recipients {
+			recipient = strings.ToLower(recipient)
+			if earlier, ok := lastForRecipient[recipient]; ok {
+				j.after = append(j.after, earlier)
+			}
+			lastForRecipient[recipient] = j.done
+		}
+		select {
+		case jobs <- j:
+			dispatched++
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	for _, action := range actions[dispatched:] {
+		report.recordMessages(queuedMessages(action))
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
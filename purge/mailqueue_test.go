@@ -0,0 +1,174 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+// orderedMailer records the spaces each recipient was warned about, delaying
+// the first message so later ones have a chance to overtake it
+type orderedMailer struct {
+	mu     sync.Mutex
+	sent   map[string][]string
+	calls  int
+	failOn string
+}
+
+func (m *orderedMailer) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	m.mu.Lock()
+	m.calls++
+	first := m.calls == 1
+	m.mu.Unlock()
+	if first {
+		time.Sleep(20 * time.Millisecond)
+	}
+	space := regexp.MustCompile(`sandbox-org/(\w+) space`).FindStringSubmatch(body)[1]
+	if space == m.failOn {
+		return errors.New("mailbox unavailable")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, recipient := range recipients {
+		m.sent[recipient] = append(m.sent[recipient], space)
+	}
+	return nil
+}
+
+func notifyAction(space string, recipients ...string) PlannedAction {
+	return PlannedAction{
+		Action:     planActionNotify,
+		Org:        &resource.Organization{Name: "sandbox-org"},
+		Details:    SpaceDetails{Space: &resource.Space{Name: space}},
+		Recipients: recipients,
+	}
+}
+
+func TestDomainRateLimitedMailerReserve(t *testing.T) {
+	m := newDomainRateLimitedMailer(&mockMailSender{}, time.Minute).(*domainRateLimitedMailer)
+	start := time.Now()
+
+	first := m.reserve([]string{"a@agency.gov"})
+	second := m.reserve([]string{"b@Agency.gov"})
+	other := m.reserve([]string{"c@other.gov"})
+
+	if first.Sub(start) > time.Second {
+		t.Errorf("expected first send to be immediate, got %s", first.Sub(start))
+	}
+	if second.Sub(first) != time.Minute {
+		t.Errorf("expected second send to the same domain a minute later, got %s", second.Sub(first))
+	}
+	if other.Sub(start) > time.Second {
+		t.Errorf("expected send to another domain to be immediate, got %s", other.Sub(start))
+	}
+}
+
+func TestDomainRateLimitedMailerCancellation(t *testing.T) {
+	recorder := &recordingMailer{}
+	m := newDomainRateLimitedMailer(recorder, time.Hour)
+	if err := m.sendMail(context.Background(), SMTPOptions{}, "no-reply@example.gov", "subject", "body", mailThread{}, []string{"a@agency.gov"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the second message to the domain waits an hour unless the wait gives
+	// up with ctx, as it must for a run stopped by SIGTERM
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := m.sendMail(ctx, SMTPOptions{}, "no-reply@example.gov", "subject", "body", mailThread{}, []string{"b@agency.gov"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded while waiting for the domain, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the wait to end with ctx, took %s", elapsed)
+	}
+	if diff := cmp.Diff([]string{"a@agency.gov"}, recorder.recipients); diff != "" {
+		t.Errorf("recipients mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOverrideRecipientMailer(t *testing.T) {
+	recorder := &recordingMailer{}
+	m := newOverrideRecipientMailer(recorder, "test@example.gov")
+	body := "<html><body>\n<p>Your sandbox will be purged</p>\n</body></html>"
+	if err := m.sendMail(context.Background(), SMTPOptions{}, "no-reply@example.gov", "subject", body, mailThread{}, []string{"a@agency.gov", "b@agency.gov"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff([]string{"test@example.gov"}, recorder.recipients); diff != "" {
+		t.Errorf("sendMail() recipients mismatch (-want +got):\n%s", diff)
+	}
+	expectedBody := "<html><body>\n<p><strong>Redirected to test@example.gov; originally addressed to: a@agency.gov, b@agency.gov</strong></p>\n\n<p>Your sandbox will be purged</p>\n</body></html>"
+	if diff := cmp.Diff([]string{expectedBody}, recorder.bodies); diff != "" {
+		t.Errorf("sendMail() body mismatch (-want +got):\n%s", diff)
+	}
+	if newOverrideRecipientMailer(recorder, "") != mailer(recorder) {
+		t.Error("expected no override without a recipient")
+	}
+}
+
+func TestApplyNotifications(t *testing.T) {
+	testCases := map[string]struct {
+		failOn           string
+		expectedSent     map[string][]string
+		expectedNotified int
+		expectedErr      string
+	}{
+		"sends to each recipient in plan order": {
+			expectedSent: map[string][]string{
+				"a@bar.gov": {"first", "third"},
+				"b@bar.gov": {"first", "second", "fourth"},
+				"c@bar.gov": {"fourth"},
+			},
+			expectedNotified: 4,
+		},
+		"skips later warnings to recipients of a failed warning": {
+			failOn:           "first",
+			expectedSent:     map[string][]string{},
+			expectedNotified: 0,
+			expectedErr:      "error notifying space first in org sandbox-org: error sending mail on space first: mailbox unavailable",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			actions := []PlannedAction{
+				notifyAction("first", "a@bar.gov", "b@bar.gov"),
+				notifyAction("second", "b@bar.gov"),
+				notifyAction("third", "a@bar.gov"),
+				notifyAction("fourth", "b@bar.gov", "c@bar.gov"),
+			}
+			opts := Config{
+				TemplateDir: "../templates",
+				MailOptions: MailOptions{MailWorkers: 4},
+			}
+			mailSender := &orderedMailer{sent: map[string][]string{}, failOn: test.failOn}
+			report := &Report{}
+
+			err := applyNotifications(context.Background(), &cfResourceClient{}, opts, actions, mailSender, nil, report, nil, nil)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedSent, mailSender.sent); diff != "" {
+				t.Errorf("sent mismatch (-want +got):\n%s", diff)
+			}
+			if report.SpacesNotified != test.expectedNotified {
+				t.Errorf("expected %d spaces notified, got %d", test.expectedNotified, report.SpacesNotified)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"gopkg.in/yaml.v3"
+)
+
+const manifestAttachmentName = "manifest.yml"
+
+// spaceManifest is a CF app manifest describing a space's apps
+type spaceManifest struct {
+	Applications []manifestApp `yaml:"applications"`
+}
+
+type manifestApp struct {
+	Name string `yaml:"name"`
+	// Lifecycle is only set for cloud native buildpack apps; buildpack is
+	// the default, and docker is implied by Docker
+	Lifecycle  string            `yaml:"lifecycle,omitempty"`
+	Docker     *manifestDocker   `yaml:"docker,omitempty"`
+	Buildpacks []string          `yaml:"buildpacks,omitempty"`
+	Stack      string            `yaml:"stack,omitempty"`
+	Processes  []manifestProcess `yaml:"processes,omitempty"`
+	Routes     []manifestRoute   `yaml:"routes,omitempty"`
+	Services   []string          `yaml:"services,omitempty"`
+}
+
+type manifestDocker struct {
+	Image string `yaml:"image"`
+}
+
+type manifestProcess struct {
+	Type    string `yaml:"type"`
+	Command string `yaml:"command,omitempty"`
+}
+
+type manifestRoute struct {
+	Route string `yaml:"route"`
+}
+
+// buildSpaceManifest generates a manifest listing a space's apps with their
+// lifecycles, buildpacks or Docker images, process types, routes, and bound
+// services, preceded by comments with the
+// commands to recreate its service instances, so users can rebuild the
+// space after it is purged; with includeParameters, the commands carry the
+// instances' parameters where their brokers let them be retrieved
+func buildSpaceManifest(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	space *resource.Space,
+	includeParameters bool,
+) (string, error) {
+	appListOptions := client.NewAppListOptions()
+	appListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	apps, err := cfClient.Applications.ListAll(ctx, appListOptions)
+	if err != nil {
+		return "", fmt.Errorf("error listing apps: %w", err)
+	}
+
+	var droplets map[string]*resource.Droplet
+	if len(apps) > 0 {
+		droplets, err = listCurrentDroplets(ctx, cfClient, space.GUID)
+		if err != nil {
+			return "", fmt.Errorf("error listing droplets: %w", err)
+		}
+	}
+
+	routeListOptions := client.NewRouteListOptions()
+	routeListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	routes, err := cfClient.Routes.ListAll(ctx, routeListOptions)
+	if err != nil {
+		return "", fmt.Errorf("error listing routes: %w", err)
+	}
+
+	instanceListOptions := client.NewServiceInstanceListOptions()
+	instanceListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	instances, err := cfClient.ServiceInstances.ListAll(ctx, instanceListOptions)
+	if err != nil {
+		return "", fmt.Errorf("error listing service instances: %w", err)
+	}
+
+	var bindings []*resource.ServiceCredentialBinding
+	plans := map[string]string{}
+	parameters := map[string]string{}
+	if len(instances) > 0 {
+		var instanceGUIDs []string
+		for _, instance := range instances {
+			instanceGUIDs = append(instanceGUIDs, instance.GUID)
+		}
+		var servicePlans []*resource.ServicePlan
+		var offerings []*resource.ServiceOffering
+		for _, batch := range guidBatches(instanceGUIDs) {
+			bindingListOptions := client.NewServiceCredentialBindingListOptions()
+			bindingListOptions.Type.EqualTo("app")
+			bindingListOptions.ServiceInstanceGUIDs.EqualTo(batch...)
+			batchBindings, err := cfClient.ServiceCredentialBindings.ListAll(ctx, bindingListOptions)
+			if err != nil {
+				return "", fmt.Errorf("error listing service bindings: %w", err)
+			}
+			bindings = append(bindings, batchBindings...)
+			planListOptions := client.NewServicePlanListOptions()
+			planListOptions.ServiceInstanceGUIDs.EqualTo(batch...)
+			batchPlans, batchOfferings, err := cfClient.ServicePlans.ListIncludeServiceOfferingAll(ctx, planListOptions)
+			if err != nil {
+				return "", fmt.Errorf("error listing service plans: %w", err)
+			}
+			servicePlans = append(servicePlans, batchPlans...)
+			offerings = append(offerings, batchOfferings...)
+		}
+		offeringNames := map[string]string{}
+		retrievableOfferings := map[string]bool{}
+		for _, offering := range offerings {
+			offeringNames[offering.GUID] = offering.Name
+			retrievableOfferings[offering.GUID] = offering.BrokerCatalog.Features.InstancesRetrievable
+		}
+		retrievable := map[string]bool{}
+		for _, plan := range servicePlans {
+			offering := plan.Relationships.ServiceOffering.Data.GUID
+			plans[plan.GUID] = offeringNames[offering] + " " + plan.Name
+			retrievable[plan.GUID] = retrievableOfferings[offering]
+		}
+		if includeParameters {
+			parameters = listInstanceParameters(ctx, cfClient, instances, retrievable)
+		}
+	}
+
+	return renderSpaceManifest(org, space, apps, droplets, routes, instances, bindings, plans, parameters)
+}
+
+// listInstanceParameters fetches the parameters of managed service instances
+// whose plans' brokers let them be retrieved, keyed by instance GUID as
+// compact JSON with secret-looking values redacted; instances whose
+// parameters can't be fetched or are empty are left out, since the manifest
+// is still useful without them
+func listInstanceParameters(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	instances []*resource.ServiceInstance,
+	retrievable map[string]bool,
+) map[string]string {
+	parameters := map[string]string{}
+	for _, instance := range instances {
+		plan := instance.Relationships.ServicePlan
+		if plan == nil || plan.Data == nil || !retrievable[plan.Data.GUID] {
+			continue
+		}
+		raw, err := cfClient.ServiceInstances.GetManagedParameters(ctx, instance.GUID)
+		if err != nil {
+			logFields{Err: err}.printf("leaving parameters of service instance %s out of the manifest: %s", instance.Name, err)
+			continue
+		}
+		if raw == nil {
+			continue
+		}
+		redacted, err := redactParameters(*raw)
+		if err != nil || redacted == "{}" || redacted == "null" {
+			continue
+		}
+		parameters[instance.GUID] = redacted
+	}
+	return parameters
+}
+
+// redactedValue replaces secret-looking parameter values
+const redactedValue = "REDACTED"
+
+// secretParameterWords mark a parameter name as holding a secret
+var secretParameterWords = []string{"password", "passwd", "secret", "token", "credential", "private", "apikey", "api_key", "access_key", "passphrase", "auth"}
+
+// redactParameters returns raw as compact JSON with the values of
+// secret-looking keys, at any depth, replaced by redactedValue
+func redactParameters(raw json.RawMessage) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	// keep numbers as written rather than as float64
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	redacted, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return "", err
+	}
+	return string(redacted), nil
+}
+
+func redactValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if isSecretParameter(key) {
+				value[key] = redactedValue
+			} else {
+				value[key] = redactValue(child)
+			}
+		}
+	case []any:
+		for i, child := range value {
+			value[i] = redactValue(child)
+		}
+	}
+	return value
+}
+
+func isSecretParameter(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range secretParameterWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote single-quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// renderSpaceManifest renders a space manifest; droplets maps app GUIDs to
+// their current droplets, plans maps service plan GUIDs to
+// "offering plan", and parameters maps service instance GUIDs to the JSON
+// parameters to create them with
+func renderSpaceManifest(
+	org *resource.Organization,
+	space *resource.Space,
+	apps []*resource.App,
+	droplets map[string]*resource.Droplet,
+	routes []*resource.Route,
+	instances []*resource.ServiceInstance,
+	bindings []*resource.ServiceCredentialBinding,
+	plans map[string]string,
+	parameters map[string]string,
+) (string, error) {
+	instanceNames := map[string]string{}
+	for _, instance := range instances {
+		instanceNames[instance.GUID] = instance.Name
+	}
+	appRoutes := map[string][]manifestRoute{}
+	for _, route := range routes {
+		for _, destination := range route.Destinations {
+			if destination.App.GUID != nil {
+				appRoutes[*destination.App.GUID] = append(appRoutes[*destination.App.GUID], manifestRoute{Route: route.URL})
+			}
+		}
+	}
+	appServices := map[string][]string{}
+	for _, binding := range bindings {
+		app, instance := binding.Relationships.App, binding.Relationships.ServiceInstance
+		if app == nil || app.Data == nil || instance == nil || instance.Data == nil {
+			continue
+		}
+		appServices[app.Data.GUID] = append(appServices[app.Data.GUID], instanceNames[instance.Data.GUID])
+	}
+
+	manifest := spaceManifest{Applications: []manifestApp{}}
+	var missingImages []string
+	for _, app := range apps {
+		services := appServices[app.GUID]
+		sort.Strings(services)
+		droplet := droplets[app.GUID]
+		entry := manifestApp{
+			Name:     app.Name,
+			Routes:   appRoutes[app.GUID],
+			Services: services,
+		}
+		switch appLifecycleType(app) {
+		case lifecycleDocker:
+			if image := dropletImage(droplet); image != "" {
+				entry.Docker = &manifestDocker{Image: image}
+			} else {
+				missingImages = append(missingImages, app.Name)
+			}
+		case lifecycleCNB:
+			entry.Lifecycle = lifecycleCNB
+			fallthrough
+		default:
+			entry.Buildpacks = app.Lifecycle.BuildpackData.Buildpacks
+			entry.Stack = app.Lifecycle.BuildpackData.Stack
+		}
+		for _, processType := range dropletProcessTypes(droplet) {
+			entry.Processes = append(entry.Processes, manifestProcess{
+				Type:    processType,
+				Command: droplet.ProcessTypes[processType],
+			})
+		}
+		manifest.Applications = append(manifest.Applications, entry)
+	}
+	var contents strings.Builder
+	encoder := yaml.NewEncoder(&contents)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(manifest); err != nil {
+		return "", fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Apps and services in the %s/%s sandbox space.\n", org.Name, space.Name)
+	b.WriteString("# After the space is cleared, recreate its services, then push with:\n")
+	b.WriteString("#   cf push -f manifest.yml\n")
+	for _, name := range missingImages {
+		fmt.Fprintf(&b, "# %s runs a Docker image that couldn't be found; add its docker.image before pushing.\n", name)
+	}
+	if len(instances) > 0 {
+		b.WriteString("#\n# Services:\n")
+		for _, instance := range instances {
+			if plan := instance.Relationships.ServicePlan; plan != nil && plan.Data != nil {
+				fmt.Fprintf(&b, "#   cf create-service %s %s", plans[plan.Data.GUID], instance.Name)
+				if params, ok := parameters[instance.GUID]; ok {
+					fmt.Fprintf(&b, " -c %s", shellQuote(params))
+				}
+				b.WriteString("\n")
+				if strings.Contains(parameters[instance.GUID], `"`+redactedValue+`"`) {
+					fmt.Fprintf(&b, "#     (replace each %s value for %s before running it)\n", redactedValue, instance.Name)
+				}
+			} else {
+				fmt.Fprintf(&b, "#   cf create-user-provided-service %s\n", instance.Name)
+			}
+		}
+	}
+	b.WriteString("---\n")
+	b.WriteString(contents.String())
+	return b.String(), nil
+}
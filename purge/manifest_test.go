@@ -0,0 +1,166 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRenderSpaceManifest(t *testing.T) {
+	appGUID := "app-1"
+	apps := []*resource.App{
+		{
+			GUID: appGUID,
+			Name: "web",
+			Lifecycle: resource.Lifecycle{
+				BuildpackData: resource.BuildpackLifecycle{Buildpacks: []string{"python_buildpack"}, Stack: "cflinuxfs4"},
+			},
+		},
+		{GUID: "app-2", Name: "worker"},
+		{GUID: "app-3", Name: "proxy", Lifecycle: resource.Lifecycle{Type: "docker"}},
+		{
+			GUID: "app-4",
+			Name: "api",
+			Lifecycle: resource.Lifecycle{
+				Type:          "cnb",
+				BuildpackData: resource.BuildpackLifecycle{Buildpacks: []string{"docker://paketobuildpacks/go"}, Stack: "cflinuxfs4"},
+			},
+		},
+		{GUID: "app-5", Name: "cache", Lifecycle: resource.Lifecycle{Type: "docker"}},
+	}
+	image := "nginx:1.25"
+	droplets := map[string]*resource.Droplet{
+		appGUID: {ProcessTypes: map[string]string{"web": "python app.py", "worker": "python worker.py"}},
+		"app-3": {Image: &image, ProcessTypes: map[string]string{"web": ""}},
+	}
+	routes := []*resource.Route{{
+		URL:          "web.app.cloud.gov",
+		Destinations: []resource.RouteDestination{{App: resource.RouteDestinationApp{GUID: &appGUID}}},
+	}}
+	db := instanceInSpace("instance-1", "space-1")
+	db.Name = "db"
+	db.Relationships.ServicePlan = &resource.ToOneRelationship{Data: &resource.Relationship{GUID: "plan-1"}}
+	creds := instanceInSpace("instance-2", "space-1")
+	creds.Name = "creds"
+	bindings := []*resource.ServiceCredentialBinding{{
+		Relationships: resource.ServiceCredentialBindingRelationships{
+			App:             &resource.ToOneRelationship{Data: &resource.Relationship{GUID: appGUID}},
+			ServiceInstance: &resource.ToOneRelationship{Data: &resource.Relationship{GUID: "instance-1"}},
+		},
+	}}
+
+	manifest, err := renderSpaceManifest(
+		&resource.Organization{Name: "sandbox-org"},
+		&resource.Space{Name: "foo"},
+		apps,
+		droplets,
+		routes,
+		[]*resource.ServiceInstance{db, creds},
+		bindings,
+		map[string]string{"plan-1": "aws-rds micro-psql"},
+		map[string]string{"instance-1": `{"storage":20,"note":"don't drop","password":"REDACTED"}`},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `# Apps and services in the sandbox-org/foo sandbox space.
+# After the space is cleared, recreate its services, then push with:
+#   cf push -f manifest.yml
+# cache runs a Docker image that couldn't be found; add its docker.image before pushing.
+#
+# Services:
+#   cf create-service aws-rds micro-psql db -c '{"storage":20,"note":"don'\''t drop","password":"REDACTED"}'
+#     (replace each REDACTED value for db before running it)
+#   cf create-user-provided-service creds
+---
+applications:
+  - name: web
+    buildpacks:
+      - python_buildpack
+    stack: cflinuxfs4
+    processes:
+      - type: web
+        command: python app.py
+      - type: worker
+        command: python worker.py
+    routes:
+      - route: web.app.cloud.gov
+    services:
+      - db
+  - name: worker
+  - name: proxy
+    docker:
+      image: nginx:1.25
+    processes:
+      - type: web
+  - name: api
+    lifecycle: cnb
+    buildpacks:
+      - docker://paketobuildpacks/go
+    stack: cflinuxfs4
+  - name: cache
+`
+	if diff := cmp.Diff(expected, manifest); diff != "" {
+		t.Errorf("renderSpaceManifest() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestListInstanceParameters(t *testing.T) {
+	planned := func(guid, plan string) *resource.ServiceInstance {
+		instance := instanceInSpace(guid, "space-1")
+		instance.Name = guid
+		if plan != "" {
+			instance.Relationships.ServicePlan = &resource.ToOneRelationship{Data: &resource.Relationship{GUID: plan}}
+		}
+		return instance
+	}
+	instances := []*resource.ServiceInstance{
+		planned("db", "retrievable"),
+		planned("bucket", "retrievable"),
+		planned("cache", "retrievable"),
+		planned("search", "opaque"),
+		planned("creds", ""),
+	}
+	cfClient := &cfResourceClient{ServiceInstances: &mockServiceInstances{
+		parameters: map[string]json.RawMessage{
+			"db":     json.RawMessage(`{ "storage": 20 }`),
+			"bucket": json.RawMessage(`{"region": "us-gov-west-1", "size": 12345678901234567890, "admin_Password": "hunter2", "replicas": [{"AuthToken": "abc", "zone": "a"}], "credentials": {"user": "u"}}`),
+			"cache":  json.RawMessage(`{}`),
+			"search": json.RawMessage(`{"shards": 3}`),
+		},
+	}}
+
+	parameters := listInstanceParameters(context.Background(), cfClient, instances, map[string]bool{"retrievable": true})
+	expected := map[string]string{
+		"db":     `{"storage":20}`,
+		"bucket": `{"admin_Password":"REDACTED","credentials":"REDACTED","region":"us-gov-west-1","replicas":[{"AuthToken":"REDACTED","zone":"a"}],"size":12345678901234567890}`,
+	}
+	if diff := cmp.Diff(expected, parameters); diff != "" {
+		t.Errorf("listInstanceParameters() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyNotifyAttachesManifest(t *testing.T) {
+	action := PlannedAction{
+		Action:     planActionNotify,
+		Org:        &resource.Organization{Name: "sandbox-org"},
+		Details:    SpaceDetails{Space: &resource.Space{Name: "foo"}},
+		Recipients: []string{"foo@bar.gov"},
+		Manifest:   "applications: []\n",
+	}
+	mailSender := &recordingMailer{}
+	if err := applyNotify(context.Background(), Config{TemplateDir: "../templates", PurgeDays: 30}, action, mailSender); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []mailAttachment{{
+		Name:        "manifest.yml",
+		ContentType: "application/x-yaml",
+		Content:     []byte("applications: []\n"),
+	}}
+	if diff := cmp.Diff(expected, mailSender.attachments); diff != "" {
+		t.Errorf("attachments mismatch (-want +got):\n%s", diff)
+	}
+}
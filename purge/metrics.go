@@ -0,0 +1,77 @@
+package purge
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// metric is a single gauge in a node_exporter textfile
+type metric struct {
+	name  string
+	help  string
+	value float64
+}
+
+// runMetrics describes a finished run as gauges
+func runMetrics(report *Report, runErr error) []metric {
+	success := 0.0
+	if runErr == nil && len(report.Errors) == 0 {
+		success = 1
+	}
+	dryRun := 0.0
+	if report.DryRun {
+		dryRun = 1
+	}
+	metrics := []metric{
+		{"sandbox_purge_last_run_timestamp_seconds", "When the last run started.", float64(report.StartedAt.Unix())},
+		{"sandbox_purge_last_run_duration_seconds", "How long the last run took.", report.FinishedAt.Sub(report.StartedAt).Seconds()},
+		{"sandbox_purge_last_run_success", "Whether the last run finished without errors.", success},
+		{"sandbox_purge_last_run_dry_run", "Whether the last run was a dry run.", dryRun},
+		{"sandbox_purge_spaces_notified", "Spaces sent a purge warning by the last run.", float64(report.SpacesNotified)},
+		{"sandbox_purge_spaces_welcomed", "Spaces sent a welcome email by the last run.", float64(report.SpacesWelcomed)},
+		{"sandbox_purge_spaces_purged", "Spaces purged and recreated by the last run.", float64(report.SpacesPurged)},
+		{"sandbox_purge_spaces_acknowledged", "Notified or purged spaces whose users acknowledged a warning.", float64(report.SpacesAcknowledged)},
+		{"sandbox_purge_instances_purged", "Aged service instances deleted by the last run.", float64(report.InstancesPurged)},
+		{"sandbox_purge_orphans_deleted", "Orphaned service instances deleted by the last run.", float64(report.OrphansDeleted)},
+		{"sandbox_purge_deleted_during_run", "Actions skipped because users deleted their target first.", float64(report.DeletedDuringRun)},
+		{"sandbox_purge_orgs_skipped", "Orgs left for the next run once MAX_RUNTIME ran out.", float64(len(report.OrgsSkipped))},
+		{"sandbox_purge_cf_api_calls", "CF API requests made by the last run.", float64(report.APICalls)},
+		{"sandbox_purge_errors", "Errors recorded by the last run.", float64(len(report.Errors))},
+	}
+	if relays := report.MailRelays; relays != nil {
+		failedOver := 0.0
+		if relays.FailedOver != "" {
+			failedOver = 1
+		}
+		metrics = append(metrics,
+			metric{"sandbox_purge_mail_primary_relay_messages", "Emails sent through SMTP_HOST by the last run.", float64(relays.Primary)},
+			metric{"sandbox_purge_mail_secondary_relay_messages", "Emails sent through SMTP_SECONDARY_HOST by the last run.", float64(relays.Secondary)},
+			metric{"sandbox_purge_mail_relay_failed_over", "Whether the last run failed over to SMTP_SECONDARY_HOST.", failedOver},
+		)
+	}
+	return metrics
+}
+
+// writeMetricsTextfile writes a run's metrics in the Prometheus text format
+// for node_exporter's textfile collector; the file is written next to its
+// final path and renamed into place, so the collector never reads a
+// partial file
+func writeMetricsTextfile(path string, report *Report, runErr error) error {
+	var b strings.Builder
+	for _, m := range runMetrics(report, runErr) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", m.name, m.help, m.name, m.name, strconv.FormatFloat(m.value, 'f', -1, 64))
+	}
+	// node_exporter only reads files ending in .prom, so the temporary file
+	// is skipped
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("error writing metrics file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error replacing metrics file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package purge
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteMetricsTextfile(t *testing.T) {
+	startedAt := time.Date(2024, 3, 10, 6, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		report   *Report
+		runErr   error
+		expected []string
+	}{
+		"successful run": {
+			report: &Report{
+				StartedAt:      startedAt,
+				FinishedAt:     startedAt.Add(90*time.Second + 500*time.Millisecond),
+				SpacesNotified: 3,
+				SpacesPurged:   2,
+				APICalls:       120,
+			},
+			expected: []string{
+				"sandbox_purge_last_run_timestamp_seconds 1710050400",
+				"sandbox_purge_last_run_duration_seconds 90.5",
+				"sandbox_purge_last_run_success 1",
+				"sandbox_purge_spaces_notified 3",
+				"sandbox_purge_spaces_purged 2",
+				"sandbox_purge_cf_api_calls 120",
+				"sandbox_purge_errors 0",
+			},
+		},
+		"partial failure": {
+			report: &Report{
+				StartedAt:  startedAt,
+				FinishedAt: startedAt,
+				Errors:     []string{"error purging space foo"},
+			},
+			expected: []string{
+				"sandbox_purge_last_run_success 0",
+				"sandbox_purge_errors 1",
+			},
+		},
+		"failed over to the secondary relay": {
+			report: &Report{
+				StartedAt:  startedAt,
+				FinishedAt: startedAt,
+				MailRelays: &MailRelayStats{Primary: 4, Secondary: 9, FailedOver: "connection refused"},
+			},
+			expected: []string{
+				"sandbox_purge_mail_primary_relay_messages 4",
+				"sandbox_purge_mail_secondary_relay_messages 9",
+				"sandbox_purge_mail_relay_failed_over 1",
+			},
+		},
+		"aborted run": {
+			report: &Report{StartedAt: startedAt, FinishedAt: startedAt},
+			runErr: errors.New("error getting orgs"),
+			expected: []string{
+				"sandbox_purge_last_run_success 0",
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "sandbox_purge.prom")
+			if err := writeMetricsTextfile(path, test.report, test.runErr); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			contents, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			samples := map[string]bool{}
+			for _, line := range strings.Split(string(contents), "\n") {
+				if line != "" && !strings.HasPrefix(line, "#") {
+					samples[line] = true
+				}
+			}
+			for _, sample := range test.expected {
+				if !samples[sample] {
+					t.Errorf("expected sample %q in:\n%s", sample, contents)
+				}
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			if diff := cmp.Diff([]string{"sandbox_purge.prom"}, names); diff != "" {
+				t.Errorf("metrics directory mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
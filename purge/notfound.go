@@ -0,0 +1,87 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// errDeletedDuringRun marks actions skipped because users deleted the space
+// or service instance after it was listed; they are noted in the report
+// rather than counted as errors
+var errDeletedDuringRun = errors.New("deleted during the run")
+
+// deletedDuringRun returns an error noting that target no longer exists
+func deletedDuringRun(target string) error {
+	return fmt.Errorf("%s was %w", target, errDeletedDuringRun)
+}
+
+// isNotFoundError reports whether a CF API error was caused by the requested
+// resource no longer existing
+func isNotFoundError(err error) bool {
+	var httpErr client.CloudFoundryHTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusNotFound
+	}
+	var cfErrs resource.CloudFoundryErrors
+	if errors.As(err, &cfErrs) {
+		for _, cfErr := range cfErrs.Errors {
+			if isNotFoundError(cfErr) {
+				return true
+			}
+		}
+		return false
+	}
+	var cfErr resource.CloudFoundryError
+	if !errors.As(err, &cfErr) {
+		return false
+	}
+	return strings.HasSuffix(cfErr.Title, "NotFound")
+}
+
+// orgDeleted reports whether an org no longer exists; an org whose lookup
+// fails for any other reason counts as still there. The org is listed
+// rather than fetched with Single, which can't tell no match from several
+func orgDeleted(ctx context.Context, cfClient *cfResourceClient, org *resource.Organization) bool {
+	orgListOptions := client.NewOrganizationListOptions()
+	orgListOptions.GUIDs.EqualTo(org.GUID)
+	orgs, err := cfClient.Organizations.ListAll(ctx, orgListOptions)
+	if err != nil {
+		return isNotFoundError(err)
+	}
+	return len(orgs) == 0
+}
+
+// checkOrgDeleted returns err, or an error noting that org was deleted
+// during the run if a missing resource behind err was deleted with it. The
+// first time an org is found deleted it is added to OrgsDeleted, and the
+// rest of its work is skipped
+func (r *Report) checkOrgDeleted(ctx context.Context, cfClient *cfResourceClient, org *resource.Organization, err error) error {
+	if !errors.Is(err, errDeletedDuringRun) && !isNotFoundError(err) {
+		return err
+	}
+	if r.orgDeletedInRun(org.Name) {
+		return deletedDuringRun("org " + org.Name)
+	}
+	if !orgDeleted(ctx, cfClient, org) {
+		return err
+	}
+	logFields{Org: org.Name, Err: err}.printf("org %s was deleted during the run; skipping the rest of its work", org.Name)
+	r.OrgsDeleted = append(r.OrgsDeleted, org.Name)
+	return deletedDuringRun("org " + org.Name)
+}
+
+// orgDeletedInRun reports whether an org was found deleted during the run
+func (r *Report) orgDeletedInRun(name string) bool {
+	for _, deleted := range r.OrgsDeleted {
+		if deleted == name {
+			return true
+		}
+	}
+	return false
+}
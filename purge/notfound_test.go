@@ -0,0 +1,233 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIsNotFoundError(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"resource not found": {
+			err:      resource.NewResourceNotFoundError(),
+			expected: true,
+		},
+		"space not found": {
+			err:      resource.NewSpaceNotFoundError(),
+			expected: true,
+		},
+		"wrapped": {
+			err:      fmt.Errorf("error deleting space: %w", resource.NewResourceNotFoundError()),
+			expected: true,
+		},
+		"HTTP 404": {
+			err:      client.CloudFoundryHTTPError{StatusCode: http.StatusNotFound},
+			expected: true,
+		},
+		"HTTP 500": {
+			err: client.CloudFoundryHTTPError{StatusCode: http.StatusInternalServerError},
+		},
+		"other CF error": {
+			err: resource.NewServerError(),
+		},
+		"other error": {
+			err: errors.New("boom"),
+		},
+		"nil": {},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := isNotFoundError(test.err); got != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestSpaceDeletedDuringRun(t *testing.T) {
+	notFound := resource.NewResourceNotFoundError()
+	org := &resource.Organization{Name: "sandbox-org"}
+	space := &resource.Space{GUID: "space-1", Name: "foo"}
+	instance := &resource.ServiceInstance{GUID: "instance-1", Name: "db"}
+	opts := Config{TemplateDir: "../templates", InstancePurgeDays: 60}
+
+	testCases := map[string]struct {
+		cfClient    *cfResourceClient
+		operation   func(*cfResourceClient) error
+		expectedErr string
+	}{
+		"planning a warning": {
+			cfClient: &cfResourceClient{Spaces: &mockSpaces{listUsersAllErr: notFound}},
+			operation: func(cfClient *cfResourceClient) error {
+				_, err := planNotify(context.Background(), cfClient, opts, nil, nil, org, SpaceDetails{Space: space}, time.Now())
+				return err
+			},
+			expectedErr: "space foo was deleted during the run",
+		},
+		"planning instance deletions": {
+			cfClient: &cfResourceClient{Spaces: &mockSpaces{listUsersAllErr: notFound}},
+			operation: func(cfClient *cfResourceClient) error {
+				aged := spaceInstances{Space: space, Instances: []*resource.ServiceInstance{instance}}
+				_, err := planPurgeInstances(context.Background(), cfClient, opts, nil, nil, org, aged, time.Time{}, time.Time{})
+				return err
+			},
+			expectedErr: "space foo was deleted during the run",
+		},
+		"deleting the space": {
+			cfClient: &cfResourceClient{
+				Spaces:               &mockSpaces{deleteErr: notFound},
+				Applications:         &mockApplications{},
+				Droplets:             &mockDroplets{},
+				Tasks:                &mockTasks{},
+				Routes:               &mockRoutes{},
+				ServiceInstances:     &mockServiceInstances{},
+				ServiceRouteBindings: &mockServiceRouteBindings{},
+			},
+			operation: func(cfClient *cfResourceClient) error {
+				action := PlannedAction{Action: planActionPurge, Org: org, Details: SpaceDetails{Space: space}}
+				return applyPurge(context.Background(), cfClient, opts, action, &mockMailSender{}, &Report{})
+			},
+			expectedErr: "space foo was deleted during the run",
+		},
+		"cleaning up the space": {
+			cfClient: &cfResourceClient{
+				Spaces:       &mockSpaces{deleteErr: errors.New("space delete failed")},
+				Applications: &mockApplications{apps: []*resource.App{{GUID: "app-1"}}, deleteErr: notFound},
+				Droplets:     &mockDroplets{droplets: []*resource.Droplet{{GUID: "droplet-1"}}, deleteErr: notFound},
+				Tasks:        &mockTasks{tasks: []*resource.Task{{GUID: "task-1"}}, cancelErr: notFound},
+			},
+			operation: func(cfClient *cfResourceClient) error {
+				_, cleanup, err := purgeSpace(context.Background(), cfClient, space, false)
+				if cleanup != (spaceCleanup{}) {
+					return fmt.Errorf("expected no resources cleaned up, got %+v", cleanup)
+				}
+				return err
+			},
+			expectedErr: "space delete failed",
+		},
+		"deleting an aged instance's bindings": {
+			cfClient: &cfResourceClient{
+				ServiceCredentialBindings: &mockServiceCredentialBindings{
+					bindings:  []*resource.ServiceCredentialBinding{{GUID: "binding-1"}},
+					deleteErr: notFound,
+				},
+				ServiceInstances:     &mockServiceInstances{},
+				ServiceRouteBindings: &mockServiceRouteBindings{},
+				Jobs:                 &mockJobs{},
+			},
+			operation: func(cfClient *cfResourceClient) error {
+				action := PlannedAction{Action: planActionPurgeInstance, Org: org, Details: SpaceDetails{Space: space}, ServiceInstance: instance}
+				return applyPurgeInstance(context.Background(), cfClient, opts, action, &mockMailSender{}, &Report{})
+			},
+		},
+		"deleting an aged instance": {
+			cfClient: &cfResourceClient{
+				ServiceCredentialBindings: &mockServiceCredentialBindings{},
+				ServiceInstances:          &mockServiceInstances{deleteErr: notFound},
+				ServiceRouteBindings:      &mockServiceRouteBindings{},
+			},
+			operation: func(cfClient *cfResourceClient) error {
+				action := PlannedAction{Action: planActionPurgeInstance, Org: org, Details: SpaceDetails{Space: space}, ServiceInstance: instance}
+				return applyPurgeInstance(context.Background(), cfClient, opts, action, &mockMailSender{}, &Report{})
+			},
+			expectedErr: "service instance db was deleted during the run",
+		},
+		"deleting an orphan": {
+			cfClient: &cfResourceClient{ServiceInstances: &mockServiceInstances{deleteErr: notFound}},
+			operation: func(cfClient *cfResourceClient) error {
+				action := PlannedAction{Action: planActionDeleteOrphan, Org: org, ServiceInstance: instance}
+				return applyDeleteOrphan(context.Background(), cfClient, opts, action, &Report{})
+			},
+			expectedErr: "orphaned service instance db was deleted during the run",
+		},
+		"annotating the space": {
+			cfClient: &cfResourceClient{Spaces: &mockSpaces{updateErr: notFound}},
+			operation: func(cfClient *cfResourceClient) error {
+				report := &Report{}
+				applySpaceAnnotations(context.Background(), cfClient, opts, []SpaceAnnotation{{Org: org.Name, Space: space.Name, SpaceGUID: space.GUID}}, report)
+				if len(report.Errors) > 0 || report.SpacesAnnotated > 0 {
+					return fmt.Errorf("expected annotation to be skipped, got %+v", report)
+				}
+				return nil
+			},
+		},
+		"listing inventory owners": {
+			cfClient: &cfResourceClient{Spaces: &mockSpaces{listUsersAllErr: notFound}},
+			operation: func(cfClient *cfResourceClient) error {
+				records := []InventoryRecord{{Space: space.Name, SpaceGUID: space.GUID, Decision: inventoryDecisionKeep}}
+				return completeInventory(context.Background(), cfClient, nil, records, nil)
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := test.operation(test.cfClient)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error %q, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRecordActionDeletedDuringRun(t *testing.T) {
+	report := &Report{}
+	action := PlannedAction{
+		Action:  planActionPurge,
+		Org:     &resource.Organization{Name: "sandbox-org"},
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+	}
+	report.recordAction(action, deletedDuringRun("space foo"))
+
+	expected := []SpaceResult{{
+		Org:       "sandbox-org",
+		Space:     "foo",
+		SpaceGUID: "space-1",
+		Action:    planActionPurge,
+		Note:      "space foo was deleted during the run",
+	}}
+	if diff := cmp.Diff(expected, report.Spaces); diff != "" {
+		t.Errorf("recordAction() mismatch (-want +got):\n%s", diff)
+	}
+	if report.DeletedDuringRun != 1 {
+		t.Errorf("expected 1 action deleted during the run, got %d", report.DeletedDuringRun)
+	}
+}
+
+func TestBuildPlanOrgDeleted(t *testing.T) {
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-gone"}
+	space := &resource.Space{GUID: "space-1", Name: "foo"}
+	app := appInSpace("app-1", space.GUID, now.AddDate(0, 0, -26))
+	cfClient := &cfResourceClient{
+		Organizations:    &mockOrganizations{},
+		Applications:     &mockApplications{apps: []*resource.App{app}},
+		ServiceInstances: &mockServiceInstances{},
+		Routes:           &mockRoutes{},
+		Spaces:           &mockSpaces{spaces: []*resource.Space{space}, listUsersAllErr: resource.NewSpaceNotFoundError()},
+		Roles:            &mockMemberRoles{},
+	}
+	state := &State{Spaces: map[string]*SpaceState{}}
+	report := &Report{StartedAt: now}
+	opts := Config{NotifyDays: 25, PurgeDays: 30, TemplateDir: "../templates"}
+
+	plan, err := buildPlan(context.Background(), cfClient, opts, []*resource.Organization{org}, nil, nil, now, time.Time{}, state, report, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(plan.Actions) != 0 || len(report.Spaces) != 0 || len(report.Errors) != 0 {
+		t.Errorf("expected the deleted org's work to be skipped, got actions %+v, results %+v, errors %v", plan.Actions, report.Spaces, report.Errors)
+	}
+	if diff := cmp.Diff([]string{"sandbox-gone"}, report.OrgsDeleted); diff != "" {
+		t.Errorf("OrgsDeleted mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,136 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+func notifySpaceUsers(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	userGUIDs map[string]bool,
+	org *resource.Organization,
+	details SpaceDetails,
+	mailSender mailer,
+) error {
+	action, err := planNotify(ctx, cfClient, opts, userGUIDs, nil, org, details, time.Now())
+	if err != nil {
+		return err
+	}
+	return applyNotify(ctx, opts, action, mailSender)
+}
+
+// planNotify looks up the recipients of a space's purge warning and picks
+// its template by the days left as of now
+func planNotify(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	userGUIDs map[string]bool,
+	rosters spaceRosters,
+	org *resource.Organization,
+	details SpaceDetails,
+	now time.Time,
+) (PlannedAction, error) {
+	spaceUsers, err := rosters.spaceUsers(ctx, cfClient, details.Space)
+	if err != nil {
+		return PlannedAction{}, err
+	}
+
+	recipients, err := listRecipients(userGUIDs, spaceUsers)
+	if err != nil {
+		return PlannedAction{}, fmt.Errorf("error listing recipients on space %s: %w", details.Space.Name, err)
+	}
+
+	var manifest string
+	if opts.AttachManifest {
+		manifest, err = buildSpaceManifest(ctx, cfClient, org, details.Space, opts.AttachManifestParameters)
+		if err != nil {
+			logFields{Org: org.Name, Space: details.Space.Name, Action: planActionNotify, Err: err}.printf("error building manifest for space %s; sending warning without it: %s", details.Space.Name, err)
+		}
+	}
+
+	templateName, subject := opts.notifyTemplate(daysUntilPurge(opts, details, now))
+	logFields{Org: org.Name, Space: details.Space.Name, Action: planActionNotify}.printf("Notifying space %s with %s; recipients %+v", details.Space.Name, templateName, recipients)
+	return PlannedAction{
+		Action:     planActionNotify,
+		Org:        org,
+		Details:    details,
+		Recipients: recipients,
+		Subject:    subject,
+		Template:   templateName,
+		Manifest:   manifest,
+		StopApps:   opts.StopAppsOnNotify,
+	}, nil
+}
+
+// stopNotifiedApps stops the running apps in a warned space when the plan
+// says to, and returns the action with StopApps cleared if they couldn't all
+// be stopped, so the warning doesn't claim they were
+func stopNotifiedApps(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	action PlannedAction,
+) (PlannedAction, int, error) {
+	if !action.StopApps || opts.DryRun {
+		return action, 0, nil
+	}
+	stopped, err := stopSpaceApps(ctx, cfClient, action.Details.Space)
+	if err != nil {
+		action.StopApps = false
+		return action, stopped, fmt.Errorf("error stopping apps in space %s: %w", action.Details.Space.Name, err)
+	}
+	return action, stopped, nil
+}
+
+// applyNotify sends a planned purge warning
+func applyNotify(
+	ctx context.Context,
+	opts Config,
+	action PlannedAction,
+	mailSender mailer,
+) error {
+	if opts.DryRun {
+		return nil
+	}
+
+	// plans saved before warnings had tiers name no template or subject
+	templateName, subject := action.Template, action.Subject
+	if templateName == "" {
+		templateName = notifyTemplateName
+	}
+	if subject == "" {
+		subject = opts.NotifyMailSubject
+	}
+	notifyTemplate, err := parseMailTemplate(opts.TemplateDir, templateName)
+	if err != nil {
+		return fmt.Errorf("error reading notify template: %w", err)
+	}
+
+	org, details, recipients := action.Org, action.Details, action.Recipients
+	data := notifyTemplateData(opts, org, details)
+	data["appsStopped"] = action.StopApps
+	body := renderSpaceMail(notifyTemplate, templateName, data, mailSender, actionFields(action))
+
+	actionFields(action).printf("sending to %s: %s", recipients, body)
+
+	var attachments []mailAttachment
+	if action.Manifest != "" {
+		attachments = append(attachments, mailAttachment{
+			Name:        manifestAttachmentName,
+			ContentType: "application/x-yaml",
+			Content:     []byte(action.Manifest),
+		})
+	}
+	thread := newMailThread(opts.MailSender, details, notifyTier(opts, details, time.Now()))
+	if err := mailSender.sendMail(ctx, opts.SMTPOptions, opts.MailSender, subject, body, thread, recipients, attachments...); err != nil {
+		return fmt.Errorf("error sending mail on space %s: %w", details.Space.Name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStopNotifiedApps(t *testing.T) {
+	apps := []*resource.App{
+		{GUID: "app-1", Name: "web", State: "STARTED"},
+		{GUID: "app-2", Name: "worker", State: "STOPPED"},
+	}
+	testCases := map[string]struct {
+		opts            Config
+		stopApps        bool
+		stopErr         error
+		expectedStopped []string
+		expectedErr     string
+		expectedBody    bool
+	}{
+		"not planned": {},
+		"dry run": {
+			opts:     Config{DryRun: true},
+			stopApps: true,
+		},
+		"stopped": {
+			stopApps:        true,
+			expectedStopped: []string{"app-1"},
+			expectedBody:    true,
+		},
+		"stop failed": {
+			stopApps:        true,
+			stopErr:         errors.New("boom"),
+			expectedStopped: []string{"app-1"},
+			expectedErr:     "error stopping apps in space foo: boom",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			applications := &mockApplications{apps: apps, stopErr: test.stopErr}
+			cfClient := &cfResourceClient{Applications: applications}
+			action := PlannedAction{
+				Action:     planActionNotify,
+				Org:        &resource.Organization{Name: "sandbox-org"},
+				Details:    SpaceDetails{Space: &resource.Space{Name: "foo"}},
+				Recipients: []string{"foo@bar.gov"},
+				StopApps:   test.stopApps,
+			}
+
+			action, _, err := stopNotifiedApps(context.Background(), cfClient, test.opts, action)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %s", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedStopped, applications.stoppedGUIDs); diff != "" {
+				t.Errorf("stopped apps mismatch (-want +got):\n%s", diff)
+			}
+
+			if test.opts.DryRun {
+				return
+			}
+			mailSender := &recordingMailer{}
+			if err := applyNotify(context.Background(), Config{TemplateDir: "../templates", PurgeDays: 30}, action, mailSender); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if said := strings.Contains(mailSender.bodies[0], "we've stopped the applications"); said != test.expectedBody {
+				t.Errorf("expected warning to say apps were stopped: %t, got: %t", test.expectedBody, said)
+			}
+		})
+	}
+}
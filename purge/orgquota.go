@@ -0,0 +1,97 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// isQuotaExceededError reports whether a CF API error was caused by an org or
+// space quota limit
+func isQuotaExceededError(err error) bool {
+	var cfErrs resource.CloudFoundryErrors
+	if errors.As(err, &cfErrs) {
+		for _, cfErr := range cfErrs.Errors {
+			if isQuotaExceededError(cfErr) {
+				return true
+			}
+		}
+		return false
+	}
+	var cfErr resource.CloudFoundryError
+	if !errors.As(err, &cfErr) {
+		return false
+	}
+	return strings.Contains(cfErr.Title, "QuotaExceeded") ||
+		strings.Contains(strings.ToLower(cfErr.Detail), "quota")
+}
+
+// createSpaceWithRetry creates a space; if the org quota is exceeded, it
+// removes org-level leftovers and retries after a delay
+func createSpaceWithRetry(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	options Config,
+	org *resource.Organization,
+	spaceRequest *resource.SpaceCreate,
+) (*resource.Space, error) {
+	for attempt := 0; ; attempt++ {
+		space, err := cfClient.Spaces.Create(ctx, spaceRequest)
+		if err == nil || !isQuotaExceededError(err) || attempt >= options.SpaceCreateRetries {
+			return space, err
+		}
+
+		logFields{Org: org.Name, Space: spaceRequest.Name, Err: err}.printf("org quota exceeded creating space %s in org %s: %s; cleaning up org leftovers and retrying", spaceRequest.Name, org.Name, err)
+		deleted, cleanupErr := deleteOrgLeftovers(ctx, cfClient, org)
+		if cleanupErr != nil {
+			return nil, fmt.Errorf("%w (error cleaning up org leftovers: %s)", err, cleanupErr)
+		}
+		logFields{Org: org.Name, Space: spaceRequest.Name}.printf("deleted %d leftover resources in org %s", deleted, org.Name)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(options.SpaceCreateRetryDelay):
+		}
+	}
+}
+
+// deleteOrgLeftovers deletes service instances and routes in an org that no
+// longer belong to one of its spaces, returning how many were deleted
+func deleteOrgLeftovers(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+) (int, error) {
+	inventory, err := collectOrgInventory(ctx, cfClient, org)
+	if err != nil {
+		return 0, fmt.Errorf("error listing org resources for org %s: %w", org.Name, err)
+	}
+
+	deleted := 0
+	for _, instance := range inventory.Orphans {
+		jobGUID, err := cfClient.ServiceInstances.Delete(ctx, instance.GUID)
+		if err != nil {
+			return deleted, fmt.Errorf("error deleting orphaned service instance %s: %w", instance.Name, err)
+		}
+		if err := waitForJob(ctx, cfClient, jobGUID); err != nil {
+			return deleted, fmt.Errorf("error waiting for delete job %s to be complete: %w", jobGUID, err)
+		}
+		deleted++
+	}
+	for _, route := range inventory.OrphanedRoutes {
+		jobGUID, err := cfClient.Routes.Delete(ctx, route.GUID)
+		if err != nil {
+			return deleted, fmt.Errorf("error deleting orphaned route %s: %w", route.URL, err)
+		}
+		if err := waitForJob(ctx, cfClient, jobGUID); err != nil {
+			return deleted, fmt.Errorf("error waiting for delete job %s to be complete: %w", jobGUID, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
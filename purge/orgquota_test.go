@@ -0,0 +1,159 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockRoutes struct {
+	routes       []*resource.Route
+	deletedGUIDs []string
+}
+
+func (r *mockRoutes) ListAll(ctx context.Context, opts *client.RouteListOptions) ([]*resource.Route, error) {
+	return r.routes, nil
+}
+
+func (r *mockRoutes) Delete(ctx context.Context, guid string) (string, error) {
+	r.deletedGUIDs = append(r.deletedGUIDs, guid)
+	return "", nil
+}
+
+// mockRetrySpaces returns each create error in turn before succeeding
+type mockRetrySpaces struct {
+	mockSpaces
+	spaces      []*resource.Space
+	createErrs  []error
+	createCalls int
+}
+
+func (s *mockRetrySpaces) ListAll(ctx context.Context, opts *client.SpaceListOptions) ([]*resource.Space, error) {
+	return s.spaces, nil
+}
+
+func (s *mockRetrySpaces) Create(ctx context.Context, r *resource.SpaceCreate) (*resource.Space, error) {
+	s.createCalls++
+	if s.createCalls <= len(s.createErrs) {
+		return nil, s.createErrs[s.createCalls-1]
+	}
+	return &resource.Space{Name: r.Name}, nil
+}
+
+func quotaExceededError() error {
+	return resource.CloudFoundryError{
+		Code:   10008,
+		Title:  "CF-UnprocessableEntity",
+		Detail: "You have exceeded the total routes for your organization's quota.",
+	}
+}
+
+func TestIsQuotaExceededError(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"quota detail": {
+			err:      quotaExceededError(),
+			expected: true,
+		},
+		"quota exceeded title": {
+			err:      resource.NewServiceInstanceQuotaExceededError(),
+			expected: true,
+		},
+		"wrapped errors list": {
+			err: fmt.Errorf("error creating space: %w", resource.CloudFoundryErrors{
+				Errors: []resource.CloudFoundryError{quotaExceededError().(resource.CloudFoundryError)},
+			}),
+			expected: true,
+		},
+		"other CF error": {
+			err: resource.NewUnprocessableEntityError(),
+		},
+		"non-CF error": {
+			err: errors.New("connection refused"),
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := isQuotaExceededError(test.err); got != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestCreateSpaceWithRetry(t *testing.T) {
+	space := func(guid string) *resource.Space { return &resource.Space{GUID: guid} }
+	testCases := map[string]struct {
+		createErrs            []error
+		retries               int
+		expectedCreateCalls   int
+		expectedDeletedRoutes []string
+		expectedErr           string
+	}{
+		"creates without retry": {
+			retries:             3,
+			expectedCreateCalls: 1,
+		},
+		"retries after cleaning up leftovers": {
+			createErrs:            []error{quotaExceededError()},
+			retries:               3,
+			expectedCreateCalls:   2,
+			expectedDeletedRoutes: []string{"route-2"},
+		},
+		"gives up after retries": {
+			createErrs:            []error{quotaExceededError(), quotaExceededError()},
+			retries:               1,
+			expectedCreateCalls:   2,
+			expectedDeletedRoutes: []string{"route-2"},
+			expectedErr:           quotaExceededError().Error(),
+		},
+		"does not retry other errors": {
+			createErrs:          []error{errors.New("boom")},
+			retries:             3,
+			expectedCreateCalls: 1,
+			expectedErr:         "boom",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			spaces := &mockRetrySpaces{
+				spaces:     []*resource.Space{space("space-1")},
+				createErrs: test.createErrs,
+			}
+			routes := &mockRoutes{
+				routes: []*resource.Route{
+					{GUID: "route-1", Relationships: resource.RouteRelationships{Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: "space-1"}}}},
+					{GUID: "route-2", Relationships: resource.RouteRelationships{Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: "space-2"}}}},
+				},
+			}
+			cfClient := &cfResourceClient{
+				Applications:     &mockApplications{},
+				ServiceInstances: &mockServiceInstances{},
+				Spaces:           spaces,
+				Routes:           routes,
+				Jobs:             &mockJobs{},
+				Roles:            &mockMemberRoles{},
+			}
+			options := Config{SpaceCreateRetries: test.retries}
+			org := &resource.Organization{GUID: "org-1", Name: "sandbox-org"}
+
+			_, err := createSpaceWithRetry(context.Background(), cfClient, options, org, &resource.SpaceCreate{Name: "space"})
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+			}
+			if spaces.createCalls != test.expectedCreateCalls {
+				t.Errorf("expected %d create calls, got %d", test.expectedCreateCalls, spaces.createCalls)
+			}
+			if diff := cmp.Diff(test.expectedDeletedRoutes, routes.deletedGUIDs); diff != "" {
+				t.Errorf("deleted routes mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// planDeleteOrphan plans the deletion of an orphaned service instance
+func planDeleteOrphan(
+	org *resource.Organization,
+	instance *resource.ServiceInstance,
+) PlannedAction {
+	logFields{Org: org.Name, Action: planActionDeleteOrphan}.printf("Deleting orphaned service instance %s (%s) in org %s", instance.Name, instance.GUID, org.Name)
+	return PlannedAction{
+		Action:          planActionDeleteOrphan,
+		Org:             org,
+		Details:         SpaceDetails{Timestamp: instance.CreatedAt},
+		ServiceInstance: instance,
+	}
+}
+
+// applyDeleteOrphan deletes an orphaned service instance and waits for any
+// asynchronous deprovisioning to finish
+func applyDeleteOrphan(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	action PlannedAction,
+	report *Report,
+) error {
+	if opts.DryRun {
+		return nil
+	}
+
+	instance := action.ServiceInstance
+	actionFields(action).printf("deleting orphaned service instance %s in org %s", instance.Name, action.Org.Name)
+	jobGUID, err := cfClient.ServiceInstances.Delete(ctx, instance.GUID)
+	if isNotFoundError(err) {
+		return deletedDuringRun("orphaned service instance " + instance.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting orphaned service instance %s in org %s: %w", instance.Name, action.Org.Name, err)
+	}
+	if err := waitForDeprovisionJob(ctx, cfClient, opts, jobGUID); err != nil {
+		return fmt.Errorf("error waiting for delete job %s to be complete: %w", jobGUID, err)
+	}
+
+	report.OrphansDeleted++
+	return nil
+}
+
+// waitForJob polls an asynchronous delete job until it completes; deletes
+// that finished synchronously have no job to wait for
+func waitForJob(ctx context.Context, cfClient *cfResourceClient, jobGUID string) error {
+	if jobGUID == "" {
+		return nil
+	}
+	return cfClient.Jobs.PollComplete(ctx, jobGUID, client.NewPollingOptions())
+}
@@ -0,0 +1,132 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockServiceInstances struct {
+	instances     []*resource.ServiceInstance
+	deleteJobGUID string
+	deleteErr     error
+	deletedGUIDs  []string
+	purgeErr      error
+	purgedGUIDs   []string
+	parameters    map[string]json.RawMessage
+	parametersErr error
+	created       []*resource.ServiceInstanceCreate
+}
+
+func (s *mockServiceInstances) CreateUserProvided(ctx context.Context, r *resource.ServiceInstanceCreate) (*resource.ServiceInstance, error) {
+	s.created = append(s.created, r)
+	return &resource.ServiceInstance{GUID: "instance-" + r.Name, Name: r.Name}, nil
+}
+
+func (s *mockServiceInstances) List(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, *client.Pager, error) {
+	return s.instances, &client.Pager{TotalResults: len(s.instances)}, nil
+}
+
+func (s *mockServiceInstances) ListAll(ctx context.Context, opts *client.ServiceInstanceListOptions) ([]*resource.ServiceInstance, error) {
+	return s.instances, nil
+}
+
+func (s *mockServiceInstances) Delete(ctx context.Context, guid string) (string, error) {
+	s.deletedGUIDs = append(s.deletedGUIDs, guid)
+	return s.deleteJobGUID, s.deleteErr
+}
+
+func (s *mockServiceInstances) Purge(ctx context.Context, guid string) error {
+	s.purgedGUIDs = append(s.purgedGUIDs, guid)
+	return s.purgeErr
+}
+
+func (s *mockServiceInstances) GetManagedParameters(ctx context.Context, guid string) (*json.RawMessage, error) {
+	if s.parametersErr != nil {
+		return nil, s.parametersErr
+	}
+	parameters, ok := s.parameters[guid]
+	if !ok {
+		return nil, resource.NewResourceNotFoundError()
+	}
+	return &parameters, nil
+}
+
+func instanceInSpace(guid string, spaceGUID string) *resource.ServiceInstance {
+	return &resource.ServiceInstance{
+		GUID: guid,
+		Relationships: resource.ServiceInstanceRelationships{
+			Space: &resource.ToOneRelationship{
+				Data: &resource.Relationship{GUID: spaceGUID},
+			},
+		},
+	}
+}
+
+func TestApplyDeleteOrphan(t *testing.T) {
+	testCases := map[string]struct {
+		dryRun          bool
+		instances       *mockServiceInstances
+		jobs            *mockJobs
+		expectedDeleted []string
+		expectedCount   int
+		expectedErr     string
+	}{
+		"dry run deletes nothing": {
+			dryRun:    true,
+			instances: &mockServiceInstances{},
+			jobs:      &mockJobs{},
+		},
+		"deletes and waits for job": {
+			instances:       &mockServiceInstances{deleteJobGUID: "job-1"},
+			jobs:            &mockJobs{expectedJobGUID: "job-1"},
+			expectedDeleted: []string{"instance-1"},
+			expectedCount:   1,
+		},
+		"synchronous delete": {
+			instances:       &mockServiceInstances{},
+			jobs:            &mockJobs{},
+			expectedDeleted: []string{"instance-1"},
+			expectedCount:   1,
+		},
+		"delete error": {
+			instances:       &mockServiceInstances{deleteErr: errors.New("boom")},
+			jobs:            &mockJobs{},
+			expectedDeleted: []string{"instance-1"},
+			expectedErr:     "error deleting orphaned service instance orphan in org sandbox-org: boom",
+		},
+		"job error": {
+			instances:       &mockServiceInstances{deleteJobGUID: "job-1"},
+			jobs:            &mockJobs{expectedJobGUID: "job-1", pollErr: errors.New("failed")},
+			expectedDeleted: []string{"instance-1"},
+			expectedErr:     "error waiting for delete job job-1 to be complete: failed",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				ServiceInstances: test.instances,
+				Jobs:             test.jobs,
+			}
+			org := &resource.Organization{Name: "sandbox-org"}
+			instance := &resource.ServiceInstance{GUID: "instance-1", Name: "orphan"}
+			report := &Report{}
+
+			err := applyDeleteOrphan(context.Background(), cfClient, Config{DryRun: test.dryRun}, planDeleteOrphan(org, instance), report)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedDeleted, test.instances.deletedGUIDs); diff != "" {
+				t.Errorf("deleted GUIDs mismatch (-want +got):\n%s", diff)
+			}
+			if report.OrphansDeleted != test.expectedCount {
+				t.Errorf("expected %d orphans deleted, got %d", test.expectedCount, report.OrphansDeleted)
+			}
+		})
+	}
+}
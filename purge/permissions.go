@@ -0,0 +1,69 @@
+package purge
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Run modes recorded in the report
+const (
+	runModeLive   = "live"
+	runModeDryRun = "dry-run"
+	// runModeReportOnly is a run configured to make changes that only
+	// reported them, because its client can't or the kill switch was engaged
+	runModeReportOnly = "report-only"
+)
+
+// CF API scopes that let a client change resources; a client with neither,
+// such as one with only cloud_controller.admin_read_only or
+// cloud_controller.global_auditor, can only read
+var writeScopes = []string{"cloud_controller.admin", "cloud_controller.write"}
+
+// tokenScopes returns the scopes granted by a UAA access token. The token
+// isn't verified; the CF API does that on every request
+func tokenScopes(token string) ([]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding access token: %w", err)
+	}
+	var claims struct {
+		Scope []string `json:"scope"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("error decoding access token: %w", err)
+	}
+	return claims.Scope, nil
+}
+
+// missingWriteAccess returns why the client can't make changes, or "" if
+// its token has a write scope
+func missingWriteAccess(ctx context.Context, cfClient *cfResourceClient) (string, error) {
+	token, err := cfClient.Auth.AccessToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting token: %w", err)
+	}
+	scopes, err := tokenScopes(token)
+	if err != nil {
+		return "", err
+	}
+	for _, scope := range scopes {
+		for _, write := range writeScopes {
+			if scope == write {
+				return "", nil
+			}
+		}
+	}
+	granted := strings.Join(scopes, ", ")
+	if granted == "" {
+		granted = "none"
+	}
+	return fmt.Sprintf("client has none of the scopes %s (has %s)", strings.Join(writeScopes, ", "), granted), nil
+}
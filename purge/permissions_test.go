@@ -0,0 +1,74 @@
+package purge
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testToken returns an unsigned JWT with a payload
+func testToken(payload string) string {
+	return "e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func TestTokenScopes(t *testing.T) {
+	scopes, err := tokenScopes(testToken(`{"scope":["cloud_controller.read","cloud_controller.admin"]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff([]string{"cloud_controller.read", "cloud_controller.admin"}, scopes); diff != "" {
+		t.Errorf("tokenScopes() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := tokenScopes("token"); err == nil {
+		t.Error("expected an error for a token that isn't a JWT")
+	}
+}
+
+func TestMissingWriteAccess(t *testing.T) {
+	testCases := map[string]struct {
+		token          string
+		expectedReason string
+		expectErr      bool
+	}{
+		"admin": {
+			token: testToken(`{"scope":["cloud_controller.admin"]}`),
+		},
+		"write": {
+			token: testToken(`{"scope":["cloud_controller.read","cloud_controller.write"]}`),
+		},
+		"read only": {
+			token:          testToken(`{"scope":["cloud_controller.admin_read_only","cloud_controller.global_auditor"]}`),
+			expectedReason: "client has none of the scopes cloud_controller.admin, cloud_controller.write (has cloud_controller.admin_read_only, cloud_controller.global_auditor)",
+		},
+		"no scopes": {
+			token:          testToken(`{}`),
+			expectedReason: "client has none of the scopes cloud_controller.admin, cloud_controller.write (has none)",
+		},
+		"opaque token": {
+			token:     "token",
+			expectErr: true,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{Auth: &mockAuth{token: test.token}}
+			reason, err := missingWriteAccess(context.Background(), cfClient)
+			if test.expectErr != (err != nil) {
+				t.Fatalf("expected error: %t, got: %v", test.expectErr, err)
+			}
+			if reason != test.expectedReason {
+				t.Errorf("expected reason %q, got %q", test.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestReportOnly(t *testing.T) {
+	report := &Report{Mode: runModeLive}
+	report.reportOnly("kill switch engaged")
+	if !report.DryRun || report.Mode != runModeReportOnly || report.ModeReason != "kill switch engaged" {
+		t.Errorf("unexpected report after reportOnly: %+v", report)
+	}
+}
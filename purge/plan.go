@@ -0,0 +1,672 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+const (
+	planActionNotify  = "notify"
+	planActionPurge   = "purge"
+	planActionWelcome = "welcome"
+
+	planActionDeleteOrphan  = "delete-orphan"
+	planActionPurgeInstance = "purge-instance"
+)
+
+// Plan describes every action a run will take, so it can be reviewed before
+// being applied
+type Plan struct {
+	CreatedAt   time.Time         `json:"created_at"`
+	Actions     []PlannedAction   `json:"actions"`
+	Annotations []SpaceAnnotation `json:"annotations,omitempty"`
+	// Inventory describes every evaluated space; it is exported separately
+	// rather than saved with the plan
+	Inventory []InventoryRecord `json:"-"`
+}
+
+// PlannedAction describes a single action on a space, on an aged service
+// instance in a space, or on an orphaned service instance that no longer
+// belongs to a space
+type PlannedAction struct {
+	Action          string                    `json:"action"`
+	Org             *resource.Organization    `json:"org"`
+	Details         SpaceDetails              `json:"details"`
+	ServiceInstance *resource.ServiceInstance `json:"service_instance,omitempty"`
+	// InstanceCost is the monthly cost of the plan of a service instance
+	// deleted early for its cost
+	InstanceCost float64 `json:"instance_cost,omitempty"`
+	Quota        string  `json:"quota,omitempty"`
+	// IsolationSegment is the GUID of the purged space's isolation segment,
+	// reassigned to the recreated space
+	IsolationSegment string `json:"isolation_segment,omitempty"`
+	// SSHEnabled is whether SSH is enabled on the recreated space; plans
+	// saved before it was recorded leave the CF default
+	SSHEnabled *bool `json:"ssh_enabled,omitempty"`
+	// SecurityGroups are bound to the recreated space
+	SecurityGroups []spaceSecurityGroup `json:"security_groups,omitempty"`
+	Developers     []spaceUser          `json:"developers,omitempty"`
+	Managers       []spaceUser          `json:"managers,omitempty"`
+	Recipients     []string             `json:"recipients"`
+	Subject        string               `json:"subject"`
+	// Template is the email template of a purge warning, chosen by the
+	// warning's tier
+	Template string `json:"template,omitempty"`
+	// Manifest describes the space's apps and services, attached to purge
+	// warnings so users can recreate them
+	Manifest string `json:"manifest,omitempty"`
+	// StopApps stops a warned space's running apps along with the warning
+	StopApps bool `json:"stop_apps,omitempty"`
+	// ContentsDiff is what changed in a purged space since the previous run
+	// saw it, for the operator digest
+	ContentsDiff *SpaceContentsDiff `json:"contents_diff,omitempty"`
+	// AcknowledgedAt is when a user acknowledged an earlier purge warning
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	// PendingDeprovisionSince is when an earlier run's purge of the space
+	// started waiting for its service instances to deprovision; its users
+	// were emailed then, so the retry doesn't email them again
+	PendingDeprovisionSince *time.Time `json:"pending_deprovision_since,omitempty"`
+}
+
+// target names what the action applies to
+func (a PlannedAction) target() string {
+	if a.ServiceInstance != nil {
+		return "service instance " + a.ServiceInstance.Name
+	}
+	return "space " + a.Details.Space.Name
+}
+
+// compact returns the action with its own copies of the space and service
+// instance, trimmed to the GUIDs, names, timestamps, relationships, and
+// metadata that applying the action and its email templates read. The
+// listed resources also carry links and the like, and a plan holds its
+// actions for every org until it is applied, so keeping them whole made the
+// plan outgrow the listings of the org being planned
+func (a PlannedAction) compact() PlannedAction {
+	if space := a.Details.Space; space != nil {
+		a.Details.Space = &resource.Space{
+			GUID:          space.GUID,
+			CreatedAt:     space.CreatedAt,
+			UpdatedAt:     space.UpdatedAt,
+			Name:          space.Name,
+			Relationships: space.Relationships,
+			Metadata:      space.Metadata,
+		}
+	}
+	if instance := a.ServiceInstance; instance != nil {
+		a.ServiceInstance = &resource.ServiceInstance{
+			GUID:          instance.GUID,
+			CreatedAt:     instance.CreatedAt,
+			UpdatedAt:     instance.UpdatedAt,
+			Name:          instance.Name,
+			Type:          instance.Type,
+			Relationships: instance.Relationships,
+			Metadata:      instance.Metadata,
+		}
+	}
+	return a
+}
+
+// add adds actions to the plan, compacted
+func (p *Plan) add(actions ...PlannedAction) {
+	for _, action := range actions {
+		p.Actions = append(p.Actions, action.compact())
+	}
+}
+
+// buildPlan evaluates every sandbox org and plans the notify and purge
+// actions for its spaces; failures planning a purge, and spaces deleted
+// since they were listed, are recorded in report and left out of the plan.
+// Orgs skipped by the previous run are evaluated first, and once MAX_RUNTIME
+// runs out no further orgs are started; the remaining orgs are remembered in
+// state for the next run
+func buildPlan(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	orgs []*resource.Organization,
+	userGUIDs map[string]bool,
+	systemPlans map[string]bool,
+	now time.Time,
+	timeStartsAt time.Time,
+	state *State,
+	report *Report,
+	prof *profiler,
+	status *runStatus,
+) (*Plan, error) {
+	plan := &Plan{CreatedAt: time.Now()}
+	policies, err := parseRecurrencePolicies(opts.NotifyRecurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs = prioritizeOrgs(orgs, state.skippedOrgs())
+	var skipped []*resource.Organization
+orgs:
+	for i, org := range orgs {
+		if i > 0 && budgetExceeded(opts.MaxRuntime, report.StartedAt) {
+			skipped = orgs[i:]
+			break
+		}
+		status.startOrg(org.Name, i, len(orgs))
+		orgOpts := opts.forOrg(org.Name)
+		evaluation, err := evaluateOrg(ctx, cfClient, org, orgOpts, systemPlans, now, timeStartsAt)
+		if err = report.checkOrgDeleted(ctx, cfClient, org, err); report.orgDeletedInRun(org.Name) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// actions planned for the org are dropped if it turns out to have
+		// been deleted
+		planned := len(plan.Actions)
+		if opts.constrained() {
+			evaluation = evaluation.onlySpaces(opts.spaceGUIDs)
+		}
+		if opts.watching {
+			evaluation = evaluation.forWatch()
+		}
+		if orgOpts.SkipCustomQuotaSpaces {
+			custom, err := findCustomQuotaSpaces(ctx, cfClient, orgOpts, org, evaluation, report)
+			if err != nil {
+				return nil, err
+			}
+			for _, space := range custom {
+				logFields{Org: org.Name, Space: space.Name}.printf("skipping space %s in org %s for review; it is assigned quota %s rather than %s", space.Name, org.Name, spaceQuotaGUID(space), orgOpts.SandboxQuotaName)
+				report.SpacesCustomQuota = append(report.SpacesCustomQuota, org.Name+"/"+space.Name)
+			}
+			evaluation = evaluation.withoutSpaces(custom)
+		}
+		for _, over := range evaluation.overCaps {
+			exceeded := strings.Join(over.Exceeded, ", ")
+			if orgOpts.EnforceSpaceCaps && !orgOpts.DisablePurge {
+				logFields{Org: org.Name, Space: over.Space.Name, Action: planActionPurge}.printf("purging space %s in org %s over its caps: %s", over.Space.Name, org.Name, exceeded)
+			} else {
+				logFields{Org: org.Name, Space: over.Space.Name}.printf("space %s in org %s is over its caps: %s", over.Space.Name, org.Name, exceeded)
+			}
+			report.SpacesOverCaps = append(report.SpacesOverCaps, fmt.Sprintf("%s/%s: %s", org.Name, over.Space.Name, exceeded))
+		}
+
+		contents := map[string]SpaceContents{}
+		for _, space := range evaluation.contents {
+			contents[space.SpaceGUID] = space
+		}
+
+		for _, details := range evaluation.toNotify {
+			if !shouldNotify(policies, state, org.Name, details, now) {
+				logFields{Org: org.Name, Space: details.Space.Name, Action: planActionNotify}.printf("skipping purge warning for space %s in org %s; last warned %s", details.Space.Name, org.Name, state.lastNotified(details.Space.GUID).Format("2006-01-02"))
+				continue
+			}
+			if opts.OnlyNotifyNew && state.notifiedInCycle(details.Space.GUID, details.Timestamp) {
+				logFields{Org: org.Name, Space: details.Space.Name, Action: planActionNotify}.printf("skipping purge warning for space %s in org %s; already warned %s", details.Space.Name, org.Name, state.lastNotified(details.Space.GUID).Format("2006-01-02"))
+				continue
+			}
+			action, err := planNotify(ctx, cfClient, orgOpts, userGUIDs, evaluation.rosters, org, details, now)
+			if err = report.checkOrgDeleted(ctx, cfClient, org, err); report.orgDeletedInRun(org.Name) {
+				plan.Actions = plan.Actions[:planned]
+				continue orgs
+			}
+			if errors.Is(err, errDeletedDuringRun) {
+				report.recordAction(PlannedAction{Action: planActionNotify, Org: org, Details: details}, err)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error notifying space %s in org %s: %w", details.Space.Name, org.Name, err)
+			}
+			action.AcknowledgedAt = state.acknowledgedAt(details.Space.GUID)
+			plan.add(action)
+		}
+
+		for _, details := range evaluation.toWelcome {
+			if !shouldWelcome(state, details) {
+				continue
+			}
+			action, err := planWelcome(ctx, cfClient, orgOpts, userGUIDs, evaluation.rosters, org, details)
+			if err = report.checkOrgDeleted(ctx, cfClient, org, err); report.orgDeletedInRun(org.Name) {
+				plan.Actions = plan.Actions[:planned]
+				continue orgs
+			}
+			if errors.Is(err, errDeletedDuringRun) {
+				report.recordAction(PlannedAction{Action: planActionWelcome, Org: org, Details: details}, err)
+				continue
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			plan.add(action)
+		}
+
+		for _, details := range evaluation.toPurge {
+			if isPurgeBlocked(details.Space) {
+				logFields{Org: org.Name, Space: details.Space.Name, Action: planActionPurge}.printf("skipping purge of space %s in org %s; it is labeled %s", details.Space.Name, org.Name, labelPurgeBlocked)
+				continue
+			}
+			action, err := planPurge(ctx, cfClient, orgOpts, userGUIDs, evaluation.rosters, org, details)
+			if err = report.checkOrgDeleted(ctx, cfClient, org, err); report.orgDeletedInRun(org.Name) {
+				plan.Actions = plan.Actions[:planned]
+				continue orgs
+			}
+			if errors.Is(err, errDeletedDuringRun) {
+				report.recordAction(PlannedAction{Action: planActionPurge, Org: org, Details: details}, err)
+				continue
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			action.AcknowledgedAt = state.acknowledgedAt(details.Space.GUID)
+			action.PendingDeprovisionSince = state.pendingDeprovisionSince(details.Space.GUID)
+			if current, ok := contents[details.Space.GUID]; ok {
+				action.ContentsDiff = diffSpaceContents(state.spaceContents(details.Space.GUID), current)
+			}
+			plan.add(action)
+		}
+
+		for _, aged := range evaluation.agedInstances {
+			actions, err := planPurgeInstances(ctx, cfClient, orgOpts, userGUIDs, evaluation.rosters, org, aged, now, timeStartsAt)
+			if err = report.checkOrgDeleted(ctx, cfClient, org, err); report.orgDeletedInRun(org.Name) {
+				plan.Actions = plan.Actions[:planned]
+				continue orgs
+			}
+			if errors.Is(err, errDeletedDuringRun) {
+				report.recordAction(PlannedAction{Action: planActionPurgeInstance, Org: org, Details: SpaceDetails{Space: aged.Space}}, err)
+				continue
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			plan.add(actions...)
+		}
+
+		if !orgOpts.DisablePurge {
+			for _, details := range evaluation.toDeleteEmpty {
+				plan.add(planDeleteEmpty(org, details))
+			}
+		}
+
+		for _, instance := range evaluation.orphans {
+			plan.add(planDeleteOrphan(org, instance))
+		}
+		state.recordContents(org, evaluation.contents)
+		plan.Annotations = append(plan.Annotations, evaluation.annotations...)
+		plan.Inventory = append(plan.Inventory, evaluation.inventory...)
+		prof.phase("plan org " + org.Name)
+	}
+
+	if !opts.constrained() {
+		state.recordSkippedOrgs(skipped)
+	}
+	for _, org := range skipped {
+		report.OrgsSkipped = append(report.OrgsSkipped, org.Name)
+	}
+	if len(skipped) > 0 {
+		logFields{}.printf("max runtime of %s exceeded; leaving %d orgs for the next run", opts.MaxRuntime, len(skipped))
+	}
+
+	if err := completeInventory(ctx, cfClient, userGUIDs, plan.Inventory, plan.Actions); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("error building inventory: %s", err))
+		plan.Inventory = nil
+	}
+	return plan, nil
+}
+
+// applyPlan sends the plan's purge warnings concurrently, then executes its
+// remaining actions in order
+func applyPlan(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	plan *Plan,
+	mailSender mailer,
+	state *State,
+	report *Report,
+	status *runStatus,
+	triage *triageCollector,
+) error {
+	var notifications, actions []PlannedAction
+	for _, action := range plan.Actions {
+		if action.Action == planActionNotify {
+			notifications = append(notifications, action)
+		} else {
+			actions = append(actions, action)
+		}
+	}
+	// when mail waits on CF, purges run first, so failures reaching CF hold
+	// the warnings that follow
+	hold := newMailHold(opts)
+	if !hold.enabled() {
+		if err := applyHaltableNotifications(ctx, cfClient, opts, notifications, mailSender, state, report, status, hold); err != nil {
+			return err
+		}
+	}
+
+	var purged []PlannedAction
+	var (
+		lastOrg string
+		halted  bool
+	)
+	throttle := newPurgeThrottle(opts, report.StartedAt)
+	for _, action := range actions {
+		started := time.Now()
+		orgOpts := opts.forOrg(action.Org.Name)
+		// the kill switch is read again between orgs rather than before
+		// every action
+		if action.Org.GUID != lastOrg {
+			lastOrg = action.Org.GUID
+			halted = opts.halted(ctx, cfClient, report)
+		}
+		if !halted && action.Action == planActionPurge {
+			waited, err := throttle.wait(ctx)
+			if errors.Is(err, errPurgeDeferred) {
+				skipDeferred(action, report, status)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error waiting to purge %s: %w", action.target(), err)
+			}
+			// a throttled run can last hours, so the kill switch is also
+			// read after each wait
+			if waited {
+				halted = opts.halted(ctx, cfClient, report)
+				started = time.Now()
+			}
+		}
+		if report.orgDeletedInRun(action.Org.Name) {
+			report.recordAction(action, deletedDuringRun("org "+action.Org.Name))
+			status.finishAction(action, report)
+			continue
+		}
+		if halted {
+			skipHalted(mailSender, orgOpts, action, report, status)
+			continue
+		}
+		deliveries := recordDeliveries(mailSender, orgOpts, action)
+		var err error
+		switch action.Action {
+		case planActionPurge:
+			err = applyPurge(ctx, cfClient, orgOpts, action, deliveries, report)
+			err = report.checkOrgDeleted(ctx, cfClient, action.Org, err)
+			report.recordAction(action, err)
+			var pending *deprovisionPendingError
+			if errors.As(err, &pending) {
+				report.SpacesPendingDeprovision = append(report.SpacesPendingDeprovision, action.Org.Name+"/"+action.Details.Space.Name)
+				state.recordPendingDeprovision(action, time.Now())
+				err = nil
+				break
+			}
+			var mismatch *spaceMismatchError
+			if err != nil && !errors.Is(err, errDeletedDuringRun) {
+				report.Errors = append(report.Errors, err.Error())
+			}
+			if err == nil || errors.Is(err, errDeletedDuringRun) || errors.As(err, &mismatch) {
+				state.forget(action.Details.Space.GUID)
+			}
+			if err == nil {
+				purged = append(purged, action)
+				state.recordNotice(action, report.RunID, time.Now())
+			}
+		case planActionPurgeInstance:
+			err = applyPurgeInstance(ctx, cfClient, orgOpts, action, deliveries, report)
+			err = report.checkOrgDeleted(ctx, cfClient, action.Org, err)
+			report.recordAction(action, err)
+			if err != nil && !errors.Is(err, errDeletedDuringRun) {
+				report.Errors = append(report.Errors, err.Error())
+			}
+			if err == nil {
+				state.recordNotice(action, report.RunID, time.Now())
+			}
+		case planActionWelcome:
+			if hold.holding() {
+				report.recordMessages(heldMessages(action))
+				status.finishAction(action, report)
+				continue
+			}
+			err = applyWelcome(ctx, orgOpts, action, deliveries)
+			report.recordAction(action, err)
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			} else {
+				report.SpacesWelcomed++
+				state.recordWelcomed(action)
+				state.recordNotice(action, report.RunID, time.Now())
+			}
+		case planActionDeleteOrphan:
+			err = applyDeleteOrphan(ctx, cfClient, orgOpts, action, report)
+			err = report.checkOrgDeleted(ctx, cfClient, action.Org, err)
+			report.recordAction(action, err)
+			if err != nil && !errors.Is(err, errDeletedDuringRun) {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		case planActionDeleteEmpty:
+			err = applyDeleteEmpty(ctx, cfClient, orgOpts, action, report)
+			err = report.checkOrgDeleted(ctx, cfClient, action.Org, err)
+			report.recordAction(action, err)
+			if err != nil && !errors.Is(err, errDeletedDuringRun) {
+				report.Errors = append(report.Errors, err.Error())
+			}
+			if err == nil || errors.Is(err, errDeletedDuringRun) {
+				state.forget(action.Details.Space.GUID)
+			}
+		default:
+			return fmt.Errorf("unknown planned action %s for %s", action.Action, action.target())
+		}
+		if action.Action != planActionWelcome && !orgOpts.DryRun {
+			hold.record(err)
+		}
+		report.recordMessages(deliveries.results(orgOpts.DryRun, err))
+		report.TemplateFallbacks = append(report.TemplateFallbacks, deliveries.fallbacks...)
+		logActionResult(action, started, err)
+		if err != nil && !errors.Is(err, errDeletedDuringRun) {
+			if err := triage.collect(ctx, cfClient, action, err, started); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+		status.finishAction(action, report)
+	}
+	verifyPurgeSample(ctx, cfClient, opts, purged, report)
+	if hold.enabled() {
+		if err := applyHaltableNotifications(ctx, cfClient, opts, notifications, mailSender, state, report, status, hold); err != nil {
+			return err
+		}
+	}
+	if opts.halted(ctx, cfClient, report) {
+		return nil
+	}
+	applySpaceAnnotations(ctx, cfClient, opts, plan.Annotations, report)
+	return nil
+}
+
+// applyHaltableNotifications sends the plan's warnings unless the kill
+// switch is engaged, in which case they are all skipped
+func applyHaltableNotifications(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	notifications []PlannedAction,
+	mailSender mailer,
+	state *State,
+	report *Report,
+	status *runStatus,
+	hold *mailHold,
+) error {
+	if len(notifications) > 0 && opts.halted(ctx, cfClient, report) {
+		for _, action := range notifications {
+			skipHalted(mailSender, opts.forOrg(action.Org.Name), action, report, status)
+		}
+		return nil
+	}
+	return applyNotifications(ctx, cfClient, opts, notifications, mailSender, state, report, status, hold)
+}
+
+// counts returns the number of planned actions of each kind
+func (p *Plan) counts() map[string]int {
+	counts := map[string]int{}
+	for _, action := range p.Actions {
+		counts[action.Action]++
+	}
+	return counts
+}
+
+// writeText writes a human-readable summary of the plan
+func (p *Plan) writeText(w io.Writer) error {
+	counts := p.counts()
+	var b strings.Builder
+	fmt.Fprintf(&b, "Plan: %d to notify, %d to purge", counts[planActionNotify], counts[planActionPurge])
+	if n := counts[planActionWelcome]; n > 0 {
+		fmt.Fprintf(&b, ", %d to welcome", n)
+	}
+	if n := counts[planActionPurgeInstance]; n > 0 {
+		fmt.Fprintf(&b, ", %d aged service instances to delete", n)
+	}
+	if n := counts[planActionDeleteOrphan]; n > 0 {
+		fmt.Fprintf(&b, ", %d orphaned service instances to delete", n)
+	}
+	if n := counts[planActionDeleteEmpty]; n > 0 {
+		fmt.Fprintf(&b, ", %d empty recreated spaces to delete", n)
+	}
+	b.WriteString("\n")
+	for _, action := range p.Actions {
+		if action.Action == planActionPurgeInstance {
+			fmt.Fprintf(
+				&b,
+				"\n  %s %s/%s/%s (created %s)\n      email %q to: %s\n",
+				action.Action,
+				action.Org.Name,
+				action.Details.Space.Name,
+				action.ServiceInstance.Name,
+				action.Details.Timestamp.Format("2006-01-02"),
+				action.Subject,
+				formatRecipients(action.Recipients),
+			)
+			continue
+		}
+		if action.Action == planActionDeleteEmpty {
+			fmt.Fprintf(
+				&b,
+				"\n  %s %s/%s (recreated %s)\n",
+				action.Action,
+				action.Org.Name,
+				action.Details.Space.Name,
+				action.Details.Timestamp.Format("2006-01-02"),
+			)
+			continue
+		}
+		if action.Action == planActionDeleteOrphan {
+			fmt.Fprintf(
+				&b,
+				"\n  %s %s/%s (created %s)\n",
+				action.Action,
+				action.Org.Name,
+				action.ServiceInstance.Name,
+				action.Details.Timestamp.Format("2006-01-02"),
+			)
+			continue
+		}
+		fmt.Fprintf(
+			&b,
+			"\n  %s %s/%s (first resource %s)\n",
+			action.Action,
+			action.Org.Name,
+			action.Details.Space.Name,
+			action.Details.Timestamp.Format("2006-01-02"),
+		)
+		if action.Action == planActionPurge {
+			fmt.Fprintf(&b, "      recreate with quota: %s\n", action.Quota)
+			if action.IsolationSegment != "" {
+				fmt.Fprintf(&b, "      isolation segment:   %s\n", action.IsolationSegment)
+			}
+			if action.SSHEnabled != nil {
+				fmt.Fprintf(&b, "      ssh:                 %s\n", formatSSHEnabled(*action.SSHEnabled))
+			}
+			if len(action.SecurityGroups) > 0 {
+				fmt.Fprintf(&b, "      security groups:     %s\n", formatSecurityGroups(action.SecurityGroups))
+			}
+			fmt.Fprintf(&b, "      re-add developers:   %s\n", formatSpaceUsers(action.Developers))
+			fmt.Fprintf(&b, "      re-add managers:     %s\n", formatSpaceUsers(action.Managers))
+			if action.PendingDeprovisionSince != nil {
+				fmt.Fprintf(&b, "      retry; deprovisioning since %s, users already emailed\n", action.PendingDeprovisionSince.Format("2006-01-02"))
+			}
+			if action.ContentsDiff.Changed() {
+				fmt.Fprintf(&b, "      changed since %s: %s\n", action.ContentsDiff.Since.Format("2006-01-02"), formatContentsDiff(action.ContentsDiff))
+			}
+		}
+		if action.StopApps {
+			b.WriteString("      stop running apps\n")
+		}
+		fmt.Fprintf(&b, "      email %q to: %s\n", action.Subject, formatRecipients(action.Recipients))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatContentsDiff(diff *SpaceContentsDiff) string {
+	var changes []string
+	for _, change := range []struct {
+		label string
+		names []string
+	}{
+		{"apps added", diff.AppsAdded},
+		{"apps removed", diff.AppsRemoved},
+		{"service instances added", diff.ServiceInstancesAdded},
+		{"service instances removed", diff.ServiceInstancesRemoved},
+	} {
+		if len(change.names) > 0 {
+			changes = append(changes, change.label+" "+strings.Join(change.names, ", "))
+		}
+	}
+	return strings.Join(changes, "; ")
+}
+
+func formatRecipients(recipients []string) string {
+	if len(recipients) == 0 {
+		return "(none)"
+	}
+	return strings.Join(recipients, ", ")
+}
+
+func formatSpaceUsers(users []spaceUser) string {
+	if len(users) == 0 {
+		return "(none)"
+	}
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	return strings.Join(usernames, ", ")
+}
+
+// writePlanFile writes a plan as JSON to path
+func writePlanFile(path string, plan *Plan) error {
+	contents, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding plan: %w", err)
+	}
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		return fmt.Errorf("error writing plan %s: %w", path, err)
+	}
+	logFields{}.printf("wrote plan to %s", path)
+	return nil
+}
+
+// readPlanFile reads a plan previously written by writePlanFile
+func readPlanFile(path string) (*Plan, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plan %s: %w", path, err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(contents, &plan); err != nil {
+		return nil, fmt.Errorf("error decoding plan %s: %w", path, err)
+	}
+	return &plan, nil
+}
@@ -0,0 +1,251 @@
+package purge
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func testPlan() *Plan {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-bar"}
+	return &Plan{
+		CreatedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		Actions: []PlannedAction{
+			{
+				Action: planActionNotify,
+				Org:    org,
+				Details: SpaceDetails{
+					Timestamp: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC),
+					Space:     &resource.Space{GUID: "space-1", Name: "foo"},
+				},
+				Recipients: []string{"foo@bar.gov"},
+				Subject:    "notify",
+			},
+			{
+				Action: planActionPurge,
+				Org:    org,
+				Details: SpaceDetails{
+					Timestamp: time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC),
+					Space:     &resource.Space{GUID: "space-2", Name: "baz"},
+				},
+				Quota:      "sandbox",
+				Developers: []spaceUser{{GUID: "user-1", Username: "baz@bar.gov"}},
+				Recipients: []string{"baz@bar.gov"},
+				Subject:    "purge",
+			},
+		},
+	}
+}
+
+func TestPlanFileRoundTrip(t *testing.T) {
+	plan := testPlan()
+	path := filepath.Join(t.TempDir(), "plan.json")
+
+	if err := writePlanFile(path, plan); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	read, err := readPlanFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(plan, read); diff != "" {
+		t.Errorf("readPlanFile() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPlanWriteText(t *testing.T) {
+	var b strings.Builder
+	if err := testPlan().writeText(&b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `Plan: 1 to notify, 1 to purge
+
+  notify sandbox-bar/foo (first resource 2023-12-01)
+      email "notify" to: foo@bar.gov
+
+  purge sandbox-bar/baz (first resource 2023-11-01)
+      recreate with quota: sandbox
+      re-add developers:   baz@bar.gov
+      re-add managers:     (none)
+      email "purge" to: baz@bar.gov
+`
+	if diff := cmp.Diff(expected, b.String()); diff != "" {
+		t.Errorf("writeText() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	t.Run("dry run", func(t *testing.T) {
+		report := &Report{}
+		err := applyPlan(context.Background(), &cfResourceClient{}, Config{DryRun: true}, testPlan(), &mockMailSender{}, nil, report, nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if report.SpacesNotified != 1 {
+			t.Fatalf("expected 1 space notified, got: %d", report.SpacesNotified)
+		}
+	})
+
+	t.Run("space deleted during the run", func(t *testing.T) {
+		cfClient := &cfResourceClient{
+			Organizations:        &mockOrganizations{orgs: []*resource.Organization{{GUID: "org-1", Name: "sandbox-bar"}}},
+			Spaces:               &mockSpaces{deleteErr: resource.NewResourceNotFoundError()},
+			Routes:               &mockRoutes{},
+			ServiceInstances:     &mockServiceInstances{},
+			ServiceRouteBindings: &mockServiceRouteBindings{},
+		}
+		report := &Report{}
+		err := applyPlan(context.Background(), cfClient, Config{TemplateDir: "../templates"}, testPlan(), &mockMailSender{}, nil, report, nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(report.Errors) > 0 {
+			t.Fatalf("unexpected errors: %v", report.Errors)
+		}
+		if report.DeletedDuringRun != 1 || report.SpacesPurged != 0 {
+			t.Fatalf("expected 1 action deleted during the run and none purged, got: %d and %d", report.DeletedDuringRun, report.SpacesPurged)
+		}
+		if note := report.Spaces[1].Note; note != "space baz was deleted during the run" {
+			t.Fatalf("unexpected note: %s", note)
+		}
+	})
+
+	t.Run("org deleted during the run", func(t *testing.T) {
+		cfClient := &cfResourceClient{
+			Organizations:        &mockOrganizations{},
+			Spaces:               &mockSpaces{deleteErr: resource.NewResourceNotFoundError()},
+			Routes:               &mockRoutes{},
+			ServiceInstances:     &mockServiceInstances{},
+			ServiceRouteBindings: &mockServiceRouteBindings{},
+		}
+		plan := testPlan()
+		second := plan.Actions[1]
+		second.Details.Space = &resource.Space{GUID: "space-3", Name: "qux"}
+		plan.Actions = append(plan.Actions, second)
+		report := &Report{}
+		err := applyPlan(context.Background(), cfClient, Config{TemplateDir: "../templates"}, plan, &mockMailSender{}, nil, report, nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(report.Errors) > 0 {
+			t.Fatalf("unexpected errors: %v", report.Errors)
+		}
+		if diff := cmp.Diff([]string{"sandbox-bar"}, report.OrgsDeleted); diff != "" {
+			t.Errorf("OrgsDeleted mismatch (-want +got):\n%s", diff)
+		}
+		var notes []string
+		for _, result := range report.Spaces[1:] {
+			notes = append(notes, result.Note)
+		}
+		expected := []string{"org sandbox-bar was deleted during the run", "org sandbox-bar was deleted during the run"}
+		if diff := cmp.Diff(expected, notes); diff != "" {
+			t.Errorf("notes mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		plan := testPlan()
+		plan.Actions[0].Action = "explode"
+		err := applyPlan(context.Background(), &cfResourceClient{}, Config{DryRun: true}, plan, &mockMailSender{}, nil, &Report{}, nil, nil)
+		if err == nil || err.Error() != "unknown planned action explode for space foo" {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	})
+}
+
+func TestBuildPlanOnlyNotifyNew(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	spaces := []*resource.Space{{GUID: "warned", Name: "warned"}, {GUID: "new", Name: "new"}}
+	firstResource := now.AddDate(0, 0, -26)
+	cfClient := &cfResourceClient{
+		Applications:     &mockApplications{apps: []*resource.App{appInSpace("app-1", "warned", firstResource), appInSpace("app-2", "new", firstResource)}},
+		ServiceInstances: &mockServiceInstances{},
+		Routes:           &mockRoutes{},
+		// only the new space's users can be listed
+		Spaces: &mockSpaces{spaces: spaces, spaceGUID: "new"},
+		Roles:  &mockMemberRoles{},
+	}
+	state := &State{Spaces: map[string]*SpaceState{
+		"warned": {Org: org.Name, Space: "warned", LastNotified: now.AddDate(0, 0, -1)},
+	}}
+	opts := Config{NotifyDays: 25, PurgeDays: 30, TemplateDir: "../templates", OnlyNotifyNew: true}
+
+	plan, err := buildPlan(context.Background(), cfClient, opts, []*resource.Organization{org}, nil, nil, now, time.Time{}, state, &Report{StartedAt: now}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var warned []string
+	for _, action := range plan.Actions {
+		if action.Action == planActionNotify {
+			warned = append(warned, action.Details.Space.Name)
+		}
+	}
+	if diff := cmp.Diff([]string{"new"}, warned); diff != "" {
+		t.Errorf("warned spaces mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBuildPlanCompactsActions(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	metadata := resource.NewMetadata()
+	metadata.SetLabel("", "team", "data")
+	space := &resource.Space{
+		GUID:     "space-1",
+		Name:     "foo",
+		Links:    map[string]resource.Link{"self": {Href: "https://api.example.gov/v3/spaces/space-1"}},
+		Metadata: metadata,
+	}
+	cfClient := &cfResourceClient{
+		Applications:     &mockApplications{apps: []*resource.App{appInSpace("app-1", "space-1", now.AddDate(0, 0, -26))}},
+		ServiceInstances: &mockServiceInstances{},
+		Routes:           &mockRoutes{},
+		Spaces:           &mockSpaces{spaces: []*resource.Space{space}, spaceGUID: "space-1"},
+		Roles:            &mockMemberRoles{},
+	}
+	opts := Config{NotifyDays: 25, PurgeDays: 30, TemplateDir: "../templates"}
+
+	plan, err := buildPlan(context.Background(), cfClient, opts, []*resource.Organization{org}, nil, nil, now, time.Time{}, &State{}, &Report{StartedAt: now}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(plan.Actions) != 1 {
+		t.Fatalf("expected 1 action, got %d", len(plan.Actions))
+	}
+	planned := plan.Actions[0].Details.Space
+	if planned == space || planned.Links != nil {
+		t.Errorf("expected a compacted copy of the listed space, got %+v", planned)
+	}
+	if planned.GUID != "space-1" || planned.Name != "foo" || planned.Metadata != metadata {
+		t.Errorf("expected the space's GUID, name, and metadata to be kept, got %+v", planned)
+	}
+}
+
+func TestPlannedActionCompact(t *testing.T) {
+	created := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	instance := &resource.ServiceInstance{
+		GUID:          "instance-1",
+		Name:          "db",
+		CreatedAt:     created,
+		Type:          "managed",
+		Tags:          []string{"postgres"},
+		LastOperation: resource.LastOperation{Type: "create", State: "succeeded"},
+		Links:         map[string]resource.Link{"self": {Href: "https://api.example.gov/v3/service_instances/instance-1"}},
+	}
+	action := PlannedAction{Action: planActionDeleteOrphan, ServiceInstance: instance}
+
+	compacted := action.compact()
+	expected := &resource.ServiceInstance{GUID: "instance-1", Name: "db", CreatedAt: created, Type: "managed"}
+	if diff := cmp.Diff(expected, compacted.ServiceInstance); diff != "" {
+		t.Errorf("compact() mismatch (-want +got):\n%s", diff)
+	}
+	if action.ServiceInstance != instance || instance.Links == nil {
+		t.Error("expected compact to leave the listed instance alone")
+	}
+}
@@ -0,0 +1,128 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"gopkg.in/yaml.v3"
+)
+
+// PlanCostOptions deletes service instances on expensive plans, such as
+// large databases, sooner than INSTANCE_PURGE_DAYS, leaving the rest of the
+// space in place
+type PlanCostOptions struct {
+	// PlanCostFile maps "offering/plan" names to the plan's monthly cost
+	PlanCostFile string `env:"PLAN_COST_FILE"`
+	// CostlyInstancePurgeDays deletes instances of plans costing at least
+	// CostlyInstanceMinCost a month once they're this many days old; zero
+	// disables it
+	CostlyInstancePurgeDays int     `env:"COSTLY_INSTANCE_PURGE_DAYS, default=0"`
+	CostlyInstanceMinCost   float64 `env:"COSTLY_INSTANCE_MIN_COST, default=0"`
+}
+
+func (o PlanCostOptions) validate() error {
+	if o.CostlyInstancePurgeDays < 0 || o.CostlyInstanceMinCost < 0 {
+		return fmt.Errorf("COSTLY_INSTANCE_PURGE_DAYS and COSTLY_INSTANCE_MIN_COST must not be negative")
+	}
+	if o.CostlyInstancePurgeDays > 0 && o.PlanCostFile == "" {
+		return fmt.Errorf("COSTLY_INSTANCE_PURGE_DAYS requires PLAN_COST_FILE")
+	}
+	return nil
+}
+
+// parsePlanCostFile parses PLAN_COST_FILE, a YAML map of "offering/plan"
+// names to monthly costs
+func parsePlanCostFile(path string, contents []byte) (map[string]float64, error) {
+	table := map[string]float64{}
+	if err := yaml.NewDecoder(bytes.NewReader(contents)).Decode(&table); err != nil {
+		return nil, fmt.Errorf("error parsing plan cost file %s: %w", path, err)
+	}
+	for name, cost := range table {
+		offering, plan, ok := strings.Cut(name, "/")
+		if !ok || offering == "" || plan == "" {
+			return nil, fmt.Errorf("plan %q in plan cost file %s must be named offering/plan", name, path)
+		}
+		if cost < 0 {
+			return nil, fmt.Errorf("plan %q in plan cost file %s has a negative cost", name, path)
+		}
+	}
+	return table, nil
+}
+
+// usePlanCostFile reads PLAN_COST_FILE, if set, so a run can look up the
+// cost of each instance's plan
+func (c *Config) usePlanCostFile(ctx context.Context) error {
+	if c.PlanCostFile == "" {
+		return nil
+	}
+	contents, err := readConfigSource(ctx, c.configSource(), c.PlanCostFile)
+	if err != nil {
+		return fmt.Errorf("error reading plan cost file: %w", err)
+	}
+	table, err := parsePlanCostFile(c.PlanCostFile, contents)
+	if err != nil {
+		return err
+	}
+	c.planCostTable = table
+	return nil
+}
+
+// listPlanCosts returns the monthly cost of each plan in the cost table by
+// plan GUID; plans in the table that CF doesn't have are skipped
+func listPlanCosts(ctx context.Context, cfClient *cfResourceClient, table map[string]float64) (map[string]float64, error) {
+	costs := map[string]float64{}
+	if len(table) == 0 {
+		return costs, nil
+	}
+	offeringNames := map[string]bool{}
+	for name := range table {
+		offering, _, _ := strings.Cut(name, "/")
+		offeringNames[offering] = true
+	}
+	planListOptions := client.NewServicePlanListOptions()
+	for name := range offeringNames {
+		planListOptions.ServiceOfferingNames.Values = append(planListOptions.ServiceOfferingNames.Values, name)
+	}
+	sort.Strings(planListOptions.ServiceOfferingNames.Values)
+	plans, offerings, err := cfClient.ServicePlans.ListIncludeServiceOfferingAll(ctx, planListOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error listing service plans from plan cost file: %w", err)
+	}
+	offeringsByGUID := map[string]string{}
+	for _, offering := range offerings {
+		offeringsByGUID[offering.GUID] = offering.Name
+	}
+	for _, plan := range plans {
+		offering := offeringsByGUID[relationshipGUID(&plan.Relationships.ServiceOffering)]
+		if cost, ok := table[offering+"/"+plan.Name]; ok {
+			costs[plan.GUID] = cost
+		}
+	}
+	return costs, nil
+}
+
+// costlyInstancePurgeEnabled reports whether instances are deleted early
+// for the cost of their plans
+func (c Config) costlyInstancePurgeEnabled() bool {
+	return c.CostlyInstancePurgeDays > 0
+}
+
+// instancePurgeDays returns the age at which a service instance is deleted
+// on its own, or zero if it isn't, along with its plan's monthly cost when
+// that cost is why it's deleted sooner
+func (c Config) instancePurgeDays(instance *resource.ServiceInstance) (int, float64) {
+	days := c.InstancePurgeDays
+	if !c.costlyInstancePurgeEnabled() {
+		return days, 0
+	}
+	cost, ok := c.planCosts[relationshipGUID(instance.Relationships.ServicePlan)]
+	if !ok || cost <= 0 || cost < c.CostlyInstanceMinCost || (days > 0 && days <= c.CostlyInstancePurgeDays) {
+		return days, 0
+	}
+	return c.CostlyInstancePurgeDays, cost
+}
@@ -0,0 +1,143 @@
+package purge
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParsePlanCostFile(t *testing.T) {
+	testCases := map[string]struct {
+		contents    string
+		expected    map[string]float64
+		expectedErr string
+	}{
+		"valid": {
+			contents: "aws-rds/medium-psql: 150\naws-rds/large-psql: 600.50\n",
+			expected: map[string]float64{"aws-rds/medium-psql": 150, "aws-rds/large-psql": 600.5},
+		},
+		"plan without offering": {
+			contents:    "medium-psql: 150\n",
+			expectedErr: `plan "medium-psql" in plan cost file costs.yml must be named offering/plan`,
+		},
+		"negative cost": {
+			contents:    "aws-rds/medium-psql: -1\n",
+			expectedErr: `plan "aws-rds/medium-psql" in plan cost file costs.yml has a negative cost`,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			table, err := parsePlanCostFile("costs.yml", []byte(test.contents))
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expected, table); diff != "" {
+				t.Errorf("parsePlanCostFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestListPlanCosts(t *testing.T) {
+	offering := resource.ToOneRelationship{Data: &resource.Relationship{GUID: "offering-rds"}}
+	cfClient := &cfResourceClient{
+		ServicePlans: &mockServicePlans{
+			byOffering: map[string][]*resource.ServicePlan{
+				"aws-rds": {
+					{GUID: "plan-medium", Name: "medium-psql", Relationships: resource.ServicePlanRelationship{ServiceOffering: offering}},
+					{GUID: "plan-micro", Name: "micro-psql", Relationships: resource.ServicePlanRelationship{ServiceOffering: offering}},
+				},
+			},
+			offerings: []*resource.ServiceOffering{{GUID: "offering-rds", Name: "aws-rds"}},
+		},
+	}
+	table := map[string]float64{"aws-rds/medium-psql": 150, "aws-rds/retired-psql": 900}
+	costs, err := listPlanCosts(context.Background(), cfClient, table)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(map[string]float64{"plan-medium": 150}, costs); diff != "" {
+		t.Errorf("listPlanCosts() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestInstancePurgeDays(t *testing.T) {
+	costly := PlanCostOptions{CostlyInstancePurgeDays: 7, CostlyInstanceMinCost: 100}
+	planCosts := map[string]float64{"plan-large": 400, "plan-small": 20, "plan-free": 0}
+	testCases := map[string]struct {
+		opts         Config
+		plan         string
+		expectedDays int
+		expectedCost float64
+	}{
+		"disabled": {
+			opts:         Config{InstancePurgeDays: 60, planCosts: planCosts},
+			plan:         "plan-large",
+			expectedDays: 60,
+		},
+		"costly plan": {
+			opts:         Config{InstancePurgeDays: 60, PlanCostOptions: costly, planCosts: planCosts},
+			plan:         "plan-large",
+			expectedDays: 7,
+			expectedCost: 400,
+		},
+		"below the minimum cost": {
+			opts:         Config{InstancePurgeDays: 60, PlanCostOptions: costly, planCosts: planCosts},
+			plan:         "plan-small",
+			expectedDays: 60,
+		},
+		"free plan": {
+			opts:         Config{PlanCostOptions: PlanCostOptions{CostlyInstancePurgeDays: 7}, planCosts: planCosts},
+			plan:         "plan-free",
+			expectedDays: 0,
+		},
+		"plan not in the table": {
+			opts:         Config{InstancePurgeDays: 60, PlanCostOptions: costly, planCosts: planCosts},
+			plan:         "plan-other",
+			expectedDays: 60,
+		},
+		"instance threshold is already sooner": {
+			opts:         Config{InstancePurgeDays: 5, PlanCostOptions: costly, planCosts: planCosts},
+			plan:         "plan-large",
+			expectedDays: 5,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instance := testPlanInstance("instance-1", "space-1", test.plan, time.Time{})
+			days, cost := test.opts.instancePurgeDays(instance)
+			if days != test.expectedDays || cost != test.expectedCost {
+				t.Errorf("expected %d days at %v, got %d days at %v", test.expectedDays, test.expectedCost, days, cost)
+			}
+		})
+	}
+}
+
+func TestApplyPurgeCostlyInstance(t *testing.T) {
+	action := PlannedAction{
+		Action:          planActionPurgeInstance,
+		Org:             &resource.Organization{Name: "sandbox-org"},
+		Details:         SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+		ServiceInstance: &resource.ServiceInstance{GUID: "instance-1", Name: "db"},
+		Recipients:      []string{"foo@bar.gov"},
+		InstanceCost:    400,
+	}
+	cfClient := &cfResourceClient{
+		ServiceCredentialBindings: &mockServiceCredentialBindings{},
+		ServiceInstances:          &mockServiceInstances{},
+		ServiceRouteBindings:      &mockServiceRouteBindings{},
+		Jobs:                      &mockJobs{},
+	}
+	opts := Config{TemplateDir: "../templates", PlanCostOptions: PlanCostOptions{CostlyInstancePurgeDays: 7}}
+	mailSender := &recordingMailer{}
+	if err := applyPurgeInstance(context.Background(), cfClient, opts, action, mailSender, &Report{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(mailSender.bodies) != 1 || !strings.Contains(mailSender.bodies[0], "costs about $400.00 a month, so we deleted it 7 days after it was created") {
+		t.Errorf("expected the email to explain the early deletion, got %v", mailSender.bodies)
+	}
+}
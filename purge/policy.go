@@ -0,0 +1,231 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OrgPolicy overrides the global settings for sandbox orgs whose names start
+// with OrgPrefix, so each program can set its own thresholds, templates,
+// sender identity, and recreate behavior; unset fields keep the global value
+type OrgPolicy struct {
+	OrgPrefix                string  `yaml:"org_prefix"`
+	NotifyDays               *int    `yaml:"notify_days"`
+	PurgeDays                *int    `yaml:"purge_days"`
+	InstancePurgeDays        *int    `yaml:"instance_purge_days"`
+	DisablePurge             *bool   `yaml:"disable_purge"`
+	TemplateDir              string  `yaml:"template_dir"`
+	MailSender               string  `yaml:"mail_sender"`
+	NotifyMailSubject        string  `yaml:"notify_mail_subject"`
+	PurgeMailSubject         string  `yaml:"purge_mail_subject"`
+	InstancePurgeMailSubject string  `yaml:"instance_purge_mail_subject"`
+	WelcomeMailSubject       string  `yaml:"welcome_mail_subject"`
+	SandboxQuotaName         string  `yaml:"sandbox_quota_name"`
+	SandboxQuotaFallback     *string `yaml:"sandbox_quota_fallback"`
+	QuarantineBlockedSpaces  *bool   `yaml:"quarantine_blocked_spaces"`
+	SpaceSSH                 string  `yaml:"space_ssh"`
+	// SpaceSecurityGroups replaces the global list, even when empty
+	SpaceSecurityGroups []string `yaml:"space_security_groups"`
+	StopAppsOnNotify    *bool    `yaml:"stop_apps_on_notify"`
+	AgeBy               string   `yaml:"age_by"`
+	SpaceMaxApps        *int     `yaml:"space_max_apps"`
+	SpaceMaxServices    *int     `yaml:"space_max_services"`
+	SpaceMaxRoutes      *int     `yaml:"space_max_routes"`
+	EnforceSpaceCaps    *bool    `yaml:"enforce_space_caps"`
+	// NotifyTiers replaces the global purge warning tiers
+	NotifyTiers []NotifyTier `yaml:"notify_tiers"`
+}
+
+// NotifyTier sends purge warnings with DaysLeft or fewer days left until the
+// purge using its own template, and its own subject if it sets one, so the
+// last reminders can be more urgent than the first
+type NotifyTier struct {
+	DaysLeft int    `yaml:"days_left"`
+	Template string `yaml:"template"`
+	Subject  string `yaml:"subject"`
+}
+
+// policyFile is the document read from POLICY_FILE
+type policyFile struct {
+	// NotifyTiers apply to every org whose policy doesn't set its own
+	NotifyTiers []NotifyTier `yaml:"notify_tiers"`
+	Policies    []OrgPolicy  `yaml:"policies"`
+}
+
+// parsePolicyFile parses and validates POLICY_FILE against the global
+// settings; unknown fields are rejected so typos don't silently fall back to
+// the global value
+func parsePolicyFile(path string, contents []byte, global Config) (policyFile, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	var file policyFile
+	if err := decoder.Decode(&file); err != nil {
+		return policyFile{}, fmt.Errorf("error parsing policy file %s: %w", path, err)
+	}
+	if err := validateNotifyTiers(file.NotifyTiers); err != nil {
+		return policyFile{}, fmt.Errorf("invalid notify_tiers: %w", err)
+	}
+	global.notifyTiers = file.NotifyTiers
+
+	seen := map[string]bool{}
+	for _, policy := range file.Policies {
+		if !strings.HasPrefix(policy.OrgPrefix, global.OrgPrefix) {
+			return policyFile{}, fmt.Errorf("policy for %q doesn't match ORG_PREFIX %s", policy.OrgPrefix, global.OrgPrefix)
+		}
+		if seen[policy.OrgPrefix] {
+			return policyFile{}, fmt.Errorf("duplicate policy for %q", policy.OrgPrefix)
+		}
+		seen[policy.OrgPrefix] = true
+		if err := policy.apply(global).validatePolicy(); err != nil {
+			return policyFile{}, fmt.Errorf("invalid policy for %q: %w", policy.OrgPrefix, err)
+		}
+	}
+	return file, nil
+}
+
+// validateNotifyTiers checks that each tier names a template in the
+// template directory and that no two tiers cover the same days
+func validateNotifyTiers(tiers []NotifyTier) error {
+	seen := map[int]bool{}
+	for _, tier := range tiers {
+		if tier.DaysLeft < 0 {
+			return fmt.Errorf("days_left must not be negative, got %d", tier.DaysLeft)
+		}
+		if tier.Template == "" || tier.Template != filepath.Base(tier.Template) {
+			return fmt.Errorf("tier for %d days left must name a template file in the template directory, got %q", tier.DaysLeft, tier.Template)
+		}
+		if seen[tier.DaysLeft] {
+			return fmt.Errorf("duplicate tier for %d days left", tier.DaysLeft)
+		}
+		seen[tier.DaysLeft] = true
+	}
+	return nil
+}
+
+// apply returns cfg with the policy's settings overriding its own
+func (p OrgPolicy) apply(cfg Config) Config {
+	setInt := func(target *int, value *int) {
+		if value != nil {
+			*target = *value
+		}
+	}
+	setBool := func(target *bool, value *bool) {
+		if value != nil {
+			*target = *value
+		}
+	}
+	setString := func(target *string, value string) {
+		if value != "" {
+			*target = value
+		}
+	}
+	setInt(&cfg.NotifyDays, p.NotifyDays)
+	setInt(&cfg.PurgeDays, p.PurgeDays)
+	setInt(&cfg.InstancePurgeDays, p.InstancePurgeDays)
+	setBool(&cfg.DisablePurge, p.DisablePurge)
+	setString(&cfg.TemplateDir, p.TemplateDir)
+	setString(&cfg.MailSender, p.MailSender)
+	setString(&cfg.NotifyMailSubject, p.NotifyMailSubject)
+	setString(&cfg.PurgeMailSubject, p.PurgeMailSubject)
+	setString(&cfg.InstancePurgeMailSubject, p.InstancePurgeMailSubject)
+	setString(&cfg.WelcomeMailSubject, p.WelcomeMailSubject)
+	setString(&cfg.SandboxQuotaName, p.SandboxQuotaName)
+	if p.SandboxQuotaFallback != nil {
+		cfg.SandboxQuotaFallback = *p.SandboxQuotaFallback
+	}
+	setBool(&cfg.QuarantineBlockedSpaces, p.QuarantineBlockedSpaces)
+	setString(&cfg.SpaceSSH, p.SpaceSSH)
+	if p.SpaceSecurityGroups != nil {
+		cfg.SpaceSecurityGroups = p.SpaceSecurityGroups
+	}
+	setBool(&cfg.StopAppsOnNotify, p.StopAppsOnNotify)
+	setString(&cfg.AgeBy, p.AgeBy)
+	setInt(&cfg.SpaceMaxApps, p.SpaceMaxApps)
+	setInt(&cfg.SpaceMaxServices, p.SpaceMaxServices)
+	setInt(&cfg.SpaceMaxRoutes, p.SpaceMaxRoutes)
+	setBool(&cfg.EnforceSpaceCaps, p.EnforceSpaceCaps)
+	if len(p.NotifyTiers) > 0 {
+		cfg.notifyTiers = p.NotifyTiers
+	}
+	return cfg
+}
+
+// validatePolicy checks the settings an org policy can change
+func (c Config) validatePolicy() error {
+	if c.NotifyDays < 0 || c.PurgeDays <= 0 || c.InstancePurgeDays < 0 {
+		return fmt.Errorf("notify, purge, and instance purge days must not be negative")
+	}
+	if c.NotifyDays >= c.PurgeDays {
+		return fmt.Errorf("notify_days %d must be less than purge_days %d", c.NotifyDays, c.PurgeDays)
+	}
+	if !validSpaceSSH(c.SpaceSSH) {
+		return fmt.Errorf("unknown space_ssh %s; expected preserve, enabled, or disabled", c.SpaceSSH)
+	}
+	if !validAgeBy(c.AgeBy) {
+		return fmt.Errorf("unknown age_by %s; expected created or updated", c.AgeBy)
+	}
+	if c.AgeBy == ageByUpdated && c.StopAppsOnNotify {
+		return fmt.Errorf("stop_apps_on_notify can't be used with age_by updated")
+	}
+	if err := validateNotifyTiers(c.notifyTiers); err != nil {
+		return fmt.Errorf("invalid notify_tiers: %w", err)
+	}
+	if err := c.SpaceCapOptions.validate(); err != nil {
+		return err
+	}
+	return c.QuotaOptions.validate()
+}
+
+// usePolicyFile reads POLICY_FILE, if set, so forOrg can apply it
+func (c *Config) usePolicyFile(ctx context.Context) error {
+	if c.PolicyFile == "" {
+		return nil
+	}
+	contents, err := readConfigSource(ctx, c.configSource(), c.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("error reading policy file: %w", err)
+	}
+	file, err := parsePolicyFile(c.PolicyFile, contents, *c)
+	if err != nil {
+		return err
+	}
+	c.policies = file.Policies
+	c.notifyTiers = file.NotifyTiers
+	c.policyDigest = fmt.Sprintf("%x", sha256.Sum256(contents))
+	return nil
+}
+
+// forOrg returns the settings for an org: the global settings overridden by
+// the policy with the longest prefix matching the org, if any
+func (c Config) forOrg(orgName string) Config {
+	var match *OrgPolicy
+	for i, policy := range c.policies {
+		if strings.HasPrefix(orgName, policy.OrgPrefix) && (match == nil || len(policy.OrgPrefix) > len(match.OrgPrefix)) {
+			match = &c.policies[i]
+		}
+	}
+	if match == nil {
+		return c
+	}
+	return match.apply(c)
+}
+
+// lintPolicyTemplates lints the email templates with the global settings
+// and with each org policy's
+func lintPolicyTemplates(c Config) error {
+	if err := lintTemplates(c); err != nil {
+		return err
+	}
+	for _, policy := range c.policies {
+		if err := lintTemplates(policy.apply(c)); err != nil {
+			return fmt.Errorf("policy for %q: %w", policy.OrgPrefix, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package purge
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParsePolicyFile(t *testing.T) {
+	global := Config{OrgPrefix: "sandbox-", NotifyDays: 30, PurgeDays: 90}
+	testCases := map[string]struct {
+		contents    string
+		expected    []string
+		expectedErr string
+	}{
+		"valid": {
+			contents: "policies:\n  - org_prefix: sandbox-training-\n    notify_days: 3\n    purge_days: 7\n  - org_prefix: sandbox-gsa-\n    mail_sender: gsa@example.gov\n",
+			expected: []string{"sandbox-training-", "sandbox-gsa-"},
+		},
+		"unknown field": {
+			contents:    "policies:\n  - org_prefix: sandbox-training-\n    notfy_days: 3\n",
+			expectedErr: "error parsing policy file policy.yml: yaml: unmarshal errors:\n  line 3: field notfy_days not found in type purge.OrgPolicy",
+		},
+		"prefix outside ORG_PREFIX": {
+			contents:    "policies:\n  - org_prefix: training-\n",
+			expectedErr: `policy for "training-" doesn't match ORG_PREFIX sandbox-`,
+		},
+		"duplicate prefix": {
+			contents:    "policies:\n  - org_prefix: sandbox-a-\n  - org_prefix: sandbox-a-\n",
+			expectedErr: `duplicate policy for "sandbox-a-"`,
+		},
+		"stopped apps aged by update": {
+			contents:    "policies:\n  - org_prefix: sandbox-a-\n    age_by: updated\n    stop_apps_on_notify: true\n",
+			expectedErr: `invalid policy for "sandbox-a-": stop_apps_on_notify can't be used with age_by updated`,
+		},
+		"notify tiers": {
+			contents: "notify_tiers:\n  - days_left: 1\n    template: notify-final.tmpl\n    subject: Last reminder\npolicies:\n  - org_prefix: sandbox-training-\n    notify_tiers:\n      - days_left: 3\n        template: notify-training.tmpl\n",
+			expected: []string{"sandbox-training-"},
+		},
+		"duplicate tier": {
+			contents:    "notify_tiers:\n  - days_left: 1\n    template: notify-final.tmpl\n  - days_left: 1\n    template: notify-last.tmpl\n",
+			expectedErr: "invalid notify_tiers: duplicate tier for 1 days left",
+		},
+		"tier template outside the template directory": {
+			contents:    "policies:\n  - org_prefix: sandbox-a-\n    notify_tiers:\n      - days_left: 1\n        template: ../final.tmpl\n",
+			expectedErr: `invalid policy for "sandbox-a-": invalid notify_tiers: tier for 1 days left must name a template file in the template directory, got "../final.tmpl"`,
+		},
+		"notify after purge": {
+			contents:    "policies:\n  - org_prefix: sandbox-a-\n    notify_days: 100\n",
+			expectedErr: `invalid policy for "sandbox-a-": notify_days 100 must be less than purge_days 90`,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			file, err := parsePolicyFile("policy.yml", []byte(test.contents), global)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %s", test.expectedErr, err)
+			}
+			var prefixes []string
+			for _, policy := range file.Policies {
+				prefixes = append(prefixes, policy.OrgPrefix)
+			}
+			if diff := cmp.Diff(test.expected, prefixes); diff != "" {
+				t.Errorf("parsePolicyFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestForOrg(t *testing.T) {
+	notifyDays, purgeDays := 3, 7
+	cfg := Config{
+		OrgPrefix:  "sandbox-",
+		NotifyDays: 30,
+		PurgeDays:  90,
+		MailSender: "no-reply@example.gov",
+		policies: []OrgPolicy{
+			{OrgPrefix: "sandbox-gsa-", MailSender: "gsa@example.gov"},
+			{OrgPrefix: "sandbox-gsa-training-", NotifyDays: &notifyDays, PurgeDays: &purgeDays},
+		},
+	}
+	type settings struct {
+		NotifyDays int
+		PurgeDays  int
+		MailSender string
+	}
+	testCases := map[string]struct {
+		org      string
+		expected settings
+	}{
+		"no policy": {
+			org:      "sandbox-epa",
+			expected: settings{30, 90, "no-reply@example.gov"},
+		},
+		"prefix policy": {
+			org:      "sandbox-gsa-18f",
+			expected: settings{30, 90, "gsa@example.gov"},
+		},
+		"longest prefix wins": {
+			org:      "sandbox-gsa-training-1",
+			expected: settings{3, 7, "no-reply@example.gov"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			orgCfg := cfg.forOrg(test.org)
+			got := settings{orgCfg.NotifyDays, orgCfg.PurgeDays, orgCfg.MailSender}
+			if diff := cmp.Diff(test.expected, got); diff != "" {
+				t.Errorf("forOrg() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPolicySpaceSecurityGroups(t *testing.T) {
+	cfg := Config{SpaceSecurityGroups: []string{"public_networks", "dns"}}
+	if got := (OrgPolicy{}).apply(cfg).SpaceSecurityGroups; !cmp.Equal(got, cfg.SpaceSecurityGroups) {
+		t.Errorf("expected the global groups without an override, got %v", got)
+	}
+	if got := (OrgPolicy{SpaceSecurityGroups: []string{"dns"}}).apply(cfg).SpaceSecurityGroups; !cmp.Equal(got, []string{"dns"}) {
+		t.Errorf("expected the policy's groups, got %v", got)
+	}
+	if got := (OrgPolicy{SpaceSecurityGroups: []string{}}).apply(cfg).SpaceSecurityGroups; len(got) != 0 {
+		t.Errorf("expected an empty policy list to clear the groups, got %v", got)
+	}
+}
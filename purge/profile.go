@@ -0,0 +1,120 @@
+package purge
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+const profileSampleInterval = 100 * time.Millisecond
+
+// profiler writes pprof CPU and heap profiles and logs peak memory per phase;
+// a nil profiler does nothing
+type profiler struct {
+	dir     string
+	cpuFile *os.File
+	done    chan struct{}
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	peakHeap uint64
+	peakSys  uint64
+}
+
+// startProfiler starts CPU profiling into dir and begins sampling memory usage
+func startProfiler(dir string) (*profiler, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating profile directory %s: %w", dir, err)
+	}
+	cpuFile, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return nil, fmt.Errorf("error creating CPU profile: %w", err)
+	}
+	if err := pprof.StartCPUProfile(cpuFile); err != nil {
+		cpuFile.Close()
+		return nil, fmt.Errorf("error starting CPU profile: %w", err)
+	}
+
+	p := &profiler{
+		dir:     dir,
+		cpuFile: cpuFile,
+		done:    make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.sample()
+	return p, nil
+}
+
+func (p *profiler) sample() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(profileSampleInterval)
+	defer ticker.Stop()
+	for {
+		p.record()
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *profiler) record() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if stats.HeapInuse > p.peakHeap {
+		p.peakHeap = stats.HeapInuse
+	}
+	if stats.Sys > p.peakSys {
+		p.peakSys = stats.Sys
+	}
+}
+
+// phase logs the peak memory observed since the previous phase and resets the peak
+func (p *profiler) phase(name string) {
+	if p == nil {
+		return
+	}
+	p.record()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	logFields{}.printf(
+		"profile: phase %s peak heap in use %d MiB, peak sys %d MiB",
+		name,
+		p.peakHeap>>20,
+		p.peakSys>>20,
+	)
+	p.peakHeap = 0
+	p.peakSys = 0
+}
+
+// stop stops CPU profiling and memory sampling and writes a heap profile
+func (p *profiler) stop() error {
+	if p == nil {
+		return nil
+	}
+	close(p.done)
+	p.wg.Wait()
+
+	pprof.StopCPUProfile()
+	if err := p.cpuFile.Close(); err != nil {
+		return fmt.Errorf("error closing CPU profile: %w", err)
+	}
+
+	heapFile, err := os.Create(filepath.Join(p.dir, "heap.pprof"))
+	if err != nil {
+		return fmt.Errorf("error creating heap profile: %w", err)
+	}
+	defer heapFile.Close()
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(heapFile); err != nil {
+		return fmt.Errorf("error writing heap profile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package purge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+
+	p, err := startProfiler(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := p.stop(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// check the reset once sampling has stopped, so a sample can't land in between
+	p.phase("test")
+	if p.peakHeap != 0 || p.peakSys != 0 {
+		t.Fatalf("expected peaks to reset after phase, got heap: %d, sys: %d", p.peakHeap, p.peakSys)
+	}
+
+	for _, name := range []string{"cpu.pprof", "heap.pprof"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if info.Size() == 0 {
+			t.Errorf("expected %s to be non-empty", name)
+		}
+	}
+}
+
+func TestNilProfiler(t *testing.T) {
+	var p *profiler
+	p.phase("test")
+	if err := p.stop(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
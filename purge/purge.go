@@ -0,0 +1,253 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+var (
+	ErrNoSpaceDeleteJobGUID = errors.New("cannot verify space deletion: no job GUID")
+)
+
+// planPurge looks up the roles and recipients needed to purge and recreate a space
+func planPurge(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	userGUIDs map[string]bool,
+	rosters spaceRosters,
+	org *resource.Organization,
+	details SpaceDetails,
+) (PlannedAction, error) {
+	var (
+		spaceRoles       []*resource.Role
+		spaceUsers       []*resource.User
+		isolationSegment string
+		sshEnabled       bool
+		securityGroups   []spaceSecurityGroup
+	)
+	err := runConcurrently(ctx, 0,
+		func(ctx context.Context) (err error) {
+			spaceRoles, spaceUsers, err = rosters.spaceRolesWithUsers(ctx, cfClient, details.Space)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			isolationSegment, err = cfClient.Spaces.GetAssignedIsolationSegment(ctx, details.Space.GUID)
+			if isNotFoundError(err) {
+				return deletedDuringRun("space " + details.Space.Name)
+			}
+			if err != nil {
+				return fmt.Errorf("error getting isolation segment of space %s: %w", details.Space.Name, err)
+			}
+			return nil
+		},
+		func(ctx context.Context) (err error) {
+			sshEnabled, err = planSpaceSSH(ctx, cfClient, opts, details.Space)
+			return err
+		},
+		func(ctx context.Context) (err error) {
+			securityGroups, err = planSpaceSecurityGroups(ctx, cfClient, opts)
+			return err
+		},
+	)
+	if err != nil {
+		return PlannedAction{}, err
+	}
+
+	recipients, err := listRecipients(userGUIDs, spaceUsers)
+	if err != nil {
+		return PlannedAction{}, fmt.Errorf("error listing recipients on space %s: %w", details.Space.Name, err)
+	}
+
+	developers, managers := listSpaceDevsAndManagers(userGUIDs, spaceRoles, spaceUsers)
+
+	logFields{Org: org.Name, Space: details.Space.Name, Action: planActionPurge}.printf("Purging space %s; recipients: %+v", details.Space.Name, recipients)
+
+	return PlannedAction{
+		Action:           planActionPurge,
+		Org:              org,
+		Details:          details,
+		Quota:            opts.SandboxQuotaName,
+		IsolationSegment: isolationSegment,
+		SSHEnabled:       &sshEnabled,
+		SecurityGroups:   securityGroups,
+		Developers:       developers,
+		Managers:         managers,
+		Recipients:       recipients,
+		Subject:          opts.PurgeMailSubject,
+	}, nil
+}
+
+// applyPurge emails recipients, then purges and recreates a space as planned;
+// if the recreated space doesn't match the purged one, the purge still counts
+// but a *spaceMismatchError describes the differences. A retried purge that
+// was waiting on service instances to deprovision doesn't email recipients
+// again, and returns a *deprovisionPendingError while they still are. With
+// MAIL_HOLD_AFTER_CF_FAILURES set, recipients are emailed once the space is
+// recreated instead, and a failure to email them doesn't fail the purge
+func applyPurge(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	action PlannedAction,
+	mailSender mailer,
+	report *Report,
+) error {
+	org, details := action.Org, action.Details
+
+	if opts.DryRun {
+		return nil
+	}
+
+	if action.PendingDeprovisionSince != nil {
+		deprovisioning, _, err := listDeprovisioningInstances(ctx, cfClient, details.Space)
+		if err != nil {
+			return err
+		}
+		if len(deprovisioning) > 0 {
+			return &deprovisionPendingError{space: details.Space.Name, instances: deprovisioning}
+		}
+		actionFields(action).printf("retrying purge of space %s, pending deprovision since %s", details.Space.Name, action.PendingDeprovisionSince.Format("2006-01-02"))
+	}
+
+	// before any email, so a failed lookup doesn't leave users told about a
+	// purge that didn't happen
+	developers, managers, err := opts.userCheck.activeRoles(ctx, action, report)
+	if err != nil {
+		return fmt.Errorf("error checking space users for space %s in org %s: %w", details.Space.Name, org.Name, err)
+	}
+
+	mailAfterPurge := opts.MailHoldAfterCFFailures > 0
+	if action.PendingDeprovisionSince == nil && !mailAfterPurge {
+		if err := sendPurgeEmail(ctx, opts, org, details, action.Recipients, mailSender); err != nil {
+			return fmt.Errorf("error sending purge notification email for space %s in org %s: %w", details.Space.Name, org.Name, err)
+		}
+	}
+
+	if err := unbindSpaceRouteServices(ctx, cfClient, org, details.Space, report); err != nil {
+		return fmt.Errorf("error unbinding route services in space %s in org %s: %w", details.Space.Name, org.Name, err)
+	}
+
+	actionFields(action).printf("purging space %s", details.Space.Name)
+	deleteJobGUID, cleanup, err := purgeSpace(ctx, cfClient, details.Space, opts.QuarantineBlockedSpaces)
+	report.recordCleanup(cleanup)
+	var quarantined *spaceQuarantinedError
+	if errors.As(err, &quarantined) {
+		report.SpacesQuarantined = append(report.SpacesQuarantined, org.Name+"/"+details.Space.Name)
+		return err
+	}
+	if errors.Is(err, errDeletedDuringRun) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("error purging space %s in org %s: %w", details.Space.Name, org.Name, err)
+	}
+
+	cleanup, err = awaitSpaceDeletion(ctx, cfClient, opts, details.Space, deleteJobGUID)
+	report.recordCleanup(cleanup)
+	if errors.As(err, &quarantined) {
+		report.SpacesQuarantined = append(report.SpacesQuarantined, org.Name+"/"+details.Space.Name)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	actionFields(action).printf("recreating space %s", details.Space.Name)
+	space, spaceQuota, err := recreateSpace(ctx, cfClient, opts, org, details, report)
+	if err != nil {
+		return fmt.Errorf("error recreating space %s in org %s: %w", details.Space.Name, org.Name, err)
+	}
+
+	if action.IsolationSegment != "" {
+		if err := cfClient.Spaces.AssignIsolationSegment(ctx, space.GUID, action.IsolationSegment); err != nil {
+			return fmt.Errorf("error assigning isolation segment %s to space %s in org %s: %w", action.IsolationSegment, details.Space.Name, org.Name, err)
+		}
+	}
+
+	if action.SSHEnabled != nil {
+		if err := cfClient.SpaceFeatures.EnableSSH(ctx, space.GUID, *action.SSHEnabled); err != nil {
+			return fmt.Errorf("error setting SSH on space %s in org %s: %w", details.Space.Name, org.Name, err)
+		}
+	}
+
+	if len(action.SecurityGroups) > 0 {
+		if err := bindSpaceSecurityGroups(ctx, cfClient, space.GUID, action.SecurityGroups); err != nil {
+			return fmt.Errorf("error binding security groups to space %s in org %s: %w", details.Space.Name, org.Name, err)
+		}
+	}
+
+	if len(developers) > 0 || len(managers) > 0 {
+		actionFields(action).printf("recreating space roles for space %s", space.Name)
+		if err := recreateSpaceDevsAndManagers(ctx, cfClient, space.GUID, developers, managers); err != nil {
+			return fmt.Errorf("error recreating space developers/managers for space %s in org %s: %w", details.Space.Name, org.Name, err)
+		}
+	}
+
+	report.SpacesPurged++
+	if mailAfterPurge {
+		if err := sendPurgeEmail(ctx, opts, org, details, action.Recipients, mailSender); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("error sending purge notification email for purged space %s in org %s: %s", details.Space.Name, org.Name, err))
+		}
+	}
+
+	expected := expectedSpace{
+		Name:             details.Space.Name,
+		OrgGUID:          org.GUID,
+		IsolationSegment: action.IsolationSegment,
+		SSHEnabled:       action.SSHEnabled,
+		Developers:       developers,
+		Managers:         managers,
+	}
+	if spaceQuota != nil {
+		expected.QuotaGUID = spaceQuota.GUID
+	}
+	mismatches, err := verifyRecreatedSpace(ctx, cfClient, space.GUID, expected)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return &spaceMismatchError{space: details.Space.Name, mismatches: mismatches}
+	}
+	return nil
+}
+
+func waitForSpaceDeletion(ctx context.Context, cfClient *cfResourceClient, deleteJobGUID string) error {
+	if deleteJobGUID == "" {
+		return ErrNoSpaceDeleteJobGUID
+	}
+
+	pollingOptions := client.NewPollingOptions()
+	pollingOptions.Timeout = 1 * time.Minute
+	return cfClient.Jobs.PollComplete(ctx, deleteJobGUID, pollingOptions)
+}
+
+func sendPurgeEmail(
+	ctx context.Context,
+	opts Config,
+	org *resource.Organization,
+	details SpaceDetails,
+	recipients []string,
+	mailSender mailer,
+) error {
+	purgeTemplate, err := parseMailTemplate(opts.TemplateDir, purgeTemplateName)
+	if err != nil {
+		return fmt.Errorf("error reading purge template: %s", err)
+	}
+
+	fields := logFields{Org: org.Name, Space: details.Space.Name, Action: planActionPurge}
+	body := renderSpaceMail(purgeTemplate, purgeTemplateName, purgeTemplateData(opts, org, details), mailSender, fields)
+
+	fields.printf("sending to %s: %s", recipients, body)
+	thread := newMailThread(opts.MailSender, details, "purge")
+	if err := mailSender.sendMail(ctx, opts.SMTPOptions, opts.MailSender, opts.PurgeMailSubject, body, thread, recipients); err != nil {
+		return fmt.Errorf("error sending mail on space %s: %w", details.Space.Name, err)
+	}
+
+	return nil
+}
@@ -1,9 +1,10 @@
-package main
+package purge
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/cloudfoundry-community/go-cfclient/v3/client"
@@ -17,6 +18,18 @@ type mockApplications struct {
 	apps            []*resource.App
 	deleteCallCount int
 	deleteErr       error
+	stoppedGUIDs    []string
+	stopErr         error
+	created         []*resource.AppCreate
+}
+
+func (a *mockApplications) Create(ctx context.Context, r *resource.AppCreate) (*resource.App, error) {
+	a.created = append(a.created, r)
+	return &resource.App{GUID: "app-" + r.Name, Name: r.Name}, nil
+}
+
+func (a *mockApplications) List(ctx context.Context, opts *client.AppListOptions) ([]*resource.App, *client.Pager, error) {
+	return a.apps, &client.Pager{TotalResults: len(a.apps)}, a.listAppsErr
 }
 
 func (a *mockApplications) ListAll(ctx context.Context, opts *client.AppListOptions) ([]*resource.App, error) {
@@ -28,6 +41,43 @@ func (a *mockApplications) Delete(ctx context.Context, guid string) (string, err
 	return "", a.deleteErr
 }
 
+func (a *mockApplications) Stop(ctx context.Context, guid string) (*resource.App, error) {
+	a.stoppedGUIDs = append(a.stoppedGUIDs, guid)
+	return nil, a.stopErr
+}
+
+type mockDroplets struct {
+	listDropletsErr error
+	droplets        []*resource.Droplet
+	deleteCallCount int
+	deleteErr       error
+}
+
+func (d *mockDroplets) ListAll(ctx context.Context, opts *client.DropletListOptions) ([]*resource.Droplet, error) {
+	return d.droplets, d.listDropletsErr
+}
+
+func (d *mockDroplets) Delete(ctx context.Context, guid string) (string, error) {
+	d.deleteCallCount += 1
+	return "", d.deleteErr
+}
+
+type mockTasks struct {
+	listTasksErr    error
+	tasks           []*resource.Task
+	cancelCallCount int
+	cancelErr       error
+}
+
+func (t *mockTasks) ListAll(ctx context.Context, opts *client.TaskListOptions) ([]*resource.Task, error) {
+	return t.tasks, t.listTasksErr
+}
+
+func (t *mockTasks) Cancel(ctx context.Context, guid string) (*resource.Task, error) {
+	t.cancelCallCount += 1
+	return nil, t.cancelErr
+}
+
 type spaceCreatedRole struct {
 	SpaceGUID string
 	UserGUID  string
@@ -35,26 +85,41 @@ type spaceCreatedRole struct {
 }
 
 type mockRoles struct {
+	mu                sync.Mutex
 	listRolesErr      error
 	roles             []*resource.Role
 	spaceGUID         string
 	users             []*resource.User
 	createdSpaceRoles []spaceCreatedRole
+	createErr         error
 }
 
 func (r *mockRoles) CreateSpaceRole(ctx context.Context, spaceGUID, userGUID string, roleType resource.SpaceRoleType) (*resource.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.createdSpaceRoles = append(r.createdSpaceRoles, spaceCreatedRole{
 		SpaceGUID: spaceGUID,
 		UserGUID:  userGUID,
 		RoleType:  roleType,
 	})
-	return nil, nil
+	return nil, r.createErr
+}
+
+func (r *mockRoles) Delete(ctx context.Context, guid string) (string, error) {
+	return "", nil
 }
 
 func (r *mockRoles) ListIncludeUsersAll(ctx context.Context, opts *client.RoleListOptions) ([]*resource.Role, []*resource.User, error) {
 	if r.listRolesErr != nil {
 		return nil, nil, r.listRolesErr
 	}
+	if len(r.createdSpaceRoles) > 0 && len(opts.SpaceGUIDs.Values) == 1 && opts.SpaceGUIDs.Values[0] == r.createdSpaceRoles[0].SpaceGUID {
+		var created []*resource.Role
+		for _, role := range r.createdSpaceRoles {
+			created = append(created, testRole("", role.RoleType.String(), role.UserGUID, role.SpaceGUID))
+		}
+		return created, r.users, nil
+	}
 	expectedOpts := &client.RoleListOptions{
 		SpaceGUIDs: client.Filter{
 			Values: []string{r.spaceGUID},
@@ -67,13 +132,24 @@ func (r *mockRoles) ListIncludeUsersAll(ctx context.Context, opts *client.RoleLi
 }
 
 type mockSpaces struct {
+	spaces                     []*resource.Space
 	listUsersAllErr            error
 	users                      []*resource.User
 	spaceGUID                  string
 	expectedSpaceCreateRequest *resource.SpaceCreate
+	createErr                  error
 	space                      *resource.Space
 	deleteJobGUID              string
 	deleteErr                  error
+	updates                    []spaceUpdate
+	updateErr                  error
+	isolationSegment           string
+	assignedIsolationSegments  []string
+}
+
+type spaceUpdate struct {
+	GUID   string
+	Update *resource.SpaceUpdate
 }
 
 func (s *mockSpaces) ListUsersAll(ctx context.Context, spaceGUID string, opts *client.UserListOptions) ([]*resource.User, error) {
@@ -87,13 +163,16 @@ func (s *mockSpaces) ListUsersAll(ctx context.Context, spaceGUID string, opts *c
 }
 
 func (s *mockSpaces) ListAll(ctx context.Context, opts *client.SpaceListOptions) ([]*resource.Space, error) {
-	return nil, nil
+	return s.spaces, nil
 }
 
 func (s *mockSpaces) Create(ctx context.Context, r *resource.SpaceCreate) (*resource.Space, error) {
-	if !cmp.Equal(r, s.expectedSpaceCreateRequest) {
+	if s.expectedSpaceCreateRequest != nil && !cmp.Equal(r, s.expectedSpaceCreateRequest) {
 		return nil, fmt.Errorf("expected creation params do not match: %s", cmp.Diff(r, s.expectedSpaceCreateRequest))
 	}
+	if s.createErr != nil {
+		return nil, s.createErr
+	}
 	return s.space, nil
 }
 
@@ -101,14 +180,50 @@ func (s *mockSpaces) Delete(ctx context.Context, guid string) (string, error) {
 	return s.deleteJobGUID, s.deleteErr
 }
 
-func (s *mockSpaces) Single(ctx context.Context, opts *client.SpaceListOptions) (*resource.Space, error) {
-	return nil, nil
+func (s *mockSpaces) Get(ctx context.Context, guid string) (*resource.Space, error) {
+	return s.space, nil
+}
+
+func (s *mockSpaces) GetAssignedIsolationSegment(ctx context.Context, guid string) (string, error) {
+	return s.isolationSegment, nil
+}
+
+func (s *mockSpaces) AssignIsolationSegment(ctx context.Context, guid, isolationSegmentGUID string) error {
+	s.assignedIsolationSegments = append(s.assignedIsolationSegments, isolationSegmentGUID)
+	return nil
+}
+
+func (s *mockSpaces) Update(ctx context.Context, guid string, r *resource.SpaceUpdate) (*resource.Space, error) {
+	s.updates = append(s.updates, spaceUpdate{guid, r})
+	return nil, s.updateErr
+}
+
+type mockSpaceFeatures struct {
+	sshEnabled bool
+	sshUpdates []bool
+}
+
+func (f *mockSpaceFeatures) IsSSHEnabled(ctx context.Context, spaceGUID string) (bool, error) {
+	return f.sshEnabled, nil
+}
+
+func (f *mockSpaceFeatures) EnableSSH(ctx context.Context, spaceGUID string, enable bool) error {
+	f.sshUpdates = append(f.sshUpdates, enable)
+	return nil
 }
 
 type mockSpaceQuotas struct {
 	spaceQuotaName string
 	orgGUID        string
 	quota          *resource.SpaceQuota
+	singleErr      error
+	// quotas are listed when a lookup matches more than one
+	quotas         []*resource.SpaceQuota
+	createRequests []*resource.SpaceQuotaCreateOrUpdate
+	createErr      error
+	updateRequests []*resource.SpaceQuotaCreateOrUpdate
+	updateErr      error
+	appliedGUIDs   []string
 }
 
 func (q *mockSpaceQuotas) Single(ctx context.Context, opts *client.SpaceQuotaListOptions) (*resource.SpaceQuota, error) {
@@ -122,16 +237,48 @@ func (q *mockSpaceQuotas) Single(ctx context.Context, opts *client.SpaceQuotaLis
 	if !cmp.Equal(opts, expectedOptions) {
 		return nil, fmt.Errorf(cmp.Diff(opts, expectedOptions))
 	}
+	if q.singleErr != nil {
+		return nil, q.singleErr
+	}
+	return q.quota, nil
+}
+
+func (q *mockSpaceQuotas) ListAll(ctx context.Context, opts *client.SpaceQuotaListOptions) ([]*resource.SpaceQuota, error) {
+	return q.quotas, nil
+}
+
+func (q *mockSpaceQuotas) Create(ctx context.Context, r *resource.SpaceQuotaCreateOrUpdate) (*resource.SpaceQuota, error) {
+	q.createRequests = append(q.createRequests, r)
+	if q.createErr != nil {
+		return nil, q.createErr
+	}
+	return q.quota, nil
+}
+
+func (q *mockSpaceQuotas) Update(ctx context.Context, guid string, r *resource.SpaceQuotaCreateOrUpdate) (*resource.SpaceQuota, error) {
+	q.updateRequests = append(q.updateRequests, r)
+	if q.updateErr != nil {
+		return nil, q.updateErr
+	}
 	return q.quota, nil
 }
 
 func (q *mockSpaceQuotas) Apply(ctx context.Context, guid string, spaceGUIDs []string) ([]string, error) {
+	q.appliedGUIDs = append(q.appliedGUIDs, spaceGUIDs...)
 	return []string{}, nil
 }
 
 type mockJobs struct {
 	expectedJobGUID string
 	pollErr         error
+	job             *resource.Job
+}
+
+func (j *mockJobs) Get(ctx context.Context, guid string) (*resource.Job, error) {
+	if j.job == nil {
+		return nil, fmt.Errorf("job %s not found", guid)
+	}
+	return j.job, nil
 }
 
 func (j *mockJobs) PollComplete(ctx context.Context, jobGUID string, opts *client.PollingOptions) error {
@@ -144,11 +291,14 @@ func (j *mockJobs) PollComplete(ctx context.Context, jobGUID string, opts *clien
 type mockMailSender struct{}
 
 func (m *mockMailSender) sendMail(
+	ctx context.Context,
 	opts SMTPOptions,
 	sender string,
 	subject string,
 	body string,
+	thread mailThread,
 	recipients []string,
+	attachments ...mailAttachment,
 ) error {
 	return nil
 }
@@ -205,14 +355,17 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 	testCases := map[string]struct {
 		cfClient                *cfResourceClient
 		userGUIDs               map[string]bool
-		options                 Options
+		options                 Config
 		organization            *resource.Organization
 		spaceDetails            SpaceDetails
 		expectSpaceCreatedRoles []spaceCreatedRole
 	}{
 		"success with one org manager": {
 			cfClient: &cfResourceClient{
-				Applications: &mockApplications{},
+				Applications:         &mockApplications{},
+				Routes:               &mockRoutes{},
+				ServiceInstances:     &mockServiceInstances{},
+				ServiceRouteBindings: &mockServiceRouteBindings{},
 				Roles: &mockRoles{
 					spaceGUID: "space-1-guid",
 					roles: []*resource.Role{
@@ -239,6 +392,7 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 						},
 					},
 				},
+				SpaceFeatures: &mockSpaceFeatures{},
 				Spaces: &mockSpaces{
 					spaceGUID: "space-1-guid",
 					users: []*resource.User{
@@ -260,6 +414,14 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 					space: &resource.Space{
 						GUID: "new-space-1-guid",
 						Name: "space-1",
+						Relationships: &resource.SpaceRelationships{
+							Organization: &resource.ToOneRelationship{
+								Data: &resource.Relationship{GUID: "org-1"},
+							},
+							Quota: &resource.ToOneRelationship{
+								Data: &resource.Relationship{GUID: "quota-guid-1"},
+							},
+						},
 					},
 					deleteJobGUID: "delete-space-1",
 				},
@@ -277,8 +439,9 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 			userGUIDs: map[string]bool{
 				"user-1": true,
 			},
-			options: Options{
+			options: Config{
 				DryRun:           false,
+				TemplateDir:      "../templates",
 				SandboxQuotaName: "quota-1",
 			},
 			organization: &resource.Organization{
@@ -307,7 +470,10 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 		},
 		"success with one org manager and one dev": {
 			cfClient: &cfResourceClient{
-				Applications: &mockApplications{},
+				Applications:         &mockApplications{},
+				Routes:               &mockRoutes{},
+				ServiceInstances:     &mockServiceInstances{},
+				ServiceRouteBindings: &mockServiceRouteBindings{},
 				Roles: &mockRoles{
 					spaceGUID: "space-1-guid",
 					roles: []*resource.Role{
@@ -353,6 +519,7 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 						},
 					},
 				},
+				SpaceFeatures: &mockSpaceFeatures{},
 				Spaces: &mockSpaces{
 					spaceGUID: "space-1-guid",
 					users: []*resource.User{
@@ -378,6 +545,14 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 					space: &resource.Space{
 						GUID: "new-space-1-guid",
 						Name: "space-1",
+						Relationships: &resource.SpaceRelationships{
+							Organization: &resource.ToOneRelationship{
+								Data: &resource.Relationship{GUID: "org-1"},
+							},
+							Quota: &resource.ToOneRelationship{
+								Data: &resource.Relationship{GUID: "quota-guid-1"},
+							},
+						},
 					},
 					deleteJobGUID: "space-delete-1",
 				},
@@ -396,8 +571,9 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 				"user-1": true,
 				"user-2": true,
 			},
-			options: Options{
+			options: Config{
 				DryRun:           false,
+				TemplateDir:      "../templates",
 				SandboxQuotaName: "quota-1",
 			},
 			organization: &resource.Organization{
@@ -431,7 +607,10 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 		},
 		"success with space quota found": {
 			cfClient: &cfResourceClient{
-				Applications: &mockApplications{},
+				Applications:         &mockApplications{},
+				Routes:               &mockRoutes{},
+				ServiceInstances:     &mockServiceInstances{},
+				ServiceRouteBindings: &mockServiceRouteBindings{},
 				Roles: &mockRoles{
 					spaceGUID: "space-1-guid",
 					roles: []*resource.Role{
@@ -477,6 +656,7 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 						},
 					},
 				},
+				SpaceFeatures: &mockSpaceFeatures{},
 				Spaces: &mockSpaces{
 					spaceGUID: "space-1-guid",
 					users: []*resource.User{
@@ -502,6 +682,14 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 					space: &resource.Space{
 						GUID: "new-space-1-guid",
 						Name: "space-1",
+						Relationships: &resource.SpaceRelationships{
+							Organization: &resource.ToOneRelationship{
+								Data: &resource.Relationship{GUID: "org-1"},
+							},
+							Quota: &resource.ToOneRelationship{
+								Data: &resource.Relationship{GUID: "quota-guid-1"},
+							},
+						},
 					},
 					deleteJobGUID: "space-delete-1",
 				},
@@ -521,8 +709,9 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 				"user-1": true,
 				"user-2": true,
 			},
-			options: Options{
+			options: Config{
 				DryRun:           false,
+				TemplateDir:      "../templates",
 				SandboxQuotaName: "quota-1",
 			},
 			organization: &resource.Organization{
@@ -563,16 +752,26 @@ func TestPurgeAndRecreateSpace(t *testing.T) {
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			err := purgeAndRecreateSpace(
+			action, err := planPurge(
 				context.Background(),
 				test.cfClient,
 				test.options,
 				test.userGUIDs,
+				nil,
 				test.organization,
 				test.spaceDetails,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = applyPurge(
+				context.Background(),
+				test.cfClient,
+				test.options,
+				action,
 				&mockMailSender{},
+				&Report{},
 			)
-
 			if err != nil {
 				t.Fatal(err)
 			}
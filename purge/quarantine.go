@@ -0,0 +1,90 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// labelPurgeBlocked marks a space whose delete failed and was quarantined;
+// purges skip it until an operator removes the label
+const labelPurgeBlocked = "purge-blocked"
+
+// spaceQuarantinedError reports that a space's delete failed and the space
+// was quarantined rather than cleaned up
+type spaceQuarantinedError struct {
+	space string
+	err   error
+}
+
+func (e *spaceQuarantinedError) Error() string {
+	return fmt.Sprintf("space %s was quarantined with label %s after its delete failed: %s", e.space, labelPurgeBlocked, e.err)
+}
+
+func (e *spaceQuarantinedError) Unwrap() error {
+	return e.err
+}
+
+// isPurgeBlocked reports whether a space was quarantined by an earlier run
+func isPurgeBlocked(space *resource.Space) bool {
+	if space.Metadata == nil {
+		return false
+	}
+	_, ok := space.Metadata.Labels[labelPurgeBlocked]
+	return ok
+}
+
+// quarantineSpace stops every app in a space and labels it purge-blocked,
+// leaving its apps and services in place for operators to investigate
+func quarantineSpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+) (spaceCleanup, error) {
+	var cleanup spaceCleanup
+
+	stopped, err := stopSpaceApps(ctx, cfClient, space)
+	cleanup.AppsStopped = stopped
+	if err != nil {
+		return cleanup, err
+	}
+
+	metadata := resource.NewMetadata()
+	metadata.SetLabel("", labelPurgeBlocked, "true")
+	if _, err := cfClient.Spaces.Update(ctx, space.GUID, &resource.SpaceUpdate{Metadata: metadata}); err != nil {
+		return cleanup, fmt.Errorf("error labeling space %s %s: %w", space.Name, labelPurgeBlocked, err)
+	}
+	return cleanup, nil
+}
+
+// stopSpaceApps stops every running app in a space and returns how many it
+// stopped; apps deleted in the meantime are skipped
+func stopSpaceApps(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+) (int, error) {
+	appListOptions := client.NewAppListOptions()
+	appListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	apps, err := cfClient.Applications.ListAll(ctx, appListOptions)
+	if err != nil {
+		return 0, err
+	}
+	stopped := 0
+	for _, app := range apps {
+		if app.State == "STOPPED" {
+			continue
+		}
+		logFields{Space: space.Name}.printf("stopping app %s in space %s", app.Name, space.Name)
+		if _, err := cfClient.Applications.Stop(ctx, app.GUID); err != nil {
+			if isNotFoundError(err) {
+				continue
+			}
+			return stopped, err
+		}
+		stopped++
+	}
+	return stopped, nil
+}
@@ -0,0 +1,270 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+const (
+	quotaFallbackCreate     = "create"
+	quotaFallbackOrgDefault = "org-default"
+)
+
+// QuotaOptions describes what to do when the sandbox quota is missing from an
+// org, and the canonical sandbox quota definition used to create it
+type QuotaOptions struct {
+	SandboxQuotaFallback            string `env:"SANDBOX_QUOTA_FALLBACK"`
+	SandboxQuotaTotalMemoryMB       int    `env:"SANDBOX_QUOTA_TOTAL_MEMORY_MB"`
+	SandboxQuotaInstanceMemoryMB    int    `env:"SANDBOX_QUOTA_INSTANCE_MEMORY_MB"`
+	SandboxQuotaTotalInstances      int    `env:"SANDBOX_QUOTA_TOTAL_INSTANCES"`
+	SandboxQuotaTotalRoutes         int    `env:"SANDBOX_QUOTA_TOTAL_ROUTES"`
+	SandboxQuotaTotalServices       int    `env:"SANDBOX_QUOTA_TOTAL_SERVICES"`
+	SandboxQuotaPaidServicesAllowed bool   `env:"SANDBOX_QUOTA_PAID_SERVICES_ALLOWED, default=false"`
+	// SandboxQuotaReconcile updates an existing sandbox quota whose limits
+	// drifted from the definition before it is applied to a space
+	SandboxQuotaReconcile bool `env:"SANDBOX_QUOTA_RECONCILE, default=false"`
+}
+
+// validate checks that the quota fallback is one we know how to apply
+func (o QuotaOptions) validate() error {
+	if o.SandboxQuotaReconcile && o.SandboxQuotaTotalMemoryMB <= 0 {
+		return fmt.Errorf("SANDBOX_QUOTA_TOTAL_MEMORY_MB is required to reconcile the sandbox quota")
+	}
+	switch o.SandboxQuotaFallback {
+	case "", quotaFallbackOrgDefault:
+		return nil
+	case quotaFallbackCreate:
+		if o.SandboxQuotaTotalMemoryMB <= 0 {
+			return fmt.Errorf("SANDBOX_QUOTA_TOTAL_MEMORY_MB is required for quota fallback %s", o.SandboxQuotaFallback)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown quota fallback %s", o.SandboxQuotaFallback)
+	}
+}
+
+// quotaDefinition builds a request for the canonical sandbox quota in an org;
+// limits that are not configured are left unlimited
+func (o QuotaOptions) quotaDefinition(name string, orgGUID string) *resource.SpaceQuotaCreateOrUpdate {
+	quota := resource.NewSpaceQuotaCreate(name, orgGUID).
+		WithTotalMemoryInMB(o.SandboxQuotaTotalMemoryMB).
+		WithPaidServicesAllowed(o.SandboxQuotaPaidServicesAllowed)
+	if o.SandboxQuotaInstanceMemoryMB > 0 {
+		quota.WithPerProcessMemoryInMB(o.SandboxQuotaInstanceMemoryMB)
+	}
+	if o.SandboxQuotaTotalInstances > 0 {
+		quota.WithTotalInstances(o.SandboxQuotaTotalInstances)
+	}
+	if o.SandboxQuotaTotalRoutes > 0 {
+		quota.WithTotalRoutes(o.SandboxQuotaTotalRoutes)
+	}
+	if o.SandboxQuotaTotalServices > 0 {
+		quota.WithTotalServiceInstances(o.SandboxQuotaTotalServices)
+	}
+	return quota
+}
+
+// quotaLimit returns a configured limit, or nil for unlimited
+func quotaLimit(value int) *int {
+	if value <= 0 {
+		return nil
+	}
+	return &value
+}
+
+// quotaUpdate builds a request that sets an existing quota's limits to the
+// definition, keeping the limits the definition doesn't cover
+func (o QuotaOptions) quotaUpdate(current *resource.SpaceQuota) *resource.SpaceQuotaCreateOrUpdate {
+	paidServicesAllowed := o.SandboxQuotaPaidServicesAllowed
+	update := resource.NewSpaceQuotaUpdate()
+	update.Apps = &resource.SpaceQuotaApps{
+		TotalMemoryInMB:              quotaLimit(o.SandboxQuotaTotalMemoryMB),
+		PerProcessMemoryInMB:         quotaLimit(o.SandboxQuotaInstanceMemoryMB),
+		TotalInstances:               quotaLimit(o.SandboxQuotaTotalInstances),
+		LogRateLimitInBytesPerSecond: current.Apps.LogRateLimitInBytesPerSecond,
+		PerAppTasks:                  current.Apps.PerAppTasks,
+	}
+	update.Services = &resource.SpaceQuotaServices{
+		PaidServicesAllowed:   &paidServicesAllowed,
+		TotalServiceInstances: quotaLimit(o.SandboxQuotaTotalServices),
+		TotalServiceKeys:      current.Services.TotalServiceKeys,
+	}
+	update.Routes = &resource.SpaceQuotaRoutes{
+		TotalRoutes:        quotaLimit(o.SandboxQuotaTotalRoutes),
+		TotalReservedPorts: current.Routes.TotalReservedPorts,
+	}
+	return update
+}
+
+// quotaDrift describes each limit of a quota that differs from the
+// definition, e.g. "total_memory_in_mb 2048 -> 1024"
+func (o QuotaOptions) quotaDrift(current *resource.SpaceQuota) []string {
+	var drift []string
+	compare := func(name string, got *int, want int) {
+		if limit := quotaLimit(want); !equalLimits(got, limit) {
+			drift = append(drift, fmt.Sprintf("%s %s -> %s", name, formatLimit(got), formatLimit(limit)))
+		}
+	}
+	compare("total_memory_in_mb", current.Apps.TotalMemoryInMB, o.SandboxQuotaTotalMemoryMB)
+	compare("per_process_memory_in_mb", current.Apps.PerProcessMemoryInMB, o.SandboxQuotaInstanceMemoryMB)
+	compare("total_instances", current.Apps.TotalInstances, o.SandboxQuotaTotalInstances)
+	compare("total_routes", current.Routes.TotalRoutes, o.SandboxQuotaTotalRoutes)
+	compare("total_service_instances", current.Services.TotalServiceInstances, o.SandboxQuotaTotalServices)
+	paid := current.Services.PaidServicesAllowed
+	if paid == nil || *paid != o.SandboxQuotaPaidServicesAllowed {
+		got := "unset"
+		if paid != nil {
+			got = fmt.Sprint(*paid)
+		}
+		drift = append(drift, fmt.Sprintf("paid_services_allowed %s -> %t", got, o.SandboxQuotaPaidServicesAllowed))
+	}
+	return drift
+}
+
+func equalLimits(a *int, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func formatLimit(limit *int) string {
+	if limit == nil {
+		return "unlimited"
+	}
+	return fmt.Sprint(*limit)
+}
+
+// reconcileSandboxQuota updates a sandbox quota whose limits drifted from
+// the definition, so spaces it is applied to get the intended limits
+func reconcileSandboxQuota(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	options Config,
+	organization *resource.Organization,
+	spaceQuota *resource.SpaceQuota,
+) (*resource.SpaceQuota, error) {
+	drift := options.quotaDrift(spaceQuota)
+	if len(drift) == 0 {
+		return spaceQuota, nil
+	}
+	logFields{Org: organization.Name}.printf("quota %s in org %s drifted from its definition; updating %s", spaceQuota.Name, organization.Name, strings.Join(drift, ", "))
+	updated, err := cfClient.SpaceQuotas.Update(ctx, spaceQuota.GUID, options.quotaUpdate(spaceQuota))
+	if err != nil {
+		return nil, fmt.Errorf("error updating quota %s in org %s: %w", spaceQuota.Name, organization.Name, err)
+	}
+	return updated, nil
+}
+
+// findSandboxQuota finds the sandbox quota in an org; if it doesn't exist, it
+// applies the configured fallback, returning a nil quota when the space should
+// be left on the org default. An existing quota is reconciled with the
+// definition when SANDBOX_QUOTA_RECONCILE is set. A lookup that matches
+// several quotas is recorded in the report as quota drift
+func findSandboxQuota(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	options Config,
+	organization *resource.Organization,
+	report *Report,
+) (*resource.SpaceQuota, error) {
+	spaceQuotaListOptions := client.NewSpaceQuotaListOptions()
+	spaceQuotaListOptions.OrganizationGUIDs.EqualTo(organization.GUID)
+	if options.SandboxQuotaName != "" {
+		spaceQuotaListOptions.Names.EqualTo(options.SandboxQuotaName)
+	}
+	spaceQuota, warning, err := lookupSpaceQuota(ctx, cfClient, organization, spaceQuotaListOptions, options.SandboxQuotaName)
+	if warning != "" {
+		report.recordQuotaDrift(warning)
+	}
+	if err == nil && options.SandboxQuotaReconcile {
+		return reconcileSandboxQuota(ctx, cfClient, options, organization, spaceQuota)
+	}
+	// only a quota that doesn't exist falls back; lookupSpaceQuota returns
+	// client.ErrNoResultsReturned for it
+	if err == nil || !errors.Is(err, client.ErrNoResultsReturned) {
+		return spaceQuota, err
+	}
+
+	switch options.SandboxQuotaFallback {
+	case quotaFallbackCreate:
+		logFields{Org: organization.Name}.printf("quota %s not found in org %s; creating it", options.SandboxQuotaName, organization.Name)
+		spaceQuota, err = cfClient.SpaceQuotas.Create(ctx, options.quotaDefinition(options.SandboxQuotaName, organization.GUID))
+		if err != nil {
+			return nil, fmt.Errorf("error creating quota %s in org %s: %w", options.SandboxQuotaName, organization.Name, err)
+		}
+		return spaceQuota, nil
+	case quotaFallbackOrgDefault:
+		logFields{Org: organization.Name}.printf("quota %s not found in org %s; leaving space on the org default", options.SandboxQuotaName, organization.Name)
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// lookupSpaceQuota finds the single space quota in an org matching a lookup
+// for the quota named name. When several quotas match, rather than fail, it
+// prefers one named exactly name and then the oldest, so every lookup in a
+// run picks the same quota, and returns a warning describing the ambiguity.
+// Single reports no match and several matches alike as
+// client.ErrExactlyOneResultNotReturned, so the quotas are listed to tell
+// them apart, and no match is returned as client.ErrNoResultsReturned
+func lookupSpaceQuota(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	organization *resource.Organization,
+	opts *client.SpaceQuotaListOptions,
+	name string,
+) (*resource.SpaceQuota, string, error) {
+	spaceQuota, err := cfClient.SpaceQuotas.Single(ctx, opts)
+	if !errors.Is(err, client.ErrExactlyOneResultNotReturned) {
+		return spaceQuota, "", err
+	}
+	quotas, err := cfClient.SpaceQuotas.ListAll(ctx, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing quotas %s in org %s: %w", name, organization.Name, err)
+	}
+	switch len(quotas) {
+	case 0:
+		return nil, "", client.ErrNoResultsReturned
+	case 1:
+		return quotas[0], "", nil
+	}
+	spaceQuota = pickSpaceQuota(quotas, name)
+	guids := make([]string, 0, len(quotas))
+	for _, quota := range quotas {
+		guids = append(guids, quota.GUID)
+	}
+	sort.Strings(guids)
+	warning := fmt.Sprintf("%d quotas in org %s match %s (%s); using %s (%s)", len(quotas), organization.Name, name, strings.Join(guids, ", "), spaceQuota.Name, spaceQuota.GUID)
+	logFields{Org: organization.Name}.printf("%s", warning)
+	return spaceQuota, warning, nil
+}
+
+// pickSpaceQuota picks the quota named exactly name, or any quota if none
+// is, breaking ties by creation time and then GUID
+func pickSpaceQuota(quotas []*resource.SpaceQuota, name string) *resource.SpaceQuota {
+	candidates := quotas
+	var exact []*resource.SpaceQuota
+	for _, quota := range quotas {
+		if quota.Name == name {
+			exact = append(exact, quota)
+		}
+	}
+	if len(exact) > 0 {
+		candidates = exact
+	}
+	picked := candidates[0]
+	for _, quota := range candidates[1:] {
+		if quota.CreatedAt.Before(picked.CreatedAt) || (quota.CreatedAt.Equal(picked.CreatedAt) && quota.GUID < picked.GUID) {
+			picked = quota
+		}
+	}
+	return picked
+}
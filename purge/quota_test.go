@@ -0,0 +1,256 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQuotaOptionsValidate(t *testing.T) {
+	testCases := map[string]struct {
+		options     QuotaOptions
+		expectedErr string
+	}{
+		"no fallback": {},
+		"org default": {
+			options: QuotaOptions{SandboxQuotaFallback: quotaFallbackOrgDefault},
+		},
+		"create": {
+			options: QuotaOptions{
+				SandboxQuotaFallback:      quotaFallbackCreate,
+				SandboxQuotaTotalMemoryMB: 1024,
+			},
+		},
+		"create without memory limit": {
+			options:     QuotaOptions{SandboxQuotaFallback: quotaFallbackCreate},
+			expectedErr: "SANDBOX_QUOTA_TOTAL_MEMORY_MB is required for quota fallback create",
+		},
+		"reconcile without memory limit": {
+			options:     QuotaOptions{SandboxQuotaReconcile: true},
+			expectedErr: "SANDBOX_QUOTA_TOTAL_MEMORY_MB is required to reconcile the sandbox quota",
+		},
+		"unknown fallback": {
+			options:     QuotaOptions{SandboxQuotaFallback: "guess"},
+			expectedErr: "unknown quota fallback guess",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := test.options.validate()
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || test.expectedErr != err.Error())) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestFindSandboxQuota(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-org"}
+	quota := &resource.SpaceQuota{GUID: "quota-guid-1", Name: "quota-1"}
+	createErr := errors.New("create error")
+
+	testCases := map[string]struct {
+		spaceQuotas    *mockSpaceQuotas
+		options        Config
+		expectedQuota  *resource.SpaceQuota
+		expectedCreate []*resource.SpaceQuotaCreateOrUpdate
+		expectedDrift  []string
+		expectedErr    error
+	}{
+		"finds existing quota": {
+			spaceQuotas: &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "quota-1",
+				quota:          quota,
+			},
+			options:       Config{SandboxQuotaName: "quota-1"},
+			expectedQuota: quota,
+		},
+		"fails without fallback": {
+			spaceQuotas: &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "quota-1",
+				singleErr:      client.ErrExactlyOneResultNotReturned,
+			},
+			options:     Config{SandboxQuotaName: "quota-1"},
+			expectedErr: client.ErrNoResultsReturned,
+		},
+		"falls back to org default": {
+			spaceQuotas: &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "quota-1",
+				singleErr:      client.ErrExactlyOneResultNotReturned,
+			},
+			options: Config{
+				SandboxQuotaName: "quota-1",
+				QuotaOptions: QuotaOptions{
+					SandboxQuotaFallback: quotaFallbackOrgDefault,
+				},
+			},
+		},
+		"creates missing quota": {
+			spaceQuotas: &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "quota-1",
+				singleErr:      client.ErrExactlyOneResultNotReturned,
+				quota:          quota,
+			},
+			options: Config{
+				SandboxQuotaName: "quota-1",
+				QuotaOptions: QuotaOptions{
+					SandboxQuotaFallback:      quotaFallbackCreate,
+					SandboxQuotaTotalMemoryMB: 1024,
+					SandboxQuotaTotalRoutes:   10,
+				},
+			},
+			expectedQuota: quota,
+			expectedCreate: []*resource.SpaceQuotaCreateOrUpdate{
+				resource.NewSpaceQuotaCreate("quota-1", "org-1").
+					WithTotalMemoryInMB(1024).
+					WithPaidServicesAllowed(false).
+					WithTotalRoutes(10),
+			},
+		},
+		"error creating quota": {
+			spaceQuotas: &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "quota-1",
+				singleErr:      client.ErrExactlyOneResultNotReturned,
+				createErr:      createErr,
+			},
+			options: Config{
+				SandboxQuotaName: "quota-1",
+				QuotaOptions: QuotaOptions{
+					SandboxQuotaFallback:      quotaFallbackCreate,
+					SandboxQuotaTotalMemoryMB: 1024,
+				},
+			},
+			expectedCreate: []*resource.SpaceQuotaCreateOrUpdate{
+				resource.NewSpaceQuotaCreate("quota-1", "org-1").
+					WithTotalMemoryInMB(1024).
+					WithPaidServicesAllowed(false),
+			},
+			expectedErr: createErr,
+		},
+		"several quotas match": {
+			spaceQuotas: &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "quota-1",
+				singleErr:      client.ErrExactlyOneResultNotReturned,
+				quotas: []*resource.SpaceQuota{
+					{GUID: "quota-guid-3", Name: "Quota-1", CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+					{GUID: "quota-guid-2", Name: "quota-1", CreatedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+					{GUID: "quota-guid-1", Name: "quota-1", CreatedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+				},
+			},
+			options:       Config{SandboxQuotaName: "quota-1"},
+			expectedQuota: &resource.SpaceQuota{GUID: "quota-guid-1", Name: "quota-1", CreatedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+			expectedDrift: []string{"3 quotas in org sandbox-org match quota-1 (quota-guid-1, quota-guid-2, quota-guid-3); using quota-1 (quota-guid-1)"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			report := &Report{}
+			spaceQuota, err := findSandboxQuota(
+				context.Background(),
+				&cfResourceClient{SpaceQuotas: test.spaceQuotas},
+				test.options,
+				org,
+				report,
+			)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedQuota, spaceQuota); diff != "" {
+				t.Errorf("findSandboxQuota() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedCreate, test.spaceQuotas.createRequests); diff != "" {
+				t.Errorf("findSandboxQuota() create mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedDrift, report.QuotaDrift); diff != "" {
+				t.Errorf("quota drift mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReconcileSandboxQuota(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-org"}
+	intPtr := func(i int) *int { return &i }
+	paid := false
+	options := Config{
+		SandboxQuotaName: "quota-1",
+		QuotaOptions: QuotaOptions{
+			SandboxQuotaReconcile:     true,
+			SandboxQuotaTotalMemoryMB: 1024,
+			SandboxQuotaTotalRoutes:   10,
+		},
+	}
+	updateErr := errors.New("update error")
+
+	testCases := map[string]struct {
+		quota          *resource.SpaceQuota
+		updateErr      error
+		expectedUpdate []*resource.SpaceQuotaCreateOrUpdate
+		expectedErr    error
+	}{
+		"matches definition": {
+			quota: &resource.SpaceQuota{
+				GUID:     "quota-guid-1",
+				Name:     "quota-1",
+				Apps:     resource.SpaceQuotaApps{TotalMemoryInMB: intPtr(1024)},
+				Routes:   resource.SpaceQuotaRoutes{TotalRoutes: intPtr(10)},
+				Services: resource.SpaceQuotaServices{PaidServicesAllowed: &paid},
+			},
+		},
+		"drifted limits": {
+			quota: &resource.SpaceQuota{
+				GUID:     "quota-guid-1",
+				Name:     "quota-1",
+				Apps:     resource.SpaceQuotaApps{TotalMemoryInMB: intPtr(4096), TotalInstances: intPtr(5), PerAppTasks: intPtr(2)},
+				Routes:   resource.SpaceQuotaRoutes{TotalRoutes: intPtr(10)},
+				Services: resource.SpaceQuotaServices{PaidServicesAllowed: &paid, TotalServiceKeys: intPtr(3)},
+			},
+			expectedUpdate: []*resource.SpaceQuotaCreateOrUpdate{{
+				Apps:     &resource.SpaceQuotaApps{TotalMemoryInMB: intPtr(1024), PerAppTasks: intPtr(2)},
+				Routes:   &resource.SpaceQuotaRoutes{TotalRoutes: intPtr(10)},
+				Services: &resource.SpaceQuotaServices{PaidServicesAllowed: &paid, TotalServiceKeys: intPtr(3)},
+			}},
+		},
+		"error updating quota": {
+			quota: &resource.SpaceQuota{
+				GUID: "quota-guid-1",
+				Name: "quota-1",
+			},
+			updateErr: updateErr,
+			expectedUpdate: []*resource.SpaceQuotaCreateOrUpdate{{
+				Apps:     &resource.SpaceQuotaApps{TotalMemoryInMB: intPtr(1024)},
+				Routes:   &resource.SpaceQuotaRoutes{TotalRoutes: intPtr(10)},
+				Services: &resource.SpaceQuotaServices{PaidServicesAllowed: &paid},
+			}},
+			expectedErr: updateErr,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			spaceQuotas := &mockSpaceQuotas{
+				orgGUID:        "org-1",
+				spaceQuotaName: "quota-1",
+				quota:          test.quota,
+				updateErr:      test.updateErr,
+			}
+			_, err := findSandboxQuota(context.Background(), &cfResourceClient{SpaceQuotas: spaceQuotas}, options, org, &Report{})
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedUpdate, spaceQuotas.updateRequests); diff != "" {
+				t.Errorf("findSandboxQuota() update mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
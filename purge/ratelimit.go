@@ -0,0 +1,147 @@
+package purge
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitReserve is the fraction of the CF API rate limit left before
+	// requests are spread out over the rest of the window
+	rateLimitReserve = 0.1
+	// rateLimitRetries is how many times a throttled request is retried
+	rateLimitRetries = 3
+	// rateLimitMaxWait caps how long a single request waits for the window
+	// to reset, in case the reset header is wrong
+	rateLimitMaxWait = 5 * time.Minute
+)
+
+// rateLimiter tracks the CF API rate limit from the X-RateLimit-* response
+// headers and slows requests down as the limit runs low, rather than only
+// backing off after a 429
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+	now       func() time.Time
+	sleep     func(ctx context.Context, d time.Duration) error
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		remaining: -1,
+		now:       time.Now,
+		sleep:     sleepContext,
+	}
+}
+
+// wrap returns a transport that paces and retries requests sent with base
+func (l *rateLimiter) wrap(base http.RoundTripper) http.RoundTripper {
+	return &rateLimitTransport{limiter: l, base: base}
+}
+
+// observe records the rate limit headers of a response, if any
+func (l *rateLimiter) observe(header http.Header) {
+	limit, limitErr := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if remainingErr != nil || resetErr != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limitErr == nil {
+		l.limit = limit
+	}
+	l.remaining = remaining
+	l.reset = time.Unix(reset, 0)
+}
+
+// delay returns how long to wait before the next request: nothing while the
+// limit is comfortably above the reserve, then the rest of the window spread
+// evenly over the requests remaining in it
+func (l *rateLimiter) delay() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	untilReset := l.reset.Sub(l.now())
+	if l.remaining < 0 || untilReset <= 0 {
+		return 0
+	}
+	if l.remaining == 0 {
+		return min(untilReset, rateLimitMaxWait)
+	}
+	if float64(l.remaining) > float64(l.limit)*rateLimitReserve {
+		return 0
+	}
+	return min(untilReset/time.Duration(l.remaining), rateLimitMaxWait)
+}
+
+// resetWait returns how long to wait after a 429, from its Retry-After
+// header or the rate limit reset time; the window will have reset by the
+// time the request is retried, so the remaining count is forgotten
+func (l *rateLimiter) resetWait(resp *http.Response) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remaining = -1
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return min(time.Duration(seconds)*time.Second, rateLimitMaxWait)
+	}
+	if wait := l.reset.Sub(l.now()); wait > 0 {
+		return min(wait, rateLimitMaxWait)
+	}
+	return time.Second
+}
+
+type rateLimitTransport struct {
+	limiter *rateLimiter
+	base    http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if delay := t.limiter.delay(); delay > 0 {
+			if err := t.limiter.sleep(req.Context(), delay); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		t.limiter.observe(resp.Header)
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= rateLimitRetries {
+			return resp, nil
+		}
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return resp, nil
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return resp, nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		wait := t.limiter.resetWait(resp)
+		resp.Body.Close()
+		logFields{}.printf("CF API rate limit exceeded for %s; retrying in %s", endpointName(req), wait)
+		if err := t.limiter.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for d or until ctx is canceled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package purge
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRateLimiterDelay(t *testing.T) {
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	reset := strconv.FormatInt(now.Add(100*time.Second).Unix(), 10)
+	testCases := map[string]struct {
+		header   http.Header
+		expected time.Duration
+	}{
+		"no headers": {
+			header:   http.Header{},
+			expected: 0,
+		},
+		"above reserve": {
+			header:   http.Header{"X-Ratelimit-Limit": {"1000"}, "X-Ratelimit-Remaining": {"500"}, "X-Ratelimit-Reset": {reset}},
+			expected: 0,
+		},
+		"within reserve": {
+			header:   http.Header{"X-Ratelimit-Limit": {"1000"}, "X-Ratelimit-Remaining": {"50"}, "X-Ratelimit-Reset": {reset}},
+			expected: 2 * time.Second,
+		},
+		"exhausted": {
+			header:   http.Header{"X-Ratelimit-Limit": {"1000"}, "X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {reset}},
+			expected: 100 * time.Second,
+		},
+		"window already reset": {
+			header:   http.Header{"X-Ratelimit-Limit": {"1000"}, "X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {strconv.FormatInt(now.Add(-time.Second).Unix(), 10)}},
+			expected: 0,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			limiter := newRateLimiter()
+			limiter.now = func() time.Time { return now }
+			limiter.observe(test.header)
+			if got := limiter.delay(); got != test.expected {
+				t.Fatalf("expected delay %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	requests := 0
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body := make([]byte, r.ContentLength)
+		r.Body.Read(body)
+		bodies = append(bodies, string(body))
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(30*time.Second).Unix(), 10))
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", "999")
+	}))
+	defer server.Close()
+
+	limiter := newRateLimiter()
+	limiter.now = func() time.Time { return now }
+	var waits []time.Duration
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	httpClient := &http.Client{Transport: limiter.wrap(http.DefaultTransport)}
+	resp, err := httpClient.Post(server.URL+"/v3/spaces", "application/json", strings.NewReader(`{"name":"space"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the retry to succeed, got %s", resp.Status)
+	}
+	if diff := cmp.Diff([]time.Duration{30 * time.Second}, waits); diff != "" {
+		t.Errorf("waits mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{`{"name":"space"}`, `{"name":"space"}`}, bodies); diff != "" {
+		t.Errorf("request bodies mismatch (-want +got):\n%s", diff)
+	}
+}
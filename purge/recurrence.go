@@ -0,0 +1,82 @@
+package purge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recurrencePolicy sends a first purge warning at NotifyDays, then a reminder
+// every Every once a space is StartDay days old, for orgs matching Prefix
+type recurrencePolicy struct {
+	Prefix   string
+	StartDay int
+	Every    time.Duration
+}
+
+// parseRecurrencePolicies parses NOTIFY_RECURRENCE, a comma-separated list of
+// PREFIX=START_DAY/EVERY entries such as "sandbox-gsa-=60/168h"
+func parseRecurrencePolicies(value string) ([]recurrencePolicy, error) {
+	var policies []recurrencePolicy
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, schedule, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid notify recurrence %q: expected PREFIX=START_DAY/EVERY", entry)
+		}
+		startDay, every, ok := strings.Cut(schedule, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid notify recurrence %q: expected PREFIX=START_DAY/EVERY", entry)
+		}
+		day, err := strconv.Atoi(startDay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start day in notify recurrence %q: %w", entry, err)
+		}
+		interval, err := time.ParseDuration(every)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in notify recurrence %q: %w", entry, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("invalid interval in notify recurrence %q: must be positive", entry)
+		}
+		policies = append(policies, recurrencePolicy{Prefix: prefix, StartDay: day, Every: interval})
+	}
+	return policies, nil
+}
+
+// recurrenceFor returns the policy with the longest prefix matching an org
+func recurrenceFor(policies []recurrencePolicy, orgName string) (recurrencePolicy, bool) {
+	var match recurrencePolicy
+	found := false
+	for _, policy := range policies {
+		if strings.HasPrefix(orgName, policy.Prefix) && (!found || len(policy.Prefix) > len(match.Prefix)) {
+			match, found = policy, true
+		}
+	}
+	return match, found
+}
+
+// shouldNotify decides whether a space due a purge warning should get one this
+// run; without a recurrence policy for its org it is warned on every run
+func shouldNotify(
+	policies []recurrencePolicy,
+	state *State,
+	orgName string,
+	details SpaceDetails,
+	now time.Time,
+) bool {
+	policy, ok := recurrenceFor(policies, orgName)
+	if !ok {
+		return true
+	}
+	if !state.notifiedInCycle(details.Space.GUID, details.Timestamp) {
+		return true
+	}
+	lastNotified := state.lastNotified(details.Space.GUID)
+	age := int(now.Sub(details.Timestamp).Hours() / 24)
+	return age >= policy.StartDay && now.Sub(lastNotified) >= policy.Every
+}
@@ -0,0 +1,123 @@
+package purge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRecurrencePolicies(t *testing.T) {
+	testCases := map[string]struct {
+		value            string
+		expectedPolicies []recurrencePolicy
+		expectedErr      string
+	}{
+		"empty": {},
+		"multiple policies": {
+			value: "sandbox-=25/168h, sandbox-gsa-=60/72h",
+			expectedPolicies: []recurrencePolicy{
+				{Prefix: "sandbox-", StartDay: 25, Every: 168 * time.Hour},
+				{Prefix: "sandbox-gsa-", StartDay: 60, Every: 72 * time.Hour},
+			},
+		},
+		"missing schedule": {
+			value:       "sandbox-",
+			expectedErr: `invalid notify recurrence "sandbox-": expected PREFIX=START_DAY/EVERY`,
+		},
+		"bad interval": {
+			value:       "sandbox-=60/weekly",
+			expectedErr: `invalid interval in notify recurrence "sandbox-=60/weekly": time: invalid duration "weekly"`,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			policies, err := parseRecurrencePolicies(test.value)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %s, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedPolicies, policies); diff != "" {
+				t.Errorf("parseRecurrencePolicies() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestShouldNotify(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	policies := []recurrencePolicy{
+		{Prefix: "sandbox-", StartDay: 25, Every: 24 * time.Hour},
+		{Prefix: "sandbox-gsa-", StartDay: 60, Every: 7 * 24 * time.Hour},
+	}
+	daysAgo := func(days int) time.Time { return now.Add(-time.Duration(days) * 24 * time.Hour) }
+
+	testCases := map[string]struct {
+		policies     []recurrencePolicy
+		org          string
+		firstDaysAgo int
+		lastNotified time.Time
+		expected     bool
+	}{
+		"no policy notifies every run": {
+			org:          "sandbox-gsa",
+			firstDaysAgo: 40,
+			lastNotified: daysAgo(1),
+			expected:     true,
+		},
+		"first warning is always sent": {
+			policies:     policies,
+			org:          "sandbox-gsa-foo",
+			firstDaysAgo: 40,
+			expected:     true,
+		},
+		"no reminder before start day": {
+			policies:     policies,
+			org:          "sandbox-gsa-foo",
+			firstDaysAgo: 50,
+			lastNotified: daysAgo(10),
+		},
+		"no reminder within interval": {
+			policies:     policies,
+			org:          "sandbox-gsa-foo",
+			firstDaysAgo: 65,
+			lastNotified: daysAgo(6),
+		},
+		"reminder after interval": {
+			policies:     policies,
+			org:          "sandbox-gsa-foo",
+			firstDaysAgo: 65,
+			lastNotified: daysAgo(7),
+			expected:     true,
+		},
+		"shorter prefix applies to other orgs": {
+			policies:     policies,
+			org:          "sandbox-other",
+			firstDaysAgo: 30,
+			lastNotified: daysAgo(1),
+			expected:     true,
+		},
+		"warning from before the space's current resources is ignored": {
+			policies:     policies,
+			org:          "sandbox-gsa-foo",
+			firstDaysAgo: 40,
+			lastNotified: daysAgo(100),
+			expected:     true,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			state := &State{Spaces: map[string]*SpaceState{}}
+			if !test.lastNotified.IsZero() {
+				state.Spaces["space-1"] = &SpaceState{LastNotified: test.lastNotified}
+			}
+			details := SpaceDetails{
+				Timestamp: daysAgo(test.firstDaysAgo),
+				Space:     &resource.Space{GUID: "space-1"},
+			}
+			if got := shouldNotify(test.policies, state, test.org, details, now); got != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gopkg.in/gomail.v2"
+)
+
+// MailRelayStats counts the messages each SMTP relay handled during a run
+// with SMTP_SECONDARY_HOST set
+type MailRelayStats struct {
+	Primary   int `json:"primary"`
+	Secondary int `json:"secondary"`
+	// FailedOver is the primary relay's error that switched the run to the
+	// secondary relay
+	FailedOver string `json:"failed_over,omitempty"`
+}
+
+// secondaryRelay returns the options for SMTP_SECONDARY_HOST, filling in
+// the primary relay's settings where its own are unset
+func (o SMTPOptions) secondaryRelay() (SMTPOptions, bool) {
+	if o.SMTPSecondaryHost == "" {
+		return SMTPOptions{}, false
+	}
+	secondary := SMTPOptions{
+		SMTPHost: o.SMTPSecondaryHost,
+		SMTPPort: o.SMTPSecondaryPort,
+		SMTPUser: o.SMTPSecondaryUser,
+		SMTPPass: o.SMTPSecondaryPass,
+		SMTPCert: o.SMTPSecondaryCert,
+	}
+	if secondary.SMTPPort == 0 {
+		secondary.SMTPPort = o.SMTPPort
+	}
+	if secondary.SMTPUser == "" {
+		secondary.SMTPUser, secondary.SMTPPass = o.SMTPUser, o.SMTPPass
+	}
+	if secondary.SMTPCert == "" {
+		secondary.SMTPCert = o.SMTPCert
+	}
+	return secondary, true
+}
+
+// relayFailover sends through the primary SMTP relay until it fails once,
+// then through the secondary relay for the rest of the run, so a relay that
+// is down isn't retried, and timed out, for every message
+type relayFailover struct {
+	mu         sync.Mutex
+	failedOver string
+	primary    int
+	secondary  int
+}
+
+// send delivers msg through the relay in use, failing over to the secondary
+// relay, when there is one, if the primary fails; a cancelled run doesn't
+// fail over
+func (r *relayFailover) send(
+	ctx context.Context,
+	opts SMTPOptions,
+	msg *gomail.Message,
+	deliver func(context.Context, SMTPOptions, *gomail.Message) error,
+) error {
+	secondary, ok := opts.secondaryRelay()
+	r.mu.Lock()
+	usePrimary := !ok || r.failedOver == ""
+	r.mu.Unlock()
+
+	if usePrimary {
+		err := deliver(ctx, opts, msg)
+		if err == nil {
+			r.count(false)
+			return nil
+		}
+		if !ok || ctx.Err() != nil {
+			return err
+		}
+		r.failOver(opts.SMTPHost, secondary.SMTPHost, err)
+	}
+	if err := deliver(ctx, secondary, msg); err != nil {
+		return fmt.Errorf("error sending mail via secondary relay %s: %w", secondary.SMTPHost, err)
+	}
+	r.count(true)
+	return nil
+}
+
+func (r *relayFailover) failOver(primary string, secondary string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failedOver != "" {
+		return
+	}
+	r.failedOver = err.Error()
+	logFields{Err: err}.printf("SMTP relay %s failed (%s); sending through %s for the rest of the run", primary, err, secondary)
+}
+
+func (r *relayFailover) count(secondary bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if secondary {
+		r.secondary++
+	} else {
+		r.primary++
+	}
+}
+
+// relayStats returns the messages each relay handled so far, or nil
+// without SMTP_SECONDARY_HOST
+func (m *smtpMailer) relayStats() *MailRelayStats {
+	if _, ok := m.options.secondaryRelay(); !ok {
+		return nil
+	}
+	return m.relays.stats()
+}
+
+// stats returns the messages each relay handled so far
+func (r *relayFailover) stats() *MailRelayStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &MailRelayStats{Primary: r.primary, Secondary: r.secondary, FailedOver: r.failedOver}
+}
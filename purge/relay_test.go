@@ -0,0 +1,143 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/gomail.v2"
+)
+
+func TestSecondaryRelay(t *testing.T) {
+	primary := SMTPOptions{SMTPHost: "smtp-1.example.gov", SMTPPort: 587, SMTPUser: "user", SMTPPass: "pass", SMTPCert: "cert"}
+	testCases := map[string]struct {
+		opts     SMTPOptions
+		expected SMTPOptions
+		ok       bool
+	}{
+		"none": {
+			opts: primary,
+		},
+		"primary settings": {
+			opts: func() SMTPOptions {
+				o := primary
+				o.SMTPSecondaryHost = "smtp-2.example.gov"
+				return o
+			}(),
+			expected: SMTPOptions{SMTPHost: "smtp-2.example.gov", SMTPPort: 587, SMTPUser: "user", SMTPPass: "pass", SMTPCert: "cert"},
+			ok:       true,
+		},
+		"own settings": {
+			opts: func() SMTPOptions {
+				o := primary
+				o.SMTPSecondaryHost = "smtp-2.example.gov"
+				o.SMTPSecondaryPort = 465
+				o.SMTPSecondaryUser = "user-2"
+				o.SMTPSecondaryPass = "pass-2"
+				return o
+			}(),
+			expected: SMTPOptions{SMTPHost: "smtp-2.example.gov", SMTPPort: 465, SMTPUser: "user-2", SMTPPass: "pass-2", SMTPCert: "cert"},
+			ok:       true,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			secondary, ok := test.opts.secondaryRelay()
+			if ok != test.ok {
+				t.Fatalf("expected ok: %t, got: %t", test.ok, ok)
+			}
+			if diff := cmp.Diff(test.expected, secondary); diff != "" {
+				t.Errorf("secondaryRelay() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSMTPMailerFailover(t *testing.T) {
+	refused := errors.New("connection refused")
+	testCases := map[string]struct {
+		secondaryHost string
+		down          map[string]bool
+		cancel        bool
+		expectedErrs  []string
+		expectedHosts []string
+		expectedStats *MailRelayStats
+	}{
+		"primary up": {
+			secondaryHost: "smtp-2.example.gov",
+			expectedErrs:  []string{"", ""},
+			expectedHosts: []string{"smtp-1.example.gov", "smtp-1.example.gov"},
+			expectedStats: &MailRelayStats{Primary: 2},
+		},
+		"fails over for the rest of the run": {
+			secondaryHost: "smtp-2.example.gov",
+			down:          map[string]bool{"smtp-1.example.gov": true},
+			expectedErrs:  []string{"", ""},
+			expectedHosts: []string{"smtp-1.example.gov", "smtp-2.example.gov", "smtp-2.example.gov"},
+			expectedStats: &MailRelayStats{Secondary: 2, FailedOver: "connection refused"},
+		},
+		"both down": {
+			secondaryHost: "smtp-2.example.gov",
+			down:          map[string]bool{"smtp-1.example.gov": true, "smtp-2.example.gov": true},
+			expectedErrs: []string{
+				"error sending mail via secondary relay smtp-2.example.gov: connection refused",
+				"error sending mail via secondary relay smtp-2.example.gov: connection refused",
+			},
+			expectedHosts: []string{"smtp-1.example.gov", "smtp-2.example.gov", "smtp-2.example.gov"},
+			expectedStats: &MailRelayStats{FailedOver: "connection refused"},
+		},
+		"no secondary": {
+			down:          map[string]bool{"smtp-1.example.gov": true},
+			expectedErrs:  []string{"connection refused", "connection refused"},
+			expectedHosts: []string{"smtp-1.example.gov", "smtp-1.example.gov"},
+		},
+		"cancelled run doesn't fail over": {
+			secondaryHost: "smtp-2.example.gov",
+			down:          map[string]bool{"smtp-1.example.gov": true},
+			cancel:        true,
+			expectedErrs:  []string{"connection refused", "connection refused"},
+			expectedHosts: []string{"smtp-1.example.gov", "smtp-1.example.gov"},
+			expectedStats: &MailRelayStats{},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			opts := SMTPOptions{SMTPHost: "smtp-1.example.gov", SMTPPort: 587, SMTPUser: "user", SMTPPass: "pass", SMTPSecondaryHost: test.secondaryHost}
+			var hosts []string
+			m := &smtpMailer{
+				options: opts,
+				deliver: func(ctx context.Context, relay SMTPOptions, msg *gomail.Message) error {
+					hosts = append(hosts, relay.SMTPHost)
+					if test.down[relay.SMTPHost] {
+						return refused
+					}
+					return nil
+				},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancel {
+				cancel()
+			}
+			var errs []string
+			for i := 0; i < 2; i++ {
+				err := m.sendMail(ctx, opts, "no-reply@example.gov", "subject", "body", mailThread{}, []string{"foo@agency.gov"})
+				if err != nil {
+					errs = append(errs, err.Error())
+				} else {
+					errs = append(errs, "")
+				}
+			}
+			if diff := cmp.Diff(test.expectedErrs, errs); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedHosts, hosts); diff != "" {
+				t.Errorf("relays mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedStats, m.relayStats()); diff != "" {
+				t.Errorf("relayStats() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package purge
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	reportFormatJSON     = "json"
+	reportFormatMarkdown = "markdown"
+	reportFormatHTML     = "html"
+	// reportFormatCSV lists the report's messages, one row per recipient
+	reportFormatCSV = "csv"
+)
+
+// reportSection is a table of space results for a single action
+type reportSection struct {
+	Title   string
+	Results []SpaceResult
+}
+
+// reportView is the data passed to the report templates
+type reportView struct {
+	Report   Report
+	Sections []reportSection
+}
+
+var reportFuncs = template.FuncMap{
+	"date":  func(r SpaceResult) string { return r.FirstResource.Format("2006-01-02") },
+	"time":  func(r Report) string { return r.StartedAt.UTC().Format("2006-01-02 15:04 MST") },
+	"day":   func(t time.Time) string { return t.UTC().Format("2006-01-02") },
+	"join":  func(values []string) string { return strings.Join(values, ", ") },
+	"cell":  markdownCell,
+	"dash":  dashIfEmpty,
+	"count": func(r Report) int { return len(r.Errors) },
+	"target": func(r SpaceResult) string {
+		if r.ServiceInstance != "" {
+			return r.ServiceInstance
+		}
+		return r.Space
+	},
+}
+
+var markdownReportTemplate = template.Must(template.New("markdown").Funcs(reportFuncs).Parse(
+	`# Sandbox purge report
+
+Run started {{ time .Report }}{{ if eq .Report.Mode "report-only" }} (report only: {{ .Report.ModeReason }}){{ else if .Report.DryRun }} (dry run){{ end }}.
+
+| Spaces notified | Spaces purged | Warnings acknowledged | Orphans deleted | CF API calls | Errors |
+| ---: | ---: | ---: | ---: | ---: | ---: |
+| {{ .Report.SpacesNotified }} | {{ .Report.SpacesPurged }} | {{ .Report.SpacesAcknowledged }} | {{ .Report.OrphansDeleted }} | {{ .Report.APICalls }} | {{ count .Report }} |
+{{ range .Sections }}
+## {{ .Title }}
+{{ if .Results }}
+| Org | Name | First resource | Recipients | Error |
+| --- | --- | --- | --- | --- |
+{{ range .Results }}| {{ cell .Org }} | {{ if .URL }}[{{ cell (target .) }}]({{ .URL }}){{ else }}{{ cell (target .) }}{{ end }} | {{ date . }} | {{ cell (dash (join .Recipients)) }} | {{ cell (dash .Error) }} |
+{{ end }}{{ else }}
+None.
+{{ end }}{{ end }}{{ with .Report.Leaderboard }}
+## Oldest active sandboxes
+{{ if .OldestSpaces }}
+| Org | Space | First resource | Age (days) | Apps | Service instances | Owners |
+| --- | --- | --- | ---: | ---: | ---: | --- |
+{{ range .OldestSpaces }}| {{ cell .Org }} | {{ cell .Space }} | {{ day .FirstResource }} | {{ .AgeDays }} | {{ .Apps }} | {{ .ServiceInstances }} | {{ cell (dash (join .Owners)) }} |
+{{ end }}{{ else }}
+None.
+{{ end }}
+## Heaviest users
+{{ if .HeaviestUsers }}
+| User | Spaces | Apps | Service instances |
+| --- | ---: | ---: | ---: |
+{{ range .HeaviestUsers }}| {{ cell .User }} | {{ .Spaces }} | {{ .Apps }} | {{ .ServiceInstances }} |
+{{ end }}{{ else }}
+None.
+{{ end }}{{ end }}{{ with .Report.Agencies }}
+## Agencies
+
+| Agency | Active spaces | Spaces notified | Spaces purged | Average lifetime (days) |
+| --- | ---: | ---: | ---: | ---: |
+{{ range . }}| {{ cell .Agency }} | {{ .SpacesActive }} | {{ .SpacesNotified }} | {{ .SpacesPurged }} | {{ printf "%.1f" .AverageLifetimeDays }} |
+{{ end }}{{ end }}{{ with .Report.RolesDropped }}
+## Roles not recreated
+
+{{ range . }}- {{ . }}
+{{ end }}{{ end }}
+## Errors
+{{ if .Report.Errors }}
+{{ range .Report.Errors }}- {{ . }}
+{{ end }}{{ else }}
+None.
+{{ end }}`))
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap(reportFuncs)).Parse(
+	`<h1>Sandbox purge report</h1>
+<p>Run started {{ time .Report }}{{ if eq .Report.Mode "report-only" }} (report only: {{ .Report.ModeReason }}){{ else if .Report.DryRun }} (dry run){{ end }}.</p>
+<table>
+  <tr><th>Spaces notified</th><th>Spaces purged</th><th>Warnings acknowledged</th><th>Orphans deleted</th><th>CF API calls</th><th>Errors</th></tr>
+  <tr><td>{{ .Report.SpacesNotified }}</td><td>{{ .Report.SpacesPurged }}</td><td>{{ .Report.SpacesAcknowledged }}</td><td>{{ .Report.OrphansDeleted }}</td><td>{{ .Report.APICalls }}</td><td>{{ count .Report }}</td></tr>
+</table>
+{{ range .Sections }}
+<h2>{{ .Title }}</h2>
+{{ if .Results }}<table>
+  <tr><th>Org</th><th>Name</th><th>First resource</th><th>Recipients</th><th>Error</th></tr>
+{{ range .Results }}  <tr><td>{{ .Org }}</td><td>{{ if .URL }}<a href="{{ .URL }}">{{ target . }}</a>{{ else }}{{ target . }}{{ end }}</td><td>{{ date . }}</td><td>{{ dash (join .Recipients) }}</td><td>{{ dash .Error }}</td></tr>
+{{ end }}</table>{{ else }}<p>None.</p>{{ end }}
+{{ end }}
+{{ with .Report.Leaderboard }}<h2>Oldest active sandboxes</h2>
+{{ if .OldestSpaces }}<table>
+  <tr><th>Org</th><th>Space</th><th>First resource</th><th>Age (days)</th><th>Apps</th><th>Service instances</th><th>Owners</th></tr>
+{{ range .OldestSpaces }}  <tr><td>{{ .Org }}</td><td>{{ .Space }}</td><td>{{ day .FirstResource }}</td><td>{{ .AgeDays }}</td><td>{{ .Apps }}</td><td>{{ .ServiceInstances }}</td><td>{{ dash (join .Owners) }}</td></tr>
+{{ end }}</table>{{ else }}<p>None.</p>{{ end }}
+<h2>Heaviest users</h2>
+{{ if .HeaviestUsers }}<table>
+  <tr><th>User</th><th>Spaces</th><th>Apps</th><th>Service instances</th></tr>
+{{ range .HeaviestUsers }}  <tr><td>{{ .User }}</td><td>{{ .Spaces }}</td><td>{{ .Apps }}</td><td>{{ .ServiceInstances }}</td></tr>
+{{ end }}</table>{{ else }}<p>None.</p>{{ end }}
+{{ end }}{{ with .Report.Agencies }}<h2>Agencies</h2>
+<table>
+  <tr><th>Agency</th><th>Active spaces</th><th>Spaces notified</th><th>Spaces purged</th><th>Average lifetime (days)</th></tr>
+{{ range . }}  <tr><td>{{ .Agency }}</td><td>{{ .SpacesActive }}</td><td>{{ .SpacesNotified }}</td><td>{{ .SpacesPurged }}</td><td>{{ printf "%.1f" .AverageLifetimeDays }}</td></tr>
+{{ end }}</table>
+{{ end }}{{ with .Report.RolesDropped }}<h2>Roles not recreated</h2>
+<ul>
+{{ range . }}  <li>{{ . }}</li>
+{{ end }}</ul>
+{{ end }}<h2>Errors</h2>
+{{ if .Report.Errors }}<ul>
+{{ range .Report.Errors }}  <li>{{ . }}</li>
+{{ end }}</ul>{{ else }}<p>None.</p>{{ end }}
+`))
+
+// validReportFormat reports whether a report format can be rendered
+func validReportFormat(format string) bool {
+	switch format {
+	case "", reportFormatJSON, reportFormatMarkdown, reportFormatHTML, reportFormatCSV:
+		return true
+	}
+	return false
+}
+
+// WriteReport renders a report as JSON, Markdown, or HTML, or its messages
+// as CSV
+func WriteReport(w io.Writer, report Report, format string) error {
+	view := reportView{
+		Report: report,
+		Sections: []reportSection{
+			{Title: "Notified spaces", Results: report.results(planActionNotify)},
+			{Title: "Purged spaces", Results: report.results(planActionPurge)},
+			{Title: "Aged service instances", Results: report.results(planActionPurgeInstance)},
+			{Title: "Orphaned service instances", Results: report.results(planActionDeleteOrphan)},
+		},
+	}
+	if welcomed := report.results(planActionWelcome); len(welcomed) > 0 {
+		view.Sections = append(view.Sections, reportSection{Title: "Welcomed spaces", Results: welcomed})
+	}
+	if deleted := report.results(planActionDeleteEmpty); len(deleted) > 0 {
+		view.Sections = append(view.Sections, reportSection{Title: "Deleted empty spaces", Results: deleted})
+	}
+	switch format {
+	case reportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case reportFormatMarkdown:
+		return markdownReportTemplate.Execute(w, view)
+	case reportFormatHTML:
+		return htmlReportTemplate.Execute(w, view)
+	case reportFormatCSV:
+		return writeMessagesCSV(w, report.Messages)
+	default:
+		return fmt.Errorf("unknown report format %s", format)
+	}
+}
+
+// writeReportFile renders a report to a file, or to stdout if path is empty
+func writeReportFile(path string, report Report, format string) error {
+	if path == "" {
+		return WriteReport(os.Stdout, report, format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating report file %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := WriteReport(f, report, format); err != nil {
+		return fmt.Errorf("error writing report file %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// results returns the space results for a single action
+func (r *Report) results(action string) []SpaceResult {
+	var results []SpaceResult
+	for _, result := range r.Spaces {
+		if result.Action == action {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// markdownCell escapes a value for use in a Markdown table cell
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
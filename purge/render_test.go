@@ -0,0 +1,162 @@
+package purge
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testRenderReport() Report {
+	return Report{
+		StartedAt:      time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC),
+		SpacesNotified: 1,
+		SpacesPurged:   1,
+		APICalls:       12,
+		Spaces: []SpaceResult{
+			{
+				Org:           "sandbox-bar",
+				Space:         "foo",
+				Action:        planActionNotify,
+				FirstResource: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC),
+				Recipients:    []string{"foo@bar.gov"},
+			},
+			{
+				Org:           "sandbox-bar",
+				Space:         "a|b",
+				Action:        planActionPurge,
+				FirstResource: time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC),
+				Error:         "error purging <space>",
+			},
+		},
+		Errors: []string{"error purging <space>"},
+	}
+}
+
+func TestWriteReportMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, testRenderReport(), reportFormatMarkdown); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `# Sandbox purge report
+
+Run started 2024-01-02 15:04 UTC.
+
+| Spaces notified | Spaces purged | Warnings acknowledged | Orphans deleted | CF API calls | Errors |
+| ---: | ---: | ---: | ---: | ---: | ---: |
+| 1 | 1 | 0 | 0 | 12 | 1 |
+
+## Notified spaces
+
+| Org | Name | First resource | Recipients | Error |
+| --- | --- | --- | --- | --- |
+| sandbox-bar | foo | 2023-12-01 | foo@bar.gov | - |
+
+## Purged spaces
+
+| Org | Name | First resource | Recipients | Error |
+| --- | --- | --- | --- | --- |
+| sandbox-bar | a\|b | 2023-11-01 | - | error purging <space> |
+
+## Aged service instances
+
+None.
+
+## Orphaned service instances
+
+None.
+
+## Errors
+
+- error purging <space>
+`
+	if diff := cmp.Diff(expected, buf.String()); diff != "" {
+		t.Errorf("WriteReport() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteReportMarkdownLeaderboard(t *testing.T) {
+	report := Report{
+		StartedAt: time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC),
+		Leaderboard: &Leaderboard{
+			OldestSpaces: []LeaderboardSpace{{
+				Org:           "sandbox-bar",
+				Space:         "foo",
+				FirstResource: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC),
+				AgeDays:       32,
+				Apps:          2,
+				Owners:        []string{"foo@bar.gov"},
+			}},
+			HeaviestUsers: []LeaderboardUser{},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, report, reportFormatMarkdown); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `## Orphaned service instances
+
+None.
+
+## Oldest active sandboxes
+
+| Org | Space | First resource | Age (days) | Apps | Service instances | Owners |
+| --- | --- | --- | ---: | ---: | ---: | --- |
+| sandbox-bar | foo | 2023-12-01 | 32 | 2 | 0 | foo@bar.gov |
+
+## Heaviest users
+
+None.
+
+## Errors
+`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected Markdown report to contain %q, got:\n%s", expected, buf.String())
+	}
+}
+
+func TestWriteReportHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteReport(&buf, testRenderReport(), reportFormatHTML); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	html := buf.String()
+	for _, expected := range []string{
+		"<td>sandbox-bar</td><td>foo</td><td>2023-12-01</td><td>foo@bar.gov</td><td>-</td>",
+		"<li>error purging &lt;space&gt;</li>",
+		"<h2>Orphaned service instances</h2>\n<p>None.</p>",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("expected HTML report to contain %q, got:\n%s", expected, html)
+		}
+	}
+}
+
+func TestWriteReportRolesDropped(t *testing.T) {
+	report := Report{
+		StartedAt:    time.Date(2024, 1, 2, 15, 4, 0, 0, time.UTC),
+		RolesDropped: []string{"sandbox-agency/foo developer b@example.gov (deactivated in UAA)"},
+	}
+	expected := map[string]string{
+		reportFormatMarkdown: "## Roles not recreated\n\n- sandbox-agency/foo developer b@example.gov (deactivated in UAA)\n\n## Errors\n",
+		reportFormatHTML:     "<h2>Roles not recreated</h2>\n<ul>\n  <li>sandbox-agency/foo developer b@example.gov (deactivated in UAA)</li>\n</ul>\n<h2>Errors</h2>",
+	}
+	for format, section := range expected {
+		var buf bytes.Buffer
+		if err := WriteReport(&buf, report, format); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !strings.Contains(buf.String(), section) {
+			t.Errorf("expected %s report to contain %q, got:\n%s", format, section, buf.String())
+		}
+	}
+}
+
+func TestWriteReportUnknownFormat(t *testing.T) {
+	err := WriteReport(&bytes.Buffer{}, Report{}, "yaml")
+	if err == nil || err.Error() != "unknown report format yaml" {
+		t.Fatalf("expected unknown format error, got: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package purge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Report summarizes the actions taken during a run
+type Report struct {
+	// RunID identifies the run in its log lines and triage bundles
+	RunID      string    `json:"run_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DryRun     bool      `json:"dry_run"`
+	// Mode is live, dry-run, or report-only for a run configured to make
+	// changes that only reported them; ModeReason says why
+	Mode            string `json:"mode"`
+	ModeReason      string `json:"mode_reason,omitempty"`
+	SpacesNotified  int    `json:"spaces_notified"`
+	SpacesWelcomed  int    `json:"spaces_welcomed,omitempty"`
+	SpacesPurged    int    `json:"spaces_purged"`
+	AppsDeleted     int    `json:"apps_deleted"`
+	DropletsDeleted int    `json:"droplets_deleted"`
+	TasksCanceled   int    `json:"tasks_canceled"`
+	AppsStopped     int    `json:"apps_stopped"`
+	// InstancesForcePurged counts service instances purged from CF, without
+	// their brokers, after their deletes failed
+	InstancesForcePurged int `json:"instances_force_purged"`
+	// SpacesQuarantined lists the org/space names labeled purge-blocked
+	// after their delete failed
+	SpacesQuarantined []string `json:"spaces_quarantined,omitempty"`
+	// SpacesPendingDeprovision lists the org/space names whose delete is
+	// waiting for service instances to deprovision and is retried next run
+	SpacesPendingDeprovision []string `json:"spaces_pending_deprovision,omitempty"`
+	// SpacesCustomQuota lists the org/space names left out of purges because
+	// they are assigned a quota other than the sandbox quota
+	SpacesCustomQuota []string `json:"spaces_custom_quota,omitempty"`
+	// SpacesOverCaps lists the org/space names holding more apps, service
+	// instances, or routes than SPACE_MAX_* allow, with the caps they exceed
+	SpacesOverCaps []string `json:"spaces_over_caps,omitempty"`
+	// RouteServicesUnbound describes the route service bindings deleted,
+	// by org/space, so that spaces and service instances could be deleted
+	RouteServicesUnbound []string `json:"route_services_unbound,omitempty"`
+	// TemplateFallbacks lists the per-space emails sent as the built-in
+	// plaintext message because their template failed, by org/space, so the
+	// templates can be fixed
+	TemplateFallbacks []string `json:"template_fallbacks,omitempty"`
+	// QuotaDrift warns of sandbox quota lookups that matched several quotas
+	// in an org and which one the run used
+	QuotaDrift []string `json:"quota_drift,omitempty"`
+	// SpacesAdopted lists the org/space names of purged spaces whose name was
+	// taken again before they were recreated; the purge gave the space that
+	// took it the sandbox quota and roles instead
+	SpacesAdopted []string `json:"spaces_adopted,omitempty"`
+	// EmptySpacesDeleted lists the org/space names of recreated spaces
+	// deleted because they stayed empty, freeing their share of org quotas
+	EmptySpacesDeleted []string `json:"empty_spaces_deleted,omitempty"`
+	// RolesDropped describes the space roles a purge didn't recreate because
+	// their users are deactivated or deleted in UAA
+	RolesDropped []string `json:"roles_dropped,omitempty"`
+	// SpacesCreated lists the org/space names of user-named spaces created
+	// because they were missing
+	SpacesCreated []string `json:"spaces_created,omitempty"`
+	// Verifications lists the recreated spaces re-read once the run's purges
+	// finished, when VERIFY_SAMPLE_SIZE is set
+	Verifications   []SpaceVerification `json:"verifications,omitempty"`
+	InstancesPurged int                 `json:"instances_purged"`
+	OrphansDeleted  int                 `json:"orphans_deleted"`
+	SpacesAnnotated int                 `json:"spaces_annotated"`
+	// DeletedDuringRun counts actions skipped because users deleted the
+	// space or service instance first
+	DeletedDuringRun int `json:"deleted_during_run"`
+	// SpacesAcknowledged counts notified or purged spaces whose users
+	// acknowledged a purge warning
+	SpacesAcknowledged int             `json:"spaces_acknowledged"`
+	APICalls           int             `json:"api_calls"`
+	TopAPICalls        []EndpointCount `json:"top_api_calls"`
+	// Halted is why the kill switch made the run report-only, from the start
+	// or partway through
+	Halted string `json:"halted,omitempty"`
+	// ApprovedNotPlanned lists the actions in APPROVED_PLAN that the run
+	// didn't plan again, so didn't apply
+	ApprovedNotPlanned []string `json:"approved_not_planned,omitempty"`
+	// OrgsDeleted names the orgs deleted after the run listed them, whose
+	// remaining work was skipped
+	OrgsDeleted []string `json:"orgs_deleted,omitempty"`
+	// OrgsSkipped names the orgs left for the next run once MAX_RUNTIME ran out
+	OrgsSkipped []string `json:"orgs_skipped,omitempty"`
+	// PurgesDeferred counts purges left for the next run because
+	// PURGES_PER_HOUR couldn't fit them in before MAX_RUNTIME ran out
+	PurgesDeferred int `json:"purges_deferred,omitempty"`
+	// Leaderboard ranks the oldest active sandboxes and heaviest users when
+	// LEADERBOARD_SIZE is set
+	Leaderboard *Leaderboard `json:"leaderboard,omitempty"`
+	// Agencies summarizes spaces by their owners' email domains when
+	// AGENCY_ROLLUPS is set
+	Agencies []AgencyRollup `json:"agencies,omitempty"`
+	Spaces   []SpaceResult  `json:"spaces"`
+	// Messages lists the delivery status of every notification to every
+	// recipient
+	Messages []MessageResult `json:"messages,omitempty"`
+	// MailRelays counts the emails each SMTP relay sent when
+	// SMTP_SECONDARY_HOST is set
+	MailRelays *MailRelayStats `json:"mail_relays,omitempty"`
+	Errors     []string        `json:"errors"`
+	// DashboardURL is the DASHBOARD_URL results link into
+	DashboardURL string `json:"-"`
+}
+
+// SpaceResult describes the outcome of a planned action on a single space
+type SpaceResult struct {
+	Org           string    `json:"org"`
+	Space         string    `json:"space"`
+	SpaceGUID     string    `json:"space_guid"`
+	Action        string    `json:"action"`
+	FirstResource time.Time `json:"first_resource"`
+	Recipients    []string  `json:"recipients"`
+	// ServiceInstance names the service instance a purge-instance or
+	// delete-orphan action applied to
+	ServiceInstance string `json:"service_instance,omitempty"`
+	Error           string `json:"error,omitempty"`
+	// Note explains an action that was skipped without error
+	Note string `json:"note,omitempty"`
+	// Mismatches lists how a recreated space differs from the purged space
+	Mismatches []SpaceMismatch `json:"mismatches,omitempty"`
+	// AcknowledgedAt is when a user acknowledged the space's purge warning
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	// URL links to the space in the dashboard, or to its org once the space
+	// is gone or recreated, when DASHBOARD_URL is set
+	URL string `json:"url,omitempty"`
+}
+
+// recordAction adds the outcome of a planned action to the report; actions
+// whose target was deleted during the run are noted rather than failed
+func (r *Report) recordAction(action PlannedAction, err error) {
+	result := SpaceResult{
+		Org:            action.Org.Name,
+		Action:         action.Action,
+		FirstResource:  action.Details.Timestamp,
+		Recipients:     action.Recipients,
+		AcknowledgedAt: action.AcknowledgedAt,
+	}
+	if action.AcknowledgedAt != nil {
+		r.SpacesAcknowledged++
+	}
+	if action.Details.Space != nil {
+		result.Space = action.Details.Space.Name
+		result.SpaceGUID = action.Details.Space.GUID
+	}
+	if action.ServiceInstance != nil {
+		result.ServiceInstance = action.ServiceInstance.Name
+	}
+	switch action.Action {
+	case planActionPurge, planActionDeleteEmpty, planActionDeleteOrphan:
+		result.URL = dashboardOrgURL(r.DashboardURL, action.Org.GUID)
+	default:
+		result.URL = dashboardSpaceURL(r.DashboardURL, action.Org.GUID, result.SpaceGUID)
+	}
+	var pending *deprovisionPendingError
+	switch {
+	case errors.Is(err, errDeletedDuringRun):
+		result.Note = err.Error()
+		r.DeletedDuringRun++
+	case errors.As(err, &pending), errors.Is(err, errHalted), errors.Is(err, errPurgeDeferred),
+		errors.Is(err, errNotApproved):
+		result.Note = err.Error()
+	case err != nil:
+		result.Error = err.Error()
+		var mismatch *spaceMismatchError
+		if errors.As(err, &mismatch) {
+			result.Mismatches = mismatch.mismatches
+		}
+	}
+	r.Spaces = append(r.Spaces, result)
+}
+
+// pinTime timestamps the report and its messages at t, so dry runs against
+// the same data produce identical reports
+func (r *Report) pinTime(t time.Time) {
+	r.StartedAt, r.FinishedAt = t, t
+	for i := range r.Messages {
+		r.Messages[i].At = t
+	}
+}
+
+// reportOnly records that a run configured to make changes only reports
+// them, and why
+func (r *Report) reportOnly(reason string) {
+	r.DryRun = true
+	r.Mode = runModeReportOnly
+	r.ModeReason = reason
+}
+
+// recordMessages adds message delivery outcomes to the report
+func (r *Report) recordMessages(messages []MessageResult) {
+	r.Messages = append(r.Messages, messages...)
+}
+
+// recordCleanup adds the resources removed by a purge fallback to the report
+func (r *Report) recordCleanup(cleanup spaceCleanup) {
+	r.AppsDeleted += cleanup.AppsDeleted
+	r.DropletsDeleted += cleanup.DropletsDeleted
+	r.TasksCanceled += cleanup.TasksCanceled
+	r.AppsStopped += cleanup.AppsStopped
+	r.InstancesForcePurged += cleanup.InstancesForcePurged
+}
+
+// recordQuotaDrift adds a quota drift warning to the report once, however
+// many spaces in the org looked the quota up
+func (r *Report) recordQuotaDrift(warning string) {
+	for _, recorded := range r.QuotaDrift {
+		if recorded == warning {
+			return
+		}
+	}
+	r.QuotaDrift = append(r.QuotaDrift, warning)
+}
+
+// recordAPICalls adds the total and n most-called CF API endpoints to the report
+func (r *Report) recordAPICalls(stats *apiCallStats, n int) {
+	r.APICalls = stats.total()
+	r.TopAPICalls = stats.top(n)
+}
+
+// summary formats the report as a single log line
+func (r *Report) summary() string {
+	return fmt.Sprintf(
+		"notified %d spaces, purged %d spaces, deleted %d aged and %d orphaned service instances, fallback deleted %d apps and %d droplets and canceled %d tasks, quarantined %d spaces and stopped %d apps, deleted %d empty recreated spaces, skipped %d deleted during the run, %d CF API calls, %d errors",
+		r.SpacesNotified,
+		r.SpacesPurged,
+		r.InstancesPurged,
+		r.OrphansDeleted,
+		r.AppsDeleted,
+		r.DropletsDeleted,
+		r.TasksCanceled,
+		len(r.SpacesQuarantined),
+		r.AppsStopped,
+		len(r.EmptySpacesDeleted),
+		r.DeletedDuringRun,
+		r.APICalls,
+		len(r.Errors),
+	)
+}
+
+// ErrorSummary joins all recorded errors
+func (r *Report) ErrorSummary() string {
+	return strings.Join(r.Errors, ", ")
+}
@@ -0,0 +1,90 @@
+package purge
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReportRecordAction(t *testing.T) {
+	report := &Report{}
+	action := PlannedAction{
+		Action: planActionPurge,
+		Org:    &resource.Organization{Name: "sandbox-bar"},
+		Details: SpaceDetails{
+			Timestamp: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			Space:     &resource.Space{GUID: "space-1", Name: "foo"},
+		},
+		Recipients: []string{"foo@bar.gov"},
+	}
+
+	report.recordAction(action, nil)
+	report.recordAction(action, errors.New("error purging space foo"))
+
+	expected := []SpaceResult{
+		{
+			Org:           "sandbox-bar",
+			Space:         "foo",
+			SpaceGUID:     "space-1",
+			Action:        planActionPurge,
+			FirstResource: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			Recipients:    []string{"foo@bar.gov"},
+		},
+		{
+			Org:           "sandbox-bar",
+			Space:         "foo",
+			SpaceGUID:     "space-1",
+			Action:        planActionPurge,
+			FirstResource: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+			Recipients:    []string{"foo@bar.gov"},
+			Error:         "error purging space foo",
+		},
+	}
+	if diff := cmp.Diff(expected, report.Spaces); diff != "" {
+		t.Errorf("recordAction() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportPinTime(t *testing.T) {
+	pinned := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	report := &Report{
+		StartedAt:  time.Now(),
+		FinishedAt: time.Now(),
+		Messages:   []MessageResult{{Recipient: "foo@bar.gov", At: time.Now()}, {Recipient: "baz@bar.gov", At: time.Now()}},
+	}
+	report.pinTime(pinned)
+	expected := &Report{
+		StartedAt:  pinned,
+		FinishedAt: pinned,
+		Messages:   []MessageResult{{Recipient: "foo@bar.gov", At: pinned}, {Recipient: "baz@bar.gov", At: pinned}},
+	}
+	if diff := cmp.Diff(expected, report); diff != "" {
+		t.Errorf("pinTime() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	report := Report{
+		SpacesPurged: 1,
+		Spaces: []SpaceResult{
+			{Org: "sandbox-bar", Space: "foo", Action: planActionPurge},
+		},
+	}
+	contents, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(contents, &decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, key := range []string{"started_at", "finished_at", "dry_run", "spaces_notified", "spaces_purged", "spaces", "errors"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("expected key %s in report JSON: %s", key, contents)
+		}
+	}
+}
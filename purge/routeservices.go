@@ -0,0 +1,120 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// unbindSpaceRouteServices deletes the route service bindings of a space's
+// routes and service instances, which otherwise keep CF from deleting the
+// space, and notes each in the report
+func unbindSpaceRouteServices(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	space *resource.Space,
+	report *Report,
+) error {
+	routeListOptions := client.NewRouteListOptions()
+	routeListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	routes, err := cfClient.Routes.ListAll(ctx, routeListOptions)
+	if err != nil {
+		return fmt.Errorf("error listing routes in space %s: %w", space.Name, err)
+	}
+	serviceListOptions := client.NewServiceInstanceListOptions()
+	serviceListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	instances, err := cfClient.ServiceInstances.ListAll(ctx, serviceListOptions)
+	if err != nil {
+		return fmt.Errorf("error listing service instances in space %s: %w", space.Name, err)
+	}
+	unbound, err := unbindRouteServices(ctx, cfClient, space, routes, instances)
+	for _, binding := range unbound {
+		report.RouteServicesUnbound = append(report.RouteServicesUnbound, org.Name+"/"+space.Name+": "+binding)
+	}
+	return err
+}
+
+// unbindRouteServices deletes every route service binding of routes or of
+// instances, waiting for each asynchronous unbind to finish, and describes
+// the bindings it deleted
+func unbindRouteServices(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+	routes []*resource.Route,
+	instances []*resource.ServiceInstance,
+) ([]string, error) {
+	routeURLs := map[string]string{}
+	for _, route := range routes {
+		routeURLs[route.GUID] = route.URL
+	}
+	instanceNames := map[string]string{}
+	for _, instance := range instances {
+		instanceNames[instance.GUID] = instance.Name
+	}
+
+	routeGUIDs := make([]string, 0, len(routes))
+	for _, route := range routes {
+		routeGUIDs = append(routeGUIDs, route.GUID)
+	}
+	instanceGUIDs := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		instanceGUIDs = append(instanceGUIDs, instance.GUID)
+	}
+	var bindings []*resource.ServiceRouteBinding
+	for _, batch := range guidBatches(routeGUIDs) {
+		bindingListOptions := client.NewServiceRouteBindingListOptions()
+		bindingListOptions.RouteGUIDs.EqualTo(batch...)
+		routeBindings, err := cfClient.ServiceRouteBindings.ListAll(ctx, bindingListOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error listing route service bindings in space %s: %w", space.Name, err)
+		}
+		bindings = append(bindings, routeBindings...)
+	}
+	for _, batch := range guidBatches(instanceGUIDs) {
+		bindingListOptions := client.NewServiceRouteBindingListOptions()
+		bindingListOptions.ServiceInstanceGUIDs.EqualTo(batch...)
+		instanceBindings, err := cfClient.ServiceRouteBindings.ListAll(ctx, bindingListOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error listing route service bindings in space %s: %w", space.Name, err)
+		}
+		bindings = append(bindings, instanceBindings...)
+	}
+
+	var unbound []string
+	seen := map[string]bool{}
+	for _, binding := range bindings {
+		if seen[binding.GUID] {
+			continue
+		}
+		seen[binding.GUID] = true
+		routeGUID := relationshipGUID(&binding.Relationships.Route)
+		instanceGUID := relationshipGUID(&binding.Relationships.ServiceInstance)
+		described := fmt.Sprintf("route %s from service instance %s", nameOr(routeURLs[routeGUID], routeGUID), nameOr(instanceNames[instanceGUID], instanceGUID))
+
+		logFields{Space: space.Name}.printf("unbinding %s", described)
+		jobGUID, err := cfClient.ServiceRouteBindings.Delete(ctx, binding.GUID)
+		if isNotFoundError(err) {
+			continue
+		}
+		if err != nil {
+			return unbound, fmt.Errorf("error unbinding %s in space %s: %w", described, space.Name, err)
+		}
+		if err := waitForJob(ctx, cfClient, jobGUID); err != nil {
+			return unbound, fmt.Errorf("error waiting for unbind job %s to be complete: %w", jobGUID, err)
+		}
+		unbound = append(unbound, described)
+	}
+	return unbound, nil
+}
+
+// nameOr returns name, or guid for resources whose name wasn't listed
+func nameOr(name string, guid string) string {
+	if name == "" {
+		return guid
+	}
+	return name
+}
@@ -0,0 +1,141 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+// mockServiceRouteBindings lists the bindings matching the route or service
+// instance GUIDs filtered on
+type mockServiceRouteBindings struct {
+	bindings      []*resource.ServiceRouteBinding
+	deleteJobGUID string
+	deleteErr     error
+	deletedGUIDs  []string
+	// batches records how many GUIDs each listing filtered on
+	batches []int
+}
+
+func (b *mockServiceRouteBindings) ListAll(ctx context.Context, opts *client.ServiceRouteBindingListOptions) ([]*resource.ServiceRouteBinding, error) {
+	b.batches = append(b.batches, len(opts.RouteGUIDs.Values)+len(opts.ServiceInstanceGUIDs.Values))
+	var bindings []*resource.ServiceRouteBinding
+	for _, binding := range b.bindings {
+		if slices.Contains(opts.RouteGUIDs.Values, binding.Relationships.Route.Data.GUID) ||
+			slices.Contains(opts.ServiceInstanceGUIDs.Values, binding.Relationships.ServiceInstance.Data.GUID) {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings, nil
+}
+
+func (b *mockServiceRouteBindings) Delete(ctx context.Context, guid string) (string, error) {
+	b.deletedGUIDs = append(b.deletedGUIDs, guid)
+	return b.deleteJobGUID, b.deleteErr
+}
+
+func routeBinding(guid string, routeGUID string, instanceGUID string) *resource.ServiceRouteBinding {
+	return &resource.ServiceRouteBinding{
+		GUID: guid,
+		Relationships: resource.ServiceRouteBindingRelationships{
+			Route:           resource.ToOneRelationship{Data: &resource.Relationship{GUID: routeGUID}},
+			ServiceInstance: resource.ToOneRelationship{Data: &resource.Relationship{GUID: instanceGUID}},
+		},
+	}
+}
+
+func TestUnbindSpaceRouteServices(t *testing.T) {
+	org := &resource.Organization{Name: "sandbox-org"}
+	space := &resource.Space{GUID: "space-1", Name: "foo"}
+	routes := []*resource.Route{{GUID: "route-1", URL: "foo.app.cloud.gov"}}
+	instances := []*resource.ServiceInstance{{GUID: "instance-1", Name: "proxy"}}
+
+	testCases := map[string]struct {
+		bindings        []*resource.ServiceRouteBinding
+		deleteErr       error
+		expectedDeleted []string
+		expectedUnbound []string
+		expectedErr     string
+	}{
+		"no route services": {},
+		"unbinds each binding once": {
+			bindings: []*resource.ServiceRouteBinding{
+				routeBinding("binding-1", "route-1", "instance-1"),
+				routeBinding("binding-2", "route-1", "instance-other"),
+			},
+			expectedDeleted: []string{"binding-1", "binding-2"},
+			expectedUnbound: []string{
+				"sandbox-org/foo: route foo.app.cloud.gov from service instance proxy",
+				"sandbox-org/foo: route foo.app.cloud.gov from service instance instance-other",
+			},
+		},
+		"already unbound": {
+			bindings:        []*resource.ServiceRouteBinding{routeBinding("binding-1", "route-1", "instance-1")},
+			deleteErr:       resource.NewResourceNotFoundError(),
+			expectedDeleted: []string{"binding-1"},
+		},
+		"unbind error": {
+			bindings:        []*resource.ServiceRouteBinding{routeBinding("binding-1", "route-1", "instance-1")},
+			deleteErr:       errors.New("boom"),
+			expectedDeleted: []string{"binding-1"},
+			expectedErr:     "error unbinding route foo.app.cloud.gov from service instance proxy in space foo: boom",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			bindings := &mockServiceRouteBindings{bindings: test.bindings, deleteJobGUID: "job-1", deleteErr: test.deleteErr}
+			cfClient := &cfResourceClient{
+				Routes:               &mockRoutes{routes: routes},
+				ServiceInstances:     &mockServiceInstances{instances: instances},
+				ServiceRouteBindings: bindings,
+				Jobs:                 &mockJobs{expectedJobGUID: "job-1"},
+			}
+			report := &Report{}
+
+			err := unbindSpaceRouteServices(context.Background(), cfClient, org, space, report)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error %q, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expectedDeleted, bindings.deletedGUIDs); diff != "" {
+				t.Errorf("deleted bindings mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedUnbound, report.RouteServicesUnbound); diff != "" {
+				t.Errorf("report mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnbindSpaceRouteServicesBatchesFilters(t *testing.T) {
+	org := &resource.Organization{Name: "sandbox-org"}
+	space := &resource.Space{GUID: "space-1", Name: "foo"}
+	var routes []*resource.Route
+	for i := 0; i < guidBatchSize+1; i++ {
+		routes = append(routes, &resource.Route{GUID: fmt.Sprintf("route-%d", i), URL: fmt.Sprintf("foo-%d.app.cloud.gov", i)})
+	}
+	bindings := &mockServiceRouteBindings{bindings: []*resource.ServiceRouteBinding{
+		routeBinding("binding-1", fmt.Sprintf("route-%d", guidBatchSize), "instance-1"),
+	}, deleteJobGUID: "job-1"}
+	cfClient := &cfResourceClient{
+		Routes:               &mockRoutes{routes: routes},
+		ServiceInstances:     &mockServiceInstances{},
+		ServiceRouteBindings: bindings,
+		Jobs:                 &mockJobs{expectedJobGUID: "job-1"},
+	}
+
+	if err := unbindSpaceRouteServices(context.Background(), cfClient, org, space, &Report{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff([]int{guidBatchSize, 1}, bindings.batches); diff != "" {
+		t.Errorf("batches mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"binding-1"}, bindings.deletedGUIDs); diff != "" {
+		t.Errorf("deleted bindings mismatch (-want +got):\n%s", diff)
+	}
+}
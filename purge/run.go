@@ -0,0 +1,458 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// Run notifies and purges sandbox spaces as configured and alerts if the run
+// fails; the returned report describes the actions taken even when the run
+// aborts with an error
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	if err := cfg.Validate(); err != nil {
+		return Report{}, fmt.Errorf("error parsing options: %w", err)
+	}
+	if err := cfg.usePolicyFile(ctx); err != nil {
+		return Report{}, err
+	}
+	if err := cfg.useSpaceGUIDsFile(); err != nil {
+		return Report{}, err
+	}
+	if err := cfg.usePlanCostFile(ctx); err != nil {
+		return Report{}, err
+	}
+	cleanupTemplates, err := cfg.useServiceTemplates()
+	if err != nil {
+		return Report{}, err
+	}
+	defer cleanupTemplates()
+	if err := lintPolicyTemplates(cfg); err != nil {
+		return Report{}, err
+	}
+
+	alertSender, err := newAlerter(cfg.AlertOptions)
+	if err != nil {
+		return Report{}, fmt.Errorf("error configuring alerts: %w", err)
+	}
+
+	var prof *profiler
+	if cfg.ProfileDir != "" {
+		prof, err = startProfiler(cfg.ProfileDir)
+		if err != nil {
+			return Report{}, fmt.Errorf("error starting profiler: %w", err)
+		}
+	}
+
+	report := &Report{
+		StartedAt:    time.Now(),
+		DryRun:       cfg.DryRun,
+		Mode:         runModeLive,
+		DashboardURL: cfg.DashboardURL,
+	}
+	if cfg.DryRun {
+		report.Mode = runModeDryRun
+	}
+	report.RunID = runID(report.StartedAt)
+	if pinned, ok := cfg.pinnedTime(); ok {
+		// so that dry runs pinned to the same time have identical reports
+		report.RunID = runID(pinned)
+	}
+	setLogRun(report.RunID)
+	status := newRunStatus()
+	stopWatching := watchStatusSignal(status, cfg.StatusFile)
+	runErr := run(ctx, cfg, report, prof, status)
+	stopWatching()
+	report.FinishedAt = time.Now()
+	if pinned, ok := cfg.pinnedTime(); ok {
+		report.pinTime(pinned)
+	}
+	if err := prof.stop(); err != nil {
+		logFields{Err: err}.printf("error writing profiles: %s", err)
+	}
+
+	logFields{}.printf("run summary: %s", report.summary())
+	if cfg.ReportFormat != "" {
+		if err := writeReportFile(cfg.ReportFile, *report, cfg.ReportFormat); err != nil {
+			logFields{Err: err}.printf("error writing report: %s", err)
+		}
+	}
+	if cfg.MetricsTextfile != "" {
+		if err := writeMetricsTextfile(cfg.MetricsTextfile, report, runErr); err != nil {
+			logFields{Err: err}.printf("error writing metrics: %s", err)
+		}
+	}
+	alertAndPublish(ctx, cfg, alertSender, report, runErr)
+
+	return *report, runErr
+}
+
+// alertAndPublish alerts if the run failed and publishes its report to
+// GitHub. A run aborted by SIGTERM has a canceled context, but its alert is
+// the one that matters most, so both get up to alertTimeout of their own
+func alertAndPublish(ctx context.Context, cfg Config, alertSender alerter, report *Report, runErr error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), alertTimeout)
+	defer cancel()
+	if summary, ok := alertSummary(cfg.AlertOptions, report, runErr); ok && alertSender != nil {
+		if err := alertSender.sendAlert(ctx, summary, report); err != nil {
+			logFields{Err: err}.printf("error sending alert: %s", err)
+		}
+	}
+	if publisher := newGitHubPublisher(cfg.GitHubOptions); publisher != nil {
+		if err := publisher.publish(ctx, *report); err != nil {
+			logFields{Err: err}.printf("error publishing report to GitHub: %s", err)
+		}
+	}
+}
+
+// run notifies and purges sandbox spaces across all sandbox orgs, recording
+// outcomes in report; it returns an error if the run aborts
+func run(ctx context.Context, opts Config, report *Report, prof *profiler, status *runStatus) error {
+	apiCalls := newAPICallStats()
+	defer func() {
+		apiCalls.log(opts.CFAPITopCalls)
+		report.recordAPICalls(apiCalls, opts.CFAPITopCalls)
+	}()
+
+	cfClient, err := newCFClient(opts.CFOptions, apiCalls.wrap)
+	if err != nil {
+		return fmt.Errorf("error creating client: %w", err)
+	}
+	opts, err = preflight(ctx, cfClient, opts, report)
+	if err != nil {
+		return err
+	}
+	triage := newTriageCollector(opts, apiCalls, report.StartedAt)
+
+	transport, err := newMailTransport(opts)
+	if err != nil {
+		return err
+	}
+	if smtpTransport, ok := transport.(*smtpMailer); ok {
+		defer func() { report.MailRelays = smtpTransport.relayStats() }()
+	}
+	mailSender, err := newNotifier(opts.ChannelOptions, opts.S3Options, transport)
+	if err != nil {
+		return fmt.Errorf("error configuring notification channels: %w", err)
+	}
+	mailSender = newDomainRateLimitedMailer(mailSender, opts.MailDomainInterval)
+	mailSender = newOverrideRecipientMailer(mailSender, opts.MailOverrideRecipient)
+
+	store := newStateStore(opts)
+	var state *State
+	if store != nil {
+		state, err = store.load()
+		if err != nil {
+			return err
+		}
+	}
+
+	var plan *Plan
+	if opts.ApplyPlan != "" {
+		plan, err = readPlanFile(opts.ApplyPlan)
+		if err != nil {
+			return err
+		}
+	} else {
+		plan, err = planRun(ctx, cfClient, opts, state, report, prof, status)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.ApprovedPlan != "" {
+		approved, err := readApprovedPlan(ctx, opts)
+		if err != nil {
+			return err
+		}
+		approvePlan(plan, approved, report)
+	}
+
+	if err := plan.writeText(log.Writer()); err != nil {
+		return fmt.Errorf("error printing plan: %w", err)
+	}
+	if len(plan.Inventory) > 0 {
+		if err := exportInventory(ctx, opts.InventoryOptions, plan.Inventory, report.StartedAt); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+	if opts.LeaderboardSize > 0 && opts.ApplyPlan == "" {
+		report.Leaderboard = buildLeaderboard(plan.Inventory, opts.LeaderboardSize)
+		if opts.LeaderboardCSVDir != "" {
+			if err := writeLeaderboardCSV(opts.LeaderboardCSVDir, report.Leaderboard); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+	}
+	if opts.AgencyRollups && opts.ApplyPlan == "" {
+		report.Agencies = buildAgencyRollups(plan.Inventory)
+		if opts.AgencyRollupCSV != "" {
+			if err := writeAgencyRollupCSV(opts.AgencyRollupCSV, report.Agencies); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+	}
+	if opts.PlanFile != "" {
+		if err := savePlan(ctx, opts, opts.PlanFile, plan); err != nil {
+			return err
+		}
+	}
+	if err := checkAnomalies(opts.AnomalyOptions, state, plan); err != nil {
+		return err
+	}
+	if opts.PlanOnly {
+		logFields{}.printf("plan only; no actions taken")
+		return nil
+	}
+	if err := sendOperatorDigest(ctx, opts, plan, mailSender); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	status.startApply(len(plan.Actions))
+	applyErr := applyPlan(ctx, cfClient, opts, plan, mailSender, state, report, status, triage)
+	if applyErr == nil && opts.CreateUserSpaces && opts.ApplyPlan == "" && !opts.constrained() && report.Halted == "" {
+		orgs, err := listSandboxOrgs(ctx, cfClient, opts.OrgPrefix)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("error getting sandbox orgs: %s", err))
+		} else {
+			reconcileUserSpaces(ctx, cfClient, opts, orgs, report)
+		}
+	}
+	// a run constrained to a few spaces says nothing about the rest, so it
+	// leaves the run history alone
+	if !opts.constrained() {
+		state.recordRun(plan.runCounts(), opts.AnomalyWindow)
+		state.recordLastRun(report.StartedAt)
+	}
+	if store != nil && !opts.DryRun {
+		if err := store.save(state); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+	if applyErr != nil {
+		return applyErr
+	}
+	prof.phase("apply plan")
+
+	return nil
+}
+
+// preflight makes the checks a scheduled run and an on-demand purge share
+// before anything reads or changes sandboxes: it refuses a foundation other
+// than the expected one, and makes the purge report-only while the kill
+// switch is engaged or the client can't make changes. It returns opts with
+// the kill switch and UAA user check set up
+func preflight(ctx context.Context, cfClient *cfResourceClient, opts Config, report *Report) (Config, error) {
+	if err := guardFoundation(ctx, cfClient, opts.FoundationOptions); err != nil {
+		return opts, err
+	}
+	opts.killSwitch = newKillSwitch(opts.KillSwitchOptions, opts.S3Options)
+	if reason := opts.killSwitch.check(ctx, cfClient); reason != "" && !opts.DryRun {
+		opts.DryRun = true
+		report.reportOnly("kill switch engaged: " + reason)
+		report.Halted = reason
+	}
+	if !opts.DryRun {
+		// a client that can only read would otherwise fail partway through
+		// with 403s
+		reason, err := missingWriteAccess(ctx, cfClient)
+		if err != nil {
+			logFields{Err: err}.printf("can't tell whether the client can make changes; running live: %s", err)
+		} else if reason != "" {
+			opts.DryRun = true
+			report.reportOnly(reason)
+			logFields{}.printf("%s; the run is report-only", reason)
+		}
+	}
+	opts.userCheck = newUAAUserChecker(opts.UAAUserCheckOptions, cfClient)
+	return opts, nil
+}
+
+// planRun lists sandbox orgs and users and plans actions for every sandbox space
+func planRun(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	state *State,
+	report *Report,
+	prof *profiler,
+	status *runStatus,
+) (*Plan, error) {
+	status.setPhase("listing orgs")
+	orgs, err := listSandboxOrgs(ctx, cfClient, opts.OrgPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("error getting orgs: %w", err)
+	}
+	if opts.constrained() {
+		orgs, err = listConstrainedOrgs(ctx, cfClient, opts, orgs)
+		if err != nil {
+			return nil, err
+		}
+		logFields{}.printf("processing %d listed spaces in %d orgs", len(opts.spaceGUIDs), len(orgs))
+	}
+	prof.phase("list orgs")
+
+	status.setPhase("listing users")
+
+	userGUIDs, err := listUserGUIDs(ctx, cfClient)
+	if err != nil {
+		return nil, fmt.Errorf("error getting users: %w", err)
+	}
+	prof.phase("list users")
+
+	systemPlans, err := listSystemPlans(ctx, cfClient, opts.SystemServiceOptions)
+	if err != nil {
+		return nil, err
+	}
+	if opts.costlyInstancePurgeEnabled() {
+		opts.planCosts, err = listPlanCosts(ctx, cfClient, opts.planCostTable)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().Truncate(24 * time.Hour)
+	if opts.clockAhead > 0 {
+		now = time.Now().Add(opts.clockAhead).Truncate(24 * time.Hour)
+		logFields{}.printf("evaluating as of %s", now.Format(time.RFC3339))
+	}
+	pinned, pinnedTime := opts.pinnedTime()
+	if pinnedTime {
+		logFields{}.printf("evaluating as of %s", pinned.Format(time.RFC3339))
+		now = pinned.Truncate(24 * time.Hour)
+	}
+
+	timeStartsAt, err := opts.timeStartsAt()
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := buildPlan(ctx, cfClient, opts, orgs, userGUIDs, systemPlans, now, timeStartsAt, state, report, prof, status)
+	if err == nil && pinnedTime {
+		plan.CreatedAt = pinned
+	}
+	return plan, err
+}
+
+// orgEvaluation is the outcome of evaluating a single org
+type orgEvaluation struct {
+	toNotify      []SpaceDetails
+	toPurge       []SpaceDetails
+	toWelcome     []SpaceDetails
+	toDeleteEmpty []SpaceDetails
+	orphans       []*resource.ServiceInstance
+	agedInstances []spaceInstances
+	annotations   []SpaceAnnotation
+	contents      []SpaceContents
+	inventory     []InventoryRecord
+	overCaps      []spaceOverCaps
+	// rosters are the planned spaces' roles and users, from the inventory
+	rosters spaceRosters
+}
+
+// evaluateOrg lists an org's resources, space by space for orgs above
+// TARGETED_QUERY_THRESHOLD, and identifies spaces to notify or
+// purge, aged service instances to delete, and orphaned service instances to
+// delete; instances provisioned from systemPlans don't count toward a space's
+// age or get purged on their own. Only the evaluation outlives the call, and
+// the plan keeps compacted copies of the spaces and instances it acts on, so
+// the org's listings can be freed before the next org is listed
+func evaluateOrg(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	opts Config,
+	systemPlans map[string]bool,
+	now time.Time,
+	timeStartsAt time.Time,
+) (orgEvaluation, error) {
+	targeted, err := useTargetedQueries(ctx, cfClient, org, opts)
+	if err != nil {
+		return orgEvaluation{}, err
+	}
+	if targeted {
+		return evaluateOrgBySpace(ctx, cfClient, org, opts, systemPlans, now, timeStartsAt)
+	}
+
+	logFields{Org: org.Name}.printf("getting org resources for org %s", org.Name)
+	inventory, err := collectOrgInventory(ctx, cfClient, org)
+	if err != nil {
+		return orgEvaluation{}, fmt.Errorf("error listing org resources for org %s: %w", org.Name, err)
+	}
+	return evaluateResources(inventory, opts, systemPlans, now, timeStartsAt), nil
+}
+
+// evaluateResources makes evaluateOrg's decisions from an org's inventory
+func evaluateResources(
+	inventory *collect.Inventory,
+	opts Config,
+	systemPlans map[string]bool,
+	now time.Time,
+	timeStartsAt time.Time,
+) orgEvaluation {
+	org := inventory.Org
+	userInventory := inventory.WithoutSystemInstances(systemPlans)
+
+	var evaluation orgEvaluation
+	evaluation.toNotify, evaluation.toPurge = listPurgeSpaces(userInventory, opts, now, timeStartsAt)
+	if opts.capsSet() {
+		evaluation.overCaps = listOverCapSpaces(userInventory, opts.SpaceCapOptions)
+		if opts.EnforceSpaceCaps && !opts.DisablePurge {
+			evaluation.toNotify, evaluation.toPurge = enforceSpaceCaps(evaluation.overCaps, evaluation.toNotify, evaluation.toPurge, userInventory, opts.AgeBy, timeStartsAt)
+		}
+	}
+	evaluation.orphans = inventory.Orphans
+	evaluation.agedInstances = listAgedInstances(userInventory, evaluation.toPurge, opts, now, timeStartsAt)
+	if opts.OperatorDigestOptions.enabled() {
+		evaluation.contents = listSpaceContents(userInventory, evaluation.toNotify, evaluation.toPurge, now)
+	}
+
+	if opts.AnnotateSpaces || opts.collectsInventory() || opts.welcomeEnabled() {
+		details := listSpaceFirstResources(userInventory, opts.AgeBy, timeStartsAt)
+		if opts.AnnotateSpaces {
+			evaluation.annotations = planSpaceAnnotations(org, details, evaluation.toPurge, opts, now)
+		}
+		if opts.welcomeEnabled() {
+			// a space is welcomed when its first resource appears, however
+			// it ages
+			firstResources := details
+			if opts.AgeBy == ageByUpdated {
+				firstResources = listSpaceFirstResources(userInventory, ageByCreated, timeStartsAt)
+			}
+			evaluation.toWelcome = listWelcomeSpaces(firstResources, evaluation.toNotify, evaluation.toPurge)
+		}
+		if opts.collectsInventory() {
+			evaluation.inventory = listInventory(inventory, details, evaluation.toNotify, evaluation.toPurge, now)
+		}
+	}
+	if opts.EmptySpaceDeleteDays > 0 {
+		var unmarked []SpaceAnnotation
+		evaluation.toDeleteEmpty, unmarked = listEmptyRecreatedSpaces(inventory, opts, now)
+		evaluation.annotations = mergeSpaceAnnotations(evaluation.annotations, unmarked)
+	}
+	if opts.DashboardNotices {
+		notices := planPurgeWarningNotices(org, inventory.SpaceList(), evaluation.toNotify, evaluation.toPurge, opts)
+		evaluation.annotations = mergeSpaceAnnotations(evaluation.annotations, notices)
+	}
+	evaluation.rosters = inventoryRosters(inventory, evaluation.plannedSpaceGUIDs())
+	return evaluation
+}
+
+// listUserGUIDs builds a filter of users with email addresses (not service accounts)
+func listUserGUIDs(ctx context.Context, cfClient *cfResourceClient) (map[string]bool, error) {
+	users, err := cfClient.Users.ListAll(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	userGUIDs := map[string]bool{}
+	for _, user := range users {
+		if strings.Contains(user.Username, "@") {
+			userGUIDs[user.GUID] = true
+		}
+	}
+	return userGUIDs, nil
+}
@@ -0,0 +1,286 @@
+package purge
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Options describes credentials and endpoints for reading from and
+// writing to S3
+type S3Options struct {
+	AWSRegion          string `env:"AWS_REGION, default=us-gov-west-1"`
+	AWSAccessKeyID     string `env:"AWS_ACCESS_KEY_ID"`
+	AWSSecretAccessKey string `env:"AWS_SECRET_ACCESS_KEY"`
+	AWSSessionToken    string `env:"AWS_SESSION_TOKEN"`
+	// S3Endpoint overrides the regional S3 endpoint, e.g. for S3-compatible
+	// stores; objects are addressed path-style when it is set
+	S3Endpoint string `env:"S3_ENDPOINT"`
+	// S3PartSize is the size of each part of a multipart upload; objects
+	// larger than one part are uploaded in parts
+	S3PartSize int `env:"S3_PART_SIZE, default=8388608"`
+	// S3Retries is how many times a failed S3 request, or a failed part of
+	// a multipart upload, is retried
+	S3Retries int `env:"S3_RETRIES, default=3"`
+}
+
+func (o S3Options) validate() error {
+	// zero, which only struct literals leave, falls back to the default
+	if o.S3PartSize != 0 && o.S3PartSize < s3MinPartSize {
+		return fmt.Errorf("S3_PART_SIZE must be at least %d (5 MiB), got %d", s3MinPartSize, o.S3PartSize)
+	}
+	if o.S3Retries < 0 {
+		return fmt.Errorf("S3_RETRIES must not be negative, got %d", o.S3Retries)
+	}
+	return nil
+}
+
+// s3ChecksumHeader carries the Content-MD5 of a whole object as user
+// metadata, written on upload and checked on download; the ETag of an object
+// uploaded in parts, or encrypted with KMS, isn't its MD5
+const s3ChecksumHeader = "X-Amz-Meta-Content-Md5"
+
+// s3Client reads and writes objects in S3 with SigV4-signed requests
+type s3Client struct {
+	options    S3Options
+	httpClient *http.Client
+	now        func() time.Time
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+func newS3Client(opts S3Options) *s3Client {
+	if opts.S3PartSize <= 0 {
+		opts.S3PartSize = s3DefaultPartSize
+	}
+	return &s3Client{
+		options:    opts,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+		now:        time.Now,
+		sleep:      sleepContext,
+	}
+}
+
+// objectURL returns the URL of an object, virtual-hosted style unless a
+// custom endpoint is configured
+func (c *s3Client) objectURL(bucket string, key string) string {
+	escaped := escapeS3Path(key)
+	if c.options.S3Endpoint != "" {
+		return strings.TrimRight(c.options.S3Endpoint, "/") + "/" + bucket + "/" + escaped
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, c.options.AWSRegion, escaped)
+}
+
+// putObject uploads body to bucket under key, in parts if it is larger than
+// S3_PART_SIZE; failed requests are retried
+func (c *s3Client) putObject(ctx context.Context, bucket string, key string, contentType string, body []byte) error {
+	if len(body) > c.options.S3PartSize {
+		return c.putMultipart(ctx, bucket, key, contentType, body)
+	}
+	header := http.Header{"Content-Type": {contentType}, s3ChecksumHeader: {contentMD5(body)}}
+	return c.withRetries(ctx, fmt.Sprintf("writing s3://%s/%s", bucket, key), func() error {
+		_, _, err := c.send(ctx, http.MethodPut, c.objectURL(bucket, key), header, body, "writing", bucket, key)
+		return err
+	})
+}
+
+// send sends a signed request to url, with a Content-MD5 checksum of any
+// body so S3 rejects a body corrupted on the way, and returns the response
+// headers and body, or an *s3StatusError for a non-2xx response
+func (c *s3Client) send(
+	ctx context.Context,
+	method string,
+	url string,
+	header http.Header,
+	body []byte,
+	verb string,
+	bucket string,
+	key string,
+) (http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		req.Header.Set("Content-MD5", contentMD5(body))
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	c.sign(req, payloadHash)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error %s s3://%s/%s: %w", verb, bucket, key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, nil, &s3StatusError{
+			statusCode: resp.StatusCode,
+			message:    fmt.Sprintf("error %s s3://%s/%s: %s: %s", verb, bucket, key, resp.Status, strings.TrimSpace(string(detail))),
+		}
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error %s s3://%s/%s: %w", verb, bucket, key, err)
+	}
+	return resp.Header, respBody, nil
+}
+
+// emptyPayloadHash is the SHA-256 digest of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3StatusError reports an S3 request that got a non-2xx response
+type s3StatusError struct {
+	statusCode int
+	message    string
+}
+
+func (e *s3StatusError) Error() string {
+	return e.message
+}
+
+// retryable reports whether the request might succeed if sent again: S3
+// errors, throttling, timeouts, and bodies that failed their checksum
+func (e *s3StatusError) retryable() bool {
+	return e.statusCode >= 500 ||
+		e.statusCode == http.StatusTooManyRequests ||
+		strings.Contains(e.message, "<Code>BadDigest</Code>") ||
+		strings.Contains(e.message, "<Code>RequestTimeout</Code>")
+}
+
+// getObject downloads the object in bucket under key; failed requests, and
+// bodies that don't match the object's checksum, are retried
+func (c *s3Client) getObject(ctx context.Context, bucket string, key string) ([]byte, error) {
+	var body []byte
+	err := c.withRetries(ctx, fmt.Sprintf("reading s3://%s/%s", bucket, key), func() error {
+		header, respBody, err := c.send(ctx, http.MethodGet, c.objectURL(bucket, key), nil, nil, "reading", bucket, key)
+		if err != nil {
+			return err
+		}
+		if !s3BodyMatches(header, respBody) {
+			return &s3StatusError{
+				statusCode: http.StatusInternalServerError,
+				message:    fmt.Sprintf("error reading s3://%s/%s: body doesn't match its checksum", bucket, key),
+			}
+		}
+		body = respBody
+		return nil
+	})
+	return body, err
+}
+
+// s3BodyMatches checks a downloaded body against the checksum written on
+// upload or, for objects written without one, an ETag that is a plain MD5;
+// bodies with neither can't be checked and are accepted
+func s3BodyMatches(header http.Header, body []byte) bool {
+	if checksum := header.Get(s3ChecksumHeader); checksum != "" {
+		return checksum == contentMD5(body)
+	}
+	etag := strings.Trim(header.Get("ETag"), `"`)
+	if len(etag) != 32 || header.Get("X-Amz-Server-Side-Encryption") == "aws:kms" {
+		return true
+	}
+	sum := md5.Sum(body)
+	return strings.EqualFold(etag, hex.EncodeToString(sum[:]))
+}
+
+// sign adds an S3 authorization header to req
+func (c *s3Client) sign(req *http.Request, payloadHash string) {
+	signV4(req, c.options, "s3", payloadHash, c.now())
+}
+
+// signV4 adds an AWS Signature Version 4 authorization header for service
+// to req, covering the host and every header already set on req
+func signV4(req *http.Request, opts S3Options, service string, payloadHash string, at time.Time) {
+	now := at.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if opts.AWSSessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", opts.AWSSessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", day, opts.AWSRegion, service)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+opts.AWSSecretAccessKey), day)
+	key = hmacSHA256(key, opts.AWSRegion)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		opts.AWSAccessKeyID,
+		scope,
+		signedHeaders,
+		signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapeS3Path percent-encodes everything in an object key except unreserved
+// characters and slashes, as SigV4 canonical requests require
+func escapeS3Path(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
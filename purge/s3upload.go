@@ -0,0 +1,162 @@
+package purge
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// s3DefaultPartSize is the part size used when S3_PART_SIZE isn't set
+	s3DefaultPartSize = 8 << 20
+	// s3MinPartSize is the smallest part S3 accepts, other than the last
+	s3MinPartSize = 5 << 20
+	// s3RetryDelay is the wait before the first retry of an S3 request,
+	// doubled before each retry after it
+	s3RetryDelay = time.Second
+)
+
+// contentMD5 returns the Content-MD5 header of body
+func contentMD5(body []byte) string {
+	sum := md5.Sum(body)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// withRetries calls attempt until it succeeds, fails in a way a retry can't
+// fix, or has been retried S3_RETRIES times, backing off between attempts
+func (c *s3Client) withRetries(ctx context.Context, what string, attempt func() error) error {
+	delay := s3RetryDelay
+	for retry := 0; ; retry++ {
+		err := attempt()
+		if err == nil || retry >= c.options.S3Retries || ctx.Err() != nil || !isRetryableS3Error(err) {
+			return err
+		}
+		logFields{Err: err}.printf("error %s, retrying in %s: %s", what, delay, err)
+		if err := c.sleep(ctx, delay); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// isRetryableS3Error reports whether err is a network failure or an S3
+// response worth retrying
+func isRetryableS3Error(err error) bool {
+	var status *s3StatusError
+	if errors.As(err, &status) {
+		return status.retryable()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// s3InitiateMultipartUploadResult is the response to starting an upload
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// s3CompleteMultipartUpload lists the uploaded parts to assemble
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads body in parts of S3_PART_SIZE. Each part carries its
+// own checksum and is retried on its own, so a dropped connection resumes
+// with the part that failed rather than resending the whole object. An
+// upload that can't be completed is aborted, so S3 doesn't keep its parts
+func (c *s3Client) putMultipart(ctx context.Context, bucket string, key string, contentType string, body []byte) error {
+	objectURL := c.objectURL(bucket, key)
+	what := fmt.Sprintf("writing s3://%s/%s", bucket, key)
+
+	var uploadID string
+	err := c.withRetries(ctx, what, func() error {
+		header := http.Header{"Content-Type": {contentType}, s3ChecksumHeader: {contentMD5(body)}}
+		_, respBody, err := c.send(ctx, http.MethodPost, objectURL+"?uploads", header, nil, "writing", bucket, key)
+		if err != nil {
+			return err
+		}
+		var result s3InitiateMultipartUploadResult
+		if err := xml.Unmarshal(respBody, &result); err != nil || result.UploadID == "" {
+			return fmt.Errorf("error writing s3://%s/%s: no upload ID in response: %s", bucket, key, respBody)
+		}
+		uploadID = result.UploadID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.uploadParts(ctx, bucket, key, uploadID, body); err != nil {
+		c.abortMultipart(bucket, key, uploadID)
+		return err
+	}
+	return nil
+}
+
+// uploadParts uploads each part of body and then assembles them
+func (c *s3Client) uploadParts(ctx context.Context, bucket string, key string, uploadID string, body []byte) error {
+	objectURL := c.objectURL(bucket, key)
+	var complete s3CompleteMultipartUpload
+	for start, number := 0, 1; start < len(body); start, number = start+c.options.S3PartSize, number+1 {
+		part := body[start:min(start+c.options.S3PartSize, len(body))]
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		what := fmt.Sprintf("writing part %d of s3://%s/%s", number, bucket, key)
+		err := c.withRetries(ctx, what, func() error {
+			header, _, err := c.send(ctx, http.MethodPut, objectURL+"?"+query.Encode(), nil, part, "writing", bucket, key)
+			if err != nil {
+				return err
+			}
+			complete.Parts = append(complete.Parts, s3CompletedPart{PartNumber: number, ETag: header.Get("ETag")})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	contents, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"uploadId": {uploadID}}
+	return c.withRetries(ctx, fmt.Sprintf("writing s3://%s/%s", bucket, key), func() error {
+		_, respBody, err := c.send(ctx, http.MethodPost, objectURL+"?"+query.Encode(), nil, contents, "writing", bucket, key)
+		if err != nil {
+			return err
+		}
+		// S3 can report a failure to assemble the parts in the body of a
+		// 200 response, which is worth retrying
+		var root struct{ XMLName xml.Name }
+		if xml.Unmarshal(respBody, &root) == nil && root.XMLName.Local == "Error" {
+			return &s3StatusError{
+				statusCode: http.StatusInternalServerError,
+				message:    fmt.Sprintf("error writing s3://%s/%s: %s", bucket, key, respBody),
+			}
+		}
+		return nil
+	})
+}
+
+// abortMultipart discards the parts of a failed upload; it runs even if the
+// run's context was canceled, and a failure is only logged
+func (c *s3Client) abortMultipart(bucket string, key string, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	query := url.Values{"uploadId": {uploadID}}
+	if _, _, err := c.send(ctx, http.MethodDelete, c.objectURL(bucket, key)+"?"+query.Encode(), nil, nil, "aborting upload to", bucket, key); err != nil {
+		logFields{Err: err}.printf("%s", err)
+	}
+}
@@ -0,0 +1,393 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+type spaceUser struct {
+	GUID     string `json:"guid"`
+	Username string `json:"username"`
+}
+
+// listRecipients get a list of recipient emails from space users
+func listRecipients(
+	userGUIDs map[string]bool,
+	spaceUsers []*resource.User,
+) (addresses []string, err error) {
+	addresses = []string{}
+	for _, user := range spaceUsers {
+		if _, ok := userGUIDs[user.GUID]; !ok {
+			continue
+		}
+
+		if _, err := mail.ParseAddress(user.Username); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, user.Username)
+	}
+	return addresses, nil
+}
+
+func listSpaceDevsAndManagers(
+	userGUIDs map[string]bool,
+	spaceRoles []*resource.Role,
+	spaceUsers []*resource.User,
+) (developers []spaceUser, managers []spaceUser) {
+	developers = []spaceUser{}
+	managers = []spaceUser{}
+	var usernamesMap map[string]string
+
+	for _, role := range spaceRoles {
+		roleUserGUID := role.Relationships.User.Data.GUID
+		if _, ok := userGUIDs[roleUserGUID]; !ok {
+			continue
+		}
+
+		var username string
+
+		username = usernamesMap[roleUserGUID]
+		if username == "" {
+			if usernamesMap == nil {
+				usernamesMap = make(map[string]string)
+			}
+			for _, spaceUser := range spaceUsers {
+				if spaceUser.GUID == roleUserGUID {
+					usernamesMap[roleUserGUID] = spaceUser.Username
+					username = usernamesMap[roleUserGUID]
+				}
+			}
+		}
+
+		if username == "" {
+			logFields{}.printf("Could not find a username for user GUID %s in role %s", roleUserGUID, role.Type)
+			continue
+		}
+
+		if role.Type == resource.SpaceRoleDeveloper.String() {
+			developers = append(developers, spaceUser{
+				GUID:     roleUserGUID,
+				Username: username,
+			})
+		} else if role.Type == resource.SpaceRoleManager.String() {
+			managers = append(managers, spaceUser{
+				GUID:     roleUserGUID,
+				Username: username,
+			})
+		}
+	}
+	return
+}
+
+func recreateSpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	options Config,
+	organization *resource.Organization,
+	details SpaceDetails,
+	report *Report,
+) (*resource.Space, *resource.SpaceQuota, error) {
+	spaceRequest := &resource.SpaceCreate{
+		Name:          details.Space.Name,
+		Relationships: details.Space.Relationships,
+	}
+
+	if spaceRequest.Relationships.Quota != nil {
+		spaceRequest.Relationships.Quota = nil
+	}
+	if options.EmptySpaceDeleteDays > 0 {
+		// so the space can be deleted if it is never used again
+		spaceRequest.Metadata = resource.NewMetadata()
+		spaceRequest.Metadata.SetAnnotation("", annotationRecreatedAt, time.Now().UTC().Format("2006-01-02"))
+	}
+
+	spaceQuota, err := findSandboxQuota(ctx, cfClient, options, organization, report)
+	if err != nil {
+		return nil, nil, fmt.Errorf(
+			"error finding quota %s for space %s in org %s: %w",
+			options.SandboxQuotaName,
+			details.Space.Name,
+			organization.Name,
+			err,
+		)
+	}
+
+	space, err := createSpaceWithRetry(ctx, cfClient, options, organization, spaceRequest)
+	if isNameTakenError(err) {
+		taken, findErr := findSpaceByName(ctx, cfClient, organization, details.Space.Name)
+		if findErr != nil {
+			return nil, nil, fmt.Errorf("error creating space %s in org %s: %w (error finding the space that took its name: %s)", details.Space.Name, organization.Name, err, findErr)
+		}
+		logFields{Org: organization.Name, Space: details.Space.Name}.printf("a space named %s was created in org %s before the purge could recreate it; adopting it (%s)", details.Space.Name, organization.Name, taken.GUID)
+		report.SpacesAdopted = append(report.SpacesAdopted, organization.Name+"/"+details.Space.Name)
+		space, err = taken, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating space %s in org %s: %w", details.Space.Name, organization.Name, err)
+	}
+	if spaceQuota == nil {
+		return space, nil, nil
+	}
+	_, err = cfClient.SpaceQuotas.Apply(ctx, spaceQuota.GUID, []string{space.GUID})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error applying space quota %s to space %s: %w", options.SandboxQuotaName, details.Space.Name, err)
+	}
+	return space, spaceQuota, nil
+}
+
+// recreateSpaceDevsAndManagers creates a space's developer and manager roles
+// a few at a time; the roles don't depend on each other, so a large shared
+// sandbox's roles don't have to be created one by one
+func recreateSpaceDevsAndManagers(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	spaceGUID string,
+	developers []spaceUser,
+	managers []spaceUser,
+) error {
+	var tasks []func(context.Context) error
+	createRole := func(user spaceUser, roleType resource.SpaceRoleType) func(context.Context) error {
+		return func(ctx context.Context) error {
+			_, err := cfClient.Roles.CreateSpaceRole(ctx, spaceGUID, user.GUID, roleType)
+			// an adopted space may already give its creator the role
+			if isRoleExistsError(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	for _, developer := range developers {
+		tasks = append(tasks, createRole(developer, resource.SpaceRoleDeveloper))
+	}
+	for _, manager := range managers {
+		tasks = append(tasks, createRole(manager, resource.SpaceRoleManager))
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+	return runConcurrently(ctx, cfRequestConcurrency, tasks...)
+}
+
+// spaceCleanup counts the resources removed or stopped by the purge fallback
+// for a space
+type spaceCleanup struct {
+	AppsDeleted     int
+	DropletsDeleted int
+	TasksCanceled   int
+	AppsStopped     int
+	// InstancesForcePurged were purged from CF after their deletes failed
+	InstancesForcePurged int
+}
+
+// purgeSpace deletes a space; if the delete fails, it cancels active tasks and
+// deletes all droplets and applications within the space, or with quarantine
+// set, stops its apps and labels it purge-blocked instead
+func purgeSpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+	quarantine bool,
+) (string, spaceCleanup, error) {
+	jobGUID, spaceErr := cfClient.Spaces.Delete(ctx, space.GUID)
+	if isNotFoundError(spaceErr) {
+		return "", spaceCleanup{}, deletedDuringRun("space " + space.Name)
+	}
+	if spaceErr != nil && quarantine {
+		cleanup, err := quarantineSpace(ctx, cfClient, space)
+		if err != nil {
+			return "", cleanup, fmt.Errorf("error quarantining space %s after its delete failed (%s): %w", space.Name, spaceErr, err)
+		}
+		return "", cleanup, &spaceQuarantinedError{space: space.Name, err: spaceErr}
+	}
+	if spaceErr != nil {
+		cleanup, err := cleanupSpaceResources(ctx, cfClient, space)
+		if err != nil {
+			return "", cleanup, err
+		}
+		return "", cleanup, spaceErr
+	}
+	return jobGUID, spaceCleanup{}, spaceErr
+}
+
+// cleanupSpaceResources cancels active tasks, then deletes droplets and
+// applications in a space; resources that disappear in the meantime are
+// skipped
+func cleanupSpaceResources(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+) (spaceCleanup, error) {
+	var cleanup spaceCleanup
+
+	taskListOptions := client.NewTaskListOptions()
+	taskListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	taskListOptions.States.EqualTo("PENDING", "RUNNING")
+	tasks, err := cfClient.Tasks.ListAll(ctx, taskListOptions)
+	if err != nil {
+		return cleanup, err
+	}
+	for _, task := range tasks {
+		if _, err := cfClient.Tasks.Cancel(ctx, task.GUID); err != nil {
+			if isNotFoundError(err) {
+				continue
+			}
+			return cleanup, err
+		}
+		cleanup.TasksCanceled++
+	}
+
+	dropletListOptions := client.NewDropletListOptions()
+	dropletListOptions.SpaceGUIDs.EqualTo(space.GUID)
+	droplets, err := cfClient.Droplets.ListAll(ctx, dropletListOptions)
+	if err != nil {
+		return cleanup, err
+	}
+	for _, droplet := range droplets {
+		if _, err := cfClient.Droplets.Delete(ctx, droplet.GUID); err != nil {
+			if isNotFoundError(err) {
+				continue
+			}
+			return cleanup, err
+		}
+		cleanup.DropletsDeleted++
+	}
+
+	apps, err := cfClient.Applications.ListAll(ctx, &client.AppListOptions{
+		SpaceGUIDs: client.Filter{
+			Values: []string{space.GUID},
+		},
+	})
+	if err != nil {
+		return cleanup, err
+	}
+	for _, app := range apps {
+		_, err := cfClient.Applications.Delete(ctx, app.GUID)
+		if err != nil {
+			if isNotFoundError(err) {
+				continue
+			}
+			return cleanup, err
+		}
+		cleanup.AppsDeleted++
+	}
+	return cleanup, nil
+}
+
+// listSandboxOrgs lists all sandbox organizations
+func listSandboxOrgs(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	prefix string,
+) ([]*resource.Organization, error) {
+	sandboxes := []*resource.Organization{}
+
+	orgs, err := cfClient.Organizations.ListAll(ctx, nil)
+	if err != nil {
+		return sandboxes, err
+	}
+
+	for _, org := range orgs {
+		if strings.HasPrefix(org.Name, prefix) {
+			sandboxes = append(sandboxes, org)
+		}
+	}
+	sortOrgs(sandboxes)
+
+	return sandboxes, nil
+}
+
+// sortOrgs orders orgs by name, so runs against the same data process them
+// in the same order
+func sortOrgs(orgs []*resource.Organization) {
+	sort.SliceStable(orgs, func(i, j int) bool {
+		if orgs[i].Name != orgs[j].Name {
+			return orgs[i].Name < orgs[j].Name
+		}
+		return orgs[i].GUID < orgs[j].GUID
+	})
+}
+
+// SpaceDetails describes a space and its first resource creation time
+type SpaceDetails struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Space     *resource.Space `json:"space"`
+}
+
+// listSpaceFirstResources gets the first resource timestamp of every space,
+// or with ageBy "updated" its latest resource update, truncated to the day
+// and moved up to timeStartsAt if earlier; spaces without resources have a
+// zero timestamp
+func listSpaceFirstResources(
+	inventory *collect.Inventory,
+	ageBy string,
+	timeStartsAt time.Time,
+) []SpaceDetails {
+	spaceAge := (*collect.SpaceInventory).FirstResource
+	if ageBy == ageByUpdated {
+		spaceAge = (*collect.SpaceInventory).LastUpdate
+	}
+
+	details := make([]SpaceDetails, 0, len(inventory.Spaces))
+	for _, space := range inventory.Spaces {
+		firstResource := spaceAge(space)
+		if !firstResource.IsZero() {
+			if timeStartsAt.After(firstResource) {
+				firstResource = timeStartsAt
+			}
+			firstResource = firstResource.Truncate(24 * time.Hour)
+		}
+		details = append(details, SpaceDetails{firstResource, space.Space})
+	}
+	return details
+}
+
+// purgeCutoff is when a space with this timestamp is due to be purged: the
+// start, in UTC, of the first run day on which listPurgeSpaces purges it.
+// Runs truncate now to the UTC day, so the first run at or after the cutoff
+// purges the space; warnings quote this time so they can't disagree
+func purgeCutoff(timestamp time.Time, purgeDays int) time.Time {
+	due := timestamp.Add(24 * time.Duration(purgeDays) * time.Hour)
+	cutoff := due.Truncate(24 * time.Hour)
+	if cutoff.Before(due) {
+		cutoff = cutoff.Add(24 * time.Hour)
+	}
+	return cutoff.UTC()
+}
+
+// listPurgeSpaces identifies spaces that will be notified or purged; now is
+// the start of the run day
+func listPurgeSpaces(
+	inventory *collect.Inventory,
+	opts Config,
+	now time.Time,
+	timeStartsAt time.Time,
+) (
+	toNotify []SpaceDetails,
+	toPurge []SpaceDetails,
+) {
+	details := listSpaceFirstResources(inventory, opts.AgeBy, timeStartsAt)
+	for _, spaceDetails := range details {
+		if spaceDetails.Timestamp.IsZero() {
+			continue
+		}
+		// an extended purge is warned about as long before its new date as
+		// an unextended one
+		cutoff := spacePurgeCutoff(spaceDetails, opts.PurgeDays)
+		extension := cutoff.Sub(purgeCutoff(spaceDetails.Timestamp, opts.PurgeDays))
+		delta := int(now.Sub(spaceDetails.Timestamp.Add(extension)).Hours() / 24)
+		if !opts.DisablePurge && !now.Before(cutoff) {
+			toPurge = append(toPurge, spaceDetails)
+		} else if delta >= opts.NotifyDays {
+			toNotify = append(toNotify, spaceDetails)
+		}
+	}
+	return
+}
@@ -1,13 +1,17 @@
-package main
+package purge
 
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/18f/cg-sandbox/purge/collect"
 	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestListRecipients(t *testing.T) {
@@ -230,13 +234,14 @@ func TestListPurgeSpaces(t *testing.T) {
 		spaces           []*resource.Space
 		apps             []*resource.App
 		instances        []*resource.ServiceInstance
+		routes           []*resource.Route
+		keys             []*resource.ServiceCredentialBinding
 		now              time.Time
 		expectedToNotify []SpaceDetails
 		expectedToPurge  []SpaceDetails
 		notifyThreshold  int
 		purgeThreshold   int
-		opts             Options
-		expectedErr      string
+		opts             Config
 		timeStartsAt     time.Time
 	}{
 		"skips empty spaces": {
@@ -244,7 +249,7 @@ func TestListPurgeSpaces(t *testing.T) {
 				{GUID: "space-guid"},
 			},
 			now: now.Truncate(24 * time.Hour),
-			opts: Options{
+			opts: Config{
 				NotifyDays: 25,
 				PurgeDays:  30,
 			},
@@ -268,7 +273,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-15 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays: 25,
 				PurgeDays:  30,
 			},
@@ -292,7 +297,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-28 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays: 25,
 				PurgeDays:  30,
 			},
@@ -324,7 +329,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-25 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays: 25,
 				PurgeDays:  30,
 			},
@@ -338,6 +343,38 @@ func TestListPurgeSpaces(t *testing.T) {
 				},
 			},
 		},
+		"purges spaces containing only routes": {
+			spaces: []*resource.Space{
+				{GUID: "space-guid"},
+			},
+			now: now.Truncate(24 * time.Hour),
+			routes: []*resource.Route{
+				{
+					GUID: "route-guid",
+					Relationships: resource.RouteRelationships{
+						Space: resource.ToOneRelationship{
+							Data: &resource.Relationship{
+								GUID: "space-guid",
+							},
+						},
+					},
+					CreatedAt: now.Add(-31 * 24 * time.Hour),
+				},
+			},
+			opts: Config{
+				NotifyDays: 25,
+				PurgeDays:  30,
+			},
+			timeStartsAt: time.Time{},
+			expectedToPurge: []SpaceDetails{
+				{
+					Timestamp: now.Add(-31 * 24 * time.Hour).Truncate(24 * time.Hour),
+					Space: &resource.Space{
+						GUID: "space-guid",
+					},
+				},
+			},
+		},
 		"purges on the purge threshold": {
 			spaces: []*resource.Space{
 				{GUID: "space-guid"},
@@ -356,7 +393,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-30 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays: 25,
 				PurgeDays:  30,
 			},
@@ -388,7 +425,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-31 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays: 25,
 				PurgeDays:  30,
 			},
@@ -420,7 +457,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-31 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays: 25,
 				PurgeDays:  30,
 			},
@@ -452,7 +489,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-31 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays: 25,
 				PurgeDays:  30,
 			},
@@ -476,7 +513,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-31 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays:   25,
 				PurgeDays:    30,
 				DisablePurge: true,
@@ -509,7 +546,7 @@ func TestListPurgeSpaces(t *testing.T) {
 					CreatedAt: now.Add(-26 * 24 * time.Hour),
 				},
 			},
-			opts: Options{
+			opts: Config{
 				NotifyDays:   30,
 				PurgeDays:    25,
 				DisablePurge: true,
@@ -519,17 +556,12 @@ func TestListPurgeSpaces(t *testing.T) {
 	}
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			toNotify, toPurge, err := listPurgeSpaces(
-				test.spaces,
-				test.apps,
-				test.instances,
+			toNotify, toPurge := listPurgeSpaces(
+				collect.New(&resource.Organization{}, collect.Resources{Spaces: test.spaces, Apps: test.apps, ServiceInstances: test.instances, Routes: test.routes, ServiceKeys: test.keys}),
 				test.opts,
 				test.now,
 				test.timeStartsAt,
 			)
-			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && test.expectedErr != err.Error()) {
-				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
-			}
 			if diff := cmp.Diff(test.expectedToNotify, toNotify); diff != "" {
 				t.Errorf("ListPurgeSpaces() mismatch toNotify (-want +got):\n%s", diff)
 			}
@@ -540,113 +572,128 @@ func TestListPurgeSpaces(t *testing.T) {
 	}
 }
 
-func TestGetFirstResource(t *testing.T) {
-	now := time.Now()
+func TestPurgeCutoff(t *testing.T) {
+	eastern := time.FixedZone("EST", -5*60*60)
 	testCases := map[string]struct {
-		space                 *resource.Space
-		apps                  []*resource.App
-		instances             []*resource.ServiceInstance
-		expectedFirstResource time.Time
-		expectedErr           string
+		createdAt      time.Time
+		timeStartsAt   time.Time
+		expectedCutoff time.Time
 	}{
-		"skips empty spaces": {
-			space: &resource.Space{
-				GUID: "space-guid",
-			},
+		"first resource": {
+			createdAt:      time.Date(2024, 1, 1, 15, 4, 5, 0, time.UTC),
+			expectedCutoff: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
 		},
-		"returns the timestamp of the earliest app": {
-			space: &resource.Space{
-				GUID: "space-guid",
-			},
-			apps: []*resource.App{
-				{
-					GUID: "app-guid",
-					Relationships: resource.SpaceRelationship{
-						Space: resource.ToOneRelationship{
-							Data: &resource.Relationship{
-								GUID: "space-guid",
-							},
-						},
-					},
-					CreatedAt: now.Add(-10 * 24 * time.Hour),
-				},
-			},
-			instances: []*resource.ServiceInstance{
-				{
-					GUID: "instance-guid",
-					Relationships: resource.ServiceInstanceRelationships{
-						Space: &resource.ToOneRelationship{
-							Data: &resource.Relationship{
-								GUID: "space-guid",
-							},
-						},
-					},
-					CreatedAt: now.Add(-5 * 24 * time.Hour),
-				},
-			},
-			expectedFirstResource: now.Add(-10 * 24 * time.Hour),
-		},
-		"returns the timestamp of the earliest instance": {
-			space: &resource.Space{
-				GUID: "space-guid",
-			},
-			apps: []*resource.App{
-				{
-					GUID: "app-guid",
-					Relationships: resource.SpaceRelationship{
-						Space: resource.ToOneRelationship{
-							Data: &resource.Relationship{
-								GUID: "space-guid",
-							},
-						},
-					},
-					CreatedAt: now.Add(-5 * 24 * time.Hour),
-				},
-			},
-			instances: []*resource.ServiceInstance{
-				{
-					GUID: "instance-guid",
-					Relationships: resource.ServiceInstanceRelationships{
-						Space: &resource.ToOneRelationship{
-							Data: &resource.Relationship{
-								GUID: "space-guid",
-							},
-						},
-					},
-					CreatedAt: now.Add(-10 * 24 * time.Hour),
-				},
-			},
-			expectedFirstResource: now.Add(-10 * 24 * time.Hour),
+		"time starts at in another zone": {
+			createdAt:      time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC),
+			timeStartsAt:   time.Date(2024, 1, 1, 21, 0, 0, 0, eastern),
+			expectedCutoff: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
 		},
 	}
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			firstResource, err := letFirstResource(
-				test.space,
-				test.apps,
-				test.instances,
-			)
-			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && test.expectedErr != err.Error()) {
-				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
+			opts := Config{NotifyDays: 25, PurgeDays: 30, TemplateDir: "../templates"}
+			spaces := []*resource.Space{{GUID: "space-guid", Name: "space"}}
+			apps := []*resource.App{appInSpace("app-guid", "space-guid", test.createdAt)}
+			purgedOn := func(now time.Time) []SpaceDetails {
+				_, toPurge := listPurgeSpaces(collect.New(&resource.Organization{}, collect.Resources{Spaces: spaces, Apps: apps}), opts, now, test.timeStartsAt)
+				return toPurge
+			}
+
+			// the cutoff is the first run day the space is purged on
+			if toPurge := purgedOn(test.expectedCutoff.Add(-24 * time.Hour)); len(toPurge) != 0 {
+				t.Fatalf("expected no purge the day before the cutoff, got %+v", toPurge)
+			}
+			toPurge := purgedOn(test.expectedCutoff)
+			if len(toPurge) != 1 {
+				t.Fatalf("expected a purge on the cutoff, got %+v", toPurge)
+			}
+			cutoff := purgeCutoff(toPurge[0].Timestamp, opts.PurgeDays)
+			if !cutoff.Equal(test.expectedCutoff) || cutoff.Location() != time.UTC {
+				t.Errorf("expected cutoff %s, got %s", test.expectedCutoff, cutoff)
 			}
-			if !cmp.Equal(test.expectedFirstResource, firstResource) {
-				t.Errorf("GetFirstResource() expected: %s, got: %s", test.expectedFirstResource, firstResource)
+
+			// the warning quotes the same cutoff
+			tmpl, err := parseMailTemplate(opts.TemplateDir, notifyTemplateName)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			org := &resource.Organization{Name: "sandbox-org"}
+			body, err := renderTemplate(tmpl, notifyTemplateData(opts, org, toPurge[0]))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			expected := "On " + test.expectedCutoff.Format("Jan 02, 2006") + ", in the first purge run after 00:00 UTC,"
+			if !strings.Contains(body, expected) {
+				t.Errorf("expected the warning to say %q, got:\n%s", expected, body)
 			}
 		})
 	}
 }
 
+func TestListSandboxOrgsSorted(t *testing.T) {
+	cfClient := &cfResourceClient{Organizations: &mockOrganizations{orgs: []*resource.Organization{
+		{GUID: "org-3", Name: "sandbox-gsa"},
+		{GUID: "org-1", Name: "production"},
+		{GUID: "org-2", Name: "sandbox-epa"},
+		{GUID: "org-4", Name: "sandbox-doi"},
+	}}}
+	orgs, err := listSandboxOrgs(context.Background(), cfClient, "sandbox-")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var names []string
+	for _, org := range orgs {
+		names = append(names, org.Name)
+	}
+	if diff := cmp.Diff([]string{"sandbox-doi", "sandbox-epa", "sandbox-gsa"}, names); diff != "" {
+		t.Errorf("listSandboxOrgs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRecreateSpaceDevsAndManagers(t *testing.T) {
+	var developers []spaceUser
+	var expected []spaceCreatedRole
+	for i := 0; i < 10; i++ {
+		guid := fmt.Sprintf("user-%02d", i)
+		developers = append(developers, spaceUser{GUID: guid})
+		expected = append(expected, spaceCreatedRole{SpaceGUID: "space-1", UserGUID: guid, RoleType: resource.SpaceRoleDeveloper})
+	}
+	managers := []spaceUser{{GUID: "user-00"}}
+	expected = append(expected, spaceCreatedRole{SpaceGUID: "space-1", UserGUID: "user-00", RoleType: resource.SpaceRoleManager})
+	sortRoles := cmpopts.SortSlices(func(a spaceCreatedRole, b spaceCreatedRole) bool {
+		return a.UserGUID+a.RoleType.String() < b.UserGUID+b.RoleType.String()
+	})
+
+	roles := &mockRoles{}
+	if err := recreateSpaceDevsAndManagers(context.Background(), &cfResourceClient{Roles: roles}, "space-1", developers, managers); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(expected, roles.createdSpaceRoles, sortRoles); diff != "" {
+		t.Errorf("created roles mismatch (-want +got):\n%s", diff)
+	}
+
+	failing := &mockRoles{createErr: errors.New("502 Bad Gateway")}
+	err := recreateSpaceDevsAndManagers(context.Background(), &cfResourceClient{Roles: failing}, "space-1", developers, managers)
+	if err == nil || err.Error() != "502 Bad Gateway" {
+		t.Errorf("expected the role error, got: %v", err)
+	}
+}
+
 func TestPurgeSpace(t *testing.T) {
 	deleteSpaceErr := errors.New("delete space error")
 	listAppsErr := errors.New("error listing applications")
 	deleteAppErr := errors.New("delete app error")
+	cancelTaskErr := errors.New("cancel task error")
 
 	testCases := map[string]struct {
 		cfClient              *cfResourceClient
 		space                 *resource.Space
+		quarantine            bool
 		expectedErr           error
 		expectedDeleteJobGUID string
 		expectDeleteCallCount int
+		expectedCleanup       spaceCleanup
+		expectLabeled         bool
 	}{
 		"success": {
 			cfClient: &cfResourceClient{
@@ -654,6 +701,8 @@ func TestPurgeSpace(t *testing.T) {
 					deleteJobGUID: "delete-1",
 				},
 				Applications: &mockApplications{},
+				Droplets:     &mockDroplets{},
+				Tasks:        &mockTasks{},
 			},
 			space: &resource.Space{
 				GUID: "space-1",
@@ -672,12 +721,28 @@ func TestPurgeSpace(t *testing.T) {
 						},
 					},
 				},
+				Droplets: &mockDroplets{
+					droplets: []*resource.Droplet{
+						{GUID: "droplet-1"},
+						{GUID: "droplet-2"},
+					},
+				},
+				Tasks: &mockTasks{
+					tasks: []*resource.Task{
+						{GUID: "task-1"},
+					},
+				},
 			},
 			space: &resource.Space{
 				GUID: "space-1",
 			},
 			expectedErr:           deleteSpaceErr,
 			expectDeleteCallCount: 1,
+			expectedCleanup: spaceCleanup{
+				AppsDeleted:     1,
+				DropletsDeleted: 2,
+				TasksCanceled:   1,
+			},
 		},
 		"error listing applications": {
 			cfClient: &cfResourceClient{
@@ -687,6 +752,8 @@ func TestPurgeSpace(t *testing.T) {
 				Applications: &mockApplications{
 					listAppsErr: listAppsErr,
 				},
+				Droplets: &mockDroplets{},
+				Tasks:    &mockTasks{},
 			},
 			space: &resource.Space{
 				GUID: "space-1",
@@ -706,6 +773,8 @@ func TestPurgeSpace(t *testing.T) {
 					},
 					deleteErr: deleteAppErr,
 				},
+				Droplets: &mockDroplets{},
+				Tasks:    &mockTasks{},
 			},
 			space: &resource.Space{
 				GUID: "space-1",
@@ -713,14 +782,79 @@ func TestPurgeSpace(t *testing.T) {
 			expectDeleteCallCount: 1,
 			expectedErr:           deleteAppErr,
 		},
+		"error canceling tasks": {
+			cfClient: &cfResourceClient{
+				Spaces: &mockSpaces{
+					deleteErr: deleteSpaceErr,
+				},
+				Applications: &mockApplications{
+					apps: []*resource.App{
+						{
+							GUID: "app-1",
+						},
+					},
+				},
+				Droplets: &mockDroplets{},
+				Tasks: &mockTasks{
+					tasks: []*resource.Task{
+						{GUID: "task-1"},
+					},
+					cancelErr: cancelTaskErr,
+				},
+			},
+			space: &resource.Space{
+				GUID: "space-1",
+			},
+			expectedErr: cancelTaskErr,
+		},
+		"quarantines instead of deleting apps": {
+			cfClient: &cfResourceClient{
+				Spaces: &mockSpaces{
+					deleteErr: deleteSpaceErr,
+				},
+				Applications: &mockApplications{
+					apps: []*resource.App{
+						{GUID: "app-1", State: "STARTED"},
+						{GUID: "app-2", State: "STOPPED"},
+					},
+				},
+				Droplets: &mockDroplets{
+					droplets: []*resource.Droplet{{GUID: "droplet-1"}},
+				},
+				Tasks: &mockTasks{},
+			},
+			space: &resource.Space{
+				GUID: "space-1",
+			},
+			quarantine:      true,
+			expectedErr:     deleteSpaceErr,
+			expectedCleanup: spaceCleanup{AppsStopped: 1},
+			expectLabeled:   true,
+		},
+		"does not quarantine when delete succeeds": {
+			cfClient: &cfResourceClient{
+				Spaces: &mockSpaces{
+					deleteJobGUID: "delete-1",
+				},
+				Applications: &mockApplications{},
+				Droplets:     &mockDroplets{},
+				Tasks:        &mockTasks{},
+			},
+			space: &resource.Space{
+				GUID: "space-1",
+			},
+			quarantine:            true,
+			expectedDeleteJobGUID: "delete-1",
+		},
 	}
 
 	for name, test := range testCases {
 		t.Run(name, func(t *testing.T) {
-			deleteJobGUID, err := purgeSpace(
+			deleteJobGUID, cleanup, err := purgeSpace(
 				context.Background(),
 				test.cfClient,
 				test.space,
+				test.quarantine,
 			)
 
 			if deleteJobGUID != test.expectedDeleteJobGUID {
@@ -733,9 +867,19 @@ func TestPurgeSpace(t *testing.T) {
 				}
 			}
 
+			if diff := cmp.Diff(test.expectedCleanup, cleanup); diff != "" {
+				t.Errorf("purgeSpace() cleanup mismatch (-want +got):\n%s", diff)
+			}
+
 			if !errors.Is(err, test.expectedErr) {
 				t.Fatalf("expected error: %s, got: %s", test.expectedErr, err)
 			}
+
+			spaces := test.cfClient.Spaces.(*mockSpaces)
+			labeled := len(spaces.updates) == 1 && isPurgeBlocked(&resource.Space{Metadata: spaces.updates[0].Update.Metadata})
+			if labeled != test.expectLabeled {
+				t.Fatalf("expected space labeled %s: %t, got: %t", labelPurgeBlocked, test.expectLabeled, labeled)
+			}
 		})
 	}
 }
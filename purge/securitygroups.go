@@ -0,0 +1,56 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+)
+
+// spaceSecurityGroup is an application security group bound to a recreated
+// space
+type spaceSecurityGroup struct {
+	GUID string `json:"guid"`
+	Name string `json:"name"`
+}
+
+// planSpaceSecurityGroups looks up the security groups SPACE_SECURITY_GROUPS
+// binds to recreated spaces, so a missing group fails the purge before the
+// space is deleted rather than after
+func planSpaceSecurityGroups(ctx context.Context, cfClient *cfResourceClient, opts Config) ([]spaceSecurityGroup, error) {
+	var groups []spaceSecurityGroup
+	for _, name := range opts.SpaceSecurityGroups {
+		securityGroupListOptions := client.NewSecurityGroupListOptions()
+		securityGroupListOptions.Names.EqualTo(name)
+		group, err := cfClient.SecurityGroups.Single(ctx, securityGroupListOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error finding security group %s: %w", name, err)
+		}
+		groups = append(groups, spaceSecurityGroup{GUID: group.GUID, Name: group.Name})
+	}
+	return groups, nil
+}
+
+// bindSpaceSecurityGroups binds security groups to a space for both running
+// and staging apps; a space's bindings are lost when it is deleted
+func bindSpaceSecurityGroups(ctx context.Context, cfClient *cfResourceClient, spaceGUID string, groups []spaceSecurityGroup) error {
+	for _, group := range groups {
+		if _, err := cfClient.SecurityGroups.BindRunningSecurityGroup(ctx, group.GUID, []string{spaceGUID}); err != nil {
+			return fmt.Errorf("error binding running security group %s: %w", group.Name, err)
+		}
+		if _, err := cfClient.SecurityGroups.BindStagingSecurityGroup(ctx, group.GUID, []string{spaceGUID}); err != nil {
+			return fmt.Errorf("error binding staging security group %s: %w", group.Name, err)
+		}
+	}
+	return nil
+}
+
+// formatSecurityGroups lists security groups by name for plans
+func formatSecurityGroups(groups []spaceSecurityGroup) string {
+	names := make([]string, len(groups))
+	for i, group := range groups {
+		names[i] = group.Name
+	}
+	return strings.Join(names, ", ")
+}
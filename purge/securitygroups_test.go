@@ -0,0 +1,91 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+type securityGroupBinding struct {
+	GUID      string
+	SpaceGUID string
+	Lifecycle string
+}
+
+type mockSecurityGroups struct {
+	groups   map[string]*resource.SecurityGroup
+	bindErr  error
+	bindings []securityGroupBinding
+}
+
+func (g *mockSecurityGroups) Single(ctx context.Context, opts *client.SecurityGroupListOptions) (*resource.SecurityGroup, error) {
+	group, ok := g.groups[opts.Names.Values[0]]
+	if !ok {
+		return nil, client.ErrNoResultsReturned
+	}
+	return group, nil
+}
+
+func (g *mockSecurityGroups) bind(guid string, spaceGUIDs []string, lifecycle string) ([]string, error) {
+	for _, spaceGUID := range spaceGUIDs {
+		g.bindings = append(g.bindings, securityGroupBinding{GUID: guid, SpaceGUID: spaceGUID, Lifecycle: lifecycle})
+	}
+	return spaceGUIDs, g.bindErr
+}
+
+func (g *mockSecurityGroups) BindRunningSecurityGroup(ctx context.Context, guid string, spaceGUIDs []string) ([]string, error) {
+	return g.bind(guid, spaceGUIDs, "running")
+}
+
+func (g *mockSecurityGroups) BindStagingSecurityGroup(ctx context.Context, guid string, spaceGUIDs []string) ([]string, error) {
+	return g.bind(guid, spaceGUIDs, "staging")
+}
+
+func TestPlanSpaceSecurityGroups(t *testing.T) {
+	cfClient := &cfResourceClient{SecurityGroups: &mockSecurityGroups{groups: map[string]*resource.SecurityGroup{
+		"public_networks": {GUID: "asg-1", Name: "public_networks"},
+		"dns":             {GUID: "asg-2", Name: "dns"},
+	}}}
+
+	groups, err := planSpaceSecurityGroups(context.Background(), cfClient, Config{SpaceSecurityGroups: []string{"public_networks", "dns"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []spaceSecurityGroup{{GUID: "asg-1", Name: "public_networks"}, {GUID: "asg-2", Name: "dns"}}
+	if diff := cmp.Diff(expected, groups); diff != "" {
+		t.Errorf("planSpaceSecurityGroups() mismatch (-want +got):\n%s", diff)
+	}
+	if got := formatSecurityGroups(groups); got != "public_networks, dns" {
+		t.Errorf("unexpected formatted groups %q", got)
+	}
+
+	_, err = planSpaceSecurityGroups(context.Background(), cfClient, Config{SpaceSecurityGroups: []string{"trusted_local_networks"}})
+	if err == nil || err.Error() != "error finding security group trusted_local_networks: expected 1 or more results, but got 0" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBindSpaceSecurityGroups(t *testing.T) {
+	groups := []spaceSecurityGroup{{GUID: "asg-1", Name: "public_networks"}}
+	securityGroups := &mockSecurityGroups{}
+	if err := bindSpaceSecurityGroups(context.Background(), &cfResourceClient{SecurityGroups: securityGroups}, "space-1", groups); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []securityGroupBinding{
+		{GUID: "asg-1", SpaceGUID: "space-1", Lifecycle: "running"},
+		{GUID: "asg-1", SpaceGUID: "space-1", Lifecycle: "staging"},
+	}
+	if diff := cmp.Diff(expected, securityGroups.bindings); diff != "" {
+		t.Errorf("bindings mismatch (-want +got):\n%s", diff)
+	}
+
+	failing := &mockSecurityGroups{bindErr: errors.New("forbidden")}
+	err := bindSpaceSecurityGroups(context.Background(), &cfResourceClient{SecurityGroups: failing}, "space-1", groups)
+	if err == nil || err.Error() != "error binding running security group public_networks: forbidden" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
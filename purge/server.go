@@ -0,0 +1,357 @@
+package purge
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// ServeConfig describes configuration for the on-demand purge server
+type ServeConfig struct {
+	Config
+	ListenAddress string `env:"LISTEN_ADDRESS, default=:8080"`
+	PurgeAPIToken string `env:"PURGE_API_TOKEN, required"`
+}
+
+// Validate checks the server's settings; an empty PURGE_API_TOKEN would let
+// a request without credentials through, so it is rejected
+func (c ServeConfig) Validate() error {
+	if c.PurgeAPIToken == "" {
+		return errors.New("PURGE_API_TOKEN must not be empty")
+	}
+	return c.Config.Validate()
+}
+
+// PurgeRequest asks the server to purge and recreate a single space
+type PurgeRequest struct {
+	Org   string `json:"org"`
+	Space string `json:"space"`
+}
+
+// PurgeResponse describes the outcome of an on-demand purge
+type PurgeResponse struct {
+	Report Report `json:"report"`
+	Error  string `json:"error,omitempty"`
+}
+
+// errSpaceNotFound is returned when a requested org or space doesn't exist
+var errSpaceNotFound = errors.New("space not found")
+
+// purgeThrottledError is returned when PURGES_PER_HOUR leaves no room for
+// another purge yet
+type purgeThrottledError struct {
+	retryAfter time.Duration
+}
+
+func (e *purgeThrottledError) Error() string {
+	return fmt.Sprintf("purge rate limit reached; retry in %s", e.retryAfter.Round(time.Second))
+}
+
+// purgeServer handles authenticated on-demand purge requests and purge
+// warning acknowledgements; requests run one at a time so they can't race
+// with each other
+type purgeServer struct {
+	opts       Config
+	token      string
+	cfClient   *cfResourceClient
+	mailSender mailer
+	store      stateStore
+	// throttle spaces purges across requests as PURGES_PER_HOUR does
+	// within a run
+	throttle *purgeThrottle
+
+	mu sync.Mutex
+}
+
+// Serve listens for on-demand purge requests until ctx is canceled
+func Serve(ctx context.Context, cfg ServeConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("error parsing options: %w", err)
+	}
+	if err := cfg.usePolicyFile(ctx); err != nil {
+		return err
+	}
+	cleanupTemplates, err := cfg.useServiceTemplates()
+	if err != nil {
+		return err
+	}
+	defer cleanupTemplates()
+	if err := lintPolicyTemplates(cfg.Config); err != nil {
+		return err
+	}
+
+	cfClient, err := newCFClient(cfg.CFOptions, nil)
+	if err != nil {
+		return fmt.Errorf("error creating client: %w", err)
+	}
+	// checked again before each purge, but a misconfigured server shouldn't
+	// start at all
+	if err := guardFoundation(ctx, cfClient, cfg.FoundationOptions); err != nil {
+		return err
+	}
+	transport, err := newMailTransport(cfg.Config)
+	if err != nil {
+		return err
+	}
+	mailSender, err := newNotifier(cfg.ChannelOptions, cfg.S3Options, transport)
+	if err != nil {
+		return fmt.Errorf("error configuring notification channels: %w", err)
+	}
+	mailSender = newOverrideRecipientMailer(mailSender, cfg.MailOverrideRecipient)
+	// MAX_RUNTIME bounds a run; the server runs until it is stopped
+	throttleOpts := cfg.Config
+	throttleOpts.MaxRuntime = 0
+
+	server := &http.Server{
+		Addr: cfg.ListenAddress,
+		Handler: &purgeServer{
+			opts:       cfg.Config,
+			token:      cfg.PurgeAPIToken,
+			cfClient:   cfClient,
+			mailSender: mailSender,
+			store:      newStateStore(cfg.Config),
+			throttle:   newPurgeThrottle(throttleOpts, time.Now()),
+		},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		logFields{}.printf("listening for purge requests on %s", cfg.ListenAddress)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// ServeHTTP routes POST /purge requests and acknowledgement links
+func (s *purgeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/purge":
+		s.servePurge(w, r)
+	case r.URL.Path == "/ack" && s.opts.AckOptions.enabled():
+		s.serveAck(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// servePurge accepts POST /purge requests with a bearer token
+func (s *purgeServer) servePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" || s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req PurgeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if req.Org == "" || req.Space == "" {
+		http.Error(w, "org and space are required", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(req.Org, s.opts.OrgPrefix) {
+		http.Error(w, fmt.Sprintf("org %s is not a sandbox org", req.Org), http.StatusForbidden)
+		return
+	}
+
+	logFields{Org: req.Org, Space: req.Space, Action: planActionPurge}.printf("on-demand purge requested for space %s in org %s", req.Space, req.Org)
+	report, err := s.purge(r.Context(), req)
+	resp := PurgeResponse{Report: report}
+	status := http.StatusOK
+	if err != nil {
+		logFields{Org: req.Org, Space: req.Space, Action: planActionPurge, Err: err}.printf("on-demand purge of space %s in org %s failed: %s", req.Space, req.Org, err)
+		resp.Error = err.Error()
+		status = http.StatusInternalServerError
+		var throttled *purgeThrottledError
+		switch {
+		case errors.Is(err, errSpaceNotFound) || errors.Is(err, errDeletedDuringRun):
+			status = http.StatusNotFound
+		case errors.Is(err, errHalted):
+			status = http.StatusServiceUnavailable
+		case errors.As(err, &throttled):
+			status = http.StatusTooManyRequests
+			w.Header().Set("Retry-After", strconv.Itoa(int(throttled.retryAfter.Round(time.Second).Seconds())))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logFields{Err: err}.printf("error writing purge response: %s", err)
+	}
+}
+
+var ackPage = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html>
+<head><title>cloud.gov sandbox</title></head>
+<body>
+{{ if .Recorded }}<p>Thanks! We've recorded that you've seen the purge warning for your sandbox.</p>
+{{ else }}<p>Confirm that you've seen the purge warning for your cloud.gov sandbox.</p>
+<form method="post"><button type="submit">I understand my sandbox will be purged</button></form>
+{{ end }}</body>
+</html>
+`))
+
+// serveAck shows a confirmation page for a signed acknowledgement link and
+// records the acknowledgement when it is submitted; link scanners that
+// prefetch the GET don't acknowledge anything
+func (s *purgeServer) serveAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	spaceGUID, err := s.opts.AckOptions.verifyAck(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	recorded := false
+	if r.Method == http.MethodPost {
+		if err := s.acknowledge(spaceGUID, time.Now()); err != nil {
+			logFields{Err: err}.printf("error recording acknowledgement for space %s: %s", spaceGUID, err)
+			http.Error(w, "error recording acknowledgement", http.StatusInternalServerError)
+			return
+		}
+		logFields{}.printf("purge warning acknowledged for space %s", spaceGUID)
+		recorded = true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := ackPage.Execute(w, struct{ Recorded bool }{recorded}); err != nil {
+		logFields{Err: err}.printf("error writing acknowledgement page: %s", err)
+	}
+}
+
+// acknowledge records an acknowledgement in the state store
+func (s *purgeServer) acknowledge(spaceGUID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.store.load()
+	if err != nil {
+		return err
+	}
+	state.recordAcknowledged(spaceGUID, at)
+	return s.store.save(state)
+}
+
+// purge purges and recreates the requested space with the same pipeline and
+// guards as a scheduled run; it refuses while the kill switch is engaged
+func (s *purgeServer) purge(ctx context.Context, req PurgeRequest) (Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := &Report{StartedAt: time.Now(), DryRun: s.opts.DryRun, Mode: runModeLive}
+	if s.opts.DryRun {
+		report.Mode = runModeDryRun
+	}
+	defer func() { report.FinishedAt = time.Now() }()
+
+	opts, err := preflight(ctx, s.cfClient, s.opts, report)
+	if err != nil {
+		return *report, err
+	}
+	if report.Halted != "" {
+		return *report, fmt.Errorf("%w: %s", errHalted, report.Halted)
+	}
+	org, details, err := findSpaceDetails(ctx, s.cfClient, opts, req.Org, req.Space)
+	if err != nil {
+		return *report, err
+	}
+	userGUIDs, err := listUserGUIDs(ctx, s.cfClient)
+	if err != nil {
+		return *report, fmt.Errorf("error getting users: %w", err)
+	}
+
+	orgOpts := opts.forOrg(org.Name)
+	action, err := planPurge(ctx, s.cfClient, orgOpts, userGUIDs, nil, org, details)
+	if err != nil {
+		return *report, err
+	}
+	if !orgOpts.DryRun {
+		if delay := s.throttle.reserve(); delay > 0 {
+			return *report, &purgeThrottledError{retryAfter: delay}
+		}
+	}
+	deliveries := recordDeliveries(s.mailSender, orgOpts, action)
+	err = applyPurge(ctx, s.cfClient, orgOpts, action, deliveries, report)
+	report.recordMessages(deliveries.results(orgOpts.DryRun, err))
+	report.TemplateFallbacks = append(report.TemplateFallbacks, deliveries.fallbacks...)
+	report.recordAction(action, err)
+	if err != nil && !errors.Is(err, errDeletedDuringRun) {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	logFields{Org: req.Org, Space: req.Space, Action: planActionPurge}.printf("on-demand purge summary: %s", report.summary())
+	return *report, err
+}
+
+// findSpaceDetails looks up a space by org and space name and ages it the
+// way a scheduled run would, by AGE_BY from TIME_STARTS_AT and without the
+// service instances of system plans; a space without resources is found
+// with a zero timestamp
+func findSpaceDetails(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	orgName string,
+	spaceName string,
+) (*resource.Organization, SpaceDetails, error) {
+	orgs, err := listSandboxOrgs(ctx, cfClient, orgName)
+	if err != nil {
+		return nil, SpaceDetails{}, fmt.Errorf("error getting orgs: %w", err)
+	}
+	var org *resource.Organization
+	for _, candidate := range orgs {
+		if candidate.Name == orgName {
+			org = candidate
+		}
+	}
+	if org == nil {
+		return nil, SpaceDetails{}, fmt.Errorf("org %s: %w", orgName, errSpaceNotFound)
+	}
+	space, err := findSpaceByName(ctx, cfClient, org, spaceName)
+	if err != nil {
+		return nil, SpaceDetails{}, err
+	}
+
+	timeStartsAt, err := opts.timeStartsAt()
+	if err != nil {
+		return nil, SpaceDetails{}, err
+	}
+	systemPlans, err := listSystemPlans(ctx, cfClient, opts.SystemServiceOptions)
+	if err != nil {
+		return nil, SpaceDetails{}, err
+	}
+	inventory, err := collectSpaceInventory(ctx, cfClient, org, space)
+	if err != nil {
+		return nil, SpaceDetails{}, fmt.Errorf("error listing resources for space %s in org %s: %w", space.Name, org.Name, err)
+	}
+	details := listSpaceFirstResources(inventory.WithoutSystemInstances(systemPlans), opts.forOrg(org.Name).AgeBy, timeStartsAt)
+	return org, details[0], nil
+}
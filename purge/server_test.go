@@ -0,0 +1,353 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+type mockUsers struct {
+	users []*resource.User
+}
+
+func (u *mockUsers) ListAll(ctx context.Context, opts *client.UserListOptions) ([]*resource.User, error) {
+	return u.users, nil
+}
+
+func testPurgeServer() *purgeServer {
+	inSpace := resource.SpaceRelationship{
+		Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: "space-1"}},
+	}
+	return &purgeServer{
+		opts:  Config{OrgPrefix: "sandbox-", DryRun: true},
+		token: "secret",
+		cfClient: &cfResourceClient{
+			Organizations: &mockOrganizations{
+				orgs: []*resource.Organization{{GUID: "org-1", Name: "sandbox-foo"}},
+			},
+			Applications: &mockApplications{
+				apps: []*resource.App{{GUID: "app-1", Relationships: inSpace}},
+			},
+			ServiceInstances: &mockServiceInstances{},
+			Routes:           &mockRoutes{},
+			Spaces: &mockRetrySpaces{
+				spaces: []*resource.Space{{GUID: "space-1", Name: "bar"}},
+			},
+			SpaceFeatures: &mockSpaceFeatures{},
+			Users: &mockUsers{
+				users: []*resource.User{{GUID: "user-1", Username: "foo@bar.gov"}},
+			},
+			Roles: &mockRoles{
+				spaceGUID: "space-1",
+				roles: []*resource.Role{{
+					Type: resource.SpaceRoleDeveloper.String(),
+					Relationships: resource.RoleSpaceUserOrganizationRelationships{
+						User: resource.ToOneRelationship{Data: &resource.Relationship{GUID: "user-1"}},
+					},
+				}},
+				users: []*resource.User{{GUID: "user-1", Username: "foo@bar.gov"}},
+			},
+		},
+		mailSender: &mockMailSender{},
+	}
+}
+
+func TestPurgeServer(t *testing.T) {
+	testCases := map[string]struct {
+		method         string
+		path           string
+		token          string
+		body           string
+		expectedStatus int
+		expectedError  string
+	}{
+		"unknown path": {
+			method:         http.MethodPost,
+			path:           "/other",
+			token:          "secret",
+			expectedStatus: http.StatusNotFound,
+		},
+		"wrong method": {
+			method:         http.MethodGet,
+			path:           "/purge",
+			token:          "secret",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		"bad token": {
+			method:         http.MethodPost,
+			path:           "/purge",
+			token:          "wrong",
+			body:           `{"org": "sandbox-foo", "space": "bar"}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"missing space": {
+			method:         http.MethodPost,
+			path:           "/purge",
+			token:          "secret",
+			body:           `{"org": "sandbox-foo"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		"non-sandbox org": {
+			method:         http.MethodPost,
+			path:           "/purge",
+			token:          "secret",
+			body:           `{"org": "prod", "space": "bar"}`,
+			expectedStatus: http.StatusForbidden,
+		},
+		"unknown space": {
+			method:         http.MethodPost,
+			path:           "/purge",
+			token:          "secret",
+			body:           `{"org": "sandbox-foo", "space": "baz"}`,
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "space baz in org sandbox-foo: space not found",
+		},
+		"purges space": {
+			method:         http.MethodPost,
+			path:           "/purge",
+			token:          "secret",
+			body:           `{"org": "sandbox-foo", "space": "bar"}`,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			req.Header.Set("Authorization", "Bearer "+test.token)
+			rec := httptest.NewRecorder()
+
+			testPurgeServer().ServeHTTP(rec, req)
+			if rec.Code != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Header().Get("Content-Type") != "application/json" {
+				return
+			}
+
+			var resp PurgeResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("error decoding response: %s", err)
+			}
+			if resp.Error != test.expectedError {
+				t.Errorf("expected error %q, got %q", test.expectedError, resp.Error)
+			}
+			if test.expectedStatus == http.StatusOK {
+				if len(resp.Report.Spaces) != 1 || resp.Report.Spaces[0].Space != "bar" {
+					t.Errorf("expected report for space bar, got %+v", resp.Report.Spaces)
+				}
+				if got := resp.Report.Spaces[0].Recipients; len(got) != 1 || got[0] != "foo@bar.gov" {
+					t.Errorf("expected recipient foo@bar.gov, got %v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeServerGuards(t *testing.T) {
+	halt := "true"
+	testCases := map[string]struct {
+		configure      func(s *purgeServer)
+		expectedStatus int
+		expectedError  string
+		retryAfter     string
+	}{
+		"kill switch engaged": {
+			configure: func(s *purgeServer) {
+				s.opts.DryRun = false
+				s.opts.KillSwitchOrg = "cloud-gov-control"
+				s.cfClient.Organizations.(*mockOrganizations).org = &resource.Organization{
+					Name:     "cloud-gov-control",
+					Metadata: &resource.Metadata{Labels: map[string]*string{labelPurgeHalt: &halt}},
+				}
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedError:  "skipped: kill switch engaged: org cloud-gov-control is labeled purge-halt",
+		},
+		"purge rate limit reached": {
+			configure: func(s *purgeServer) {
+				now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+				s.opts.DryRun = false
+				s.cfClient.Auth = &mockAuth{}
+				s.throttle = &purgeThrottle{
+					interval: 30 * time.Minute,
+					next:     now.Add(10 * time.Minute),
+					now:      func() time.Time { return now },
+				}
+			},
+			expectedStatus: http.StatusTooManyRequests,
+			expectedError:  "purge rate limit reached; retry in 10m0s",
+			retryAfter:     "600",
+		},
+		"wrong foundation": {
+			configure: func(s *purgeServer) {
+				root := &resource.Root{}
+				root.Links.Self.Href = "https://api.other.gov"
+				s.cfClient.Root = &mockRoot{root: root}
+				s.opts.ExpectAPI = "https://api.example.gov"
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedError:  "refusing to run against this foundation: API root is https://api.other.gov, not the expected https://api.example.gov",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := testPurgeServer()
+			test.configure(server)
+			req := httptest.NewRequest(http.MethodPost, "/purge", strings.NewReader(`{"org": "sandbox-foo", "space": "bar"}`))
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+
+			server.ServeHTTP(rec, req)
+			if rec.Code != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", test.expectedStatus, rec.Code, rec.Body.String())
+			}
+			var resp PurgeResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("error decoding response: %s", err)
+			}
+			if resp.Error != test.expectedError {
+				t.Errorf("expected error %q, got %q", test.expectedError, resp.Error)
+			}
+			if got := rec.Header().Get("Retry-After"); got != test.retryAfter {
+				t.Errorf("expected Retry-After %q, got %q", test.retryAfter, got)
+			}
+		})
+	}
+}
+
+func TestPurgeServerAuthorization(t *testing.T) {
+	testCases := map[string]struct {
+		token          string
+		authorization  string
+		expectedStatus int
+	}{
+		"bearer token": {
+			token:          "secret",
+			authorization:  "Bearer secret",
+			expectedStatus: http.StatusOK,
+		},
+		"no header": {
+			token:          "secret",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"token without bearer prefix": {
+			token:          "secret",
+			authorization:  "secret",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"empty bearer token": {
+			token:          "secret",
+			authorization:  "Bearer ",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"empty configured token": {
+			authorization:  "Bearer ",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		"empty configured token and no header": {
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server := testPurgeServer()
+			server.token = test.token
+			req := httptest.NewRequest(http.MethodPost, "/purge", strings.NewReader(`{"org": "sandbox-foo", "space": "bar"}`))
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			server.ServeHTTP(rec, req)
+			if rec.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", test.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeConfigValidate(t *testing.T) {
+	testCases := map[string]struct {
+		token       string
+		expectedErr string
+	}{
+		"token": {
+			token: "secret",
+		},
+		"empty token": {
+			expectedErr: "PURGE_API_TOKEN must not be empty",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := ServeConfig{PurgeAPIToken: test.token}.Validate()
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Errorf("expected error %q, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestFindSpaceDetails(t *testing.T) {
+	created := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 2, 20, 12, 0, 0, 0, time.UTC)
+	inSpace := resource.SpaceRelationship{
+		Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: "space-1"}},
+	}
+	testCases := map[string]struct {
+		apps              []*resource.App
+		opts              Config
+		expectedTimestamp time.Time
+	}{
+		"space without resources": {
+			expectedTimestamp: time.Time{},
+		},
+		"age by created": {
+			apps:              []*resource.App{{GUID: "app-1", CreatedAt: created, UpdatedAt: updated, Relationships: inSpace}},
+			expectedTimestamp: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC),
+		},
+		"age by updated": {
+			apps:              []*resource.App{{GUID: "app-1", CreatedAt: created, UpdatedAt: updated, Relationships: inSpace}},
+			opts:              Config{AgeBy: ageByUpdated},
+			expectedTimestamp: time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC),
+		},
+		"time starts at": {
+			apps:              []*resource.App{{GUID: "app-1", CreatedAt: created, UpdatedAt: updated, Relationships: inSpace}},
+			opts:              Config{TimeStartsAt: "2024-02-01T00:00:00Z"},
+			expectedTimestamp: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				Organizations: &mockOrganizations{
+					orgs: []*resource.Organization{{GUID: "org-1", Name: "sandbox-foo"}},
+				},
+				Applications:     &mockApplications{apps: test.apps},
+				ServiceInstances: &mockServiceInstances{},
+				Routes:           &mockRoutes{},
+				Spaces: &mockRetrySpaces{
+					spaces: []*resource.Space{{GUID: "space-1", Name: "bar"}},
+				},
+				Roles: &mockMemberRoles{},
+			}
+			org, details, err := findSpaceDetails(context.Background(), cfClient, test.opts, "sandbox-foo", "bar")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if org.Name != "sandbox-foo" || details.Space.GUID != "space-1" {
+				t.Errorf("expected space-1 in sandbox-foo, got %s in %s", details.Space.GUID, org.Name)
+			}
+			if !details.Timestamp.Equal(test.expectedTimestamp) {
+				t.Errorf("expected timestamp %s, got %s", test.expectedTimestamp, details.Timestamp)
+			}
+		})
+	}
+}
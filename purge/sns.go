@@ -0,0 +1,109 @@
+package purge
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// snsSubjectLimit is the longest subject SNS accepts
+const snsSubjectLimit = 100
+
+// snsNotifier publishes notifications to an SNS topic, leaving delivery to
+// the topic's subscribers
+type snsNotifier struct {
+	options    ChannelOptions
+	aws        S3Options
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// region returns the topic's region from its ARN, e.g.
+// arn:aws-us-gov:sns:us-gov-west-1:123456789012:sandbox-notifications
+func (s *snsNotifier) region() string {
+	if fields := strings.Split(s.options.NotifySNSTopicARN, ":"); len(fields) == 6 && fields[3] != "" {
+		return fields[3]
+	}
+	return s.aws.AWSRegion
+}
+
+// endpoint returns the SNS API URL for the topic's region unless a custom
+// endpoint is configured
+func (s *snsNotifier) endpoint() string {
+	if s.options.NotifySNSEndpoint != "" {
+		return s.options.NotifySNSEndpoint
+	}
+	return fmt.Sprintf("https://sns.%s.amazonaws.com/", s.region())
+}
+
+// sendMail publishes the notification to the topic as the same JSON payload
+// webhooks get; attachments aren't published, since SNS messages are
+// limited to 256 KB
+func (s *snsNotifier) sendMail(
+	ctx context.Context,
+	opts SMTPOptions,
+	sender string,
+	subject string,
+	body string,
+	thread mailThread,
+	recipients []string,
+	attachments ...mailAttachment,
+) error {
+	message, err := json.Marshal(notificationPayload(sender, subject, body, thread, recipients))
+	if err != nil {
+		return fmt.Errorf("error encoding notification: %w", err)
+	}
+	form := url.Values{
+		"Action":   {"Publish"},
+		"Version":  {"2010-03-31"},
+		"TopicArn": {s.options.NotifySNSTopicARN},
+		"Message":  {string(message)},
+	}
+	if subject := snsSubject(subject); subject != "" {
+		form.Set("Subject", subject)
+	}
+	payload := form.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint(), strings.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	awsOptions := s.aws
+	awsOptions.AWSRegion = s.region()
+	sum := sha256.Sum256([]byte(payload))
+	signV4(req, awsOptions, "sns", hex.EncodeToString(sum[:]), s.now())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error publishing to %s: %w", s.options.NotifySNSTopicARN, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("error publishing to %s: %s: %s", s.options.NotifySNSTopicARN, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// snsSubject makes a subject acceptable to SNS, which only takes printable
+// ASCII on a single line of up to 100 characters
+func snsSubject(subject string) string {
+	var b strings.Builder
+	for _, r := range subject {
+		if b.Len() >= snsSubjectLimit {
+			break
+		}
+		if r >= ' ' && r <= '~' {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,104 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSNSNotifier(t *testing.T) {
+	var forms []map[string]string
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		form := map[string]string{}
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		forms = append(forms, form)
+	}))
+	defer server.Close()
+
+	notifier := &snsNotifier{
+		options: ChannelOptions{
+			NotifySNSTopicARN: "arn:aws-us-gov:sns:us-gov-east-1:123456789012:sandbox",
+			NotifySNSEndpoint: server.URL,
+		},
+		aws:        S3Options{AWSRegion: "us-gov-west-1", AWSAccessKeyID: "AKID", AWSSecretAccessKey: "secret"},
+		httpClient: server.Client(),
+		now:        func() time.Time { return time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC) },
+	}
+	body := "<html><body>\n<p>Your sandbox &amp; apps</p>\n</body></html>"
+	thread := mailThread{MessageID: "<purge@example.gov>"}
+	attachment := mailAttachment{Name: "manifest.yml", Content: []byte("applications: []\n")}
+	if err := notifier.sendMail(context.Background(), SMTPOptions{}, "sender", "Purge warning – 5 days", body, thread, []string{"foo@agency.gov"}, attachment); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(forms) != 1 {
+		t.Fatalf("expected one publish, got %d", len(forms))
+	}
+	message := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(forms[0]["Message"]), &message); err != nil {
+		t.Fatal(err)
+	}
+	delete(forms[0], "Message")
+	expectedForm := map[string]string{
+		"Action":   "Publish",
+		"Version":  "2010-03-31",
+		"TopicArn": "arn:aws-us-gov:sns:us-gov-east-1:123456789012:sandbox",
+		"Subject":  "Purge warning  5 days",
+	}
+	if diff := cmp.Diff(expectedForm, forms[0]); diff != "" {
+		t.Errorf("sendMail() form mismatch (-want +got):\n%s", diff)
+	}
+	expectedMessage := map[string]interface{}{
+		"sender":     "sender",
+		"subject":    "Purge warning – 5 days",
+		"body":       body,
+		"text":       "Your sandbox & apps",
+		"recipients": []interface{}{"foo@agency.gov"},
+		"message_id": "<purge@example.gov>",
+	}
+	if diff := cmp.Diff(expectedMessage, message); diff != "" {
+		t.Errorf("sendMail() message mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(authorization, "Credential=AKID/20240131/us-gov-east-1/sns/aws4_request") {
+		t.Errorf("unexpected authorization header: %s", authorization)
+	}
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<ErrorResponse><Error><Code>AuthorizationError</Code></Error></ErrorResponse>", http.StatusForbidden)
+	})
+	err := notifier.sendMail(context.Background(), SMTPOptions{}, "sender", "Purge warning", body, thread, []string{"foo@agency.gov"})
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden: <ErrorResponse><Error><Code>AuthorizationError</Code>") {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestSNSSubject(t *testing.T) {
+	testCases := map[string]struct {
+		subject  string
+		expected string
+	}{
+		"ascii":          {subject: "Purge warning", expected: "Purge warning"},
+		"line breaks":    {subject: "Purge\nwarning", expected: "Purgewarning"},
+		"too long":       {subject: strings.Repeat("a", 120), expected: strings.Repeat("a", 100)},
+		"only non-ascii": {subject: "–", expected: ""},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := snsSubject(test.subject); got != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package purge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// readSpaceGUIDsFile reads one space GUID per line, skipping blank lines and
+// lines starting with #
+func readSpaceGUIDsFile(path string) (map[string]bool, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading space GUIDs file: %w", err)
+	}
+	guids := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		guids[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading space GUIDs file %s: %w", path, err)
+	}
+	if len(guids) == 0 {
+		return nil, fmt.Errorf("space GUIDs file %s lists no spaces", path)
+	}
+	return guids, nil
+}
+
+// useSpaceGUIDsFile reads SPACE_GUIDS_FILE, if set, so the run only
+// processes the spaces it lists
+func (c *Config) useSpaceGUIDsFile() error {
+	if c.SpaceGUIDsFile == "" {
+		return nil
+	}
+	guids, err := readSpaceGUIDsFile(c.SpaceGUIDsFile)
+	if err != nil {
+		return err
+	}
+	c.spaceGUIDs = guids
+	return nil
+}
+
+// constrained reports whether the run only processes the spaces listed in
+// SPACE_GUIDS_FILE
+func (c Config) constrained() bool {
+	return len(c.spaceGUIDs) > 0
+}
+
+// withoutRunOutputs drops the plan, report, digest, and exports describing a
+// whole run, for runs made over a few spaces on the side
+func (c Config) withoutRunOutputs() Config {
+	c.PlanFile = ""
+	c.ApplyPlan = ""
+	c.ApprovedPlan = ""
+	c.ReportFormat = ""
+	c.MetricsTextfile = ""
+	c.InventoryOptions = InventoryOptions{}
+	c.LeaderboardOptions = LeaderboardOptions{}
+	c.AgencyRollupOptions = AgencyRollupOptions{}
+	c.GitHubOptions = GitHubOptions{}
+	c.OperatorDigestRecipients = nil
+	return c
+}
+
+// listConstrainedOrgs narrows orgs to those holding a listed space; listed
+// spaces that no longer exist or aren't in a sandbox org are logged and
+// skipped
+func listConstrainedOrgs(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	orgs []*resource.Organization,
+) ([]*resource.Organization, error) {
+	var guids []string
+	for guid := range opts.spaceGUIDs {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	var spaces []*resource.Space
+	for _, batch := range guidBatches(guids) {
+		spaceListOptions := client.NewSpaceListOptions()
+		spaceListOptions.GUIDs.EqualTo(batch...)
+		batchSpaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error listing spaces from space GUIDs file: %w", err)
+		}
+		spaces = append(spaces, batchSpaces...)
+	}
+
+	sandboxOrgs := map[string]bool{}
+	for _, org := range orgs {
+		sandboxOrgs[org.GUID] = true
+	}
+	found := map[string]bool{}
+	spaceOrgs := map[string]bool{}
+	for _, space := range spaces {
+		found[space.GUID] = true
+		var orgGUID string
+		if space.Relationships != nil {
+			orgGUID = relationshipGUID(space.Relationships.Organization)
+		}
+		if !sandboxOrgs[orgGUID] {
+			logFields{Space: space.Name}.printf("skipping space %s (%s); it isn't in a sandbox org", space.Name, space.GUID)
+			continue
+		}
+		spaceOrgs[orgGUID] = true
+	}
+	for guid := range opts.spaceGUIDs {
+		if !found[guid] {
+			logFields{}.printf("skipping space %s; it wasn't found", guid)
+		}
+	}
+
+	var constrained []*resource.Organization
+	for _, org := range orgs {
+		if spaceOrgs[org.GUID] {
+			constrained = append(constrained, org)
+		}
+	}
+	return constrained, nil
+}
+
+// onlySpaces keeps the parts of an evaluation that concern the given spaces;
+// orphaned service instances belong to no space, so they are dropped
+func (e orgEvaluation) onlySpaces(guids map[string]bool) orgEvaluation {
+	keepDetails := func(details []SpaceDetails) []SpaceDetails {
+		var kept []SpaceDetails
+		for _, d := range details {
+			if guids[d.Space.GUID] {
+				kept = append(kept, d)
+			}
+		}
+		return kept
+	}
+	constrained := orgEvaluation{
+		toNotify:      keepDetails(e.toNotify),
+		toPurge:       keepDetails(e.toPurge),
+		toWelcome:     keepDetails(e.toWelcome),
+		toDeleteEmpty: keepDetails(e.toDeleteEmpty),
+	}
+	for _, aged := range e.agedInstances {
+		if guids[aged.Space.GUID] {
+			constrained.agedInstances = append(constrained.agedInstances, aged)
+		}
+	}
+	for _, annotation := range e.annotations {
+		if guids[annotation.SpaceGUID] {
+			constrained.annotations = append(constrained.annotations, annotation)
+		}
+	}
+	for _, contents := range e.contents {
+		if guids[contents.SpaceGUID] {
+			constrained.contents = append(constrained.contents, contents)
+		}
+	}
+	for _, record := range e.inventory {
+		if guids[record.SpaceGUID] {
+			constrained.inventory = append(constrained.inventory, record)
+		}
+	}
+	for _, over := range e.overCaps {
+		if guids[over.Space.GUID] {
+			constrained.overCaps = append(constrained.overCaps, over)
+		}
+	}
+	return constrained
+}
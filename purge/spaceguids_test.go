@@ -0,0 +1,100 @@
+package purge
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadSpaceGUIDsFile(t *testing.T) {
+	testCases := map[string]struct {
+		contents    string
+		expected    map[string]bool
+		expectedErr string
+	}{
+		"guids with comments": {
+			contents: "# failures from the last run\nspace-1\n\n  space-2  \nspace-1\n",
+			expected: map[string]bool{"space-1": true, "space-2": true},
+		},
+		"no guids": {
+			contents:    "# nothing to re-drive\n",
+			expectedErr: "space GUIDs file spaces.txt lists no spaces",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "spaces.txt")
+			if err := os.WriteFile(path, []byte(test.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if test.expectedErr != "" {
+				test.expectedErr = strings.Replace(test.expectedErr, "spaces.txt", path, 1)
+			}
+			guids, err := readSpaceGUIDsFile(path)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error: %q, got: %s", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expected, guids); diff != "" {
+				t.Errorf("readSpaceGUIDsFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestListConstrainedOrgs(t *testing.T) {
+	inOrg := func(guid string) *resource.SpaceRelationships {
+		return &resource.SpaceRelationships{
+			Organization: &resource.ToOneRelationship{Data: &resource.Relationship{GUID: guid}},
+		}
+	}
+	orgs := []*resource.Organization{
+		{GUID: "org-1", Name: "sandbox-a"},
+		{GUID: "org-2", Name: "sandbox-b"},
+		{GUID: "org-3", Name: "sandbox-c"},
+	}
+	cfClient := &cfResourceClient{Spaces: &mockSpaces{spaces: []*resource.Space{
+		{GUID: "space-1", Relationships: inOrg("org-1")},
+		{GUID: "space-2", Relationships: inOrg("org-3")},
+		{GUID: "space-3", Relationships: inOrg("production-org")},
+	}}}
+	opts := Config{spaceGUIDs: map[string]bool{"space-1": true, "space-2": true, "space-3": true, "deleted": true}}
+
+	constrained, err := listConstrainedOrgs(context.Background(), cfClient, opts, orgs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var names []string
+	for _, org := range constrained {
+		names = append(names, org.Name)
+	}
+	if diff := cmp.Diff([]string{"sandbox-a", "sandbox-c"}, names); diff != "" {
+		t.Errorf("listConstrainedOrgs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOnlySpaces(t *testing.T) {
+	listed := &resource.Space{GUID: "space-1", Name: "listed"}
+	other := &resource.Space{GUID: "space-2", Name: "other"}
+	evaluation := orgEvaluation{
+		toNotify:      []SpaceDetails{{Space: listed}, {Space: other}},
+		toPurge:       []SpaceDetails{{Space: other}},
+		orphans:       []*resource.ServiceInstance{{GUID: "orphan"}},
+		agedInstances: []spaceInstances{{Space: other}, {Space: listed}},
+		annotations:   []SpaceAnnotation{{SpaceGUID: "space-2"}},
+	}
+	constrained := evaluation.onlySpaces(map[string]bool{"space-1": true})
+	if len(constrained.toNotify) != 1 || constrained.toNotify[0].Space != listed {
+		t.Errorf("expected only the listed space to be notified, got: %+v", constrained.toNotify)
+	}
+	if len(constrained.toPurge) != 0 || len(constrained.orphans) != 0 || len(constrained.annotations) != 0 {
+		t.Errorf("expected unlisted spaces and orphans to be dropped, got: %+v", constrained)
+	}
+	if len(constrained.agedInstances) != 1 || constrained.agedInstances[0].Space != listed {
+		t.Errorf("expected only the listed space's aged instances, got: %+v", constrained.agedInstances)
+	}
+}
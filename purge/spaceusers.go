@@ -0,0 +1,150 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// spaceRosterBatchSize caps the space GUIDs filtered on in each role
+// listing, keeping request URLs well under proxy limits
+const spaceRosterBatchSize = 50
+
+// spaceRoster is the roles on a space and the users holding them
+type spaceRoster struct {
+	roles []*resource.Role
+	users []*resource.User
+}
+
+// spaceRosters maps space GUIDs to rosters resolved ahead of planning; a nil
+// map is empty
+type spaceRosters map[string]spaceRoster
+
+// listSpaceRosters lists the roles on spaces with their users included,
+// spaceRosterBatchSize spaces per request, so the inventory export doesn't
+// list each unplanned space's users separately; spaces with no roles are left out, so
+// lookups fall back to the per-space listings that notice deleted spaces, and
+// a single space is left to its own listing, since batching it saves nothing
+func listSpaceRosters(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	spaceGUIDs []string,
+) (spaceRosters, error) {
+	if len(spaceGUIDs) < 2 {
+		return nil, nil
+	}
+	rosters := spaceRosters{}
+	for start := 0; start < len(spaceGUIDs); start += spaceRosterBatchSize {
+		end := min(start+spaceRosterBatchSize, len(spaceGUIDs))
+		roleListOptions := client.NewRoleListOptions()
+		roleListOptions.SpaceGUIDs.EqualTo(spaceGUIDs[start:end]...)
+		roles, users, err := cfClient.Roles.ListIncludeUsersAll(ctx, roleListOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error listing roles with users on %d spaces: %w", end-start, err)
+		}
+		usersByGUID := map[string]*resource.User{}
+		for _, user := range users {
+			usersByGUID[user.GUID] = user
+		}
+		added := map[string]map[string]bool{}
+		for _, role := range roles {
+			if role.Relationships.Space.Data == nil || role.Relationships.User.Data == nil {
+				continue
+			}
+			spaceGUID, userGUID := role.Relationships.Space.Data.GUID, role.Relationships.User.Data.GUID
+			roster := rosters[spaceGUID]
+			roster.roles = append(roster.roles, role)
+			if user, ok := usersByGUID[userGUID]; ok && !added[spaceGUID][userGUID] {
+				if added[spaceGUID] == nil {
+					added[spaceGUID] = map[string]bool{}
+				}
+				added[spaceGUID][userGUID] = true
+				roster.users = append(roster.users, user)
+			}
+			rosters[spaceGUID] = roster
+		}
+	}
+	return rosters, nil
+}
+
+// inventoryRosters takes the rosters of the given spaces from an org's
+// inventory, so planning doesn't list their roles again; spaces with no roles
+// are left out, so lookups fall back to the per-space listings that notice
+// deleted spaces
+func inventoryRosters(inventory *collect.Inventory, spaceGUIDs []string) spaceRosters {
+	wanted := map[string]bool{}
+	for _, guid := range spaceGUIDs {
+		wanted[guid] = true
+	}
+	rosters := spaceRosters{}
+	for _, space := range inventory.Spaces {
+		if wanted[space.Space.GUID] && len(space.Roles) > 0 {
+			rosters[space.Space.GUID] = spaceRoster{roles: space.Roles, users: space.Users}
+		}
+	}
+	return rosters
+}
+
+// plannedSpaceGUIDs lists the spaces whose users are looked up to plan an
+// org's warnings, welcomes, purges, and aged instance deletes
+func (e orgEvaluation) plannedSpaceGUIDs() []string {
+	seen := map[string]bool{}
+	var guids []string
+	add := func(space *resource.Space) {
+		if !seen[space.GUID] {
+			seen[space.GUID] = true
+			guids = append(guids, space.GUID)
+		}
+	}
+	for _, details := range [][]SpaceDetails{e.toNotify, e.toWelcome, e.toPurge} {
+		for _, d := range details {
+			add(d.Space)
+		}
+	}
+	for _, aged := range e.agedInstances {
+		add(aged.Space)
+	}
+	return guids
+}
+
+// spaceUsers returns the users on a space from its roster, or lists them
+// when it has none
+func (r spaceRosters) spaceUsers(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+) ([]*resource.User, error) {
+	if roster, ok := r[space.GUID]; ok {
+		return roster.users, nil
+	}
+	spaceUsers, err := cfClient.Spaces.ListUsersAll(ctx, space.GUID, nil)
+	if isNotFoundError(err) {
+		return nil, deletedDuringRun("space " + space.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing users on space %s: %w", space.Name, err)
+	}
+	return spaceUsers, nil
+}
+
+// spaceRolesWithUsers returns the roles and users on a space from its
+// roster, or lists them when it has none
+func (r spaceRosters) spaceRolesWithUsers(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	space *resource.Space,
+) ([]*resource.Role, []*resource.User, error) {
+	if roster, ok := r[space.GUID]; ok {
+		return roster.roles, roster.users, nil
+	}
+	roleListOpts := client.NewRoleListOptions()
+	roleListOpts.SpaceGUIDs.Values = []string{space.GUID}
+	spaceRoles, spaceUsers, err := cfClient.Roles.ListIncludeUsersAll(ctx, roleListOpts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing roles with users on space %s: %w", space.Name, err)
+	}
+	return spaceRoles, spaceUsers, nil
+}
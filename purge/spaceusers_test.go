@@ -0,0 +1,115 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+// batchRoles serves space roles filtered by the listed space GUIDs and
+// records the size of each batch
+type batchRoles struct {
+	mockRoles
+	batches []int
+}
+
+func (r *batchRoles) ListIncludeUsersAll(ctx context.Context, opts *client.RoleListOptions) ([]*resource.Role, []*resource.User, error) {
+	r.batches = append(r.batches, len(opts.SpaceGUIDs.Values))
+	requested := map[string]bool{}
+	for _, guid := range opts.SpaceGUIDs.Values {
+		requested[guid] = true
+	}
+	var roles []*resource.Role
+	for _, role := range r.roles {
+		if requested[role.Relationships.Space.Data.GUID] {
+			roles = append(roles, role)
+		}
+	}
+	return roles, r.users, nil
+}
+
+func TestListSpaceRosters(t *testing.T) {
+	var spaceGUIDs []string
+	for i := 0; i < spaceRosterBatchSize+1; i++ {
+		spaceGUIDs = append(spaceGUIDs, fmt.Sprintf("space-%d", i))
+	}
+	roles := &batchRoles{mockRoles: mockRoles{
+		roles: []*resource.Role{
+			testRole("role-1", "space_developer", "user-1", "space-0"),
+			testRole("role-2", "space_manager", "user-1", "space-0"),
+			testRole("role-3", "space_developer", "user-2", "space-50"),
+		},
+		users: []*resource.User{{GUID: "user-1", Username: "foo@bar.gov"}, {GUID: "user-2", Username: "baz@bar.gov"}},
+	}}
+	cfClient := &cfResourceClient{
+		Roles: roles,
+		Spaces: &mockSpaces{
+			spaceGUID: "space-1",
+			users:     []*resource.User{{GUID: "user-3", Username: "qux@bar.gov"}},
+		},
+	}
+
+	rosters, err := listSpaceRosters(context.Background(), cfClient, spaceGUIDs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff([]int{spaceRosterBatchSize, 1}, roles.batches); diff != "" {
+		t.Errorf("batches mismatch (-want +got):\n%s", diff)
+	}
+
+	usernames := func(space string) []string {
+		users, err := rosters.spaceUsers(context.Background(), cfClient, &resource.Space{GUID: space, Name: space})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var names []string
+		for _, user := range users {
+			names = append(names, user.Username)
+		}
+		return names
+	}
+	if diff := cmp.Diff([]string{"foo@bar.gov"}, usernames("space-0")); diff != "" {
+		t.Errorf("space-0 users mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"baz@bar.gov"}, usernames("space-50")); diff != "" {
+		t.Errorf("space-50 users mismatch (-want +got):\n%s", diff)
+	}
+	// a space with no roles is listed on its own
+	if diff := cmp.Diff([]string{"qux@bar.gov"}, usernames("space-1")); diff != "" {
+		t.Errorf("space-1 users mismatch (-want +got):\n%s", diff)
+	}
+
+	spaceRoles, _, err := rosters.spaceRolesWithUsers(context.Background(), cfClient, &resource.Space{GUID: "space-0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(spaceRoles) != 2 {
+		t.Errorf("expected 2 roles on space-0, got %d", len(spaceRoles))
+	}
+
+	single, err := listSpaceRosters(context.Background(), cfClient, spaceGUIDs[:1])
+	if err != nil || single != nil || len(roles.batches) != 2 {
+		t.Errorf("expected a single space to be left to its own listing, got %v, %v", single, err)
+	}
+}
+
+func TestInventoryRosters(t *testing.T) {
+	spaces := []*resource.Space{{GUID: "space-1"}, {GUID: "space-2"}, {GUID: "space-3"}}
+	roles := []*resource.Role{
+		testRole("role-1", "space_developer", "user-1", "space-1"),
+		testRole("role-2", "space_developer", "user-2", "space-2"),
+	}
+	users := []*resource.User{{GUID: "user-1"}, {GUID: "user-2"}}
+	inventory := collect.New(&resource.Organization{}, collect.Resources{Spaces: spaces, Roles: roles, Users: users})
+
+	rosters := inventoryRosters(inventory, []string{"space-1", "space-3"})
+	expected := spaceRosters{"space-1": {roles: roles[:1], users: users[:1]}}
+	if diff := cmp.Diff(expected, rosters, cmp.AllowUnexported(spaceRoster{})); diff != "" {
+		t.Errorf("inventoryRosters() mismatch (-want +got):\n%s", diff)
+	}
+}
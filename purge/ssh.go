@@ -0,0 +1,51 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// SPACE_SSH values
+const (
+	// spaceSSHPreserve gives a recreated space the purged space's setting
+	spaceSSHPreserve = "preserve"
+	spaceSSHEnabled  = "enabled"
+	spaceSSHDisabled = "disabled"
+)
+
+func validSpaceSSH(value string) bool {
+	switch value {
+	case "", spaceSSHPreserve, spaceSSHEnabled, spaceSSHDisabled:
+		return true
+	}
+	return false
+}
+
+// planSpaceSSH returns whether SSH is enabled on the space recreated by a
+// purge: the purged space's own setting, or the one SPACE_SSH forces
+func planSpaceSSH(ctx context.Context, cfClient *cfResourceClient, opts Config, space *resource.Space) (bool, error) {
+	switch opts.SpaceSSH {
+	case spaceSSHEnabled:
+		return true, nil
+	case spaceSSHDisabled:
+		return false, nil
+	}
+	enabled, err := cfClient.SpaceFeatures.IsSSHEnabled(ctx, space.GUID)
+	if isNotFoundError(err) {
+		return false, deletedDuringRun("space " + space.Name)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting SSH setting of space %s: %w", space.Name, err)
+	}
+	return enabled, nil
+}
+
+// formatSSHEnabled describes an SSH setting for plans and mismatches
+func formatSSHEnabled(enabled bool) string {
+	if enabled {
+		return spaceSSHEnabled
+	}
+	return spaceSSHDisabled
+}
@@ -0,0 +1,52 @@
+package purge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+func TestPlanSpaceSSH(t *testing.T) {
+	testCases := map[string]struct {
+		spaceSSH   string
+		sshEnabled bool
+		expected   bool
+	}{
+		"preserves enabled": {
+			spaceSSH:   spaceSSHPreserve,
+			sshEnabled: true,
+			expected:   true,
+		},
+		"preserves disabled": {
+			spaceSSH: spaceSSHPreserve,
+			expected: false,
+		},
+		"unset preserves": {
+			sshEnabled: true,
+			expected:   true,
+		},
+		"forces enabled": {
+			spaceSSH: spaceSSHEnabled,
+			expected: true,
+		},
+		"forces disabled": {
+			spaceSSH:   spaceSSHDisabled,
+			sshEnabled: true,
+			expected:   false,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{SpaceFeatures: &mockSpaceFeatures{sshEnabled: test.sshEnabled}}
+			space := &resource.Space{GUID: "space-1", Name: "foo"}
+			enabled, err := planSpaceSSH(context.Background(), cfClient, Config{SpaceSSH: test.spaceSSH}, space)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if enabled != test.expected {
+				t.Errorf("expected SSH enabled %t, got %t", test.expected, enabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package purge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// State is what the purge job remembers about spaces between runs; a nil
+// State remembers nothing
+type State struct {
+	mu      sync.Mutex
+	Spaces  map[string]*SpaceState `json:"spaces"`
+	History []RunCounts            `json:"history,omitempty"`
+	// SkippedOrgs lists the GUIDs of orgs the last run ran out of time for
+	SkippedOrgs []string `json:"skipped_orgs,omitempty"`
+	// LastRunAt is when the last run started
+	LastRunAt time.Time `json:"last_run_at,omitempty"`
+	// Notices lists the warnings and purges sent or applied over the last
+	// noticeRetention, for looking up a user's history
+	Notices []Notice `json:"notices,omitempty"`
+}
+
+// SpaceState is what the purge job remembers about a single space
+type SpaceState struct {
+	Org          string    `json:"org"`
+	Space        string    `json:"space"`
+	LastNotified time.Time `json:"last_notified"`
+	// AcknowledgedAt is when a user confirmed through a signed link that
+	// they know the space will be purged
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	// WelcomedFor is the first resource timestamp of the purge cycle the
+	// space's users were sent a welcome email for
+	WelcomedFor *time.Time `json:"welcomed_for,omitempty"`
+	// PendingDeprovisionSince is when the space's purge first had to wait
+	// for its service instances to deprovision
+	PendingDeprovisionSince *time.Time `json:"pending_deprovision_since,omitempty"`
+	// Contents are the apps and service instances the last run saw in the
+	// space while it was being warned or purged
+	Contents *SpaceContents `json:"contents,omitempty"`
+}
+
+// stateStore loads and saves state between runs
+type stateStore interface {
+	load() (*State, error)
+	save(state *State) error
+}
+
+// newStateStore returns the configured state store, or nil if none is configured
+func newStateStore(opts Config) stateStore {
+	if opts.StateFile == "" {
+		return nil
+	}
+	return &fileStateStore{path: opts.StateFile}
+}
+
+// fileStateStore keeps state in a local JSON file
+type fileStateStore struct {
+	path string
+}
+
+// load reads state from the file, starting fresh if it doesn't exist yet
+func (s *fileStateStore) load() (*State, error) {
+	contents, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{Spaces: map[string]*SpaceState{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file %s: %w", s.path, err)
+	}
+	state := &State{}
+	if err := json.Unmarshal(contents, state); err != nil {
+		return nil, fmt.Errorf("error decoding state file %s: %w", s.path, err)
+	}
+	if state.Spaces == nil {
+		state.Spaces = map[string]*SpaceState{}
+	}
+	return state, nil
+}
+
+// save writes state to a temporary file and renames it into place, so a
+// failed write never leaves a truncated state file behind; acknowledgements
+// the purge server recorded since state was loaded are kept
+func (s *fileStateStore) save(state *State) error {
+	if current, err := s.load(); err == nil {
+		state.mergeAcknowledgements(current)
+	}
+	state.mu.Lock()
+	contents, err := json.MarshalIndent(state, "", "  ")
+	state.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, contents, 0644); err != nil {
+		return fmt.Errorf("error writing state file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("error replacing state file %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// lastNotified returns when a space was last sent a purge warning
+func (s *State) lastNotified(spaceGUID string) time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if space, ok := s.Spaces[spaceGUID]; ok {
+		return space.LastNotified
+	}
+	return time.Time{}
+}
+
+// notifiedInCycle reports whether a space was sent a purge warning in the
+// purge cycle starting at firstResource
+func (s *State) notifiedInCycle(spaceGUID string, firstResource time.Time) bool {
+	lastNotified := s.lastNotified(spaceGUID)
+	return !lastNotified.IsZero() && !lastNotified.Before(firstResource)
+}
+
+// recordNotified remembers that a purge warning was sent for a space
+func (s *State) recordNotified(action PlannedAction, at time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	space := &SpaceState{
+		Org:          action.Org.Name,
+		Space:        action.Details.Space.Name,
+		LastNotified: at,
+	}
+	if previous, ok := s.Spaces[action.Details.Space.GUID]; ok {
+		space.AcknowledgedAt = previous.AcknowledgedAt
+		space.WelcomedFor = previous.WelcomedFor
+		space.PendingDeprovisionSince = previous.PendingDeprovisionSince
+		space.Contents = previous.Contents
+	}
+	s.Spaces[action.Details.Space.GUID] = space
+}
+
+// spaceContents returns the contents the last run saw in a space, if any
+func (s *State) spaceContents(spaceGUID string) *SpaceContents {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if space, ok := s.Spaces[spaceGUID]; ok {
+		return space.Contents
+	}
+	return nil
+}
+
+// recordContents remembers the contents a run saw in an org's spaces
+func (s *State) recordContents(org *resource.Organization, contents []SpaceContents) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range contents {
+		space, ok := s.Spaces[contents[i].SpaceGUID]
+		if !ok {
+			space = &SpaceState{Org: org.Name, Space: contents[i].Space}
+			s.Spaces[contents[i].SpaceGUID] = space
+		}
+		space.Contents = &contents[i]
+	}
+}
+
+// forget drops what is remembered about a space, such as after it is purged
+func (s *State) forget(spaceGUID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Spaces, spaceGUID)
+}
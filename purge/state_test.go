@@ -0,0 +1,67 @@
+package purge
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileStateStore(t *testing.T) {
+	store := &fileStateStore{path: filepath.Join(t.TempDir(), "state.json")}
+
+	state, err := store.load()
+	if err != nil {
+		t.Fatalf("unexpected error loading missing state: %s", err)
+	}
+	notifiedAt := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	state.recordNotified(PlannedAction{
+		Org:     &resource.Organization{Name: "sandbox-org"},
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+	}, notifiedAt)
+	state.recordNotified(PlannedAction{
+		Org:     &resource.Organization{Name: "sandbox-org"},
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-2", Name: "bar"}},
+	}, notifiedAt)
+	state.forget("space-2")
+	if err := store.save(state); err != nil {
+		t.Fatalf("unexpected error saving state: %s", err)
+	}
+
+	loaded, err := store.load()
+	if err != nil {
+		t.Fatalf("unexpected error loading state: %s", err)
+	}
+	expected := map[string]*SpaceState{
+		"space-1": {Org: "sandbox-org", Space: "foo", LastNotified: notifiedAt},
+	}
+	if diff := cmp.Diff(expected, loaded.Spaces); diff != "" {
+		t.Errorf("load() mismatch (-want +got):\n%s", diff)
+	}
+	if got := loaded.lastNotified("space-1"); !got.Equal(notifiedAt) {
+		t.Errorf("expected last notified %s, got %s", notifiedAt, got)
+	}
+}
+
+func TestNotifiedInCycle(t *testing.T) {
+	cycleStart := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	state := &State{Spaces: map[string]*SpaceState{
+		"this-cycle": {LastNotified: cycleStart.AddDate(0, 0, 26)},
+		"last-cycle": {LastNotified: cycleStart.AddDate(0, 0, -5)},
+	}}
+	for guid, expected := range map[string]bool{
+		"this-cycle": true,
+		"last-cycle": false,
+		"never":      false,
+	} {
+		if got := state.notifiedInCycle(guid, cycleStart); got != expected {
+			t.Errorf("notifiedInCycle(%s) = %t, expected %t", guid, got, expected)
+		}
+	}
+	var noState *State
+	if noState.notifiedInCycle("this-cycle", cycleStart) {
+		t.Errorf("expected nil state to remember no warnings")
+	}
+}
@@ -0,0 +1,127 @@
+package purge
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// runStatus tracks the progress of a run so it can be dumped on demand; a nil
+// runStatus does nothing
+type runStatus struct {
+	mu           sync.Mutex
+	startedAt    time.Time
+	phase        string
+	org          string
+	orgsDone     int
+	orgsTotal    int
+	actionsDone  int
+	actionsTotal int
+	notified     int
+	purged       int
+	errors       int
+}
+
+func newRunStatus() *runStatus {
+	return &runStatus{startedAt: time.Now(), phase: "starting"}
+}
+
+// setPhase records the part of the run in progress
+func (s *runStatus) setPhase(phase string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phase
+	setLogPhase(phase)
+}
+
+// startOrg records that planning has moved on to the next org
+func (s *runStatus) startOrg(org string, done int, total int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = "planning"
+	setLogPhase(s.phase)
+	s.org = org
+	s.orgsDone = done
+	s.orgsTotal = total
+}
+
+// startApply records that the plan is being applied
+func (s *runStatus) startApply(total int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = "applying"
+	setLogPhase(s.phase)
+	s.org = ""
+	s.orgsDone = s.orgsTotal
+	s.actionsTotal = total
+}
+
+// finishAction records a completed action and the report counts so far
+func (s *runStatus) finishAction(action PlannedAction, report *Report) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.org = action.Org.Name
+	s.actionsDone++
+	s.notified = report.SpacesNotified
+	s.purged = report.SpacesPurged
+	s.errors = len(report.Errors)
+}
+
+// write formats the current status
+func (s *runStatus) write(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(
+		w,
+		"status at %s: running for %s, phase %s, org %q, orgs %d/%d, actions %d/%d (%d queued), notified %d, purged %d, errors %d\n",
+		time.Now().Format(time.RFC3339),
+		time.Since(s.startedAt).Round(time.Second),
+		s.phase,
+		s.org,
+		s.orgsDone,
+		s.orgsTotal,
+		s.actionsDone,
+		s.actionsTotal,
+		s.actionsTotal-s.actionsDone,
+		s.notified,
+		s.purged,
+		s.errors,
+	)
+	return err
+}
+
+// dump writes the current status to path, or to stderr if path is empty
+func (s *runStatus) dump(path string) {
+	if s == nil {
+		return
+	}
+	if path == "" {
+		if err := s.write(os.Stderr); err != nil {
+			logFields{Err: err}.printf("error writing status: %s", err)
+		}
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		logFields{Err: err}.printf("error creating status file %s: %s", path, err)
+		return
+	}
+	defer f.Close()
+	if err := s.write(f); err != nil {
+		logFields{Err: err}.printf("error writing status file %s: %s", path, err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package purge
+
+// watchStatusSignal does nothing on platforms without SIGUSR1
+func watchStatusSignal(status *runStatus, path string) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package purge
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchStatusSignal dumps the run status whenever the process receives
+// SIGUSR1, until the returned stop function is called
+func watchStatusSignal(status *runStatus, path string) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				status.dump(path)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,58 @@
+//go:build unix
+
+package purge
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+func TestRunStatusWrite(t *testing.T) {
+	status := newRunStatus()
+	status.startOrg("sandbox-foo", 1, 3)
+	status.startApply(4)
+	status.finishAction(
+		PlannedAction{Org: &resource.Organization{Name: "sandbox-bar"}},
+		&Report{SpacesNotified: 1, Errors: []string{"boom"}},
+	)
+
+	var b bytes.Buffer
+	if err := status.write(&b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `phase applying, org "sandbox-bar", orgs 3/3, actions 1/4 (3 queued), notified 1, purged 0, errors 1`
+	if !strings.Contains(b.String(), expected) {
+		t.Errorf("expected status to contain %q, got: %s", expected, b.String())
+	}
+}
+
+func TestWatchStatusSignal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status")
+	status := newRunStatus()
+	status.startOrg("sandbox-foo", 0, 2)
+
+	stop := watchStatusSignal(status, path)
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		contents, err := os.ReadFile(path)
+		if err == nil && bytes.Contains(contents, []byte(`phase planning, org "sandbox-foo", orgs 0/2`)) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status file not written: %s, %v", contents, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,43 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+)
+
+// SystemServiceOptions lists the service offerings and brokers whose
+// instances are provisioned by the platform, such as logging or identity,
+// rather than by users; those instances don't count toward a space's age
+type SystemServiceOptions struct {
+	ExcludedServiceOfferings []string `env:"EXCLUDED_SERVICE_OFFERINGS"`
+	ExcludedServiceBrokers   []string `env:"EXCLUDED_SERVICE_BROKERS"`
+}
+
+// listSystemPlans returns the GUIDs of the service plans of excluded
+// offerings and brokers
+func listSystemPlans(ctx context.Context, cfClient *cfResourceClient, opts SystemServiceOptions) (map[string]bool, error) {
+	systemPlans := map[string]bool{}
+	var filters []*client.ServicePlanListOptions
+	if len(opts.ExcludedServiceOfferings) > 0 {
+		planListOptions := client.NewServicePlanListOptions()
+		planListOptions.ServiceOfferingNames.EqualTo(opts.ExcludedServiceOfferings...)
+		filters = append(filters, planListOptions)
+	}
+	if len(opts.ExcludedServiceBrokers) > 0 {
+		planListOptions := client.NewServicePlanListOptions()
+		planListOptions.ServiceBrokerNames.EqualTo(opts.ExcludedServiceBrokers...)
+		filters = append(filters, planListOptions)
+	}
+	for _, planListOptions := range filters {
+		plans, _, err := cfClient.ServicePlans.ListIncludeServiceOfferingAll(ctx, planListOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error listing excluded service plans: %w", err)
+		}
+		for _, plan := range plans {
+			systemPlans[plan.GUID] = true
+		}
+	}
+	return systemPlans, nil
+}
@@ -0,0 +1,100 @@
+package purge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+// mockServicePlans returns plans by the offering or broker names filtered on
+type mockServicePlans struct {
+	byOffering map[string][]*resource.ServicePlan
+	byBroker   map[string][]*resource.ServicePlan
+	offerings  []*resource.ServiceOffering
+}
+
+func (p *mockServicePlans) ListIncludeServiceOfferingAll(ctx context.Context, opts *client.ServicePlanListOptions) ([]*resource.ServicePlan, []*resource.ServiceOffering, error) {
+	var plans []*resource.ServicePlan
+	for _, name := range opts.ServiceOfferingNames.Values {
+		plans = append(plans, p.byOffering[name]...)
+	}
+	for _, name := range opts.ServiceBrokerNames.Values {
+		plans = append(plans, p.byBroker[name]...)
+	}
+	return plans, p.offerings, nil
+}
+
+func testPlanInstance(guid string, spaceGUID string, planGUID string, createdAt time.Time) *resource.ServiceInstance {
+	instance := &resource.ServiceInstance{
+		GUID:      guid,
+		CreatedAt: createdAt,
+		Relationships: resource.ServiceInstanceRelationships{
+			Space: &resource.ToOneRelationship{Data: &resource.Relationship{GUID: spaceGUID}},
+		},
+	}
+	if planGUID != "" {
+		instance.Relationships.ServicePlan = &resource.ToOneRelationship{Data: &resource.Relationship{GUID: planGUID}}
+	}
+	return instance
+}
+
+func TestListSystemPlans(t *testing.T) {
+	cfClient := &cfResourceClient{
+		ServicePlans: &mockServicePlans{
+			byOffering: map[string][]*resource.ServicePlan{
+				"logging": {{GUID: "plan-logging"}},
+			},
+			byBroker: map[string][]*resource.ServicePlan{
+				"identity-broker": {{GUID: "plan-identity"}},
+			},
+		},
+	}
+	testCases := map[string]struct {
+		opts     SystemServiceOptions
+		expected map[string]bool
+	}{
+		"none excluded": {
+			expected: map[string]bool{},
+		},
+		"offerings and brokers": {
+			opts: SystemServiceOptions{
+				ExcludedServiceOfferings: []string{"logging"},
+				ExcludedServiceBrokers:   []string{"identity-broker"},
+			},
+			expected: map[string]bool{"plan-logging": true, "plan-identity": true},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			systemPlans, err := listSystemPlans(context.Background(), cfClient, test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(test.expected, systemPlans); diff != "" {
+				t.Errorf("listSystemPlans() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSystemInstancesExcludedFromAge(t *testing.T) {
+	day := 24 * time.Hour
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	space := &resource.Space{GUID: "space-1"}
+	instances := []*resource.ServiceInstance{
+		testPlanInstance("logging", "space-1", "plan-logging", now.Add(-60*day)),
+		testPlanInstance("database", "space-1", "plan-db", now.Add(-10*day)),
+		testPlanInstance("user-provided", "space-1", "", now.Add(-5*day)),
+	}
+	inventory := collect.New(&resource.Organization{}, collect.Resources{Spaces: []*resource.Space{space}, ServiceInstances: instances})
+
+	details := listSpaceFirstResources(inventory.WithoutSystemInstances(map[string]bool{"plan-logging": true}), ageByCreated, time.Time{})
+	if expected := now.Add(-10 * day); !details[0].Timestamp.Equal(expected) {
+		t.Errorf("expected first resource %s, got %s", expected, details[0].Timestamp)
+	}
+}
@@ -0,0 +1,111 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// countOrgResources counts an org's apps and service instances from the
+// totals of single-result listings, without paging through them
+func countOrgResources(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+) (apps int, instances int, err error) {
+	err = runConcurrently(ctx, 0,
+		func(ctx context.Context) error {
+			appListOptions := client.NewAppListOptions()
+			appListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+			appListOptions.PerPage = 1
+			_, pager, err := cfClient.Applications.List(ctx, appListOptions)
+			if err != nil {
+				return err
+			}
+			apps = pager.TotalResults
+			return nil
+		},
+		func(ctx context.Context) error {
+			serviceListOptions := client.NewServiceInstanceListOptions()
+			serviceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+			serviceListOptions.PerPage = 1
+			_, pager, err := cfClient.ServiceInstances.List(ctx, serviceListOptions)
+			if err != nil {
+				return err
+			}
+			instances = pager.TotalResults
+			return nil
+		},
+	)
+	return
+}
+
+// useTargetedQueries reports whether an org holds more apps or service
+// instances than TARGETED_QUERY_THRESHOLD, so its resources are listed space
+// by space rather than org-wide
+func useTargetedQueries(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	opts Config,
+) (bool, error) {
+	if opts.TargetedQueryThreshold <= 0 {
+		return false, nil
+	}
+	apps, instances, err := countOrgResources(ctx, cfClient, org)
+	if err != nil {
+		return false, fmt.Errorf("error counting resources for org %s: %w", org.Name, err)
+	}
+	if apps <= opts.TargetedQueryThreshold && instances <= opts.TargetedQueryThreshold {
+		return false, nil
+	}
+	logFields{Org: org.Name}.printf("org %s has %d apps and %d service instances; listing its resources space by space", org.Name, apps, instances)
+	return true, nil
+}
+
+// evaluateOrgBySpace evaluates an org one space at a time, listing only that
+// space's resources, so no org-wide listing or grouping is built; orphaned
+// service instances belong to no space, so they aren't found this way
+func evaluateOrgBySpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	opts Config,
+	systemPlans map[string]bool,
+	now time.Time,
+	timeStartsAt time.Time,
+) (orgEvaluation, error) {
+	spaceListOptions := client.NewSpaceListOptions()
+	spaceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	spaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+	if err != nil {
+		return orgEvaluation{}, fmt.Errorf("error listing spaces for org %s: %w", org.Name, err)
+	}
+	collect.SortSpaces(spaces)
+
+	evaluation := orgEvaluation{rosters: spaceRosters{}}
+	for _, space := range spaces {
+		inventory, err := collectSpaceInventory(ctx, cfClient, org, space)
+		if err != nil {
+			return orgEvaluation{}, fmt.Errorf("error listing resources for space %s in org %s: %w", space.Name, org.Name, err)
+		}
+		spaceEvaluation := evaluateResources(inventory, opts, systemPlans, now, timeStartsAt)
+		evaluation.toNotify = append(evaluation.toNotify, spaceEvaluation.toNotify...)
+		evaluation.toPurge = append(evaluation.toPurge, spaceEvaluation.toPurge...)
+		evaluation.toWelcome = append(evaluation.toWelcome, spaceEvaluation.toWelcome...)
+		evaluation.toDeleteEmpty = append(evaluation.toDeleteEmpty, spaceEvaluation.toDeleteEmpty...)
+		evaluation.agedInstances = append(evaluation.agedInstances, spaceEvaluation.agedInstances...)
+		evaluation.annotations = append(evaluation.annotations, spaceEvaluation.annotations...)
+		evaluation.contents = append(evaluation.contents, spaceEvaluation.contents...)
+		evaluation.inventory = append(evaluation.inventory, spaceEvaluation.inventory...)
+		evaluation.overCaps = append(evaluation.overCaps, spaceEvaluation.overCaps...)
+		for spaceGUID, roster := range spaceEvaluation.rosters {
+			evaluation.rosters[spaceGUID] = roster
+		}
+	}
+	return evaluation, nil
+}
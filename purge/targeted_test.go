@@ -0,0 +1,107 @@
+package purge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+// mockSpaceApplications lists only the apps in the space a listing filters on
+type mockSpaceApplications struct {
+	mockApplications
+	listedSpaces []string
+}
+
+func (a *mockSpaceApplications) ListAll(ctx context.Context, opts *client.AppListOptions) ([]*resource.App, error) {
+	var apps []*resource.App
+	for _, spaceGUID := range opts.SpaceGUIDs.Values {
+		a.listedSpaces = append(a.listedSpaces, spaceGUID)
+		for _, app := range a.apps {
+			if app.Relationships.Space.Data.GUID == spaceGUID {
+				apps = append(apps, app)
+			}
+		}
+	}
+	return apps, nil
+}
+
+func TestUseTargetedQueries(t *testing.T) {
+	org := &resource.Organization{GUID: "org-guid", Name: "sandbox-gsa"}
+	apps := []*resource.App{{GUID: "app-1"}, {GUID: "app-2"}, {GUID: "app-3"}}
+	testCases := map[string]struct {
+		threshold int
+		expected  bool
+	}{
+		"disabled":        {threshold: 0, expected: false},
+		"below threshold": {threshold: 3, expected: false},
+		"above threshold": {threshold: 2, expected: true},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				Applications:     &mockApplications{apps: apps},
+				ServiceInstances: &mockServiceInstances{},
+			}
+			targeted, err := useTargetedQueries(context.Background(), cfClient, org, Config{TargetedQueryThreshold: test.threshold})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if targeted != test.expected {
+				t.Errorf("expected targeted queries: %t, got: %t", test.expected, targeted)
+			}
+		})
+	}
+}
+
+func TestEvaluateOrgBySpace(t *testing.T) {
+	now := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	org := &resource.Organization{GUID: "org-guid", Name: "sandbox-gsa"}
+	inSpace := func(guid string) resource.SpaceRelationship {
+		return resource.SpaceRelationship{
+			Space: resource.ToOneRelationship{Data: &resource.Relationship{GUID: guid}},
+		}
+	}
+	applications := &mockSpaceApplications{mockApplications: mockApplications{apps: []*resource.App{
+		{GUID: "app-1", CreatedAt: now.AddDate(0, 0, -40), Relationships: inSpace("space-1")},
+		{GUID: "app-2", CreatedAt: now.AddDate(0, 0, -26), Relationships: inSpace("space-2")},
+		{GUID: "app-3", CreatedAt: now.AddDate(0, 0, -1), Relationships: inSpace("space-3")},
+	}}}
+	cfClient := &cfResourceClient{
+		Applications:     applications,
+		ServiceInstances: &mockServiceInstances{},
+		Routes:           &mockRoutes{},
+		Spaces: &mockSpaces{spaces: []*resource.Space{
+			{GUID: "space-1", Name: "old"},
+			{GUID: "space-2", Name: "warned"},
+			{GUID: "space-3", Name: "new"},
+		}},
+		Roles: &mockMemberRoles{},
+	}
+	opts := Config{NotifyDays: 25, PurgeDays: 30, TargetedQueryThreshold: 2}
+
+	evaluation, err := evaluateOrg(context.Background(), cfClient, org, opts, nil, now, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// spaces are evaluated by name
+	if diff := cmp.Diff([]string{"space-3", "space-1", "space-2"}, applications.listedSpaces); diff != "" {
+		t.Errorf("listed spaces mismatch (-want +got):\n%s", diff)
+	}
+	names := func(details []SpaceDetails) []string {
+		var names []string
+		for _, d := range details {
+			names = append(names, d.Space.Name)
+		}
+		return names
+	}
+	if diff := cmp.Diff([]string{"old"}, names(evaluation.toPurge)); diff != "" {
+		t.Errorf("toPurge mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"warned"}, names(evaluation.toNotify)); diff != "" {
+		t.Errorf("toNotify mismatch (-want +got):\n%s", diff)
+	}
+}
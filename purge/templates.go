@@ -0,0 +1,264 @@
+package purge
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"golang.org/x/net/html"
+)
+
+const (
+	notifyTemplateName        = "notify.tmpl"
+	purgeTemplateName         = "purge.tmpl"
+	purgeInstanceTemplateName = "purge-instance.tmpl"
+	welcomeTemplateName       = "welcome.tmpl"
+
+	// mailPartialPattern matches the shared partials, such as a header or
+	// footer, that every email template can use
+	mailPartialPattern = "_*.html"
+)
+
+// voidElements are HTML elements that never have an end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// parseMailTemplate parses an email template along with the shared base
+// layout and partials; executing it fails on keys missing from the data
+func parseMailTemplate(templateDir string, name string) (*template.Template, error) {
+	partials, err := filepath.Glob(filepath.Join(templateDir, mailPartialPattern))
+	if err != nil {
+		return nil, err
+	}
+	files := append([]string{filepath.Join(templateDir, "base.html")}, partials...)
+	tmpl, err := template.ParseFiles(append(files, filepath.Join(templateDir, name))...)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.Option("missingkey=error"), nil
+}
+
+// notifyTemplate returns the template and subject of a purge warning sent
+// daysLeft days before the purge: those of the tier with the fewest days
+// that still covers daysLeft, or notify.tmpl and NOTIFY_MAIL_SUBJECT when no
+// tier does
+func (c Config) notifyTemplate(daysLeft int) (string, string) {
+	name, subject := notifyTemplateName, c.NotifyMailSubject
+	var match *NotifyTier
+	for i, tier := range c.notifyTiers {
+		if daysLeft <= tier.DaysLeft && (match == nil || tier.DaysLeft < match.DaysLeft) {
+			match = &c.notifyTiers[i]
+		}
+	}
+	if match == nil {
+		return name, subject
+	}
+	if match.Subject != "" {
+		subject = match.Subject
+	}
+	return match.Template, subject
+}
+
+// notifyTemplateData is the data passed to the notify template
+func notifyTemplateData(opts Config, org *resource.Organization, details SpaceDetails) map[string]interface{} {
+	purgeDate := spacePurgeCutoff(details, opts.PurgeDays)
+	return map[string]interface{}{
+		"org":      org,
+		"space":    details.Space,
+		"date":     purgeDate,
+		"days":     opts.PurgeDays,
+		"ackURL":   opts.ackURL(details.Space.GUID, purgeDate),
+		"spaceURL": dashboardSpaceURL(opts.DashboardURL, org.GUID, details.Space.GUID),
+		// appsStopped is replaced by whether the warned space's apps were
+		// actually stopped when the warning is sent
+		"appsStopped": opts.StopAppsOnNotify,
+	}
+}
+
+// welcomeTemplateData is the data passed to the welcome template
+func welcomeTemplateData(opts Config, org *resource.Organization, details SpaceDetails) map[string]interface{} {
+	return map[string]interface{}{
+		"org":        org,
+		"space":      details.Space,
+		"date":       spacePurgeCutoff(details, opts.PurgeDays),
+		"days":       opts.PurgeDays,
+		"notifyDays": opts.NotifyDays,
+		"spaceURL":   dashboardSpaceURL(opts.DashboardURL, org.GUID, details.Space.GUID),
+	}
+}
+
+// purgeTemplateData is the data passed to the purge template
+func purgeTemplateData(opts Config, org *resource.Organization, details SpaceDetails) map[string]interface{} {
+	return map[string]interface{}{
+		"org":   org,
+		"space": details.Space,
+		"days":  opts.PurgeDays,
+		// the space is recreated with a new GUID, so the link is to its org
+		"orgURL": dashboardOrgURL(opts.DashboardURL, org.GUID),
+	}
+}
+
+// purgeInstanceTemplateData is the data passed to the purge instance
+// template; cost is the monthly cost of an instance deleted early for its
+// plan's cost, and zero otherwise
+func purgeInstanceTemplateData(
+	opts Config,
+	org *resource.Organization,
+	details SpaceDetails,
+	instance *resource.ServiceInstance,
+	cost float64,
+) map[string]interface{} {
+	days := opts.InstancePurgeDays
+	if cost > 0 {
+		days = opts.CostlyInstancePurgeDays
+	}
+	return map[string]interface{}{
+		"org":       org,
+		"space":     details.Space,
+		"instance":  instance,
+		"days":      days,
+		"purgeDays": opts.PurgeDays,
+		"cost":      cost,
+		"spaceURL":  dashboardSpaceURL(opts.DashboardURL, org.GUID, details.Space.GUID),
+	}
+}
+
+// lintTemplates renders every email template against a synthetic space and
+// reports templates that fail to parse or execute, produce unbalanced HTML,
+// or exceed MAIL_MAX_BODY_BYTES, so a run fails before it touches CF rather
+// than after purges have started
+func lintTemplates(opts Config) error {
+	org := &resource.Organization{GUID: "lint-org-guid", Name: opts.OrgPrefix + "example"}
+	details := SpaceDetails{
+		Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Space:     &resource.Space{GUID: "lint-space-guid", Name: "jane.doe@example.gov"},
+	}
+	templates := []struct {
+		name string
+		data map[string]interface{}
+	}{
+		{notifyTemplateName, notifyTemplateData(opts, org, details)},
+		{purgeTemplateName, purgeTemplateData(opts, org, details)},
+	}
+	for _, tier := range opts.notifyTiers {
+		templates = append(templates, struct {
+			name string
+			data map[string]interface{}
+		}{tier.Template, notifyTemplateData(opts, org, details)})
+	}
+	instance := &resource.ServiceInstance{GUID: "lint-instance-guid", Name: "example-db"}
+	if opts.InstancePurgeDays > 0 {
+		templates = append(templates, struct {
+			name string
+			data map[string]interface{}
+		}{purgeInstanceTemplateName, purgeInstanceTemplateData(opts, org, details, instance, 0)})
+	}
+	if opts.costlyInstancePurgeEnabled() {
+		templates = append(templates, struct {
+			name string
+			data map[string]interface{}
+		}{purgeInstanceTemplateName, purgeInstanceTemplateData(opts, org, details, instance, opts.CostlyInstanceMinCost+1)})
+	}
+	if opts.welcomeEnabled() {
+		templates = append(templates, struct {
+			name string
+			data map[string]interface{}
+		}{welcomeTemplateName, welcomeTemplateData(opts, org, details)})
+	}
+	if opts.OperatorDigestOptions.enabled() {
+		changed := &SpaceContentsDiff{Since: details.Timestamp, AppsAdded: []string{"example-app"}, ServiceInstancesRemoved: []string{"example-db"}}
+		plan := &Plan{Actions: []PlannedAction{
+			{Action: planActionPurge, Org: org, Details: details, ContentsDiff: changed},
+			{Action: planActionPurge, Org: org, Details: details, ContentsDiff: &SpaceContentsDiff{Since: details.Timestamp}},
+			{Action: planActionPurge, Org: org, Details: details},
+		}}
+		templates = append(templates, struct {
+			name string
+			data map[string]interface{}
+		}{digestTemplateName, digestData(opts, plan)})
+	}
+
+	var problems []string
+	for _, t := range templates {
+		tmpl, err := parseMailTemplate(opts.TemplateDir, t.name)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		body, err := renderTemplate(tmpl, t.data)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		for _, problem := range checkHTML(body) {
+			problems = append(problems, fmt.Sprintf("%s: %s", t.name, problem))
+		}
+		if opts.MailMaxBodyBytes > 0 && len(body) > opts.MailMaxBodyBytes {
+			problems = append(problems, fmt.Sprintf("%s: rendered body is %d bytes, over the %d byte limit", t.name, len(body), opts.MailMaxBodyBytes))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid email templates:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// checkHTML reports tags in a rendered body that are never closed or closed
+// without being opened, with the rendered line they appear on
+func checkHTML(body string) []string {
+	type openTag struct {
+		name string
+		line int
+	}
+	var (
+		problems []string
+		open     []openTag
+		line     = 1
+	)
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				problems = append(problems, fmt.Sprintf("line %d: %s", line, err))
+			}
+			break
+		}
+		tokenLine := line
+		line += bytes.Count(z.Raw(), []byte("\n"))
+
+		name, _ := z.TagName()
+		tag := string(name)
+		switch tokenType {
+		case html.StartTagToken:
+			if !voidElements[tag] {
+				open = append(open, openTag{tag, tokenLine})
+			}
+		case html.EndTagToken:
+			i := len(open) - 1
+			for i >= 0 && open[i].name != tag {
+				i--
+			}
+			if i < 0 {
+				problems = append(problems, fmt.Sprintf("line %d: unexpected </%s>", tokenLine, tag))
+				continue
+			}
+			for _, unclosed := range open[i+1:] {
+				problems = append(problems, fmt.Sprintf("line %d: <%s> is never closed before </%s> on line %d", unclosed.line, unclosed.name, tag, tokenLine))
+			}
+			open = open[:i]
+		}
+	}
+	for _, tag := range open {
+		problems = append(problems, fmt.Sprintf("line %d: <%s> is never closed", tag.line, tag.name))
+	}
+	return problems
+}
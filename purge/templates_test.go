@@ -0,0 +1,164 @@
+package purge
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+func writeTemplates(t *testing.T, notify string, purge string) string {
+	t.Helper()
+	dir := t.TempDir()
+	base, err := os.ReadFile("../templates/base.html")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	files := map[string]string{
+		"base.html":        string(base),
+		notifyTemplateName: notify,
+		purgeTemplateName:  purge,
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	return dir
+}
+
+func TestLintTemplates(t *testing.T) {
+	valid := `{{define "content"}}<p>{{.org.Name}}/{{.space.Name}}</p>{{end}}`
+	testCases := map[string]struct {
+		templateDir        string
+		maxBytes           int
+		instancePurgeDays  int
+		welcomeMailSubject string
+		notifyTiers        []NotifyTier
+		expectedErr        string
+	}{
+		"repo templates": {
+			templateDir:        "../templates",
+			maxBytes:           102400,
+			instancePurgeDays:  60,
+			welcomeMailSubject: "Welcome to your sandbox",
+			notifyTiers:        []NotifyTier{{DaysLeft: 1, Template: "notify-final.tmpl"}},
+		},
+		"missing tier template": {
+			templateDir: writeTemplates(t, valid, valid),
+			notifyTiers: []NotifyTier{{DaysLeft: 1, Template: "notify-final.tmpl"}},
+			expectedErr: "invalid email templates:\n  open ",
+		},
+		"missing instance template": {
+			templateDir:       writeTemplates(t, valid, valid),
+			instancePurgeDays: 60,
+			expectedErr:       "invalid email templates:\n  open ",
+		},
+		"missing key": {
+			templateDir: writeTemplates(t, valid, `{{define "content"}}<p>{{.date}}</p>{{end}}`),
+			expectedErr: `invalid email templates:
+  template: purge.tmpl:1:25: executing "content" at <.date>: map has no entry for key "date"`,
+		},
+		"parse error": {
+			templateDir: writeTemplates(t, `{{define "content"}}{{.org.Name}{{end}}`, valid),
+			expectedErr: `invalid email templates:
+  template: notify.tmpl:1: bad character U+007D '}'`,
+		},
+		"unbalanced html": {
+			templateDir: writeTemplates(t, "{{define \"content\"}}\n<ul>\n<li>{{.days}}</ul>\n{{end}}", valid),
+			expectedErr: `invalid email templates:
+  notify.tmpl: line 10: <li> is never closed before </ul> on line 10`,
+		},
+		"oversized body": {
+			templateDir: "../templates",
+			maxBytes:    100,
+			expectedErr: "invalid email templates:\n  notify.tmpl: rendered body is",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			opts := Config{OrgPrefix: "sandbox-", PurgeDays: 30, TemplateDir: test.templateDir}
+			opts.MailMaxBodyBytes = test.maxBytes
+			opts.InstancePurgeDays = test.instancePurgeDays
+			opts.WelcomeMailSubject = test.welcomeMailSubject
+			opts.notifyTiers = test.notifyTiers
+			if test.templateDir == "../templates" {
+				opts.OperatorDigestRecipients = []string{"operators@example.gov"}
+			}
+			err := lintTemplates(opts)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || !strings.HasPrefix(err.Error(), test.expectedErr))) {
+				t.Fatalf("expected error %q, got: %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestNotifyTemplate(t *testing.T) {
+	opts := Config{
+		NotifyMailSubject: "Your sandbox will be cleared",
+		notifyTiers: []NotifyTier{
+			{DaysLeft: 7, Template: "notify-7d.tmpl"},
+			{DaysLeft: 1, Template: "notify-final.tmpl", Subject: "Last reminder: your sandbox will be cleared"},
+			{DaysLeft: 30, Template: "notify-30d.tmpl"},
+		},
+	}
+	testCases := map[string]struct {
+		daysLeft         int
+		expectedTemplate string
+		expectedSubject  string
+	}{
+		"before every tier": {
+			daysLeft:         45,
+			expectedTemplate: notifyTemplateName,
+			expectedSubject:  "Your sandbox will be cleared",
+		},
+		"first tier": {
+			daysLeft:         30,
+			expectedTemplate: "notify-30d.tmpl",
+			expectedSubject:  "Your sandbox will be cleared",
+		},
+		"closest tier": {
+			daysLeft:         5,
+			expectedTemplate: "notify-7d.tmpl",
+			expectedSubject:  "Your sandbox will be cleared",
+		},
+		"tier subject": {
+			daysLeft:         1,
+			expectedTemplate: "notify-final.tmpl",
+			expectedSubject:  "Last reminder: your sandbox will be cleared",
+		},
+		"overdue": {
+			daysLeft:         -2,
+			expectedTemplate: "notify-final.tmpl",
+			expectedSubject:  "Last reminder: your sandbox will be cleared",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			template, subject := opts.notifyTemplate(test.daysLeft)
+			if template != test.expectedTemplate || subject != test.expectedSubject {
+				t.Errorf("expected %s with %q, got %s with %q", test.expectedTemplate, test.expectedSubject, template, subject)
+			}
+		})
+	}
+}
+
+func TestParseMailTemplatePartials(t *testing.T) {
+	dir := writeTemplates(t, `{{define "content"}}<p>{{.org.Name}}</p>{{template "footer" .}}{{end}}`, "")
+	if err := os.WriteFile(filepath.Join(dir, "_footer.html"), []byte(`{{define "footer"}}<p>Thanks</p>{{end}}`), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tmpl, err := parseMailTemplate(dir, notifyTemplateName)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, err := renderTemplate(tmpl, map[string]interface{}{"org": &resource.Organization{Name: "sandbox-foo"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(body, "<p>sandbox-foo</p><p>Thanks</p>") {
+		t.Errorf("expected the footer partial after the content, got %s", body)
+	}
+}
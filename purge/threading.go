@@ -0,0 +1,61 @@
+package purge
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// mailThread identifies a message and the thread it belongs to; IDs are
+// derived from the space and notification tier, so successive mails about a
+// space thread together and a repeated send reuses its Message-ID
+type mailThread struct {
+	MessageID  string
+	InReplyTo  string
+	References []string
+}
+
+// headers returns the threading headers for a message, if any
+func (t mailThread) headers() map[string][]string {
+	headers := map[string][]string{}
+	if t.MessageID != "" {
+		headers["Message-ID"] = []string{t.MessageID}
+	}
+	if t.InReplyTo != "" {
+		headers["In-Reply-To"] = []string{t.InReplyTo}
+	}
+	if len(t.References) > 0 {
+		headers["References"] = []string{strings.Join(t.References, " ")}
+	}
+	return headers
+}
+
+// newMailThread returns the thread for a mail about a space; every mail in
+// a space's purge cycle, which starts at its first resource, refers back to
+// the same thread root
+func newMailThread(sender string, details SpaceDetails, tier string) mailThread {
+	domain := "cloud.gov"
+	if address, err := mail.ParseAddress(sender); err == nil {
+		domain = address.Address[strings.LastIndex(address.Address, "@")+1:]
+	}
+	cycle := fmt.Sprintf("%s.%d", details.Space.GUID, details.Timestamp.Unix())
+	root := fmt.Sprintf("<sandbox.%s@%s>", cycle, domain)
+	return mailThread{
+		MessageID:  fmt.Sprintf("<sandbox.%s.%s@%s>", cycle, tier, domain),
+		InReplyTo:  root,
+		References: []string{root},
+	}
+}
+
+// notifyTier names a purge warning by the days left until the purge, so each
+// day's reminder gets its own Message-ID
+func notifyTier(opts Config, details SpaceDetails, now time.Time) string {
+	return fmt.Sprintf("notify-%dd", daysUntilPurge(opts, details, now))
+}
+
+// daysUntilPurge is the number of whole days left until a space is purged
+func daysUntilPurge(opts Config, details SpaceDetails, now time.Time) int {
+	purgeDate := spacePurgeCutoff(details, opts.PurgeDays)
+	return int(purgeDate.Sub(now).Hours() / 24)
+}
@@ -0,0 +1,77 @@
+package purge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewMailThread(t *testing.T) {
+	details := SpaceDetails{
+		Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Space:     &resource.Space{GUID: "space-1"},
+	}
+	testCases := map[string]struct {
+		sender   string
+		tier     string
+		expected map[string][]string
+	}{
+		"warning": {
+			sender: "cloud.gov <no-reply@cloud.gov>",
+			tier:   "notify-5d",
+			expected: map[string][]string{
+				"Message-ID":  {"<sandbox.space-1.1704067200.notify-5d@cloud.gov>"},
+				"In-Reply-To": {"<sandbox.space-1.1704067200@cloud.gov>"},
+				"References":  {"<sandbox.space-1.1704067200@cloud.gov>"},
+			},
+		},
+		"purge from another domain": {
+			sender: "sandbox@agency.gov",
+			tier:   "purge",
+			expected: map[string][]string{
+				"Message-ID":  {"<sandbox.space-1.1704067200.purge@agency.gov>"},
+				"In-Reply-To": {"<sandbox.space-1.1704067200@agency.gov>"},
+				"References":  {"<sandbox.space-1.1704067200@agency.gov>"},
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			thread := newMailThread(test.sender, details, test.tier)
+			if diff := cmp.Diff(test.expected, thread.headers()); diff != "" {
+				t.Errorf("newMailThread() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNotifyTier(t *testing.T) {
+	opts := Config{PurgeDays: 30}
+	details := SpaceDetails{Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	testCases := map[string]struct {
+		now      time.Time
+		expected string
+	}{
+		"first warning": {
+			now:      time.Date(2024, 1, 26, 9, 0, 0, 0, time.UTC),
+			expected: "notify-4d",
+		},
+		"repeated the same day": {
+			now:      time.Date(2024, 1, 26, 17, 0, 0, 0, time.UTC),
+			expected: "notify-4d",
+		},
+		"next reminder": {
+			now:      time.Date(2024, 1, 27, 9, 0, 0, 0, time.UTC),
+			expected: "notify-3d",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if got := notifyTier(opts, details, test.now); got != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
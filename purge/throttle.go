@@ -0,0 +1,84 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errPurgeDeferred marks purges left for the next run because
+// PURGES_PER_HOUR couldn't fit them in before MAX_RUNTIME ran out
+var errPurgeDeferred = errors.New("deferred: purge rate limit reached before max runtime")
+
+// purgeThrottle spaces space purges evenly, so a run after a long gap
+// doesn't deprovision every expired space's services at once
+type purgeThrottle struct {
+	interval time.Duration
+	// deadline is when MAX_RUNTIME runs out, or zero
+	deadline time.Time
+	next     time.Time
+	now      func() time.Time
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+// newPurgeThrottle returns a throttle for PURGES_PER_HOUR, or nil if purges
+// aren't throttled
+func newPurgeThrottle(opts Config, startedAt time.Time) *purgeThrottle {
+	if opts.PurgesPerHour <= 0 || opts.DryRun {
+		return nil
+	}
+	t := &purgeThrottle{
+		interval: time.Hour / time.Duration(opts.PurgesPerHour),
+		now:      time.Now,
+		sleep:    sleepContext,
+	}
+	if opts.MaxRuntime > 0 {
+		t.deadline = startedAt.Add(opts.MaxRuntime)
+	}
+	return t
+}
+
+// wait blocks until the next purge may start, reporting whether it waited;
+// it returns errPurgeDeferred instead if that is past the deadline
+func (t *purgeThrottle) wait(ctx context.Context) (bool, error) {
+	if t == nil {
+		return false, nil
+	}
+	now := t.now()
+	if !t.deadline.IsZero() && t.next.After(t.deadline) {
+		return false, errPurgeDeferred
+	}
+	waited := false
+	if delay := t.next.Sub(now); delay > 0 {
+		if err := t.sleep(ctx, delay); err != nil {
+			return false, err
+		}
+		now = now.Add(delay)
+		waited = true
+	}
+	t.next = now.Add(t.interval)
+	return waited, nil
+}
+
+// reserve takes the next purge slot without waiting for it, or returns how
+// long until the slot comes; the purge server turns a request away rather
+// than hold it open that long
+func (t *purgeThrottle) reserve() time.Duration {
+	if t == nil {
+		return 0
+	}
+	now := t.now()
+	if delay := t.next.Sub(now); delay > 0 {
+		return delay
+	}
+	t.next = now.Add(t.interval)
+	return 0
+}
+
+// skipDeferred records a purge left for the next run; its space is still
+// past the purge age then, so it is planned again without another warning
+func skipDeferred(action PlannedAction, report *Report, status *runStatus) {
+	report.recordAction(action, errPurgeDeferred)
+	report.PurgesDeferred++
+	status.finishAction(action, report)
+}
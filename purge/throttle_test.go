@@ -0,0 +1,69 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewPurgeThrottle(t *testing.T) {
+	startedAt := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	if throttle := newPurgeThrottle(Config{}, startedAt); throttle != nil {
+		t.Errorf("expected no throttle without PURGES_PER_HOUR, got %+v", throttle)
+	}
+	if throttle := newPurgeThrottle(Config{PurgesPerHour: 12, DryRun: true}, startedAt); throttle != nil {
+		t.Errorf("expected no throttle for a dry run, got %+v", throttle)
+	}
+	throttle := newPurgeThrottle(Config{PurgesPerHour: 12, MaxRuntime: time.Hour}, startedAt)
+	if throttle.interval != 5*time.Minute || !throttle.deadline.Equal(startedAt.Add(time.Hour)) {
+		t.Errorf("unexpected throttle %+v", throttle)
+	}
+}
+
+func TestPurgeThrottleWait(t *testing.T) {
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	var slept []time.Duration
+	throttle := &purgeThrottle{
+		interval: 20 * time.Minute,
+		deadline: now.Add(time.Hour),
+		now:      func() time.Time { return now },
+		sleep: func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			now = now.Add(d)
+			return nil
+		},
+	}
+
+	var waits []bool
+	var err error
+	for i := 0; i < 5; i++ {
+		var waited bool
+		waited, err = throttle.wait(context.Background())
+		if err != nil {
+			break
+		}
+		waits = append(waits, waited)
+		// each purge takes five minutes
+		now = now.Add(5 * time.Minute)
+	}
+	if !errors.Is(err, errPurgeDeferred) {
+		t.Errorf("expected the purge after the deadline to be deferred, got %v", err)
+	}
+	if diff := cmp.Diff([]bool{false, true, true, true}, waits); diff != "" {
+		t.Errorf("waits mismatch (-want +got):\n%s", diff)
+	}
+	expected := []time.Duration{15 * time.Minute, 15 * time.Minute, 15 * time.Minute}
+	if diff := cmp.Diff(expected, slept); diff != "" {
+		t.Errorf("sleeps mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPurgeThrottleNil(t *testing.T) {
+	var throttle *purgeThrottle
+	if waited, err := throttle.wait(context.Background()); waited || err != nil {
+		t.Errorf("expected a nil throttle not to wait, got %t, %v", waited, err)
+	}
+}
@@ -0,0 +1,204 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// Kinds of timeline events
+const (
+	timelinePush   = "push"
+	timelineDelete = "delete"
+	timelineRole   = "role"
+)
+
+// timelineEventKinds maps the CF audit event types that show how a space was
+// used, and who changed its access, to their kind in a timeline
+var timelineEventKinds = map[string]string{
+	"audit.app.create":                  timelinePush,
+	"audit.app.upload-bits":             timelinePush,
+	"audit.app.build.create":            timelinePush,
+	"audit.app.droplet.create":          timelinePush,
+	"audit.app.restage":                 timelinePush,
+	"audit.app.start":                   timelinePush,
+	"audit.service_instance.create":     timelinePush,
+	"audit.route.create":                timelinePush,
+	"audit.service_key.create":          timelinePush,
+	"audit.app.delete-request":          timelineDelete,
+	"audit.service_instance.delete":     timelineDelete,
+	"audit.route.delete-request":        timelineDelete,
+	"audit.service_key.delete":          timelineDelete,
+	"audit.space.delete-request":        timelineDelete,
+	"audit.user.space_developer_add":    timelineRole,
+	"audit.user.space_developer_remove": timelineRole,
+	"audit.user.space_manager_add":      timelineRole,
+	"audit.user.space_manager_remove":   timelineRole,
+	"audit.user.space_auditor_add":      timelineRole,
+	"audit.user.space_auditor_remove":   timelineRole,
+	"audit.user.space_supporter_add":    timelineRole,
+	"audit.user.space_supporter_remove": timelineRole,
+}
+
+// TimelineEvent is one audit event in a space's timeline
+type TimelineEvent struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Type   string    `json:"type"`
+	Actor  string    `json:"actor"`
+	Target string    `json:"target"`
+}
+
+// listSpaceTimeline lists a space's pushes, deletes, and role changes since
+// a time, oldest first
+func listSpaceTimeline(ctx context.Context, cfClient *cfResourceClient, spaceGUID string, since time.Time) ([]TimelineEvent, error) {
+	eventListOptions := client.NewAuditEventListOptions()
+	eventListOptions.SpaceGUIDs.EqualTo(spaceGUID)
+	eventListOptions.CreateAts.AfterOrEqualTo(since)
+	for eventType := range timelineEventKinds {
+		eventListOptions.Types.Values = append(eventListOptions.Types.Values, eventType)
+	}
+	sort.Strings(eventListOptions.Types.Values)
+	events, err := cfClient.AuditEvents.ListAll(ctx, eventListOptions)
+	if err != nil {
+		return nil, err
+	}
+	timeline := []TimelineEvent{}
+	for _, event := range events {
+		kind, ok := timelineEventKinds[event.Type]
+		if !ok {
+			continue
+		}
+		timeline = append(timeline, TimelineEvent{
+			Time:   event.CreatedAt,
+			Kind:   kind,
+			Type:   event.Type,
+			Actor:  auditObjectName(event.Actor),
+			Target: auditObjectName(event.Target),
+		})
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Time.Before(timeline[j].Time)
+	})
+	return timeline, nil
+}
+
+// auditObjectName describes an audit event's actor or target by name,
+// falling back to its GUID
+func auditObjectName(object resource.AuditEventRelatedObject) string {
+	name := object.Name
+	if name == "" {
+		name = object.GUID
+	}
+	if object.Type == "" {
+		return name
+	}
+	return object.Type + " " + name
+}
+
+// TimelineConfig describes configuration for listing a space's timeline
+type TimelineConfig struct {
+	CFOptions
+	OrgPrefix     string `env:"ORG_PREFIX, required"`
+	TimelineOrg   string
+	TimelineSpace string
+	// TimelineSpaceGUID picks the space by GUID, so a purged space's
+	// history can be listed after it has been recreated
+	TimelineSpaceGUID string
+	TimelineDays      int `env:"TRIAGE_TIMELINE_DAYS, default=30"`
+}
+
+func (c TimelineConfig) validate() error {
+	if c.TimelineSpaceGUID == "" && (c.TimelineOrg == "" || c.TimelineSpace == "") {
+		return errors.New("an org and space, or a space GUID, are required")
+	}
+	if c.TimelineDays <= 0 {
+		return fmt.Errorf("days must be positive, got %d", c.TimelineDays)
+	}
+	return nil
+}
+
+// SpaceTimeline is a space's pushes, deletes, and role changes over a window
+type SpaceTimeline struct {
+	Org       string          `json:"org,omitempty"`
+	Space     string          `json:"space,omitempty"`
+	SpaceGUID string          `json:"space_guid"`
+	Since     time.Time       `json:"since"`
+	Events    []TimelineEvent `json:"events"`
+}
+
+// Timeline lists a space's audit events over the last TimelineDays, to
+// answer a disputed purge
+func Timeline(ctx context.Context, cfg TimelineConfig) (SpaceTimeline, error) {
+	if err := cfg.validate(); err != nil {
+		return SpaceTimeline{}, fmt.Errorf("error parsing options: %w", err)
+	}
+	cfClient, err := newCFClient(cfg.CFOptions, nil)
+	if err != nil {
+		return SpaceTimeline{}, fmt.Errorf("error creating client: %w", err)
+	}
+	return spaceTimeline(ctx, cfClient, cfg, time.Now())
+}
+
+func spaceTimeline(ctx context.Context, cfClient *cfResourceClient, cfg TimelineConfig, now time.Time) (SpaceTimeline, error) {
+	timeline := SpaceTimeline{
+		Org:       cfg.TimelineOrg,
+		Space:     cfg.TimelineSpace,
+		SpaceGUID: cfg.TimelineSpaceGUID,
+		Since:     now.UTC().AddDate(0, 0, -cfg.TimelineDays),
+	}
+	if timeline.SpaceGUID == "" {
+		if !strings.HasPrefix(cfg.TimelineOrg, cfg.OrgPrefix) {
+			return SpaceTimeline{}, fmt.Errorf("org %s is not a sandbox org", cfg.TimelineOrg)
+		}
+		orgListOptions := client.NewOrganizationListOptions()
+		orgListOptions.Names.EqualTo(cfg.TimelineOrg)
+		org, err := cfClient.Organizations.Single(ctx, orgListOptions)
+		if err != nil {
+			return SpaceTimeline{}, fmt.Errorf("error getting org %s: %w", cfg.TimelineOrg, err)
+		}
+		spaceListOptions := client.NewSpaceListOptions()
+		spaceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+		spaceListOptions.Names.EqualTo(cfg.TimelineSpace)
+		spaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+		if err != nil {
+			return SpaceTimeline{}, fmt.Errorf("error listing spaces in org %s: %w", org.Name, err)
+		}
+		for _, space := range spaces {
+			if space.Name == cfg.TimelineSpace {
+				timeline.SpaceGUID = space.GUID
+			}
+		}
+		if timeline.SpaceGUID == "" {
+			return SpaceTimeline{}, fmt.Errorf("space %s in org %s: %w", cfg.TimelineSpace, org.Name, errSpaceNotFound)
+		}
+	}
+	events, err := listSpaceTimeline(ctx, cfClient, timeline.SpaceGUID, timeline.Since)
+	if err != nil {
+		return SpaceTimeline{}, fmt.Errorf("error listing audit events for space %s: %w", timeline.SpaceGUID, err)
+	}
+	timeline.Events = events
+	return timeline, nil
+}
+
+// WriteText writes the timeline one event per line
+func (t SpaceTimeline) WriteText(w io.Writer) error {
+	var b strings.Builder
+	target := t.SpaceGUID
+	if t.Space != "" {
+		target = fmt.Sprintf("%s/%s (%s)", t.Org, t.Space, t.SpaceGUID)
+	}
+	fmt.Fprintf(&b, "%d events for %s since %s\n", len(t.Events), target, t.Since.Format(time.RFC3339))
+	for _, event := range t.Events {
+		fmt.Fprintf(&b, "  %s  %-6s  %s  %s by %s\n", event.Time.UTC().Format(time.RFC3339), event.Kind, event.Type, event.Target, event.Actor)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,115 @@
+package purge
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSpaceTimeline(t *testing.T) {
+	now := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	events := &mockAuditEvents{events: []*resource.AuditEvent{
+		{
+			CreatedAt: now.Add(-time.Hour),
+			Type:      "audit.space.delete-request",
+			Actor:     resource.AuditEventRelatedObject{GUID: "client-1", Type: "user", Name: "sandbox-bot"},
+			Target:    resource.AuditEventRelatedObject{GUID: "space-1", Type: "space", Name: "jane.doe"},
+		},
+		{
+			CreatedAt: now.AddDate(0, 0, -20),
+			Type:      "audit.app.create",
+			Actor:     resource.AuditEventRelatedObject{GUID: "user-1", Type: "user", Name: "jane.doe@agency.gov"},
+			Target:    resource.AuditEventRelatedObject{GUID: "app-1", Type: "app", Name: "web"},
+		},
+		{
+			CreatedAt: now.AddDate(0, 0, -10),
+			Type:      "audit.app.ssh-authorized",
+			Actor:     resource.AuditEventRelatedObject{GUID: "user-1", Type: "user", Name: "jane.doe@agency.gov"},
+			Target:    resource.AuditEventRelatedObject{GUID: "app-1", Type: "app", Name: "web"},
+		},
+		{
+			CreatedAt: now.AddDate(0, 0, -5),
+			Type:      "audit.user.space_developer_add",
+			Actor:     resource.AuditEventRelatedObject{GUID: "user-2", Type: "user", Name: "manager@agency.gov"},
+			Target:    resource.AuditEventRelatedObject{GUID: "user-3", Type: "user"},
+		},
+	}}
+	cfClient := &cfResourceClient{
+		Organizations: &mockOrganizations{org: &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}},
+		Spaces:        &mockSpaces{spaces: []*resource.Space{{GUID: "space-1", Name: "jane.doe"}}},
+		AuditEvents:   events,
+	}
+
+	testCases := map[string]struct {
+		cfg          TimelineConfig
+		expectedGUID string
+		expectedErr  string
+	}{
+		"by name": {
+			cfg:          TimelineConfig{OrgPrefix: "sandbox-", TimelineOrg: "sandbox-agency", TimelineSpace: "jane.doe", TimelineDays: 30},
+			expectedGUID: "space-1",
+		},
+		"by guid": {
+			cfg:          TimelineConfig{OrgPrefix: "sandbox-", TimelineSpaceGUID: "purged-space-1", TimelineDays: 30},
+			expectedGUID: "purged-space-1",
+		},
+		"space missing": {
+			cfg:         TimelineConfig{OrgPrefix: "sandbox-", TimelineOrg: "sandbox-agency", TimelineSpace: "john.doe", TimelineDays: 30},
+			expectedErr: "space john.doe in org sandbox-agency: space not found",
+		},
+		"not a sandbox org": {
+			cfg:         TimelineConfig{OrgPrefix: "sandbox-", TimelineOrg: "cloud-gov", TimelineSpace: "jane.doe", TimelineDays: 30},
+			expectedErr: "org cloud-gov is not a sandbox org",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			timeline, err := spaceTimeline(context.Background(), cfClient, test.cfg, now)
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if timeline.SpaceGUID != test.expectedGUID {
+				t.Errorf("expected space %s, got %s", test.expectedGUID, timeline.SpaceGUID)
+			}
+			expected := []TimelineEvent{
+				{Time: now.AddDate(0, 0, -20), Kind: timelinePush, Type: "audit.app.create", Actor: "user jane.doe@agency.gov", Target: "app web"},
+				{Time: now.AddDate(0, 0, -5), Kind: timelineRole, Type: "audit.user.space_developer_add", Actor: "user manager@agency.gov", Target: "user user-3"},
+				{Time: now.Add(-time.Hour), Kind: timelineDelete, Type: "audit.space.delete-request", Actor: "user sandbox-bot", Target: "space jane.doe"},
+			}
+			if diff := cmp.Diff(expected, timeline.Events); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSpaceTimelineWriteText(t *testing.T) {
+	timeline := SpaceTimeline{
+		Org:       "sandbox-agency",
+		Space:     "jane.doe",
+		SpaceGUID: "space-1",
+		Since:     time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		Events: []TimelineEvent{
+			{Time: time.Date(2024, 2, 10, 12, 0, 0, 0, time.UTC), Kind: timelinePush, Type: "audit.app.create", Actor: "user jane.doe@agency.gov", Target: "app web"},
+		},
+	}
+	var b strings.Builder
+	if err := timeline.WriteText(&b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "1 events for sandbox-agency/jane.doe (space-1) since 2024-02-01T00:00:00Z\n" +
+		"  2024-02-10T12:00:00Z  push    audit.app.create  app web by user jane.doe@agency.gov\n"
+	if diff := cmp.Diff(expected, b.String()); diff != "" {
+		t.Errorf("WriteText mismatch (-want +got):\n%s", diff)
+	}
+}
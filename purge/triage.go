@@ -0,0 +1,194 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// TriageOptions describes where diagnostic bundles for failed space actions
+// are written, so engineers can triage without re-querying an environment
+// that has since changed
+type TriageOptions struct {
+	TriageDir    string `env:"TRIAGE_DIR"`
+	TriageBucket string `env:"TRIAGE_BUCKET"`
+	TriagePrefix string `env:"TRIAGE_PREFIX, default=sandbox-triage/"`
+	// TriageEventsWindow is how far back a bundle's audit events go
+	TriageEventsWindow time.Duration `env:"TRIAGE_EVENTS_WINDOW, default=24h"`
+	// TriageTimelineDays is how far back a bundle's timeline of pushes,
+	// deletes, and role changes goes
+	TriageTimelineDays int `env:"TRIAGE_TIMELINE_DAYS, default=30"`
+}
+
+// enabled reports whether triage bundles are written
+func (o TriageOptions) enabled() bool {
+	return o.TriageDir != "" || o.TriageBucket != ""
+}
+
+// TriageBundle captures the state around a failed space action
+type TriageBundle struct {
+	CreatedAt       time.Time `json:"created_at"`
+	Org             string    `json:"org"`
+	OrgGUID         string    `json:"org_guid"`
+	Space           string    `json:"space,omitempty"`
+	SpaceGUID       string    `json:"space_guid,omitempty"`
+	Action          string    `json:"action"`
+	ServiceInstance string    `json:"service_instance,omitempty"`
+	Error           string    `json:"error"`
+	// APIResponses are the failed CF API responses and job states received
+	// while the action ran
+	APIResponses []APIResponse          `json:"api_responses"`
+	Inventory    *TriageInventory       `json:"inventory,omitempty"`
+	AuditEvents  []*resource.AuditEvent `json:"audit_events"`
+	Timeline     []TimelineEvent        `json:"timeline"`
+	// CollectionErrors lists the parts of the bundle that couldn't be gathered
+	CollectionErrors []string `json:"collection_errors,omitempty"`
+}
+
+// TriageInventory is a space's resources as listed after the failure
+type TriageInventory struct {
+	Apps             []*resource.App             `json:"apps"`
+	ServiceInstances []*resource.ServiceInstance `json:"service_instances"`
+	Routes           []*resource.Route           `json:"routes"`
+}
+
+// triageCollector writes a bundle for each failed space action in a run
+type triageCollector struct {
+	opts         TriageOptions
+	s3           S3Options
+	apiCalls     *apiCallStats
+	runStartedAt time.Time
+}
+
+// newTriageCollector returns a collector for a run, or nil if triage
+// bundles are disabled; it has apiCalls start remembering failed responses
+func newTriageCollector(opts Config, apiCalls *apiCallStats, runStartedAt time.Time) *triageCollector {
+	if !opts.TriageOptions.enabled() {
+		return nil
+	}
+	apiCalls.keepResponses = true
+	return &triageCollector{
+		opts:         opts.TriageOptions,
+		s3:           opts.S3Options,
+		apiCalls:     apiCalls,
+		runStartedAt: runStartedAt,
+	}
+}
+
+// collect gathers and writes the bundle for an action that failed with
+// actionErr after starting at started
+func (c *triageCollector) collect(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	action PlannedAction,
+	actionErr error,
+	started time.Time,
+) error {
+	if c == nil {
+		return nil
+	}
+	bundle := TriageBundle{
+		CreatedAt:    time.Now(),
+		Org:          action.Org.Name,
+		OrgGUID:      action.Org.GUID,
+		Action:       action.Action,
+		Error:        actionErr.Error(),
+		APIResponses: c.apiCalls.responsesSince(started),
+		AuditEvents:  []*resource.AuditEvent{},
+		Timeline:     []TimelineEvent{},
+	}
+	if action.ServiceInstance != nil {
+		bundle.ServiceInstance = action.ServiceInstance.Name
+	}
+	if space := action.Details.Space; space != nil {
+		bundle.Space = space.Name
+		bundle.SpaceGUID = space.GUID
+		c.collectSpace(ctx, cfClient, &bundle)
+	}
+
+	contents, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding triage bundle: %w", err)
+	}
+	name := c.bundleName(action)
+	if c.opts.TriageDir != "" {
+		file := filepath.Join(c.opts.TriageDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return fmt.Errorf("error writing triage bundle %s: %w", file, err)
+		}
+		if err := os.WriteFile(file, contents, 0644); err != nil {
+			return fmt.Errorf("error writing triage bundle %s: %w", file, err)
+		}
+		actionFields(action).printf("wrote triage bundle for %s to %s", action.target(), file)
+	}
+	if c.opts.TriageBucket != "" {
+		key := path.Join(c.opts.TriagePrefix, name)
+		if err := newS3Client(c.s3).putObject(ctx, c.opts.TriageBucket, key, "application/json", contents); err != nil {
+			return err
+		}
+		actionFields(action).printf("wrote triage bundle for %s to s3://%s/%s", action.target(), c.opts.TriageBucket, key)
+	}
+	return nil
+}
+
+// collectSpace adds a space's current resources, recent audit events, and
+// timeline to a bundle
+func (c *triageCollector) collectSpace(ctx context.Context, cfClient *cfResourceClient, bundle *TriageBundle) {
+	inventory := &TriageInventory{}
+	appListOptions := client.NewAppListOptions()
+	appListOptions.SpaceGUIDs.EqualTo(bundle.SpaceGUID)
+	instanceListOptions := client.NewServiceInstanceListOptions()
+	instanceListOptions.SpaceGUIDs.EqualTo(bundle.SpaceGUID)
+	routeListOptions := client.NewRouteListOptions()
+	routeListOptions.SpaceGUIDs.EqualTo(bundle.SpaceGUID)
+	eventListOptions := client.NewAuditEventListOptions()
+	eventListOptions.SpaceGUIDs.EqualTo(bundle.SpaceGUID)
+	eventListOptions.CreateAts.AfterOrEqualTo(bundle.CreatedAt.Add(-c.opts.TriageEventsWindow))
+
+	var err error
+	if inventory.Apps, err = cfClient.Applications.ListAll(ctx, appListOptions); err != nil {
+		bundle.CollectionErrors = append(bundle.CollectionErrors, fmt.Sprintf("error listing apps: %s", err))
+	}
+	if inventory.ServiceInstances, err = cfClient.ServiceInstances.ListAll(ctx, instanceListOptions); err != nil {
+		bundle.CollectionErrors = append(bundle.CollectionErrors, fmt.Sprintf("error listing service instances: %s", err))
+	}
+	if inventory.Routes, err = cfClient.Routes.ListAll(ctx, routeListOptions); err != nil {
+		bundle.CollectionErrors = append(bundle.CollectionErrors, fmt.Sprintf("error listing routes: %s", err))
+	}
+	bundle.Inventory = inventory
+	events, err := cfClient.AuditEvents.ListAll(ctx, eventListOptions)
+	if err != nil {
+		bundle.CollectionErrors = append(bundle.CollectionErrors, fmt.Sprintf("error listing audit events: %s", err))
+	} else {
+		bundle.AuditEvents = events
+	}
+	timeline, err := listSpaceTimeline(ctx, cfClient, bundle.SpaceGUID, bundle.CreatedAt.AddDate(0, 0, -c.opts.TriageTimelineDays))
+	if err != nil {
+		bundle.CollectionErrors = append(bundle.CollectionErrors, fmt.Sprintf("error listing timeline events: %s", err))
+	} else {
+		bundle.Timeline = timeline
+	}
+}
+
+// bundleName returns the relative path of an action's bundle, grouped by run
+// and named by GUID, since CF names may contain any character
+func (c *triageCollector) bundleName(action PlannedAction) string {
+	target := action.Org.GUID
+	if action.Details.Space != nil {
+		target = action.Details.Space.GUID
+	}
+	if action.ServiceInstance != nil {
+		target = action.ServiceInstance.GUID
+	}
+	return path.Join(
+		runID(c.runStartedAt),
+		fmt.Sprintf("%s-%s.json", action.Action, target),
+	)
+}
@@ -0,0 +1,83 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockAuditEvents struct {
+	events  []*resource.AuditEvent
+	listErr error
+}
+
+func (e *mockAuditEvents) ListAll(ctx context.Context, opts *client.AuditEventListOptions) ([]*resource.AuditEvent, error) {
+	return e.events, e.listErr
+}
+
+func TestTriageCollect(t *testing.T) {
+	dir := t.TempDir()
+	runStartedAt := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	stats := newAPICallStats()
+	triage := newTriageCollector(Config{TriageOptions: TriageOptions{TriageDir: dir, TriageEventsWindow: time.Hour}}, stats, runStartedAt)
+	if !stats.keepResponses {
+		t.Fatalf("expected collector to keep API responses")
+	}
+	started := time.Now()
+	stats.recordResponse(APIResponse{Time: started, Method: http.MethodDelete, Path: "/v3/spaces/space-1", Status: 422})
+
+	cfClient := &cfResourceClient{
+		Applications:     &mockApplications{apps: []*resource.App{{GUID: "app-1", Name: "app"}}},
+		ServiceInstances: &mockServiceInstances{},
+		Routes:           &mockRoutes{},
+		AuditEvents:      &mockAuditEvents{listErr: errors.New("forbidden")},
+	}
+	action := PlannedAction{
+		Action:  planActionPurge,
+		Org:     &resource.Organization{GUID: "org-1", Name: "sandbox-agency"},
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "jane.doe"}},
+	}
+	if err := triage.collect(context.Background(), cfClient, action, errors.New("error deleting space"), started); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, "20240301T060000Z", "purge-space-1.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var bundle TriageBundle
+	if err := json.Unmarshal(contents, &bundle); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if bundle.Space != "jane.doe" || bundle.Error != "error deleting space" {
+		t.Errorf("unexpected bundle %+v", bundle)
+	}
+	if len(bundle.APIResponses) != 1 || bundle.APIResponses[0].Status != 422 {
+		t.Errorf("expected the failed delete response, got %+v", bundle.APIResponses)
+	}
+	if bundle.Inventory == nil || len(bundle.Inventory.Apps) != 1 {
+		t.Errorf("expected the space's apps, got %+v", bundle.Inventory)
+	}
+	if diff := cmp.Diff([]string{"error listing audit events: forbidden", "error listing timeline events: forbidden"}, bundle.CollectionErrors); diff != "" {
+		t.Errorf("CollectionErrors mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTriageCollectDisabled(t *testing.T) {
+	if triage := newTriageCollector(Config{}, newAPICallStats(), time.Now()); triage != nil {
+		t.Fatalf("expected no collector without TRIAGE_DIR or TRIAGE_BUCKET")
+	}
+	var triage *triageCollector
+	if err := triage.collect(context.Background(), nil, PlannedAction{}, errors.New("failed"), time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,154 @@
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// UAAUserCheckOptions describes checking that the users whose roles a purge
+// recreates are still active in UAA
+type UAAUserCheckOptions struct {
+	// UAAUserCheck drops recreated roles held by users who are deactivated
+	// or deleted in UAA; the client needs the scim.read scope
+	UAAUserCheck bool `env:"UAA_USER_CHECK, default=false"`
+	// UAAAddress overrides the UAA address advertised by the CF API
+	UAAAddress string `env:"UAA_ADDRESS"`
+}
+
+// uaaUserChecker looks up users in UAA, remembering each user's status for
+// the rest of the run
+type uaaUserChecker struct {
+	cfClient   *cfResourceClient
+	httpClient *http.Client
+	address    string
+	// inactive holds why each looked-up user is inactive, or "" for users
+	// who are active
+	inactive map[string]string
+}
+
+// newUAAUserChecker returns a checker, or nil when UAA_USER_CHECK is off;
+// the UAA address is found on the first lookup, so runs that purge nothing
+// don't need UAA
+func newUAAUserChecker(opts UAAUserCheckOptions, cfClient *cfResourceClient) *uaaUserChecker {
+	if !opts.UAAUserCheck {
+		return nil
+	}
+	return &uaaUserChecker{
+		cfClient:   cfClient,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		address:    opts.UAAAddress,
+		inactive:   map[string]string{},
+	}
+}
+
+// uaaUser is the subset of a UAA /Users response used to check a user
+type uaaUser struct {
+	Active bool `json:"active"`
+}
+
+// inactiveReason returns why a user can't sign in, or "" if they are active
+func (c *uaaUserChecker) inactiveReason(ctx context.Context, userGUID string) (string, error) {
+	if reason, ok := c.inactive[userGUID]; ok {
+		return reason, nil
+	}
+	if c.address == "" {
+		root, err := c.cfClient.Root.Get(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error finding UAA address: %w", err)
+		}
+		c.address = root.Links.Uaa.Href
+	}
+	token, err := c.cfClient.Auth.AccessToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting token: %w", err)
+	}
+	reason, err := readUAAUserStatus(ctx, c.httpClient, c.address, token, userGUID)
+	if err != nil {
+		return "", err
+	}
+	c.inactive[userGUID] = reason
+	return reason, nil
+}
+
+// readUAAUserStatus reads a user from UAA and returns why they are inactive,
+// or "" if they are active
+func readUAAUserStatus(
+	ctx context.Context,
+	httpClient *http.Client,
+	uaaAddress string,
+	token string,
+	userGUID string,
+) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(uaaAddress, "/")+"/Users/"+url.PathEscape(userGUID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error getting UAA user %s: %w", userGUID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "deleted in UAA", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("error getting UAA user %s: %s: %s", userGUID, resp.Status, body)
+	}
+	var user uaaUser
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", fmt.Errorf("error decoding UAA user %s: %w", userGUID, err)
+	}
+	if !user.Active {
+		return "deactivated in UAA", nil
+	}
+	return "", nil
+}
+
+// activeRoles returns the developers and managers a purge should recreate,
+// leaving out users who are inactive in UAA and noting each dropped role in
+// the report. It is called before users are emailed and the space is
+// deleted, so a failed lookup fails the purge rather than leaving a
+// recreated space without its roles
+func (c *uaaUserChecker) activeRoles(
+	ctx context.Context,
+	action PlannedAction,
+	report *Report,
+) ([]spaceUser, []spaceUser, error) {
+	if c == nil {
+		return action.Developers, action.Managers, nil
+	}
+	keep := func(users []spaceUser, role string) ([]spaceUser, error) {
+		var active []spaceUser
+		for _, user := range users {
+			reason, err := c.inactiveReason(ctx, user.GUID)
+			if err != nil {
+				return nil, err
+			}
+			if reason == "" {
+				active = append(active, user)
+				continue
+			}
+			actionFields(action).printf("not recreating %s role for %s in space %s: %s", role, user.Username, action.Details.Space.Name, reason)
+			report.RolesDropped = append(report.RolesDropped, fmt.Sprintf("%s/%s %s %s (%s)", action.Org.Name, action.Details.Space.Name, role, user.Username, reason))
+		}
+		return active, nil
+	}
+	developers, err := keep(action.Developers, "developer")
+	if err != nil {
+		return nil, nil, err
+	}
+	managers, err := keep(action.Managers, "manager")
+	if err != nil {
+		return nil, nil, err
+	}
+	return developers, managers, nil
+}
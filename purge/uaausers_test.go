@@ -0,0 +1,137 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newUAAUsersServer(t *testing.T, lookups map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected authorization header: %s", r.Header.Get("Authorization"))
+		}
+		lookups[r.URL.Path]++
+		switch r.URL.Path {
+		case "/Users/active":
+			fmt.Fprint(w, `{"id": "active", "active": true}`)
+		case "/Users/deactivated":
+			fmt.Fprint(w, `{"id": "deactivated", "active": false}`)
+		case "/Users/broken":
+			http.Error(w, "boom", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestReadUAAUserStatus(t *testing.T) {
+	server := newUAAUsersServer(t, map[string]int{})
+	defer server.Close()
+
+	testCases := map[string]struct {
+		expected    string
+		expectedErr bool
+	}{
+		"active":      {},
+		"deactivated": {expected: "deactivated in UAA"},
+		"deleted":     {expected: "deleted in UAA"},
+		"broken":      {expectedErr: true},
+	}
+	for guid, test := range testCases {
+		t.Run(guid, func(t *testing.T) {
+			reason, err := readUAAUserStatus(context.Background(), server.Client(), server.URL+"/", "token", guid)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if reason != test.expected {
+				t.Errorf("expected reason %q, got %q", test.expected, reason)
+			}
+		})
+	}
+}
+
+func TestActiveRoles(t *testing.T) {
+	lookups := map[string]int{}
+	server := newUAAUsersServer(t, lookups)
+	defer server.Close()
+
+	checker := newUAAUserChecker(UAAUserCheckOptions{UAAUserCheck: true, UAAAddress: server.URL}, &cfResourceClient{Auth: &mockAuth{}})
+	action := PlannedAction{
+		Action:     planActionPurge,
+		Org:        &resource.Organization{GUID: "org-1", Name: "sandbox-agency"},
+		Details:    SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+		Developers: []spaceUser{{GUID: "active", Username: "a@example.gov"}, {GUID: "deactivated", Username: "b@example.gov"}},
+		Managers:   []spaceUser{{GUID: "active", Username: "a@example.gov"}, {GUID: "deleted", Username: "c@example.gov"}},
+	}
+	report := &Report{}
+	developers, managers, err := checker.activeRoles(context.Background(), action, report)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(action.Developers[:1], developers); diff != "" {
+		t.Errorf("developers mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(action.Managers[:1], managers); diff != "" {
+		t.Errorf("managers mismatch (-want +got):\n%s", diff)
+	}
+	expected := []string{
+		"sandbox-agency/foo developer b@example.gov (deactivated in UAA)",
+		"sandbox-agency/foo manager c@example.gov (deleted in UAA)",
+	}
+	if diff := cmp.Diff(expected, report.RolesDropped); diff != "" {
+		t.Errorf("dropped roles mismatch (-want +got):\n%s", diff)
+	}
+	if lookups["/Users/active"] != 1 {
+		t.Errorf("expected each user to be looked up once, got %d lookups", lookups["/Users/active"])
+	}
+
+	action.Developers = []spaceUser{{GUID: "broken", Username: "d@example.gov"}}
+	if _, _, err := checker.activeRoles(context.Background(), action, report); err == nil {
+		t.Errorf("expected a failed lookup to fail the check")
+	}
+}
+
+func TestActiveRolesWithoutCheck(t *testing.T) {
+	var checker *uaaUserChecker
+	action := PlannedAction{Developers: []spaceUser{{GUID: "user-1"}}, Managers: []spaceUser{{GUID: "user-2"}}}
+	developers, managers, err := checker.activeRoles(context.Background(), action, &Report{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(action.Developers, developers); diff != "" {
+		t.Errorf("developers mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(action.Managers, managers); diff != "" {
+		t.Errorf("managers mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyPurgeUserCheckFailsBeforeEmail(t *testing.T) {
+	server := newUAAUsersServer(t, map[string]int{})
+	defer server.Close()
+
+	cfClient := &cfResourceClient{Auth: &mockAuth{}}
+	opts := Config{TemplateDir: "../templates"}
+	opts.userCheck = newUAAUserChecker(UAAUserCheckOptions{UAAUserCheck: true, UAAAddress: server.URL}, cfClient)
+	action := PlannedAction{
+		Action:     planActionPurge,
+		Org:        &resource.Organization{GUID: "org-1", Name: "sandbox-agency"},
+		Details:    SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+		Developers: []spaceUser{{GUID: "broken", Username: "d@example.gov"}},
+		Recipients: []string{"d@example.gov"},
+	}
+	mailSender := &recordingMailer{}
+
+	if err := applyPurge(context.Background(), cfClient, opts, action, mailSender, &Report{}); err == nil {
+		t.Fatal("expected a failed lookup to fail the purge")
+	}
+	if len(mailSender.recipients) > 0 {
+		t.Errorf("expected no purge email before the users were checked, got one to %v", mailSender.recipients)
+	}
+}
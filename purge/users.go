@@ -0,0 +1,395 @@
+package purge
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// UsersConfig describes configuration for reconciling sandbox org membership
+// against an allowlist
+type UsersConfig struct {
+	CFOptions
+	OrgPrefix string `env:"ORG_PREFIX, required"`
+	DryRun    bool   `env:"DRY_RUN, default=true"`
+	// UsersAllowlistFile lists allowed usernames, one per line
+	UsersAllowlistFile string `env:"USERS_ALLOWLIST_FILE"`
+	// UsersAllowlistGroup names a UAA group whose members are allowed
+	UsersAllowlistGroup string `env:"USERS_ALLOWLIST_UAA_GROUP"`
+	// UAAAddress overrides the UAA address advertised by the CF API
+	UAAAddress string `env:"UAA_ADDRESS"`
+	FoundationOptions
+	KillSwitchOptions
+	S3Options
+}
+
+// Validate checks that exactly one allowlist source is configured
+func (c UsersConfig) Validate() error {
+	if (c.UsersAllowlistFile == "") == (c.UsersAllowlistGroup == "") {
+		return errors.New("exactly one of USERS_ALLOWLIST_FILE or USERS_ALLOWLIST_UAA_GROUP is required")
+	}
+	if err := c.S3Options.validate(); err != nil {
+		return err
+	}
+	return c.KillSwitchOptions.validate()
+}
+
+// UsersReport summarizes a membership reconcile
+type UsersReport struct {
+	DryRun       bool          `json:"dry_run"`
+	RolesRemoved []RoleRemoval `json:"roles_removed"`
+	// UsersMissing lists allowlisted users with no role in any sandbox org,
+	// for an operator to add
+	UsersMissing []string `json:"users_missing"`
+	// Halted says why the kill switch made a live reconcile report-only
+	Halted string   `json:"halted,omitempty"`
+	Errors []string `json:"errors"`
+}
+
+// RoleRemoval describes an org or space role held by a user who is not on
+// the allowlist
+type RoleRemoval struct {
+	Org      string `json:"org"`
+	Space    string `json:"space,omitempty"`
+	Username string `json:"username"`
+	UserGUID string `json:"user_guid"`
+	Role     string `json:"role"`
+	Error    string `json:"error,omitempty"`
+}
+
+// userAllowlist holds allowed users by username or, for UAA group members,
+// by user GUID
+type userAllowlist struct {
+	usernames map[string]bool
+	guids     map[string]bool
+}
+
+func (a userAllowlist) allows(user *resource.User) bool {
+	return a.usernames[strings.ToLower(user.Username)] || a.guids[user.GUID]
+}
+
+func (a userAllowlist) size() int {
+	return len(a.usernames) + len(a.guids)
+}
+
+// readAllowlistFile reads one username per line, ignoring blank lines and
+// lines starting with #
+func readAllowlistFile(path string) (userAllowlist, error) {
+	allowlist := userAllowlist{usernames: map[string]bool{}}
+	f, err := os.Open(path)
+	if err != nil {
+		return allowlist, fmt.Errorf("error reading allowlist %s: %w", path, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		allowlist.usernames[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return allowlist, fmt.Errorf("error reading allowlist %s: %w", path, err)
+	}
+	return allowlist, nil
+}
+
+// uaaGroups is the subset of a UAA /Groups response used to read members
+type uaaGroups struct {
+	Resources []struct {
+		DisplayName string `json:"displayName"`
+		Members     []struct {
+			Value string `json:"value"`
+			Type  string `json:"type"`
+		} `json:"members"`
+	} `json:"resources"`
+}
+
+// readAllowlistGroup lists the user members of a UAA group; the CF client's
+// token needs the scim.read scope
+func readAllowlistGroup(
+	ctx context.Context,
+	httpClient *http.Client,
+	uaaAddress string,
+	token string,
+	group string,
+) (userAllowlist, error) {
+	allowlist := userAllowlist{guids: map[string]bool{}}
+	query := url.Values{"filter": {fmt.Sprintf("displayName eq %q", group)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(uaaAddress, "/")+"/Groups?"+query.Encode(), nil)
+	if err != nil {
+		return allowlist, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return allowlist, fmt.Errorf("error listing UAA group %s: %w", group, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return allowlist, fmt.Errorf("error listing UAA group %s: %s: %s", group, resp.Status, body)
+	}
+	var groups uaaGroups
+	if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+		return allowlist, fmt.Errorf("error decoding UAA group %s: %w", group, err)
+	}
+	if len(groups.Resources) == 0 {
+		return allowlist, fmt.Errorf("UAA group %s not found", group)
+	}
+	for _, member := range groups.Resources[0].Members {
+		if member.Type == "USER" {
+			allowlist.guids[member.Value] = true
+		}
+	}
+	return allowlist, nil
+}
+
+// ReconcileUsers removes sandbox org and space roles held by users who are
+// not on the allowlist and reports allowlisted users who have no roles
+func ReconcileUsers(ctx context.Context, cfg UsersConfig) (UsersReport, error) {
+	report := UsersReport{DryRun: cfg.DryRun}
+	if err := cfg.Validate(); err != nil {
+		return report, fmt.Errorf("error parsing options: %w", err)
+	}
+	cfClient, err := newCFClient(cfg.CFOptions, nil)
+	if err != nil {
+		return report, fmt.Errorf("error creating client: %w", err)
+	}
+	if err := guardFoundation(ctx, cfClient, cfg.FoundationOptions); err != nil {
+		return report, err
+	}
+	if reason := newKillSwitch(cfg.KillSwitchOptions, cfg.S3Options).check(ctx, cfClient); reason != "" && !cfg.DryRun {
+		cfg.DryRun = true
+		report.DryRun = true
+		report.Halted = reason
+	}
+
+	var allowlist userAllowlist
+	if cfg.UsersAllowlistFile != "" {
+		allowlist, err = readAllowlistFile(cfg.UsersAllowlistFile)
+	} else {
+		allowlist, err = loadAllowlistGroup(ctx, cfClient, cfg)
+	}
+	if err != nil {
+		return report, err
+	}
+	if allowlist.size() == 0 {
+		return report, errors.New("refusing to reconcile against an empty allowlist")
+	}
+
+	orgs, err := listSandboxOrgs(ctx, cfClient, cfg.OrgPrefix)
+	if err != nil {
+		return report, fmt.Errorf("error getting sandbox orgs: %w", err)
+	}
+	reconcileUsers(ctx, cfClient, orgs, allowlist, cfg.DryRun, &report)
+	return report, nil
+}
+
+// loadAllowlistGroup reads the allowlist group from UAA_ADDRESS or from the
+// UAA advertised by the CF API
+func loadAllowlistGroup(ctx context.Context, cfClient *cfResourceClient, cfg UsersConfig) (userAllowlist, error) {
+	uaaAddress := cfg.UAAAddress
+	if uaaAddress == "" {
+		root, err := cfClient.Root.Get(ctx)
+		if err != nil {
+			return userAllowlist{}, fmt.Errorf("error finding UAA address: %w", err)
+		}
+		uaaAddress = root.Links.Uaa.Href
+	}
+	token, err := cfClient.Auth.AccessToken(ctx)
+	if err != nil {
+		return userAllowlist{}, fmt.Errorf("error getting token: %w", err)
+	}
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	return readAllowlistGroup(ctx, httpClient, uaaAddress, token, cfg.UsersAllowlistGroup)
+}
+
+// reconcileUsers removes roles in each org for users who are not allowed;
+// service accounts, whose usernames aren't email addresses, are left alone
+func reconcileUsers(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	orgs []*resource.Organization,
+	allowlist userAllowlist,
+	dryRun bool,
+	report *UsersReport,
+) {
+	seen := map[string]bool{}
+	for _, org := range orgs {
+		removals, err := listRoleRemovals(ctx, cfClient, org, allowlist, seen)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		for _, removal := range removals {
+			if !dryRun {
+				// CF removes roles asynchronously; a removal only counts once
+				// its job completes
+				jobGUID, err := cfClient.Roles.Delete(ctx, removal.guid)
+				if err == nil {
+					err = waitForJob(ctx, cfClient, jobGUID)
+				}
+				if err != nil && !isNotFoundError(err) {
+					removal.Error = err.Error()
+					report.Errors = append(report.Errors, fmt.Sprintf("error removing %s role from %s in org %s: %s", removal.Role, removal.Username, org.Name, err))
+				}
+			}
+			report.RolesRemoved = append(report.RolesRemoved, removal.RoleRemoval)
+		}
+	}
+
+	for username := range allowlist.usernames {
+		if !seen[username] {
+			report.UsersMissing = append(report.UsersMissing, username)
+		}
+	}
+	for guid := range allowlist.guids {
+		if !seen[guid] {
+			report.UsersMissing = append(report.UsersMissing, guid)
+		}
+	}
+	sort.Strings(report.UsersMissing)
+}
+
+// plannedRoleRemoval is a role removal along with the role GUID to delete
+type plannedRoleRemoval struct {
+	RoleRemoval
+	guid string
+}
+
+// listRoleRemovals lists the roles to remove in an org, space roles first and
+// org user roles last, since CF won't remove an org user who still has other
+// roles; allowed users are marked in seen by username and GUID
+func listRoleRemovals(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	org *resource.Organization,
+	allowlist userAllowlist,
+	seen map[string]bool,
+) ([]plannedRoleRemoval, error) {
+	spaceListOptions := client.NewSpaceListOptions()
+	spaceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	spaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error listing spaces in org %s: %w", org.Name, err)
+	}
+	spaceNames := map[string]string{}
+	for _, space := range spaces {
+		spaceNames[space.GUID] = space.Name
+	}
+
+	roleListOptions := client.NewRoleListOptions()
+	roleListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	roles, users, err := cfClient.Roles.ListIncludeUsersAll(ctx, roleListOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles in org %s: %w", org.Name, err)
+	}
+	spaceGUIDs := make([]string, 0, len(spaces))
+	for _, space := range spaces {
+		spaceGUIDs = append(spaceGUIDs, space.GUID)
+	}
+	for _, batch := range guidBatches(spaceGUIDs) {
+		spaceRoleListOptions := client.NewRoleListOptions()
+		spaceRoleListOptions.SpaceGUIDs.EqualTo(batch...)
+		spaceRoles, spaceUsers, err := cfClient.Roles.ListIncludeUsersAll(ctx, spaceRoleListOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error listing space roles in org %s: %w", org.Name, err)
+		}
+		roles = append(roles, spaceRoles...)
+		users = append(users, spaceUsers...)
+	}
+
+	usersByGUID := map[string]*resource.User{}
+	for _, user := range users {
+		usersByGUID[user.GUID] = user
+	}
+
+	var removals []plannedRoleRemoval
+	for _, role := range roles {
+		if role.Relationships.User.Data == nil {
+			continue
+		}
+		user, ok := usersByGUID[role.Relationships.User.Data.GUID]
+		if !ok || !strings.Contains(user.Username, "@") {
+			continue
+		}
+		if allowlist.allows(user) {
+			seen[strings.ToLower(user.Username)] = true
+			seen[user.GUID] = true
+			continue
+		}
+		removal := plannedRoleRemoval{
+			RoleRemoval: RoleRemoval{
+				Org:      org.Name,
+				Username: user.Username,
+				UserGUID: user.GUID,
+				Role:     role.Type,
+			},
+			guid: role.GUID,
+		}
+		if space := role.Relationships.Space.Data; space != nil {
+			removal.Space = spaceNames[space.GUID]
+		}
+		removals = append(removals, removal)
+	}
+	sort.SliceStable(removals, func(i, j int) bool {
+		return roleRemovalOrder(removals[i].RoleRemoval) < roleRemovalOrder(removals[j].RoleRemoval)
+	})
+	return removals, nil
+}
+
+func roleRemovalOrder(removal RoleRemoval) int {
+	switch {
+	case removal.Space != "":
+		return 0
+	case removal.Role == resource.OrganizationRoleUser.String():
+		return 2
+	default:
+		return 1
+	}
+}
+
+// WriteText writes a human-readable summary of the reconcile
+func (r UsersReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	if r.Halted != "" {
+		fmt.Fprintf(&b, "kill switch engaged: %s; no roles were removed\n", r.Halted)
+	}
+	verb := "removed"
+	if r.DryRun {
+		verb = "would remove"
+	}
+	fmt.Fprintf(&b, "%s %d roles from users not on the allowlist\n", verb, len(r.RolesRemoved))
+	for _, removal := range r.RolesRemoved {
+		target := removal.Org
+		if removal.Space != "" {
+			target += "/" + removal.Space
+		}
+		fmt.Fprintf(&b, "  %s %s %s", target, removal.Role, removal.Username)
+		if removal.Error != "" {
+			fmt.Fprintf(&b, " (error: %s)", removal.Error)
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "%d allowlisted users have no sandbox roles\n", len(r.UsersMissing))
+	for _, user := range r.UsersMissing {
+		fmt.Fprintf(&b, "  %s\n", user)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
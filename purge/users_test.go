@@ -0,0 +1,272 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+type mockMemberRoles struct {
+	orgRoles     []*resource.Role
+	spaceRoles   []*resource.Role
+	users        []*resource.User
+	deleteErr    error
+	deletedGUIDs []string
+	// spaceBatches records how many spaces each space role listing
+	// filtered on
+	spaceBatches []int
+}
+
+func (r *mockMemberRoles) CreateSpaceRole(ctx context.Context, spaceGUID, userGUID string, roleType resource.SpaceRoleType) (*resource.Role, error) {
+	return nil, errors.New("unexpected role creation")
+}
+
+func (r *mockMemberRoles) Delete(ctx context.Context, guid string) (string, error) {
+	r.deletedGUIDs = append(r.deletedGUIDs, guid)
+	return "job-1", r.deleteErr
+}
+
+func (r *mockMemberRoles) ListIncludeUsersAll(ctx context.Context, opts *client.RoleListOptions) ([]*resource.Role, []*resource.User, error) {
+	if len(opts.SpaceGUIDs.Values) > 0 {
+		r.spaceBatches = append(r.spaceBatches, len(opts.SpaceGUIDs.Values))
+		return r.spaceRoles, r.users, nil
+	}
+	return r.orgRoles, r.users, nil
+}
+
+func testRole(guid string, roleType string, userGUID string, spaceGUID string) *resource.Role {
+	role := &resource.Role{
+		GUID: guid,
+		Type: roleType,
+		Relationships: resource.RoleSpaceUserOrganizationRelationships{
+			User: resource.ToOneRelationship{Data: &resource.Relationship{GUID: userGUID}},
+		},
+	}
+	if spaceGUID != "" {
+		role.Relationships.Space = resource.ToOneRelationship{Data: &resource.Relationship{GUID: spaceGUID}}
+	}
+	return role
+}
+
+func TestReconcileUsers(t *testing.T) {
+	orgs := []*resource.Organization{{GUID: "org-1", Name: "sandbox-agency"}}
+	newRoles := func(deleteErr error) *mockMemberRoles {
+		return &mockMemberRoles{
+			orgRoles: []*resource.Role{
+				testRole("role-1", "organization_user", "user-1", ""),
+				testRole("role-2", "organization_user", "user-2", ""),
+				testRole("role-3", "organization_manager", "user-2", ""),
+				testRole("role-4", "organization_user", "service-account", ""),
+			},
+			spaceRoles: []*resource.Role{
+				testRole("role-5", "space_developer", "user-2", "space-1"),
+			},
+			users: []*resource.User{
+				{GUID: "user-1", Username: "Stays@agency.gov"},
+				{GUID: "user-2", Username: "left@agency.gov"},
+				{GUID: "service-account", Username: "deployer"},
+			},
+			deleteErr: deleteErr,
+		}
+	}
+	allowlist := userAllowlist{
+		usernames: map[string]bool{"stays@agency.gov": true, "new@agency.gov": true},
+		guids:     map[string]bool{"user-3": true},
+	}
+	expectedRemovals := []RoleRemoval{
+		{Org: "sandbox-agency", Space: "left", Username: "left@agency.gov", UserGUID: "user-2", Role: "space_developer"},
+		{Org: "sandbox-agency", Username: "left@agency.gov", UserGUID: "user-2", Role: "organization_manager"},
+		{Org: "sandbox-agency", Username: "left@agency.gov", UserGUID: "user-2", Role: "organization_user"},
+	}
+
+	testCases := map[string]struct {
+		dryRun          bool
+		deleteErr       error
+		pollErr         error
+		expectedDeleted []string
+		expectedReport  UsersReport
+	}{
+		"dry run": {
+			dryRun: true,
+			expectedReport: UsersReport{
+				RolesRemoved: expectedRemovals,
+				UsersMissing: []string{"new@agency.gov", "user-3"},
+			},
+		},
+		"removes space roles before org roles": {
+			expectedDeleted: []string{"role-5", "role-3", "role-2"},
+			expectedReport: UsersReport{
+				RolesRemoved: expectedRemovals,
+				UsersMissing: []string{"new@agency.gov", "user-3"},
+			},
+		},
+		"records delete errors": {
+			deleteErr:       errors.New("boom"),
+			expectedDeleted: []string{"role-5", "role-3", "role-2"},
+			expectedReport: UsersReport{
+				RolesRemoved: func() []RoleRemoval {
+					removals := append([]RoleRemoval(nil), expectedRemovals...)
+					for i := range removals {
+						removals[i].Error = "boom"
+					}
+					return removals
+				}(),
+				UsersMissing: []string{"new@agency.gov", "user-3"},
+				Errors: []string{
+					"error removing space_developer role from left@agency.gov in org sandbox-agency: boom",
+					"error removing organization_manager role from left@agency.gov in org sandbox-agency: boom",
+					"error removing organization_user role from left@agency.gov in org sandbox-agency: boom",
+				},
+			},
+		},
+		"records failed removal jobs": {
+			pollErr:         errors.New("job failed"),
+			expectedDeleted: []string{"role-5", "role-3", "role-2"},
+			expectedReport: UsersReport{
+				RolesRemoved: func() []RoleRemoval {
+					removals := append([]RoleRemoval(nil), expectedRemovals...)
+					for i := range removals {
+						removals[i].Error = "job failed"
+					}
+					return removals
+				}(),
+				UsersMissing: []string{"new@agency.gov", "user-3"},
+				Errors: []string{
+					"error removing space_developer role from left@agency.gov in org sandbox-agency: job failed",
+					"error removing organization_manager role from left@agency.gov in org sandbox-agency: job failed",
+					"error removing organization_user role from left@agency.gov in org sandbox-agency: job failed",
+				},
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			roles := newRoles(test.deleteErr)
+			cfClient := &cfResourceClient{
+				Roles:  roles,
+				Spaces: &mockSpaces{spaces: []*resource.Space{{GUID: "space-1", Name: "left"}}},
+				Jobs:   &mockJobs{expectedJobGUID: "job-1", pollErr: test.pollErr},
+			}
+			report := &UsersReport{}
+			reconcileUsers(context.Background(), cfClient, orgs, allowlist, test.dryRun, report)
+			if diff := cmp.Diff(test.expectedReport, *report); diff != "" {
+				t.Errorf("reconcileUsers() mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedDeleted, roles.deletedGUIDs); diff != "" {
+				t.Errorf("deleted roles mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestListRoleRemovalsBatchesSpaces(t *testing.T) {
+	var spaces []*resource.Space
+	for i := 0; i < guidBatchSize+1; i++ {
+		spaces = append(spaces, &resource.Space{GUID: fmt.Sprintf("space-%d", i), Name: fmt.Sprintf("space-%d", i)})
+	}
+	roles := &mockMemberRoles{}
+	cfClient := &cfResourceClient{
+		Roles:  roles,
+		Spaces: &mockSpaces{spaces: spaces},
+	}
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	if _, err := listRoleRemovals(context.Background(), cfClient, org, userAllowlist{}, map[string]bool{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff([]int{guidBatchSize, 1}, roles.spaceBatches); diff != "" {
+		t.Errorf("batches mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadAllowlistFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist")
+	if err := os.WriteFile(path, []byte("# sandbox users\nFoo@agency.gov\n\n  bar@agency.gov  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	allowlist, err := readAllowlistFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]bool{"foo@agency.gov": true, "bar@agency.gov": true}
+	if diff := cmp.Diff(expected, allowlist.usernames); diff != "" {
+		t.Errorf("readAllowlistFile() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadAllowlistGroup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected authorization header: %s", r.Header.Get("Authorization"))
+		}
+		if r.URL.Path != "/Groups" {
+			http.NotFound(w, r)
+			return
+		}
+		if filter := r.URL.Query().Get("filter"); filter != `displayName eq "sandbox.users"` {
+			fmt.Fprint(w, `{"resources": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"resources": [{"displayName": "sandbox.users", "members": [
+			{"value": "user-1", "type": "USER"},
+			{"value": "group-1", "type": "GROUP"}
+		]}]}`)
+	}))
+	defer server.Close()
+
+	testCases := map[string]struct {
+		group       string
+		expected    map[string]bool
+		expectedErr string
+	}{
+		"lists user members": {
+			group:    "sandbox.users",
+			expected: map[string]bool{"user-1": true},
+		},
+		"missing group": {
+			group:       "other.users",
+			expected:    map[string]bool{},
+			expectedErr: "UAA group other.users not found",
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			allowlist, err := readAllowlistGroup(context.Background(), server.Client(), server.URL+"/", "token", test.group)
+			if (test.expectedErr == "" && err != nil) || (test.expectedErr != "" && (err == nil || err.Error() != test.expectedErr)) {
+				t.Fatalf("expected error %q, got: %v", test.expectedErr, err)
+			}
+			if diff := cmp.Diff(test.expected, allowlist.guids); diff != "" {
+				t.Errorf("readAllowlistGroup() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUsersReportWriteTextHalted(t *testing.T) {
+	report := UsersReport{
+		DryRun:       true,
+		Halted:       "org cloud-gov-control is labeled purge-halt",
+		RolesRemoved: []RoleRemoval{{Org: "sandbox-foo", Username: "gone@example.gov", Role: "organization_user"}},
+	}
+	var b strings.Builder
+	if err := report.WriteText(&b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `kill switch engaged: org cloud-gov-control is labeled purge-halt; no roles were removed
+would remove 1 roles from users not on the allowlist
+  sandbox-foo organization_user gone@example.gov
+0 allowlisted users have no sandbox roles
+`
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,123 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// userSpaceName returns the name of a user's sandbox space, the local part
+// of their email address, or an empty string for service accounts, whose
+// usernames aren't email addresses
+func userSpaceName(username string) string {
+	at := strings.LastIndex(username, "@")
+	if at <= 0 {
+		return ""
+	}
+	return strings.ToLower(username[:at])
+}
+
+// reconcileUserSpaces creates the missing user-named space of every user in
+// each sandbox org, with the sandbox quota and the user as its developer and
+// manager; created spaces are listed in the report as org/space
+func reconcileUserSpaces(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	orgs []*resource.Organization,
+	report *Report,
+) {
+	for _, org := range orgs {
+		if err := reconcileOrgUserSpaces(ctx, cfClient, opts.forOrg(org.Name), org, report); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+}
+
+func reconcileOrgUserSpaces(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	org *resource.Organization,
+	report *Report,
+) error {
+	spaceListOptions := client.NewSpaceListOptions()
+	spaceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	spaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+	if err != nil {
+		return fmt.Errorf("error listing spaces in org %s: %w", org.Name, err)
+	}
+	existing := map[string]bool{}
+	for _, space := range spaces {
+		existing[strings.ToLower(space.Name)] = true
+	}
+
+	roleListOptions := client.NewRoleListOptions()
+	roleListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	roleListOptions.Types.EqualTo(resource.OrganizationRoleUser.String())
+	_, users, err := cfClient.Roles.ListIncludeUsersAll(ctx, roleListOptions)
+	if err != nil {
+		return fmt.Errorf("error listing users in org %s: %w", org.Name, err)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	var quota *resource.SpaceQuota
+	quotaFound := false
+	for _, user := range users {
+		name := userSpaceName(user.Username)
+		if name == "" || existing[name] {
+			continue
+		}
+		existing[name] = true
+		if opts.DryRun {
+			logFields{Org: org.Name, Space: name}.printf("would create space %s in org %s for %s", name, org.Name, user.Username)
+			report.SpacesCreated = append(report.SpacesCreated, org.Name+"/"+name)
+			continue
+		}
+		if !quotaFound {
+			quota, err = findSandboxQuota(ctx, cfClient, opts, org, report)
+			if err != nil {
+				return fmt.Errorf("error finding quota %s in org %s: %w", opts.SandboxQuotaName, org.Name, err)
+			}
+			quotaFound = true
+		}
+		if err := createUserSpace(ctx, cfClient, opts, org, quota, name, user); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		logFields{Org: org.Name, Space: name}.printf("created space %s in org %s for %s", name, org.Name, user.Username)
+		report.SpacesCreated = append(report.SpacesCreated, org.Name+"/"+name)
+	}
+	return nil
+}
+
+// createUserSpace creates a user's sandbox space with the sandbox quota, if
+// any, and gives the user the developer and manager roles in it
+func createUserSpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	org *resource.Organization,
+	quota *resource.SpaceQuota,
+	name string,
+	user *resource.User,
+) error {
+	space, err := createSpaceWithRetry(ctx, cfClient, opts, org, resource.NewSpaceCreate(name, org.GUID))
+	if err != nil {
+		return fmt.Errorf("error creating space %s in org %s: %w", name, org.Name, err)
+	}
+	if quota != nil {
+		if _, err := cfClient.SpaceQuotas.Apply(ctx, quota.GUID, []string{space.GUID}); err != nil {
+			return fmt.Errorf("error applying space quota %s to space %s: %w", opts.SandboxQuotaName, name, err)
+		}
+	}
+	owner := []spaceUser{{GUID: user.GUID, Username: user.Username}}
+	if err := recreateSpaceDevsAndManagers(ctx, cfClient, space.GUID, owner, owner); err != nil {
+		return fmt.Errorf("error adding %s to space %s in org %s: %w", user.Username, name, org.Name, err)
+	}
+	return nil
+}
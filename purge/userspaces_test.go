@@ -0,0 +1,95 @@
+package purge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// mockOrgUsers lists org users and records created space roles
+type mockOrgUsers struct {
+	mockRoles
+	listOpts *client.RoleListOptions
+}
+
+func (r *mockOrgUsers) ListIncludeUsersAll(ctx context.Context, opts *client.RoleListOptions) ([]*resource.Role, []*resource.User, error) {
+	r.listOpts = opts
+	return nil, r.users, nil
+}
+
+func TestUserSpaceName(t *testing.T) {
+	testCases := map[string]string{
+		"Jane.Doe@agency.gov": "jane.doe",
+		"a@b@agency.gov":      "a@b",
+		"deployer":            "",
+		"@agency.gov":         "",
+	}
+	for username, expected := range testCases {
+		if got := userSpaceName(username); got != expected {
+			t.Errorf("userSpaceName(%q): expected %q, got %q", username, expected, got)
+		}
+	}
+}
+
+func TestReconcileUserSpaces(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-agency"}
+	users := []*resource.User{
+		{GUID: "user-1", Username: "New.User@agency.gov"},
+		{GUID: "user-2", Username: "existing@agency.gov"},
+		{GUID: "service-account", Username: "deployer"},
+	}
+
+	testCases := map[string]struct {
+		dryRun        bool
+		expectedRoles []spaceCreatedRole
+	}{
+		"creates missing spaces": {
+			expectedRoles: []spaceCreatedRole{
+				{SpaceGUID: "space-new", UserGUID: "user-1", RoleType: resource.SpaceRoleDeveloper},
+				{SpaceGUID: "space-new", UserGUID: "user-1", RoleType: resource.SpaceRoleManager},
+			},
+		},
+		"dry run": {
+			dryRun: true,
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			roles := &mockOrgUsers{mockRoles: mockRoles{users: users}}
+			cfClient := &cfResourceClient{
+				Roles: roles,
+				Spaces: &mockSpaces{
+					spaces:                     []*resource.Space{{GUID: "space-1", Name: "Existing"}},
+					expectedSpaceCreateRequest: resource.NewSpaceCreate("new.user", org.GUID),
+					space:                      &resource.Space{GUID: "space-new", Name: "new.user"},
+				},
+				SpaceQuotas: &mockSpaceQuotas{
+					spaceQuotaName: "sandbox",
+					orgGUID:        org.GUID,
+					quota:          &resource.SpaceQuota{GUID: "quota-1"},
+				},
+			}
+			opts := Config{DryRun: test.dryRun, SandboxQuotaName: "sandbox"}
+			report := &Report{}
+			reconcileUserSpaces(context.Background(), cfClient, opts, []*resource.Organization{org}, report)
+
+			if len(report.Errors) > 0 {
+				t.Fatalf("unexpected errors: %v", report.Errors)
+			}
+			if diff := cmp.Diff([]string{"sandbox-agency/new.user"}, report.SpacesCreated); diff != "" {
+				t.Errorf("SpacesCreated mismatch (-want +got):\n%s", diff)
+			}
+			sortRoles := cmpopts.SortSlices(func(a spaceCreatedRole, b spaceCreatedRole) bool { return a.RoleType.String() < b.RoleType.String() })
+			if diff := cmp.Diff(test.expectedRoles, roles.createdSpaceRoles, sortRoles); diff != "" {
+				t.Errorf("created roles mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff([]string{"organization_user"}, roles.listOpts.Types.Values); diff != "" {
+				t.Errorf("role types mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
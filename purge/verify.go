@@ -0,0 +1,175 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// SpaceMismatch describes one way a recreated space differs from the space
+// the purge meant to recreate
+type SpaceMismatch struct {
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// spaceMismatchError reports that a space was purged and recreated, but the
+// recreated space doesn't match expectations
+type spaceMismatchError struct {
+	space      string
+	mismatches []SpaceMismatch
+}
+
+func (e *spaceMismatchError) Error() string {
+	fields := make([]string, 0, len(e.mismatches))
+	for _, m := range e.mismatches {
+		fields = append(fields, fmt.Sprintf("%s: expected %s, got %s", m.Field, emptyAsNone(m.Expected), emptyAsNone(m.Actual)))
+	}
+	return fmt.Sprintf("recreated space %s does not match the purged space: %s", e.space, strings.Join(fields, "; "))
+}
+
+// expectedSpace is the state a recreated space should have
+type expectedSpace struct {
+	Name             string
+	OrgGUID          string
+	QuotaGUID        string
+	IsolationSegment string
+	// SSHEnabled is only checked when set
+	SSHEnabled *bool
+	Developers []spaceUser
+	Managers   []spaceUser
+}
+
+// verifyRecreatedSpace re-queries a recreated space and its roles and lists
+// every difference from what was expected
+func verifyRecreatedSpace(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	spaceGUID string,
+	expected expectedSpace,
+) ([]SpaceMismatch, error) {
+	var (
+		space            *resource.Space
+		isolationSegment string
+		sshEnabled       bool
+		roles            []*resource.Role
+		users            []*resource.User
+	)
+	err := runConcurrently(ctx, 0,
+		func(ctx context.Context) (err error) {
+			space, err = cfClient.Spaces.Get(ctx, spaceGUID)
+			if err != nil {
+				return fmt.Errorf("error getting recreated space %s: %w", expected.Name, err)
+			}
+			return nil
+		},
+		func(ctx context.Context) (err error) {
+			isolationSegment, err = cfClient.Spaces.GetAssignedIsolationSegment(ctx, spaceGUID)
+			if err != nil {
+				return fmt.Errorf("error getting isolation segment of recreated space %s: %w", expected.Name, err)
+			}
+			return nil
+		},
+		func(ctx context.Context) (err error) {
+			if expected.SSHEnabled == nil {
+				return nil
+			}
+			sshEnabled, err = cfClient.SpaceFeatures.IsSSHEnabled(ctx, spaceGUID)
+			if err != nil {
+				return fmt.Errorf("error getting SSH setting of recreated space %s: %w", expected.Name, err)
+			}
+			return nil
+		},
+		func(ctx context.Context) (err error) {
+			roleListOptions := client.NewRoleListOptions()
+			roleListOptions.SpaceGUIDs.EqualTo(spaceGUID)
+			roles, users, err = cfClient.Roles.ListIncludeUsersAll(ctx, roleListOptions)
+			if err != nil {
+				return fmt.Errorf("error listing roles on recreated space %s: %w", expected.Name, err)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []SpaceMismatch
+	compare := func(field, want, got string) {
+		if want != got {
+			mismatches = append(mismatches, SpaceMismatch{Field: field, Expected: want, Actual: got})
+		}
+	}
+	var orgGUID, quotaGUID string
+	if space.Relationships != nil {
+		orgGUID = relationshipGUID(space.Relationships.Organization)
+		quotaGUID = relationshipGUID(space.Relationships.Quota)
+	}
+	compare("name", expected.Name, space.Name)
+	compare("org", expected.OrgGUID, orgGUID)
+	compare("quota", expected.QuotaGUID, quotaGUID)
+	compare("isolation segment", expected.IsolationSegment, isolationSegment)
+	if expected.SSHEnabled != nil {
+		compare("ssh", formatSSHEnabled(*expected.SSHEnabled), formatSSHEnabled(sshEnabled))
+	}
+
+	want := map[string]bool{}
+	for _, developer := range expected.Developers {
+		want[resource.SpaceRoleDeveloper.String()+" "+developer.Username] = true
+	}
+	for _, manager := range expected.Managers {
+		want[resource.SpaceRoleManager.String()+" "+manager.Username] = true
+	}
+	usernames := map[string]string{}
+	for _, user := range users {
+		usernames[user.GUID] = user.Username
+	}
+	got := map[string]bool{}
+	for _, role := range roles {
+		if role.Type != resource.SpaceRoleDeveloper.String() && role.Type != resource.SpaceRoleManager.String() {
+			continue
+		}
+		if role.Relationships.User.Data != nil {
+			got[role.Type+" "+usernames[role.Relationships.User.Data.GUID]] = true
+		}
+	}
+	for _, role := range sortedKeys(want) {
+		if !got[role] {
+			mismatches = append(mismatches, SpaceMismatch{Field: "role", Expected: role})
+		}
+	}
+	for _, role := range sortedKeys(got) {
+		if !want[role] {
+			mismatches = append(mismatches, SpaceMismatch{Field: "role", Actual: role})
+		}
+	}
+	return mismatches, nil
+}
+
+func relationshipGUID(relationship *resource.ToOneRelationship) string {
+	if relationship == nil || relationship.Data == nil {
+		return ""
+	}
+	return relationship.Data.GUID
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func emptyAsNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
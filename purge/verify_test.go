@@ -0,0 +1,117 @@
+package purge
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestVerifyRecreatedSpace(t *testing.T) {
+	recreated := &resource.Space{
+		GUID: "new-space-1",
+		Name: "foo",
+		Relationships: &resource.SpaceRelationships{
+			Organization: &resource.ToOneRelationship{Data: &resource.Relationship{GUID: "org-1"}},
+			Quota:        &resource.ToOneRelationship{Data: &resource.Relationship{GUID: "quota-1"}},
+		},
+	}
+	roles := &mockMemberRoles{
+		spaceRoles: []*resource.Role{
+			testRole("role-1", "space_manager", "user-1", "new-space-1"),
+			testRole("role-2", "space_developer", "user-3", "new-space-1"),
+			testRole("role-3", "space_auditor", "user-1", "new-space-1"),
+		},
+		users: []*resource.User{
+			{GUID: "user-1", Username: "manager@agency.gov"},
+			{GUID: "user-3", Username: "other@agency.gov"},
+		},
+	}
+	expected := expectedSpace{
+		Name:       "foo",
+		OrgGUID:    "org-1",
+		QuotaGUID:  "quota-1",
+		Managers:   []spaceUser{{GUID: "user-1", Username: "manager@agency.gov"}},
+		Developers: []spaceUser{{GUID: "user-3", Username: "other@agency.gov"}},
+	}
+
+	testCases := map[string]struct {
+		isolationSegment   string
+		expected           func(expectedSpace) expectedSpace
+		expectedMismatches []SpaceMismatch
+	}{
+		"matches": {
+			expected: func(e expectedSpace) expectedSpace { return e },
+		},
+		"wrong quota and isolation segment": {
+			isolationSegment: "iso-2",
+			expected: func(e expectedSpace) expectedSpace {
+				e.QuotaGUID = "quota-2"
+				e.IsolationSegment = "iso-1"
+				return e
+			},
+			expectedMismatches: []SpaceMismatch{
+				{Field: "quota", Expected: "quota-2", Actual: "quota-1"},
+				{Field: "isolation segment", Expected: "iso-1", Actual: "iso-2"},
+			},
+		},
+		"ssh not restored": {
+			expected: func(e expectedSpace) expectedSpace {
+				sshEnabled := true
+				e.SSHEnabled = &sshEnabled
+				return e
+			},
+			expectedMismatches: []SpaceMismatch{
+				{Field: "ssh", Expected: "enabled", Actual: "disabled"},
+			},
+		},
+		"missing and unexpected roles": {
+			expected: func(e expectedSpace) expectedSpace {
+				e.Developers = []spaceUser{{GUID: "user-2", Username: "dev@agency.gov"}}
+				return e
+			},
+			expectedMismatches: []SpaceMismatch{
+				{Field: "role", Expected: "space_developer dev@agency.gov"},
+				{Field: "role", Actual: "space_developer other@agency.gov"},
+			},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				Spaces:        &mockSpaces{space: recreated, isolationSegment: test.isolationSegment},
+				SpaceFeatures: &mockSpaceFeatures{},
+				Roles:         roles,
+			}
+			mismatches, err := verifyRecreatedSpace(context.Background(), cfClient, recreated.GUID, test.expected(expected))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff := cmp.Diff(test.expectedMismatches, mismatches); diff != "" {
+				t.Errorf("verifyRecreatedSpace() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSpaceMismatchError(t *testing.T) {
+	err := &spaceMismatchError{space: "foo", mismatches: []SpaceMismatch{
+		{Field: "quota", Expected: "quota-1"},
+		{Field: "role", Actual: "space_developer foo@agency.gov"},
+	}}
+	expected := "recreated space foo does not match the purged space: quota: expected quota-1, got (none); role: expected (none), got space_developer foo@agency.gov"
+	if err.Error() != expected {
+		t.Errorf("expected error %q, got %q", expected, err.Error())
+	}
+
+	report := &Report{}
+	report.recordAction(PlannedAction{
+		Action:  planActionPurge,
+		Org:     &resource.Organization{Name: "sandbox-org"},
+		Details: SpaceDetails{Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+	}, err)
+	if diff := cmp.Diff(err.mismatches, report.Spaces[0].Mismatches); diff != "" {
+		t.Errorf("recordAction() mismatches mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,193 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+)
+
+// SpaceVerification describes a recreated space re-read once a run's purges
+// finished
+type SpaceVerification struct {
+	Org       string `json:"org"`
+	Space     string `json:"space"`
+	SpaceGUID string `json:"space_guid,omitempty"`
+	Verified  bool   `json:"verified"`
+	// Mismatches lists how the space differs from an empty space on the
+	// sandbox quota with the purged space's roles
+	Mismatches []SpaceMismatch `json:"mismatches,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// samplePurges picks up to n of the purges at random, keeping their order in
+// the plan
+func samplePurges(purges []PlannedAction, n int) []PlannedAction {
+	if n >= len(purges) {
+		return purges
+	}
+	picked := rand.Perm(len(purges))[:n]
+	sort.Ints(picked)
+	sample := make([]PlannedAction, 0, n)
+	for _, i := range picked {
+		sample = append(sample, purges[i])
+	}
+	return sample
+}
+
+// verifyPurgeSample re-reads a random sample of VERIFY_SAMPLE_SIZE spaces
+// the run purged and recreated, looking each one up again by name, and
+// records in the report whether it is empty, on the sandbox quota, and
+// holds the purged space's roles; a failed verification is a run error, but
+// nothing is changed to fix it
+func verifyPurgeSample(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	purges []PlannedAction,
+	report *Report,
+) {
+	if opts.VerifySampleSize == 0 || opts.DryRun || len(purges) == 0 {
+		return
+	}
+	sample := samplePurges(purges, opts.VerifySampleSize)
+	logFields{}.printf("verifying %d of %d recreated spaces", len(sample), len(purges))
+	verifications := make([]SpaceVerification, len(sample))
+	tasks := make([]func(context.Context) error, 0, len(sample))
+	for i, action := range sample {
+		tasks = append(tasks, func(ctx context.Context) error {
+			verifications[i] = verifySampledSpace(ctx, cfClient, opts.forOrg(action.Org.Name), action)
+			return nil
+		})
+	}
+	// each task records its own failure, so there is no error to return
+	_ = runConcurrently(ctx, cfRequestConcurrency, tasks...)
+	for _, verification := range verifications {
+		report.Verifications = append(report.Verifications, verification)
+		switch {
+		case verification.Error != "":
+			report.Errors = append(report.Errors, verification.Error)
+		case !verification.Verified:
+			err := &spaceMismatchError{space: verification.Space, mismatches: verification.Mismatches}
+			report.Errors = append(report.Errors, fmt.Sprintf("sampled verification of space %s in org %s failed: %s", verification.Space, verification.Org, err))
+		}
+	}
+}
+
+// verifySampledSpace re-reads the space recreated by a purge
+func verifySampledSpace(ctx context.Context, cfClient *cfResourceClient, opts Config, action PlannedAction) SpaceVerification {
+	org, name := action.Org, action.Details.Space.Name
+	verification := SpaceVerification{Org: org.Name, Space: name}
+	fail := func(err error) SpaceVerification {
+		verification.Error = fmt.Sprintf("error verifying recreated space %s in org %s: %s", name, org.Name, err)
+		return verification
+	}
+
+	spaceListOptions := client.NewSpaceListOptions()
+	spaceListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	spaceListOptions.Names.EqualTo(name)
+	spaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+	if err != nil {
+		return fail(fmt.Errorf("error finding space: %w", err))
+	}
+	if len(spaces) != 1 {
+		return fail(fmt.Errorf("expected 1 space named %s, found %d", name, len(spaces)))
+	}
+	spaceGUID := spaces[0].GUID
+	verification.SpaceGUID = spaceGUID
+
+	// a plain lookup, unlike findSandboxQuota, so verifying never creates or
+	// reconciles a quota; the purge already recorded any ambiguous match
+	quotaListOptions := client.NewSpaceQuotaListOptions()
+	quotaListOptions.OrganizationGUIDs.EqualTo(org.GUID)
+	quotaListOptions.Names.EqualTo(opts.SandboxQuotaName)
+	quota, _, err := lookupSpaceQuota(ctx, cfClient, org, quotaListOptions, opts.SandboxQuotaName)
+	if err != nil && !errors.Is(err, client.ErrNoResultsReturned) {
+		return fail(fmt.Errorf("error finding quota %s: %w", opts.SandboxQuotaName, err))
+	}
+
+	expected := expectedSpace{
+		Name:             name,
+		OrgGUID:          org.GUID,
+		IsolationSegment: action.IsolationSegment,
+		SSHEnabled:       action.SSHEnabled,
+		Developers:       action.Developers,
+		Managers:         action.Managers,
+	}
+	if quota != nil {
+		expected.QuotaGUID = quota.GUID
+	}
+	mismatches, err := verifyRecreatedSpace(ctx, cfClient, spaceGUID, expected)
+	if err != nil {
+		return fail(err)
+	}
+	leftovers, err := listSpaceLeftovers(ctx, cfClient, spaceGUID)
+	if err != nil {
+		return fail(err)
+	}
+	verification.Mismatches = append(mismatches, leftovers...)
+	verification.Verified = len(verification.Mismatches) == 0
+	return verification
+}
+
+// listSpaceLeftovers lists the apps, service instances, and routes in a
+// space that should be empty, as one mismatch per kind of resource
+func listSpaceLeftovers(ctx context.Context, cfClient *cfResourceClient, spaceGUID string) ([]SpaceMismatch, error) {
+	var apps, instances, routes []string
+	err := runConcurrently(ctx, 0,
+		func(ctx context.Context) error {
+			appListOptions := client.NewAppListOptions()
+			appListOptions.SpaceGUIDs.EqualTo(spaceGUID)
+			found, err := cfClient.Applications.ListAll(ctx, appListOptions)
+			if err != nil {
+				return fmt.Errorf("error listing apps: %w", err)
+			}
+			for _, app := range found {
+				apps = append(apps, app.Name)
+			}
+			return nil
+		},
+		func(ctx context.Context) error {
+			instanceListOptions := client.NewServiceInstanceListOptions()
+			instanceListOptions.SpaceGUIDs.EqualTo(spaceGUID)
+			found, err := cfClient.ServiceInstances.ListAll(ctx, instanceListOptions)
+			if err != nil {
+				return fmt.Errorf("error listing service instances: %w", err)
+			}
+			for _, instance := range found {
+				instances = append(instances, instance.Name)
+			}
+			return nil
+		},
+		func(ctx context.Context) error {
+			routeListOptions := client.NewRouteListOptions()
+			routeListOptions.SpaceGUIDs.EqualTo(spaceGUID)
+			found, err := cfClient.Routes.ListAll(ctx, routeListOptions)
+			if err != nil {
+				return fmt.Errorf("error listing routes: %w", err)
+			}
+			for _, route := range found {
+				routes = append(routes, route.URL)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	var leftovers []SpaceMismatch
+	for _, kind := range []struct {
+		field string
+		names []string
+	}{{"apps", apps}, {"service instances", instances}, {"routes", routes}} {
+		if len(kind.names) > 0 {
+			sort.Strings(kind.names)
+			leftovers = append(leftovers, SpaceMismatch{Field: kind.field, Actual: strings.Join(kind.names, ", ")})
+		}
+	}
+	return leftovers, nil
+}
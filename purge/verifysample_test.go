@@ -0,0 +1,117 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSamplePurges(t *testing.T) {
+	var purges []PlannedAction
+	for i := 0; i < 10; i++ {
+		purges = append(purges, PlannedAction{Details: SpaceDetails{Space: &resource.Space{Name: fmt.Sprintf("space-%d", i)}}})
+	}
+	if sample := samplePurges(purges, 20); len(sample) != len(purges) {
+		t.Errorf("expected every purge when the sample is larger, got %d", len(sample))
+	}
+	sample := samplePurges(purges, 3)
+	if len(sample) != 3 {
+		t.Fatalf("expected 3 purges, got %d", len(sample))
+	}
+	for i := 1; i < len(sample); i++ {
+		if sample[i-1].Details.Space.Name >= sample[i].Details.Space.Name {
+			t.Errorf("expected the sample in plan order, got %s before %s", sample[i-1].Details.Space.Name, sample[i].Details.Space.Name)
+		}
+	}
+}
+
+func TestVerifyPurgeSample(t *testing.T) {
+	org := &resource.Organization{GUID: "org-1", Name: "sandbox-bar"}
+	action := PlannedAction{
+		Action:     planActionPurge,
+		Org:        org,
+		Details:    SpaceDetails{Space: &resource.Space{GUID: "space-2", Name: "baz"}},
+		Developers: []spaceUser{{GUID: "user-1", Username: "baz@bar.gov"}},
+	}
+	recreated := &resource.Space{
+		GUID: "new-space-2",
+		Name: "baz",
+		Relationships: &resource.SpaceRelationships{
+			Organization: &resource.ToOneRelationship{Data: &resource.Relationship{GUID: "org-1"}},
+			Quota:        &resource.ToOneRelationship{Data: &resource.Relationship{GUID: "quota-1"}},
+		},
+	}
+	roles := &mockMemberRoles{
+		spaceRoles: []*resource.Role{testRole("role-1", "space_developer", "user-1", "new-space-2")},
+		users:      []*resource.User{{GUID: "user-1", Username: "baz@bar.gov"}},
+	}
+	testCases := map[string]struct {
+		opts                  Config
+		spaces                []*resource.Space
+		apps                  []*resource.App
+		routes                []*resource.Route
+		expectedVerifications []SpaceVerification
+		expectedErrors        []string
+	}{
+		"disabled": {
+			opts:   Config{SandboxQuotaName: "sandbox"},
+			spaces: []*resource.Space{recreated},
+		},
+		"verified": {
+			opts:   Config{SandboxQuotaName: "sandbox", VerifySampleSize: 5},
+			spaces: []*resource.Space{recreated},
+			expectedVerifications: []SpaceVerification{
+				{Org: "sandbox-bar", Space: "baz", SpaceGUID: "new-space-2", Verified: true},
+			},
+		},
+		"not empty": {
+			opts:   Config{SandboxQuotaName: "sandbox", VerifySampleSize: 5},
+			spaces: []*resource.Space{recreated},
+			apps:   []*resource.App{{Name: "web"}},
+			routes: []*resource.Route{{URL: "web.app.cloud.gov"}},
+			expectedVerifications: []SpaceVerification{
+				{
+					Org:       "sandbox-bar",
+					Space:     "baz",
+					SpaceGUID: "new-space-2",
+					Mismatches: []SpaceMismatch{
+						{Field: "apps", Actual: "web"},
+						{Field: "routes", Actual: "web.app.cloud.gov"},
+					},
+				},
+			},
+			expectedErrors: []string{"sampled verification of space baz in org sandbox-bar failed: recreated space baz does not match the purged space: apps: expected (none), got web; routes: expected (none), got web.app.cloud.gov"},
+		},
+		"space missing": {
+			opts: Config{SandboxQuotaName: "sandbox", VerifySampleSize: 5},
+			expectedVerifications: []SpaceVerification{
+				{Org: "sandbox-bar", Space: "baz", Error: "error verifying recreated space baz in org sandbox-bar: expected 1 space named baz, found 0"},
+			},
+			expectedErrors: []string{"error verifying recreated space baz in org sandbox-bar: expected 1 space named baz, found 0"},
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfClient := &cfResourceClient{
+				Spaces:           &mockSpaces{spaces: test.spaces, space: recreated},
+				SpaceQuotas:      &mockSpaceQuotas{spaceQuotaName: "sandbox", orgGUID: "org-1", quota: &resource.SpaceQuota{GUID: "quota-1"}},
+				SpaceFeatures:    &mockSpaceFeatures{},
+				Roles:            roles,
+				Applications:     &mockApplications{apps: test.apps},
+				ServiceInstances: &mockServiceInstances{},
+				Routes:           &mockRoutes{routes: test.routes},
+			}
+			report := &Report{}
+			verifyPurgeSample(context.Background(), cfClient, test.opts, []PlannedAction{action}, report)
+			if diff := cmp.Diff(test.expectedVerifications, report.Verifications); diff != "" {
+				t.Errorf("verifications mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.expectedErrors, report.Errors); diff != "" {
+				t.Errorf("errors mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
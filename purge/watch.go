@@ -0,0 +1,241 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/18f/cg-sandbox/purge/collect"
+	"github.com/cloudfoundry-community/go-cfclient/v3/client"
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// watchBatchSize caps the org GUIDs filtered on in each listing, so a
+// foundation with many sandbox orgs doesn't build an overlong URL
+const watchBatchSize = 50
+
+// WatchConfig describes configuration for watching sandbox orgs for new
+// spaces and resources between scheduled runs
+type WatchConfig struct {
+	// ConfigFile holds KEY=VALUE settings that override the environment,
+	// as for the daemon; it is reread before every poll
+	ConfigFile    string        `env:"CONFIG_FILE"`
+	WatchInterval time.Duration `env:"WATCH_INTERVAL, default=5m"`
+}
+
+// watcher polls sandbox orgs for new spaces and resources
+type watcher struct {
+	opts WatchConfig
+	// run is Run and newClient is newCFClient, replaced in tests
+	run       func(context.Context, Config) (Report, error)
+	newClient func(CFOptions, func(http.RoundTripper) http.RoundTripper) (*cfResourceClient, error)
+}
+
+// Watch polls sandbox orgs every WatchInterval until ctx is canceled. Spaces
+// created since the last poll are given the sandbox quota, and every space
+// holding a resource created since then gets its welcome email and
+// annotations right away, rather than at the next scheduled run; warnings
+// and purges are left to the scheduled runs
+func Watch(ctx context.Context, opts WatchConfig) error {
+	if opts.WatchInterval <= 0 {
+		return fmt.Errorf("WATCH_INTERVAL must be positive")
+	}
+	w := &watcher{opts: opts, run: Run, newClient: newCFClient}
+	logFields{}.printf("watching sandbox orgs every %s", opts.WatchInterval)
+	w.loop(ctx, time.Now().Add(-opts.WatchInterval))
+	return nil
+}
+
+// loop polls until ctx is canceled; a failed poll is retried from the same
+// point, so nothing created in the meantime is missed
+func (w *watcher) loop(ctx context.Context, since time.Time) {
+	for {
+		started := time.Now()
+		if err := w.poll(ctx, since); err != nil && !errors.Is(err, context.Canceled) {
+			logFields{Err: err}.printf("watch poll failed: %s", err)
+		} else {
+			since = started
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.opts.WatchInterval):
+		}
+	}
+}
+
+// poll reloads the configuration and handles what was created since
+func (w *watcher) poll(ctx context.Context, since time.Time) error {
+	cfg, err := LoadConfig(ctx, w.opts.ConfigFile)
+	if err != nil {
+		return err
+	}
+	cfClient, err := w.newClient(cfg.CFOptions, nil)
+	if err != nil {
+		return fmt.Errorf("error creating client: %w", err)
+	}
+	return w.handleNew(ctx, cfClient, cfg, since)
+}
+
+// handleNew quotas the sandbox spaces created since, then runs a watch run
+// over every space holding something created since
+func (w *watcher) handleNew(ctx context.Context, cfClient *cfResourceClient, cfg Config, since time.Time) error {
+	orgs, err := listSandboxOrgs(ctx, cfClient, cfg.OrgPrefix)
+	if err != nil {
+		return fmt.Errorf("error getting orgs: %w", err)
+	}
+	spaces, touched, err := listNewSandboxResources(ctx, cfClient, orgs, since)
+	if err != nil {
+		return err
+	}
+	if len(touched) == 0 {
+		return nil
+	}
+	logFields{}.printf("found %d new spaces and new resources in %d spaces since %s", len(spaces), len(touched), since.UTC().Format(time.RFC3339))
+
+	orgsByGUID := map[string]*resource.Organization{}
+	for _, org := range orgs {
+		orgsByGUID[org.GUID] = org
+	}
+	for _, space := range spaces {
+		org, ok := orgsByGUID[spaceOrgGUID(space)]
+		if !ok {
+			continue
+		}
+		if err := applyNewSpaceQuota(ctx, cfClient, cfg.forOrg(org.Name), org, space); err != nil {
+			logFields{Org: org.Name, Space: space.Name, Err: err}.printf("%s", err)
+		}
+	}
+
+	_, err = w.run(ctx, cfg.forWatch(touched))
+	return err
+}
+
+// listNewSandboxResources lists the spaces created in orgs since, and the
+// GUIDs of those spaces and of every space in orgs holding an app, service
+// instance, or route created since
+func listNewSandboxResources(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	orgs []*resource.Organization,
+	since time.Time,
+) ([]*resource.Space, map[string]bool, error) {
+	var spaces []*resource.Space
+	touched := map[string]bool{}
+	for start := 0; start < len(orgs); start += watchBatchSize {
+		var orgGUIDs []string
+		for _, org := range orgs[start:min(start+watchBatchSize, len(orgs))] {
+			orgGUIDs = append(orgGUIDs, org.GUID)
+		}
+
+		spaceListOptions := client.NewSpaceListOptions()
+		spaceListOptions.OrganizationGUIDs.Values = orgGUIDs
+		spaceListOptions.CreateAts.After(since)
+		newSpaces, err := cfClient.Spaces.ListAll(ctx, spaceListOptions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing new spaces: %w", err)
+		}
+		for _, space := range newSpaces {
+			spaces = append(spaces, space)
+			touched[space.GUID] = true
+		}
+
+		appListOptions := client.NewAppListOptions()
+		appListOptions.OrganizationGUIDs.Values = orgGUIDs
+		appListOptions.CreateAts.After(since)
+		apps, err := cfClient.Applications.ListAll(ctx, appListOptions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing new apps: %w", err)
+		}
+		for _, app := range apps {
+			touched[app.Relationships.Space.Data.GUID] = true
+		}
+
+		serviceListOptions := client.NewServiceInstanceListOptions()
+		serviceListOptions.OrganizationGUIDs.Values = orgGUIDs
+		serviceListOptions.CreateAts.After(since)
+		instances, err := cfClient.ServiceInstances.ListAll(ctx, serviceListOptions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing new service instances: %w", err)
+		}
+		for _, instance := range instances {
+			if space := instance.Relationships.Space; space != nil && space.Data != nil {
+				touched[space.Data.GUID] = true
+			}
+		}
+
+		routeListOptions := client.NewRouteListOptions()
+		routeListOptions.OrganizationGUIDs.Values = orgGUIDs
+		routeListOptions.CreateAts.After(since)
+		routes, err := cfClient.Routes.ListAll(ctx, routeListOptions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error listing new routes: %w", err)
+		}
+		for _, route := range routes {
+			if route.Relationships.Space.Data != nil {
+				touched[route.Relationships.Space.Data.GUID] = true
+			}
+		}
+	}
+	collect.SortSpaces(spaces)
+	return spaces, touched, nil
+}
+
+// applyNewSpaceQuota assigns the sandbox quota to a new space left on the
+// org default; spaces already assigned a quota keep it
+func applyNewSpaceQuota(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	org *resource.Organization,
+	space *resource.Space,
+) error {
+	if spaceQuotaGUID(space) != "" {
+		return nil
+	}
+	if opts.DryRun {
+		logFields{Org: org.Name, Space: space.Name}.printf("would apply quota %s to new space %s in org %s", opts.SandboxQuotaName, space.Name, org.Name)
+		return nil
+	}
+	quota, err := findSandboxQuota(ctx, cfClient, opts, org, &Report{})
+	if err != nil {
+		return fmt.Errorf("error finding quota %s in org %s: %w", opts.SandboxQuotaName, org.Name, err)
+	}
+	if quota == nil {
+		return nil
+	}
+	if _, err := cfClient.SpaceQuotas.Apply(ctx, quota.GUID, []string{space.GUID}); err != nil {
+		return fmt.Errorf("error applying space quota %s to space %s: %w", opts.SandboxQuotaName, space.Name, err)
+	}
+	logFields{Org: org.Name, Space: space.Name}.printf("applied quota %s to new space %s in org %s", opts.SandboxQuotaName, space.Name, org.Name)
+	return nil
+}
+
+// spaceOrgGUID returns the GUID of a space's org
+func spaceOrgGUID(space *resource.Space) string {
+	if space.Relationships == nil {
+		return ""
+	}
+	return relationshipGUID(space.Relationships.Organization)
+}
+
+// forWatch configures a run over just the spaces a watch poll found: it
+// only welcomes and annotates them, and leaves the plan, report, digest,
+// and exports describing a whole run to the scheduled runs
+func (c Config) forWatch(spaceGUIDs map[string]bool) Config {
+	c = c.withoutRunOutputs()
+	c.watching = true
+	c.SpaceGUIDsFile = ""
+	c.spaceGUIDs = spaceGUIDs
+	return c
+}
+
+// forWatch keeps the parts of an evaluation a watch run acts on
+func (e orgEvaluation) forWatch() orgEvaluation {
+	return orgEvaluation{
+		toWelcome:   e.toWelcome,
+		annotations: e.annotations,
+	}
+}
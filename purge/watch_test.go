@@ -0,0 +1,123 @@
+package purge
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+	"github.com/google/go-cmp/cmp"
+)
+
+func newSpaceInOrg(guid string, orgGUID string, quotaGUID string) *resource.Space {
+	space := spaceWithQuota(guid, quotaGUID)
+	space.Relationships.Organization = &resource.ToOneRelationship{Data: &resource.Relationship{GUID: orgGUID}}
+	return space
+}
+
+func TestListNewSandboxResources(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	orgs := []*resource.Organization{{GUID: "org-1", Name: "sandbox-agency"}}
+	spaces := []*resource.Space{newSpaceInOrg("space-b", "org-1", ""), newSpaceInOrg("space-a", "org-1", "")}
+	cfClient := &cfResourceClient{
+		Spaces:           &mockSpaces{spaces: spaces},
+		Applications:     &mockApplications{apps: []*resource.App{appInSpace("app-1", "space-c", now)}},
+		ServiceInstances: &mockServiceInstances{instances: []*resource.ServiceInstance{instanceInSpace("instance-1", "space-a")}},
+		Routes:           &mockRoutes{routes: []*resource.Route{routeInSpace("route-1", "space-d")}},
+	}
+
+	newSpaces, touched, err := listNewSandboxResources(context.Background(), cfClient, orgs, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff([]*resource.Space{spaces[1], spaces[0]}, newSpaces); diff != "" {
+		t.Errorf("spaces mismatch (-want +got):\n%s", diff)
+	}
+	expected := map[string]bool{"space-a": true, "space-b": true, "space-c": true, "space-d": true}
+	if diff := cmp.Diff(expected, touched); diff != "" {
+		t.Errorf("touched spaces mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHandleNew(t *testing.T) {
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	quotas := &mockSpaceQuotas{
+		spaceQuotaName: "sandbox",
+		orgGUID:        "org-1",
+		quota:          &resource.SpaceQuota{GUID: "quota-1", Name: "sandbox"},
+	}
+	cfClient := &cfResourceClient{
+		Organizations: &mockOrganizations{orgs: []*resource.Organization{
+			{GUID: "org-1", Name: "sandbox-agency"},
+			{GUID: "org-2", Name: "cloud-gov"},
+		}},
+		Spaces: &mockSpaces{spaces: []*resource.Space{
+			newSpaceInOrg("space-new", "org-1", ""),
+			newSpaceInOrg("space-custom", "org-1", "custom"),
+		}},
+		Applications:     &mockApplications{apps: []*resource.App{appInSpace("app-1", "space-old", now)}},
+		ServiceInstances: &mockServiceInstances{},
+		Routes:           &mockRoutes{},
+		SpaceQuotas:      quotas,
+	}
+
+	var ran Config
+	w := &watcher{run: func(ctx context.Context, cfg Config) (Report, error) {
+		ran = cfg
+		return Report{}, nil
+	}}
+	cfg := Config{
+		OrgPrefix:          "sandbox-",
+		SandboxQuotaName:   "sandbox",
+		PlanFile:           "plan.json",
+		ReportFormat:       reportFormatMarkdown,
+		LeaderboardOptions: LeaderboardOptions{LeaderboardSize: 10},
+	}
+	if err := w.handleNew(context.Background(), cfClient, cfg, now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if diff := cmp.Diff([]string{"space-new"}, quotas.appliedGUIDs); diff != "" {
+		t.Errorf("applied quotas mismatch (-want +got):\n%s", diff)
+	}
+	if !ran.watching {
+		t.Errorf("expected a watch run")
+	}
+	expected := map[string]bool{"space-new": true, "space-custom": true, "space-old": true}
+	if diff := cmp.Diff(expected, ran.spaceGUIDs); diff != "" {
+		t.Errorf("run spaces mismatch (-want +got):\n%s", diff)
+	}
+	if ran.PlanFile != "" || ran.ReportFormat != "" || ran.LeaderboardSize != 0 {
+		t.Errorf("expected the watch run to skip the plan and report, got %+v", ran)
+	}
+}
+
+func TestHandleNewWithoutNewResources(t *testing.T) {
+	cfClient := &cfResourceClient{
+		Organizations:    &mockOrganizations{orgs: []*resource.Organization{{GUID: "org-1", Name: "sandbox-agency"}}},
+		Spaces:           &mockSpaces{},
+		Applications:     &mockApplications{},
+		ServiceInstances: &mockServiceInstances{},
+		Routes:           &mockRoutes{},
+	}
+	w := &watcher{run: func(ctx context.Context, cfg Config) (Report, error) {
+		t.Errorf("expected no run without new resources")
+		return Report{}, nil
+	}}
+	if err := w.handleNew(context.Background(), cfClient, Config{OrgPrefix: "sandbox-"}, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestOrgEvaluationForWatch(t *testing.T) {
+	space := &resource.Space{GUID: "space-1", Name: "foo"}
+	evaluation := orgEvaluation{
+		toWelcome: []SpaceDetails{{Space: space}},
+		toNotify:  []SpaceDetails{{Space: space}},
+		toPurge:   []SpaceDetails{{Space: space}},
+	}
+	watched := evaluation.forWatch()
+	if len(watched.toWelcome) != 1 || len(watched.toNotify) != 0 || len(watched.toPurge) != 0 {
+		t.Errorf("expected only welcomes to be kept, got %+v", watched)
+	}
+}
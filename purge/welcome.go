@@ -0,0 +1,157 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+// welcomeEnabled reports whether new sandbox spaces are sent a welcome email
+// explaining the purge policy
+func (c Config) welcomeEnabled() bool {
+	return c.WelcomeMailSubject != ""
+}
+
+// listWelcomeSpaces returns the spaces with resources that aren't already
+// being warned about or purged
+func listWelcomeSpaces(details []SpaceDetails, toNotify []SpaceDetails, toPurge []SpaceDetails) []SpaceDetails {
+	excluded := map[string]bool{}
+	for _, d := range toNotify {
+		excluded[d.Space.GUID] = true
+	}
+	for _, d := range toPurge {
+		excluded[d.Space.GUID] = true
+	}
+	var candidates []SpaceDetails
+	for _, d := range details {
+		if !d.Timestamp.IsZero() && !excluded[d.Space.GUID] {
+			candidates = append(candidates, d)
+		}
+	}
+	return candidates
+}
+
+// shouldWelcome reports whether a space's first resource appeared since the
+// last run and its users haven't been welcomed for it; first resources are
+// truncated to the day, so the whole day of the last run counts, and the
+// state keeps a space from being welcomed twice. Without a previous run to
+// compare against, no space is welcomed
+func shouldWelcome(state *State, details SpaceDetails) bool {
+	lastRun := state.lastRunAt()
+	if lastRun.IsZero() {
+		return false
+	}
+	if details.Timestamp.Before(lastRun.Truncate(24 * time.Hour)) {
+		return false
+	}
+	return !state.welcomed(details.Space.GUID, details.Timestamp)
+}
+
+// planWelcome looks up the recipients of a space's welcome email
+func planWelcome(
+	ctx context.Context,
+	cfClient *cfResourceClient,
+	opts Config,
+	userGUIDs map[string]bool,
+	rosters spaceRosters,
+	org *resource.Organization,
+	details SpaceDetails,
+) (PlannedAction, error) {
+	spaceUsers, err := rosters.spaceUsers(ctx, cfClient, details.Space)
+	if err != nil {
+		return PlannedAction{}, err
+	}
+
+	recipients, err := listRecipients(userGUIDs, spaceUsers)
+	if err != nil {
+		return PlannedAction{}, fmt.Errorf("error listing recipients on space %s: %w", details.Space.Name, err)
+	}
+
+	logFields{Org: org.Name, Space: details.Space.Name, Action: planActionWelcome}.printf("Welcoming space %s; recipients %+v", details.Space.Name, recipients)
+	return PlannedAction{
+		Action:     planActionWelcome,
+		Org:        org,
+		Details:    details,
+		Recipients: recipients,
+		Subject:    opts.WelcomeMailSubject,
+	}, nil
+}
+
+// applyWelcome sends a planned welcome email
+func applyWelcome(
+	ctx context.Context,
+	opts Config,
+	action PlannedAction,
+	mailSender mailer,
+) error {
+	if opts.DryRun {
+		return nil
+	}
+
+	welcomeTemplate, err := parseMailTemplate(opts.TemplateDir, welcomeTemplateName)
+	if err != nil {
+		return fmt.Errorf("error reading welcome template: %w", err)
+	}
+
+	org, details, recipients := action.Org, action.Details, action.Recipients
+	body := renderSpaceMail(welcomeTemplate, welcomeTemplateName, welcomeTemplateData(opts, org, details), mailSender, actionFields(action))
+
+	actionFields(action).printf("sending to %s: %s", recipients, body)
+
+	thread := newMailThread(opts.MailSender, details, "welcome")
+	if err := mailSender.sendMail(ctx, opts.SMTPOptions, opts.MailSender, opts.WelcomeMailSubject, body, thread, recipients); err != nil {
+		return fmt.Errorf("error sending mail on space %s: %w", details.Space.Name, err)
+	}
+	return nil
+}
+
+// lastRunAt returns when the last run that saved state started
+func (s *State) lastRunAt() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.LastRunAt
+}
+
+// recordLastRun remembers when a run started, so the next run can tell
+// which spaces are new
+func (s *State) recordLastRun(at time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastRunAt = at
+}
+
+// welcomed reports whether a space was welcomed for the purge cycle starting
+// at firstResource
+func (s *State) welcomed(spaceGUID string, firstResource time.Time) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	space, ok := s.Spaces[spaceGUID]
+	return ok && space.WelcomedFor != nil && space.WelcomedFor.Equal(firstResource)
+}
+
+// recordWelcomed remembers that a space was welcomed for its current cycle
+func (s *State) recordWelcomed(action PlannedAction) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	space, ok := s.Spaces[action.Details.Space.GUID]
+	if !ok {
+		space = &SpaceState{Org: action.Org.Name, Space: action.Details.Space.Name}
+		s.Spaces[action.Details.Space.GUID] = space
+	}
+	firstResource := action.Details.Timestamp
+	space.WelcomedFor = &firstResource
+}
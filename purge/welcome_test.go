@@ -0,0 +1,81 @@
+package purge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/go-cfclient/v3/resource"
+)
+
+func TestShouldWelcome(t *testing.T) {
+	lastRun := time.Date(2024, 3, 10, 6, 0, 0, 0, time.UTC)
+	welcomedFor := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		state         *State
+		firstResource time.Time
+		expected      bool
+	}{
+		"new since last run": {
+			state:         &State{Spaces: map[string]*SpaceState{}, LastRunAt: lastRun},
+			firstResource: time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+			expected:      true,
+		},
+		"same day as last run": {
+			state:         &State{Spaces: map[string]*SpaceState{}, LastRunAt: lastRun},
+			firstResource: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+			expected:      true,
+		},
+		"before last run": {
+			state:         &State{Spaces: map[string]*SpaceState{}, LastRunAt: lastRun},
+			firstResource: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC),
+		},
+		"already welcomed": {
+			state: &State{
+				Spaces:    map[string]*SpaceState{"space-1": {WelcomedFor: &welcomedFor}},
+				LastRunAt: lastRun,
+			},
+			firstResource: welcomedFor,
+		},
+		"welcomed for an earlier cycle": {
+			state: &State{
+				Spaces:    map[string]*SpaceState{"space-1": {WelcomedFor: &welcomedFor}},
+				LastRunAt: lastRun,
+			},
+			firstResource: time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC),
+			expected:      true,
+		},
+		"no previous run": {
+			state:         &State{Spaces: map[string]*SpaceState{}},
+			firstResource: time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+		},
+		"no state": {
+			firstResource: time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for name, test := range testCases {
+		t.Run(name, func(t *testing.T) {
+			details := SpaceDetails{Timestamp: test.firstResource, Space: &resource.Space{GUID: "space-1", Name: "foo"}}
+			if got := shouldWelcome(test.state, details); got != test.expected {
+				t.Errorf("expected shouldWelcome() %t, got %t", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestRecordWelcomed(t *testing.T) {
+	state := &State{Spaces: map[string]*SpaceState{}}
+	firstResource := time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)
+	action := PlannedAction{
+		Action:  planActionWelcome,
+		Org:     &resource.Organization{Name: "sandbox-org"},
+		Details: SpaceDetails{Timestamp: firstResource, Space: &resource.Space{GUID: "space-1", Name: "foo"}},
+	}
+	state.recordWelcomed(action)
+	if !state.welcomed("space-1", firstResource) {
+		t.Fatal("expected space to be welcomed")
+	}
+	state.recordNotified(action, firstResource.Add(20*24*time.Hour))
+	if !state.welcomed("space-1", firstResource) {
+		t.Error("expected purge warning to keep the welcome")
+	}
+}